@@ -635,6 +635,12 @@ func (stub *ChaincodeStub) GetCallerMetadata() ([]byte, error) {
 	return stub.securityContext.Metadata, nil
 }
 
+// GetCallerAttributesKeys returns the keys of the attributes of the caller
+// certificate disclosed by the caller
+func (stub *ChaincodeStub) GetCallerAttributesKeys() ([]byte, error) {
+	return stub.securityContext.AttributesKeys, nil
+}
+
 // GetBinding returns the transaction binding
 func (stub *ChaincodeStub) GetBinding() ([]byte, error) {
 	return stub.securityContext.Binding, nil
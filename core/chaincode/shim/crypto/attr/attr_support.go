@@ -23,6 +23,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/crypto/attributes"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/protos"
 )
 
 //Attribute defines a name, value pair to be verified.
@@ -36,11 +37,8 @@ type chaincodeHolder interface {
 	// GetCallerCertificate returns caller certificate
 	GetCallerCertificate() ([]byte, error)
 
-	// GetCallerMetadata returns caller metadata
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		GetCallerMetadata() ([]byte, error)
-	*/
+	// GetCallerAttributesKeys returns the keys of the caller certificate attributes disclosed by the caller
+	GetCallerAttributesKeys() ([]byte, error)
 }
 
 //AttributesHandler is an entity can be used to both verify and read attributes.
@@ -84,7 +82,8 @@ type AttributesHandlerImpl struct {
 }
 
 type chaincodeHolderImpl struct {
-	Certificate []byte
+	Certificate    []byte
+	AttributesKeys []byte
 }
 
 // GetCallerCertificate returns caller certificate
@@ -92,6 +91,11 @@ func (holderImpl *chaincodeHolderImpl) GetCallerCertificate() ([]byte, error) {
 	return holderImpl.Certificate, nil
 }
 
+// GetCallerAttributesKeys returns the keys of the caller certificate attributes
+func (holderImpl *chaincodeHolderImpl) GetCallerAttributesKeys() ([]byte, error) {
+	return holderImpl.AttributesKeys, nil
+}
+
 //GetValueFrom returns the value of 'attributeName0' from a cert.
 func GetValueFrom(attributeName string, cert []byte) ([]byte, error) {
 	handler, err := NewAttributesHandlerImpl(&chaincodeHolderImpl{Certificate: cert})
@@ -101,6 +105,12 @@ func GetValueFrom(attributeName string, cert []byte) ([]byte, error) {
 	return handler.GetValue(attributeName)
 }
 
+//NewAttributesHandlerForTx creates a new AttributesHandlerImpl from the certificate and the attributes keys of a transaction. Validators
+//can use it to read and verify the attributes of the submitter of a transaction, once decrypted if confidential.
+func NewAttributesHandlerForTx(tx *pb.Transaction) (*AttributesHandlerImpl, error) {
+	return NewAttributesHandlerImpl(&chaincodeHolderImpl{Certificate: tx.Cert, AttributesKeys: tx.AttributesKeys})
+}
+
 //NewAttributesHandlerImpl creates a new AttributesHandlerImpl from a pb.ChaincodeSecurityContext object.
 func NewAttributesHandlerImpl(holder chaincodeHolder) (*AttributesHandlerImpl, error) {
 	// Getting certificate
@@ -119,25 +129,20 @@ func NewAttributesHandlerImpl(holder chaincodeHolder) (*AttributesHandlerImpl, e
 
 	keys := make(map[string][]byte)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-
-		//Getting Attributes Metadata from security context.
-		var attrsMetadata *attributespb.AttributesMetadata
-		var rawMetadata []byte
-		rawMetadata, err = holder.GetCallerMetadata()
-		if err != nil {
-			return nil, err
-		}
+	//Getting the attributes keys disclosed by the caller.
+	rawKeys, err := holder.GetCallerAttributesKeys()
+	if err != nil {
+		return nil, err
+	}
 
-		if rawMetadata != nil {
-			attrsMetadata, err = attributes.GetAttributesMetadata(rawMetadata)
-			if err == nil {
-				for _, entry := range attrsMetadata.Entries {
-					keys[entry.AttributeName] = entry.AttributeKey
-				}
+	if rawKeys != nil {
+		attrsMetadata, err := attributes.GetAttributesMetadata(rawKeys)
+		if err == nil {
+			for _, entry := range attrsMetadata.Entries {
+				keys[entry.AttributeName] = entry.AttributeKey
 			}
-		}*/
+		}
+	}
 
 	cache := make(map[string][]byte)
 	return &AttributesHandlerImpl{tcert, cache, keys, nil, false}, nil
@@ -24,7 +24,9 @@ import (
 	"io/ioutil"
 	"testing"
 
+	"github.com/hyperledger/fabric/core/crypto/attributes"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/protos"
 )
 
 var (
@@ -32,11 +34,8 @@ var (
 )
 
 type chaincodeStubMock struct {
-	callerCert []byte
-	/*
-		TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata   []byte
-	*/
+	callerCert     []byte
+	attributesKeys []byte
 }
 
 // GetCallerCertificate returns caller certificate
@@ -44,19 +43,13 @@ func (shim *chaincodeStubMock) GetCallerCertificate() ([]byte, error) {
 	return shim.callerCert, nil
 }
 
-/*
-	TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-// GetCallerMetadata returns caller metadata
-func (shim *chaincodeStubMock) GetCallerMetadata() ([]byte, error) {
-	return shim.metadata, nil
+// GetCallerAttributesKeys returns the keys of the caller certificate attributes
+func (shim *chaincodeStubMock) GetCallerAttributesKeys() ([]byte, error) {
+	return shim.attributesKeys, nil
 }
-*/
 
 type certErrorMock struct {
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata []byte
-	*/
+	attributesKeys []byte
 }
 
 // GetCallerCertificate returns caller certificate
@@ -64,12 +57,10 @@ func (shim *certErrorMock) GetCallerCertificate() ([]byte, error) {
 	return nil, errors.New("GetCallerCertificate error")
 }
 
-/*
-	TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-// GetCallerMetadata returns caller metadata
-func (shim *certErrorMock) GetCallerMetadata() ([]byte, error) {
-	return shim.metadata, nil
-}*/
+// GetCallerAttributesKeys returns the keys of the caller certificate attributes
+func (shim *certErrorMock) GetCallerAttributesKeys() ([]byte, error) {
+	return shim.attributesKeys, nil
+}
 
 type metadataErrorMock struct {
 	callerCert []byte
@@ -80,33 +71,19 @@ func (shim *metadataErrorMock) GetCallerCertificate() ([]byte, error) {
 	return shim.callerCert, nil
 }
 
-/*
-	TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-// GetCallerMetadata returns caller metadata
-func (shim *metadataErrorMock) GetCallerMetadata() ([]byte, error) {
-	return nil, errors.New("GetCallerCertificate error")
-}*/
+// GetCallerAttributesKeys returns the keys of the caller certificate attributes
+func (shim *metadataErrorMock) GetCallerAttributesKeys() ([]byte, error) {
+	return nil, errors.New("GetCallerAttributesKeys error")
+}
 
 func TestVerifyAttribute(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata := []byte{32, 64}
-
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -123,9 +100,7 @@ func TestVerifyAttribute(t *testing.T) {
 	}
 }
 
-/*
-	TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-func TestVerifyAttribute_InvalidAttributeMetadata(t *testing.T) {
+func TestVerifyAttribute_InvalidAttributesKeys(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
 	tcert, _, err := loadTCertAndPreK0()
@@ -135,9 +110,9 @@ func TestVerifyAttribute_InvalidAttributeMetadata(t *testing.T) {
 
 	tcertder := tcert.Raw
 
-	attributeMetadata := []byte{123, 22, 34, 56, 78, 44}
+	attributesKeys := []byte{123, 22, 34, 56, 78, 44}
 
-	stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}
+	stub := &chaincodeStubMock{callerCert: tcertder, attributesKeys: attributesKeys}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
 		t.Error(err)
@@ -146,25 +121,11 @@ func TestVerifyAttribute_InvalidAttributeMetadata(t *testing.T) {
 	if keySize != 0 {
 		t.Errorf("Test failed expected [%v] keys but found [%v]", keySize, 0)
 	}
-}*/
+}
 
 func TestNewAttributesHandlerImpl_CertificateError(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		tcert, prek0, err := loadTCertAndPreK0()
-		tcert, err := loadTCertClear()
-		if err != nil {
-			t.Error(err)
-		}
-		tcertder := tcert.Raw
-		metadata := []byte{32, 64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &certErrorMock{metadata: attributeMetadata}*/
 	stub := &certErrorMock{}
 	_, err := NewAttributesHandlerImpl(stub)
 	if err == nil {
@@ -172,9 +133,7 @@ func TestNewAttributesHandlerImpl_CertificateError(t *testing.T) {
 	}
 }
 
-/*
-	TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-func TestNewAttributesHandlerImpl_MetadataError(t *testing.T) {
+func TestNewAttributesHandlerImpl_AttributesKeysError(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
 	tcert, _, err := loadTCertAndPreK0()
@@ -190,7 +149,7 @@ func TestNewAttributesHandlerImpl_MetadataError(t *testing.T) {
 	if err == nil {
 		t.Fatal("Error shouldn't be nil")
 	}
-}*/
+}
 
 func TestNewAttributesHandlerImpl_InvalidCertificate(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
@@ -213,20 +172,6 @@ func TestNewAttributesHandlerImpl_InvalidCertificate(t *testing.T) {
 func TestNewAttributesHandlerImpl_NullCertificate(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-				TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		tcert, prek0, err := loadTCertAndPreK0()
-		tcert, err := loadTCertClear()
-		if err != nil {
-			t.Error(err)
-		}
-		metadata := []byte{32, 64}
-		tcertder := tcert.Raw
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: nil, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: nil}
 	_, err := NewAttributesHandlerImpl(stub)
 	if err == nil {
@@ -234,9 +179,7 @@ func TestNewAttributesHandlerImpl_NullCertificate(t *testing.T) {
 	}
 }
 
-/*
-	TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-func TestNewAttributesHandlerImpl_NullMetadata(t *testing.T) {
+func TestNewAttributesHandlerImpl_NullAttributesKeys(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
 	tcert, _, err := loadTCertAndPreK0()
@@ -247,7 +190,7 @@ func TestNewAttributesHandlerImpl_NullMetadata(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	stub := &chaincodeStubMock{callerCert: tcertder, metadata: nil}
+	stub := &chaincodeStubMock{callerCert: tcertder, attributesKeys: nil}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
 		t.Error(err)
@@ -256,28 +199,17 @@ func TestNewAttributesHandlerImpl_NullMetadata(t *testing.T) {
 	if keySize != 0 {
 		t.Errorf("Test failed expected [%v] keys but found [%v]", keySize, 0)
 	}
-}*/
+}
 
 func TestVerifyAttributes(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata := []byte{32,64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata} */
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -297,23 +229,12 @@ func TestVerifyAttributes(t *testing.T) {
 func TestVerifyAttributes_Invalid(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata := []byte{32,64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -333,9 +254,6 @@ func TestVerifyAttributes_Invalid(t *testing.T) {
 func TestVerifyAttributes_InvalidHeader(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
@@ -345,14 +263,6 @@ func TestVerifyAttributes_InvalidHeader(t *testing.T) {
 	tcert.Raw[583] = tcert.Raw[583] + 124
 
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata := []byte{32,64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -368,29 +278,15 @@ func TestVerifyAttributes_InvalidHeader(t *testing.T) {
 func TestVerifyAttributes_InvalidAttributeValue(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 
 	//Change header extensions
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field. 337 is the offset in encrypted tcert.
-	tcert.Raw[371] = tcert.Raw[371] + 124*/
 	tcert.Raw[558] = tcert.Raw[558] + 124
 
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata := []byte{32,64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata} */
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -406,23 +302,12 @@ func TestVerifyAttributes_InvalidAttributeValue(t *testing.T) {
 func TestVerifyAttributes_Null(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-		metadata := []byte{32,64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -442,23 +327,11 @@ func TestVerifyAttributes_Null(t *testing.T) {
 func TestGetValue(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-			metadata := []byte{32, 64}
-
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -485,6 +358,73 @@ func TestGetValue(t *testing.T) {
 	}
 }
 
+func TestGetValue_Encrypted(t *testing.T) {
+	primitives.SetSecurityLevel("SHA3", 256)
+
+	tcert, prek0, err := loadTCertAndPreK0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcertder := tcert.Raw
+
+	attributesKeys, err := attributes.CreateAttributesMetadata(tcertder, nil, prek0, attributeNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &chaincodeStubMock{callerCert: tcertder, attributesKeys: attributesKeys}
+	handler, err := NewAttributesHandlerImpl(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := handler.GetValue("position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(value, []byte("Software Engineer")) != 0 {
+		t.Fatalf("Value expected was [%v] and result was [%v].", []byte("Software Engineer"), value)
+	}
+
+	//Without the keys the encrypted attributes can't be read.
+	if _, err := GetValueFrom("position", tcertder); err == nil {
+		t.Fatal("Reading an encrypted attribute without its key should fail.")
+	}
+}
+
+func TestNewAttributesHandlerForTx(t *testing.T) {
+	primitives.SetSecurityLevel("SHA3", 256)
+
+	tcert, prek0, err := loadTCertAndPreK0()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributesKeys, err := attributes.CreateAttributesMetadata(tcert.Raw, nil, prek0, []string{"position"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := NewAttributesHandlerForTx(&pb.Transaction{Cert: tcert.Raw, AttributesKeys: attributesKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isOk, err := handler.VerifyAttribute("position", []byte("Software Engineer"))
+	if err != nil || !isOk {
+		t.Fatalf("Disclosed attribute not verified [%v].", err)
+	}
+
+	//Only the disclosed attributes can be read.
+	handler, err = NewAttributesHandlerForTx(&pb.Transaction{Cert: tcert.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.GetValue("position"); err == nil {
+		t.Fatal("Reading an attribute not disclosed should fail.")
+	}
+}
+
 func TestGetValue_Clear(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
@@ -538,22 +478,11 @@ func TestGetValue_Clear_NullTCert(t *testing.T) {
 func TestGetValue_InvalidAttribute(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-				metadata := []byte{32, 64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -581,14 +510,6 @@ func TestGetValue_Clear_InvalidAttribute(t *testing.T) {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-				metadata := []byte{32, 64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
@@ -604,22 +525,11 @@ func TestGetValue_Clear_InvalidAttribute(t *testing.T) {
 func TestGetValue_InvalidAttribute_ValidAttribute(t *testing.T) {
 	primitives.SetSecurityLevel("SHA3", 256)
 
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-	tcert, prek0, err := loadTCertAndPreK0() */
 	tcert, err := loadTCertClear()
 	if err != nil {
 		t.Error(err)
 	}
 	tcertder := tcert.Raw
-	/*
-			TODO: ##attributes-keys-pending This code have be redefined to avoid use of metadata field.
-			metadata := []byte{32, 64}
-		attributeMetadata, err := attributes.CreateAttributesMetadata(tcertder, metadata, prek0, attributeNames)
-		if err != nil {
-			t.Error(err)
-		}
-		stub := &chaincodeStubMock{callerCert: tcertder, metadata: attributeMetadata}*/
 	stub := &chaincodeStubMock{callerCert: tcertder}
 	handler, err := NewAttributesHandlerImpl(stub)
 	if err != nil {
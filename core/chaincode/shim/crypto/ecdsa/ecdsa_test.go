@@ -189,3 +189,48 @@ func TestSignatureVerifierSHA3_512(t *testing.T) {
 		t.Fatal("Signature does not verify")
 	}
 }
+
+func TestSecp256k1SignatureVerifier(t *testing.T) {
+	// Create a signature
+	key, err := primitives.NewSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("Hello World!")
+	signature, err := primitives.Secp256k1Sign(key, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, err := primitives.Secp256k1PublicKeyToBytes(&key.PublicKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := primitives.Secp256k1PublicKeyToDER(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Instantiate a new SignatureVerifier
+	sv := NewSecp256k1SignatureVerifier()
+
+	// Verify the signature, against both encodings of the key
+	for _, vk := range [][]byte{compressed, der} {
+		ok, err := sv.Verify(vk, signature, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("Signature does not verify")
+		}
+	}
+
+	ok, err := sv.Verify(compressed, signature, []byte("Hello World?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("Signature of another message verifies")
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ecdsa
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim/crypto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+type secp256k1SignatureVerifierImpl struct {
+}
+
+// Verify verifies signature, on the SHA-256 hash of message, against
+// publicKey, a secp256k1 public key encoded as in SEC 1, compressed or
+// not, or as a PKIX SubjectPublicKeyInfo
+func (sv *secp256k1SignatureVerifierImpl) Verify(publicKey, signature, message []byte) (bool, error) {
+	vk, err := sv.parsePublicKey(publicKey)
+	if err != nil {
+		return false, err
+	}
+
+	return primitives.Secp256k1Verify(vk, message, signature)
+}
+
+func (sv *secp256k1SignatureVerifierImpl) parsePublicKey(publicKey []byte) (*ecdsa.PublicKey, error) {
+	if vk, err := primitives.BytesToSecp256k1PublicKey(publicKey); err == nil {
+		return vk, nil
+	}
+
+	return primitives.DERToSecp256k1PublicKey(publicKey)
+}
+
+// NewSecp256k1SignatureVerifier returns a verifier of the secp256k1
+// signatures of the identities bridged from other blockchains
+func NewSecp256k1SignatureVerifier() crypto.SignatureVerifier {
+	return &secp256k1SignatureVerifierImpl{}
+}
@@ -52,7 +52,7 @@ func RegisterClient(name string, pwd []byte, enrollID, enrollPWD string) error {
 		return nil
 	}
 
-	client := newClient()
+	client := newClient(nil)
 	if err := client.register(name, pwd, enrollID, enrollPWD); err != nil {
 		if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
 			log.Errorf("Failed registering client [%s] with name [%s] [%s].", enrollID, name, err)
@@ -86,7 +86,7 @@ func InitClient(name string, pwd []byte) (Client, error) {
 		return clients[name].client, nil
 	}
 
-	client := newClient()
+	client := newClient(nil)
 	if err := client.init(name, pwd); err != nil {
 		log.Errorf("Failed client initialization [%s]: [%s].", name, err)
 
@@ -107,31 +107,29 @@ func CloseClient(client Client) error {
 	return closeClientInternal(client, false)
 }
 
-// CloseAllClients closes all the clients initialized so far
+// CloseAllClients closes all the clients initialized so far. It returns true if
+// all were closed, and the errors of the ones failing to close otherwise.
 func CloseAllClients() (bool, []error) {
 	clientMutex.Lock()
 	defer clientMutex.Unlock()
 
 	log.Info("Closing all clients...")
 
-	errs := make([]error, len(clients))
+	errs := []error{}
 	for _, value := range clients {
 		err := closeClientInternal(value.client, true)
-
-		errs = append(errs, err)
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	log.Info("Closing all clients...done!")
 
-	return len(errs) != 0, errs
+	return len(errs) == 0, errs
 }
 
 // Private Methods
 
-func newClient() *clientImpl {
-	return &clientImpl{&nodeImpl{}, false, nil, nil, nil, nil}
-}
-
 func closeClientInternal(client Client, force bool) error {
 	if client == nil {
 		return utils.ErrNilArgument
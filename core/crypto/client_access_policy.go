@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"encoding/asn1"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// Private Methods
+
+// newPayloadAccessRequest generates the ephemeral key the grant is to be
+// encrypted under, stores its private part and returns the signed request
+func (client *clientImpl) newPayloadAccessRequest(tx *obc.Transaction) ([]byte, error) {
+	key, err := client.eciesSPI.NewPrivateKey(primitives.RandReader, primitives.GetDefaultCurve())
+	if err != nil {
+		client.Errorf("Failed generating access request key [%s].", err.Error())
+		return nil, err
+	}
+	privBytes, err := client.eciesSPI.SerializePrivateKey(key)
+	if err != nil {
+		client.Errorf("Failed serializing access request key [%s].", err.Error())
+		return nil, err
+	}
+	pubBytes, err := client.eciesSPI.SerializePublicKey(key.GetPublicKey())
+	if err != nil {
+		client.Errorf("Failed serializing access request key [%s].", err.Error())
+		return nil, err
+	}
+
+	request := &payloadAccessRequest{Nonce: tx.Nonce, Cert: client.enrollCert.Raw, PublicKey: pubBytes}
+	signed, err := request.signedBytes()
+	if err != nil {
+		client.Errorf("Failed marshalling access request [%s].", err.Error())
+		return nil, err
+	}
+	if request.Signature, err = client.signWithEnrollmentKey(signed); err != nil {
+		client.Errorf("Failed signing access request [%s].", err.Error())
+		return nil, err
+	}
+	raw, err := asn1.Marshal(*request)
+	if err != nil {
+		client.Errorf("Failed marshalling access request [%s].", err.Error())
+		return nil, err
+	}
+
+	if err := client.ks.PutBlob(payloadAccessNamespace, tx.Uuid, privBytes); err != nil {
+		client.Errorf("Failed storing access request key [%s].", err.Error())
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// decryptPayload opens grant with the key of the access request for tx,
+// then removes the two layers of the payload of tx
+func (client *clientImpl) decryptPayload(tx *obc.Transaction, grant []byte) ([]byte, error) {
+	privBytes, err := client.ks.GetBlob(payloadAccessNamespace, tx.Uuid)
+	if err != nil {
+		client.Errorf("Failed loading access request key [%s].", err.Error())
+		return nil, err
+	}
+	if privBytes == nil {
+		client.Errorf("No access request for transaction [%s].", tx.Uuid)
+		return nil, errNoPayloadAccessRequest
+	}
+
+	cipher, err := client.eciesSPI.NewAsymmetricCipherFromSerializedPrivateKey(privBytes)
+	if err != nil {
+		client.Errorf("Failed init decryption engine [%s].", err.Error())
+		return nil, err
+	}
+	grantRaw, err := cipher.Process(grant)
+	if err != nil {
+		client.Errorf("Failed decrypting access grant [%s].", err.Error())
+		return nil, err
+	}
+	keys := new(payloadAccessGrant)
+	if _, err := asn1.Unmarshal(grantRaw, keys); err != nil {
+		client.Errorf("Failed unmarshalling access grant [%s].", err.Error())
+		return nil, err
+	}
+
+	payload, err := primitives.CBCPKCS7Decrypt(keys.TxPayloadKey, tx.Payload)
+	if err != nil {
+		client.Errorf("Failed decrypting payload [%s].", err.Error())
+		return nil, err
+	}
+	if payload, err = primitives.CBCPKCS7Decrypt(keys.PayloadKey, payload); err != nil {
+		client.Errorf("Failed decrypting restricted payload [%s].", err.Error())
+		return nil, err
+	}
+
+	// The request is answered, its key is of no further use
+	if err := client.ks.PutBlob(payloadAccessNamespace, tx.Uuid, nil); err != nil {
+		client.Warningf("Failed deleting access request key [%s].", err.Error())
+	}
+
+	return payload, nil
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package crypto
 
 import (
-	"crypto/rand"
 	"encoding/asn1"
 	"errors"
 
@@ -26,11 +25,16 @@ import (
 	obc "github.com/hyperledger/fabric/protos"
 )
 
-func (client *clientImpl) encryptTx(tx *obc.Transaction) error {
+// encryptTx encrypts tx. With policy, the payload is
+// restricted to the validators and the identities it permits.
+func (client *clientImpl) encryptTx(tx *obc.Transaction, policy *AccessPolicy) error {
 
 	if len(tx.Nonce) == 0 {
 		return errors.New("Failed encrypting payload. Invalid nonce.")
 	}
+	if policy != nil && tx.ConfidentialityProtocolVersion != "1.3" {
+		return errAccessPolicyUnsupported
+	}
 
 	client.Debugf("Confidentiality protocol version [%s]", tx.ConfidentialityProtocolVersion)
 	switch tx.ConfidentialityProtocolVersion {
@@ -40,6 +44,9 @@ func (client *clientImpl) encryptTx(tx *obc.Transaction) error {
 	case "1.2":
 		client.Debug("Using confidentiality protocol version 1.2")
 		return client.encryptTxVersion1_2(tx)
+	case "1.3":
+		client.Debug("Using confidentiality protocol version 1.3")
+		return client.encryptTxVersion1_3(tx, policy)
 	}
 
 	return utils.ErrInvalidProtocolVersion
@@ -56,6 +63,12 @@ func (client *clientImpl) encryptTxVersion1_1(tx *obc.Transaction) error {
 	//	client.log.Info("Nonce  ", utils.EncodeBase64(tx.Nonce))
 	//	client.log.Info("Derived key  ", utils.EncodeBase64(txKey))
 
+	return encryptTxFields(txKey, tx)
+}
+
+// encryptTxFields encrypts payload, chaincodeID, metadata and attributes
+// keys of tx under keys derived from txKey
+func encryptTxFields(txKey []byte, tx *obc.Transaction) error {
 	// Encrypt Payload
 	payloadKey := primitives.HMACAESTruncated(txKey, []byte{1})
 	encryptedPayload, err := primitives.CBCPKCS7Encrypt(payloadKey, tx.Payload)
@@ -82,6 +95,16 @@ func (client *clientImpl) encryptTxVersion1_1(tx *obc.Transaction) error {
 		tx.Metadata = encryptedMetadata
 	}
 
+	// Encrypt AttributesKeys
+	if len(tx.AttributesKeys) != 0 {
+		attributesKeysKey := primitives.HMACAESTruncated(txKey, []byte{4})
+		encryptedAttributesKeys, err := primitives.CBCPKCS7Encrypt(attributesKeysKey, tx.AttributesKeys)
+		if err != nil {
+			return err
+		}
+		tx.AttributesKeys = encryptedAttributesKeys
+	}
+
 	return nil
 }
 
@@ -93,7 +116,7 @@ type chainCodeValidatorMessage1_2 struct {
 
 func (client *clientImpl) encryptTxVersion1_2(tx *obc.Transaction) error {
 	// Create (PK_C,SK_C) pair
-	ccPrivateKey, err := client.eciesSPI.NewPrivateKey(rand.Reader, primitives.GetDefaultCurve())
+	ccPrivateKey, err := client.eciesSPI.NewPrivateKey(primitives.RandReader, primitives.GetDefaultCurve())
 	if err != nil {
 		client.Errorf("Failed generate chaincode keypair: [%s]", err)
 
@@ -211,5 +234,119 @@ func (client *clientImpl) encryptTxVersion1_2(tx *obc.Transaction) error {
 		tx.Metadata = encryptedMetadata
 	}
 
+	// Encrypt attributes keys using pkC
+	if len(tx.AttributesKeys) != 0 {
+		encryptedAttributesKeys, err := cipher.Process(tx.AttributesKeys)
+		if err != nil {
+			client.Errorf("Failed encrypting attributes keys: [%s]", err)
+
+			return err
+		}
+		tx.AttributesKeys = encryptedAttributesKeys
+	}
+
 	return nil
 }
+
+// chainCodeValidatorMessage1_3 represents a message to validators.
+// PayloadAccess is set when an access policy restricts the payload.
+type chainCodeValidatorMessage1_3 struct {
+	ChaincodeKey  []byte
+	QueryKey      []byte
+	PayloadAccess payloadAccess `asn1:"optional"`
+}
+
+func (client *clientImpl) encryptTxVersion1_3(tx *obc.Transaction, policy *AccessPolicy) error {
+	// The chaincodeID is still in clear
+	name, err := chaincodeKeyNameFromTx(tx)
+	if err != nil {
+		client.Errorf("Failed getting chaincode name: [%s]", err)
+
+		return err
+	}
+
+	// Get the chaincode key. The deployer generates it, the invokers
+	// must have received it from the deployer
+	var chaincodeKey []byte
+	switch tx.Type {
+	case obc.Transaction_CHAINCODE_DEPLOY:
+		chaincodeKey, err = primitives.GenAESKey()
+		if err != nil {
+			client.Errorf("Failed creating chaincode key: [%s]", err)
+
+			return err
+		}
+
+		if err = client.ks.storeChaincodeKey(name, chaincodeKey); err != nil {
+			client.Errorf("Failed storing chaincode key: [%s]", err)
+
+			return err
+		}
+	default:
+		chaincodeKey, err = client.ks.loadChaincodeKey(name)
+		if err != nil {
+			client.Errorf("Failed loading chaincode key: [%s]", err)
+
+			return err
+		}
+		if chaincodeKey == nil {
+			client.Errorf("Failed loading chaincode key: [%s] not found", name)
+
+			return utils.ErrUnknownChaincodeKey
+		}
+	}
+
+	// The result of a query is encrypted to its invoker only
+	queryKey := make([]byte, 0)
+	if tx.Type == obc.Transaction_CHAINCODE_QUERY {
+		queryKey = primitives.HMACAESTruncated(client.queryStateKey, append([]byte{6}, tx.Nonce...))
+	}
+
+	// Restrict the payload to the access policy, under a payload key
+	// carried to the validators only
+	msg := chainCodeValidatorMessage1_3{ChaincodeKey: chaincodeKey, QueryKey: queryKey}
+	if policy != nil {
+		payloadKey, err := primitives.GenAESKey()
+		if err != nil {
+			client.Errorf("Failed creating payload key: [%s]", err)
+
+			return err
+		}
+
+		encryptedPayload, err := primitives.CBCPKCS7Encrypt(payloadLayerKey(payloadKey), tx.Payload)
+		if err != nil {
+			client.Errorf("Failed encrypting payload: [%s]", err)
+
+			return err
+		}
+		tx.Payload = encryptedPayload
+
+		msg.PayloadAccess = payloadAccess{PayloadKey: payloadKey, Policy: newAccessPolicy(policy)}
+	}
+
+	// Wrap the chaincode key under the chain key
+	cipher, err := client.eciesSPI.NewAsymmetricCipherFromPublicKey(client.chainPublicKey)
+	if err != nil {
+		client.Errorf("Failed creating new encryption scheme: [%s]", err)
+
+		return err
+	}
+
+	msgToValidators, err := asn1.Marshal(msg)
+	if err != nil {
+		client.Errorf("Failed preparing message to the validators: [%s]", err)
+
+		return err
+	}
+
+	encMsgToValidators, err := cipher.Process(msgToValidators)
+	if err != nil {
+		client.Errorf("Failed encrypting message to the validators: [%s]", err)
+
+		return err
+	}
+	tx.ToValidators = encMsgToValidators
+
+	// Encrypt the rest of the fields under the transaction key
+	return encryptTxFields(chaincodeTxKey(chaincodeKey, tx.Nonce), tx)
+}
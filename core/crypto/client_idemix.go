@@ -0,0 +1,241 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/idemix"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// A client gets its anonymous credential in a round trip with the issuer:
+// it sends a request committing to a secret it keeps, bound to a nonce of
+// the issuer, and receives the issued credential. The issuer public key,
+// the secret of a pending request and the credential are kept in the
+// keystore.
+
+// Private type and variables
+
+const (
+	idemixNamespace = "idemix"
+)
+
+// Private Methods
+
+// initIdemix loads the anonymous credential of the client, if any
+func (client *clientImpl) initIdemix() error {
+	raw, err := client.ks.GetBlob(idemixNamespace, "credential")
+	if err != nil || raw == nil {
+		return err
+	}
+	credential, err := idemix.ParseCredential(raw)
+	if err != nil {
+		return err
+	}
+	issuer, err := client.loadIdemixIssuer()
+	if err != nil {
+		return err
+	}
+	if err := credential.Verify(issuer); err != nil {
+		return err
+	}
+
+	client.idemixMutex.Lock()
+	client.idemixIssuer, client.idemixCredential = issuer, credential
+	client.idemixMutex.Unlock()
+
+	return nil
+}
+
+func (client *clientImpl) loadIdemixIssuer() (*idemix.IssuerPublicKey, error) {
+	raw, err := client.ks.GetBlob(idemixNamespace, "issuerPublicKey")
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, utils.ErrNoCredential
+	}
+
+	return idemix.ParseIssuerPublicKey(raw)
+}
+
+// newAnonymousCredentialRequest returns a request for a credential
+// under issuerPublicKey, bound to nonce, and keeps its secrets
+func (client *clientImpl) newAnonymousCredentialRequest(issuerPublicKey, nonce []byte) ([]byte, error) {
+	issuer, err := idemix.ParseIssuerPublicKey(issuerPublicKey)
+	if err != nil {
+		client.Errorf("Failed parsing issuer public key [%s].", err.Error())
+		return nil, err
+	}
+
+	request, pending, err := idemix.NewCredentialRequest(issuer, nonce)
+	if err != nil {
+		client.Errorf("Failed creating credential request [%s].", err.Error())
+		return nil, err
+	}
+	rawPending, err := pending.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	err = client.ks.PutBlobs(idemixNamespace, map[string][]byte{
+		"pending":       rawPending,
+		"pendingIssuer": issuerPublicKey,
+	})
+	if err != nil {
+		client.Errorf("Failed storing credential request [%s].", err.Error())
+		return nil, err
+	}
+
+	return request.Bytes()
+}
+
+// setAnonymousCredential completes the pending request with the
+// credential issued, and stores it
+func (client *clientImpl) setAnonymousCredential(issuedCredential []byte) error {
+	rawPending, err := client.ks.GetBlob(idemixNamespace, "pending")
+	if err != nil {
+		return err
+	}
+	rawIssuer, err := client.ks.GetBlob(idemixNamespace, "pendingIssuer")
+	if err != nil {
+		return err
+	}
+	if rawPending == nil || rawIssuer == nil {
+		return utils.ErrNoCredential
+	}
+
+	pending, err := idemix.ParsePendingCredential(rawPending)
+	if err != nil {
+		return err
+	}
+	issuer, err := idemix.ParseIssuerPublicKey(rawIssuer)
+	if err != nil {
+		return err
+	}
+	issued, err := idemix.ParseIssuedCredential(issuedCredential)
+	if err != nil {
+		return err
+	}
+	credential, err := pending.Complete(issuer, issued)
+	if err != nil {
+		client.Errorf("Failed completing credential [%s].", err.Error())
+		return err
+	}
+	rawCredential, err := credential.Bytes()
+	if err != nil {
+		return err
+	}
+
+	err = client.ks.PutBlobs(idemixNamespace, map[string][]byte{
+		"credential":      rawCredential,
+		"issuerPublicKey": rawIssuer,
+		"pending":         nil,
+		"pendingIssuer":   nil,
+	})
+	if err != nil {
+		client.Errorf("Failed storing credential [%s].", err.Error())
+		return err
+	}
+
+	client.idemixMutex.Lock()
+	client.idemixIssuer, client.idemixCredential = issuer, credential
+	client.idemixMutex.Unlock()
+
+	return nil
+}
+
+func (client *clientImpl) getAnonymousCredential() (*idemix.Credential, *idemix.IssuerPublicKey, error) {
+	client.idemixMutex.RLock()
+	defer client.idemixMutex.RUnlock()
+
+	if client.idemixCredential == nil {
+		return nil, nil, utils.ErrNoCredential
+	}
+
+	return client.idemixCredential, client.idemixIssuer, nil
+}
+
+func (client *clientImpl) newChaincodeDeployUsingCredential(chaincodeDeploymentSpec *obc.ChaincodeDeploymentSpec, uuid string, attributeNames []string, nonce []byte) (*obc.Transaction, error) {
+	// Create a new transaction
+	tx, err := client.createDeployTx(chaincodeDeploymentSpec, uuid, nonce, nil)
+	if err != nil {
+		client.Errorf("Failed creating new deploy transaction [%s].", err.Error())
+		return nil, err
+	}
+
+	return client.signWithCredential(tx, attributeNames)
+}
+
+func (client *clientImpl) newChaincodeExecuteUsingCredential(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributeNames []string, nonce []byte, policy *AccessPolicy) (*obc.Transaction, error) {
+	// Create a new transaction
+	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nonce, policy, nil)
+	if err != nil {
+		client.Errorf("Failed creating new execute transaction [%s].", err.Error())
+		return nil, err
+	}
+
+	return client.signWithCredential(tx, attributeNames)
+}
+
+func (client *clientImpl) newChaincodeQueryUsingCredential(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributeNames []string, nonce []byte) (*obc.Transaction, error) {
+	// Create a new transaction
+	tx, err := client.createQueryTx(chaincodeInvocation, uuid, nonce, nil)
+	if err != nil {
+		client.Errorf("Failed creating new query transaction [%s].", err.Error())
+		return nil, err
+	}
+
+	return client.signWithCredential(tx, attributeNames)
+}
+
+// signWithCredential signs tx with the anonymous credential of the
+// client, disclosing the attributes attributeNames
+func (client *clientImpl) signWithCredential(tx *obc.Transaction, attributeNames []string) (*obc.Transaction, error) {
+	credential, issuer, err := client.getAnonymousCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	// Append the issuer to the transaction
+	if tx.Cert, err = newIdemixCert(issuer); err != nil {
+		return nil, err
+	}
+
+	// Sign the transaction and append the signature
+	// 1. Marshall tx to bytes
+	rawTx, err := proto.Marshal(tx)
+	if err != nil {
+		client.Errorf("Failed marshaling tx [%s].", err.Error())
+		return nil, err
+	}
+
+	// 2. Sign rawTx
+	signature, err := credential.Sign(issuer, attributeNames, rawTx)
+	if err != nil {
+		client.Errorf("Failed creating idemix signature [%s].", err.Error())
+		return nil, err
+	}
+	client.logSignature("idemix/"+utils.EncodeBase64(tx.Cert[len(idemixCertPrefix):]), rawTx)
+
+	// 3. Append the signature
+	if tx.Signature, err = signature.Bytes(); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
@@ -18,7 +18,10 @@ package crypto
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
+	"github.com/hyperledger/fabric/core/crypto/idemix"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	obc "github.com/hyperledger/fabric/protos"
@@ -36,6 +39,11 @@ type clientImpl struct {
 	// TCA KDFKey
 	tCertOwnerKDFKey []byte
 	tCertPool        tCertPool
+
+	// Anonymous credential, and its issuer
+	idemixMutex      sync.RWMutex
+	idemixIssuer     *idemix.IssuerPublicKey
+	idemixCredential *idemix.Credential
 }
 
 // NewChaincodeDeployTransaction is used to deploy chaincode.
@@ -45,6 +53,11 @@ func (client *clientImpl) NewChaincodeDeployTransaction(chaincodeDeploymentSpec
 		return nil, utils.ErrNotInitialized
 	}
 
+	// Sign with the anonymous credential rather than a TCert
+	if client.conf.isIdemixEnabled() {
+		return client.newChaincodeDeployUsingCredential(chaincodeDeploymentSpec, uuid, attributes, nil)
+	}
+
 	// Get next available (not yet used) transaction certificate
 	tCerts, err := client.tCertPool.GetNextTCerts(1, attributes...)
 	if err != nil {
@@ -92,6 +105,33 @@ func (client *clientImpl) NewChaincodeExecute(chaincodeInvocation *obc.Chaincode
 		return nil, utils.ErrNotInitialized
 	}
 
+	return client.newChaincodeExecute(chaincodeInvocation, uuid, nil, attributes...)
+}
+
+// NewChaincodeExecuteWithPolicy is used to execute chaincode's functions,
+// with a payload restricted to the validators and the access policy.
+func (client *clientImpl) NewChaincodeExecuteWithPolicy(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, policy *AccessPolicy, attributes ...string) (*obc.Transaction, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+	if policy == nil {
+		return nil, utils.ErrNilArgument
+	}
+	if chaincodeInvocation.ChaincodeSpec.ConfidentialityLevel != obc.ConfidentialityLevel_CONFIDENTIAL ||
+		client.conf.GetConfidentialityProtocolVersion() != "1.3" {
+		return nil, errAccessPolicyUnsupported
+	}
+
+	return client.newChaincodeExecute(chaincodeInvocation, uuid, policy, attributes...)
+}
+
+func (client *clientImpl) newChaincodeExecute(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, policy *AccessPolicy, attributes ...string) (*obc.Transaction, error) {
+	// Sign with the anonymous credential rather than a TCert
+	if client.conf.isIdemixEnabled() {
+		return client.newChaincodeExecuteUsingCredential(chaincodeInvocation, uuid, attributes, nil, policy)
+	}
+
 	// Get next available (not yet used) transaction certificate
 	tBlocks, err := client.tCertPool.GetNextTCerts(1, attributes...)
 	if err != nil {
@@ -105,7 +145,7 @@ func (client *clientImpl) NewChaincodeExecute(chaincodeInvocation *obc.Chaincode
 	}
 
 	// Create Transaction
-	return client.newChaincodeExecuteUsingTCert(chaincodeInvocation, uuid, attributes, tBlocks[0].tCert, nil)
+	return client.newChaincodeExecuteUsingTCert(chaincodeInvocation, uuid, attributes, tBlocks[0].tCert, nil, policy)
 }
 
 // NewChaincodeQuery is used to query chaincode's functions.
@@ -115,6 +155,11 @@ func (client *clientImpl) NewChaincodeQuery(chaincodeInvocation *obc.ChaincodeIn
 		return nil, utils.ErrNotInitialized
 	}
 
+	// Sign with the anonymous credential rather than a TCert
+	if client.conf.isIdemixEnabled() {
+		return client.newChaincodeQueryUsingCredential(chaincodeInvocation, uuid, attributes, nil)
+	}
+
 	// Get next available (not yet used) transaction certificate
 	tBlocks, err := client.tCertPool.GetNextTCerts(1, attributes...)
 	if err != nil {
@@ -205,6 +250,188 @@ func (client *clientImpl) GetTCertificateHandlerFromDER(tCertDER []byte) (Certif
 	return handler, nil
 }
 
+// GetTCert returns a CertificateHandler whose certificate is the index-th
+// TCert used by the client, in the order they were used
+func (client *clientImpl) GetTCert(index int) (CertificateHandler, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	if index < 0 {
+		return nil, errors.New("Invalid TCert index. It must be non-negative.")
+	}
+
+	tCertDBBlock, err := client.ks.loadUsedTCert(index)
+	if err != nil {
+		client.Errorf("Failed loading TCert [%d] [%s].", index, err)
+
+		return nil, err
+	}
+	if tCertDBBlock == nil {
+		return nil, utils.ErrUnknownTCert
+	}
+
+	tCertBlock, err := client.getTCertFromDER(tCertDBBlock)
+	if err != nil {
+		client.Errorf("Failed parsing TCert [%d] [%s].", index, err)
+
+		return nil, err
+	}
+
+	// Return the handler
+	handler := &tCertHandlerImpl{}
+	err = handler.init(client, tCertBlock.tCert)
+	if err != nil {
+		client.Errorf("Failed getting handler [%s].", err.Error())
+		return nil, err
+	}
+
+	return handler, nil
+}
+
+// SignWithTCert signs msg with the signing key of tCertDER, a TCert
+// issued to the client
+func (client *clientImpl) SignWithTCert(tCertDER, msg []byte) ([]byte, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	tCert, err := client.getTCertFromExternalDER(tCertDER)
+	if err != nil {
+		client.Warningf("Failed validating transaction certificate [%s].", err)
+
+		return nil, err
+	}
+	if impl, ok := tCert.(*tCertImpl); !ok || impl.sk == nil {
+		return nil, utils.ErrForeignTCert
+	}
+
+	return tCert.Sign(msg)
+}
+
+// GetChaincodeKey returns the key protecting the transactions and the state
+// of chaincodeID under confidentiality protocol 1.3, to be handed to the
+// clients authorized to invoke it
+func (client *clientImpl) GetChaincodeKey(chaincodeID *obc.ChaincodeID) ([]byte, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	name, err := chaincodeKeyName(chaincodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	chaincodeKey, err := client.ks.loadChaincodeKey(name)
+	if err != nil {
+		client.Errorf("Failed loading chaincode key [%s].", err)
+
+		return nil, err
+	}
+	if chaincodeKey == nil {
+		return nil, utils.ErrUnknownChaincodeKey
+	}
+
+	return chaincodeKey, nil
+}
+
+// SetChaincodeKey stores the key of chaincodeID received from its deployer,
+// allowing this client to invoke and query it under confidentiality
+// protocol 1.3
+func (client *clientImpl) SetChaincodeKey(chaincodeID *obc.ChaincodeID, chaincodeKey []byte) error {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	if len(chaincodeKey) != primitives.AESKeyLength {
+		return fmt.Errorf("Invalid chaincode key. Its length must be [%d].", primitives.AESKeyLength)
+	}
+
+	name, err := chaincodeKeyName(chaincodeID)
+	if err != nil {
+		return err
+	}
+
+	if err := client.ks.storeChaincodeKey(name, chaincodeKey); err != nil {
+		client.Errorf("Failed storing chaincode key [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// RotateEnrollmentKey replaces the enrollment key and cert of the client with
+// new ones for the same identity. The old key is retired after the overlap window.
+func (client *clientImpl) RotateEnrollmentKey() error {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	if err := client.rotateEnrollmentKey(); err != nil {
+		client.Errorf("Failed rotating enrollment key [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// NewAnonymousCredentialRequest returns a request for an anonymous credential
+// to the issuer of public key issuerPublicKey, bound to the nonce of the issuer.
+func (client *clientImpl) NewAnonymousCredentialRequest(issuerPublicKey, nonce []byte) ([]byte, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	return client.newAnonymousCredentialRequest(issuerPublicKey, nonce)
+}
+
+// SetAnonymousCredential stores the anonymous credential issued in
+// response to the last request of the client
+func (client *clientImpl) SetAnonymousCredential(issuedCredential []byte) error {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	return client.setAnonymousCredential(issuedCredential)
+}
+
+// NewPayloadAccessRequest returns a request, to be handed to a
+// validator, to read the payload of tx restricted by an access policy
+func (client *clientImpl) NewPayloadAccessRequest(tx *obc.Transaction) ([]byte, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+	if tx == nil {
+		return nil, utils.ErrNilArgument
+	}
+
+	return client.newPayloadAccessRequest(tx)
+}
+
+// DecryptPayload decrypts the payload of tx with the grant returned by
+// a validator in response to the last access request of the client for tx
+func (client *clientImpl) DecryptPayload(tx *obc.Transaction, grant []byte) ([]byte, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+	if tx == nil || len(grant) == 0 {
+		return nil, utils.ErrNilArgument
+	}
+
+	return client.decryptPayload(tx, grant)
+}
+
 func (client *clientImpl) register(id string, pwd []byte, enrollID, enrollPWD string) (err error) {
 	if client.isInitialized {
 		client.Errorf("Registering [%s]...done! Initialization already performed", id)
@@ -259,6 +486,12 @@ func (client *clientImpl) init(id string, pwd []byte) error {
 		return err
 	}
 
+	// Load the anonymous credential
+	if err := client.initIdemix(); err != nil {
+		client.Errorf("Failed loading anonymous credential [%s].", err.Error())
+		return err
+	}
+
 	// initialized
 	client.isInitialized = true
 
@@ -266,14 +499,23 @@ func (client *clientImpl) init(id string, pwd []byte) error {
 }
 
 func (client *clientImpl) close() (err error) {
+	// Store the pending TCerts before closing the keystore
 	if client.tCertPool != nil {
 		if err = client.tCertPool.Stop(); err != nil {
-			client.Debugf("Failed closing TCertPool [%s]", err)
+			client.Errorf("Failed closing TCertPool [%s]", err)
 		}
 	}
 
-	if err = client.nodeImpl.close(); err != nil {
-		client.Debugf("Failed closing node [%s]", err)
+	if nodeErr := client.nodeImpl.close(); nodeErr != nil {
+		client.Errorf("Failed closing node [%s]", nodeErr)
+		if err == nil {
+			err = nodeErr
+		}
 	}
+
+	// Wipe the secrets of the client
+	utils.Zeroize(client.queryStateKey)
+	utils.Zeroize(client.tCertOwnerKDFKey)
+
 	return
 }
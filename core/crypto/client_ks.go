@@ -19,6 +19,7 @@ package crypto
 import (
 	"database/sql"
 	"os"
+	"time"
 )
 
 func (client *clientImpl) initKeyStore() error {
@@ -26,15 +27,9 @@ func (client *clientImpl) initKeyStore() error {
 	os.MkdirAll(client.conf.getTCertsPath(), 0755)
 
 	// create tables
-	client.Debugf("Create Table if not exists [TCert] at [%s].", client.conf.getKeyStorePath())
-	if _, err := client.ks.sqlDB.Exec("CREATE TABLE IF NOT EXISTS TCerts (id INTEGER, attrhash VARCHAR, cert BLOB, prkz BLOB, PRIMARY KEY (id))"); err != nil {
-		client.Debugf("Failed creating table [%s].", err)
-		return err
-	}
-
-	client.Debugf("Create Table if not exists [UsedTCert] at [%s].", client.conf.getKeyStorePath())
-	if _, err := client.ks.sqlDB.Exec("CREATE TABLE IF NOT EXISTS UsedTCert (id INTEGER, attrhash VARCHAR, cert BLOB, prkz BLOB, PRIMARY KEY (id))"); err != nil {
-		client.Debugf("Failed creating table [%s].", err)
+	client.Debugf("Migrate TCert tables at [%s].", client.conf.getKeyStorePath())
+	if err := migrateSchema(client.ks.sqlDB, "client", clientMigrations); err != nil {
+		client.Debugf("Failed migrating tables [%s].", err)
 		return err
 	}
 
@@ -47,6 +42,15 @@ func (ks *keyStore) storeUsedTCert(tCertBlck *TCertBlock) (err error) {
 
 	ks.node.Debug("Storing used TCert...")
 
+	if err = ks.checkWritable(); err != nil {
+		return
+	}
+
+	cert, prek0, err := ks.encryptTCertBlock(tCertBlck)
+	if err != nil {
+		return
+	}
+
 	// Open transaction
 	tx, err := ks.sqlDB.Begin()
 	if err != nil {
@@ -56,8 +60,9 @@ func (ks *keyStore) storeUsedTCert(tCertBlck *TCertBlock) (err error) {
 	}
 
 	// Insert into UsedTCert
-	if _, err = tx.Exec("INSERT INTO UsedTCert (attrhash, cert, prkz) VALUES (?, ?, ?)", tCertBlck.attributesHash, tCertBlck.tCert.GetCertificate().Raw, tCertBlck.tCert.GetPreK0()); err != nil {
+	if _, err = tx.Exec("INSERT INTO UsedTCert (owner, attrhash, cert, prkz) VALUES (?, ?, ?, ?)", ks.node.conf.getKeyStoreOwner(), tCertBlck.attributesHash, cert, prek0); err != nil {
 		ks.node.Errorf("Failed inserting TCert to UsedTCert: [%s].", err)
+		tCertInsertFailures.inc()
 
 		tx.Rollback()
 
@@ -98,31 +103,16 @@ func (ks *keyStore) storeUnusedTCerts(tCertBlocks []*TCertBlock) (err error) {
 		return
 	}
 
-	// Open transaction
-	tx, err := ks.sqlDB.Begin()
-	if err != nil {
-		ks.node.Errorf("Failed beginning transaction [%s].", err)
-
-		return
-	}
-
-	for _, tCertBlck := range tCertBlocks {
-		// Insert into UsedTCert
-		if _, err = tx.Exec("INSERT INTO TCerts (attrhash, cert, prkz) VALUES (?, ?, ?)", tCertBlck.attributesHash, tCertBlck.tCert.GetCertificate().Raw, tCertBlck.tCert.GetPreK0()); err != nil {
-			ks.node.Errorf("Failed inserting unused TCert to TCerts: [%s].", err)
-
-			tx.Rollback()
-
-			return
+	records := make([]CertRecord, len(tCertBlocks))
+	for i, tCertBlck := range tCertBlocks {
+		records[i] = CertRecord{
+			AttributesHash: tCertBlck.attributesHash,
+			Cert:           tCertBlck.tCert.GetCertificate().Raw,
+			PreK0:          tCertBlck.tCert.GetPreK0(),
 		}
 	}
 
-	// Finalize
-	err = tx.Commit()
-	if err != nil {
-		ks.node.Errorf("Failed commiting [%s].", err)
-		tx.Rollback()
-
+	if err = ks.StoreCertificates(records); err != nil {
 		return
 	}
 
@@ -131,12 +121,35 @@ func (ks *keyStore) storeUnusedTCerts(tCertBlocks []*TCertBlock) (err error) {
 	return
 }
 
+// CertRecord is a TCert, with its attributes hash and preK0, to be stored in the TCerts table
+type CertRecord struct {
+	AttributesHash string
+	Cert           []byte
+	PreK0          []byte
+}
+
+// StoreCertificates stores a batch of unused TCerts in a single transaction
+func (ks *keyStore) StoreCertificates(records []CertRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	return ks.WithTx(func(tx CertTx) error {
+		return tx.StoreCertificates(records)
+	})
+}
+
 //Used by the MT pool
 func (ks *keyStore) loadUnusedTCert() ([]byte, error) {
+	// Loaded TCerts are removed
+	if err := ks.checkWritable(); err != nil {
+		return nil, err
+	}
+
 	// Get the first row available
 	var id int
 	var cert []byte
-	row := ks.sqlDB.QueryRow("SELECT id, cert FROM TCerts")
+	row := ks.sqlDB.QueryRow("SELECT id, cert FROM TCerts WHERE owner = ? AND spent = 0", ks.node.conf.getKeyStoreOwner())
 	err := row.Scan(&id, &cert)
 
 	if err == sql.ErrNoRows {
@@ -154,12 +167,17 @@ func (ks *keyStore) loadUnusedTCert() ([]byte, error) {
 		return nil, err
 	}
 
-	return cert, nil
+	return ks.decryptBlob(cert)
 }
 
 func (ks *keyStore) loadUnusedTCerts() ([]*TCertDBBlock, error) {
+	// Loaded TCerts are removed
+	if err := ks.checkWritable(); err != nil {
+		return nil, err
+	}
+
 	// Get unused TCerts
-	rows, err := ks.sqlDB.Query("SELECT attrhash, cert, prkz FROM TCerts")
+	rows, err := ks.sqlDB.Query("SELECT attrhash, cert, prkz FROM TCerts WHERE owner = ? AND spent = 0", ks.node.conf.getKeyStoreOwner())
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
@@ -180,6 +198,12 @@ func (ks *keyStore) loadUnusedTCerts() ([]*TCertDBBlock, error) {
 
 				continue
 			}
+			if tCertDER, err = ks.decryptBlob(tCertDER); err != nil {
+				continue
+			}
+			if prek0, err = ks.decryptBlob(prek0); err != nil {
+				continue
+			}
 
 			var tCertBlk = new(TCertDBBlock)
 			tCertBlk.attributesHash = attributeHash
@@ -192,8 +216,8 @@ func (ks *keyStore) loadUnusedTCerts() ([]*TCertDBBlock, error) {
 		}
 	}
 
-	// Delete all entries
-	if _, err = ks.sqlDB.Exec("DELETE FROM TCerts"); err != nil {
+	// Delete all entries, the spent ones are kept as a record
+	if _, err = ks.sqlDB.Exec("DELETE FROM TCerts WHERE owner = ? AND spent = 0", ks.node.conf.getKeyStoreOwner()); err != nil {
 		ks.node.Errorf("Failed cleaning up unused TCert entries: [%s].", err)
 
 		return nil, err
@@ -201,3 +225,106 @@ func (ks *keyStore) loadUnusedTCerts() ([]*TCertDBBlock, error) {
 
 	return tCertDBBlocks, nil
 }
+
+// countUnusedTCerts returns the number of unspent TCerts for attrhash
+func (ks *keyStore) countUnusedTCerts(attrhash string) (int, error) {
+	var n int
+	err := ks.sqlDB.QueryRow("SELECT COUNT(*) FROM TCerts WHERE owner = ? AND attrhash = ? AND spent = 0", ks.node.conf.getKeyStoreOwner(), attrhash).Scan(&n)
+	if err != nil {
+		ks.node.Errorf("Failed counting unused TCerts [%s].", err)
+
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// NextUnusedTCert marks as spent the oldest unspent TCert for attrhash and
+// returns it, or nil if there is none. A TCert is spent before being
+// returned, so it is never returned twice, not even after a restart or to
+// another process sharing the keystore DB.
+func (ks *keyStore) NextUnusedTCert(attrhash string) (*TCertDBBlock, error) {
+	if err := ks.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	for {
+		var id int64
+		var tCertDER, prek0 []byte
+		row := ks.sqlDB.QueryRow("SELECT id, cert, prkz FROM TCerts WHERE owner = ? AND attrhash = ? AND spent = 0 ORDER BY id LIMIT 1", ks.node.conf.getKeyStoreOwner(), attrhash)
+		err := row.Scan(&id, &tCertDER, &prek0)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			ks.node.Errorf("Error during select [%s].", err)
+
+			return nil, err
+		}
+
+		// Spend it, unless another process sharing the keystore DB did first
+		res, err := ks.sqlDB.Exec("UPDATE TCerts SET spent = 1, spentat = ? WHERE id = ? AND spent = 0", time.Now().Unix(), id)
+		if err != nil {
+			ks.node.Errorf("Failed spending TCert [%d]: [%s].", id, err)
+
+			return nil, err
+		}
+		spent, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if spent == 0 {
+			continue
+		}
+
+		if tCertDER, err = ks.decryptBlob(tCertDER); err != nil {
+			return nil, err
+		}
+		if prek0, err = ks.decryptBlob(prek0); err != nil {
+			return nil, err
+		}
+
+		return &TCertDBBlock{tCertDER: tCertDER, attributesHash: attrhash, preK0: prek0}, nil
+	}
+}
+
+// loadUsedTCert returns the index-th TCert spent by the client, in the
+// order they were spent, or nil if fewer TCerts were spent
+func (ks *keyStore) loadUsedTCert(index int) (*TCertDBBlock, error) {
+	var attrhash string
+	var tCertDER, prek0 []byte
+	row := ks.sqlDB.QueryRow("SELECT attrhash, cert, prkz FROM TCerts WHERE owner = ? AND spent = 1 ORDER BY spentat, id LIMIT 1 OFFSET ?", ks.node.conf.getKeyStoreOwner(), index)
+	err := row.Scan(&attrhash, &tCertDER, &prek0)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		ks.node.Errorf("Error during select [%s].", err)
+
+		return nil, err
+	}
+
+	if tCertDER, err = ks.decryptBlob(tCertDER); err != nil {
+		return nil, err
+	}
+	if prek0, err = ks.decryptBlob(prek0); err != nil {
+		return nil, err
+	}
+
+	return &TCertDBBlock{tCertDER: tCertDER, attributesHash: attrhash, preK0: prek0}, nil
+}
+
+func (ks *keyStore) encryptTCertBlock(tCertBlck *TCertBlock) ([]byte, []byte, error) {
+	cert, err := ks.encryptBlob(tCertBlck.tCert.GetCertificate().Raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prek0, err := ks.encryptBlob(tCertBlck.tCert.GetPreK0())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, prek0, nil
+}
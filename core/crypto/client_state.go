@@ -40,8 +40,10 @@ func (client *clientImpl) DecryptQueryResult(queryTx *obc.Transaction, ct []byte
 		queryKey = primitives.HMACAESTruncated(enrollChainKey, append([]byte{6}, queryTx.Nonce...))
 		//	client.log.Info("QUERY Decrypting with key: ", utils.EncodeBase64(queryKey))
 		break
-	case "1.2":
+	case "1.2", "1.3":
 		queryKey = primitives.HMACAESTruncated(client.queryStateKey, append([]byte{6}, queryTx.Nonce...))
+	default:
+		return nil, utils.ErrInvalidProtocolVersion
 	}
 
 	if len(ct) <= primitives.NonceSize {
@@ -19,9 +19,9 @@ package crypto
 import (
 	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/hmac"
+	"crypto/x509"
 
 	"errors"
 	"fmt"
@@ -32,9 +32,14 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
 	"golang.org/x/net/context"
 )
 
+var (
+	errTCertsRequireECDSA = errors.New("Transaction certificates require an ECDSA enrollment key. Sign with the enrollment certificate instead.")
+)
+
 func (client *clientImpl) initTCertEngine() (err error) {
 	// load TCertOwnerKDFKey
 	if err = client.loadTCertOwnerKDFKey(); err != nil {
@@ -77,7 +82,7 @@ func (client *clientImpl) loadTCertOwnerKDFKey() error {
 	// Load TCertOwnerKDFKey
 	client.Debug("Loading TCertOwnerKDFKey...")
 
-	if !client.ks.isAliasSet(client.conf.getTCertOwnerKDFKeyFilename()) {
+	if !client.ks.isKeySet(client.conf.getTCertOwnerKDFKeyFilename()) {
 		client.Debug("Failed loading TCertOwnerKDFKey. Key is missing.")
 
 		return nil
@@ -144,10 +149,11 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 	// 384-bit ExpansionValue = HMAC(Expansion_Key, TCertIndex)
 	// Let TCertIndex = Timestamp, RandValue, 1,2,…
 	// Timestamp assigned, RandValue assigned and counter reinitialized to 1 per batch
-	// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?)
+	// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?), in a copy, as ct
+	// shares its bytes with the DER of the TCert
 	TCertOwnerEncryptKey := primitives.HMACAESTruncated(client.tCertOwnerKDFKey, []byte{1})
 	ExpansionKey := primitives.HMAC(client.tCertOwnerKDFKey, []byte{2})
-	pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, tCertIndexCT)
+	pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, utils.Clone(tCertIndexCT))
 
 	if err == nil {
 		// Compute ExpansionValue based on TCertIndex
@@ -157,60 +163,11 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 		// TODO: verify that TCertIndex has right format.
 
 		client.Debugf("TCertIndex: [% x].", TCertIndex)
-		mac := hmac.New(primitives.NewHash, ExpansionKey)
-		mac.Write(TCertIndex)
-		ExpansionValue := mac.Sum(nil)
-
-		// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
-		// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
-		// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
-
-		// Compute temporary secret key
-		tempSK := &ecdsa.PrivateKey{
-			PublicKey: ecdsa.PublicKey{
-				Curve: client.enrollPrivKey.Curve,
-				X:     new(big.Int),
-				Y:     new(big.Int),
-			},
-			D: new(big.Int),
-		}
-
-		var k = new(big.Int).SetBytes(ExpansionValue)
-		var one = new(big.Int).SetInt64(1)
-		n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
-		k.Mod(k, n)
-		k.Add(k, one)
-
-		tempSK.D.Add(client.enrollPrivKey.D, k)
-		tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
-
-		// Compute temporary public key
-		tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
-		tempSK.PublicKey.X, tempSK.PublicKey.Y =
-			tempSK.PublicKey.Add(
-				client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
-				tempX, tempY,
-			)
-
-		// Verify temporary public key is a valid point on the reference curve
-		isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
-		if !isOn {
-			client.Warning("Failed temporary public key IsOnCurve check. This is an foreign certificate.")
-
-			return &tCertImpl{client, x509Cert, nil, []byte{}}, nil
-		}
-
-		// Check that the derived public key is the same as the one in the certificate
-		certPK := x509Cert.PublicKey.(*ecdsa.PublicKey)
+		ExpansionValue := tCertExpansionValue(ExpansionKey, TCertIndex)
 
-		if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
-			client.Warning("Derived public key is different on X. This is an foreign certificate.")
-
-			return &tCertImpl{client, x509Cert, nil, []byte{}}, nil
-		}
-
-		if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
-			client.Warning("Derived public key is different on Y. This is an foreign certificate.")
+		tempSK := client.deriveOwnedTCertKey(x509Cert, ExpansionValue)
+		if tempSK == nil {
+			client.Warning("Derived public key is different. This is an foreign certificate.")
 
 			return &tCertImpl{client, x509Cert, nil, []byte{}}, nil
 		}
@@ -242,6 +199,100 @@ func (client *clientImpl) getTCertFromExternalDER(der []byte) (tCert, error) {
 	return &tCertImpl{client, x509Cert, nil, []byte{}}, nil
 }
 
+// getTCertEnrollmentKeys returns the enrollment keys TCerts may derive
+// from: the enrollment key and the retired one, if any
+func (client *clientImpl) getTCertEnrollmentKeys() []*ecdsa.PrivateKey {
+	client.enrollMutex.RLock()
+	defer client.enrollMutex.RUnlock()
+
+	keys := []*ecdsa.PrivateKey{}
+	if client.enrollPrivKey != nil {
+		keys = append(keys, client.enrollPrivKey)
+	}
+	if client.retiredEnrollPrivKey != nil {
+		keys = append(keys, client.retiredEnrollPrivKey)
+	}
+
+	return keys
+}
+
+// deriveOwnedTCertKey derives the secret key of x509Cert from the
+// enrollment key or, during the overlap window of an enrollment key
+// rotation, from the retired one. It returns nil if no enrollment key
+// of the client derives the public key of the TCert.
+func (client *clientImpl) deriveOwnedTCertKey(x509Cert *x509.Certificate, expansionValue []byte) *ecdsa.PrivateKey {
+	certPK, ok := x509Cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil
+	}
+
+	for _, enrollPrivKey := range client.getTCertEnrollmentKeys() {
+		sk := deriveTCertKey(enrollPrivKey, expansionValue)
+
+		// Verify temporary public key is a valid point on the reference curve
+		if !sk.Curve.IsOnCurve(sk.PublicKey.X, sk.PublicKey.Y) {
+			continue
+		}
+
+		// Check that the derived public key is the same as the one in the certificate
+		if certPK.X.Cmp(sk.PublicKey.X) == 0 && certPK.Y.Cmp(sk.PublicKey.Y) == 0 {
+			return sk
+		}
+	}
+
+	return nil
+}
+
+// tCertExpansionValue returns the ExpansionValue of the TCert whose index is
+// tCertIndex. The secret key of a TCert is a child of the enrollment key,
+// derived by deriveTCertKey from the ExpansionKey, itself derived from the
+// TCertOwnerKDFKey, and from the index, encrypted in the TCert. Only the
+// TCerts and the TCertOwnerKDFKey are stored, never the secret keys of the
+// TCerts, which are derived again when the TCerts are loaded.
+func tCertExpansionValue(expansionKey, tCertIndex []byte) []byte {
+	mac := hmac.New(primitives.NewHash, expansionKey)
+	mac.Write(tCertIndex)
+
+	return mac.Sum(nil)
+}
+
+// deriveTCertKey derives the secret key of a TCert from the enrollment key
+// and the ExpansionValue of the TCert
+func deriveTCertKey(enrollPrivKey *ecdsa.PrivateKey, expansionValue []byte) *ecdsa.PrivateKey {
+	// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
+	// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
+	// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
+
+	// Compute temporary secret key
+	tempSK := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: enrollPrivKey.Curve,
+			X:     new(big.Int),
+			Y:     new(big.Int),
+		},
+		D: new(big.Int),
+	}
+
+	var k = new(big.Int).SetBytes(expansionValue)
+	var one = new(big.Int).SetInt64(1)
+	n := new(big.Int).Sub(enrollPrivKey.Params().N, one)
+	k.Mod(k, n)
+	k.Add(k, one)
+
+	tempSK.D.Add(enrollPrivKey.D, k)
+	tempSK.D.Mod(tempSK.D, enrollPrivKey.PublicKey.Params().N)
+
+	// Compute temporary public key
+	tempX, tempY := enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
+	tempSK.PublicKey.X, tempSK.PublicKey.Y =
+		tempSK.PublicKey.Add(
+			enrollPrivKey.PublicKey.X, enrollPrivKey.PublicKey.Y,
+			tempX, tempY,
+		)
+
+	return tempSK
+}
+
 func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCertBlock, err error) {
 	if client.tCertOwnerKDFKey == nil {
 		return nil, fmt.Errorf("KDF key not initialized yet")
@@ -280,7 +331,8 @@ func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCe
 	// Timestamp assigned, RandValue assigned and counter reinitialized to 1 per batch
 
 	// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?)
-	pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, tCertIndexCT)
+	// ct is decrypted in a copy, as it shares its bytes with the DER of the TCert
+	pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, utils.Clone(tCertIndexCT))
 	if err != nil {
 		client.Errorf("Failed decrypting extension TCERT_ENC_TCERTINDEX [%s].", err.Error())
 
@@ -292,62 +344,15 @@ func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCe
 	//		TCertIndex := []byte(strconv.Itoa(i))
 
 	client.Debugf("TCertIndex: [% x].", TCertIndex)
-	mac := hmac.New(primitives.NewHash, ExpansionKey)
-	mac.Write(TCertIndex)
-	ExpansionValue := mac.Sum(nil)
-
-	// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
-	// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
-	// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
-
-	// Compute temporary secret key
-	tempSK := &ecdsa.PrivateKey{
-		PublicKey: ecdsa.PublicKey{
-			Curve: client.enrollPrivKey.Curve,
-			X:     new(big.Int),
-			Y:     new(big.Int),
-		},
-		D: new(big.Int),
-	}
-
-	var k = new(big.Int).SetBytes(ExpansionValue)
-	var one = new(big.Int).SetInt64(1)
-	n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
-	k.Mod(k, n)
-	k.Add(k, one)
 
-	tempSK.D.Add(client.enrollPrivKey.D, k)
-	tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+	// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk.
+	// The TCerts stored before an enrollment key rotation derive from the
+	// retired key until the overlap window ends.
+	tempSK := client.deriveOwnedTCertKey(x509Cert, tCertExpansionValue(ExpansionKey, TCertIndex))
+	if tempSK == nil {
+		client.Error("Derived public key is different. No enrollment key derives the TCert.")
 
-	// Compute temporary public key
-	tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
-	tempSK.PublicKey.X, tempSK.PublicKey.Y =
-		tempSK.PublicKey.Add(
-			client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
-			tempX, tempY,
-		)
-
-	// Verify temporary public key is a valid point on the reference curve
-	isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
-	if !isOn {
-		client.Error("Failed temporary public key IsOnCurve check.")
-
-		return nil, fmt.Errorf("Failed temporary public key IsOnCurve check.")
-	}
-
-	// Check that the derived public key is the same as the one in the certificate
-	certPK := x509Cert.PublicKey.(*ecdsa.PublicKey)
-
-	if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
-		client.Error("Derived public key is different on X")
-
-		return nil, fmt.Errorf("Derived public key is different on X")
-	}
-
-	if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
-		client.Error("Derived public key is different on Y")
-
-		return nil, fmt.Errorf("Derived public key is different on Y")
+		return nil, fmt.Errorf("Derived public key is different. No enrollment key derives the TCert.")
 	}
 
 	// Verify the signing capability of tempSK
@@ -379,20 +384,32 @@ func (client *clientImpl) getTCertFromDER(certBlk *TCertDBBlock) (certBlock *TCe
 func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string, num int) error {
 	client.Debugf("Get [%d] certificates from the TCA...", num)
 
+	// TCert keys are derived from ECDSA enrollment keys only
+	client.enrollMutex.RLock()
+	ecdsaEnrolled := client.enrollAltKey == nil
+	client.enrollMutex.RUnlock()
+	if !ecdsaEnrolled {
+		client.Error("Failed getting TCerts. The enrollment key is not an ECDSA key.")
+
+		return errTCertsRequireECDSA
+	}
+
 	// Contact the TCA
 	TCertOwnerKDFKey, certDERs, err := client.callTCACreateCertificateSet(num, attributes)
 	if err != nil {
 		client.Debugf("Failed contacting TCA [%s].", err.Error())
+		client.ks.audit(AuditTCAFetch, attrhash, err.Error())
 
 		return err
 	}
+	client.ks.audit(AuditTCAFetch, attrhash, fmt.Sprintf("%d TCerts", len(certDERs)))
 
 	//	client.debug("TCertOwnerKDFKey [%s].", utils.EncodeBase64(TCertOwnerKDFKey))
 
 	// Store TCertOwnerKDFKey and checks that every time it is always the same key
 	if client.tCertOwnerKDFKey != nil {
 		// Check that the keys are the same
-		equal := bytes.Equal(client.tCertOwnerKDFKey, TCertOwnerKDFKey)
+		equal := utils.ConstantTimeEqual(client.tCertOwnerKDFKey, TCertOwnerKDFKey)
 		if !equal {
 			return errors.New("Failed reciving kdf key from TCA. The keys are different.")
 		}
@@ -412,152 +429,133 @@ func (client *clientImpl) getTCertsFromTCA(attrhash string, attributes []string,
 	TCertOwnerEncryptKey := primitives.HMACAESTruncated(client.tCertOwnerKDFKey, []byte{1})
 	ExpansionKey := primitives.HMAC(client.tCertOwnerKDFKey, []byte{2})
 
-	j := 0
-	for i := 0; i < num; i++ {
-		// DER to x509
-		x509Cert, err := primitives.DERToX509Certificate(certDERs[i].Cert)
-		prek0 := certDERs[i].Prek0
-		if err != nil {
-			client.Debugf("Failed parsing certificate [% x]: [%s].", certDERs[i].Cert, err)
-
-			continue
-		}
-
-		// Handle Critical Extenstion TCertEncTCertIndex
-		tCertIndexCT, err := primitives.GetCriticalExtension(x509Cert, primitives.TCertEncTCertIndex)
-		if err != nil {
-			client.Errorf("Failed getting extension TCERT_ENC_TCERTINDEX [% x]: [%s].", primitives.TCertEncTCertIndex, err)
+	// Derive the keys of the TCerts in parallel
+	tCertBlocks := make([]*TCertBlock, len(certDERs))
+	parallelFor(len(certDERs), func(i int) {
+		tCertBlocks[i] = client.deriveTCert(attrhash, certDERs[i], TCertOwnerEncryptKey, ExpansionKey)
+	})
 
+	j := 0
+	for i, tcertBlk := range tCertBlocks {
+		if tcertBlk == nil {
 			continue
 		}
 
-		// Verify certificate against root
-		if _, err := primitives.CheckCertAgainRoot(x509Cert, client.tcaCertPool); err != nil {
-			client.Warningf("Warning verifing certificate [%s].", err.Error())
+		client.Debugf("Sub index [%d]", j)
+		j++
+		client.Debugf("Certificate [%d] validated.", i)
 
-			continue
-		}
+		client.tCertPool.AddTCert(tcertBlk)
+	}
 
-		// Verify public key
+	if j == 0 {
+		client.Error("No valid TCert was sent")
 
-		// 384-bit ExpansionValue = HMAC(Expansion_Key, TCertIndex)
-		// Let TCertIndex = Timestamp, RandValue, 1,2,…
-		// Timestamp assigned, RandValue assigned and counter reinitialized to 1 per batch
+		return errors.New("No valid TCert was sent.")
+	}
 
-		// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?)
-		pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, tCertIndexCT)
-		if err != nil {
-			client.Errorf("Failed decrypting extension TCERT_ENC_TCERTINDEX [%s].", err.Error())
+	return nil
+}
 
-			continue
-		}
+// deriveTCert validates a TCert received from the TCA and derives its key.
+// It returns nil if the TCert is invalid. It is safe for concurrent use.
+func (client *clientImpl) deriveTCert(attrhash string, certDER *membersrvc.TCert, TCertOwnerEncryptKey, ExpansionKey []byte) *TCertBlock {
+	// DER to x509
+	x509Cert, err := primitives.DERToX509Certificate(certDER.Cert)
+	prek0 := certDER.Prek0
+	if err != nil {
+		client.Debugf("Failed parsing certificate [% x]: [%s].", certDER.Cert, err)
 
-		// Compute ExpansionValue based on TCertIndex
-		TCertIndex := pt
-		//		TCertIndex := []byte(strconv.Itoa(i))
+		return nil
+	}
 
-		client.Debugf("TCertIndex: [% x].", TCertIndex)
-		mac := hmac.New(primitives.NewHash, ExpansionKey)
-		mac.Write(TCertIndex)
-		ExpansionValue := mac.Sum(nil)
-
-		// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
-		// Computable by TCA / Auditor: TCertPub_Key = EnrollPub_Key + ExpansionValue G
-		// using elliptic curve point addition per NIST FIPS PUB 186-4- specified P-384
-
-		// Compute temporary secret key
-		tempSK := &ecdsa.PrivateKey{
-			PublicKey: ecdsa.PublicKey{
-				Curve: client.enrollPrivKey.Curve,
-				X:     new(big.Int),
-				Y:     new(big.Int),
-			},
-			D: new(big.Int),
-		}
+	// Handle Critical Extenstion TCertEncTCertIndex
+	tCertIndexCT, err := primitives.GetCriticalExtension(x509Cert, primitives.TCertEncTCertIndex)
+	if err != nil {
+		client.Errorf("Failed getting extension TCERT_ENC_TCERTINDEX [% x]: [%s].", primitives.TCertEncTCertIndex, err)
 
-		var k = new(big.Int).SetBytes(ExpansionValue)
-		var one = new(big.Int).SetInt64(1)
-		n := new(big.Int).Sub(client.enrollPrivKey.Params().N, one)
-		k.Mod(k, n)
-		k.Add(k, one)
+		return nil
+	}
 
-		tempSK.D.Add(client.enrollPrivKey.D, k)
-		tempSK.D.Mod(tempSK.D, client.enrollPrivKey.PublicKey.Params().N)
+	// Verify certificate against root
+	if _, err := primitives.CheckCertAgainRoot(x509Cert, client.tcaCertPool); err != nil {
+		client.Warningf("Warning verifing certificate [%s].", err.Error())
 
-		// Compute temporary public key
-		tempX, tempY := client.enrollPrivKey.PublicKey.ScalarBaseMult(k.Bytes())
-		tempSK.PublicKey.X, tempSK.PublicKey.Y =
-			tempSK.PublicKey.Add(
-				client.enrollPrivKey.PublicKey.X, client.enrollPrivKey.PublicKey.Y,
-				tempX, tempY,
-			)
+		return nil
+	}
 
-		// Verify temporary public key is a valid point on the reference curve
-		isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
-		if !isOn {
-			client.Error("Failed temporary public key IsOnCurve check.")
+	// Verify public key
 
-			continue
-		}
+	// 384-bit ExpansionValue = HMAC(Expansion_Key, TCertIndex)
+	// Let TCertIndex = Timestamp, RandValue, 1,2,…
+	// Timestamp assigned, RandValue assigned and counter reinitialized to 1 per batch
 
-		// Check that the derived public key is the same as the one in the certificate
-		certPK := x509Cert.PublicKey.(*ecdsa.PublicKey)
+	// Decrypt ct to TCertIndex (TODO: || EnrollPub_Key || EnrollID ?)
+	// ct is decrypted in a copy, as it shares its bytes with the DER of the TCert
+	pt, err := primitives.CBCPKCS7Decrypt(TCertOwnerEncryptKey, utils.Clone(tCertIndexCT))
+	if err != nil {
+		client.Errorf("Failed decrypting extension TCERT_ENC_TCERTINDEX [%s].", err.Error())
 
-		if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
-			client.Error("Derived public key is different on X")
+		return nil
+	}
 
-			continue
-		}
+	// Compute ExpansionValue based on TCertIndex
+	TCertIndex := pt
+	//		TCertIndex := []byte(strconv.Itoa(i))
 
-		if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
-			client.Error("Derived public key is different on Y")
+	client.Debugf("TCertIndex: [% x].", TCertIndex)
 
-			continue
-		}
+	// Derive tpk and tsk accordingly to ExpansionValue from enrollment pk,sk
+	tempSK := deriveTCertKey(client.enrollPrivKey, tCertExpansionValue(ExpansionKey, TCertIndex))
 
-		// Verify the signing capability of tempSK
-		err = primitives.VerifySignCapability(tempSK, x509Cert.PublicKey)
-		if err != nil {
-			client.Errorf("Failed verifing signing capability [%s].", err.Error())
+	// Verify temporary public key is a valid point on the reference curve
+	isOn := tempSK.Curve.IsOnCurve(tempSK.PublicKey.X, tempSK.PublicKey.Y)
+	if !isOn {
+		client.Error("Failed temporary public key IsOnCurve check.")
 
-			continue
-		}
+		return nil
+	}
 
-		// Marshall certificate and secret key to be stored in the database
-		if err != nil {
-			client.Errorf("Failed marshalling private key [%s].", err.Error())
+	// Check that the derived public key is the same as the one in the certificate
+	certPK := x509Cert.PublicKey.(*ecdsa.PublicKey)
 
-			continue
-		}
+	if certPK.X.Cmp(tempSK.PublicKey.X) != 0 {
+		client.Error("Derived public key is different on X")
 
-		if err := primitives.CheckCertPKAgainstSK(x509Cert, interface{}(tempSK)); err != nil {
-			client.Errorf("Failed checking TCA cert PK against private key [%s].", err.Error())
+		return nil
+	}
 
-			continue
-		}
+	if certPK.Y.Cmp(tempSK.PublicKey.Y) != 0 {
+		client.Error("Derived public key is different on Y")
 
-		client.Debugf("Sub index [%d]", j)
-		j++
-		client.Debugf("Certificate [%d] validated.", i)
+		return nil
+	}
 
-		prek0Cp := make([]byte, len(prek0))
-		copy(prek0Cp, prek0)
+	// Verify the signing capability of tempSK
+	err = primitives.VerifySignCapability(tempSK, x509Cert.PublicKey)
+	if err != nil {
+		client.Errorf("Failed verifing signing capability [%s].", err.Error())
 
-		tcertBlk := new(TCertBlock)
+		return nil
+	}
 
-		tcertBlk.tCert = &tCertImpl{client, x509Cert, tempSK, prek0Cp}
-		tcertBlk.attributesHash = attrhash
+	// Marshall certificate and secret key to be stored in the database
+	if err != nil {
+		client.Errorf("Failed marshalling private key [%s].", err.Error())
 
-		client.tCertPool.AddTCert(tcertBlk)
+		return nil
 	}
 
-	if j == 0 {
-		client.Error("No valid TCert was sent")
+	if err := primitives.CheckCertPKAgainstSK(x509Cert, interface{}(tempSK)); err != nil {
+		client.Errorf("Failed checking TCA cert PK against private key [%s].", err.Error())
 
-		return errors.New("No valid TCert was sent.")
+		return nil
 	}
 
-	return nil
+	prek0Cp := make([]byte, len(prek0))
+	copy(prek0Cp, prek0)
+
+	return &TCertBlock{tCert: &tCertImpl{client, x509Cert, tempSK, prek0Cp}, attributesHash: attrhash}
 }
 
 func (client *clientImpl) callTCACreateCertificateSet(num int, attributes []string) ([]byte, []*membersrvc.TCert, error) {
@@ -582,6 +580,7 @@ func (client *clientImpl) callTCACreateCertificateSet(num int, attributes []stri
 		Num:        uint32(num),
 		Attributes: attributesList,
 		Sig:        nil,
+		Validity:   uint64(client.conf.getTCertValidityPeriod() / time.Second),
 	}
 
 	rawReq, err := proto.Marshal(req)
@@ -20,6 +20,7 @@ import (
 	"crypto/x509"
 
 	"github.com/hyperledger/fabric/core/crypto/attributes"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 )
 
@@ -63,6 +64,8 @@ func (tCert *tCertImpl) Sign(msg []byte) ([]byte, error) {
 		return nil, utils.ErrNilArgument
 	}
 
+	tCert.client.logSignature("tcert/"+utils.EncodeBase64(primitives.Hash(tCert.cert.Raw)), msg)
+
 	return tCert.client.sign(tCert.sk, msg)
 }
 
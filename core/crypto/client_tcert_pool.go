@@ -27,3 +27,8 @@ type tCertPool interface {
 
 	AddTCert(tCertBlock *TCertBlock) (err error)
 }
+
+const (
+	// Bound of the number of TCerts in a batch, the one of the TCA by default
+	maxTCertBatchSize = 1000
+)
@@ -35,8 +35,8 @@ type tCertPoolEntry struct {
 
 //NewTCertPoolEntry creates a new tcert pool entry
 func newTCertPoolEntry(client *clientImpl, attributes []string) *tCertPoolEntry {
-	tCertChannel := make(chan *TCertBlock, client.conf.getTCertBatchSize()*2)
-	tCertChannelFeedback := make(chan struct{}, client.conf.getTCertBatchSize()*2)
+	tCertChannel := make(chan *TCertBlock, client.conf.getTCertPoolSize())
+	tCertChannelFeedback := make(chan struct{}, client.conf.getTCertPoolSize())
 	done := make(chan struct{}, 1)
 	return &tCertPoolEntry{attributes, tCertChannel, tCertChannelFeedback, done, client, nil}
 }
@@ -114,6 +114,16 @@ func (tCertPoolEntry *tCertPoolEntry) GetNextTCert(attributes ...string) (tCertB
 	return
 }
 
+// refillThreshold returns the number of TCerts below which the
+// entry is refilled: the watermark if set, the batch size otherwise
+func (tCertPoolEntry *tCertPoolEntry) refillThreshold() int {
+	if watermark := tCertPoolEntry.client.conf.getTCertPoolWatermark(); watermark > 0 {
+		return watermark
+	}
+
+	return tCertPoolEntry.client.conf.getTCertBatchSize()
+}
+
 func (tCertPoolEntry *tCertPoolEntry) filler() {
 	// Load unused TCerts
 	stop := false
@@ -194,7 +204,7 @@ func (tCertPoolEntry *tCertPoolEntry) filler() {
 				break
 			}
 
-			if len(tCertPoolEntry.tCertChannel) < tCertPoolEntry.client.conf.getTCertBatchSize() {
+			if len(tCertPoolEntry.tCertChannel) < tCertPoolEntry.refillThreshold() {
 				tCertPoolEntry.client.Debugf("Refill TCert Pool. Current size [%d].",
 					len(tCertPoolEntry.tCertChannel),
 				)
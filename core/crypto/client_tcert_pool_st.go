@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 )
@@ -38,59 +39,52 @@ type TCertDBBlock struct {
 	preK0          []byte
 }
 
+// The single-threaded pool keeps its TCerts in the keystore DB. TCerts
+// received from the TCA are stored unspent, and each TCert handed out is
+// first marked as spent by NextUnusedTCert, so that it is never used twice,
+// even across restarts.
+// If a watermark is configured, a refiller checks the unused TCerts of each
+// attribute set requested so far, and of the empty one, every refill
+// interval and after each TCert handed out. It fetches a batch from the TCA
+// for the sets below the watermark, so that requests seldom wait on the TCA.
 type tCertPoolSingleThreadImpl struct {
 	client *clientImpl
 
-	empty bool
+	// TCerts received from the TCA, not yet stored
+	pending []*TCertBlock
 
-	length map[string]int
-
-	tCerts map[string][]*TCertBlock
+	// Attribute sets requested so far, by hash
+	attributeSets map[string][]string
 
+	// Guards pending and attributeSets
 	m sync.Mutex
+
+	// Serializes the fetches from the TCA
+	fetchMutex sync.Mutex
+
+	// Refiller
+	refillWake chan struct{}
+	refillStop chan struct{}
+	refillDone chan struct{}
 }
 
 //Start starts the pool processing.
 func (tCertPool *tCertPoolSingleThreadImpl) Start() (err error) {
-	tCertPool.m.Lock()
-	defer tCertPool.m.Unlock()
-
 	tCertPool.client.Debug("Starting TCert Pool...")
 
-	// Load unused TCerts if any
-	tCertDBBlocks, err := tCertPool.client.ks.loadUnusedTCerts()
-	if err != nil {
-		tCertPool.client.Errorf("Failed loading TCerts from cache: [%s]", err)
-
-		return
+	if watermark := tCertPool.client.conf.getTCertPoolWatermark(); watermark > 0 && !tCertPool.client.conf.isKeyStoreReadOnly() {
+		tCertPool.startRefiller(watermark, tCertPool.client.conf.getTCertPoolRefillInterval())
 	}
 
-	if len(tCertDBBlocks) > 0 {
-
-		tCertPool.client.Debug("TCerts in cache found! Loading them...")
-
-		for _, tCertDBBlock := range tCertDBBlocks {
-			tCertBlock, err := tCertPool.client.getTCertFromDER(tCertDBBlock)
-			if err != nil {
-				tCertPool.client.Errorf("Failed paring TCert [% x]: [%s]", tCertDBBlock.tCertDER, err)
-
-				continue
-			}
-			tCertPool.AddTCert(tCertBlock)
-		}
-	} //END-IF
-
 	return
 }
 
 //Stop stops the pool.
 func (tCertPool *tCertPoolSingleThreadImpl) Stop() (err error) {
-	tCertPool.m.Lock()
-	defer tCertPool.m.Unlock()
-	for k := range tCertPool.tCerts {
-		certList := tCertPool.tCerts[k]
-		certListLen := tCertPool.length[k]
-		tCertPool.client.ks.storeUnusedTCerts(certList[:certListLen])
+	tCertPool.stopRefiller()
+
+	if err = tCertPool.storePending(); err != nil {
+		return
 	}
 
 	tCertPool.client.Debug("Store unused TCerts...done!")
@@ -136,46 +130,192 @@ func (tCertPool *tCertPoolSingleThreadImpl) GetNextTCerts(nCerts int, attributes
 }
 
 func (tCertPool *tCertPoolSingleThreadImpl) getNextTCert(attributes ...string) (tCert *TCertBlock, err error) {
+	attributesHash := calculateAttributesHash(attributes)
 
 	tCertPool.m.Lock()
-	defer tCertPool.m.Unlock()
+	if _, ok := tCertPool.attributeSets[attributesHash]; !ok {
+		tCertPool.attributeSets[attributesHash] = attributes
+	}
+	tCertPool.m.Unlock()
 
-	attributesHash := calculateAttributesHash(attributes)
+	if tCert, err = tCertPool.nextUnusedTCert(attributesHash); err != nil {
+		return
+	}
+	if tCert != nil {
+		tCertPool.wakeRefiller()
+		return
+	}
+	tCertPoolMisses.inc()
+
+	// Reload, unless the refiller just did
+	if err := tCertPool.refill(attributesHash, attributes, 1); err != nil {
+		return nil, fmt.Errorf("Failed loading TCerts from TCA")
+	}
+
+	if tCert, err = tCertPool.nextUnusedTCert(attributesHash); err != nil {
+		return
+	}
+	if tCert == nil {
+		return nil, fmt.Errorf("Failed loading TCerts from TCA")
+	}
+
+	return tCert, nil
+}
+
+// refill fetches a batch of TCerts for attributes from the TCA and stores
+// them, unless attributesHash has at least below unused TCerts
+func (tCertPool *tCertPoolSingleThreadImpl) refill(attributesHash string, attributes []string, below int) error {
+	tCertPool.fetchMutex.Lock()
+	defer tCertPool.fetchMutex.Unlock()
+
+	n, err := tCertPool.client.ks.countUnusedTCerts(attributesHash)
+	if err != nil {
+		return err
+	}
+	if n >= below {
+		return nil
+	}
+
+	if err := tCertPool.client.getTCertsFromTCA(attributesHash, attributes, tCertPool.client.conf.getTCertBatchSize()); err != nil {
+		return err
+	}
+
+	return tCertPool.storePending()
+}
+
+// startRefiller keeps the unused TCerts of every attribute set
+// requested at or above watermark, checking every interval
+func (tCertPool *tCertPoolSingleThreadImpl) startRefiller(watermark int, interval time.Duration) {
+	tCertPool.client.Debugf("Refilling TCert pool below [%d] TCerts, checking every [%s].", watermark, interval)
+
+	tCertPool.refillWake = make(chan struct{}, 1)
+	tCertPool.refillStop = make(chan struct{})
+	tCertPool.refillDone = make(chan struct{})
+	go func(wake, stop, done chan struct{}) {
+		defer close(done)
 
-	poolLen := tCertPool.length[attributesHash]
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 
-	if poolLen <= 0 {
-		// Reload
-		if err := tCertPool.client.getTCertsFromTCA(attributesHash, attributes, tCertPool.client.conf.getTCertBatchSize()); err != nil {
-			return nil, fmt.Errorf("Failed loading TCerts from TCA")
+		for {
+			tCertPool.refillBelowWatermark(watermark, stop)
+
+			select {
+			case <-ticker.C:
+			case <-wake:
+			case <-stop:
+				return
+			}
 		}
+	}(tCertPool.refillWake, tCertPool.refillStop, tCertPool.refillDone)
+}
+
+// stopRefiller stops the refiller, waiting for a refill in progress to complete
+func (tCertPool *tCertPoolSingleThreadImpl) stopRefiller() {
+	if tCertPool.refillStop == nil {
+		return
 	}
 
-	tCert = tCertPool.tCerts[attributesHash][tCertPool.length[attributesHash]-1]
+	close(tCertPool.refillStop)
+	<-tCertPool.refillDone
+	tCertPool.refillStop = nil
+	tCertPool.refillWake = nil
+}
 
-	tCertPool.length[attributesHash] = tCertPool.length[attributesHash] - 1
+// wakeRefiller has the refiller check the pool, if it is running
+func (tCertPool *tCertPoolSingleThreadImpl) wakeRefiller() {
+	if tCertPool.refillWake == nil {
+		return
+	}
 
-	return tCert, nil
+	select {
+	case tCertPool.refillWake <- struct{}{}:
+	default:
+	}
 }
 
-//AddTCert adds a TCert into the pool is invoked by the client after TCA is called.
-func (tCertPool *tCertPoolSingleThreadImpl) AddTCert(tCertBlock *TCertBlock) (err error) {
+func (tCertPool *tCertPoolSingleThreadImpl) refillBelowWatermark(watermark int, stop chan struct{}) {
+	tCertPool.m.Lock()
+	attributeSets := make(map[string][]string, len(tCertPool.attributeSets))
+	for attributesHash, attributes := range tCertPool.attributeSets {
+		attributeSets[attributesHash] = attributes
+	}
+	tCertPool.m.Unlock()
 
-	tCertPool.client.Debugf("Adding new Cert [% x].", tCertBlock.tCert.GetCertificate().Raw)
+	for attributesHash, attributes := range attributeSets {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		n, err := tCertPool.client.ks.countUnusedTCerts(attributesHash)
+		if err != nil {
+			continue
+		}
+		tCertPoolDepth.set(float64(n), tCertPool.client.conf.name, attributesHash)
+		if n >= watermark {
+			continue
+		}
+
+		tCertPool.client.Debugf("Refilling TCert pool [%s], [%d] TCerts left.", attributesHash, n)
+		if err := tCertPool.refill(attributesHash, attributes, watermark); err != nil {
+			tCertPool.client.Errorf("Failed refilling TCert pool [%s].", err)
+			continue
+		}
+		tCertPoolRefills.inc()
 
-	if tCertPool.length[tCertBlock.attributesHash] <= 0 {
-		tCertPool.length[tCertBlock.attributesHash] = 0
+		if n, err := tCertPool.client.ks.countUnusedTCerts(attributesHash); err == nil {
+			tCertPool.client.Debugf("Refilling TCert pool [%s]...done! [%d] TCerts left.", attributesHash, n)
+			tCertPoolDepth.set(float64(n), tCertPool.client.conf.name, attributesHash)
+		}
 	}
+}
 
-	tCertPool.length[tCertBlock.attributesHash] = tCertPool.length[tCertBlock.attributesHash] + 1
+// nextUnusedTCert spends and returns the next stored TCert for attributesHash,
+// or nil if there is none. TCerts failing parsing are skipped.
+func (tCertPool *tCertPoolSingleThreadImpl) nextUnusedTCert(attributesHash string) (*TCertBlock, error) {
+	for {
+		tCertDBBlock, err := tCertPool.client.ks.NextUnusedTCert(attributesHash)
+		if err != nil || tCertDBBlock == nil {
+			return nil, err
+		}
 
-	if tCertPool.tCerts[tCertBlock.attributesHash] == nil {
+		tCertBlock, err := tCertPool.client.getTCertFromDER(tCertDBBlock)
+		if err != nil {
+			tCertPool.client.Errorf("Failed paring TCert [% x]: [%s]", tCertDBBlock.tCertDER, err)
 
-		tCertPool.tCerts[tCertBlock.attributesHash] = make([]*TCertBlock, tCertPool.client.conf.getTCertBatchSize())
+			continue
+		}
 
+		return tCertBlock, nil
 	}
+}
+
+// storePending stores the TCerts received from the TCA
+func (tCertPool *tCertPoolSingleThreadImpl) storePending() error {
+	tCertPool.m.Lock()
+	defer tCertPool.m.Unlock()
+
+	if err := tCertPool.client.ks.storeUnusedTCerts(tCertPool.pending); err != nil {
+		tCertPool.client.Errorf("Failed storing TCerts [%s].", err)
 
-	tCertPool.tCerts[tCertBlock.attributesHash][tCertPool.length[tCertBlock.attributesHash]-1] = tCertBlock
+		return err
+	}
+	tCertPool.pending = nil
+
+	return nil
+}
+
+//AddTCert adds a TCert into the pool is invoked by the client after TCA is called.
+func (tCertPool *tCertPoolSingleThreadImpl) AddTCert(tCertBlock *TCertBlock) (err error) {
+
+	tCertPool.client.Debugf("Adding new Cert [% x].", tCertBlock.tCert.GetCertificate().Raw)
+
+	tCertPool.m.Lock()
+	defer tCertPool.m.Unlock()
+
+	tCertPool.pending = append(tCertPool.pending, tCertBlock)
 
 	return nil
 }
@@ -184,9 +324,8 @@ func (tCertPool *tCertPoolSingleThreadImpl) init(client *clientImpl) (err error)
 	tCertPool.client = client
 	tCertPool.client.Debug("Init TCert Pool...")
 
-	tCertPool.tCerts = make(map[string][]*TCertBlock)
-
-	tCertPool.length = make(map[string]int)
+	// Prefetch the TCerts without attributes
+	tCertPool.attributeSets = map[string][]string{calculateAttributesHash(nil): nil}
 
 	return
 }
@@ -18,6 +18,7 @@ package crypto
 
 import (
 	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/attributes"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	obc "github.com/hyperledger/fabric/protos"
@@ -42,7 +43,10 @@ func (client *clientImpl) createDeployTx(chaincodeDeploymentSpec *obc.ChaincodeD
 	}
 
 	// Copy metadata from ChaincodeSpec
-	tx.Metadata, err = getMetadata(chaincodeDeploymentSpec.GetChaincodeSpec(), tCert, attrs...)
+	tx.Metadata = chaincodeDeploymentSpec.GetChaincodeSpec().Metadata
+
+	// Disclose the keys of attrs
+	tx.AttributesKeys, err = getAttributesKeys(tCert, attrs...)
 	if err != nil {
 		client.Errorf("Failed creating new transaction [%s].", err.Error())
 		return nil, err
@@ -68,7 +72,7 @@ func (client *clientImpl) createDeployTx(chaincodeDeploymentSpec *obc.ChaincodeD
 		tx.ConfidentialityProtocolVersion = client.conf.GetConfidentialityProtocolVersion()
 
 		// 3. encrypt tx
-		err = client.encryptTx(tx)
+		err = client.encryptTx(tx, nil)
 		if err != nil {
 			client.Errorf("Failed encrypting payload [%s].", err.Error())
 			return nil, err
@@ -79,24 +83,18 @@ func (client *clientImpl) createDeployTx(chaincodeDeploymentSpec *obc.ChaincodeD
 	return tx, nil
 }
 
-func getMetadata(chaincodeSpec *obc.ChaincodeSpec, tCert tCert, attrs ...string) ([]byte, error) {
-	//TODO this code is being commented due temporarily is not enabled attributes encryption.
-	/*
-		isAttributesEnabled := viper.GetBool("security.attributes.enabled")
-		if !isAttributesEnabled {
-			return chaincodeSpec.Metadata, nil
-		}
-
-		if tCert == nil {
-			return nil, errors.New("Invalid TCert.")
-		}
+// getAttributesKeys returns the keys of the attributes attrs of tCert,
+// disclosed along with the transaction to let the chaincode read them.
+// It returns nil if no attribute is disclosed.
+func getAttributesKeys(tCert tCert, attrs ...string) ([]byte, error) {
+	if tCert == nil || tCert.GetPreK0() == nil || len(attrs) == 0 {
+		return nil, nil
+	}
 
-		return attributes.CreateAttributesMetadata(tCert.GetCertificate().Raw, chaincodeSpec.Metadata, tCert.GetPreK0(), attrs)
-	*/
-	return chaincodeSpec.Metadata, nil
+	return attributes.CreateAttributesMetadataFromCert(tCert.GetCertificate(), nil, tCert.GetPreK0(), attrs)
 }
 
-func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, nonce []byte, tCert tCert, attrs ...string) (*obc.Transaction, error) {
+func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, nonce []byte, policy *AccessPolicy, tCert tCert, attrs ...string) (*obc.Transaction, error) {
 	/// Create a new transaction
 	tx, err := obc.NewChaincodeExecute(chaincodeInvocation, uuid, obc.Transaction_CHAINCODE_INVOKE)
 	if err != nil {
@@ -105,7 +103,10 @@ func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvo
 	}
 
 	// Copy metadata from ChaincodeSpec
-	tx.Metadata, err = getMetadata(chaincodeInvocation.GetChaincodeSpec(), tCert, attrs...)
+	tx.Metadata = chaincodeInvocation.GetChaincodeSpec().Metadata
+
+	// Disclose the keys of attrs
+	tx.AttributesKeys, err = getAttributesKeys(tCert, attrs...)
 	if err != nil {
 		client.Errorf("Failed creating new transaction [%s].", err.Error())
 		return nil, err
@@ -130,7 +131,7 @@ func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvo
 		tx.ConfidentialityProtocolVersion = client.conf.GetConfidentialityProtocolVersion()
 
 		// 3. encrypt tx
-		err = client.encryptTx(tx)
+		err = client.encryptTx(tx, policy)
 		if err != nil {
 			client.Errorf("Failed encrypting payload [%s].", err.Error())
 			return nil, err
@@ -150,7 +151,10 @@ func (client *clientImpl) createQueryTx(chaincodeInvocation *obc.ChaincodeInvoca
 	}
 
 	// Copy metadata from ChaincodeSpec
-	tx.Metadata, err = getMetadata(chaincodeInvocation.GetChaincodeSpec(), tCert, attrs...)
+	tx.Metadata = chaincodeInvocation.GetChaincodeSpec().Metadata
+
+	// Disclose the keys of attrs
+	tx.AttributesKeys, err = getAttributesKeys(tCert, attrs...)
 	if err != nil {
 		client.Errorf("Failed creating new transaction [%s].", err.Error())
 		return nil, err
@@ -175,7 +179,7 @@ func (client *clientImpl) createQueryTx(chaincodeInvocation *obc.ChaincodeInvoca
 		tx.ConfidentialityProtocolVersion = client.conf.GetConfidentialityProtocolVersion()
 
 		// 3. encrypt tx
-		err = client.encryptTx(tx)
+		err = client.encryptTx(tx, nil)
 		if err != nil {
 			client.Errorf("Failed encrypting payload [%s].", err.Error())
 			return nil, err
@@ -223,9 +227,9 @@ func (client *clientImpl) newChaincodeDeployUsingTCert(chaincodeDeploymentSpec *
 	return tx, nil
 }
 
-func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributeKeys []string, tCert tCert, nonce []byte) (*obc.Transaction, error) {
+func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributeKeys []string, tCert tCert, nonce []byte, policy *AccessPolicy) (*obc.Transaction, error) {
 	/// Create a new transaction
-	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nonce, tCert, attributeKeys...)
+	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nonce, policy, tCert, attributeKeys...)
 	if err != nil {
 		client.Errorf("Failed creating new execute transaction [%s].", err.Error())
 		return nil, err
@@ -336,7 +340,7 @@ func (client *clientImpl) newChaincodeDeployUsingECert(chaincodeDeploymentSpec *
 
 func (client *clientImpl) newChaincodeExecuteUsingECert(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, nonce []byte) (*obc.Transaction, error) {
 	/// Create a new transaction
-	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nonce, nil)
+	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nonce, nil, nil)
 	if err != nil {
 		client.Errorf("Failed creating new execute transaction [%s].", err.Error())
 		return nil, err
@@ -420,6 +424,17 @@ func (client *clientImpl) checkTransaction(tx *obc.Transaction) error {
 		return utils.ErrTransactionMissingCert
 	}
 
+	if tx.Cert != nil && tx.Signature != nil && isIdemixCert(tx.Cert) {
+		// Verify against the issuer of the credential of the client
+		_, issuer, err := client.getAnonymousCredential()
+		if err != nil {
+			return err
+		}
+		_, err = client.verifyIdemixTransaction(tx, issuer)
+
+		return err
+	}
+
 	if tx.Cert != nil && tx.Signature != nil {
 		// Verify the transaction
 		// 1. Unmarshal cert
@@ -17,7 +17,11 @@ limitations under the License.
 package crypto
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+
 	obc "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
 )
 
 // Public Interfaces
@@ -54,12 +58,25 @@ type Client interface {
 	// NewChaincodeExecute is used to execute chaincode's functions.
 	NewChaincodeExecute(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributes ...string) (*obc.Transaction, error)
 
+	// NewChaincodeExecuteWithPolicy is used to execute chaincode's functions
+	// under confidentiality protocol 1.3, with a payload only the validators
+	// and the identities and roles of policy can read.
+	NewChaincodeExecuteWithPolicy(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, policy *AccessPolicy, attributes ...string) (*obc.Transaction, error)
+
 	// NewChaincodeQuery is used to query chaincode's functions.
 	NewChaincodeQuery(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributes ...string) (*obc.Transaction, error)
 
 	// DecryptQueryResult is used to decrypt the result of a query transaction
 	DecryptQueryResult(queryTx *obc.Transaction, result []byte) ([]byte, error)
 
+	// NewPayloadAccessRequest returns a request, to be handed to a validator,
+	// to read the payload of tx restricted by an access policy
+	NewPayloadAccessRequest(tx *obc.Transaction) ([]byte, error)
+
+	// DecryptPayload decrypts the payload of tx with the grant returned
+	// by a validator in response to the last access request for tx
+	DecryptPayload(tx *obc.Transaction, grant []byte) ([]byte, error)
+
 	// GetEnrollmentCertHandler returns a CertificateHandler whose certificate is the enrollment certificate
 	GetEnrollmentCertificateHandler() (CertificateHandler, error)
 
@@ -71,6 +88,36 @@ type Client interface {
 
 	// GetNextTCert returns a slice of a requested number of (not yet used) transaction certificates
 	GetNextTCerts(nCerts int, attributes ...string) ([]tCert, error)
+
+	// GetTCert returns a CertificateHandler whose certificate is the index-th
+	// TCert used by this client, 0 being the first one. It allows to sign and
+	// to create transactions again under the same TCert.
+	GetTCert(index int) (CertificateHandler, error)
+
+	// SignWithTCert signs msg with the signing key of the TCert tCertDER,
+	// which must have been issued to this client
+	SignWithTCert(tCertDER, msg []byte) ([]byte, error)
+
+	// GetChaincodeKey returns the key of a chaincode deployed by this client under
+	// confidentiality protocol 1.3, to be handed to the clients authorized to invoke it
+	GetChaincodeKey(chaincodeID *obc.ChaincodeID) ([]byte, error)
+
+	// SetChaincodeKey stores the key of a chaincode received from its deployer
+	SetChaincodeKey(chaincodeID *obc.ChaincodeID, chaincodeKey []byte) error
+
+	// RotateEnrollmentKey replaces the enrollment key and certificate of this client
+	// with new ones, for the same identity, obtained from the ECA. The old key is
+	// kept for the TCerts derived from it until the overlap window is over.
+	RotateEnrollmentKey() error
+
+	// NewAnonymousCredentialRequest returns a request for an anonymous credential to
+	// the issuer of public key issuerPublicKey, bound to the nonce of the issuer
+	NewAnonymousCredentialRequest(issuerPublicKey, nonce []byte) ([]byte, error)
+
+	// SetAnonymousCredential stores the anonymous credential issued in response to the
+	// last request. With security.idemix.enabled, the transactions of this client are
+	// then signed with the credential instead of TCerts.
+	SetAnonymousCredential(issuedCredential []byte) error
 }
 
 // Peer is an entity able to verify transactions
@@ -88,6 +135,17 @@ type Peer interface {
 	// prescriptions (i.e. signature verification).
 	TransactionPreValidation(tx *obc.Transaction) (*obc.Transaction, error)
 
+	// TransactionsPreValidation runs TransactionPreValidation on the
+	// transactions of txs as a batch. The i-th returned transaction
+	// and error are the outcome for txs[i].
+	TransactionsPreValidation(txs []*obc.Transaction) ([]*obc.Transaction, []error)
+
+	// VerifyTransactionsBatch verifies the certificates and signatures of
+	// txs, the transactions of a block, as a batch: each distinct
+	// certificate is verified once, and the signatures concurrently.
+	// The i-th error is the outcome for txs[i].
+	VerifyTransactionsBatch(txs []*obc.Transaction) []error
+
 	// TransactionPreExecution verifies that the transaction is
 	// well formed with the respect to the security layer
 	// prescriptions (i.e. signature verification). If this is the case,
@@ -104,14 +162,79 @@ type Peer interface {
 	// If vkID is nil, then the signature is verified against this validator's verification key.
 	Verify(vkID, signature, message []byte) error
 
+	// GetTLSCertificate returns the TLS certificate of this peer, and its
+	// key. Set as tls.Config.GetCertificate, it has the listeners of the
+	// peer serve the certificate renewed with the TLSCA from then on.
+	GetTLSCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
 	// GetStateEncryptor returns a StateEncryptor linked to pair defined by
 	// the deploy transaction and the execute transaction. Notice that,
 	// executeTx can also correspond to a deploy transaction.
 	GetStateEncryptor(deployTx, executeTx *obc.Transaction) (StateEncryptor, error)
 
 	GetTransactionBinding(tx *obc.Transaction) ([]byte, error)
+
+	// GrantPayloadAccess returns, if the access policy of tx permits the
+	// identity of request, the keys of the payload of tx encrypted to the
+	// requester. Only validators resolve access policies.
+	GrantPayloadAccess(tx *obc.Transaction, request []byte) ([]byte, error)
+
+	// DeleteEnrollmentCert drops the cached enrollment certificate of
+	// the peer identified by id. The next verification against id fetches
+	// the certificate again from the ECA.
+	DeleteEnrollmentCert(id []byte) error
+
+	// PurgeEnrollmentCerts drops the cached enrollment certificates
+	// selected by filter, and returns how many were dropped.
+	PurgeEnrollmentCerts(filter CertFilter) (int, error)
+
+	// GetEnrollmentCertificateChain returns the enrollment certificate of
+	// this peer followed by the certificates of the ECA, DER encoded, as
+	// served to the validators lacking it.
+	GetEnrollmentCertificateChain() ([][]byte, error)
+
+	// SetEnrollmentCertSource has the enrollment certificates missing from
+	// the cert store requested from the peers themselves through source,
+	// before the ECA, when security.ecertExchange.enabled is set.
+	SetEnrollmentCertSource(source EnrollmentCertSource)
+
+	// ListCertificates describes, ordered by expiry, the cached
+	// certificates selected by filter. A nil filter selects them all.
+	ListCertificates(filter *CertInfoFilter) ([]CertInfo, error)
+
+	// ProcessCRL applies the certificate revocation list crl, issued
+	// by the ECA or the TCA. The cached certificates it revokes are
+	// dropped, and verification against them fails from now on.
+	// ProcessCRL returns the number of cached certificates dropped.
+	ProcessCRL(crl []byte) (int, error)
+
+	// ProcessOCSPResponse applies the OCSP response resp, signed by the
+	// ECA or a responder it delegated OCSP signing to, such as a response
+	// stapled by a counterparty. Enrollment certificates reported revoked
+	// are handled as if listed in a CRL. ProcessOCSPResponse returns the
+	// number of certificate statuses applied.
+	ProcessOCSPResponse(resp []byte) (int, error)
 }
 
+// AccessPolicy lists the identities, by enrollment ID, the roles and
+// the affiliation groups permitted to read the payload of a confidential
+// transaction besides the validators. An affiliation group permits the
+// members of its whole subtree.
+type AccessPolicy struct {
+	Identities   []string
+	Roles        []NodeType
+	Affiliations []string
+}
+
+// EnrollmentCertSource requests from the peer identified by id its
+// enrollment certificate chain, as returned by GetEnrollmentCertificateChain.
+// The request is abandoned when ctx is done.
+type EnrollmentCertSource func(ctx context.Context, id []byte) ([][]byte, error)
+
+// CertFilter selects cached enrollment certificates. It returns true
+// if the certificate cert of the peer identified by id must be selected.
+type CertFilter func(id []byte, cert *x509.Certificate) bool
+
 // StateEncryptor is used to encrypt chaincode's state
 type StateEncryptor interface {
 
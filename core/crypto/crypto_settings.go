@@ -17,6 +17,8 @@ limitations under the License.
 package crypto
 
 import (
+	"fmt"
+
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -68,5 +70,29 @@ func Init() (err error) {
 		return
 	}
 
+	// Init the source of randomness
+
+	if viper.GetBool("security.drbg.enabled") {
+		reseedInterval := primitives.DefaultDRBGReseedInterval
+		if viper.IsSet("security.drbg.reseedInterval") {
+			ovveride := viper.GetInt("security.drbg.reseedInterval")
+			if ovveride < 0 {
+				return fmt.Errorf("Invalid DRBG reseed interval [%d]. It must be positive.", ovveride)
+			}
+			if ovveride != 0 {
+				reseedInterval = ovveride
+			}
+		}
+
+		var drbg *primitives.HMACDRBG
+		if drbg, err = primitives.NewHMACDRBG(nil, []byte("fabric"), reseedInterval); err != nil {
+			log.Errorf("Failed instantiating DRBG: [%s]", err)
+
+			return
+		}
+		primitives.SetRandomSource(drbg)
+		log.Debugf("Drawing randomness from an HMAC_DRBG reseeded every [%d] requests", reseedInterval)
+	}
+
 	return
 }
@@ -21,26 +21,43 @@ import (
 	"github.com/op/go-logging"
 
 	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
 
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/hyperledger/fabric/core/chaincode/shim/crypto/attr"
 	"github.com/hyperledger/fabric/core/crypto/attributes"
+	"github.com/hyperledger/fabric/core/crypto/idemix"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/membersrvc/ca"
 	"github.com/spf13/viper"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -391,6 +408,8 @@ func TestClientMultiExecuteTransaction(t *testing.T) {
 }
 
 func TestClientGetNextTCerts(t *testing.T) {
+	initNodes()
+	defer closeNodes()
 
 	// Some positive flow tests here
 	var nCerts int = 1
@@ -463,6 +482,196 @@ func TestClientGetAttributesFromTCert(t *testing.T) {
 	}
 }
 
+//TestClientAttributesKeys verifies that the keys of the attributes requested along with a transaction let validators read them.
+func TestClientAttributesKeys(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	_, tx, err := createConfidentialExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating transaction [%s].", err)
+	}
+	if len(tx.AttributesKeys) == 0 {
+		t.Fatal("Transaction should disclose the keys of the requested attributes.")
+	}
+
+	res, err := validator.TransactionPreExecution(tx)
+	if err != nil {
+		t.Fatalf("Failed pre-executing transaction [%s].", err)
+	}
+
+	handler, err := attr.NewAttributesHandlerForTx(res)
+	if err != nil {
+		t.Fatalf("Failed creating attributes handler [%s].", err)
+	}
+	isOk, err := handler.VerifyAttribute("company", []byte("ACompany"))
+	if err != nil || !isOk {
+		t.Fatalf("Attribute company should be verified [%v].", err)
+	}
+}
+
+func TestClientTCertsUsedOnce(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	client := deployer.(*clientImpl)
+	if _, ok := client.tCertPool.(*tCertPoolSingleThreadImpl); !ok {
+		t.Skip("TCerts are tracked by the single-threaded pool only.")
+	}
+
+	handedOut := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		tCerts, err := deployer.GetNextTCerts(2, attrs...)
+		if err != nil {
+			t.Fatalf("Failed getting TCerts [%s].", err)
+		}
+		for _, tCert := range tCerts {
+			raw := string(tCert.GetCertificate().Raw)
+			if handedOut[raw] {
+				t.Fatal("A TCert was handed out twice.")
+			}
+			handedOut[raw] = true
+		}
+
+		// Restart the pool, as a restart of the process would
+		if err := client.tCertPool.Stop(); err != nil {
+			t.Fatalf("Failed stopping TCert pool [%s].", err)
+		}
+		if err := client.tCertPool.Start(); err != nil {
+			t.Fatalf("Failed starting TCert pool [%s].", err)
+		}
+	}
+
+	var spent int
+	err := client.ks.sqlDB.QueryRow("SELECT COUNT(*) FROM TCerts WHERE owner = ? AND attrhash = ? AND spent = 1",
+		client.conf.getKeyStoreOwner(), calculateAttributesHash(attrs)).Scan(&spent)
+	if err != nil {
+		t.Fatalf("Failed counting spent TCerts [%s].", err)
+	}
+	if spent < len(handedOut) {
+		t.Fatalf("Every TCert handed out should be spent, [%d] spent out of [%d].", spent, len(handedOut))
+	}
+}
+
+func TestClientSignatureSchemes(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	defer viper.Set("security.signatureScheme", "ecdsa")
+
+	for _, scheme := range []struct{ name, user string }{
+		{"ed25519", "usered25519"},
+		{"rsa", "userrsa"},
+	} {
+		t.Logf("TestClientSignatureSchemes with [%s]\n", scheme.name)
+
+		viper.Set("security.signatureScheme", scheme.name)
+
+		conf := utils.NodeConfiguration{Type: "client", Name: scheme.user}
+		if err := RegisterClient(conf.Name, ksPwd, conf.GetEnrollmentID(), conf.GetEnrollmentPWD()); err != nil {
+			t.Fatalf("Failed registering client [%s].", err)
+		}
+		client, err := InitClient(conf.Name, ksPwd)
+		if err != nil {
+			t.Fatalf("Failed initializing client [%s].", err)
+		}
+
+		impl := client.(*clientImpl)
+		if impl.enrollAltKey == nil || impl.enrollPrivKey != nil {
+			t.Fatalf("The enrollment key must be a [%s] key.", scheme.name)
+		}
+		if recorded := getCertSignatureScheme(impl.enrollCert); recorded != scheme.name {
+			t.Fatalf("The enrollment certificate must record the [%s] scheme rather than [%s].", scheme.name, recorded)
+		}
+
+		// TCerts are not available
+		if _, err := client.GetTCertificateHandlerNext(); err == nil {
+			t.Fatalf("Getting a TCert should fail.")
+		}
+
+		// Transactions signed with the enrollment certificate verify
+		cis := &obc.ChaincodeInvocationSpec{
+			ChaincodeSpec: &obc.ChaincodeSpec{
+				Type:                 obc.ChaincodeSpec_GOLANG,
+				ChaincodeID:          &obc.ChaincodeID{Path: "Contract001"},
+				ConfidentialityLevel: obc.ConfidentialityLevel_PUBLIC,
+			},
+		}
+		handler, err := client.GetEnrollmentCertificateHandler()
+		if err != nil {
+			t.Fatalf("Failed getting handler [%s].", err)
+		}
+		txHandler, err := handler.GetTransactionHandler()
+		if err != nil {
+			t.Fatalf("Failed getting transaction handler [%s].", err)
+		}
+		tx, err := txHandler.NewChaincodeExecute(cis, util.GenerateUUID())
+		if err != nil {
+			t.Fatalf("Failed creating transaction [%s].", err)
+		}
+		if _, err := validator.TransactionPreValidation(tx); err != nil {
+			t.Fatalf("Error must be nil [%s].", err)
+		}
+
+		tx.Signature[0]++
+		if _, err := validator.TransactionPreValidation(tx); err == nil {
+			t.Fatalf("Pre-validation of a tampered transaction must fail.")
+		}
+
+		if err := CloseClient(client); err != nil {
+			t.Fatalf("Failed closing client [%s].", err)
+		}
+	}
+}
+
+func TestClientTCertPoolRefill(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	client := deployer.(*clientImpl)
+	pool, ok := client.tCertPool.(*tCertPoolSingleThreadImpl)
+	if !ok {
+		t.Skip("The refiller runs in the single-threaded pool only.")
+	}
+
+	// Request a TCert, so that the refiller watches its attribute set
+	if _, err := deployer.GetNextTCerts(1, attrs...); err != nil {
+		t.Fatalf("Failed getting TCerts [%s].", err)
+	}
+	attributesHash := calculateAttributesHash(attrs)
+	before, err := client.ks.countUnusedTCerts(attributesHash)
+	if err != nil {
+		t.Fatalf("Failed counting unused TCerts [%s].", err)
+	}
+
+	refills := tCertPoolRefills.get()
+	pool.startRefiller(before+1, time.Hour)
+	defer pool.stopRefiller()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for tCertPoolRefills.get() == refills {
+		if time.Now().After(deadline) {
+			t.Fatal("The pool should be refilled below its watermark.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pool.stopRefiller()
+
+	after, err := client.ks.countUnusedTCerts(attributesHash)
+	if err != nil {
+		t.Fatalf("Failed counting unused TCerts [%s].", err)
+	}
+	if after < before+client.conf.getTCertBatchSize() {
+		t.Fatalf("A batch of TCerts should be stored, [%d] before, [%d] after.", before, after)
+	}
+
+	var buf bytes.Buffer
+	tCertPoolDepth.write(&buf)
+	if !strings.Contains(buf.String(), attributesHash) {
+		t.Fatalf("The pool depth should be exported [%s].", buf.String())
+	}
+}
+
 func TestClientGetAttributesFromTCertWithUnusedTCerts(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -472,6 +681,9 @@ func TestClientGetAttributesFromTCertWithUnusedTCerts(t *testing.T) {
 	after()  //Tear down the server.
 	before() //Start up again to use unsed TCerts
 
+	// The TCerts of the client are in its keystore, opened again with it
+	initNodes()
+
 	tcerts, err := deployer.GetNextTCerts(1, attrs...)
 
 	if err != nil {
@@ -557,6 +769,69 @@ func TestClientGetTCertHandlerFromDER(t *testing.T) {
 	}
 }
 
+func TestClientTCertFromRetiredEnrollmentKey(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	client := deployer.(*clientImpl)
+	handler, err := client.GetTCertificateHandlerNext(attrs...)
+	if err != nil {
+		t.Fatalf("Failed getting handler: [%s]", err)
+	}
+
+	// Rotate the enrollment key, keeping the old one retired
+	newKey, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	client.enrollMutex.Lock()
+	oldKey := client.enrollPrivKey
+	client.enrollPrivKey, client.retiredEnrollPrivKey = newKey, oldKey
+	client.enrollMutex.Unlock()
+	defer func() {
+		client.enrollMutex.Lock()
+		client.enrollPrivKey, client.retiredEnrollPrivKey = oldKey, nil
+		client.enrollMutex.Unlock()
+	}()
+
+	tCert, err := client.getTCertFromExternalDER(handler.GetCertificate())
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	msg := []byte("Hello World!!!")
+	signature, err := tCert.Sign(msg)
+	if err != nil {
+		t.Fatalf("TCert should derive from the retired enrollment key [%s].", err)
+	}
+	if err := tCert.Verify(signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+
+	// As do the TCerts loaded from the keystore
+	certBlock, err := client.getTCertFromDER(&TCertDBBlock{tCertDER: handler.GetCertificate()})
+	if err != nil {
+		t.Fatalf("Stored TCert should derive from the retired enrollment key [%s].", err)
+	}
+	if _, err := certBlock.tCert.Sign(msg); err != nil {
+		t.Fatalf("Failed signing with stored TCert [%s].", err)
+	}
+
+	// Once retired, the TCert is foreign
+	client.enrollMutex.Lock()
+	client.retiredEnrollPrivKey = nil
+	client.enrollMutex.Unlock()
+	tCert, err = client.getTCertFromExternalDER(handler.GetCertificate())
+	if err != nil {
+		t.Fatalf("Failed getting tcert: [%s]", err)
+	}
+	if _, err := tCert.Sign(msg); err == nil {
+		t.Fatal("TCert of a retired enrollment key should not sign.")
+	}
+	if _, err := client.getTCertFromDER(&TCertDBBlock{tCertDER: handler.GetCertificate()}); err == nil {
+		t.Fatal("Stored TCert of a retired enrollment key should not load.")
+	}
+}
+
 func TestClientTCertHandlerSign(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -621,6 +896,50 @@ func TestClientTCertHandlerSign(t *testing.T) {
 	}
 }
 
+func TestClientSignWithTCert(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	handler, err := deployer.GetTCertificateHandlerNext(attrs...)
+	if err != nil {
+		t.Fatalf("Failed getting handler: [%s]", err)
+	}
+
+	// The last TCert used is the one of handler
+	var last CertificateHandler
+	for index := 0; ; index++ {
+		h, err := deployer.GetTCert(index)
+		if err == utils.ErrUnknownTCert {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed getting TCert [%d]: [%s]", index, err)
+		}
+		last = h
+	}
+	if last == nil || !reflect.DeepEqual(last.GetCertificate(), handler.GetCertificate()) {
+		t.Fatal("The last TCert used should be the one of the handler.")
+	}
+	if _, err := deployer.GetTCert(-1); err == nil {
+		t.Fatal("Negative indexes should be rejected.")
+	}
+
+	// Sign again under the same TCert
+	msg := []byte("Hello World!!!")
+	signature, err := deployer.SignWithTCert(last.GetCertificate(), msg)
+	if err != nil {
+		t.Fatalf("Failed signing with TCert: [%s]", err)
+	}
+	if err := handler.Verify(signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature: [%s]", err)
+	}
+
+	// Invoker does not own the TCert of deployer
+	if _, err := invoker.SignWithTCert(handler.GetCertificate(), msg); err != utils.ErrForeignTCert {
+		t.Fatalf("Invoker should not be able to sign with the TCert of deployer [%v].", err)
+	}
+}
+
 func TestClientGetEnrollmentCertHandler(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -1078,6 +1397,86 @@ func TestValidatorExecuteTransaction(t *testing.T) {
 	}
 }
 
+func TestValidatorTransactionsPreValidation(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	txs := []*obc.Transaction{}
+	for _, createTx := range append(deployTxCreators, executeTxCreators...) {
+		_, tx, err := createTx(t)
+		if err != nil {
+			t.Fatalf("Failed creating transaction [%s].", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	// Tamper with the signature of one transaction
+	invalid := len(txs) / 2
+	txs[invalid].Signature[0]++
+
+	res, errs := validator.TransactionsPreValidation(txs)
+	if len(res) != len(txs) || len(errs) != len(txs) {
+		t.Fatalf("There must be an outcome for each transaction.")
+	}
+	for i := range txs {
+		if i == invalid {
+			if errs[i] == nil {
+				t.Fatalf("Pre-validation of transaction [%d] must fail.", i)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Fatalf("Error must be nil for transaction [%d] [%s].", i, errs[i])
+		}
+		if res[i] != txs[i] {
+			t.Fatalf("Result must be transaction [%d].", i)
+		}
+	}
+}
+
+func TestValidatorVerifyTransactionsBatch(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	txs := []*obc.Transaction{}
+	for _, createTx := range executeTxCreators {
+		_, tx, err := createTx(t)
+		if err != nil {
+			t.Fatalf("Failed creating transaction [%s].", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	// The same transaction twice shares its certificate
+	txs = append(txs, txs[0])
+
+	// A transaction without certificate
+	noCert := *txs[0]
+	noCert.Cert = nil
+	txs = append(txs, &noCert)
+
+	// A transaction signed by another certificate
+	tampered := *txs[0]
+	tampered.Signature = txs[1].Signature
+	txs = append(txs, &tampered)
+
+	errs := validator.VerifyTransactionsBatch(txs)
+	if len(errs) != len(txs) {
+		t.Fatalf("There must be an outcome for each transaction.")
+	}
+	for i := 0; i < len(txs)-2; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Error must be nil for transaction [%d] [%s].", i, errs[i])
+		}
+	}
+	if errs[len(txs)-2] != utils.ErrTransactionCertificate {
+		t.Fatalf("Transaction without certificate must be rejected [%v].", errs[len(txs)-2])
+	}
+	if errs[len(txs)-1] != utils.ErrInvalidTransactionSignature {
+		t.Fatalf("Transaction with a wrong signature must be rejected [%v].", errs[len(txs)-1])
+	}
+}
+
 func TestValidatorQueryTransaction(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -1297,90 +1696,2814 @@ func TestValidatorStateEncryptor(t *testing.T) {
 
 }
 
-func TestValidatorSignVerify(t *testing.T) {
+func TestValidatorAnonymousCredential(t *testing.T) {
 	initNodes()
 	defer closeNodes()
 
-	msg := []byte("Hello World!!!")
-	signature, err := validator.Sign(msg)
+	issuerKey, err := idemix.NewIssuerKey([]string{"company", "position"}, idemix.MinModulusSize)
 	if err != nil {
-		t.Fatalf("TestSign: failed generating signature [%s].", err)
+		t.Fatalf("Failed generating issuer key [%s].", err)
+	}
+	issuerPublicKey, err := issuerKey.Public().Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling issuer public key [%s].", err)
 	}
 
-	err = validator.Verify(validator.GetID(), signature, msg)
+	// Request and get a credential
+	client := invoker.(*clientImpl)
+	nonce := []byte("issuer nonce")
+	rawRequest, err := client.NewAnonymousCredentialRequest(issuerPublicKey, nonce)
 	if err != nil {
-		t.Fatalf("TestSign: failed validating signature [%s].", err)
+		t.Fatalf("Failed requesting credential [%s].", err)
+	}
+	request, err := idemix.ParseCredentialRequest(rawRequest)
+	if err != nil {
+		t.Fatalf("Failed parsing credential request [%s].", err)
+	}
+	if err := request.Verify(issuerKey.Public(), nonce); err != nil {
+		t.Fatalf("Failed verifying credential request [%s].", err)
+	}
+	issued, err := issuerKey.Issue(request, [][]byte{[]byte("ACompany"), []byte("Software Engineer")})
+	if err != nil {
+		t.Fatalf("Failed issuing credential [%s].", err)
+	}
+	rawIssued, err := issued.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling credential [%s].", err)
+	}
+	if err := client.SetAnonymousCredential(rawIssued); err != nil {
+		t.Fatalf("Failed setting credential [%s].", err)
 	}
-}
 
-func TestValidatorVerify(t *testing.T) {
-	initNodes()
-	defer closeNodes()
+	client.conf.idemixEnabled = true
+	defer func() {
+		client.conf.idemixEnabled = false
+		client.idemixCredential, client.idemixIssuer = nil, nil
+		client.ks.PutBlobs(idemixNamespace, map[string][]byte{"credential": nil, "issuerPublicKey": nil})
+	}()
 
-	msg := []byte("Hello World!!!")
-	signature, err := validator.Sign(msg)
+	_, tx1, err := createPublicExecuteTransaction(t)
 	if err != nil {
-		t.Fatalf("Failed generating signature [%s].", err)
+		t.Fatalf("Failed creating transaction [%s].", err)
 	}
-
-	err = validator.Verify(nil, signature, msg)
-	if err == nil {
-		t.Fatal("Verify should fail when given an empty id.", err)
+	_, tx2, err := createPublicExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating transaction [%s].", err)
+	}
+	if !isIdemixCert(tx1.Cert) {
+		t.Fatal("Transaction should be signed with the credential.")
+	}
+	if err := client.checkTransaction(tx1); err != nil {
+		t.Fatalf("Failed checking transaction [%s].", err)
 	}
 
-	err = validator.Verify(msg, signature, msg)
-	if err == nil {
-		t.Fatal("Verify should fail when given an invalid id.", err)
+	// Validators not trusting the issuer reject the transactions
+	if _, err := validator.TransactionPreValidation(tx1); err != utils.ErrUnknownIssuer {
+		t.Fatalf("Transaction of an unknown issuer should be rejected [%v].", err)
 	}
 
-	err = validator.Verify(validator.GetID(), nil, msg)
-	if err == nil {
-		t.Fatal("Verify should fail when given an invalid signature.", err)
+	peer := validator.(*validatorImpl).peerImpl
+	peer.idemixIssuer = issuerKey.Public()
+	defer func() { peer.idemixIssuer = nil }()
+
+	for _, tx := range []*obc.Transaction{tx1, tx2} {
+		if _, err := validator.TransactionPreValidation(tx); err != nil {
+			t.Fatalf("Failed pre-validating transaction [%s].", err)
+		}
+		signature, err := idemix.ParseSignature(tx.Signature)
+		if err != nil {
+			t.Fatalf("Failed parsing signature [%s].", err)
+		}
+		attributes := signature.Attributes(issuerKey.Public())
+		if !bytes.Equal(attributes["company"], []byte("ACompany")) || !bytes.Equal(attributes["position"], []byte("Software Engineer")) {
+			t.Fatalf("Requested attributes should be disclosed [%v].", attributes)
+		}
 	}
 
-	err = validator.Verify(validator.GetID(), msg, msg)
-	if err == nil {
-		t.Fatal("Verify should fail when given an invalid signature.", err)
+	// Nothing links the two transactions
+	if bytes.Equal(tx1.Signature, tx2.Signature) {
+		t.Fatal("Signatures should differ.")
 	}
 
-	err = validator.Verify(validator.GetID(), signature, nil)
-	if err == nil {
-		t.Fatal("Verify should fail when given an invalid messahe.", err)
+	tx1.Payload = []byte("Another payload")
+	if _, err := validator.TransactionPreValidation(tx1); err != utils.ErrInvalidTransactionSignature {
+		t.Fatalf("Altered transaction should be rejected [%v].", err)
 	}
 }
 
-func BenchmarkTransactionCreation(b *testing.B) {
+func TestValidatorChaincodeKeyHierarchy(t *testing.T) {
 	initNodes()
 	defer closeNodes()
 
-	b.StopTimer()
-	b.ResetTimer()
-	cis := &obc.ChaincodeInvocationSpec{
-		ChaincodeSpec: &obc.ChaincodeSpec{
-			Type:                 obc.ChaincodeSpec_GOLANG,
-			ChaincodeID:          &obc.ChaincodeID{Path: "Contract001"},
-			CtorMsg:              nil,
-			ConfidentialityLevel: obc.ConfidentialityLevel_CONFIDENTIAL,
-		},
+	// Switch the clients to confidentiality protocol 1.3
+	for _, client := range []Client{deployer, invoker} {
+		conf := client.(*clientImpl).conf
+		version := conf.confidentialityProtocolVersion
+		conf.confidentialityProtocolVersion = "1.3"
+		defer func() { conf.confidentialityProtocolVersion = version }()
 	}
-	invoker.GetTCertificateHandlerNext(attrs...)
 
-	for i := 0; i < b.N; i++ {
-		uuid := util.GenerateUUID()
-		b.StartTimer()
-		invoker.NewChaincodeExecute(cis, uuid, attrs...)
-		b.StopTimer()
+	preExecute := func(tx *obc.Transaction) *obc.Transaction {
+		if _, err := validator.TransactionPreValidation(tx); err != nil {
+			t.Fatalf("Failed pre-validating transaction [%s].", err)
+		}
+		res, err := validator.TransactionPreExecution(tx)
+		if err != nil {
+			t.Fatalf("Failed pre-executing transaction [%s].", err)
+		}
+		return res
 	}
-}
 
-func BenchmarkTransactionValidation(b *testing.B) {
-	initNodes()
-	defer closeNodes()
+	otx, deployTx, err := createConfidentialDeployTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating deploy transaction [%s]", err)
+	}
+	if deployTx.ConfidentialityProtocolVersion != "1.3" {
+		t.Fatalf("Deploy transaction should use confidentiality protocol 1.3, got [%s].", deployTx.ConfidentialityProtocolVersion)
+	}
+	deployTx = preExecute(deployTx)
+	if err := isEqual(otx, deployTx); err != nil {
+		t.Fatalf("Decrypted transaction differs from the original: [%s]", err)
+	}
 
-	b.StopTimer()
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, tx, _ := createConfidentialTCertHExecuteTransaction(nil)
+	// The invoker can't invoke the chaincode until the deployer hands it the key
+	if _, _, err := createConfidentialExecuteTransaction(t); err != utils.ErrUnknownChaincodeKey {
+		t.Fatalf("Invoking without the chaincode key should fail with [%s], got [%v].", utils.ErrUnknownChaincodeKey, err)
+	}
+
+	chaincodeID := &obc.ChaincodeID{Path: "Contract001"}
+	chaincodeKey, err := deployer.GetChaincodeKey(chaincodeID)
+	if err != nil {
+		t.Fatalf("Failed getting chaincode key [%s].", err)
+	}
+	if err := invoker.SetChaincodeKey(chaincodeID, chaincodeKey[1:]); err == nil {
+		t.Fatal("Setting a truncated chaincode key should fail.")
+	}
+	if err := invoker.SetChaincodeKey(chaincodeID, chaincodeKey); err != nil {
+		t.Fatalf("Failed setting chaincode key [%s].", err)
+	}
+
+	otx, invokeTx, err := createConfidentialExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating invoke transaction [%s]", err)
+	}
+	invokeTx = preExecute(invokeTx)
+	if err := isEqual(otx, invokeTx); err != nil {
+		t.Fatalf("Decrypted transaction differs from the original: [%s]", err)
+	}
+
+	// The state written by the invocation is readable from the chaincode key
+	se, err := validator.GetStateEncryptor(deployTx, invokeTx)
+	if err != nil {
+		t.Fatalf("Failed creating state encryptor [%s].", err)
+	}
+	pt := []byte("Hello World")
+	ct, err := se.Encrypt(pt)
+	if err != nil {
+		t.Fatalf("Failed encrypting state [%s].", err)
+	}
+
+	_, queryTx, err := createConfidentialQueryTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating query transaction [%s]", err)
+	}
+	seQuery, err := validator.GetStateEncryptor(deployTx, preExecute(queryTx))
+	if err != nil {
+		t.Fatalf("Failed creating state encryptor [%s].", err)
+	}
+	aPt, err := seQuery.Decrypt(ct)
+	if err != nil {
+		t.Fatalf("Failed decrypting state [%s].", err)
+	}
+	if !bytes.Equal(pt, aPt) {
+		t.Fatalf("Failed decrypting state [%s != %s]", string(pt), string(aPt))
+	}
+	ctQ, err := seQuery.Encrypt(aPt)
+	if err != nil {
+		t.Fatalf("Failed encrypting query result [%s].", err)
+	}
+	aPt, err = invoker.DecryptQueryResult(queryTx, ctQ)
+	if err != nil {
+		t.Fatalf("Failed decrypting query result [%s].", err)
+	}
+	if !bytes.Equal(pt, aPt) {
+		t.Fatalf("Failed decrypting query result [%s != %s]", string(pt), string(aPt))
+	}
+	if _, err := deployer.DecryptQueryResult(queryTx, ctQ); err == nil {
+		t.Fatal("Only the invoker should decrypt the result of its query.")
+	}
+
+	// An invocation under another chaincode key is rejected
+	otherKey, err := primitives.GenAESKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	if err := invoker.SetChaincodeKey(chaincodeID, otherKey); err != nil {
+		t.Fatalf("Failed setting chaincode key [%s].", err)
+	}
+	_, invokeTx, err = createConfidentialExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating invoke transaction [%s]", err)
+	}
+	if _, err := validator.GetStateEncryptor(deployTx, preExecute(invokeTx)); err != utils.ErrInvalidChaincodeKey {
+		t.Fatalf("Invoking under another chaincode key should fail with [%s], got [%v].", utils.ErrInvalidChaincodeKey, err)
+	}
+}
+
+func TestValidatorPayloadAccessPolicy(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	cis := &obc.ChaincodeInvocationSpec{
+		ChaincodeSpec: &obc.ChaincodeSpec{
+			Type:                 obc.ChaincodeSpec_GOLANG,
+			ChaincodeID:          &obc.ChaincodeID{Path: "Contract001"},
+			CtorMsg:              &obc.ChaincodeInput{Function: "read", Args: []string{"secret"}},
+			ConfidentialityLevel: obc.ConfidentialityLevel_CONFIDENTIAL,
+		},
+	}
+	policy := &AccessPolicy{Identities: []string{invoker.(*clientImpl).enrollCert.Subject.CommonName}}
+
+	// Access policies require confidentiality protocol 1.3
+	if _, err := invoker.NewChaincodeExecuteWithPolicy(cis, util.GenerateUUID(), policy); err != errAccessPolicyUnsupported {
+		t.Fatalf("Restricting a payload under protocol 1.2 should fail with [%s], got [%v].", errAccessPolicyUnsupported, err)
+	}
+
+	for _, client := range []Client{deployer, invoker} {
+		conf := client.(*clientImpl).conf
+		version := conf.confidentialityProtocolVersion
+		conf.confidentialityProtocolVersion = "1.3"
+		defer func() { conf.confidentialityProtocolVersion = version }()
+	}
+
+	if _, _, err := createConfidentialDeployTransaction(t); err != nil {
+		t.Fatalf("Failed creating deploy transaction [%s]", err)
+	}
+	chaincodeID := &obc.ChaincodeID{Path: "Contract001"}
+	chaincodeKey, err := deployer.GetChaincodeKey(chaincodeID)
+	if err != nil {
+		t.Fatalf("Failed getting chaincode key [%s].", err)
+	}
+	if err := invoker.SetChaincodeKey(chaincodeID, chaincodeKey); err != nil {
+		t.Fatalf("Failed setting chaincode key [%s].", err)
+	}
+
+	otx, err := obc.NewChaincodeExecute(cis, util.GenerateUUID(), obc.Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Failed creating transaction [%s].", err)
+	}
+	tx, err := invoker.NewChaincodeExecuteWithPolicy(cis, otx.Uuid, policy)
+	if err != nil {
+		t.Fatalf("Failed creating restricted transaction [%s].", err)
+	}
+
+	// The validators read the payload
+	if _, err := validator.TransactionPreValidation(tx); err != nil {
+		t.Fatalf("Failed pre-validating transaction [%s].", err)
+	}
+	res, err := validator.TransactionPreExecution(tx)
+	if err != nil {
+		t.Fatalf("Failed pre-executing transaction [%s].", err)
+	}
+	if !bytes.Equal(otx.Payload, res.Payload) {
+		t.Fatal("Decrypted payload differs from the original.")
+	}
+
+	// A permitted identity reads the payload with a grant of a validator
+	request, err := invoker.NewPayloadAccessRequest(tx)
+	if err != nil {
+		t.Fatalf("Failed creating access request [%s].", err)
+	}
+	grant, err := validator.GrantPayloadAccess(tx, request)
+	if err != nil {
+		t.Fatalf("Failed granting access [%s].", err)
+	}
+	payload, err := invoker.DecryptPayload(tx, grant)
+	if err != nil {
+		t.Fatalf("Failed decrypting payload [%s].", err)
+	}
+	if !bytes.Equal(otx.Payload, payload) {
+		t.Fatal("Decrypted payload differs from the original.")
+	}
+
+	// The other holders of the chaincode key are denied access
+	request, err = deployer.NewPayloadAccessRequest(tx)
+	if err != nil {
+		t.Fatalf("Failed creating access request [%s].", err)
+	}
+	if _, err := validator.GrantPayloadAccess(tx, request); err != utils.ErrAccessDenied {
+		t.Fatalf("Granting access outside the policy should fail with [%s], got [%v].", utils.ErrAccessDenied, err)
+	}
+
+	// A request is bound to its transaction
+	otherTx, err := invoker.NewChaincodeExecuteWithPolicy(cis, util.GenerateUUID(), policy)
+	if err != nil {
+		t.Fatalf("Failed creating restricted transaction [%s].", err)
+	}
+	request, err = invoker.NewPayloadAccessRequest(otherTx)
+	if err != nil {
+		t.Fatalf("Failed creating access request [%s].", err)
+	}
+	if _, err := validator.GrantPayloadAccess(tx, request); err != errInvalidAccessRequest {
+		t.Fatalf("Granting access to another transaction should fail with [%s], got [%v].", errInvalidAccessRequest, err)
+	}
+}
+
+func TestValidatorSignVerify(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	msg := []byte("Hello World!!!")
+	signature, err := validator.Sign(msg)
+	if err != nil {
+		t.Fatalf("TestSign: failed generating signature [%s].", err)
+	}
+
+	err = validator.Verify(validator.GetID(), signature, msg)
+	if err != nil {
+		t.Fatalf("TestSign: failed validating signature [%s].", err)
+	}
+}
+
+func TestValidatorVerify(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	msg := []byte("Hello World!!!")
+	signature, err := validator.Sign(msg)
+	if err != nil {
+		t.Fatalf("Failed generating signature [%s].", err)
+	}
+
+	err = validator.Verify(nil, signature, msg)
+	if err == nil {
+		t.Fatal("Verify should fail when given an empty id.", err)
+	}
+
+	err = validator.Verify(msg, signature, msg)
+	if err == nil {
+		t.Fatal("Verify should fail when given an invalid id.", err)
+	}
+
+	err = validator.Verify(validator.GetID(), nil, msg)
+	if err == nil {
+		t.Fatal("Verify should fail when given an invalid signature.", err)
+	}
+
+	err = validator.Verify(validator.GetID(), msg, msg)
+	if err == nil {
+		t.Fatal("Verify should fail when given an invalid signature.", err)
+	}
+
+	err = validator.Verify(validator.GetID(), signature, nil)
+	if err == nil {
+		t.Fatal("Verify should fail when given an invalid messahe.", err)
+	}
+}
+
+func TestValidatorDeleteEnrollmentCert(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	msg := []byte("Hello World!!!")
+	signature, err := peer.Sign(msg)
+	if err != nil {
+		t.Fatalf("Failed generating signature [%s].", err)
+	}
+
+	// Cache the cert of peer
+	if err := validator.Verify(peer.GetID(), signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+
+	if err := validator.DeleteEnrollmentCert(nil); err == nil {
+		t.Fatal("DeleteEnrollmentCert should fail when given an empty id.")
+	}
+	if err := validator.DeleteEnrollmentCert(peer.GetID()); err != nil {
+		t.Fatalf("Failed deleting enrollment cert [%s].", err)
+	}
+
+	// The cert is fetched again from the ECA
+	if err := validator.Verify(peer.GetID(), signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature after delete [%s].", err)
+	}
+
+	if _, err := validator.PurgeEnrollmentCerts(nil); err == nil {
+		t.Fatal("PurgeEnrollmentCerts should fail when given a nil filter.")
+	}
+	n, err := validator.PurgeEnrollmentCerts(func(id []byte, cert *x509.Certificate) bool {
+		return bytes.Equal(id, peer.GetID())
+	})
+	if err != nil {
+		t.Fatalf("Failed purging enrollment certs [%s].", err)
+	}
+	if n != 1 {
+		t.Fatalf("Purge should have removed one cert, removed [%d].", n)
+	}
+}
+
+func TestValidatorProcessCRL(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	if _, err := validator.ProcessCRL(nil); err == nil {
+		t.Fatal("ProcessCRL should fail when given an empty CRL.")
+	}
+
+	// CRL not issued by the ECA or the TCA
+	der, priv, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing self-signed cert [%s].", err)
+	}
+	crl, err := cert.CreateCRL(rand.Reader, priv, nil, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed creating CRL [%s].", err)
+	}
+	if _, err := validator.ProcessCRL(crl); err == nil {
+		t.Fatal("ProcessCRL should fail when given a CRL not issued by the ECA or the TCA.")
+	}
+}
+
+func TestValidatorPollCRLs(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	polls, failures := crlPolls.get(), crlPollFailures.get()
+
+	validator.(*validatorImpl).peerImpl.pollCRLs()
+
+	if n := crlPolls.get() - polls; n != 2 {
+		t.Fatalf("The CRLs of the ECA and the TCA should have been fetched, fetched [%d].", n)
+	}
+	if n := crlPollFailures.get() - failures; n != 0 {
+		t.Fatalf("The CRLs of the ECA and the TCA should have been applied, [%d] failures.", n)
+	}
+}
+
+func TestValidatorProcessOCSPResponse(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	if _, err := validator.ProcessOCSPResponse(nil); err == nil {
+		t.Fatal("ProcessOCSPResponse should fail when given an empty response.")
+	}
+
+	// Response not signed by the ECA
+	der, priv, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing self-signed cert [%s].", err)
+	}
+	resp, err := primitives.CreateOCSPResponse(cert, cert, priv.(*ecdsa.PrivateKey), []*primitives.OCSPResponse{
+		{Status: primitives.OCSPRevoked, SerialNumber: big.NewInt(100), ThisUpdate: time.Now(), RevokedAt: time.Now()},
+		{Status: primitives.OCSPGood, SerialNumber: big.NewInt(101), ThisUpdate: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Failed creating OCSP response [%s].", err)
+	}
+	if _, err := validator.ProcessOCSPResponse(resp); err == nil {
+		t.Fatal("ProcessOCSPResponse should fail when given a response not signed by the ECA.")
+	}
+
+	// Statuses of the certs of a trusted issuer
+	v := validator.(*validatorImpl)
+	v.ocspIssuer = cert
+	n, err := validator.ProcessOCSPResponse(resp)
+	if err != nil || n != 2 {
+		t.Fatalf("Failed processing OCSP response [%d][%v].", n, err)
+	}
+
+	revoked := &x509.Certificate{RawIssuer: cert.RawSubject, SerialNumber: big.NewInt(100)}
+	if err := v.checkOCSP(revoked); err != utils.ErrCertRevoked {
+		t.Fatalf("Certificate revoked according to OCSP should be rejected [%v].", err)
+	}
+	if !v.isCertRevoked(revoked) {
+		t.Fatal("Certificate revoked according to OCSP should be recorded as revoked.")
+	}
+	if err := v.checkOCSP(&x509.Certificate{RawIssuer: cert.RawSubject, SerialNumber: big.NewInt(101)}); err != nil {
+		t.Fatalf("Good certificate should be accepted [%s].", err)
+	}
+
+	// Without live checks, certificates without status are accepted
+	if err := v.checkOCSP(&x509.Certificate{RawIssuer: cert.RawSubject, SerialNumber: big.NewInt(102)}); err != nil {
+		t.Fatalf("Certificate without status should be accepted [%s].", err)
+	}
+}
+
+func TestNodeOptions(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	dataPath, err := ioutil.TempDir("", "crypto-options")
+	if err != nil {
+		t.Fatalf("Failed creating data path [%s].", err)
+	}
+	defer os.RemoveAll(dataPath)
+
+	// The data of the shared peer are not at dataPath
+	if _, err := NewPeer("peer", ksPwd, &Options{DataPath: dataPath}); err != utils.ErrRegistrationRequired {
+		t.Fatalf("A node at another data path should not be registered [%v].", err)
+	}
+
+	// Nor do they show in another configuration
+	config := viper.New()
+	config.Set("peer.fileSystemPath", dataPath)
+	for _, property := range []string{"peer.pki.eca.paddr", "peer.pki.tca.paddr", "peer.pki.tlsca.paddr"} {
+		config.Set(property, viper.GetString(property))
+	}
+	if _, err := NewValidator("validator", ksPwd, &Options{Config: config}); err != utils.ErrRegistrationRequired {
+		t.Fatalf("A node with another configuration should not be registered [%v].", err)
+	}
+
+	// Shared nodes are not affected
+	shared, err := InitValidator("validator", ksPwd)
+	if err != nil || shared != validator {
+		t.Fatalf("The shared validator should be returned [%v].", err)
+	}
+	if err := CloseValidator(shared); err != nil {
+		t.Fatalf("Failed closing validator [%s].", err)
+	}
+
+	if err := Register(NodeType(-1), "other", ksPwd, "", "", nil); err != utils.ErrInvalidNodeType {
+		t.Fatalf("Invalid node type should be rejected [%v].", err)
+	}
+	if err := Close(nil); err != utils.ErrInvalidReference {
+		t.Fatalf("Closing nil should fail [%v].", err)
+	}
+}
+
+func TestNodeConfTLSSubjectAltNames(t *testing.T) {
+	config := viper.New()
+	for _, property := range []string{"peer.fileSystemPath", "peer.pki.eca.paddr", "peer.pki.tca.paddr", "peer.pki.tlsca.paddr"} {
+		config.Set(property, viper.GetString(property))
+	}
+
+	// No subject alternative names unless configured
+	conf := &configuration{prefix: "peer", name: "san", v: config}
+	if err := conf.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if len(conf.getTLSDNSNames()) != 0 || len(conf.getTLSIPAddresses()) != 0 {
+		t.Fatal("No subject alternative name should be requested by default.")
+	}
+
+	config.Set("security.tlssan.dnsNames", []string{"vp0.example.com", "vp0"})
+	config.Set("security.tlssan.ipAddresses", []string{"10.0.0.1"})
+	conf = &configuration{prefix: "peer", name: "san", v: config}
+	if err := conf.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if !reflect.DeepEqual(conf.getTLSDNSNames(), []string{"vp0.example.com", "vp0"}) {
+		t.Fatalf("Unexpected DNS names [%v].", conf.getTLSDNSNames())
+	}
+	if !reflect.DeepEqual(conf.getTLSIPAddresses(), []string{"10.0.0.1"}) {
+		t.Fatalf("Unexpected IP addresses [%v].", conf.getTLSIPAddresses())
+	}
+}
+
+func TestValidatorCertVerifyCache(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	_, tx, err := createPublicExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating transaction [%s].", err)
+	}
+
+	// The chain is walked once
+	hits := certVerifyCacheHits.get()
+	for i := 0; i < 3; i++ {
+		if _, err := validator.TransactionPreValidation(tx); err != nil {
+			t.Fatalf("Failed pre-validating transaction [%s].", err)
+		}
+	}
+	if certVerifyCacheHits.get()-hits != 2 {
+		t.Fatalf("Verified cert should be served from the cache [%d].", certVerifyCacheHits.get()-hits)
+	}
+
+	// Certs issued by another CA are rejected
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	forged := *tx
+	forged.Cert = der
+	if _, err := validator.TransactionPreValidation(&forged); err != utils.ErrInvalidCertificateChain {
+		t.Fatalf("Cert not issued by the TCA should be rejected [%v].", err)
+	}
+
+	// A revocation drops the verified cert
+	cert, err := primitives.DERToX509Certificate(tx.Cert)
+	if err != nil {
+		t.Fatalf("Failed parsing cert [%s].", err)
+	}
+	peer := validator.(*validatorImpl).peerImpl
+	key := revokedCertKey(utils.EncodeBase64(cert.RawIssuer), cert.SerialNumber.String())
+	peer.revokedCertsMutex.Lock()
+	peer.revokedCerts[key] = true
+	peer.revokedCertsMutex.Unlock()
+	defer func() {
+		peer.revokedCertsMutex.Lock()
+		delete(peer.revokedCerts, key)
+		peer.revokedCertsMutex.Unlock()
+	}()
+	if n := peer.purgeVerifiedCerts(); n != 1 {
+		t.Fatalf("Revoked cert should be dropped [%d].", n)
+	}
+	if _, err := validator.TransactionPreValidation(tx); err != utils.ErrCertRevoked {
+		t.Fatalf("Transaction with a revoked cert should be rejected [%v].", err)
+	}
+}
+
+func TestValidatorExportImportIdentity(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	var pfx bytes.Buffer
+	if err := ExportIdentity(NodeValidator, validator.GetName(), ksPwd, "secret", &pfx); err != nil {
+		t.Fatalf("Failed exporting identity [%s].", err)
+	}
+
+	if err := ImportIdentity(NodeValidator, validator.GetName(), ksPwd, "wrong", bytes.NewReader(pfx.Bytes())); err == nil {
+		t.Fatal("ImportIdentity should fail when given a wrong password.")
+	}
+	if err := ImportIdentity(NodeValidator, validator.GetName(), ksPwd, "secret", bytes.NewReader(pfx.Bytes())); err != nil {
+		t.Fatalf("Failed importing identity [%s].", err)
+	}
+
+	// The imported identity is the exported one
+	var again bytes.Buffer
+	if err := ExportIdentity(NodeValidator, validator.GetName(), ksPwd, "secret", &again); err != nil {
+		t.Fatalf("Failed exporting identity after import [%s].", err)
+	}
+	if again.Len() == 0 {
+		t.Fatal("Exported identity is empty.")
+	}
+}
+
+func TestValidatorVerifyKeyStore(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	msg := []byte("Hello World!!!")
+	signature, err := peer.Sign(msg)
+	if err != nil {
+		t.Fatalf("Failed generating signature [%s].", err)
+	}
+
+	// Cache the cert of peer
+	if err := validator.Verify(peer.GetID(), signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+
+	report, err := VerifyKeyStore(NodeValidator, validator.GetName(), ksPwd, false)
+	if err != nil {
+		t.Fatalf("Failed verifying keystore [%s].", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("Verification should not report problems, reported [%v].", report.Problems)
+	}
+	if report.Checked < 3 {
+		t.Fatalf("Verification should check the chains, the enrollment cert and the cached cert, checked [%d].", report.Checked)
+	}
+}
+
+func TestValidatorReadOnlyKeyStore(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	viper.Set("security.keystore.readOnly", true)
+	defer viper.Set("security.keystore.readOnly", false)
+
+	node, err := openNodeKeyStore(NodeValidator, validator.GetName(), ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening read-only keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening read-only cert store [%s].", err)
+	}
+
+	if _, err := node.ks.loadPrivateKey(node.conf.getEnrollmentKeyFilename()); err != nil {
+		t.Fatalf("Failed loading enrollment key [%s].", err)
+	}
+
+	if err := node.ks.storeKeyMaterial("key", []byte("key")); err != utils.ErrKeyStoreReadOnly {
+		t.Fatalf("Storing a key should fail with [%s], got [%s].", utils.ErrKeyStoreReadOnly, err)
+	}
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		t.Fatal("A read-only keystore should not fetch certs.")
+		return nil, nil, nil
+	}
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), []byte("unknown"), fetcher); err != utils.ErrKeyStoreReadOnly {
+		t.Fatalf("Fetching a cert should fail with [%s], got [%s].", utils.ErrKeyStoreReadOnly, err)
+	}
+	if _, err := node.ks.verify(true); err != utils.ErrKeyStoreReadOnly {
+		t.Fatalf("Repairing should fail with [%s], got [%s].", utils.ErrKeyStoreReadOnly, err)
+	}
+}
+
+func TestKeyStoreInMemory(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "crypto-inmemory")
+	if err != nil {
+		t.Fatalf("Failed creating data path [%s].", err)
+	}
+	defer os.RemoveAll(dataPath)
+
+	config := viper.New()
+	config.Set("peer.fileSystemPath", dataPath)
+	for _, property := range []string{"peer.pki.eca.paddr", "peer.pki.tca.paddr", "peer.pki.tlsca.paddr"} {
+		config.Set(property, viper.GetString(property))
+	}
+	config.Set("security.keystore.inmemory", true)
+
+	node := &nodeImpl{eType: NodeValidator, opts: Options{Config: config}}
+	if err := node.initConfiguration("inmemory"); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if err := node.initCSP(); err != nil {
+		t.Fatalf("Failed initializing CSP [%s].", err)
+	}
+	if err := node.initKeyStore(ksPwd); err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+	if _, ok := node.ks.certStore.(*memoryCertStore); !ok {
+		t.Fatalf("In-memory keystore should use the memory cert store, got [%T].", node.ks.certStore)
+	}
+	if err := node.ks.PutBlob("inmemory.test", "a", []byte("1")); err != nil {
+		t.Fatalf("Failed storing blob [%s].", err)
+	}
+	if err := node.ks.certStore.Put("id", &CertEntry{CertSign: []byte("cert")}); err != nil {
+		t.Fatalf("Failed storing cert [%s].", err)
+	}
+	if value, err := node.ks.GetBlob("inmemory.test", "a"); err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Fatalf("Failed reading blob [%s][%v].", value, err)
+	}
+	if err := node.ks.close(); err != nil {
+		t.Fatalf("Failed closing keystore [%s].", err)
+	}
+
+	// No file is written
+	err = filepath.Walk(dataPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("In-memory keystore should write no file, found [%s].", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed walking data path [%s].", err)
+	}
+}
+
+func TestKeyStoreConcurrentInit(t *testing.T) {
+	node := &nodeImpl{eType: NodeValidator}
+	if err := node.initConfiguration("concurrentinit"); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if err := node.initCSP(); err != nil {
+		t.Fatalf("Failed initializing CSP [%s].", err)
+	}
+
+	// The keystore is initialized once, whatever the number of callers
+	ks := &keyStore{}
+	errs := make(chan error, 16)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- ks.init(node, ksPwd)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	initialized := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			initialized++
+		case utils.ErrKeyStoreAlreadyInitialized:
+		default:
+			t.Fatalf("Failed initializing keystore [%s].", err)
+		}
+	}
+	if initialized != 1 {
+		t.Fatalf("Keystore should be initialized once, [%d] initializations.", initialized)
+	}
+	if err := ks.init(node, ksPwd); err != utils.ErrKeyStoreAlreadyInitialized {
+		t.Fatalf("Keystore should be already initialized [%v].", err)
+	}
+
+	// Closing twice is harmless
+	if err := ks.close(); err != nil {
+		t.Fatalf("Failed closing keystore [%s].", err)
+	}
+	if err := ks.close(); err != nil {
+		t.Fatalf("Closing a closed keystore should succeed [%s].", err)
+	}
+}
+
+func TestKeyStoreShared(t *testing.T) {
+	viper.Set("security.keystore.shared", true)
+	defer viper.Set("security.keystore.shared", false)
+
+	// Nodes of different types, with the same name, share the keystore DB
+	validatorNode, err := openNodeKeyStore(NodeValidator, "shared", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening validator keystore [%s].", err)
+	}
+	defer validatorNode.ks.close()
+	clientNode, err := openNodeKeyStore(NodeClient, "shared", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening client keystore [%s].", err)
+	}
+	defer clientNode.ks.close()
+
+	if validatorNode.conf.getKeyStoreFilePath() != clientNode.conf.getKeyStoreFilePath() {
+		t.Fatal("Nodes should share the keystore DB.")
+	}
+
+	if err := validatorNode.ks.storeKeyMaterial("key", []byte("validator")); err != nil {
+		t.Fatalf("Failed storing validator key [%s].", err)
+	}
+	if err := clientNode.ks.storeKeyMaterial("key", []byte("client")); err != nil {
+		t.Fatalf("Failed storing client key [%s].", err)
+	}
+
+	for node, expected := range map[*nodeImpl]string{validatorNode: "validator", clientNode: "client"} {
+		key, err := node.ks.loadKeyMaterial("key")
+		if err != nil {
+			t.Fatalf("Failed loading key [%s].", err)
+		}
+		if string(key) != expected {
+			t.Fatalf("Rows of the nodes are not namespaced. Expected [%s], got [%s].", expected, key)
+		}
+	}
+}
+
+func TestKeyStoreNodeDBLocation(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "obc-crypto-tests", "custom")
+	viper.Set("security.keystore.nodes.custom.path", path)
+	viper.Set("security.keystore.nodes.custom.filename", "custom.db")
+	viper.Set("security.keystore.nodes.custom.fileMode", "0600")
+	defer func() {
+		viper.Set("security.keystore.nodes.custom.path", "")
+		viper.Set("security.keystore.nodes.custom.filename", "")
+		viper.Set("security.keystore.nodes.custom.fileMode", "")
+	}()
+
+	node, err := openNodeKeyStore(NodeValidator, "custom", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	info, err := os.Stat(filepath.Join(path, "custom.db"))
+	if err != nil {
+		t.Fatalf("Keystore DB not found at the configured location [%s].", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Keystore DB has mode [%s], expected [%s].", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// Other nodes keep the default location
+	other := &configuration{prefix: "validator", name: "other"}
+	if err := other.init(); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if other.getKeyStoreFilePath() != filepath.Join(other.getKeyStorePath(), "db") {
+		t.Fatalf("Unexpected keystore DB location [%s].", other.getKeyStoreFilePath())
+	}
+}
+
+func TestCertStoreRegistry(t *testing.T) {
+	if err := RegisterCertStore("sqlite", newSQLiteCertStore); err == nil {
+		t.Fatal("Registering a backend twice should fail.")
+	}
+	if err := RegisterCertStore("registry", nil); err != utils.ErrNilArgument {
+		t.Fatalf("Registering a nil factory should fail with [%s], got [%v].", utils.ErrNilArgument, err)
+	}
+
+	var opened *CertStoreConfig
+	store, _ := newMemoryCertStore(nil)
+	factory := func(conf *CertStoreConfig) (CertStore, error) {
+		opened = conf
+		return store, nil
+	}
+	if err := RegisterCertStore("registry", factory); err != nil {
+		t.Fatalf("Failed registering backend [%s].", err)
+	}
+	// The test runs once per scenario
+	defer func() {
+		certStoreFactoriesMutex.Lock()
+		delete(certStoreFactories, "registry")
+		certStoreFactoriesMutex.Unlock()
+	}()
+
+	// The backend is selected by configuration
+	viper.Set("security.keystore.certstore.backend", "registry")
+	defer viper.Set("security.keystore.certstore.backend", "")
+	node, err := openNodeKeyStore(NodeValidator, "registry", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+	if node.ks.certStore != store {
+		t.Fatal("The cert store should be created by the configured backend.")
+	}
+	if opened == nil || opened.Name != "registry" || opened.Path != node.conf.getKeyStorePath() {
+		t.Fatalf("Invalid cert store configuration [%v].", opened)
+	}
+
+	// Unknown backends are rejected
+	viper.Set("security.keystore.certstore.backend", "unknown")
+	other, err := openNodeKeyStore(NodeValidator, "registry-unknown", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer other.ks.close()
+	if err := other.ks.openCertStore(); err == nil || !strings.Contains(err.Error(), "[unknown] not registered") {
+		t.Fatalf("Opening an unknown backend should fail [%v].", err)
+	}
+}
+
+// testCertStore checks that store, empty, behaves as the CertStore
+// interface requires
+func testCertStore(t *testing.T, store CertStore) {
+	if entry, err := store.Get("missing"); entry != nil || err != nil {
+		t.Fatalf("Getting a missing entry should return nil [%v].", err)
+	}
+
+	now := time.Unix(time.Now().Unix(), 0)
+	entry := &CertEntry{
+		CertSign:     []byte("sign"),
+		CertEnc:      []byte("enc"),
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		Type:         CertTypeECert,
+		SerialNumber: "42",
+		Issuer:       "issuer",
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.Put(id, entry); err != nil {
+			t.Fatalf("Failed putting entry [%s].", err)
+		}
+	}
+	stored, err := store.Get("a")
+	if err != nil || !reflect.DeepEqual(stored, entry) {
+		t.Fatalf("Stored entry [%v] differs from [%v]: [%v].", stored, entry, err)
+	}
+
+	// Put replaces the entry
+	replaced := &CertEntry{CertSign: []byte("other")}
+	if err := store.Put("a", replaced); err != nil {
+		t.Fatalf("Failed replacing entry [%s].", err)
+	}
+	if stored, err = store.Get("a"); err != nil || !bytes.Equal(stored.CertSign, replaced.CertSign) || len(stored.CertEnc) != 0 || !stored.NotAfter.IsZero() {
+		t.Fatalf("Entry should be replaced [%v]: [%v].", stored, err)
+	}
+
+	// ForEach visits every entry, and stops at the first error
+	visited := map[string]bool{}
+	if err := store.ForEach(func(id string, entry *CertEntry) error {
+		visited[id] = true
+		return nil
+	}); err != nil || len(visited) != 3 {
+		t.Fatalf("ForEach should visit the 3 entries, visited [%v]: [%v].", visited, err)
+	}
+	stop := errors.New("stop")
+	calls := 0
+	if err := store.ForEach(func(id string, entry *CertEntry) error {
+		calls++
+		return stop
+	}); err != stop || calls != 1 {
+		t.Fatalf("ForEach should stop at the first error, called [%d] times: [%v].", calls, err)
+	}
+
+	// Delete removes the entry, deleting a missing entry is not an error
+	if err := store.Delete("b"); err != nil {
+		t.Fatalf("Failed deleting entry [%s].", err)
+	}
+	if stored, err = store.Get("b"); stored != nil || err != nil {
+		t.Fatalf("Deleted entry should be missing [%v]: [%v].", stored, err)
+	}
+	if err := store.Delete("b"); err != nil {
+		t.Fatalf("Deleting a missing entry should not fail [%s].", err)
+	}
+
+	// Trim evicts entries down to maxEntries
+	if evicted, err := store.Trim(2); evicted != 0 || err != nil {
+		t.Fatalf("Nothing should be evicted, evicted [%d]: [%v].", evicted, err)
+	}
+	if evicted, err := store.Trim(1); evicted != 1 || err != nil {
+		t.Fatalf("One entry should be evicted, evicted [%d]: [%v].", evicted, err)
+	}
+	remaining := 0
+	store.ForEach(func(id string, entry *CertEntry) error {
+		remaining++
+		return nil
+	})
+	if remaining != 1 {
+		t.Fatalf("One entry should remain, [%d] remain.", remaining)
+	}
+
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Failed compacting [%s].", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed closing [%s].", err)
+	}
+}
+
+func TestCertStoreBackends(t *testing.T) {
+	for _, backend := range []string{"sqlite", "memory", "bolt"} {
+		path, err := ioutil.TempDir("", "certstore-"+backend)
+		if err != nil {
+			t.Fatalf("Failed creating path [%s].", err)
+		}
+		defer os.RemoveAll(path)
+
+		store, err := newCertStore(backend, &CertStoreConfig{Path: path, DBPath: path, Filename: "db", Name: "conformance"})
+		if err != nil {
+			t.Fatalf("Failed opening cert store [%s]: [%s].", backend, err)
+		}
+		t.Logf("Testing cert store [%s]", backend)
+		testCertStore(t, store)
+	}
+}
+
+func TestCertStoreTrimmer(t *testing.T) {
+	defer viper.Set("security.keystore.certstore.backend", "sqlite")
+
+	for _, backend := range []string{"memory", "sqlite"} {
+		viper.Set("security.keystore.certstore.backend", backend)
+		node, err := openNodeKeyStore(NodeValidator, "trim."+backend, ksPwd)
+		if err != nil {
+			t.Fatalf("Failed opening keystore [%s].", err)
+		}
+		if err := node.ks.openCertStore(); err != nil {
+			t.Fatalf("Failed opening cert store [%s].", err)
+		}
+		store := node.ks.certStore
+
+		for _, id := range []string{"a", "b", "c", "d"} {
+			if err := store.Put(id, &CertEntry{CertSign: []byte(id)}); err != nil {
+				t.Fatalf("Failed storing entry [%s].", err)
+			}
+		}
+		// a is the most recently used, b and c the least
+		if sqliteStore, ok := store.(*sqliteCertStore); ok {
+			// The use of the entries is recorded to the second
+			for i, id := range []string{"b", "c", "d", "a"} {
+				if _, err := sqliteStore.sqlDB.Exec("UPDATE Certificates SET lastused = ? WHERE id = ?", i, id); err != nil {
+					t.Fatalf("Failed setting use of entry [%s].", err)
+				}
+			}
+		} else if _, err := store.Get("a"); err != nil {
+			t.Fatalf("Failed getting entry [%s].", err)
+		}
+
+		evictions := certCacheEvictions.get()
+		node.ks.startCertStoreTrimmer(2, 10*time.Millisecond)
+		ids := []string{}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			ids = ids[:0]
+			node.ks.m.Lock()
+			err := store.ForEach(func(id string, entry *CertEntry) error {
+				ids = append(ids, id)
+				return nil
+			})
+			node.ks.m.Unlock()
+			if err != nil {
+				t.Fatalf("Failed listing entries [%s].", err)
+			}
+			if len(ids) <= 2 {
+				break
+			}
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, []string{"a", "d"}) {
+			t.Fatalf("The least recently used entries should be evicted by [%s], got %v.", backend, ids)
+		}
+		if evicted := certCacheEvictions.get() - evictions; evicted != 2 {
+			t.Fatalf("Expected [2] evictions, got [%d].", evicted)
+		}
+
+		// Closing the keystore stops the job
+		stop := node.ks.certStoreTrimStop
+		if err := node.ks.close(); err != nil {
+			t.Fatalf("Failed closing keystore [%s].", err)
+		}
+		select {
+		case <-stop:
+		default:
+			t.Fatal("Trim job should be stopped on close.")
+		}
+	}
+}
+
+func TestKeyStoreConcurrentCertFetch(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "fetch", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+
+	var m sync.Mutex
+	fetches := 0
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		m.Lock()
+		fetches++
+		m.Unlock()
+		time.Sleep(100 * time.Millisecond)
+
+		return der, nil, nil
+	}
+
+	// Concurrent misses on the same id share a single fetch
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cert, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher)
+			if err == nil && !bytes.Equal(cert, der) {
+				err = fmt.Errorf("Invalid cert [% x].", cert)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Failed getting cert [%s].", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("Concurrent misses should share a single fetch, [%d] fetches.", fetches)
+	}
+}
+
+func TestKeyStoreCertFetchValidation(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "validation", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+	rogue, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+
+	for _, c := range []struct {
+		desc     string
+		id, cert []byte
+	}{
+		{"Unparsable certs", primitives.Hash([]byte("garbage")), []byte("garbage")},
+		{"Certs not issued by the ECA", primitives.Hash(rogue), rogue},
+		{"Certs of another id", []byte("other"), der},
+	} {
+		cert := c.cert
+		fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+			return cert, nil, nil
+		}
+		if _, err := node.ks.GetSignEnrollmentCert(context.Background(), c.id, fetcher); err == nil {
+			t.Fatalf("%s should be rejected.", c.desc)
+		}
+		if entry, err := node.ks.certStore.Get(utils.EncodeBase64(c.id)); err != nil || entry != nil {
+			t.Fatalf("%s should not be cached [%v].", c.desc, err)
+		}
+	}
+
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		return der, nil, nil
+	}
+	if cert, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil || !bytes.Equal(cert, der) {
+		t.Fatalf("Valid cert should be cached [%s].", err)
+	}
+}
+
+// newTestECACert returns a cert, and its id, chaining to the ECA of node
+func newTestECACert(t *testing.T, node *nodeImpl) ([]byte, []byte) {
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	x509Cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing cert [%s].", err)
+	}
+	if node.ecaCertPool == nil {
+		node.ecaCertPool = x509.NewCertPool()
+	}
+	node.ecaCertPool.AddCert(x509Cert)
+
+	return der, primitives.Hash(der)
+}
+
+func TestKeyStoreCertExpiry(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "expiry", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+	x509Cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing cert [%s].", err)
+	}
+	fetches := 0
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		fetches++
+		return der, nil, nil
+	}
+
+	// The expiry of a fetched cert is stored with it
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil {
+		t.Fatalf("Failed fetching cert [%s].", err)
+	}
+	sid := utils.EncodeBase64(id)
+	entry, err := node.ks.certStore.Get(sid)
+	if err != nil || entry == nil {
+		t.Fatalf("Cert should be stored [%v].", err)
+	}
+	if !entry.NotAfter.Equal(x509Cert.NotAfter) {
+		t.Fatalf("Stored expiry should be [%s], got [%s].", x509Cert.NotAfter, entry.NotAfter)
+	}
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil || fetches != 1 {
+		t.Fatalf("Cert far from expiry should be served from the cert store, [%d] fetches [%v].", fetches, err)
+	}
+
+	// The expiry of an entry stored without it is read from the cert
+	entry.NotAfter = time.Time{}
+	if err := node.ks.certStore.Put(sid, entry); err != nil {
+		t.Fatalf("Failed storing entry [%s].", err)
+	}
+	if entry, err = node.ks.selectSignEnrollmentCert(context.Background(), sid); err != nil || !entry.NotAfter.Equal(x509Cert.NotAfter) {
+		t.Fatalf("Expiry should be read from the cert [%v].", err)
+	}
+
+	// A cert expiring within the renewal window is fetched again
+	node.conf.certRenewalWindow = x509Cert.NotAfter.Sub(time.Now()) + time.Hour
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil || fetches != 2 {
+		t.Fatalf("Cert within the renewal window should be fetched again, [%d] fetches [%v].", fetches, err)
+	}
+}
+
+func TestKeyStoreMetrics(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "metrics", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		return der, nil, nil
+	}
+	fetchCount := func() uint64 {
+		ecaFetchLatency.m.Lock()
+		defer ecaFetchLatency.m.Unlock()
+		return ecaFetchLatency.count
+	}
+	hits, misses, fetches := certCacheHits.get(), certCacheMisses.get(), fetchCount()
+
+	// A miss, fetched from the ECA, then a hit
+	for i := 0; i < 2; i++ {
+		if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil {
+			t.Fatalf("Failed getting cert [%s].", err)
+		}
+	}
+	if certCacheHits.get()-hits != 1 || certCacheMisses.get()-misses != 1 || fetchCount()-fetches != 1 {
+		t.Fatalf("Expected a hit, a miss and a fetch, got [%d] hits, [%d] misses and [%d] fetches.", certCacheHits.get()-hits, certCacheMisses.get()-misses, fetchCount()-fetches)
+	}
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, nil)
+	for _, sample := range []string{
+		fmt.Sprintf("crypto_cert_cache_hits_total %d\n", certCacheHits.get()),
+		fmt.Sprintf("crypto_cert_cache_misses_total %d\n", certCacheMisses.get()),
+		fmt.Sprintf("%s_count %d\n", ecaFetchLatency.name, fetchCount()),
+	} {
+		if !strings.Contains(recorder.Body.String(), sample) {
+			t.Fatalf("Metrics should contain [%s].", sample)
+		}
+	}
+}
+
+func TestKeyStoreCertFetchRetry(t *testing.T) {
+	viper.Set("security.keystore.certstore.retry.initialBackoff", "1ms")
+	defer viper.Set("security.keystore.certstore.retry.initialBackoff", "200ms")
+
+	node, err := openNodeKeyStore(NodeValidator, "retry", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+
+	// Transient failures are retried
+	attempts := 0
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, nil, grpc.Errorf(codes.Unavailable, "ECA down")
+		}
+		return der, nil, nil
+	}
+	cert, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher)
+	if err != nil || !bytes.Equal(cert, der) {
+		t.Fatalf("Fetch should succeed after retries [%s].", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Fetch should be attempted 3 times, [%d] attempts.", attempts)
+	}
+
+	// Unknown identities are not
+	attempts = 0
+	fetcher = func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		attempts++
+		return nil, nil, grpc.Errorf(codes.Unknown, "sql: no rows in result set")
+	}
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), []byte("unknown"), fetcher); err == nil {
+		t.Fatal("Fetch of an unknown identity should fail.")
+	}
+	if attempts != 1 {
+		t.Fatalf("Fetch of an unknown identity should not be retried, [%d] attempts.", attempts)
+	}
+
+	// Nor are fetches whose context is done
+	attempts = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	fetcher = func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		attempts++
+		cancel()
+		return nil, nil, grpc.Errorf(codes.Unavailable, "ECA down")
+	}
+	if _, err := node.ks.GetSignEnrollmentCert(ctx, []byte("cancelled"), fetcher); err == nil {
+		t.Fatal("Cancelled fetch should fail.")
+	}
+	if attempts != 1 {
+		t.Fatalf("Cancelled fetch should not be retried, [%d] attempts.", attempts)
+	}
+}
+
+func TestKeyStoreCertLookupContext(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "lookupctx", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+	fetches := 0
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		fetches++
+		return der, nil, nil
+	}
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil {
+		t.Fatalf("Failed fetching cert [%s].", err)
+	}
+
+	// A done context aborts the lookup, even of a stored cert
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := node.ks.GetSignEnrollmentCert(ctx, id, fetcher); err != context.Canceled {
+		t.Fatalf("Lookup with a cancelled context should fail with [%s], got [%v].", context.Canceled, err)
+	}
+	if fetches != 1 {
+		t.Fatalf("Cancelled lookup should not fetch, [%d] fetches.", fetches)
+	}
+
+	// Cancelling the context aborts an in-flight fetch
+	started, release := make(chan struct{}), make(chan struct{})
+	blocking := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-release:
+			return der, nil, nil
+		}
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := node.ks.GetSignEnrollmentCert(ctx, []byte("inflight"), blocking)
+		done <- err
+	}()
+	<-started
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("Cancelled fetch should fail.")
+	}
+	if entry, err := node.ks.certStore.Get(utils.EncodeBase64([]byte("inflight"))); err != nil || entry != nil {
+		t.Fatalf("Cancelled fetch should store nothing [%v].", err)
+	}
+
+	// A lookup waiting for the fetch of another gives up at its deadline,
+	// leaving the fetch running
+	if err := node.ks.DeleteEnrollmentCert(id); err != nil {
+		t.Fatalf("Failed deleting cert [%s].", err)
+	}
+	started = make(chan struct{})
+	go func() {
+		_, err := node.ks.GetSignEnrollmentCert(context.Background(), id, blocking)
+		done <- err
+	}()
+	<-started
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := node.ks.GetSignEnrollmentCert(ctx, id, fetcher); err != context.DeadlineExceeded {
+		t.Fatalf("Waiting lookup should fail with [%s], got [%v].", context.DeadlineExceeded, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Shared fetch should complete [%s].", err)
+	}
+}
+
+func TestKeyStoreDBKey(t *testing.T) {
+	viper.Set("security.keystore.db.key", "it's a secret")
+	defer viper.Set("security.keystore.db.key", "")
+
+	node, err := openNodeKeyStore(NodeValidator, "sqlcipher", ksPwd)
+	if err == errSQLCipherMissing {
+		// Built against SQLite, the key must not be silently ignored
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	// Built against SQLCipher, the file is encrypted
+	raw, err := ioutil.ReadFile(node.conf.getKeyStoreFilePath())
+	if err != nil {
+		t.Fatalf("Failed reading keystore DB [%s].", err)
+	}
+	if bytes.HasPrefix(raw, []byte("SQLite format 3")) {
+		t.Fatal("Keystore DB should be encrypted.")
+	}
+}
+
+func TestKeyStorePragmas(t *testing.T) {
+	pragmas := func(node *nodeImpl) (journalMode string, synchronous, busyTimeout int) {
+		// Every connection of the pool is set up the same way
+		node.ks.sqlDB.SetMaxIdleConns(0)
+		for _, pragma := range []struct {
+			name string
+			dest interface{}
+		}{{"journal_mode", &journalMode}, {"synchronous", &synchronous}, {"busy_timeout", &busyTimeout}} {
+			if err := node.ks.sqlDB.QueryRow("PRAGMA " + pragma.name).Scan(pragma.dest); err != nil {
+				t.Fatalf("Failed reading pragma [%s]: [%s].", pragma.name, err)
+			}
+		}
+		return
+	}
+
+	// Defaults
+	node, err := openNodeKeyStore(NodeValidator, "pragmas.default", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	journalMode, synchronous, busyTimeout := pragmas(node)
+	node.ks.close()
+	if journalMode != "wal" || synchronous != 1 || busyTimeout != 5000 {
+		t.Fatalf("Expected WAL journal, NORMAL synchronous and a 5000ms busy timeout, got [%s][%d][%d].", journalMode, synchronous, busyTimeout)
+	}
+
+	viper.Set("security.keystore.db.journalMode", "truncate")
+	viper.Set("security.keystore.db.synchronous", "full")
+	viper.Set("security.keystore.db.busyTimeout", 1234)
+	defer viper.Set("security.keystore.db.journalMode", "WAL")
+	defer viper.Set("security.keystore.db.synchronous", "NORMAL")
+	defer viper.Set("security.keystore.db.busyTimeout", 5000)
+
+	node, err = openNodeKeyStore(NodeValidator, "pragmas.set", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	journalMode, synchronous, busyTimeout = pragmas(node)
+	node.ks.close()
+	if journalMode != "truncate" || synchronous != 2 || busyTimeout != 1234 {
+		t.Fatalf("Expected TRUNCATE journal, FULL synchronous and a 1234ms busy timeout, got [%s][%d][%d].", journalMode, synchronous, busyTimeout)
+	}
+
+	viper.Set("security.keystore.db.journalMode", "bogus")
+	if _, err := openNodeKeyStore(NodeValidator, "pragmas.invalid", ksPwd); err == nil || !strings.Contains(err.Error(), "Invalid SQLite journal mode") {
+		t.Fatalf("An invalid journal mode should be rejected [%v].", err)
+	}
+}
+
+func TestKeyStoreBlobs(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "blobs", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	if err := node.ks.PutBlob("nonces", "a", []byte("1")); err != nil {
+		t.Fatalf("Failed storing blob [%s].", err)
+	}
+	if err := node.ks.PutBlobs("nonces", map[string][]byte{"a": []byte("2"), "b": []byte("3")}); err != nil {
+		t.Fatalf("Failed storing blobs [%s].", err)
+	}
+	if err := node.ks.PutBlob("chainkeys", "a", []byte("4")); err != nil {
+		t.Fatalf("Failed storing blob [%s].", err)
+	}
+
+	value, err := node.ks.GetBlob("nonces", "a")
+	if err != nil || !bytes.Equal(value, []byte("2")) {
+		t.Fatalf("Blob should be replaced [%s][%v].", value, err)
+	}
+	keys, err := node.ks.ListBlobs("nonces")
+	if err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("Namespace should hold two blobs [%v][%v].", keys, err)
+	}
+
+	if err := node.ks.DeleteBlob("nonces", "a"); err != nil {
+		t.Fatalf("Failed deleting blob [%s].", err)
+	}
+	if value, err := node.ks.GetBlob("nonces", "a"); err != nil || value != nil {
+		t.Fatalf("Deleted blob should be missing [%s][%v].", value, err)
+	}
+	if value, err := node.ks.GetBlob("chainkeys", "a"); err != nil || !bytes.Equal(value, []byte("4")) {
+		t.Fatalf("Blobs of other namespaces should be kept [%s][%v].", value, err)
+	}
+
+	if err := node.ks.PutBlob("", "a", []byte("5")); err == nil {
+		t.Fatal("Empty namespaces should be rejected.")
+	}
+}
+
+func TestKeyStoreStoreCertificates(t *testing.T) {
+	node, err := openNodeKeyStore(NodeClient, "storecerts", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := migrateSchema(node.ks.sqlDB, "client", clientMigrations); err != nil {
+		t.Fatalf("Failed creating TCert tables [%s].", err)
+	}
+
+	countTCerts := func(attrhash string) int {
+		var count int
+		if err := node.ks.sqlDB.QueryRow("SELECT COUNT(*) FROM TCerts WHERE owner = ? AND attrhash = ?", node.conf.getKeyStoreOwner(), attrhash).Scan(&count); err != nil {
+			t.Fatalf("Failed counting TCerts [%s].", err)
+		}
+		return count
+	}
+
+	records := make([]CertRecord, 100)
+	for i := range records {
+		records[i] = CertRecord{AttributesHash: "batch", Cert: []byte(fmt.Sprintf("tcert%d", i)), PreK0: []byte("prek0")}
+	}
+	if err := node.ks.StoreCertificates(records); err != nil {
+		t.Fatalf("Failed storing batch [%s].", err)
+	}
+	if count := countTCerts("batch"); count != len(records) {
+		t.Fatalf("Expected [%d] TCerts, got [%d].", len(records), count)
+	}
+	if err := node.ks.StoreCertificates(nil); err != nil {
+		t.Fatalf("Storing an empty batch should succeed [%s].", err)
+	}
+
+	// A TCert listed twice in a batch is stored once
+	duplicates := []CertRecord{
+		{AttributesHash: "duplicates", Cert: []byte("tcert")},
+		{AttributesHash: "duplicates", Cert: []byte("tcert")},
+		{AttributesHash: "duplicates", Cert: []byte("other")},
+	}
+	if err := node.ks.StoreCertificates(duplicates); err != nil {
+		t.Fatalf("Failed storing batch [%s].", err)
+	}
+	if count := countTCerts("duplicates"); count != 2 {
+		t.Fatalf("Duplicate TCert should be stored once, got [%d] TCerts.", count)
+	}
+
+	// A failing insert rolls the whole batch back
+	if _, err := node.ks.sqlDB.Exec("CREATE TRIGGER failTCert BEFORE INSERT ON TCerts WHEN NEW.cert = X'6661696c' BEGIN SELECT RAISE(ABORT, 'fail'); END"); err != nil {
+		t.Fatalf("Failed creating trigger [%s].", err)
+	}
+	defer node.ks.sqlDB.Exec("DROP TRIGGER IF EXISTS failTCert")
+	partial := []CertRecord{
+		{AttributesHash: "partial", Cert: []byte("first")},
+		{AttributesHash: "partial", Cert: []byte("fail")},
+		{AttributesHash: "partial", Cert: []byte("last")},
+	}
+	if err := node.ks.StoreCertificates(partial); err == nil {
+		t.Fatal("Batch should fail on a failing insert.")
+	}
+	if count := countTCerts("partial"); count != 0 {
+		t.Fatalf("Failed batch should be rolled back, got [%d] TCerts.", count)
+	}
+}
+
+func TestKeyStoreWithTx(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "withtx", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+
+	// A failing transaction stores nothing
+	errAbort := errors.New("abort")
+	err = node.ks.WithTx(func(tx CertTx) error {
+		if err := tx.StorePrivateKey("withtx.key", key); err != nil {
+			return err
+		}
+		if err := tx.PutBlob("withtx", "meta", []byte("1")); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("WithTx should return the error of its function [%v].", err)
+	}
+	if node.ks.isKeySet("withtx.key") {
+		t.Fatal("Key should be rolled back.")
+	}
+	if value, err := node.ks.GetBlob("withtx", "meta"); err != nil || value != nil {
+		t.Fatalf("Blob should be rolled back [%s][%v].", value, err)
+	}
+
+	err = node.ks.WithTx(func(tx CertTx) error {
+		if err := tx.StorePrivateKey("withtx.key", key); err != nil {
+			return err
+		}
+
+		return tx.PutBlob("withtx", "meta", []byte("1"))
+	})
+	if err != nil {
+		t.Fatalf("Failed committing transaction [%s].", err)
+	}
+	if _, err := node.ks.loadPrivateKey("withtx.key"); err != nil {
+		t.Fatalf("Key should be committed [%s].", err)
+	}
+	if value, err := node.ks.GetBlob("withtx", "meta"); err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Fatalf("Blob should be committed [%s][%v].", value, err)
+	}
+}
+
+func TestKeyStoreEncryption(t *testing.T) {
+	viper.Set("security.keystore.encryption.enabled", true)
+	viper.Set("security.keystore.encryption.passphrase", "secret")
+	defer viper.Set("security.keystore.encryption.enabled", false)
+	defer viper.Set("security.keystore.encryption.passphrase", "")
+
+	node, err := openNodeKeyStore(NodeValidator, "encryption", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if err := node.ks.PutBlob("encryption.test", "a", []byte("plaintext")); err != nil {
+		t.Fatalf("Failed storing blob [%s].", err)
+	}
+	var raw []byte
+	if err := node.ks.sqlDB.QueryRow("SELECT value FROM Blobs WHERE namespace = ? AND key = ?", "encryption.test", "a").Scan(&raw); err != nil {
+		t.Fatalf("Failed reading blob [%s].", err)
+	}
+	if bytes.Contains(raw, []byte("plaintext")) {
+		t.Fatal("Blob should be stored encrypted.")
+	}
+
+	// The DEK file is readable by the owner only, and carries the KEK derivation parameters
+	path := node.conf.getPathForAlias(node.conf.getKeyStoreDEKFilename())
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed reading data encryption key [%s].", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Data encryption key should be stored with mode 0600, got [%s].", info.Mode().Perm())
+	}
+	wrapped, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed reading data encryption key [%s].", err)
+	}
+	if binary.BigEndian.Uint32(wrapped[ksKEKSaltLen:ksKEKParamsLen]) != ksKEKIterations {
+		t.Fatal("Data encryption key should carry the iteration count of the KEK derivation.")
+	}
+
+	sealed, err := node.ks.sealWithPassphrase([]byte("secret"), []byte("data"))
+	if err != nil {
+		t.Fatalf("Failed sealing data [%s].", err)
+	}
+	if data, err := node.ks.unsealWithPassphrase([]byte("secret"), sealed); err != nil || !bytes.Equal(data, []byte("data")) {
+		t.Fatalf("Failed unsealing data [%s][%v].", data, err)
+	}
+	if _, err := node.ks.unsealWithPassphrase([]byte("wrong"), sealed); err == nil {
+		t.Fatal("Data should not be unsealed with a wrong passphrase.")
+	}
+	node.ks.close()
+
+	viper.Set("security.keystore.encryption.passphrase", "wrong")
+	if _, err := openNodeKeyStore(NodeValidator, "encryption", ksPwd); err == nil {
+		t.Fatal("Keystore should not open with a wrong passphrase.")
+	}
+
+	viper.Set("security.keystore.encryption.passphrase", "secret")
+	node, err = openNodeKeyStore(NodeValidator, "encryption", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore again [%s].", err)
+	}
+	defer node.ks.close()
+	if value, err := node.ks.GetBlob("encryption.test", "a"); err != nil || !bytes.Equal(value, []byte("plaintext")) {
+		t.Fatalf("Blob should be decrypted [%s][%v].", value, err)
+	}
+}
+
+func TestKeyStoreBackupRestore(t *testing.T) {
+	defer viper.Set("security.keystore.encryption.enabled", false)
+	defer viper.Set("security.keystore.encryption.passphrase", "")
+
+	for _, encrypted := range []bool{false, true} {
+		viper.Set("security.keystore.encryption.enabled", encrypted)
+		viper.Set("security.keystore.encryption.passphrase", "keystore")
+		name := fmt.Sprintf("backup.%t", encrypted)
+
+		node, err := openNodeKeyStore(NodeValidator, name, ksPwd)
+		if err != nil {
+			t.Fatalf("Failed opening keystore [%s].", err)
+		}
+		if err := node.ks.PutBlob("backup.test", "a", []byte("1")); err != nil {
+			t.Fatalf("Failed storing blob [%s].", err)
+		}
+		if err := node.ks.storeCertPEM("backup.cert", []byte("certificate")); err != nil {
+			t.Fatalf("Failed storing certificate [%s].", err)
+		}
+		node.ks.close()
+
+		for _, passphrase := range [][]byte{nil, []byte("backup")} {
+			var backup bytes.Buffer
+			if err := BackupKeyStore(NodeValidator, name, ksPwd, passphrase, &backup); err != nil {
+				t.Fatalf("Failed backing up keystore [%s].", err)
+			}
+			if passphrase != nil && bytes.Contains(backup.Bytes(), []byte("certificate")) {
+				t.Fatal("Encrypted backup should not contain the certificate in the clear.")
+			}
+
+			// Changes made after the backup are undone by the restore
+			node, err := openNodeKeyStore(NodeValidator, name, ksPwd)
+			if err != nil {
+				t.Fatalf("Failed opening keystore [%s].", err)
+			}
+			if err := node.ks.PutBlob("backup.test", "a", []byte("2")); err != nil {
+				t.Fatalf("Failed storing blob [%s].", err)
+			}
+			if err := node.ks.storeCertPEM("backup.cert", []byte("changed")); err != nil {
+				t.Fatalf("Failed storing certificate [%s].", err)
+			}
+			node.ks.close()
+
+			if passphrase != nil {
+				if err := RestoreKeyStore(NodeValidator, name, ksPwd, nil, bytes.NewReader(backup.Bytes())); err == nil {
+					t.Fatal("Encrypted backup should not be restored without a passphrase.")
+				}
+				if err := RestoreKeyStore(NodeValidator, name, ksPwd, []byte("wrong"), bytes.NewReader(backup.Bytes())); err == nil {
+					t.Fatal("Encrypted backup should not be restored with a wrong passphrase.")
+				}
+			}
+			if err := RestoreKeyStore(NodeValidator, name, ksPwd, passphrase, &backup); err != nil {
+				t.Fatalf("Failed restoring keystore [%s].", err)
+			}
+
+			node, err = openNodeKeyStore(NodeValidator, name, ksPwd)
+			if err != nil {
+				t.Fatalf("Failed opening restored keystore [%s].", err)
+			}
+			if value, err := node.ks.GetBlob("backup.test", "a"); err != nil || !bytes.Equal(value, []byte("1")) {
+				t.Fatalf("Blob should be restored [%s][%v].", value, err)
+			}
+			if pem, err := node.ks.loadCertPEM("backup.cert"); err != nil || !bytes.Equal(pem, []byte("certificate")) {
+				t.Fatalf("Certificate should be restored [%s][%v].", pem, err)
+			}
+			node.ks.close()
+		}
+	}
+
+	if err := RestoreKeyStore(NodeValidator, "backup.false", ksPwd, nil, bytes.NewReader([]byte("garbage"))); err != errInvalidKeyStoreBackup {
+		t.Fatalf("A corrupt backup should be rejected [%v].", err)
+	}
+}
+
+func TestKeyStoreRekey(t *testing.T) {
+	viper.Set("security.keystore.encryption.enabled", true)
+	viper.Set("security.keystore.encryption.passphrase", "old")
+	defer viper.Set("security.keystore.encryption.enabled", false)
+	defer viper.Set("security.keystore.encryption.passphrase", "")
+
+	node, err := openNodeKeyStore(NodeValidator, "rekey", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if err := node.ks.PutBlobs("rekey.test", map[string][]byte{"a": []byte("1"), "b": []byte("2")}); err != nil {
+		t.Fatalf("Failed storing blobs [%s].", err)
+	}
+
+	// A BLOB failing decryption aborts the rekey
+	if _, err := node.ks.sqlDB.Exec("UPDATE Blobs SET value = ? WHERE namespace = ? AND key = ?", []byte("garbage"), "rekey.test", "b"); err != nil {
+		t.Fatalf("Failed corrupting blob [%s].", err)
+	}
+	if err := node.ks.rekey([]byte("new")); err == nil {
+		t.Fatal("Rekey should fail on a corrupt blob.")
+	}
+	if value, err := node.ks.GetBlob("rekey.test", "a"); err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Fatalf("Blobs should be left unchanged [%s][%v].", value, err)
+	}
+	if err := node.ks.DeleteBlob("rekey.test", "b"); err != nil {
+		t.Fatalf("Failed deleting blob [%s].", err)
+	}
+	node.ks.close()
+
+	if err := RekeyKeyStore(NodeValidator, "rekey", ksPwd, []byte("new")); err != nil {
+		t.Fatalf("Failed rekeying keystore [%s].", err)
+	}
+
+	if _, err := openNodeKeyStore(NodeValidator, "rekey", ksPwd); err == nil {
+		t.Fatal("Keystore should not open with the old passphrase.")
+	}
+
+	viper.Set("security.keystore.encryption.passphrase", "new")
+	node, err = openNodeKeyStore(NodeValidator, "rekey", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore with the new passphrase [%s].", err)
+	}
+	defer node.ks.close()
+	if value, err := node.ks.GetBlob("rekey.test", "a"); err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Fatalf("Blobs should be re-encrypted [%s][%v].", value, err)
+	}
+	if pending, err := node.ks.getPendingDataEncryptionKey(); err != nil || pending != nil {
+		t.Fatalf("Data encryption key journal should be cleared [%v].", err)
+	}
+}
+
+func TestKeyStoreWipeSecrets(t *testing.T) {
+	viper.Set("security.keystore.encryption.enabled", true)
+	viper.Set("security.keystore.encryption.passphrase", "wipe")
+	defer viper.Set("security.keystore.encryption.enabled", false)
+	defer viper.Set("security.keystore.encryption.passphrase", "")
+
+	node, err := openNodeKeyStore(NodeValidator, "wipe", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if node.ks.dek == nil || !bytes.Equal(node.ks.pwd, ksPwd) {
+		t.Fatal("Keystore secrets should be set.")
+	}
+
+	// The DEK replaced by a rekey is wiped
+	dekBuf := node.ks.dekBuf
+	if err := node.ks.rekey([]byte("wipe")); err != nil {
+		t.Fatalf("Failed rekeying keystore [%s].", err)
+	}
+	if dekBuf.Bytes() != nil || node.ks.dek == nil {
+		t.Fatal("Old data encryption key should be destroyed.")
+	}
+
+	// The enrollment keys are wiped on close
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	chainKey := []byte("enrollment chain key")
+	node.enrollPrivKey, node.enrollChainKey = key, chainKey
+	if err := node.close(); err != nil {
+		t.Fatalf("Failed closing node [%s].", err)
+	}
+	if key.D.Sign() != 0 || node.enrollPrivKey != nil {
+		t.Fatal("Enrollment key should be wiped.")
+	}
+	if !bytes.Equal(chainKey, make([]byte, len(chainKey))) || node.enrollChainKey != nil {
+		t.Fatal("Enrollment chain key should be wiped.")
+	}
+	if node.ks.pwd != nil || node.ks.dek != nil {
+		t.Fatal("Keystore secrets should be wiped.")
+	}
+}
+
+// newVaultServer returns a fake Vault key/value store, requiring token.
+// The secrets under fail/ answer with an internal server error.
+func newVaultServer(token string) *httptest.Server {
+	var mutex sync.Mutex
+	secrets := make(map[string][]byte)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/v1/secret/fail/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			body, ok := secrets[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"data":%s}`, body)
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			secrets[r.URL.Path] = body
+			w.WriteHeader(http.StatusNoContent)
+		case "DELETE":
+			delete(secrets, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestVaultSecretStore(t *testing.T) {
+	server := newVaultServer("token")
+	defer server.Close()
+
+	if _, err := newSecretStore("vault", &SecretStoreConfig{}); err == nil {
+		t.Fatal("Vault secret store should require an address.")
+	}
+
+	store, err := newSecretStore("vault", &SecretStoreConfig{Address: server.URL + "/", Token: "token", Path: "/secret/", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed opening secret store [%s].", err)
+	}
+
+	if secret, err := store.Get("node/missing"); err != nil || secret != nil {
+		t.Fatalf("A missing secret should be nil [%s][%v].", secret, err)
+	}
+	if err := store.Put("node/key", []byte("secret")); err != nil {
+		t.Fatalf("Failed storing secret [%s].", err)
+	}
+	if secret, err := store.Get("node/key"); err != nil || !bytes.Equal(secret, []byte("secret")) {
+		t.Fatalf("Failed fetching secret [%s][%v].", secret, err)
+	}
+	if err := store.Delete("node/key"); err != nil {
+		t.Fatalf("Failed deleting secret [%s].", err)
+	}
+	if secret, err := store.Get("node/key"); err != nil || secret != nil {
+		t.Fatalf("A deleted secret should be nil [%s][%v].", secret, err)
+	}
+
+	// Answers other than OK, No Content and, for reads and deletes, Not Found fail
+	if _, err := store.Get("fail/key"); err == nil {
+		t.Fatal("Get should fail on an internal server error.")
+	}
+	if err := store.Put("fail/key", []byte("secret")); err == nil {
+		t.Fatal("Put should fail on an internal server error.")
+	}
+	if err := store.Delete("fail/key"); err == nil {
+		t.Fatal("Delete should fail on an internal server error.")
+	}
+	unauthorized, err := newSecretStore("vault", &SecretStoreConfig{Address: server.URL, Token: "wrong", Path: "secret"})
+	if err != nil {
+		t.Fatalf("Failed opening secret store [%s].", err)
+	}
+	if _, err := unauthorized.Get("node/key"); err == nil {
+		t.Fatal("Get should fail with a wrong token.")
+	}
+}
+
+func TestKeyStoreSecretStore(t *testing.T) {
+	server := newVaultServer("token")
+	defer server.Close()
+
+	viper.Set("security.keystore.secrets.backend", "vault")
+	viper.Set("security.keystore.secrets.address", server.URL)
+	viper.Set("security.keystore.secrets.token", "token")
+	viper.Set("security.keystore.secrets.path", "secret")
+	defer viper.Set("security.keystore.secrets.backend", "")
+
+	node, err := openNodeKeyStore(NodeValidator, "secrets", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	// Certificates go to the secret store, not to the file system
+	if err := node.ks.storeCertPEM("secrets.cert", []byte("pem")); err != nil {
+		t.Fatalf("Failed storing certificate [%s].", err)
+	}
+	if _, err := os.Stat(node.conf.getPathForAlias("secrets.cert")); !os.IsNotExist(err) {
+		t.Fatal("Certificate should not be stored on the file system.")
+	}
+	if pem, err := node.ks.loadCertPEM("secrets.cert"); err != nil || !bytes.Equal(pem, []byte("pem")) {
+		t.Fatalf("Failed loading certificate [%s][%v].", pem, err)
+	}
+	if _, err := node.ks.loadCertPEM("secrets.missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("A missing certificate should not be found [%v].", err)
+	}
+}
+
+type countingCSP struct {
+	CSP
+
+	signs int
+}
+
+func (csp *countingCSP) Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	csp.signs++
+
+	return csp.CSP.Sign(signKey, msg)
+}
+
+func TestNodeHSMConfiguration(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "crypto-hsm")
+	if err != nil {
+		t.Fatalf("Failed creating data path [%s].", err)
+	}
+	defer os.RemoveAll(dataPath)
+
+	config := viper.New()
+	config.Set("peer.fileSystemPath", dataPath)
+	for _, property := range []string{"peer.pki.eca.paddr", "peer.pki.tca.paddr", "peer.pki.tlsca.paddr"} {
+		config.Set(property, viper.GetString(property))
+	}
+	config.Set("security.hsm.library", "/nonexistent/libpkcs11.so")
+	config.Set("security.hsm.label", "fabric")
+	config.Set("security.hsm.pin", "1234")
+
+	// HSM mode is off unless enabled
+	node := &nodeImpl{eType: NodeValidator, opts: Options{Config: config}}
+	if err := node.initConfiguration("hsm"); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if node.conf.isHSMEnabled() {
+		t.Fatal("HSM mode should be disabled by default.")
+	}
+	if err := node.initHSM(); err != nil || node.hsm != nil {
+		t.Fatalf("No token should be opened with HSM mode disabled [%v].", err)
+	}
+
+	config.Set("security.hsm.enabled", true)
+	if err := node.initConfiguration("hsm"); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if !node.conf.isHSMEnabled() || node.conf.getHSMLibrary() != "/nonexistent/libpkcs11.so" || node.conf.getHSMLabel() != "fabric" || node.conf.getHSMPin() != "1234" {
+		t.Fatal("HSM settings should be read from the configuration.")
+	}
+	if err := node.initHSM(); err == nil || !strings.Contains(err.Error(), "Failed loading PKCS#11 library") {
+		t.Fatalf("A missing PKCS#11 library should fail [%v].", err)
+	}
+
+	// Clients and the other signature schemes are not supported
+	client := &nodeImpl{eType: NodeClient, opts: Options{Config: config}}
+	if err := client.initConfiguration("hsm"); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if err := client.initHSM(); err == nil {
+		t.Fatal("HSM mode should not be supported for clients.")
+	}
+	config.Set("security.signatureScheme", signatureSchemeEd25519)
+	if err := node.initConfiguration("hsm"); err != nil {
+		t.Fatalf("Failed initializing configuration [%s].", err)
+	}
+	if err := node.initHSM(); err == nil {
+		t.Fatal("HSM mode should not be supported for Ed25519.")
+	}
+}
+
+// softHSMLibraries are the usual install paths of the SoftHSM library
+var softHSMLibraries = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+}
+
+// TestNodeHSMSoftHSM runs against a SoftHSM token, labelled as
+// SOFTHSM_LABEL, whose user PIN is SOFTHSM_PIN. The library is looked up
+// at SOFTHSM_LIB, then at the usual install paths.
+func TestNodeHSMSoftHSM(t *testing.T) {
+	library := os.Getenv("SOFTHSM_LIB")
+	if library == "" {
+		for _, path := range softHSMLibraries {
+			if _, err := os.Stat(path); err == nil {
+				library = path
+				break
+			}
+		}
+	}
+	if library == "" {
+		t.Skip("SoftHSM not installed.")
+	}
+	label, pin := os.Getenv("SOFTHSM_LABEL"), os.Getenv("SOFTHSM_PIN")
+	if label == "" || pin == "" {
+		t.Skip("SoftHSM token not configured, set SOFTHSM_LABEL and SOFTHSM_PIN.")
+	}
+
+	hsm, err := openHSM(library, label, pin)
+	if err != nil {
+		t.Fatalf("Failed opening token [%s].", err)
+	}
+	defer hsm.close()
+
+	key, err := hsm.generateKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	found, err := hsm.findKey(key.handle())
+	if err != nil {
+		t.Fatalf("Failed finding key [%s].", err)
+	}
+	if found.pub.X.Cmp(key.pub.X) != 0 || found.pub.Y.Cmp(key.pub.Y) != 0 {
+		t.Fatal("Found key should be the generated one.")
+	}
+
+	csp := &hsmCSP{CSP: &swCSP{scheme: signatureSchemeECDSA}, hsm: hsm}
+	msg := []byte("Hello World!!!")
+	signature, err := csp.Sign(found, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	if ok, err := csp.Verify(key.pub, msg, signature); err != nil || !ok {
+		t.Fatalf("Failed verifying signature of the token [%v].", err)
+	}
+
+	if _, err := hsm.findKey("00"); err == nil {
+		t.Fatal("An unknown key should not be found.")
+	}
+}
+
+func TestNodeCSP(t *testing.T) {
+	counting := &countingCSP{CSP: &swCSP{}}
+	factory := func(conf *CSPConfig) (CSP, error) { return counting, nil }
+	if err := RegisterCSP("counting", factory); err != nil {
+		t.Fatalf("Failed registering provider [%s].", err)
+	}
+	// The test runs once per scenario
+	defer func() {
+		cspFactoriesMutex.Lock()
+		delete(cspFactories, "counting")
+		cspFactoriesMutex.Unlock()
+	}()
+	if err := RegisterCSP("counting", factory); err == nil {
+		t.Fatal("Registering a provider twice should fail.")
+	}
+
+	viper.Set("security.csp.provider", "unknown")
+	defer viper.Set("security.csp.provider", "")
+	if _, err := openNodeKeyStore(NodeValidator, "csp", ksPwd); err == nil {
+		t.Fatal("Opening a node with an unknown provider should fail.")
+	}
+
+	viper.Set("security.csp.provider", "counting")
+	node, err := openNodeKeyStore(NodeValidator, "csp", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	key, _, err := node.csp.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	msg := []byte("Hello World")
+	signature, err := node.sign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	if ok, err := node.verify(&key.(*ecdsa.PrivateKey).PublicKey, msg, signature); err != nil || !ok {
+		t.Fatalf("Failed verifying signature [%v].", err)
+	}
+	if counting.signs != 1 {
+		t.Fatalf("Signatures should go through the configured provider [%d].", counting.signs)
+	}
+}
+
+func TestNodeCompleteReenrollment(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "reenroll", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	der, key, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	pem, err := primitives.PrivateKeyToPEM(key, node.ks.pwd)
+	if err != nil {
+		t.Fatalf("Failed converting key to PEM [%s].", err)
+	}
+
+	// A re-enrollment journaled but not completed before a stop
+	if err := node.ks.PutBlobs(reenrollmentNamespace, map[string][]byte{"cert": der, "key": pem}); err != nil {
+		t.Fatalf("Failed journaling re-enrollment [%s].", err)
+	}
+	if err := node.completeReenrollment(); err != nil {
+		t.Fatalf("Failed completing re-enrollment [%s].", err)
+	}
+
+	_, certDER, err := node.ks.loadCertX509AndDer(node.conf.getEnrollmentCertFilename())
+	if err != nil || !bytes.Equal(certDER, der) {
+		t.Fatalf("Enrollment cert should be swapped [%v].", err)
+	}
+	loaded, err := node.ks.loadPrivateKey(node.conf.getEnrollmentKeyFilename())
+	if err != nil || !reflect.DeepEqual(loaded, key) {
+		t.Fatalf("Enrollment key should be swapped [%v].", err)
+	}
+	names, err := node.ks.ListBlobs(reenrollmentNamespace)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("Re-enrollment journal should be cleared [%v][%v].", names, err)
+	}
+
+	// Nothing to complete
+	if err := node.completeReenrollment(); err != nil {
+		t.Fatalf("Completing no re-enrollment should succeed [%s].", err)
+	}
+}
+
+func TestNodeCompleteTLSRenewal(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "tlsrenewal", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	if _, err := node.GetTLSCertificate(nil); err != errTLSCertificateMissing {
+		t.Fatalf("No TLS certificate should be served before loading [%v].", err)
+	}
+
+	der, key, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	pem, err := primitives.PrivateKeyToPEM(key, nil)
+	if err != nil {
+		t.Fatalf("Failed converting key to PEM [%s].", err)
+	}
+
+	// A renewal journaled but not completed before a stop
+	if err := node.ks.PutBlobs(tlsRenewalNamespace, map[string][]byte{"cert": der, "key": pem}); err != nil {
+		t.Fatalf("Failed journaling TLS certificate renewal [%s].", err)
+	}
+	if err := node.completeTLSRenewal(); err != nil {
+		t.Fatalf("Failed completing TLS certificate renewal [%s].", err)
+	}
+	names, err := node.ks.ListBlobs(tlsRenewalNamespace)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("TLS certificate renewal journal should be cleared [%v][%v].", names, err)
+	}
+
+	// The renewed key pair is the one served
+	if err := node.loadTLSCertificate(); err != nil {
+		t.Fatalf("Failed loading TLS certificate [%s].", err)
+	}
+	keyPair, err := node.GetTLSCertificate(nil)
+	if err != nil {
+		t.Fatalf("Failed getting TLS certificate [%s].", err)
+	}
+	if !bytes.Equal(keyPair.Certificate[0], der) || !reflect.DeepEqual(keyPair.PrivateKey, key) {
+		t.Fatal("TLS certificate should be swapped.")
+	}
+
+	// Expiring within the renewal window, a renewal is due
+	if !node.isTLSRenewalDue() {
+		t.Fatal("TLS certificate should be due for renewal.")
+	}
+}
+
+func TestNodeRetiredEnrollmentKey(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "retired", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	defer node.stopRetireTimer()
+
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	pem, err := primitives.PrivateKeyToPEM(key, node.ks.pwd)
+	if err != nil {
+		t.Fatalf("Failed converting key to PEM [%s].", err)
+	}
+	retire := func(until time.Time) {
+		err := node.ks.PutBlobs(retiredEnrollmentNamespace, map[string][]byte{
+			"key":   pem,
+			"until": []byte(strconv.FormatInt(until.UnixNano(), 10)),
+		})
+		if err != nil {
+			t.Fatalf("Failed storing retired key [%s].", err)
+		}
+	}
+
+	// Within the overlap window, the retired key is reloaded
+	retire(time.Now().Add(time.Hour))
+	if err := node.initRetiredEnrollmentKey(); err != nil {
+		t.Fatalf("Failed loading retired key [%s].", err)
+	}
+	if !reflect.DeepEqual(node.retiredEnrollPrivKey, key) {
+		t.Fatal("Retired key should be reloaded.")
+	}
+	reloaded := node.retiredEnrollPrivKey
+
+	// Past the overlap window, the retired key is dropped
+	retire(time.Now().Add(-time.Second))
+	if err := node.initRetiredEnrollmentKey(); err != nil {
+		t.Fatalf("Failed retiring key [%s].", err)
+	}
+	if node.retiredEnrollPrivKey != nil || reloaded.D.Sign() != 0 {
+		t.Fatal("Retired key should be dropped and wiped.")
+	}
+	names, err := node.ks.ListBlobs(retiredEnrollmentNamespace)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("Retired key should be removed [%v][%v].", names, err)
+	}
+
+	// A retired key replaced by another rotation is wiped
+	replaced, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	node.scheduleRetirement(replaced, time.Now().Add(time.Hour))
+	node.scheduleRetirement(key, time.Now().Add(10*time.Millisecond))
+	if replaced.D.Sign() != 0 {
+		t.Fatal("Replaced retired key should be wiped.")
+	}
+
+	// The retirement timer drops the key at the end of the window
+	time.Sleep(100 * time.Millisecond)
+	node.enrollMutex.RLock()
+	retired := node.retiredEnrollPrivKey
+	node.enrollMutex.RUnlock()
+	if retired != nil || key.D.Sign() != 0 {
+		t.Fatal("Retired key should be dropped and wiped when the timer fires.")
+	}
+}
+
+func TestKeyStoreAuditLog(t *testing.T) {
+	viper.Set("security.keystore.audit.enabled", true)
+	defer viper.Set("security.keystore.audit.enabled", false)
+
+	node, err := openNodeKeyStore(NodeValidator, "audit", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		return der, nil, nil
+	}
+
+	// A miss fetches from the ECA and caches, a hit is not audited
+	for i := 0; i < 2; i++ {
+		if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil {
+			t.Fatalf("Failed getting cert [%s].", err)
+		}
+	}
+
+	records, err := node.ks.queryAuditLog(&AuditFilter{})
+	if err != nil {
+		t.Fatalf("Failed querying audit log [%s].", err)
+	}
+	if len(records) != 2 || records[0].Event != AuditECAFetch || records[1].Event != AuditCacheInsert {
+		t.Fatalf("Audit log should record a fetch then a cache insert [%v].", records)
+	}
+	for _, record := range records {
+		if record.Subject != utils.EncodeBase64(id) || record.Caller == "" || record.Time.IsZero() {
+			t.Fatalf("Invalid audit record [%v].", record)
+		}
+	}
+
+	// Filters
+	records, err = node.ks.queryAuditLog(&AuditFilter{Event: AuditCacheInsert})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Event filter should select one record [%v][%v].", records, err)
+	}
+	records, err = node.ks.queryAuditLog(&AuditFilter{Limit: 1})
+	if err != nil || len(records) != 1 || records[0].Event != AuditCacheInsert {
+		t.Fatalf("Limit should select the most recent record [%v][%v].", records, err)
+	}
+	records, err = node.ks.queryAuditLog(&AuditFilter{Since: time.Now()})
+	if err != nil || len(records) != 0 {
+		t.Fatalf("Since filter should select no record [%v][%v].", records, err)
+	}
+}
+
+func TestKeyStoreSignatureLog(t *testing.T) {
+	viper.Set("security.keystore.audit.signatures.enabled", true)
+	viper.Set("security.keystore.audit.signatures.maxSize", 1024)
+	viper.Set("security.keystore.audit.signatures.maxFiles", 3)
+	defer func() {
+		viper.Set("security.keystore.audit.signatures.enabled", false)
+		viper.Set("security.keystore.audit.signatures.maxSize", 0)
+		viper.Set("security.keystore.audit.signatures.maxFiles", 0)
+	}()
+
+	node, err := openNodeKeyStore(NodeValidator, "signlog", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	n := 20
+	for i := 0; i < n; i++ {
+		node.logSignature("tcert/test", []byte(fmt.Sprintf("tx%d", i)))
+	}
+
+	// The log got rotated, and the oldest records dropped
+	path := node.conf.getSignatureLogPath()
+	if _, err := os.Stat(rotatedSignatureLogPath(path, 2)); err != nil {
+		t.Fatalf("Signature log should have been rotated twice [%s].", err)
+	}
+	if _, err := os.Stat(rotatedSignatureLogPath(path, 3)); err == nil {
+		t.Fatal("Signature log should keep at most 3 files.")
+	}
+
+	records, err := node.ks.querySignatureLog(&SignatureFilter{})
+	if err != nil {
+		t.Fatalf("Failed querying signature log [%s].", err)
+	}
+	if len(records) == 0 || len(records) >= n {
+		t.Fatalf("Signature log should hold the most recent records only [%d].", len(records))
+	}
+	for i, record := range records {
+		digest := utils.EncodeBase64(primitives.Hash([]byte(fmt.Sprintf("tx%d", n-len(records)+i))))
+		if record.Key != "tcert/test" || record.Digest != digest || record.Caller == "" || record.Time.IsZero() {
+			t.Fatalf("Invalid signature record [%v].", record)
+		}
+	}
+
+	// Filters
+	records, err = node.ks.querySignatureLog(&SignatureFilter{Limit: 1})
+	if err != nil || len(records) != 1 || records[0].Digest != utils.EncodeBase64(primitives.Hash([]byte(fmt.Sprintf("tx%d", n-1)))) {
+		t.Fatalf("Limit should select the most recent record [%v][%v].", records, err)
+	}
+	records, err = node.ks.querySignatureLog(&SignatureFilter{Key: "enrollment"})
+	if err != nil || len(records) != 0 {
+		t.Fatalf("Key filter should select no record [%v][%v].", records, err)
+	}
+
+	// A record inserted breaks the chain
+	file, err := os.OpenFile(rotatedSignatureLogPath(path, 1), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed opening signature log [%s].", err)
+	}
+	file.WriteString(utils.EncodeBase64([]byte("forged")) + "\n")
+	file.Close()
+
+	_, err = node.ks.querySignatureLog(&SignatureFilter{})
+	if _, ok := err.(*ErrSignatureLogTampered); !ok {
+		t.Fatalf("Querying a tampered signature log should fail [%v].", err)
+	}
+}
+
+func TestKeyStoreListCertificates(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "certinfo", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if err := node.ks.openCertStore(); err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+
+	der, id := newTestECACert(t, node)
+	x509Cert, err := primitives.DERToX509Certificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing cert [%s].", err)
+	}
+	fetcher := func(ctx context.Context, id []byte) ([]byte, []byte, error) {
+		return der, nil, nil
+	}
+	if _, err := node.ks.GetSignEnrollmentCert(context.Background(), id, fetcher); err != nil {
+		t.Fatalf("Failed getting cert [%s].", err)
+	}
+
+	// An entry stored before metadata were recorded
+	encCertSign, err := node.ks.encryptBlob(der)
+	if err != nil {
+		t.Fatalf("Failed encrypting cert [%s].", err)
+	}
+	if err := node.ks.certStore.Put(utils.EncodeBase64([]byte("legacy")), &CertEntry{CertSign: encCertSign}); err != nil {
+		t.Fatalf("Failed storing cert [%s].", err)
+	}
+
+	infos, err := node.ks.listCertificates(nil)
+	if err != nil {
+		t.Fatalf("Failed listing certificates [%s].", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Two certificates should be listed [%v].", infos)
+	}
+	for _, info := range infos {
+		if info.Type != CertTypeECert ||
+			info.SerialNumber != x509Cert.SerialNumber.String() ||
+			info.Issuer != utils.EncodeBase64(x509Cert.RawIssuer) ||
+			info.NotBefore.Unix() != x509Cert.NotBefore.Unix() ||
+			info.NotAfter.Unix() != x509Cert.NotAfter.Unix() {
+			t.Fatalf("Invalid metadata for [%s] [%v].", info.ID, info)
+		}
+	}
+
+	// Filters
+	infos, err = node.ks.listCertificates(&CertInfoFilter{Type: CertTypeTCert})
+	if err != nil || len(infos) != 0 {
+		t.Fatalf("Type filter should select no certificate [%v][%v].", infos, err)
+	}
+	infos, err = node.ks.listCertificates(&CertInfoFilter{ExpiresBefore: x509Cert.NotAfter.Add(time.Second)})
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("Expiry filter should select both certificates [%v][%v].", infos, err)
+	}
+	infos, err = node.ks.listCertificates(&CertInfoFilter{ExpiresBefore: x509Cert.NotAfter})
+	if err != nil || len(infos) != 0 {
+		t.Fatalf("Expiry filter should select no certificate [%v][%v].", infos, err)
+	}
+	infos, err = node.ks.listCertificates(&CertInfoFilter{SerialNumber: x509Cert.SerialNumber.String(), Limit: 1})
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("Limit should select one certificate [%v][%v].", infos, err)
+	}
+}
+
+func TestCertLRU(t *testing.T) {
+	certs := make([]*x509.Certificate, 3)
+	for i := range certs {
+		der, _, err := primitives.NewSelfSignedCert()
+		if err != nil {
+			t.Fatalf("Failed generating self-signed cert [%s].", err)
+		}
+		if certs[i], err = primitives.DERToX509Certificate(der); err != nil {
+			t.Fatalf("Failed parsing cert [%s].", err)
+		}
+	}
+
+	cache := newCertLRU(2)
+	cache.put("0", certs[0])
+	cache.put("1", certs[1])
+
+	// Using 0 makes 1 the least recently used, evicted by 2
+	if cache.get("0") != certs[0] {
+		t.Fatal("Cert 0 should be cached.")
+	}
+	cache.put("2", certs[2])
+	if cache.len() != 2 || cache.get("1") != nil || cache.get("0") != certs[0] || cache.get("2") != certs[2] {
+		t.Fatal("The least recently used cert should be evicted.")
+	}
+
+	cache.delete("0")
+	if cache.len() != 1 || cache.get("0") != nil {
+		t.Fatal("Cert 0 should be deleted.")
+	}
+
+	// Unbounded
+	cache = newCertLRU(0)
+	for i, cert := range certs {
+		cache.put(fmt.Sprintf("%d", i), cert)
+	}
+	if cache.len() != len(certs) {
+		t.Fatalf("Unbounded cache should keep every cert, [%d] kept.", cache.len())
+	}
+}
+
+func TestTrustedCAs(t *testing.T) {
+	newCert := func(cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := primitives.NewECDSAKey()
+		if err != nil {
+			t.Fatalf("Failed generating key [%s].", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			IsCA:                  parent == nil,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatalf("Failed creating cert [%s].", err)
+		}
+		cert, err := primitives.DERToX509Certificate(der)
+		if err != nil {
+			t.Fatalf("Failed parsing cert [%s].", err)
+		}
+		return cert, key
+	}
+
+	eca, ecaKey := newCert("org2 eca", nil, nil)
+	tca, tcaKey := newCert("org2 tca", nil, nil)
+	cas := make(trustedCAs)
+	cas.add("org2", trustedECA, []*x509.Certificate{eca})
+	cas.add("org2", trustedTCA, []*x509.Certificate{tca})
+
+	ecert, _ := newCert("alice", eca, ecaKey)
+	if org, err := cas.verify(ecert, trustedECA); err != nil || org != "org2" {
+		t.Fatalf("The ECert should be issued by the ECA of org2 [%s].", err)
+	}
+	if _, err := cas.verify(ecert, trustedTCA); err == nil {
+		t.Fatal("The ECert should not be trusted as a TCert.")
+	}
+
+	tcert, _ := newCert("tcert", tca, tcaKey)
+	if org, err := cas.verify(tcert, trustedTCA); err != nil || org != "org2" {
+		t.Fatalf("The TCert should be issued by the TCA of org2 [%s].", err)
+	}
+
+	// A CA named after a trusted one is not trusted
+	forged, forgedKey := newCert("org2 eca", nil, nil)
+	cert, _ := newCert("mallory", forged, forgedKey)
+	if _, err := cas.verify(cert, trustedECA); err == nil {
+		t.Fatal("A cert issued by an untrusted CA should not be trusted.")
+	}
+}
+
+func TestCloseAllNodes(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	for _, closeAll := range []func() (bool, []error){CloseAllClients, CloseAllPeers, CloseAllValidators} {
+		if ok, errs := closeAll(); !ok || len(errs) != 0 {
+			t.Fatalf("Nodes should close without errors [%v].", errs)
+		}
+	}
+	if len(clients) != 0 || len(peers) != 0 || len(validators) != 0 {
+		t.Fatal("Closed nodes should be released.")
+	}
+}
+
+func TestKeyStoreIncompatibleFormat(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "format", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	version, err := getSchemaVersion(node.ks.sqlDB, ksFormatComponent)
+	if err != nil || version != ksFormatVersion {
+		t.Fatalf("Keystore should be stamped with format version [%d], found [%d]: [%v].", ksFormatVersion, version, err)
+	}
+
+	// As a newer release would
+	if _, err := node.ks.sqlDB.Exec("UPDATE SchemaVersion SET version = ? WHERE component = ?", ksFormatVersion+1, ksFormatComponent); err != nil {
+		t.Fatalf("Failed updating format version [%s].", err)
+	}
+	node.ks.close()
+
+	_, err = openNodeKeyStore(NodeValidator, "format", ksPwd)
+	incompatible, ok := err.(*ErrIncompatibleKeystore)
+	if !ok {
+		t.Fatalf("Opening a newer keystore should fail with ErrIncompatibleKeystore [%v].", err)
+	}
+	if incompatible.Component != ksFormatComponent || incompatible.Version != ksFormatVersion+1 || incompatible.Supported != ksFormatVersion {
+		t.Fatalf("Invalid incompatibility [%v].", incompatible)
+	}
+}
+
+func TestKeyStoreSecurityParameters(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "params", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	recorded, err := node.ks.GetBlob(securityParamsNamespace, securityParamsKey)
+	if err != nil || string(recorded) != securityParameters() {
+		t.Fatalf("Keystore should record security parameters [%s], found [%s]: [%v].", securityParameters(), recorded, err)
+	}
+
+	// As if written under other parameters, none of the test scenarios
+	if err := node.ks.PutBlob(securityParamsNamespace, securityParamsKey, []byte("SHA2-512")); err != nil {
+		t.Fatalf("Failed updating security parameters [%s].", err)
+	}
+	node.ks.close()
+
+	_, err = openNodeKeyStore(NodeValidator, "params", ksPwd)
+	mismatch, ok := err.(*ErrSecurityParametersMismatch)
+	if !ok {
+		t.Fatalf("Opening the keystore should fail with ErrSecurityParametersMismatch [%v].", err)
+	}
+	if mismatch.Recorded != "SHA2-512" || mismatch.Configured != securityParameters() {
+		t.Fatalf("Invalid mismatch [%v].", mismatch)
+	}
+}
+
+func TestKeyStoreMigrateSchema(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed opening DB [%s].", err)
+	}
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	migrations := []ksMigration{
+		{1, "create table", []string{"CREATE TABLE Test (id INTEGER, PRIMARY KEY (id))"}},
+	}
+	if err := migrateSchema(sqlDB, "test", migrations); err != nil {
+		t.Fatalf("Failed migrating to version 1 [%s].", err)
+	}
+
+	// Migrating again must be a no-op
+	if err := migrateSchema(sqlDB, "test", migrations); err != nil {
+		t.Fatalf("Failed re-migrating to version 1 [%s].", err)
+	}
+
+	migrations = append(migrations, ksMigration{2, "add column", []string{"ALTER TABLE Test ADD COLUMN value BLOB"}})
+	if err := migrateSchema(sqlDB, "test", migrations); err != nil {
+		t.Fatalf("Failed migrating to version 2 [%s].", err)
+	}
+	if _, err := sqlDB.Exec("INSERT INTO Test (id, value) VALUES (?, ?)", 1, []byte("value")); err != nil {
+		t.Fatalf("Column not added by migration [%s].", err)
+	}
+
+	version, err := getSchemaVersion(sqlDB, "test")
+	if err != nil || version != 2 {
+		t.Fatalf("Invalid schema version [%d]: [%s].", version, err)
+	}
+
+	// A schema newer than the supported one must be rejected
+	if _, ok := migrateSchema(sqlDB, "test", migrations[:1]).(*ErrIncompatibleKeystore); !ok {
+		t.Fatal("Migrating a newer schema should fail with ErrIncompatibleKeystore.")
+	}
+}
+
+func BenchmarkTransactionCreation(b *testing.B) {
+	initNodes()
+	defer closeNodes()
+
+	b.StopTimer()
+	b.ResetTimer()
+	cis := &obc.ChaincodeInvocationSpec{
+		ChaincodeSpec: &obc.ChaincodeSpec{
+			Type:                 obc.ChaincodeSpec_GOLANG,
+			ChaincodeID:          &obc.ChaincodeID{Path: "Contract001"},
+			CtorMsg:              nil,
+			ConfidentialityLevel: obc.ConfidentialityLevel_CONFIDENTIAL,
+		},
+	}
+	invoker.GetTCertificateHandlerNext(attrs...)
+
+	for i := 0; i < b.N; i++ {
+		uuid := util.GenerateUUID()
+		b.StartTimer()
+		invoker.NewChaincodeExecute(cis, uuid, attrs...)
+		b.StopTimer()
+	}
+}
+
+func BenchmarkTransactionValidation(b *testing.B) {
+	initNodes()
+	defer closeNodes()
+
+	b.StopTimer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, tx, _ := createConfidentialTCertHExecuteTransaction(nil)
 
 		b.StartTimer()
 		validator.TransactionPreValidation(tx)
@@ -1423,6 +4546,51 @@ func BenchmarkVerify(b *testing.B) {
 	}
 }
 
+func BenchmarkTransactionPreValidationHotCert(b *testing.B) {
+	initNodes()
+	defer closeNodes()
+
+	// Transactions of the same TCert, whose cert is verified once
+	_, tx, err := createConfidentialTCertHExecuteTransaction(nil)
+	if err != nil {
+		b.Fatalf("Failed creating transaction [%s].", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		validator.TransactionPreValidation(tx)
+	}
+}
+
+func BenchmarkTCertDerivation(b *testing.B) {
+	enrollKey, _ := primitives.NewECDSAKey()
+	expansionKey, _ := primitives.GenAESKey()
+	tCertIndex := make([]byte, 16)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(tCertIndex, uint64(i))
+		deriveTCertKey(enrollKey, tCertExpansionValue(expansionKey, tCertIndex))
+	}
+}
+
+func BenchmarkCertLRUGet(b *testing.B) {
+	der, _, _ := primitives.NewSelfSignedCert()
+	cert, _ := primitives.DERToX509Certificate(der)
+
+	cache := newCertLRU(1000)
+	sids := make([]string, 1000)
+	for i := range sids {
+		sids[i] = certFingerprint([]byte(strconv.Itoa(i)))
+		cache.put(sids[i], cert)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.get(sids[i%len(sids)])
+	}
+}
+
 func setup() {
 	// Conf
 	viper.SetConfigName("crypto_test") // name of config file (without extension)
@@ -0,0 +1,190 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cryptotest
+
+import (
+	"crypto/tls"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// testPeer is a crypto.Peer signing with an identity of a Provider, and
+// verifying against the identities of the same Provider. It supports
+// public transactions only, and caches no certificate.
+type testPeer struct {
+	*Identity
+
+	eType crypto.NodeType
+}
+
+// NewPeer returns a peer enrolled as enrollID
+func (p *Provider) NewPeer(enrollID string) (crypto.Peer, error) {
+	return p.newPeer(crypto.NodePeer, enrollID)
+}
+
+// NewValidator returns a validator enrolled as enrollID
+func (p *Provider) NewValidator(enrollID string) (crypto.Peer, error) {
+	return p.newPeer(crypto.NodeValidator, enrollID)
+}
+
+func (p *Provider) newPeer(eType crypto.NodeType, enrollID string) (crypto.Peer, error) {
+	identity, err := p.Enroll(enrollID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testPeer{identity, eType}, nil
+}
+
+func (peer *testPeer) GetType() crypto.NodeType {
+	return peer.eType
+}
+
+func (peer *testPeer) GetName() string {
+	return peer.EnrollID
+}
+
+func (peer *testPeer) GetID() []byte {
+	return peer.ID
+}
+
+func (peer *testPeer) GetEnrollmentID() string {
+	return peer.EnrollID
+}
+
+func (peer *testPeer) TransactionPreValidation(tx *obc.Transaction) (*obc.Transaction, error) {
+	if tx.Cert == nil || tx.Signature == nil {
+		return tx, nil
+	}
+
+	cert, err := primitives.DERToX509Certificate(tx.Cert)
+	if err != nil {
+		return tx, err
+	}
+	if err := peer.provider.VerifyCert(cert); err != nil {
+		return tx, utils.ErrInvalidCertificateChain
+	}
+
+	// Verify the signature of tx without signature
+	signature := tx.Signature
+	tx.Signature = nil
+	rawTx, err := proto.Marshal(tx)
+	tx.Signature = signature
+	if err != nil {
+		return tx, err
+	}
+
+	ok, err := primitives.ECDSAVerify(cert.PublicKey, rawTx, signature)
+	if err != nil {
+		return tx, err
+	}
+	if !ok {
+		return tx, utils.ErrInvalidTransactionSignature
+	}
+
+	return tx, nil
+}
+
+func (peer *testPeer) TransactionsPreValidation(txs []*obc.Transaction) ([]*obc.Transaction, []error) {
+	results := make([]*obc.Transaction, len(txs))
+	errs := make([]error, len(txs))
+	for i, tx := range txs {
+		results[i], errs[i] = peer.TransactionPreValidation(tx)
+	}
+
+	return results, errs
+}
+
+func (peer *testPeer) VerifyTransactionsBatch(txs []*obc.Transaction) []error {
+	_, errs := peer.TransactionsPreValidation(txs)
+
+	return errs
+}
+
+func (peer *testPeer) TransactionPreExecution(tx *obc.Transaction) (*obc.Transaction, error) {
+	if tx.ConfidentialityLevel == obc.ConfidentialityLevel_CONFIDENTIAL {
+		return nil, utils.ErrNotImplemented
+	}
+
+	return proto.Clone(tx).(*obc.Transaction), nil
+}
+
+func (peer *testPeer) Verify(vkID, signature, message []byte) error {
+	if len(vkID) == 0 {
+		return peer.Identity.Verify(signature, message)
+	}
+
+	identity, err := peer.provider.Lookup(vkID)
+	if err != nil {
+		return err
+	}
+
+	return identity.Verify(signature, message)
+}
+
+func (peer *testPeer) GetTLSCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &tls.Certificate{
+		Certificate: [][]byte{peer.Cert.Raw},
+		PrivateKey:  peer.Key,
+		Leaf:        peer.Cert,
+	}, nil
+}
+
+func (peer *testPeer) GetStateEncryptor(deployTx, executeTx *obc.Transaction) (crypto.StateEncryptor, error) {
+	return nil, utils.ErrNotImplemented
+}
+
+func (peer *testPeer) GetTransactionBinding(tx *obc.Transaction) ([]byte, error) {
+	return primitives.Hash(append(tx.Cert, tx.Nonce...)), nil
+}
+
+func (peer *testPeer) GrantPayloadAccess(tx *obc.Transaction, request []byte) ([]byte, error) {
+	return nil, utils.ErrNotImplemented
+}
+
+func (peer *testPeer) DeleteEnrollmentCert(id []byte) error {
+	return nil
+}
+
+func (peer *testPeer) PurgeEnrollmentCerts(filter crypto.CertFilter) (int, error) {
+	return 0, nil
+}
+
+func (peer *testPeer) GetEnrollmentCertificateChain() ([][]byte, error) {
+	return [][]byte{peer.Cert.Raw, peer.provider.ca.Cert.Raw}, nil
+}
+
+// SetEnrollmentCertSource is a no-op, the test peer resolving
+// the identities of its Provider directly
+func (peer *testPeer) SetEnrollmentCertSource(source crypto.EnrollmentCertSource) {
+}
+
+func (peer *testPeer) ListCertificates(filter *crypto.CertInfoFilter) ([]crypto.CertInfo, error) {
+	return []crypto.CertInfo{}, nil
+}
+
+func (peer *testPeer) ProcessCRL(crl []byte) (int, error) {
+	return 0, utils.ErrNotImplemented
+}
+
+func (peer *testPeer) ProcessOCSPResponse(resp []byte) (int, error) {
+	return 0, utils.ErrNotImplemented
+}
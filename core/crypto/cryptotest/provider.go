@@ -0,0 +1,320 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cryptotest provides a deterministic crypto provider for tests.
+// A Provider derives from a seed a CA, and the enrollment keys and
+// certificates of the nodes of a test network, and signs with them
+// deterministically: two runs with the same seed produce the same keys,
+// certificates and signatures. The peers of a Provider implement
+// crypto.Peer, and verify against the Provider in place of a live ECA or TCA.
+//
+// The keys of a Provider are predictable by anyone knowing its seed.
+// It must never be used outside of tests.
+package cryptotest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Epoch is the start of the validity period of the certificates of the
+// providers. The certificates are valid for Validity from then on.
+var Epoch = time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Validity is the validity period of the certificates of the providers
+const Validity = 100 * 365 * 24 * time.Hour
+
+var (
+	// ErrUnknownIdentity is returned when verifying against
+	// an identity not enrolled with the provider
+	ErrUnknownIdentity = errors.New("Identity not enrolled with the provider.")
+)
+
+// Provider derives keys, certificates and signatures from a seed
+type Provider struct {
+	seed []byte
+
+	ca *Identity
+
+	// Enrolled identities, by enrollment id and by Base64 encoded id
+	m          sync.Mutex
+	identities map[string]*Identity
+	ids        map[string]*Identity
+	serial     int64
+}
+
+// Identity is an enrollment key and certificate issued by a Provider
+type Identity struct {
+	provider *Provider
+
+	// EnrollID is the enrollment id of the identity
+	EnrollID string
+
+	// Key is the enrollment key
+	Key *ecdsa.PrivateKey
+
+	// Cert is the enrollment certificate
+	Cert *x509.Certificate
+
+	// ID is the id of the identity, the hash of Cert
+	ID []byte
+}
+
+// NewProvider returns a provider deriving everything from seed. The
+// security level, if not initialized yet, is set to the default one.
+func NewProvider(seed []byte) (*Provider, error) {
+	if len(seed) == 0 {
+		return nil, errors.New("Invalid seed. It is empty.")
+	}
+	if primitives.GetDefaultCurve() == nil {
+		if err := primitives.InitSecurityLevel("SHA3", 256); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Provider{
+		seed:       utils.Clone(seed),
+		identities: make(map[string]*Identity),
+		ids:        make(map[string]*Identity),
+	}
+
+	// Self-signed CA
+	key := p.Key("ca")
+	template := p.certTemplate("ca", 0)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	ca, err := p.createCertificate(template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	p.ca = &Identity{p, "ca", key, ca, primitives.Hash(ca.Raw)}
+
+	return p, nil
+}
+
+// Reader returns the stream of bytes labeled label
+func (p *Provider) Reader(label string) io.Reader {
+	return hkdf.New(sha256.New, p.seed, nil, []byte(label))
+}
+
+// Key returns the ECDSA key labeled label, on the default curve
+func (p *Provider) Key(label string) *ecdsa.PrivateKey {
+	return newKey(p.Reader("key/" + label))
+}
+
+// CA returns the CA issuing the enrollment certificates
+func (p *Provider) CA() *Identity {
+	return p.ca
+}
+
+// CertPool returns a pool holding the certificate of the CA
+func (p *Provider) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.ca.Cert)
+
+	return pool
+}
+
+// Enroll returns the identity enrolled as enrollID, issuing it on first call.
+// The certificates depend on the order of the enrollments.
+func (p *Provider) Enroll(enrollID string) (*Identity, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if identity, ok := p.identities[enrollID]; ok {
+		return identity, nil
+	}
+
+	p.serial++
+	key := p.Key("enrollment/" + enrollID)
+	template := p.certTemplate(enrollID, p.serial)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	cert, err := p.createCertificate(template, p.ca.Cert, &key.PublicKey, p.ca.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{p, enrollID, key, cert, primitives.Hash(cert.Raw)}
+	p.identities[enrollID] = identity
+	p.ids[utils.EncodeBase64(identity.ID)] = identity
+
+	return identity, nil
+}
+
+// Lookup returns the identity of id
+func (p *Provider) Lookup(id []byte) (*Identity, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	identity, ok := p.ids[utils.EncodeBase64(id)]
+	if !ok {
+		return nil, ErrUnknownIdentity
+	}
+
+	return identity, nil
+}
+
+// VerifyCert checks that cert was issued by the CA of the provider
+func (p *Provider) VerifyCert(cert *x509.Certificate) error {
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       p.CertPool(),
+		CurrentTime: Epoch.Add(time.Hour),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	return err
+}
+
+// Sign signs msg with the enrollment key of the identity.
+// The same message always gets the same signature.
+func (identity *Identity) Sign(msg []byte) ([]byte, error) {
+	return signDigest(identity.Key, primitives.Hash(msg))
+}
+
+// Verify checks signature, as returned by Sign, of msg
+func (identity *Identity) Verify(signature, msg []byte) error {
+	ok, err := primitives.ECDSAVerify(&identity.Key.PublicKey, msg, signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return utils.ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// SignTransaction signs tx with the identity, as a client
+// signing with its enrollment certificate does
+func (identity *Identity) SignTransaction(tx *obc.Transaction) error {
+	tx.Cert = identity.Cert.Raw
+	tx.Signature = nil
+
+	rawTx, err := proto.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	tx.Signature, err = identity.Sign(rawTx)
+
+	return err
+}
+
+// signer signs the certificates with the deterministic signatures
+type signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s signer) Public() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+func (s signer) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return signDigest(s.key, digest)
+}
+
+func (p *Provider) certTemplate(commonName string, serial int64) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial + 1),
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{"cryptotest"},
+		},
+		NotBefore: Epoch,
+		NotAfter:  Epoch.Add(Validity),
+	}
+}
+
+func (p *Provider) createCertificate(template, parent *x509.Certificate, pub interface{}, key *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(p.Reader("cert"), template, parent, pub, signer{key})
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// newKey derives an ECDSA key on the default curve from the bytes of r
+func newKey(r io.Reader) *ecdsa.PrivateKey {
+	curve := primitives.GetDefaultCurve()
+
+	key := new(ecdsa.PrivateKey)
+	key.Curve = curve
+	key.D = randomScalar(r, curve.Params().N)
+	key.X, key.Y = curve.ScalarBaseMult(key.D.Bytes())
+
+	return key
+}
+
+// randomScalar reads from r a scalar in [1, n-1], with a negligible bias
+func randomScalar(r io.Reader, n *big.Int) *big.Int {
+	buf := make([]byte, (n.BitLen()+7)/8+8)
+	io.ReadFull(r, buf)
+
+	k := new(big.Int).SetBytes(buf)
+	k.Mod(k, new(big.Int).Sub(n, big.NewInt(1)))
+
+	return k.Add(k, big.NewInt(1))
+}
+
+// signDigest signs digest with key, deriving the nonce from the key and
+// the digest, and returns the ASN.1 encoded signature
+func signDigest(key *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	curve := key.Curve
+	n := curve.Params().N
+
+	// The digest, truncated to the bit length of n
+	e := new(big.Int).SetBytes(digest)
+	if excess := len(digest)*8 - n.BitLen(); excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+
+	for counter := byte(0); ; counter++ {
+		k := randomScalar(hkdf.New(sha256.New, key.D.Bytes(), []byte{counter}, digest), n)
+
+		x, _ := curve.ScalarBaseMult(k.Bytes())
+		r := x.Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// s = k^-1 (e + r d) mod n
+		s := new(big.Int).Mul(r, key.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		return asn1.Marshal(primitives.ECDSASignature{R: r, S: s})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cryptotest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+func newProvider(t *testing.T, seed string) *Provider {
+	p, err := NewProvider([]byte(seed))
+	if err != nil {
+		t.Fatalf("Failed creating provider [%s].", err)
+	}
+
+	return p
+}
+
+func enroll(t *testing.T, p *Provider, enrollID string) *Identity {
+	identity, err := p.Enroll(enrollID)
+	if err != nil {
+		t.Fatalf("Failed enrolling [%s] [%s].", enrollID, err)
+	}
+
+	return identity
+}
+
+func TestProviderDeterminism(t *testing.T) {
+	p1, p2 := newProvider(t, "seed"), newProvider(t, "seed")
+	msg := []byte("Hello World!!!")
+
+	if !bytes.Equal(p1.CA().Cert.Raw, p2.CA().Cert.Raw) {
+		t.Fatal("CA certificates should be equal.")
+	}
+	alice1, alice2 := enroll(t, p1, "alice"), enroll(t, p2, "alice")
+	if !bytes.Equal(alice1.Cert.Raw, alice2.Cert.Raw) || alice1.Key.D.Cmp(alice2.Key.D) != 0 {
+		t.Fatal("Enrollments should be equal.")
+	}
+	if enroll(t, p1, "alice") != alice1 {
+		t.Fatal("Enrolling twice should return the same identity.")
+	}
+
+	sig1, err := alice1.Sign(msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	sig2, _ := alice2.Sign(msg)
+	if !bytes.Equal(sig1, sig2) {
+		t.Fatal("Signatures should be equal.")
+	}
+	if err := alice1.Verify(sig1, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+
+	other := newProvider(t, "other seed")
+	if bytes.Equal(other.CA().Cert.Raw, p1.CA().Cert.Raw) {
+		t.Fatal("Other seeds should give other CAs.")
+	}
+	if err := other.VerifyCert(alice1.Cert); err == nil {
+		t.Fatal("Certificates of another provider should be rejected.")
+	}
+}
+
+func TestProviderPeers(t *testing.T) {
+	p := newProvider(t, "peers")
+	vp0, err := p.NewValidator("vp0")
+	if err != nil {
+		t.Fatalf("Failed creating validator [%s].", err)
+	}
+	vp1, err := p.NewValidator("vp1")
+	if err != nil {
+		t.Fatalf("Failed creating validator [%s].", err)
+	}
+
+	// Peers verify each other
+	msg := []byte("Hello World!!!")
+	signature, err := vp0.Sign(msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	if err := vp1.Verify(vp0.GetID(), signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+	if err := vp1.Verify(vp1.GetID(), signature, msg); err != utils.ErrInvalidSignature {
+		t.Fatalf("Signature of another peer should be rejected [%v].", err)
+	}
+	if err := vp1.Verify([]byte("unknown"), signature, msg); err != ErrUnknownIdentity {
+		t.Fatalf("Unknown ids should be rejected [%v].", err)
+	}
+
+	// Transactions signed by a client
+	tx := &obc.Transaction{Type: obc.Transaction_CHAINCODE_INVOKE, Uuid: "tx", Nonce: []byte("nonce")}
+	if err := enroll(t, p, "alice").SignTransaction(tx); err != nil {
+		t.Fatalf("Failed signing transaction [%s].", err)
+	}
+	if _, err := vp0.TransactionPreValidation(tx); err != nil {
+		t.Fatalf("Failed validating transaction [%s].", err)
+	}
+	tx.Uuid = "another tx"
+	if _, err := vp0.TransactionPreValidation(tx); err != utils.ErrInvalidTransactionSignature {
+		t.Fatalf("Altered transaction should be rejected [%v].", err)
+	}
+
+	// Transactions signed by another network
+	if err := enroll(t, newProvider(t, "other"), "alice").SignTransaction(tx); err != nil {
+		t.Fatalf("Failed signing transaction [%s].", err)
+	}
+	if _, err := vp0.TransactionPreValidation(tx); err != utils.ErrInvalidCertificateChain {
+		t.Fatalf("Transaction of another network should be rejected [%v].", err)
+	}
+}
@@ -0,0 +1,315 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idemix
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// Issuance takes a round trip. The user commits to its secret sk as
+// U = S^v' R0^sk and proves knowledge of the opening, bound to a nonce of
+// the issuer. The issuer picks a prime e and a v'', and computes
+// A = (Z / (U S^v'' R1^m1 ... Rl^ml))^(1/e). The credential of the user is
+// (A, e, v' + v''), a CL signature of (sk, m1, ..., ml).
+
+// CredentialRequest is the commitment of a user to its secret,
+// with the proof of knowledge of its opening
+type CredentialRequest struct {
+	U  *big.Int
+	C  *big.Int
+	SK *big.Int
+	SV *big.Int
+}
+
+// PendingCredential holds the secrets of the user between the request
+// and the issuance of a credential
+type PendingCredential struct {
+	Secret *big.Int
+	VPrime *big.Int
+}
+
+// Credential is a CL signature of the user secret and of the attributes
+// Attributes, in the order of the attribute names of the issuer
+type Credential struct {
+	A          *big.Int
+	E          *big.Int
+	V          *big.Int
+	Secret     *big.Int
+	Attributes [][]byte
+}
+
+// IssuedCredential is the part of a credential computed by the issuer
+type IssuedCredential struct {
+	A          *big.Int
+	E          *big.Int
+	VPrime     *big.Int
+	Attributes [][]byte
+}
+
+// NewCredentialRequest generates a user secret and commits to it for the
+// issuer pk, binding the proof of knowledge of the commitment to nonce
+func NewCredentialRequest(pk *IssuerPublicKey, nonce []byte) (*CredentialRequest, *PendingCredential, error) {
+	sk, err := randomBits(lm)
+	if err != nil {
+		return nil, nil, err
+	}
+	vPrime, err := randomBits(pk.N.BitLen() + lstat)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := new(big.Int).Mul(new(big.Int).Exp(pk.S, vPrime, pk.N), new(big.Int).Exp(pk.R[0], sk, pk.N))
+	u.Mod(u, pk.N)
+
+	// Prove knowledge of sk and v'
+	rSK, err := randomBits(lm + lstat + lh)
+	if err != nil {
+		return nil, nil, err
+	}
+	rV, err := randomBits(pk.N.BitLen() + 2*lstat + lh)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := new(big.Int).Mul(new(big.Int).Exp(pk.S, rV, pk.N), new(big.Int).Exp(pk.R[0], rSK, pk.N))
+	t.Mod(t, pk.N)
+
+	c, err := requestChallenge(pk, u, t, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request := &CredentialRequest{
+		U:  u,
+		C:  c,
+		SK: rSK.Add(rSK, new(big.Int).Mul(c, sk)),
+		SV: rV.Add(rV, new(big.Int).Mul(c, vPrime)),
+	}
+
+	return request, &PendingCredential{sk, vPrime}, nil
+}
+
+// Verify checks the proof of knowledge of the commitment for the issuer pk and nonce
+func (request *CredentialRequest) Verify(pk *IssuerPublicKey, nonce []byte) error {
+	if request.U == nil || request.C == nil || request.SK == nil || request.SV == nil {
+		return ErrInvalidProof
+	}
+	if !inRange(request.SK, lm+lstat+lh+1) || !inRange(request.SV, pk.N.BitLen()+2*lstat+lh+1) {
+		return ErrInvalidProof
+	}
+
+	// t = U^-c S^sv R0^sk
+	t := expMod(request.U, new(big.Int).Neg(request.C), pk.N)
+	t.Mul(t, expMod(pk.S, request.SV, pk.N))
+	t.Mul(t, expMod(pk.R[0], request.SK, pk.N))
+	t.Mod(t, pk.N)
+
+	c, err := requestChallenge(pk, request.U, t, nonce)
+	if err != nil {
+		return err
+	}
+	if c.Cmp(request.C) != 0 {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// Issue certifies the secret committed to by request, whose proof must
+// have been verified, and attributes, in the order of the attribute names
+func (key *IssuerKey) Issue(request *CredentialRequest, attributes [][]byte) (*IssuedCredential, error) {
+	pk := key.Public()
+	if len(attributes) != len(pk.AttributeNames) {
+		return nil, fmt.Errorf("Expected [%d] attributes, got [%d].", len(pk.AttributeNames), len(attributes))
+	}
+
+	e, err := randomPrimeExponent()
+	if err != nil {
+		return nil, err
+	}
+	vPrime, err := randomBits(lv(pk.N.BitLen()) - 1)
+	if err != nil {
+		return nil, err
+	}
+	vPrime.SetBit(vPrime, lv(pk.N.BitLen())-1, 1)
+
+	// Q = Z / (U S^v'' R1^m1 ... Rl^ml)
+	q := new(big.Int).Mul(request.U, new(big.Int).Exp(pk.S, vPrime, pk.N))
+	for i, value := range attributes {
+		q.Mul(q, new(big.Int).Exp(pk.R[i+1], AttributeValue(value), pk.N))
+		q.Mod(q, pk.N)
+	}
+	if q.ModInverse(q, pk.N) == nil {
+		return nil, ErrInvalidProof
+	}
+	q.Mul(q, pk.Z)
+	q.Mod(q, pk.N)
+
+	// A = Q^(1/e), e being inverted modulo the order P'Q' of the quadratic residues
+	order := new(big.Int).Mul(key.P, key.Q)
+	eInv := new(big.Int).ModInverse(e, order)
+	if eInv == nil {
+		return nil, fmt.Errorf("Failed inverting exponent.")
+	}
+	a := new(big.Int).Exp(q, eInv, pk.N)
+
+	return &IssuedCredential{a, e, vPrime, attributes}, nil
+}
+
+// Complete combines the credential issued by the issuer pk with the
+// secrets of the user, and checks it
+func (pending *PendingCredential) Complete(pk *IssuerPublicKey, issued *IssuedCredential) (*Credential, error) {
+	if issued.A == nil || issued.E == nil || issued.VPrime == nil {
+		return nil, ErrInvalidCredential
+	}
+
+	credential := &Credential{
+		A:          issued.A,
+		E:          issued.E,
+		V:          new(big.Int).Add(pending.VPrime, issued.VPrime),
+		Secret:     pending.Secret,
+		Attributes: issued.Attributes,
+	}
+	if err := credential.Verify(pk); err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// Verify checks that the credential is a signature of its secret and
+// attributes under the issuer public key pk
+func (credential *Credential) Verify(pk *IssuerPublicKey) error {
+	if credential.A == nil || credential.E == nil || credential.V == nil || credential.Secret == nil {
+		return ErrInvalidCredential
+	}
+	if len(credential.Attributes) != len(pk.AttributeNames) {
+		return ErrInvalidCredential
+	}
+	if credential.E.BitLen() != le || !credential.E.ProbablyPrime(20) {
+		return ErrInvalidCredential
+	}
+
+	// Z = A^e S^v R0^sk R1^m1 ... Rl^ml
+	z := new(big.Int).Exp(credential.A, credential.E, pk.N)
+	z.Mul(z, new(big.Int).Exp(pk.S, credential.V, pk.N))
+	z.Mul(z, new(big.Int).Exp(pk.R[0], credential.Secret, pk.N))
+	for i, value := range credential.Attributes {
+		z.Mul(z, new(big.Int).Exp(pk.R[i+1], AttributeValue(value), pk.N))
+		z.Mod(z, pk.N)
+	}
+	z.Mod(z, pk.N)
+	if z.Cmp(pk.Z) != 0 {
+		return ErrInvalidCredential
+	}
+
+	return nil
+}
+
+// Attribute returns the value of the attribute name, certified by the issuer pk
+func (credential *Credential) Attribute(pk *IssuerPublicKey, name string) ([]byte, error) {
+	index := pk.AttributeIndex(name)
+	if index < 0 || index > len(credential.Attributes) {
+		return nil, ErrUnknownAttribute
+	}
+
+	return credential.Attributes[index-1], nil
+}
+
+// Bytes marshals the credential
+func (credential *Credential) Bytes() ([]byte, error) {
+	return asn1.Marshal(*credential)
+}
+
+// ParseCredential unmarshals a credential
+func ParseCredential(raw []byte) (*Credential, error) {
+	credential := &Credential{}
+	if _, err := asn1.Unmarshal(raw, credential); err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// Bytes marshals the issued credential
+func (issued *IssuedCredential) Bytes() ([]byte, error) {
+	return asn1.Marshal(*issued)
+}
+
+// ParseIssuedCredential unmarshals an issued credential
+func ParseIssuedCredential(raw []byte) (*IssuedCredential, error) {
+	issued := &IssuedCredential{}
+	if _, err := asn1.Unmarshal(raw, issued); err != nil {
+		return nil, err
+	}
+
+	return issued, nil
+}
+
+// Bytes marshals the credential request
+func (request *CredentialRequest) Bytes() ([]byte, error) {
+	return asn1.Marshal(*request)
+}
+
+// ParseCredentialRequest unmarshals a credential request
+func ParseCredentialRequest(raw []byte) (*CredentialRequest, error) {
+	request := &CredentialRequest{}
+	if _, err := asn1.Unmarshal(raw, request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Bytes marshals the pending credential
+func (pending *PendingCredential) Bytes() ([]byte, error) {
+	return asn1.Marshal(*pending)
+}
+
+// ParsePendingCredential unmarshals a pending credential
+func ParsePendingCredential(raw []byte) (*PendingCredential, error) {
+	pending := &PendingCredential{}
+	if _, err := asn1.Unmarshal(raw, pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// randomPrimeExponent returns a random prime in [2^(le-1), 2^(le-1) + 2^(lePrime-1)]
+func randomPrimeExponent() (*big.Int, error) {
+	base := new(big.Int).Lsh(one, le-1)
+	for {
+		offset, err := randomBits(lePrime - 1)
+		if err != nil {
+			return nil, err
+		}
+		e := offset.Add(offset, base)
+		if e.ProbablyPrime(20) {
+			return e, nil
+		}
+	}
+}
+
+func requestChallenge(pk *IssuerPublicKey, u, t *big.Int, nonce []byte) (*big.Int, error) {
+	pkHash, err := pk.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge(pkHash, u, t, nonce)
+}
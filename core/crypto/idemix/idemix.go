@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package idemix implements anonymous credentials in the style of Identity
+// Mixer: Camenisch-Lysyanskaya signatures over a strong RSA group.
+//
+// An issuer certifies a user secret and a list of attributes, without
+// learning the secret. The user then signs messages with zero-knowledge
+// proofs of possession of the credential, disclosing some of its attributes
+// only. Two signatures by the same user cannot be linked, neither to each
+// other nor to the issuance of the credential.
+package idemix
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+const (
+	// DefaultModulusSize is the size, in bits, of the issuer modulus
+	DefaultModulusSize = 2048
+
+	// MinModulusSize is the size, in bits, of the smallest issuer modulus accepted
+	MinModulusSize = 1024
+
+	// Size, in bits, of the attributes and of the user secret
+	lm = 256
+
+	// Size, in bits, of the challenges
+	lh = 256
+
+	// Statistical zero-knowledge parameter
+	lstat = 80
+
+	// Size, in bits, of the prime exponents e, in [2^(le-1), 2^(le-1) + 2^(lePrime-1)]
+	le      = lstat + lh + lm + 5
+	lePrime = 120
+)
+
+var (
+	// ErrInvalidProof is returned when a proof does not verify
+	ErrInvalidProof = errors.New("Invalid proof.")
+
+	// ErrInvalidCredential is returned when a credential is not a signature
+	// of its attributes under the issuer public key
+	ErrInvalidCredential = errors.New("Invalid credential.")
+
+	// ErrUnknownAttribute is returned when disclosing an attribute
+	// not certified by the issuer
+	ErrUnknownAttribute = errors.New("Unknown attribute.")
+
+	one = big.NewInt(1)
+)
+
+// lv is the size, in bits, of the v part of the credentials issued
+// under a modulus of ln bits
+func lv(ln int) int {
+	return ln + 2*lstat + 2*lh + lm + 4
+}
+
+// AttributeValue maps the value of an attribute to the exponent it is certified as
+func AttributeValue(value []byte) *big.Int {
+	digest := sha256.Sum256(value)
+
+	return new(big.Int).SetBytes(digest[:])
+}
+
+// randomBits returns a uniformly random integer in [0, 2^bits)
+func randomBits(bits int) (*big.Int, error) {
+	return rand.Int(primitives.RandReader, new(big.Int).Lsh(one, uint(bits)))
+}
+
+// expMod computes base^exp mod n, for a possibly negative exp
+func expMod(base, exp, n *big.Int) *big.Int {
+	if exp.Sign() >= 0 {
+		return new(big.Int).Exp(base, exp, n)
+	}
+
+	inv := new(big.Int).ModInverse(base, n)
+	if inv == nil {
+		// base is not invertible, the result is not defined
+		return new(big.Int)
+	}
+
+	return new(big.Int).Exp(inv, new(big.Int).Neg(exp), n)
+}
+
+// inRange returns true if |x| < 2^bits
+func inRange(x *big.Int, bits int) bool {
+	return x != nil && x.BitLen() <= bits
+}
+
+// challenge hashes values, marshalled in ASN.1, into a challenge
+func challenge(values ...interface{}) (*big.Int, error) {
+	raw, err := asn1.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(raw)
+
+	return new(big.Int).SetBytes(digest[:]), nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idemix
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var (
+	issuerKey  *IssuerKey
+	attributes = [][]byte{[]byte("ACompany"), []byte("admin"), []byte("Europe")}
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	issuerKey, err = NewIssuerKey([]string{"company", "role", "region"}, MinModulusSize)
+	if err != nil {
+		fmt.Printf("Failed generating issuer key [%s].\n", err)
+		os.Exit(-1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func issueCredential(t *testing.T) *Credential {
+	pk := issuerKey.Public()
+	nonce := []byte("nonce")
+
+	request, pending, err := NewCredentialRequest(pk, nonce)
+	if err != nil {
+		t.Fatalf("Failed creating credential request [%s].", err)
+	}
+	if err := request.Verify(pk, nonce); err != nil {
+		t.Fatalf("Failed verifying credential request [%s].", err)
+	}
+	issued, err := issuerKey.Issue(request, attributes)
+	if err != nil {
+		t.Fatalf("Failed issuing credential [%s].", err)
+	}
+	credential, err := pending.Complete(pk, issued)
+	if err != nil {
+		t.Fatalf("Failed completing credential [%s].", err)
+	}
+
+	return credential
+}
+
+func TestIssuerKeyMarshalling(t *testing.T) {
+	raw, err := issuerKey.Public().Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling issuer public key [%s].", err)
+	}
+	pk, err := ParseIssuerPublicKey(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing issuer public key [%s].", err)
+	}
+	if !reflect.DeepEqual(pk, issuerKey.Public()) {
+		t.Fatal("Issuer public keys should be equal.")
+	}
+
+	raw, err = issuerKey.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling issuer key [%s].", err)
+	}
+	key, err := ParseIssuerKey(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing issuer key [%s].", err)
+	}
+	if !reflect.DeepEqual(key, issuerKey) {
+		t.Fatal("Issuer keys should be equal.")
+	}
+
+	if _, err := NewIssuerKey([]string{"role"}, MinModulusSize/2); err == nil {
+		t.Fatal("Small moduli should be rejected.")
+	}
+	if _, err := NewIssuerKey([]string{"role", "role"}, MinModulusSize); err == nil {
+		t.Fatal("Duplicated attributes should be rejected.")
+	}
+}
+
+func TestCredentialRequest(t *testing.T) {
+	pk := issuerKey.Public()
+
+	request, _, err := NewCredentialRequest(pk, []byte("nonce"))
+	if err != nil {
+		t.Fatalf("Failed creating credential request [%s].", err)
+	}
+	if err := request.Verify(pk, []byte("other nonce")); err != ErrInvalidProof {
+		t.Fatalf("Request bound to another nonce should be rejected [%v].", err)
+	}
+
+	raw, err := request.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling credential request [%s].", err)
+	}
+	parsed, err := ParseCredentialRequest(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing credential request [%s].", err)
+	}
+	parsed.U.Add(parsed.U, one)
+	if err := parsed.Verify(pk, []byte("nonce")); err != ErrInvalidProof {
+		t.Fatalf("Altered request should be rejected [%v].", err)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	pk := issuerKey.Public()
+	credential := issueCredential(t)
+
+	raw, err := credential.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling credential [%s].", err)
+	}
+	parsed, err := ParseCredential(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing credential [%s].", err)
+	}
+	if err := parsed.Verify(pk); err != nil {
+		t.Fatalf("Failed verifying credential [%s].", err)
+	}
+
+	role, err := credential.Attribute(pk, "role")
+	if err != nil || !bytes.Equal(role, []byte("admin")) {
+		t.Fatalf("Failed reading attribute [%s][%v].", role, err)
+	}
+	if _, err := credential.Attribute(pk, "age"); err != ErrUnknownAttribute {
+		t.Fatalf("Unknown attribute should be rejected [%v].", err)
+	}
+
+	// A credential for other attributes
+	parsed.Attributes[1] = []byte("user")
+	if err := parsed.Verify(pk); err != ErrInvalidCredential {
+		t.Fatalf("Altered credential should be rejected [%v].", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	pk := issuerKey.Public()
+	credential := issueCredential(t)
+	msg := []byte("Hello World!!!")
+
+	signature, err := credential.Sign(pk, []string{"role"}, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	raw, err := signature.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling signature [%s].", err)
+	}
+	signature, err = ParseSignature(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing signature [%s].", err)
+	}
+	if err := signature.Verify(pk, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+
+	disclosed := signature.Attributes(pk)
+	if len(disclosed) != 1 || !bytes.Equal(disclosed["role"], []byte("admin")) {
+		t.Fatalf("Only the role should be disclosed [%v].", disclosed)
+	}
+
+	if err := signature.Verify(pk, []byte("Another message")); err != ErrInvalidProof {
+		t.Fatalf("Signature of another message should be rejected [%v].", err)
+	}
+
+	// Disclosing another value
+	forged, _ := ParseSignature(raw)
+	forged.Values[0] = []byte("superuser")
+	if err := forged.Verify(pk, msg); err != ErrInvalidProof {
+		t.Fatalf("Signature disclosing another value should be rejected [%v].", err)
+	}
+
+	// Hiding a disclosed attribute
+	forged, _ = ParseSignature(raw)
+	forged.Disclosed, forged.Values = []int{}, [][]byte{}
+	if err := forged.Verify(pk, msg); err != ErrInvalidProof {
+		t.Fatalf("Signature with altered disclosure should be rejected [%v].", err)
+	}
+
+	if _, err := credential.Sign(pk, []string{"age"}, msg); err != ErrUnknownAttribute {
+		t.Fatalf("Disclosing an unknown attribute should fail [%v].", err)
+	}
+
+	// Another issuer
+	other, err := NewIssuerKey([]string{"company", "role", "region"}, MinModulusSize)
+	if err != nil {
+		t.Fatalf("Failed generating issuer key [%s].", err)
+	}
+	if err := signature.Verify(other.Public(), msg); err != ErrInvalidProof {
+		t.Fatalf("Signature under another issuer should be rejected [%v].", err)
+	}
+}
+
+func TestSignatureUnlinkability(t *testing.T) {
+	pk := issuerKey.Public()
+	credential := issueCredential(t)
+	msg := []byte("Hello World!!!")
+
+	signature1, err := credential.Sign(pk, nil, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	signature2, err := credential.Sign(pk, nil, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	if err := signature1.Verify(pk, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+	if err := signature2.Verify(pk, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+
+	// Nothing of the credential shows in the clear
+	if signature1.APrime.Cmp(credential.A) == 0 || signature1.APrime.Cmp(signature2.APrime) == 0 {
+		t.Fatal("Signatures should randomize the credential.")
+	}
+	if len(signature1.Attributes(pk)) != 0 {
+		t.Fatal("No attribute should be disclosed.")
+	}
+}
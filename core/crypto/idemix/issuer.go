@@ -0,0 +1,222 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idemix
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// IssuerPublicKey is the public key of an issuer of credentials.
+// R[0] certifies the user secret, R[i] the i-th attribute of AttributeNames.
+type IssuerPublicKey struct {
+	AttributeNames []string
+	N              *big.Int
+	S              *big.Int
+	Z              *big.Int
+	R              []*big.Int
+}
+
+// IssuerKey is the key pair of an issuer of credentials.
+// N = (2P + 1)(2Q + 1) for primes P, Q.
+type IssuerKey struct {
+	IssuerPublicKey
+	P *big.Int
+	Q *big.Int
+}
+
+// NewIssuerKey generates the key pair of an issuer of credentials certifying
+// the attributes attributeNames, under a modulus of modulusSize bits
+func NewIssuerKey(attributeNames []string, modulusSize int) (*IssuerKey, error) {
+	if modulusSize < MinModulusSize {
+		return nil, fmt.Errorf("Modulus size [%d] too small. It must be at least %d bits.", modulusSize, MinModulusSize)
+	}
+	seen := map[string]bool{}
+	for _, name := range attributeNames {
+		if seen[name] {
+			return nil, fmt.Errorf("Attribute [%s] listed twice.", name)
+		}
+		seen[name] = true
+	}
+
+	p, pPrime, err := safePrime(modulusSize / 2)
+	if err != nil {
+		return nil, err
+	}
+	q, qPrime := p, pPrime
+	for q.Cmp(p) == 0 {
+		if q, qPrime, err = safePrime(modulusSize - modulusSize/2); err != nil {
+			return nil, err
+		}
+	}
+	n := new(big.Int).Mul(p, q)
+	order := new(big.Int).Mul(pPrime, qPrime)
+
+	// S generates the quadratic residues, of order P'Q'
+	s, err := quadraticResidue(n)
+	if err != nil {
+		return nil, err
+	}
+	power := func() (*big.Int, error) {
+		x, err := rand.Int(primitives.RandReader, order)
+		if err != nil {
+			return nil, err
+		}
+
+		return new(big.Int).Exp(s, x.Add(x, one), n), nil
+	}
+
+	key := &IssuerKey{
+		IssuerPublicKey: IssuerPublicKey{
+			AttributeNames: append([]string{}, attributeNames...),
+			N:              n,
+			S:              s,
+			R:              make([]*big.Int, len(attributeNames)+1),
+		},
+		P: pPrime,
+		Q: qPrime,
+	}
+	if key.Z, err = power(); err != nil {
+		return nil, err
+	}
+	for i := range key.R {
+		if key.R[i], err = power(); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Public returns the public key of the issuer
+func (key *IssuerKey) Public() *IssuerPublicKey {
+	return &key.IssuerPublicKey
+}
+
+// Bytes marshals the issuer public key
+func (pk *IssuerPublicKey) Bytes() ([]byte, error) {
+	return asn1.Marshal(*pk)
+}
+
+// Hash identifies the issuer public key
+func (pk *IssuerPublicKey) Hash() ([]byte, error) {
+	raw, err := pk.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(raw)
+
+	return digest[:], nil
+}
+
+// AttributeIndex returns the index in R of the attribute name, -1 if not certified
+func (pk *IssuerPublicKey) AttributeIndex(name string) int {
+	for i, attributeName := range pk.AttributeNames {
+		if attributeName == name {
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// Bytes marshals the issuer key pair
+func (key *IssuerKey) Bytes() ([]byte, error) {
+	return asn1.Marshal(*key)
+}
+
+// ParseIssuerPublicKey unmarshals an issuer public key
+func ParseIssuerPublicKey(raw []byte) (*IssuerPublicKey, error) {
+	pk := &IssuerPublicKey{}
+	if rest, err := asn1.Unmarshal(raw, pk); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("Invalid issuer public key. Trailing data.")
+	}
+	if err := pk.check(); err != nil {
+		return nil, err
+	}
+
+	return pk, nil
+}
+
+// ParseIssuerKey unmarshals an issuer key pair
+func ParseIssuerKey(raw []byte) (*IssuerKey, error) {
+	key := &IssuerKey{}
+	if rest, err := asn1.Unmarshal(raw, key); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("Invalid issuer key. Trailing data.")
+	}
+	if err := key.check(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (pk *IssuerPublicKey) check() error {
+	if pk.N == nil || pk.N.BitLen() < MinModulusSize {
+		return fmt.Errorf("Invalid issuer public key. Modulus too small.")
+	}
+	if len(pk.R) != len(pk.AttributeNames)+1 {
+		return fmt.Errorf("Invalid issuer public key. Expected [%d] bases, got [%d].", len(pk.AttributeNames)+1, len(pk.R))
+	}
+	for _, base := range append([]*big.Int{pk.S, pk.Z}, pk.R...) {
+		if base == nil || base.Sign() <= 0 || base.Cmp(pk.N) >= 0 {
+			return fmt.Errorf("Invalid issuer public key. Base out of range.")
+		}
+	}
+
+	return nil
+}
+
+// safePrime returns a prime p of bits bits such that p' = (p - 1) / 2 is prime too
+func safePrime(bits int) (p, pPrime *big.Int, err error) {
+	for {
+		if pPrime, err = rand.Prime(primitives.RandReader, bits-1); err != nil {
+			return nil, nil, err
+		}
+		p = new(big.Int).Lsh(pPrime, 1)
+		p.Add(p, one)
+		if p.BitLen() == bits && p.ProbablyPrime(20) {
+			return p, pPrime, nil
+		}
+	}
+}
+
+// quadraticResidue returns a random quadratic residue modulo n, other than 1
+func quadraticResidue(n *big.Int) (*big.Int, error) {
+	for {
+		x, err := rand.Int(primitives.RandReader, n)
+		if err != nil {
+			return nil, err
+		}
+		if new(big.Int).GCD(nil, nil, x, n).Cmp(one) != 0 {
+			continue
+		}
+		x.Exp(x, big.NewInt(2), n)
+		if x.Cmp(one) != 0 {
+			return x, nil
+		}
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idemix
+
+import (
+	"encoding/asn1"
+	"math/big"
+	"sort"
+)
+
+// A signature is a non-interactive proof of knowledge of a credential,
+// bound to the message signed. The signer randomizes A into
+// A' = A S^rA, and proves knowledge of e' = e - 2^(le-1), v' = v - e rA and
+// of the hidden attributes, the user secret among them, such that
+// Z / (A'^2^(le-1) Rd^md...) = A'^e' S^v' Rh^mh..., d ranging over the
+// disclosed attributes and h over the hidden ones.
+
+// Signature is an anonymous signature by the holder of a credential,
+// disclosing the attributes of indices Disclosed, valued Values
+type Signature struct {
+	APrime    *big.Int
+	C         *big.Int
+	SE        *big.Int
+	SV        *big.Int
+	SM        []*big.Int
+	Disclosed []int
+	Values    [][]byte
+}
+
+// Sign signs msg with credential, issued by pk, disclosing the attributes disclosed
+func (credential *Credential) Sign(pk *IssuerPublicKey, disclosed []string, msg []byte) (*Signature, error) {
+	if len(credential.Attributes) != len(pk.AttributeNames) {
+		return nil, ErrInvalidCredential
+	}
+
+	indices := []int{}
+	for _, name := range disclosed {
+		index := pk.AttributeIndex(name)
+		if index < 0 {
+			return nil, ErrUnknownAttribute
+		}
+		indices = append(indices, index)
+	}
+	indices = uniqueSorted(indices)
+	values := make([][]byte, len(indices))
+	for i, index := range indices {
+		values[i] = credential.Attributes[index-1]
+	}
+	hidden := hiddenIndices(len(pk.R), indices)
+
+	ln := pk.N.BitLen()
+
+	// Randomize A
+	rA, err := randomBits(ln + lstat)
+	if err != nil {
+		return nil, err
+	}
+	aPrime := new(big.Int).Mul(credential.A, new(big.Int).Exp(pk.S, rA, pk.N))
+	aPrime.Mod(aPrime, pk.N)
+	vPrime := new(big.Int).Sub(credential.V, new(big.Int).Mul(credential.E, rA))
+	ePrime := new(big.Int).Sub(credential.E, new(big.Int).Lsh(one, le-1))
+
+	// Commit
+	rE, err := randomBits(lePrime + lstat + lh)
+	if err != nil {
+		return nil, err
+	}
+	rV, err := randomBits(lv(ln) + lstat + lh)
+	if err != nil {
+		return nil, err
+	}
+	rM := make([]*big.Int, len(hidden))
+	t := new(big.Int).Mul(new(big.Int).Exp(aPrime, rE, pk.N), expMod(pk.S, rV, pk.N))
+	for i, index := range hidden {
+		if rM[i], err = randomBits(lm + lstat + lh); err != nil {
+			return nil, err
+		}
+		t.Mul(t, new(big.Int).Exp(pk.R[index], rM[i], pk.N))
+		t.Mod(t, pk.N)
+	}
+	t.Mod(t, pk.N)
+
+	c, err := signatureChallenge(pk, aPrime, t, indices, values, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Respond
+	signature := &Signature{
+		APrime:    aPrime,
+		C:         c,
+		SE:        rE.Add(rE, new(big.Int).Mul(c, ePrime)),
+		SV:        rV.Add(rV, new(big.Int).Mul(c, vPrime)),
+		SM:        make([]*big.Int, len(hidden)),
+		Disclosed: indices,
+		Values:    values,
+	}
+	for i, index := range hidden {
+		m := credential.Secret
+		if index > 0 {
+			m = AttributeValue(credential.Attributes[index-1])
+		}
+		signature.SM[i] = rM[i].Add(rM[i], new(big.Int).Mul(c, m))
+	}
+
+	return signature, nil
+}
+
+// Verify checks that signature is a signature of msg by the holder of a
+// credential issued by pk, whose attributes are the disclosed ones
+func (signature *Signature) Verify(pk *IssuerPublicKey, msg []byte) error {
+	if signature.APrime == nil || signature.C == nil || signature.SE == nil || signature.SV == nil {
+		return ErrInvalidProof
+	}
+	if signature.APrime.Sign() <= 0 || signature.APrime.Cmp(pk.N) >= 0 {
+		return ErrInvalidProof
+	}
+	if len(signature.Values) != len(signature.Disclosed) {
+		return ErrInvalidProof
+	}
+	for i, index := range signature.Disclosed {
+		if index < 1 || index >= len(pk.R) || (i > 0 && index <= signature.Disclosed[i-1]) {
+			return ErrInvalidProof
+		}
+	}
+	hidden := hiddenIndices(len(pk.R), signature.Disclosed)
+	if len(signature.SM) != len(hidden) {
+		return ErrInvalidProof
+	}
+
+	ln := pk.N.BitLen()
+	if !inRange(signature.SE, lePrime+lstat+lh+1) || !inRange(signature.SV, lv(ln)+lstat+lh+1) {
+		return ErrInvalidProof
+	}
+	for _, sm := range signature.SM {
+		if sm == nil || !inRange(sm, lm+lstat+lh+1) {
+			return ErrInvalidProof
+		}
+	}
+
+	// T = Z / (A'^2^(le-1) Rd^md...)
+	d := new(big.Int).Exp(signature.APrime, new(big.Int).Lsh(one, le-1), pk.N)
+	for i, index := range signature.Disclosed {
+		d.Mul(d, new(big.Int).Exp(pk.R[index], AttributeValue(signature.Values[i]), pk.N))
+		d.Mod(d, pk.N)
+	}
+	if d.ModInverse(d, pk.N) == nil {
+		return ErrInvalidProof
+	}
+	tt := d.Mul(d, pk.Z)
+	tt.Mod(tt, pk.N)
+
+	// t = T^-c A'^se S^sv Rh^sm...
+	t := expMod(tt, new(big.Int).Neg(signature.C), pk.N)
+	t.Mul(t, expMod(signature.APrime, signature.SE, pk.N))
+	t.Mul(t, expMod(pk.S, signature.SV, pk.N))
+	t.Mod(t, pk.N)
+	for i, index := range hidden {
+		t.Mul(t, expMod(pk.R[index], signature.SM[i], pk.N))
+		t.Mod(t, pk.N)
+	}
+
+	c, err := signatureChallenge(pk, signature.APrime, t, signature.Disclosed, signature.Values, msg)
+	if err != nil {
+		return err
+	}
+	if c.Cmp(signature.C) != 0 {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// Attributes returns the attributes disclosed by signature, issued by pk, by name
+func (signature *Signature) Attributes(pk *IssuerPublicKey) map[string][]byte {
+	attributes := map[string][]byte{}
+	for i, index := range signature.Disclosed {
+		if index >= 1 && index <= len(pk.AttributeNames) && i < len(signature.Values) {
+			attributes[pk.AttributeNames[index-1]] = signature.Values[i]
+		}
+	}
+
+	return attributes
+}
+
+// Bytes marshals the signature
+func (signature *Signature) Bytes() ([]byte, error) {
+	return asn1.Marshal(*signature)
+}
+
+// ParseSignature unmarshals a signature
+func ParseSignature(raw []byte) (*Signature, error) {
+	signature := &Signature{}
+	if _, err := asn1.Unmarshal(raw, signature); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+func signatureChallenge(pk *IssuerPublicKey, aPrime, t *big.Int, disclosed []int, values [][]byte, msg []byte) (*big.Int, error) {
+	pkHash, err := pk.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge(pkHash, aPrime, t, disclosed, values, msg)
+}
+
+// hiddenIndices returns the indices, in [0, n), not in disclosed
+func hiddenIndices(n int, disclosed []int) []int {
+	isDisclosed := map[int]bool{}
+	for _, index := range disclosed {
+		isDisclosed[index] = true
+	}
+
+	hidden := []int{}
+	for i := 0; i < n; i++ {
+		if !isDisclosed[i] {
+			hidden = append(hidden, i)
+		}
+	}
+
+	return hidden
+}
+
+func uniqueSorted(indices []int) []int {
+	sort.Ints(indices)
+
+	unique := []int{}
+	for i, index := range indices {
+		if i == 0 || index != indices[i-1] {
+			unique = append(unique, index)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,200 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// Under confidentiality protocol 1.3, the holders of the chaincode key read
+// the transactions of the chaincode. The invoker can restrict the payload
+// of a transaction further, to the identities and roles of an access policy:
+//
+//	payload key  a random AES key generated by the invoker. The payload is
+//	             encrypted under it, then under the tx key as the other
+//	             fields, so that the holders of the chaincode key do not
+//	             read it.
+//	policy       carried, along with the payload key, in the message wrapped
+//	             under the chain key. Only the validators read it.
+//
+// A permitted identity asks a validator for access with a request signed
+// with its enrollment key, bound to the transaction nonce and carrying an
+// ephemeral public key. The validator resolves the policy against the
+// enrollment certificate of the request, its common name, its role and its
+// affiliation, and
+// grants the keys of the two layers of the payload, encrypted under the
+// ephemeral key. The keys are specific to the transaction: the grant reveals
+// neither the chaincode key nor the payload of another transaction.
+
+// Private type and variables
+
+const (
+	payloadAccessNamespace = "payloadAccess"
+)
+
+var (
+	errAccessPolicyUnsupported = errors.New("Access policies require confidentiality protocol 1.3.")
+	errNoAccessPolicy          = errors.New("Transaction without access policy.")
+	errNoPayloadAccessRequest  = errors.New("No access request for the transaction.")
+	errInvalidAccessRequest    = errors.New("Access request for another transaction.")
+)
+
+// accessPolicy is the ASN.1 encoding of an AccessPolicy
+type accessPolicy struct {
+	Identities   []string
+	Roles        []int
+	Affiliations []string `asn1:"optional"`
+}
+
+// payloadAccess is carried to the validators along with the chaincode key
+type payloadAccess struct {
+	PayloadKey []byte
+	Policy     accessPolicy
+}
+
+// payloadAccessRequest is the request of a permitted identity, whose
+// enrollment certificate is Cert, to read the payload of a transaction
+type payloadAccessRequest struct {
+	Nonce     []byte
+	Cert      []byte
+	PublicKey []byte
+	Signature []byte `asn1:"optional"`
+}
+
+// payloadAccessGrant holds the keys of the two layers of the payload
+type payloadAccessGrant struct {
+	TxPayloadKey []byte
+	PayloadKey   []byte
+}
+
+// payloadAccessRequester is the identity of a checked access request
+// and the key to encrypt the grant under
+type payloadAccessRequester struct {
+	cert      *x509.Certificate
+	publicKey []byte
+}
+
+func newAccessPolicy(policy *AccessPolicy) accessPolicy {
+	encoded := accessPolicy{Identities: policy.Identities, Roles: []int{}, Affiliations: policy.Affiliations}
+	if encoded.Identities == nil {
+		encoded.Identities = []string{}
+	}
+	for _, role := range policy.Roles {
+		encoded.Roles = append(encoded.Roles, int(role))
+	}
+
+	return encoded
+}
+
+// permits returns true if the owner of the enrollment certificate
+// cert is one of the identities, has one of the roles or belongs to
+// one of the affiliation subtrees of policy
+func (policy *accessPolicy) permits(cert *x509.Certificate) bool {
+	for _, id := range policy.Identities {
+		if id == cert.Subject.CommonName {
+			return true
+		}
+	}
+
+	role, ok := enrollmentCertRole(cert)
+	if !ok {
+		return false
+	}
+	for _, r := range policy.Roles {
+		if NodeType(r) == role {
+			return true
+		}
+	}
+
+	path := enrollmentCertAffiliation(cert)
+	for _, affiliation := range policy.Affiliations {
+		for _, group := range path {
+			if group == affiliation {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// markFabricExtensionsHandled marks as handled the critical extensions of
+// cert the fabric handles, not x509: the role of an ECert and the
+// TCertIndex of a TCert. x509 refuses to verify cert otherwise.
+func markFabricExtensionsHandled(cert *x509.Certificate) {
+	primitives.GetCriticalExtension(cert, ECertSubjectRole)
+	primitives.GetCriticalExtension(cert, primitives.TCertEncTCertIndex)
+}
+
+// enrollmentCertRole returns the role of the owner of the
+// enrollment certificate cert, recorded by the ECA
+func enrollmentCertRole(cert *x509.Certificate) (NodeType, bool) {
+	raw, err := primitives.GetCriticalExtension(cert, ECertSubjectRole)
+	if err != nil {
+		return 0, false
+	}
+	role, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, false
+	}
+
+	switch membersrvc.Role(role) {
+	case membersrvc.Role_CLIENT:
+		return NodeClient, true
+	case membersrvc.Role_PEER:
+		return NodePeer, true
+	case membersrvc.Role_VALIDATOR:
+		return NodeValidator, true
+	}
+
+	return 0, false
+}
+
+// signedBytes returns the bytes the signature of request is on
+func (request *payloadAccessRequest) signedBytes() ([]byte, error) {
+	unsigned := *request
+	unsigned.Signature = nil
+
+	return asn1.Marshal(unsigned)
+}
+
+// payloadLayerKey derives, from the key of a layer of the
+// payload, the key the payload is encrypted under
+func payloadLayerKey(key []byte) []byte {
+	return primitives.HMACAESTruncated(key, []byte{1})
+}
+
+// enrollmentCertAffiliation returns the path of the affiliation group
+// of the owner of the enrollment certificate cert, from the top-level
+// group down, recorded by the ECA. It is empty if the owner has no
+// affiliation.
+func enrollmentCertAffiliation(cert *x509.Certificate) []string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ECertSubjectAffiliation) && len(ext.Value) > 0 {
+			return strings.Split(string(ext.Value), "/")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// The ECA and the TCA may operate as intermediates of an external, e.g.
+// enterprise, CA. A node retrieves their certificates chains, from the CA
+// certificate up to the root, when it registers, and trusts all the
+// certificates of a chain to verify the certificates the CA issues. If
+// peer.pki.ca.rootcert.file is set, a chain must end with one of the roots
+// in that file.
+
+// verifyCACertsChain checks that each certificate of chain is issued by the
+// next one and, if configured, that the chain ends with a trusted root. It
+// returns the chain PEM encoded.
+func (node *nodeImpl) verifyCACertsChain(chain [][]byte) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("Empty CA certificates chain.")
+	}
+
+	certs := make([]*x509.Certificate, len(chain))
+	var pem []byte
+	for i, der := range chain {
+		cert, err := primitives.DERToX509Certificate(der)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			if err = certs[i-1].CheckSignatureFrom(cert); err != nil {
+				return nil, fmt.Errorf("Broken CA certificates chain [%s].", err)
+			}
+		}
+		certs[i] = cert
+		pem = append(pem, primitives.DERCertToPEM(der)...)
+	}
+
+	path := node.conf.getCARootCertsExternalPath()
+	if path == "" {
+		return pem, nil
+	}
+
+	raw, err := node.ks.loadExternalCert(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(raw) {
+		return nil, errors.New("Failed appending CA root certificates.")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err = certs[0].Verify(opts); err != nil {
+		return nil, fmt.Errorf("CA certificate not issued by a trusted root [%s].", err)
+	}
+
+	return pem, nil
+}
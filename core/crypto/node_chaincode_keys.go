@@ -0,0 +1,99 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// Confidentiality protocol 1.3 encrypts the transactions and the state of
+// each chaincode under a key hierarchy rooted in the chain key:
+//
+//	chain key      the validators' chain key pair. It wraps the chaincode
+//	               key carried by each transaction to the validators.
+//	chaincode key  a random AES key generated by the deployer. The deployer
+//	               hands it to the clients authorized to invoke the chaincode.
+//	tx keys        derived from the chaincode key and the transaction nonce.
+//	               They encrypt the payload, chaincodeID, metadata and
+//	               attributes keys of the transaction.
+//	state keys     derived from the chaincode key and the deploy nonce, then
+//	               from the invoke nonce as in the previous versions.
+//
+// As in version 1.2, the result of a query is encrypted under a key derived
+// from a secret of its invoker, carried to the validators along with the
+// chaincode key.
+//
+// Only the validators and the holders of the chaincode key can then read
+// the transactions and the state of the chaincode, and the validators
+// reject the transactions carrying another chaincode key than the deployed
+// one.
+
+const (
+	chaincodeKeysNamespace = "chaincodeKeys"
+)
+
+// Private Methods
+
+// chaincodeTxKey derives the key encrypting the transaction with nonce
+func chaincodeTxKey(chaincodeKey, nonce []byte) []byte {
+	return primitives.HMAC(chaincodeKey, append([]byte{1}, nonce...))
+}
+
+// chaincodeStateKey derives the root of the keys encrypting the state of
+// the chaincode deployed by the transaction with deployNonce
+func chaincodeStateKey(chaincodeKey, deployNonce []byte) []byte {
+	return primitives.HMAC(chaincodeKey, append([]byte{2}, deployNonce...))
+}
+
+// chaincodeKeyName returns the name the key of chaincodeID is stored under:
+// its name, or its path if the chaincode is not named yet
+func chaincodeKeyName(chaincodeID *obc.ChaincodeID) (string, error) {
+	if chaincodeID == nil {
+		return "", errors.New("Invalid chaincodeID. It is nil.")
+	}
+	if chaincodeID.Name != "" {
+		return chaincodeID.Name, nil
+	}
+	if chaincodeID.Path != "" {
+		return chaincodeID.Path, nil
+	}
+
+	return "", errors.New("Invalid chaincodeID. Both name and path are empty.")
+}
+
+// chaincodeKeyNameFromTx returns the name the chaincode key of the
+// transaction, whose chaincodeID is still in clear, is stored under
+func chaincodeKeyNameFromTx(tx *obc.Transaction) (string, error) {
+	chaincodeID := &obc.ChaincodeID{}
+	if err := proto.Unmarshal(tx.ChaincodeID, chaincodeID); err != nil {
+		return "", err
+	}
+
+	return chaincodeKeyName(chaincodeID)
+}
+
+func (ks *keyStore) loadChaincodeKey(name string) ([]byte, error) {
+	return ks.GetBlob(chaincodeKeysNamespace, name)
+}
+
+func (ks *keyStore) storeChaincodeKey(name string, chaincodeKey []byte) error {
+	return ks.PutBlob(chaincodeKeysNamespace, name, chaincodeKey)
+}
@@ -18,9 +18,11 @@ package crypto
 
 import (
 	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
-
-	"github.com/spf13/viper"
+	"strconv"
+	"time"
 )
 
 func (node *nodeImpl) initConfiguration(name string) (err error) {
@@ -28,7 +30,7 @@ func (node *nodeImpl) initConfiguration(name string) (err error) {
 	prefix := eTypeToString(node.eType)
 
 	// Set configuration
-	node.conf = &configuration{prefix: prefix, name: name}
+	node.conf = &configuration{prefix: prefix, name: name, v: node.opts.Config, dataPath: node.opts.DataPath}
 	if err = node.conf.init(); err != nil {
 		return
 	}
@@ -42,11 +44,20 @@ type configuration struct {
 	prefix string
 	name   string
 
+	// Source of the settings, the global viper configuration by default
+	v Config
+
+	// Root of the data of the node, overriding peer.fileSystemPath if set
+	dataPath string
+
 	logPrefix string
 
 	rootDataPath      string
 	configurationPath string
 	keystorePath      string
+	keyStoreDBPath    string
+	keyStoreFilename  string
+	keyStoreFileMode  os.FileMode
 	rawsPath          string
 	tCertsPath        string
 
@@ -55,14 +66,102 @@ type configuration struct {
 	tcaPAddressProperty       string
 	tlscaPAddressProperty     string
 
-	securityLevel                  int
-	hashAlgorithm                  string
+	signatureScheme                string
+	rsaKeySize                     int
 	confidentialityProtocolVersion string
 
 	tlsServerName string
 
-	multiThreading bool
-	tCertBatchSize int
+	multiThreading      bool
+	tCertBatchSize      int
+	tCertValidityPeriod time.Duration
+
+	tCertPoolWatermark      int
+	tCertPoolRefillInterval time.Duration
+	tCertPoolSize           int
+
+	keyStoreInMemory bool
+	keyStoreShared   bool
+	keyStoreReadOnly bool
+
+	certStoreBackend      string
+	certRenewalWindow     time.Duration
+	certFetchTimeout      time.Duration
+	certFetchAttempts     int
+	certFetchBackoff      time.Duration
+	certFetchMaxBackoff   time.Duration
+	certFetchJitter       float64
+	certStoreMaxEntries   int
+	certStoreTrimInterval time.Duration
+	certHotCacheSize      int
+	certVerifyCacheSize   int
+
+	keyStoreMaxOpenConns int
+	keyStoreMaxIdleConns int
+	keyStoreBusyTimeout  int
+	keyStoreJournalMode  string
+	keyStoreSynchronous  string
+	keyStoreDBKey        string
+
+	keyStoreCompactInterval  time.Duration
+	keyStoreCompactThreshold int64
+
+	auditEnabled bool
+
+	signatureLogEnabled  bool
+	signatureLogMaxSize  int64
+	signatureLogMaxFiles int
+
+	keyStoreEncryption           bool
+	keyStoreEncryptionPassphrase string
+
+	secretStoreBackend string
+	secretStoreAddress string
+	secretStoreToken   string
+	secretStorePath    string
+	secretStoreTimeout time.Duration
+
+	hsmEnabled bool
+	hsmLibrary string
+	hsmLabel   string
+	hsmPin     string
+
+	tpmEnabled bool
+	tpmDevice  string
+
+	cspProvider string
+	cspOptions  map[string]string
+
+	reenrollmentEnabled  bool
+	reenrollmentWindow   time.Duration
+	reenrollmentInterval time.Duration
+	reenrollmentSecret   string
+	reenrollmentOverlap  time.Duration
+
+	tlsRenewalEnabled  bool
+	tlsRenewalWindow   time.Duration
+	tlsRenewalInterval time.Duration
+
+	tlsDNSNames    []string
+	tlsIPAddresses []string
+
+	ocspEnabled   bool
+	ocspResponder string
+	ocspPolicy    string
+	ocspTimeout   time.Duration
+	ocspCacheTTL  time.Duration
+
+	crlPollingEnabled  bool
+	crlPollingInterval time.Duration
+
+	crlFeedEnabled       bool
+	crlFeedRetryInterval time.Duration
+
+	eCertExchangeEnabled bool
+	eCertExchangeTimeout time.Duration
+
+	idemixEnabled         bool
+	idemixIssuerPublicKey string
 }
 
 func (conf *configuration) init() error {
@@ -71,10 +170,15 @@ func (conf *configuration) init() error {
 	conf.tcaPAddressProperty = "peer.pki.tca.paddr"
 	conf.tlscaPAddressProperty = "peer.pki.tlsca.paddr"
 	conf.logPrefix = "[" + conf.prefix + "." + conf.name + "] "
+	if conf.v == nil {
+		conf.v = globalConfig{}
+	}
 
 	// Check mandatory fields
-	if err := conf.checkProperty(conf.configurationPathProperty); err != nil {
-		return err
+	if conf.dataPath == "" {
+		if err := conf.checkProperty(conf.configurationPathProperty); err != nil {
+			return err
+		}
 	}
 	if err := conf.checkProperty(conf.ecaPAddressProperty); err != nil {
 		return err
@@ -86,7 +190,10 @@ func (conf *configuration) init() error {
 		return err
 	}
 
-	conf.configurationPath = viper.GetString(conf.configurationPathProperty)
+	conf.configurationPath = conf.dataPath
+	if conf.configurationPath == "" {
+		conf.configurationPath = conf.v.GetString(conf.configurationPathProperty)
+	}
 	conf.rootDataPath = conf.configurationPath
 
 	// Set configuration path
@@ -98,31 +205,79 @@ func (conf *configuration) init() error {
 	// Set ks path
 	conf.keystorePath = filepath.Join(conf.configurationPath, "ks")
 
+	// Set keystore DB path. A shared keystore DB hosts the tables of all
+	// the nodes of the process, each namespaced by its owner
+	conf.keyStoreShared = false
+	if conf.v.IsSet("security.keystore.shared") {
+		conf.keyStoreShared = conf.v.GetBool("security.keystore.shared")
+	}
+	conf.keyStoreDBPath = conf.keystorePath
+	if conf.keyStoreShared {
+		conf.keyStoreDBPath = filepath.Join(conf.rootDataPath, "crypto", "ks")
+	}
+
+	// Set keystore DB location and permissions. Relative paths are
+	// relative to the root data path
+	if conf.v.IsSet(conf.getKeyStoreProperty("path")) {
+		ovveride := conf.v.GetString(conf.getKeyStoreProperty("path"))
+		if ovveride != "" {
+			if !filepath.IsAbs(ovveride) {
+				ovveride = filepath.Join(conf.rootDataPath, ovveride)
+			}
+			conf.keyStoreDBPath = ovveride
+		}
+	}
+	conf.keyStoreFilename = "db"
+	if conf.v.IsSet(conf.getKeyStoreProperty("filename")) {
+		ovveride := conf.v.GetString(conf.getKeyStoreProperty("filename"))
+		if ovveride != "" {
+			conf.keyStoreFilename = ovveride
+		}
+	}
+	conf.keyStoreFileMode = 0
+	if conf.v.IsSet(conf.getKeyStoreProperty("fileMode")) {
+		ovveride := conf.v.GetString(conf.getKeyStoreProperty("fileMode"))
+		if ovveride != "" {
+			// Octal if written with a leading 0
+			mode, err := strconv.ParseUint(ovveride, 0, 32)
+			if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+				return fmt.Errorf("Invalid keystore DB file mode [%s].", ovveride)
+			}
+			conf.keyStoreFileMode = os.FileMode(mode)
+		}
+	}
+
 	// Set raws path
 	conf.rawsPath = filepath.Join(conf.keystorePath, "raw")
 
 	// Set tCerts path
 	conf.tCertsPath = filepath.Join(conf.keystorePath, "tcerts")
 
-	conf.securityLevel = 384
-	if viper.IsSet("security.level") {
-		ovveride := viper.GetInt("security.level")
-		if ovveride != 0 {
-			conf.securityLevel = ovveride
+	conf.signatureScheme = signatureSchemeECDSA
+	if conf.v.IsSet("security.signatureScheme") {
+		ovveride := conf.v.GetString("security.signatureScheme")
+		if ovveride != "" {
+			if ovveride != signatureSchemeECDSA && ovveride != signatureSchemeEd25519 && ovveride != signatureSchemeRSA {
+				return fmt.Errorf("Invalid signature scheme [%s]. It must be %s, %s or %s.", ovveride, signatureSchemeECDSA, signatureSchemeEd25519, signatureSchemeRSA)
+			}
+			conf.signatureScheme = ovveride
 		}
 	}
 
-	conf.hashAlgorithm = "SHA3"
-	if viper.IsSet("security.hashAlgorithm") {
-		ovveride := viper.GetString("security.hashAlgorithm")
-		if ovveride != "" {
-			conf.hashAlgorithm = ovveride
+	conf.rsaKeySize = 2048
+	if conf.v.IsSet("security.rsa.keySize") {
+		ovveride := conf.v.GetInt("security.rsa.keySize")
+		if ovveride != 0 {
+			if ovveride != 2048 && ovveride != 4096 {
+				return fmt.Errorf("Invalid RSA key size [%d]. It must be 2048 or 4096.", ovveride)
+			}
+			conf.rsaKeySize = ovveride
 		}
 	}
 
 	conf.confidentialityProtocolVersion = "1.2"
-	if viper.IsSet("security.confidentialityProtocolVersion") {
-		ovveride := viper.GetString("security.confidentialityProtocolVersion")
+	if conf.v.IsSet("security.confidentialityProtocolVersion") {
+		ovveride := conf.v.GetString("security.confidentialityProtocolVersion")
 		if ovveride != "" {
 			conf.confidentialityProtocolVersion = ovveride
 		}
@@ -130,8 +285,8 @@ func (conf *configuration) init() error {
 
 	// Set TLS host override
 	conf.tlsServerName = "tlsca"
-	if viper.IsSet("peer.pki.tls.serverhostoverride") {
-		ovveride := viper.GetString("peer.pki.tls.serverhostoverride")
+	if conf.v.IsSet("peer.pki.tls.serverhostoverride") {
+		ovveride := conf.v.GetString("peer.pki.tls.serverhostoverride")
 		if ovveride != "" {
 			conf.tlsServerName = ovveride
 		}
@@ -139,24 +294,413 @@ func (conf *configuration) init() error {
 
 	// Set tCertBatchSize
 	conf.tCertBatchSize = 200
-	if viper.IsSet("security.tcert.batch.size") {
-		ovveride := viper.GetInt("security.tcert.batch.size")
+	if conf.v.IsSet("security.tcert.batch.size") {
+		ovveride := conf.v.GetInt("security.tcert.batch.size")
+		if ovveride < 0 || ovveride > maxTCertBatchSize {
+			return fmt.Errorf("Invalid TCert batch size [%d]. It must be between 1 and %d.", ovveride, maxTCertBatchSize)
+		}
 		if ovveride != 0 {
 			conf.tCertBatchSize = ovveride
 		}
 	}
 
+	// Set the validity period requested for the TCerts, the one of the TCA by default
+	conf.tCertValidityPeriod = 0
+	if conf.v.IsSet("security.tcert.validityPeriod") {
+		conf.tCertValidityPeriod = conf.v.GetDuration("security.tcert.validityPeriod")
+		if conf.tCertValidityPeriod < 0 {
+			return fmt.Errorf("Invalid TCert validity period [%s]. It must not be negative.", conf.tCertValidityPeriod)
+		}
+	}
+
+	// Set the TCert pool refill, disabled by default
+	conf.tCertPoolWatermark = 0
+	if conf.v.IsSet("security.tcert.pool.watermark") {
+		conf.tCertPoolWatermark = conf.v.GetInt("security.tcert.pool.watermark")
+		if conf.tCertPoolWatermark < 0 {
+			return fmt.Errorf("Invalid TCert pool watermark [%d]. It must not be negative.", conf.tCertPoolWatermark)
+		}
+	}
+	conf.tCertPoolRefillInterval = 5 * time.Second
+	if conf.v.IsSet("security.tcert.pool.refillInterval") {
+		ovveride := conf.v.GetDuration("security.tcert.pool.refillInterval")
+		if ovveride > 0 {
+			conf.tCertPoolRefillInterval = ovveride
+		}
+	}
+
+	// Set the capacity of the multi-threaded TCert pool, two batches by default
+	conf.tCertPoolSize = 2 * conf.tCertBatchSize
+	if conf.v.IsSet("security.tcert.pool.size") {
+		ovveride := conf.v.GetInt("security.tcert.pool.size")
+		if ovveride != 0 && (ovveride < conf.tCertBatchSize || ovveride > 2*maxTCertBatchSize) {
+			return fmt.Errorf("Invalid TCert pool size [%d]. It must be between the batch size %d and %d.", ovveride, conf.tCertBatchSize, 2*maxTCertBatchSize)
+		}
+		if ovveride != 0 {
+			conf.tCertPoolSize = ovveride
+		}
+	}
+	if conf.tCertPoolWatermark > conf.tCertPoolSize {
+		return fmt.Errorf("Invalid TCert pool watermark [%d]. It must be at most the pool size %d.", conf.tCertPoolWatermark, conf.tCertPoolSize)
+	}
+
+	// Set in-memory keystore
+	conf.keyStoreInMemory = false
+	if conf.v.IsSet("security.keystore.inmemory") {
+		conf.keyStoreInMemory = conf.v.GetBool("security.keystore.inmemory")
+	}
+
+	// Set read-only keystore
+	conf.keyStoreReadOnly = false
+	if conf.v.IsSet("security.keystore.readOnly") {
+		conf.keyStoreReadOnly = conf.v.GetBool("security.keystore.readOnly")
+	}
+
+	// Set keystore DB pool
+	conf.keyStoreMaxOpenConns = 0
+	if conf.v.IsSet("security.keystore.db.maxOpenConns") {
+		conf.keyStoreMaxOpenConns = conf.v.GetInt("security.keystore.db.maxOpenConns")
+	}
+	conf.keyStoreMaxIdleConns = 2
+	if conf.v.IsSet("security.keystore.db.maxIdleConns") {
+		conf.keyStoreMaxIdleConns = conf.v.GetInt("security.keystore.db.maxIdleConns")
+	}
+	conf.keyStoreBusyTimeout = 5000
+	if conf.v.IsSet("security.keystore.db.busyTimeout") {
+		ovveride := conf.v.GetInt("security.keystore.db.busyTimeout")
+		if ovveride != 0 {
+			conf.keyStoreBusyTimeout = ovveride
+		}
+	}
+	conf.keyStoreJournalMode = "WAL"
+	if conf.v.IsSet("security.keystore.db.journalMode") {
+		conf.keyStoreJournalMode = conf.v.GetString("security.keystore.db.journalMode")
+	}
+	conf.keyStoreSynchronous = "NORMAL"
+	if conf.v.IsSet("security.keystore.db.synchronous") {
+		conf.keyStoreSynchronous = conf.v.GetString("security.keystore.db.synchronous")
+	}
+
+	// Set the SQLCipher key of the keystore DB, best passed through the environment
+	conf.keyStoreDBKey = conf.v.GetString(conf.getKeyStoreProperty("key"))
+
+	// Set cert store backend
+	conf.certStoreBackend = "sqlite"
+	if conf.keyStoreInMemory {
+		conf.certStoreBackend = "memory"
+	}
+	if conf.v.IsSet("security.keystore.certstore.backend") {
+		ovveride := conf.v.GetString("security.keystore.certstore.backend")
+		if ovveride != "" {
+			conf.certStoreBackend = ovveride
+		}
+	}
+
+	// Set keystore encryption
+	conf.keyStoreEncryption = false
+	if conf.v.IsSet("security.keystore.encryption.enabled") {
+		conf.keyStoreEncryption = conf.v.GetBool("security.keystore.encryption.enabled")
+	}
+	conf.keyStoreEncryptionPassphrase = conf.v.GetString("security.keystore.encryption.passphrase")
+
+	// Set remote secret store
+	conf.secretStoreBackend = conf.v.GetString("security.keystore.secrets.backend")
+	conf.secretStoreAddress = conf.v.GetString("security.keystore.secrets.address")
+	conf.secretStoreToken = conf.v.GetString("security.keystore.secrets.token")
+	conf.secretStorePath = "secret/fabric"
+	if conf.v.IsSet("security.keystore.secrets.path") {
+		ovveride := conf.v.GetString("security.keystore.secrets.path")
+		if ovveride != "" {
+			conf.secretStorePath = ovveride
+		}
+	}
+	conf.secretStoreTimeout = 10 * time.Second
+	if conf.v.IsSet("security.keystore.secrets.timeout") {
+		ovveride := conf.v.GetDuration("security.keystore.secrets.timeout")
+		if ovveride > 0 {
+			conf.secretStoreTimeout = ovveride
+		}
+	}
+
+	// Set PKCS#11 token holding the enrollment key
+	conf.hsmEnabled = false
+	if conf.v.IsSet("security.hsm.enabled") {
+		conf.hsmEnabled = conf.v.GetBool("security.hsm.enabled")
+	}
+	conf.hsmLibrary = conf.v.GetString("security.hsm.library")
+	conf.hsmLabel = conf.v.GetString("security.hsm.label")
+	conf.hsmPin = conf.v.GetString("security.hsm.pin")
+
+	// Set TPM the enrollment key is sealed to
+	conf.tpmEnabled = false
+	if conf.v.IsSet("security.tpm.enabled") {
+		conf.tpmEnabled = conf.v.GetBool("security.tpm.enabled")
+	}
+	conf.tpmDevice = "/dev/tpmrm0"
+	if conf.v.IsSet("security.tpm.device") {
+		ovveride := conf.v.GetString("security.tpm.device")
+		if ovveride != "" {
+			conf.tpmDevice = ovveride
+		}
+	}
+
+	// Set the cryptographic service provider
+	conf.cspProvider = "sw"
+	if conf.v.IsSet("security.csp.provider") {
+		ovveride := conf.v.GetString("security.csp.provider")
+		if ovveride != "" {
+			conf.cspProvider = ovveride
+		}
+	}
+	conf.cspOptions = conf.v.GetStringMapString("security.csp.options")
+
+	// Set the window before expiry in which cached certs are re-fetched
+	conf.certRenewalWindow = 0
+	if conf.v.IsSet("security.keystore.certstore.renewalWindow") {
+		conf.certRenewalWindow = conf.v.GetDuration("security.keystore.certstore.renewalWindow")
+	}
+
+	// Set the deadline for retrieving a cert, ECA fetch included
+	conf.certFetchTimeout = 0
+	if conf.v.IsSet("security.keystore.certstore.fetchTimeout") {
+		conf.certFetchTimeout = conf.v.GetDuration("security.keystore.certstore.fetchTimeout")
+	}
+
+	// Set the retries of the ECA fetches failing for want of the ECA
+	conf.certFetchAttempts = 3
+	if conf.v.IsSet("security.keystore.certstore.retry.attempts") {
+		ovveride := conf.v.GetInt("security.keystore.certstore.retry.attempts")
+		if ovveride > 0 {
+			conf.certFetchAttempts = ovveride
+		}
+	}
+	conf.certFetchBackoff = 200 * time.Millisecond
+	if conf.v.IsSet("security.keystore.certstore.retry.initialBackoff") {
+		conf.certFetchBackoff = conf.v.GetDuration("security.keystore.certstore.retry.initialBackoff")
+	}
+	conf.certFetchMaxBackoff = 5 * time.Second
+	if conf.v.IsSet("security.keystore.certstore.retry.maxBackoff") {
+		conf.certFetchMaxBackoff = conf.v.GetDuration("security.keystore.certstore.retry.maxBackoff")
+	}
+	if conf.certFetchMaxBackoff < conf.certFetchBackoff {
+		conf.certFetchMaxBackoff = conf.certFetchBackoff
+	}
+	conf.certFetchJitter = 0.2
+	if conf.v.IsSet("security.keystore.certstore.retry.jitter") {
+		ovveride := conf.v.GetFloat64("security.keystore.certstore.retry.jitter")
+		if ovveride < 0 || ovveride > 1 {
+			return fmt.Errorf("Invalid ECA fetch retry jitter [%f]. It must be between 0 and 1.", ovveride)
+		}
+		conf.certFetchJitter = ovveride
+	}
+
+	// Set how often the keystore DB and the cert store are compacted
+	conf.keyStoreCompactInterval = 0
+	if conf.v.IsSet("security.keystore.db.compactInterval") {
+		conf.keyStoreCompactInterval = conf.v.GetDuration("security.keystore.db.compactInterval")
+	}
+	conf.keyStoreCompactThreshold = 0
+	if conf.v.IsSet("security.keystore.db.compactThreshold") {
+		conf.keyStoreCompactThreshold = int64(conf.v.GetInt("security.keystore.db.compactThreshold"))
+	}
+
+	// Set the cert store bound and how often it is enforced
+	conf.certStoreMaxEntries = 0
+	if conf.v.IsSet("security.keystore.certstore.maxEntries") {
+		conf.certStoreMaxEntries = conf.v.GetInt("security.keystore.certstore.maxEntries")
+	}
+	conf.certStoreTrimInterval = 10 * time.Minute
+	if conf.v.IsSet("security.keystore.certstore.trimInterval") {
+		ovveride := conf.v.GetDuration("security.keystore.certstore.trimInterval")
+		if ovveride > 0 {
+			conf.certStoreTrimInterval = ovveride
+		}
+	}
+
+	// Set the bound of the in-memory cache of hot certs
+	conf.certHotCacheSize = 1024
+	if conf.v.IsSet("security.keystore.certstore.hotCacheSize") {
+		conf.certHotCacheSize = conf.v.GetInt("security.keystore.certstore.hotCacheSize")
+	}
+
+	// Set the bound of the in-memory cache of verified transaction certs
+	conf.certVerifyCacheSize = 4096
+	if conf.v.IsSet("security.keystore.certstore.verifyCacheSize") {
+		conf.certVerifyCacheSize = conf.v.GetInt("security.keystore.certstore.verifyCacheSize")
+	}
+
+	// Set the audit log of certificate fetches and usages
+	conf.auditEnabled = false
+	if conf.v.IsSet("security.keystore.audit.enabled") {
+		conf.auditEnabled = conf.v.GetBool("security.keystore.audit.enabled")
+	}
+
+	// Set the log of the signatures made by the node
+	conf.signatureLogEnabled = false
+	if conf.v.IsSet("security.keystore.audit.signatures.enabled") {
+		conf.signatureLogEnabled = conf.v.GetBool("security.keystore.audit.signatures.enabled")
+	}
+	conf.signatureLogMaxSize = 10485760
+	if conf.v.IsSet("security.keystore.audit.signatures.maxSize") {
+		ovveride := int64(conf.v.GetInt("security.keystore.audit.signatures.maxSize"))
+		if ovveride > 0 {
+			conf.signatureLogMaxSize = ovveride
+		}
+	}
+	conf.signatureLogMaxFiles = 5
+	if conf.v.IsSet("security.keystore.audit.signatures.maxFiles") {
+		ovveride := conf.v.GetInt("security.keystore.audit.signatures.maxFiles")
+		if ovveride > 0 {
+			conf.signatureLogMaxFiles = ovveride
+		}
+	}
+
+	// Set the re-enrollment of the node before its enrollment cert expires
+	conf.reenrollmentEnabled = false
+	if conf.v.IsSet("security.reenrollment.enabled") {
+		conf.reenrollmentEnabled = conf.v.GetBool("security.reenrollment.enabled")
+	}
+	conf.reenrollmentWindow = 7 * 24 * time.Hour
+	if conf.v.IsSet("security.reenrollment.window") {
+		conf.reenrollmentWindow = conf.v.GetDuration("security.reenrollment.window")
+	}
+	conf.reenrollmentInterval = time.Hour
+	if conf.v.IsSet("security.reenrollment.interval") {
+		ovveride := conf.v.GetDuration("security.reenrollment.interval")
+		if ovveride > 0 {
+			conf.reenrollmentInterval = ovveride
+		}
+	}
+	conf.reenrollmentSecret = conf.v.GetString("security.reenrollment.secret")
+	if conf.reenrollmentSecret == "" {
+		conf.reenrollmentSecret = conf.v.GetString("security.enrollSecret")
+	}
+	conf.reenrollmentOverlap = 24 * time.Hour
+	if conf.v.IsSet("security.reenrollment.overlap") {
+		conf.reenrollmentOverlap = conf.v.GetDuration("security.reenrollment.overlap")
+	}
+
+	// Set the renewal of the TLS cert before it expires
+	conf.tlsRenewalEnabled = false
+	if conf.v.IsSet("security.tlsrenewal.enabled") {
+		conf.tlsRenewalEnabled = conf.v.GetBool("security.tlsrenewal.enabled")
+	}
+	conf.tlsRenewalWindow = 7 * 24 * time.Hour
+	if conf.v.IsSet("security.tlsrenewal.window") {
+		conf.tlsRenewalWindow = conf.v.GetDuration("security.tlsrenewal.window")
+	}
+	conf.tlsRenewalInterval = time.Hour
+	if conf.v.IsSet("security.tlsrenewal.interval") {
+		ovveride := conf.v.GetDuration("security.tlsrenewal.interval")
+		if ovveride > 0 {
+			conf.tlsRenewalInterval = ovveride
+		}
+	}
+
+	// Set the subject alternative names requested in the TLS cert
+	conf.tlsDNSNames = conf.v.GetStringSlice("security.tlssan.dnsNames")
+	conf.tlsIPAddresses = conf.v.GetStringSlice("security.tlssan.ipAddresses")
+
+	// Set the OCSP revocation checks of enrollment certs
+	conf.ocspEnabled = false
+	if conf.v.IsSet("security.ocsp.enabled") {
+		conf.ocspEnabled = conf.v.GetBool("security.ocsp.enabled")
+	}
+	conf.ocspResponder = conf.v.GetString("security.ocsp.responder")
+	conf.ocspPolicy = ocspPolicyFailOpen
+	if conf.v.IsSet("security.ocsp.policy") {
+		ovveride := conf.v.GetString("security.ocsp.policy")
+		if ovveride != "" {
+			if ovveride != ocspPolicyFailOpen && ovveride != ocspPolicyFailClosed {
+				return fmt.Errorf("Invalid OCSP policy [%s]. It must be %s or %s.", ovveride, ocspPolicyFailOpen, ocspPolicyFailClosed)
+			}
+			conf.ocspPolicy = ovveride
+		}
+	}
+	conf.ocspTimeout = 5 * time.Second
+	if conf.v.IsSet("security.ocsp.timeout") {
+		ovveride := conf.v.GetDuration("security.ocsp.timeout")
+		if ovveride > 0 {
+			conf.ocspTimeout = ovveride
+		}
+	}
+	conf.ocspCacheTTL = time.Hour
+	if conf.v.IsSet("security.ocsp.cacheTTL") {
+		ovveride := conf.v.GetDuration("security.ocsp.cacheTTL")
+		if ovveride > 0 {
+			conf.ocspCacheTTL = ovveride
+		}
+	}
+
+	// Set the polling of the CRLs of the ECA and the TCA
+	conf.crlPollingEnabled = false
+	if conf.v.IsSet("security.crl.polling.enabled") {
+		conf.crlPollingEnabled = conf.v.GetBool("security.crl.polling.enabled")
+	}
+	conf.crlPollingInterval = 10 * time.Minute
+	if conf.v.IsSet("security.crl.polling.interval") {
+		ovveride := conf.v.GetDuration("security.crl.polling.interval")
+		if ovveride > 0 {
+			conf.crlPollingInterval = ovveride
+		}
+	}
+
+	// Set the subscription to the revocation feed of the ECA
+	conf.crlFeedEnabled = false
+	if conf.v.IsSet("security.crl.feed.enabled") {
+		conf.crlFeedEnabled = conf.v.GetBool("security.crl.feed.enabled")
+	}
+	conf.crlFeedRetryInterval = 5 * time.Second
+	if conf.v.IsSet("security.crl.feed.retryInterval") {
+		ovveride := conf.v.GetDuration("security.crl.feed.retryInterval")
+		if ovveride > 0 {
+			conf.crlFeedRetryInterval = ovveride
+		}
+	}
+
+	// Set the requests of enrollment certs to the peers themselves
+	conf.eCertExchangeEnabled = false
+	if conf.v.IsSet("security.ecertExchange.enabled") {
+		conf.eCertExchangeEnabled = conf.v.GetBool("security.ecertExchange.enabled")
+	}
+	conf.eCertExchangeTimeout = 2 * time.Second
+	if conf.v.IsSet("security.ecertExchange.timeout") {
+		ovveride := conf.v.GetDuration("security.ecertExchange.timeout")
+		if ovveride > 0 {
+			conf.eCertExchangeTimeout = ovveride
+		}
+	}
+
+	// Set the anonymous credentials identity
+	conf.idemixEnabled = false
+	if conf.v.IsSet("security.idemix.enabled") {
+		conf.idemixEnabled = conf.v.GetBool("security.idemix.enabled")
+	}
+	conf.idemixIssuerPublicKey = conf.v.GetString("security.idemix.issuerPublicKey")
+
 	// Set multithread
 	conf.multiThreading = false
-	if viper.IsSet("security.multithreading.enabled") {
-		conf.multiThreading = viper.GetBool("security.multithreading.enabled")
+	if conf.v.IsSet("security.multithreading.enabled") {
+		conf.multiThreading = conf.v.GetBool("security.multithreading.enabled")
 	}
 
 	return nil
 }
 
+// getKeyStoreProperty returns the name of the property key of the keystore
+// DB of the node. The section security.keystore.nodes.<name> of the node,
+// if it sets key, takes precedence over security.keystore.db.
+func (conf *configuration) getKeyStoreProperty(key string) string {
+	property := "security.keystore.nodes." + conf.name + "." + key
+	if conf.v.IsSet(property) {
+		return property
+	}
+
+	return "security.keystore.db." + key
+}
+
 func (conf *configuration) checkProperty(property string) error {
-	res := viper.GetString(property)
+	res := conf.v.GetString(property)
 	if res == "" {
 		return errors.New("Property not specified in configuration file. Please check that property is set: " + property)
 	}
@@ -164,15 +708,15 @@ func (conf *configuration) checkProperty(property string) error {
 }
 
 func (conf *configuration) getTCAPAddr() string {
-	return viper.GetString(conf.tcaPAddressProperty)
+	return conf.v.GetString(conf.tcaPAddressProperty)
 }
 
 func (conf *configuration) getECAPAddr() string {
-	return viper.GetString(conf.ecaPAddressProperty)
+	return conf.v.GetString(conf.ecaPAddressProperty)
 }
 
 func (conf *configuration) getTLSCAPAddr() string {
-	return viper.GetString(conf.tlscaPAddressProperty)
+	return conf.v.GetString(conf.tlscaPAddressProperty)
 }
 
 func (conf *configuration) getConfPath() string {
@@ -196,11 +740,237 @@ func (conf *configuration) getRawsPath() string {
 }
 
 func (conf *configuration) getKeyStoreFilename() string {
-	return "db"
+	return conf.keyStoreFilename
+}
+
+func (conf *configuration) getKeyStoreFileMode() os.FileMode {
+	return conf.keyStoreFileMode
+}
+
+func (conf *configuration) getKeyStoreDBPath() string {
+	return conf.keyStoreDBPath
 }
 
 func (conf *configuration) getKeyStoreFilePath() string {
-	return filepath.Join(conf.getKeyStorePath(), conf.getKeyStoreFilename())
+	return filepath.Join(conf.getKeyStoreDBPath(), conf.getKeyStoreFilename())
+}
+
+func (conf *configuration) isAuditEnabled() bool {
+	return conf.auditEnabled
+}
+
+func (conf *configuration) isSignatureLogEnabled() bool {
+	return conf.signatureLogEnabled
+}
+
+func (conf *configuration) getSignatureLogPath() string {
+	return filepath.Join(conf.getKeyStorePath(), "signatures.log")
+}
+
+func (conf *configuration) getSignatureLogMaxSize() int64 {
+	return conf.signatureLogMaxSize
+}
+
+func (conf *configuration) getSignatureLogMaxFiles() int {
+	return conf.signatureLogMaxFiles
+}
+
+func (conf *configuration) isKeyStoreReadOnly() bool {
+	return conf.keyStoreReadOnly
+}
+
+func (conf *configuration) isKeyStoreShared() bool {
+	return conf.keyStoreShared
+}
+
+// getKeyStoreOwner returns the value of the owner column of the rows of
+// the node in the keystore DB. Rows of a DB not shared have no owner.
+func (conf *configuration) getKeyStoreOwner() string {
+	if !conf.keyStoreShared {
+		return ""
+	}
+
+	return conf.prefix + "." + conf.name
+}
+
+func (conf *configuration) getKeyStoreDataSourceName() string {
+	if conf.keyStoreInMemory {
+		return ":memory:"
+	}
+
+	return sqliteDataSourceName(conf.getKeyStoreFilePath(), conf.keyStoreBusyTimeout, conf.keyStoreReadOnly)
+}
+
+func (conf *configuration) getKeyStoreMaxOpenConns() int {
+	return conf.keyStoreMaxOpenConns
+}
+
+func (conf *configuration) getKeyStoreMaxIdleConns() int {
+	return conf.keyStoreMaxIdleConns
+}
+
+func (conf *configuration) getKeyStoreBusyTimeout() int {
+	return conf.keyStoreBusyTimeout
+}
+
+func (conf *configuration) getKeyStoreJournalMode() string {
+	return conf.keyStoreJournalMode
+}
+
+func (conf *configuration) getKeyStoreSynchronous() string {
+	return conf.keyStoreSynchronous
+}
+
+func (conf *configuration) getKeyStoreDBKey() string {
+	return conf.keyStoreDBKey
+}
+
+func (conf *configuration) getKeyStoreCompactInterval() time.Duration {
+	return conf.keyStoreCompactInterval
+}
+
+func (conf *configuration) getKeyStoreCompactThreshold() int64 {
+	return conf.keyStoreCompactThreshold
+}
+
+func (conf *configuration) isKeyStoreInMemory() bool {
+	return conf.keyStoreInMemory
+}
+
+func (conf *configuration) getKeyStoreDEKFilename() string {
+	return "db.dek"
+}
+
+func (conf *configuration) isKeyStoreEncryptionEnabled() bool {
+	return conf.keyStoreEncryption
+}
+
+func (conf *configuration) getKeyStoreEncryptionPassphrase() []byte {
+	return []byte(conf.keyStoreEncryptionPassphrase)
+}
+
+func (conf *configuration) isHSMEnabled() bool {
+	return conf.hsmEnabled
+}
+
+func (conf *configuration) getHSMLibrary() string {
+	return conf.hsmLibrary
+}
+
+func (conf *configuration) getHSMLabel() string {
+	return conf.hsmLabel
+}
+
+func (conf *configuration) getHSMPin() string {
+	return conf.hsmPin
+}
+
+func (conf *configuration) isTPMEnabled() bool {
+	return conf.tpmEnabled
+}
+
+func (conf *configuration) getTPMDevice() string {
+	return conf.tpmDevice
+}
+
+func (conf *configuration) getCSPProvider() string {
+	return conf.cspProvider
+}
+
+func (conf *configuration) getCSPOptions() map[string]string {
+	return conf.cspOptions
+}
+
+func (conf *configuration) isReenrollmentEnabled() bool {
+	return conf.reenrollmentEnabled
+}
+
+func (conf *configuration) getReenrollmentWindow() time.Duration {
+	return conf.reenrollmentWindow
+}
+
+func (conf *configuration) getReenrollmentInterval() time.Duration {
+	return conf.reenrollmentInterval
+}
+
+func (conf *configuration) getReenrollmentSecret() string {
+	return conf.reenrollmentSecret
+}
+
+func (conf *configuration) getReenrollmentOverlap() time.Duration {
+	return conf.reenrollmentOverlap
+}
+
+func (conf *configuration) isTLSRenewalEnabled() bool {
+	return conf.tlsRenewalEnabled
+}
+
+func (conf *configuration) getTLSRenewalWindow() time.Duration {
+	return conf.tlsRenewalWindow
+}
+
+func (conf *configuration) getTLSRenewalInterval() time.Duration {
+	return conf.tlsRenewalInterval
+}
+
+func (conf *configuration) getTLSDNSNames() []string {
+	return conf.tlsDNSNames
+}
+
+func (conf *configuration) getTLSIPAddresses() []string {
+	return conf.tlsIPAddresses
+}
+
+func (conf *configuration) isOCSPEnabled() bool {
+	return conf.ocspEnabled
+}
+
+func (conf *configuration) getOCSPResponder() string {
+	return conf.ocspResponder
+}
+
+func (conf *configuration) isOCSPFailClosed() bool {
+	return conf.ocspPolicy == ocspPolicyFailClosed
+}
+
+func (conf *configuration) getOCSPTimeout() time.Duration {
+	return conf.ocspTimeout
+}
+
+func (conf *configuration) getOCSPCacheTTL() time.Duration {
+	return conf.ocspCacheTTL
+}
+
+func (conf *configuration) isCRLPollingEnabled() bool {
+	return conf.crlPollingEnabled
+}
+
+func (conf *configuration) getCRLPollingInterval() time.Duration {
+	return conf.crlPollingInterval
+}
+
+func (conf *configuration) isCRLFeedEnabled() bool {
+	return conf.crlFeedEnabled
+}
+
+func (conf *configuration) getCRLFeedRetryInterval() time.Duration {
+	return conf.crlFeedRetryInterval
+}
+
+func (conf *configuration) isECertExchangeEnabled() bool {
+	return conf.eCertExchangeEnabled
+}
+
+func (conf *configuration) getECertExchangeTimeout() time.Duration {
+	return conf.eCertExchangeTimeout
+}
+
+func (conf *configuration) isIdemixEnabled() bool {
+	return conf.idemixEnabled
+}
+
+func (conf *configuration) getIdemixIssuerPublicKeyPath() string {
+	return conf.idemixIssuerPublicKey
 }
 
 func (conf *configuration) getPathForAlias(alias string) string {
@@ -240,15 +1010,27 @@ func (conf *configuration) getTLSCACertsChainFilename() string {
 }
 
 func (conf *configuration) getTLSCACertsExternalPath() string {
-	return viper.GetString("peer.pki.tls.rootcert.file")
+	return conf.v.GetString("peer.pki.tls.rootcert.file")
+}
+
+func (conf *configuration) getCARootCertsExternalPath() string {
+	return conf.v.GetString("peer.pki.ca.rootcert.file")
+}
+
+func (conf *configuration) getTrustedECARootsPaths() map[string]string {
+	return conf.v.GetStringMapString("peer.pki.trusted.eca")
+}
+
+func (conf *configuration) getTrustedTCARootsPaths() map[string]string {
+	return conf.v.GetStringMapString("peer.pki.trusted.tca")
 }
 
 func (conf *configuration) isTLSEnabled() bool {
-	return viper.GetBool("peer.pki.tls.enabled")
+	return conf.v.GetBool("peer.pki.tls.enabled")
 }
 
 func (conf *configuration) isTLSClientAuthEnabled() bool {
-	return viper.GetBool("peer.pki.tls.client.auth.enabled")
+	return conf.v.GetBool("peer.pki.tls.client.auth.enabled")
 }
 
 func (conf *configuration) IsMultithreadingEnabled() bool {
@@ -291,6 +1073,104 @@ func (conf *configuration) getTCertBatchSize() int {
 	return conf.tCertBatchSize
 }
 
+func (conf *configuration) getTCertPoolWatermark() int {
+	return conf.tCertPoolWatermark
+}
+
+func (conf *configuration) getTCertPoolRefillInterval() time.Duration {
+	return conf.tCertPoolRefillInterval
+}
+
+func (conf *configuration) getTCertValidityPeriod() time.Duration {
+	return conf.tCertValidityPeriod
+}
+
+func (conf *configuration) getTCertPoolSize() int {
+	return conf.tCertPoolSize
+}
+
+func (conf *configuration) getCertStoreBackend() string {
+	return conf.certStoreBackend
+}
+
+func (conf *configuration) getSecretStoreBackend() string {
+	return conf.secretStoreBackend
+}
+
+func (conf *configuration) getSecretStoreAddress() string {
+	return conf.secretStoreAddress
+}
+
+func (conf *configuration) getSecretStoreToken() string {
+	return conf.secretStoreToken
+}
+
+func (conf *configuration) getSecretStorePath() string {
+	return conf.secretStorePath
+}
+
+func (conf *configuration) getSecretStoreTimeout() time.Duration {
+	return conf.secretStoreTimeout
+}
+
+func (conf *configuration) getCertFetchTimeout() time.Duration {
+	return conf.certFetchTimeout
+}
+
+func (conf *configuration) getCertFetchAttempts() int {
+	return conf.certFetchAttempts
+}
+
+func (conf *configuration) getCertFetchInitialBackoff() time.Duration {
+	return conf.certFetchBackoff
+}
+
+func (conf *configuration) getCertFetchMaxBackoff() time.Duration {
+	return conf.certFetchMaxBackoff
+}
+
+func (conf *configuration) getCertFetchJitter() float64 {
+	return conf.certFetchJitter
+}
+
+func (conf *configuration) getCertStoreMaxEntries() int {
+	return conf.certStoreMaxEntries
+}
+
+func (conf *configuration) getCertStoreTrimInterval() time.Duration {
+	return conf.certStoreTrimInterval
+}
+
+func (conf *configuration) getCertHotCacheSize() int {
+	return conf.certHotCacheSize
+}
+
+func (conf *configuration) getCertVerifyCacheSize() int {
+	return conf.certVerifyCacheSize
+}
+
+func (conf *configuration) getCertRenewalWindow() time.Duration {
+	return conf.certRenewalWindow
+}
+
+// isCertRenewalDue returns true if a cert expiring at notAfter
+// is expired or within the renewal window
+func (conf *configuration) isCertRenewalDue(notAfter time.Time) bool {
+	if notAfter.IsZero() {
+		return false
+	}
+
+	return !time.Now().Add(conf.certRenewalWindow).Before(notAfter)
+}
+
+func (conf *configuration) getSignatureScheme() string {
+	return conf.signatureScheme
+}
+
+func (conf *configuration) getRSAKeySize() int {
+	return conf.rsaKeySize
+}
+
 func (conf *configuration) GetConfidentialityProtocolVersion() string {
 	return conf.confidentialityProtocolVersion
 }
@@ -85,6 +85,11 @@ func (node *nodeImpl) initCryptoEngine() error {
 		return err
 	}
 
+	// Load the roots of the trusted organizations
+	if err := node.loadTrustedCAs(); err != nil {
+		return err
+	}
+
 	// Load enrollment secret key
 	if err := node.loadEnrollmentKey(); err != nil {
 		return err
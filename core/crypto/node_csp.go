@@ -0,0 +1,272 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/aes"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The signing key pairs of a node, its signatures and verifications, the
+// keystore encryption and key derivations go through a cryptographic service
+// provider (CSP), selected by the security.csp.provider property. The
+// software provider, sw, is the default. In HSM mode, the provider is wrapped
+// so that the keys held by the PKCS#11 token are generated and used inside it.
+// Signing keys are generated for the signature scheme selected by the
+// security.signatureScheme property, ecdsa, ed25519 or rsa. Signatures are made
+// and verified according to the type of the key, whatever the scheme selected.
+
+// Public Interfaces
+
+// CSP is a cryptographic service provider
+type CSP interface {
+
+	// GenerateKey generates a new signing key pair, of the
+	// signature scheme of the configuration of the provider,
+	// and returns its private and public keys
+	GenerateKey() (interface{}, interface{}, error)
+
+	// Sign hashes msg and signs the hash with signKey.
+	// The signature is ASN.1 encoded.
+	Sign(signKey interface{}, msg []byte) ([]byte, error)
+
+	// SignDigest signs digest with signKey, an ECDSA key
+	SignDigest(signKey interface{}, digest []byte) (*big.Int, *big.Int, error)
+
+	// Verify checks signature, as returned by Sign, of msg against verKey
+	Verify(verKey interface{}, msg, signature []byte) (bool, error)
+
+	// GenerateSecretKey generates a new serialized symmetric key
+	GenerateSecretKey() ([]byte, error)
+
+	// Encrypt encrypts, and authenticates, plaintext
+	// under the serialized symmetric key
+	Encrypt(key, plaintext []byte) ([]byte, error)
+
+	// Decrypt decrypts ciphertext, as returned by Encrypt
+	Decrypt(key, ciphertext []byte) ([]byte, error)
+
+	// DeriveKey derives a key of length bytes from passphrase and salt,
+	// iterating the derivation iterations times to slow down guessing
+	DeriveKey(passphrase, salt []byte, iterations, length int) ([]byte, error)
+}
+
+// CSPConfig carries the parameters passed to a CSPFactory
+type CSPConfig struct {
+
+	// Name is the name of the node using the provider
+	Name string
+
+	// Options are the provider specific settings,
+	// read from the security.csp.options property
+	Options map[string]string
+
+	// SignatureScheme is the scheme of the signing keys
+	// to generate, ecdsa, ed25519 or rsa
+	SignatureScheme string
+
+	// RSAKeySize is the size, in bits, of the RSA signing keys to generate
+	RSAKeySize int
+}
+
+// CSPFactory creates a new CSP for the passed configuration
+type CSPFactory func(conf *CSPConfig) (CSP, error)
+
+// Private type and variables
+
+const (
+	signatureSchemeECDSA   = "ecdsa"
+	signatureSchemeEd25519 = "ed25519"
+	signatureSchemeRSA     = "rsa"
+)
+
+var (
+	// Map of registered providers
+	cspFactories = make(map[string]CSPFactory)
+
+	// Sync
+	cspFactoriesMutex sync.RWMutex
+)
+
+func init() {
+	RegisterCSP("sw", newSoftwareCSP)
+}
+
+// swCSP is the software provider, built on the primitives package
+type swCSP struct {
+	scheme  string
+	rsaBits int
+}
+
+// Public Methods
+
+// RegisterCSP makes a cryptographic service provider available under name.
+// The provider used by a node is selected by the security.csp.provider property.
+func RegisterCSP(name string, factory CSPFactory) error {
+	if factory == nil {
+		return utils.ErrNilArgument
+	}
+
+	cspFactoriesMutex.Lock()
+	defer cspFactoriesMutex.Unlock()
+
+	if _, ok := cspFactories[name]; ok {
+		return fmt.Errorf("Cryptographic service provider [%s] already registered.", name)
+	}
+	cspFactories[name] = factory
+
+	return nil
+}
+
+// Private Methods
+
+func (node *nodeImpl) initCSP() error {
+	name := node.conf.getCSPProvider()
+
+	cspFactoriesMutex.RLock()
+	factory, ok := cspFactories[name]
+	cspFactoriesMutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("Cryptographic service provider [%s] not registered.", name)
+	}
+
+	csp, err := factory(&CSPConfig{
+		Name:            node.conf.name,
+		Options:         node.conf.getCSPOptions(),
+		SignatureScheme: node.conf.getSignatureScheme(),
+		RSAKeySize:      node.conf.getRSAKeySize(),
+	})
+	if err != nil {
+		node.Errorf("Failed initializing cryptographic service provider [%s] [%s].", name, err.Error())
+		return err
+	}
+	node.csp = csp
+
+	node.Debugf("Using cryptographic service provider [%s].", name)
+
+	return nil
+}
+
+func newSoftwareCSP(conf *CSPConfig) (CSP, error) {
+	return &swCSP{scheme: conf.SignatureScheme, rsaBits: conf.RSAKeySize}, nil
+}
+
+func (csp *swCSP) GenerateKey() (interface{}, interface{}, error) {
+	switch csp.scheme {
+	case signatureSchemeEd25519:
+		key, err := primitives.NewEd25519Key()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return key, key.Public(), nil
+	case signatureSchemeRSA:
+		key, err := primitives.NewRSAKey(csp.rsaBits)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return key, &key.PublicKey, nil
+	}
+
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, &key.PublicKey, nil
+}
+
+func (csp *swCSP) Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	switch signKey.(type) {
+	case *ecdsa.PrivateKey:
+		return primitives.ECDSASign(signKey, msg)
+	case ed25519.PrivateKey:
+		return primitives.Ed25519Sign(signKey, msg)
+	case *rsa.PrivateKey:
+		return primitives.RSASign(signKey, msg)
+	}
+
+	return nil, utils.ErrInvalidKey
+}
+
+func (csp *swCSP) SignDigest(signKey interface{}, digest []byte) (*big.Int, *big.Int, error) {
+	sk, ok := signKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, utils.ErrInvalidKey
+	}
+
+	return ecdsa.Sign(primitives.RandReader, sk, digest)
+}
+
+func (csp *swCSP) Verify(verKey interface{}, msg, signature []byte) (bool, error) {
+	switch verKey.(type) {
+	case *ecdsa.PublicKey:
+		return primitives.ECDSAVerify(verKey, msg, signature)
+	case ed25519.PublicKey:
+		return primitives.Ed25519Verify(verKey, msg, signature)
+	case *rsa.PublicKey:
+		return primitives.RSAVerify(verKey, msg, signature)
+	}
+
+	return false, utils.ErrInvalidKey
+}
+
+func (csp *swCSP) GenerateSecretKey() ([]byte, error) {
+	_, key, err := aes.NewAES256GSMSPI().GenerateKeyAndSerialize()
+
+	return key, err
+}
+
+func (csp *swCSP) Encrypt(key, plaintext []byte) ([]byte, error) {
+	cipher, err := aes.NewAES256GSMSPI().NewStreamCipherForEncryptionFromSerializedKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.Process(plaintext)
+}
+
+func (csp *swCSP) Decrypt(key, ciphertext []byte) ([]byte, error) {
+	cipher, err := aes.NewAES256GSMSPI().NewStreamCipherForDecryptionFromSerializedKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.Process(ciphertext)
+}
+
+func (csp *swCSP) DeriveKey(passphrase, salt []byte, iterations, length int) ([]byte, error) {
+	if iterations <= 0 || length <= 0 {
+		return nil, utils.ErrInvalidKey
+	}
+
+	// SHA-256 whatever the security level, so that the key
+	// depends on the stored salt and iteration count only
+	return pbkdf2.Key(passphrase, salt, iterations, length, sha256.New), nil
+}
@@ -17,8 +17,8 @@ limitations under the License.
 package crypto
 
 import (
-	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
 	"google/protobuf"
 	"time"
@@ -39,36 +39,54 @@ import (
 var (
 	// ECertSubjectRole is the ASN1 object identifier of the subject's role.
 	ECertSubjectRole = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 7}
+
+	// ECertSignatureScheme is the ASN1 object identifier of the signature scheme
+	// of the key certified by an enrollment certificate for signing.
+	ECertSignatureScheme = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 8}
+
+	// ECertSubjectAffiliation is the ASN1 object identifier of the path of the
+	// subject's affiliation group, from the top-level group down.
+	ECertSubjectAffiliation = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 9}
 )
 
+// getCertSignatureScheme returns the signature scheme recorded in cert.
+// Certificates issued before schemes were recorded are ECDSA ones.
+func getCertSignatureScheme(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ECertSignatureScheme) {
+			return string(ext.Value)
+		}
+	}
+
+	return signatureSchemeECDSA
+}
+
 func (node *nodeImpl) retrieveECACertsChain(userID string) error {
-	// Retrieve ECA certificate and verify it
-	ecaCertRaw, err := node.getECACertificate()
+	// Retrieve ECA certificates chain and verify it
+	ecaCertsChain, err := node.getECACertificateChain()
 	if err != nil {
-		node.Errorf("Failed getting ECA certificate [%s].", err.Error())
+		node.Errorf("Failed getting ECA certificates chain [%s].", err.Error())
 
 		return err
 	}
-	node.Debugf("ECA certificate [% x].", ecaCertRaw)
+	node.Debugf("ECA certificates chain of length [%d].", len(ecaCertsChain))
 
-	// TODO: Test ECA cert againt root CA
-	// TODO: check response.Cert against rootCA
-	x509ECACert, err := primitives.DERToX509Certificate(ecaCertRaw)
+	pem, err := node.verifyCACertsChain(ecaCertsChain)
 	if err != nil {
-		node.Errorf("Failed parsing ECA certificate [%s].", err.Error())
+		node.Errorf("Failed verifying ECA certificates chain [%s].", err.Error())
 
 		return err
 	}
 
 	// Prepare ecaCertPool
 	node.ecaCertPool = x509.NewCertPool()
-	node.ecaCertPool.AddCert(x509ECACert)
+	node.ecaCertPool.AppendCertsFromPEM(pem)
 
-	// Store ECA cert
-	node.Debugf("Storing ECA certificate for [%s]...", userID)
+	// Store ECA certs chain
+	node.Debugf("Storing ECA certificates chain for [%s]...", userID)
 
-	if err := node.ks.storeCert(node.conf.getECACertsChainFilename(), ecaCertRaw); err != nil {
-		node.Errorf("Failed storing eca certificate [%s].", err.Error())
+	if err := node.ks.storeCertPEM(node.conf.getECACertsChainFilename(), pem); err != nil {
+		node.Errorf("Failed storing eca certificates chain [%s].", err.Error())
 		return err
 	}
 
@@ -93,8 +111,13 @@ func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
 		return err
 	}
 
-	// Store enrollment key
-	if err := node.ks.storePrivateKey(node.conf.getEnrollmentKeyFilename(), key); err != nil {
+	// Store enrollment key, only its handle in HSM or TPM mode
+	if heldKey, ok := key.(deviceKey); ok {
+		err = node.ks.storeKeyHandle(node.conf.getEnrollmentKeyFilename(), heldKey.handle())
+	} else {
+		err = node.ks.storePrivateKey(node.conf.getEnrollmentKeyFilename(), key)
+	}
+	if err != nil {
 		node.Errorf("Failed storing enrollment key [id=%s]: [%s]", enrollID, err)
 		return err
 	}
@@ -151,6 +174,42 @@ func (node *nodeImpl) retrieveEnrollmentData(enrollID, enrollPWD string) error {
 func (node *nodeImpl) loadEnrollmentKey() error {
 	node.Debug("Loading enrollment key...")
 
+	if node.hsm != nil {
+		handle, err := node.ks.loadKeyHandle(node.conf.getEnrollmentKeyFilename())
+		if err != nil {
+			node.Errorf("Failed loading enrollment key handle [%s].", err.Error())
+
+			return err
+		}
+
+		node.enrollHSMKey, err = node.hsm.findKey(handle)
+		if err != nil {
+			node.Errorf("Failed loading enrollment key from PKCS#11 token [%s].", err.Error())
+
+			return err
+		}
+
+		return nil
+	}
+
+	if node.tpm != nil {
+		handle, err := node.ks.loadKeyHandle(node.conf.getEnrollmentKeyFilename())
+		if err != nil {
+			node.Errorf("Failed loading sealed enrollment key [%s].", err.Error())
+
+			return err
+		}
+
+		node.enrollTPMKey, err = node.tpm.loadKey(handle)
+		if err != nil {
+			node.Errorf("Failed loading enrollment key into the TPM [%s].", err.Error())
+
+			return err
+		}
+
+		return nil
+	}
+
 	enrollPrivKey, err := node.ks.loadPrivateKey(node.conf.getEnrollmentKeyFilename())
 	if err != nil {
 		node.Errorf("Failed loading enrollment private key [%s].", err.Error())
@@ -158,7 +217,11 @@ func (node *nodeImpl) loadEnrollmentKey() error {
 		return err
 	}
 
-	node.enrollPrivKey = enrollPrivKey.(*ecdsa.PrivateKey)
+	if err := node.setEnrollmentKey(enrollPrivKey); err != nil {
+		node.Errorf("Failed loading enrollment private key [%s].", err.Error())
+
+		return err
+	}
 
 	return nil
 }
@@ -175,14 +238,17 @@ func (node *nodeImpl) loadEnrollmentCertificate() error {
 	node.enrollCert = cert
 
 	// TODO: move this to retrieve
-	pk := node.enrollCert.PublicKey.(*ecdsa.PublicKey)
-	err = primitives.VerifySignCapability(node.enrollPrivKey, pk)
+	err = primitives.VerifySignCapability(node.getEnrollmentKey(), node.enrollCert.PublicKey)
 	if err != nil {
 		node.Errorf("Failed checking enrollment certificate against enrollment key [%s].", err.Error())
 
 		return err
 	}
 
+	if scheme := getCertSignatureScheme(cert); scheme != node.conf.getSignatureScheme() {
+		node.Warningf("Enrolled with signature scheme [%s] rather than [%s]. Re-enroll to switch.", scheme, node.conf.getSignatureScheme())
+	}
+
 	// Set node ID
 	node.id = primitives.Hash(der)
 	node.Debugf("Setting id to [% x].", node.id)
@@ -280,20 +346,20 @@ func (node *nodeImpl) getECAClient() (*grpc.ClientConn, membersrvc.ECAPClient, e
 	return conn, client, nil
 }
 
-func (node *nodeImpl) callECAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+func (node *nodeImpl) callECAReadCACertificateChain(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.CertChain, error) {
 	// Get an ECA Client
 	sock, ecaP, err := node.getECAClient()
 	defer sock.Close()
 
 	// Issue the request
-	cert, err := ecaP.ReadCACertificate(ctx, &membersrvc.Empty{}, opts...)
+	chain, err := ecaP.ReadCACertificateChain(ctx, &membersrvc.Empty{}, opts...)
 	if err != nil {
-		node.Errorf("Failed requesting read certificate [%s].", err.Error())
+		node.Errorf("Failed requesting read certificates chain [%s].", err.Error())
 
 		return nil, err
 	}
 
-	return cert, nil
+	return chain, nil
 }
 
 func (node *nodeImpl) callECAReadCertificate(ctx context.Context, in *membersrvc.ECertReadReq, opts ...grpc.CallOption) (*membersrvc.CertPair, error) {
@@ -335,18 +401,25 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 
 	// Run the protocol
 
-	signPriv, err := primitives.NewECDSAKey()
+	signPriv, signPubKey, err := node.csp.GenerateKey()
 	if err != nil {
-		node.Errorf("Failed generating ECDSA key [%s].", err.Error())
+		node.Errorf("Failed generating signing key [%s].", err.Error())
 
 		return nil, nil, nil, err
 	}
-	signPub, err := x509.MarshalPKIXPublicKey(&signPriv.PublicKey)
+	signPub, err := x509.MarshalPKIXPublicKey(signPubKey)
 	if err != nil {
-		node.Errorf("Failed mashalling ECDSA key [%s].", err.Error())
+		node.Errorf("Failed mashalling signing key [%s].", err.Error())
 
 		return nil, nil, nil, err
 	}
+	signType := membersrvc.CryptoType_ECDSA
+	switch signPriv.(type) {
+	case ed25519.PrivateKey:
+		signType = membersrvc.CryptoType_ED25519
+	case *rsa.PrivateKey:
+		signType = membersrvc.CryptoType_RSA
+	}
 
 	encPriv, err := primitives.NewECDSAKey()
 	if err != nil {
@@ -365,7 +438,7 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
 		Id:   &membersrvc.Identity{Id: id},
 		Tok:  &membersrvc.Token{Tok: []byte(pw)},
-		Sign: &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: signPub},
+		Sign: &membersrvc.PublicKey{Type: signType, Key: signPub},
 		Enc:  &membersrvc.PublicKey{Type: membersrvc.CryptoType_ECDSA, Key: encPub},
 		Sig:  nil}
 
@@ -405,19 +478,14 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 	req.Tok.Tok = out
 	req.Sig = nil
 
-	hash := primitives.NewHash()
 	raw, _ := proto.Marshal(req)
-	hash.Write(raw)
-
-	r, s, err := ecdsa.Sign(rand.Reader, signPriv, hash.Sum(nil))
+	sig, err := node.signRequest(signPriv, raw)
 	if err != nil {
 		node.Errorf("Failed signing [%s].", err.Error())
 
 		return nil, nil, nil, err
 	}
-	R, _ := r.MarshalText()
-	S, _ := s.MarshalText()
-	req.Sig = &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}
+	req.Sig = sig
 
 	resp, err = ecaP.CreateCertificatePair(context.Background(), req)
 	if err != nil {
@@ -445,12 +513,13 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 		return nil, nil, nil, err
 	}
 
-	err = primitives.CheckCertAgainstSKAndRoot(x509SignCert, signPriv, node.ecaCertPool)
+	err = checkCertAgainstKeyAndRoot(x509SignCert, signPriv, node.ecaCertPool)
 	if err != nil {
 		node.Errorf("Failed checking signing enrollment certificate for signing: [%s]", err)
 
 		return nil, nil, nil, err
 	}
+	node.Debugf("Enrollment certificate for signing of signature scheme [%s]", getCertSignatureScheme(x509SignCert))
 
 	// Verify cert for encrypting
 	node.Debugf("Enrollment certificate for encrypting [% x]", primitives.Hash(resp.Certs.Enc))
@@ -479,13 +548,102 @@ func (node *nodeImpl) getEnrollmentCertificateFromECA(id, pw string) (interface{
 	return signPriv, resp.Certs.Sign, resp.Pkchain, nil
 }
 
-func (node *nodeImpl) getECACertificate() ([]byte, error) {
-	responce, err := node.callECAReadCACertificate(context.Background())
+// getRenewedEnrollmentCertificateFromECA re-enrolls the node, authenticated
+// with its current enrollment key and cert, for a new enrollment key. Unlike
+// getEnrollmentCertificateFromECA it needs no enrollment secret, but the
+// current enrollment cert must be neither expired nor revoked.
+func (node *nodeImpl) getRenewedEnrollmentCertificateFromECA() (interface{}, []byte, []byte, error) {
+	// Get a new ECA Client
+	sock, ecaP, err := node.getECAClient()
+	defer sock.Close()
+
+	signPriv, signPubKey, err := node.csp.GenerateKey()
+	if err != nil {
+		node.Errorf("Failed generating signing key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	signPub, err := x509.MarshalPKIXPublicKey(signPubKey)
+	if err != nil {
+		node.Errorf("Failed mashalling signing key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	signType := membersrvc.CryptoType_ECDSA
+	switch signPriv.(type) {
+	case ed25519.PrivateKey:
+		signType = membersrvc.CryptoType_ED25519
+	case *rsa.PrivateKey:
+		signType = membersrvc.CryptoType_RSA
+	}
+
+	req := &membersrvc.ECertRenewReq{
+		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &membersrvc.Identity{Id: node.enrollID},
+		Sign: &membersrvc.PublicKey{Type: signType, Key: signPub}}
+
+	// Sign with the current enrollment key, and the new one to prove its possession
+	raw, _ := proto.Marshal(req)
+	node.enrollMutex.RLock()
+	req.Sig, err = node.signRequest(node.getEnrollmentKey(), raw)
+	node.enrollMutex.RUnlock()
+	if err != nil {
+		node.Errorf("Failed signing with the current enrollment key [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	req.SignSig, err = node.signRequest(signPriv, raw)
+	if err != nil {
+		node.Errorf("Failed signing [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+
+	resp, err := ecaP.RenewCertificatePair(context.Background(), req)
+	if err != nil {
+		node.Errorf("Failed invoking RenewCertificatePair [%s].", err.Error())
+
+		return nil, nil, nil, err
+	}
+	if resp.FetchResult != nil && resp.FetchResult.Status != membersrvc.FetchAttrsResult_SUCCESS {
+		node.Warning(resp.FetchResult.Msg)
+	}
+
+	// Verify cert for signing
+	node.Debugf("Renewed enrollment certificate for signing [% x]", primitives.Hash(resp.Certs.Sign))
+
+	x509SignCert, err := primitives.DERToX509Certificate(resp.Certs.Sign)
+	if err != nil {
+		node.Errorf("Failed parsing signing enrollment certificate for signing: [%s]", err)
+
+		return nil, nil, nil, err
+	}
+	if x509SignCert.Subject.CommonName != node.enrollCert.Subject.CommonName {
+		node.Errorf("Renewed enrollment certificate for another identity [%s].", x509SignCert.Subject.CommonName)
+
+		return nil, nil, nil, errors.New("Renewed enrollment certificate for another identity.")
+	}
+	if _, err = primitives.GetCriticalExtension(x509SignCert, ECertSubjectRole); err != nil {
+		node.Errorf("Failed parsing ECertSubjectRole in enrollment certificate for signing: [%s]", err)
+
+		return nil, nil, nil, err
+	}
+	if err = checkCertAgainstKeyAndRoot(x509SignCert, signPriv, node.ecaCertPool); err != nil {
+		node.Errorf("Failed checking signing enrollment certificate for signing: [%s]", err)
+
+		return nil, nil, nil, err
+	}
+
+	return signPriv, resp.Certs.Sign, resp.Pkchain, nil
+}
+
+func (node *nodeImpl) getECACertificateChain() ([][]byte, error) {
+	responce, err := node.callECAReadCACertificateChain(context.Background())
 	if err != nil {
-		node.Errorf("Failed requesting ECA certificate [%s].", err.Error())
+		node.Errorf("Failed requesting ECA certificates chain [%s].", err.Error())
 
 		return nil, err
 	}
 
-	return responce.Cert, nil
+	return responce.Certs, nil
 }
@@ -0,0 +1,403 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/miekg/pkcs11"
+)
+
+// In HSM mode, the enrollment key of peers and validators is generated
+// inside a PKCS#11 token and never leaves it. The keystore records the
+// CKA_ID of the key, hex encoded, as its handle, and every signature with
+// the enrollment key is computed by the token. Clients are not supported:
+// their TCert keys are derived from the enrollment key, which the token
+// does not expose.
+
+var (
+	// Named curves supported in HSM mode
+	hsmCurveOIDs = map[string]asn1.ObjectIdentifier{
+		"P-256": {1, 2, 840, 10045, 3, 1, 7},
+		"P-384": {1, 3, 132, 0, 34},
+	}
+)
+
+// hsm is a logged in session with the configured token.
+// PKCS#11 sessions are not safe for concurrent use, hence the mutex.
+type hsm struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+
+	m sync.Mutex
+}
+
+// hsmCSP is the provider of a node in HSM mode: it generates and uses
+// the signing keys inside the token, and delegates the other operations
+// to the configured provider
+type hsmCSP struct {
+	CSP
+
+	hsm *hsm
+}
+
+// hsmKey is an ECDSA key pair held by the token
+type hsmKey struct {
+	hsm  *hsm
+	id   []byte
+	priv pkcs11.ObjectHandle
+	pub  *ecdsa.PublicKey
+}
+
+func (node *nodeImpl) initHSM() error {
+	if !node.conf.isHSMEnabled() || node.hsm != nil {
+		return nil
+	}
+
+	if node.eType == NodeClient {
+		return errors.New("HSM mode is not supported for clients.")
+	}
+	if node.conf.getSignatureScheme() != signatureSchemeECDSA {
+		return fmt.Errorf("HSM mode is not supported for signature scheme [%s].", node.conf.getSignatureScheme())
+	}
+
+	node.Debugf("Opening PKCS#11 token [%s] with [%s]...", node.conf.getHSMLabel(), node.conf.getHSMLibrary())
+	hsm, err := openHSM(node.conf.getHSMLibrary(), node.conf.getHSMLabel(), node.conf.getHSMPin())
+	if err != nil {
+		node.Errorf("Failed opening PKCS#11 token [%s].", err.Error())
+
+		return err
+	}
+	node.hsm = hsm
+	node.csp = &hsmCSP{CSP: node.csp, hsm: hsm}
+
+	return nil
+}
+
+func openHSM(library, label, pin string) (*hsm, error) {
+	ctx := pkcs11.New(library)
+	if ctx == nil {
+		return nil, fmt.Errorf("Failed loading PKCS#11 library [%s].", library)
+	}
+
+	err := ctx.Initialize()
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, err
+	}
+
+	slot, err := findHSMSlot(ctx, label)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	err = ctx.Login(session, pkcs11.CKU_USER, pin)
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return &hsm{ctx: ctx, session: session}, nil
+}
+
+func findHSMSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+
+	return 0, fmt.Errorf("PKCS#11 token [%s] not found.", label)
+}
+
+func (hsm *hsm) close() error {
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	hsm.ctx.Logout(hsm.session)
+	hsm.ctx.CloseSession(hsm.session)
+	err := hsm.ctx.Finalize()
+	hsm.ctx.Destroy()
+
+	return err
+}
+
+// generateKey generates a new ECDSA key pair, on the default curve,
+// inside the token
+func (hsm *hsm) generateKey() (*hsmKey, error) {
+	curve := primitives.GetDefaultCurve()
+	oid, ok := hsmCurveOIDs[curve.Params().Name]
+	if !ok {
+		return nil, fmt.Errorf("Curve [%s] not supported in HSM mode.", curve.Params().Name)
+	}
+	params, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key pair gets a random ID first, replaced by the hash of
+	// the public key once it is known
+	tmpID, err := primitives.GetRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, tmpID),
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, tmpID),
+	}
+
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	pubHandle, privHandle, err := hsm.ctx.GenerateKeyPair(
+		hsm.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		pubTemplate, privTemplate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := hsm.getPublicKey(pubHandle, curve)
+	if err != nil {
+		return nil, err
+	}
+
+	id := primitives.Hash(elliptic.Marshal(curve, pub.X, pub.Y))
+	for _, handle := range []pkcs11.ObjectHandle{pubHandle, privHandle} {
+		err := hsm.ctx.SetAttributeValue(hsm.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, id)})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &hsmKey{hsm: hsm, id: id, priv: privHandle, pub: pub}, nil
+}
+
+// findKey returns the key pair whose handle, as returned by hsmKey.handle,
+// is passed
+func (hsm *hsm) findKey(handle string) (*hsmKey, error) {
+	id, err := hex.DecodeString(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	hsm.m.Lock()
+	defer hsm.m.Unlock()
+
+	privHandle, err := hsm.findObject(pkcs11.CKO_PRIVATE_KEY, id)
+	if err != nil {
+		return nil, err
+	}
+	pubHandle, err := hsm.findObject(pkcs11.CKO_PUBLIC_KEY, id)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := hsm.getPublicKey(pubHandle, primitives.GetDefaultCurve())
+	if err != nil {
+		return nil, err
+	}
+
+	return &hsmKey{hsm: hsm, id: id, priv: privHandle, pub: pub}, nil
+}
+
+func (hsm *hsm) findObject(class uint, id []byte) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	if err := hsm.ctx.FindObjectsInit(hsm.session, template); err != nil {
+		return 0, err
+	}
+	defer hsm.ctx.FindObjectsFinal(hsm.session)
+
+	handles, _, err := hsm.ctx.FindObjects(hsm.session, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("Key [%x] not found in the PKCS#11 token.", id)
+	}
+
+	return handles[0], nil
+}
+
+func (hsm *hsm) getPublicKey(handle pkcs11.ObjectHandle, curve elliptic.Curve) (*ecdsa.PublicKey, error) {
+	attrs, err := hsm.ctx.GetAttributeValue(hsm.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// CKA_EC_POINT is a DER encoded octet string, some tokens
+	// return the raw point instead
+	point := attrs[0].Value
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("Failed unmarshalling EC point.")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// handle returns the handle of the key to be recorded in the keystore
+func (key *hsmKey) handle() string {
+	return hex.EncodeToString(key.id)
+}
+
+// signDigest signs digest and returns the signature as a pair of integers
+func (key *hsmKey) signDigest(digest []byte) (*big.Int, *big.Int, error) {
+	key.hsm.m.Lock()
+	defer key.hsm.m.Unlock()
+
+	err := key.hsm.ctx.SignInit(key.hsm.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, key.priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := key.hsm.ctx.Sign(key.hsm.session, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, nil, errors.New("Invalid ECDSA signature returned by the PKCS#11 token.")
+	}
+
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+
+	return r, s, nil
+}
+
+// signDirect hashes and signs msg, like primitives.ECDSASignDirect
+func (key *hsmKey) signDirect(msg []byte) (*big.Int, *big.Int, error) {
+	return key.signDigest(primitives.Hash(msg))
+}
+
+// sign hashes and signs msg, like primitives.ECDSASign
+func (key *hsmKey) sign(msg []byte) ([]byte, error) {
+	r, s, err := key.signDirect(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(primitives.ECDSASignature{R: r, S: s})
+}
+
+// GenerateKey generates a new signing key pair inside the token
+func (csp *hsmCSP) GenerateKey() (interface{}, interface{}, error) {
+	key, err := csp.hsm.generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, key.pub, nil
+}
+
+// Sign signs with signKey inside the token, if held by it
+func (csp *hsmCSP) Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	if key, ok := signKey.(*hsmKey); ok {
+		return key.sign(msg)
+	}
+
+	return csp.CSP.Sign(signKey, msg)
+}
+
+// SignDigest signs with signKey inside the token, if held by it
+func (csp *hsmCSP) SignDigest(signKey interface{}, digest []byte) (*big.Int, *big.Int, error) {
+	if key, ok := signKey.(*hsmKey); ok {
+		return key.signDigest(digest)
+	}
+
+	return csp.CSP.SignDigest(signKey, digest)
+}
+
+// deviceKey is a key held by a PKCS#11 token or a TPM,
+// of which the keystore records a handle only
+type deviceKey interface {
+	handle() string
+}
+
+// checkCertAgainstKeyAndRoot is primitives.CheckCertAgainstSKAndRoot for
+// the keys returned by CSP.GenerateKey
+func checkCertAgainstKeyAndRoot(cert *x509.Certificate, key interface{}, certPool *x509.CertPool) error {
+	var skPub *ecdsa.PublicKey
+	switch sk := key.(type) {
+	case *hsmKey:
+		skPub = sk.pub
+	case *tpmKey:
+		skPub = sk.pub
+	default:
+		return primitives.CheckCertAgainstSKAndRoot(cert, key, certPool)
+	}
+
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(skPub.X) != 0 || pub.Y.Cmp(skPub.Y) != 0 {
+		return errors.New("Private key does not match public key")
+	}
+	_, err := primitives.CheckCertAgainRoot(cert, certPool)
+
+	return err
+}
@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/idemix"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// Anonymous credentials are an alternative to TCerts. A client holding a
+// credential, issued once, signs each transaction with an idemix signature:
+// a proof of possession of the credential disclosing the attributes
+// requested, and nothing else. The Cert of such a transaction is
+// idemixCertPrefix followed by the hash of the issuer public key, and its
+// Signature is the idemix signature of the transaction without signature.
+
+// Private type and variables
+
+var idemixCertPrefix = []byte("idemix:")
+
+// Private Methods
+
+// isIdemixCert returns true if cert, the Cert of a transaction,
+// designates an idemix signature
+func isIdemixCert(cert []byte) bool {
+	return bytes.HasPrefix(cert, idemixCertPrefix)
+}
+
+// newIdemixCert returns the Cert of the transactions signed
+// with a credential issued under issuer
+func newIdemixCert(issuer *idemix.IssuerPublicKey) ([]byte, error) {
+	hash, err := issuer.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, idemixCertPrefix...), hash...), nil
+}
+
+// loadIdemixIssuer reads the issuer public key at path
+func loadIdemixIssuer(path string) (*idemix.IssuerPublicKey, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return idemix.ParseIssuerPublicKey(raw)
+}
+
+// verifyIdemixTransaction checks the idemix signature of tx against
+// issuer, and returns the attributes it discloses
+func (node *nodeImpl) verifyIdemixTransaction(tx *obc.Transaction, issuer *idemix.IssuerPublicKey) (map[string][]byte, error) {
+	if issuer == nil {
+		return nil, utils.ErrUnknownIssuer
+	}
+	cert, err := newIdemixCert(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(cert, tx.Cert) {
+		return nil, utils.ErrUnknownIssuer
+	}
+
+	signature, err := idemix.ParseSignature(tx.Signature)
+	if err != nil {
+		node.Errorf("Failed unmarshalling idemix signature [%s].", err.Error())
+		return nil, utils.ErrInvalidTransactionSignature
+	}
+
+	// Marshall tx without signature
+	rawSignature := tx.Signature
+	tx.Signature = nil
+	rawTx, err := proto.Marshal(tx)
+	tx.Signature = rawSignature
+	if err != nil {
+		node.Errorf("Failed marshaling tx [%s].", err.Error())
+		return nil, err
+	}
+
+	if err := signature.Verify(issuer, rawTx); err != nil {
+		node.ks.audit(AuditVerifyFailure, utils.EncodeBase64(primitives.Hash(tx.Cert)), utils.ErrInvalidTransactionSignature.Error())
+		return nil, utils.ErrInvalidTransactionSignature
+	}
+
+	return signature.Attributes(issuer), nil
+}
@@ -18,7 +18,10 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/tls"
 	"crypto/x509"
+	"sync"
+	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
@@ -29,6 +32,9 @@ import (
 type nodeImpl struct {
 	isInitialized bool
 
+	// Dependencies injected at construction
+	opts Options
+
 	// Node type
 	eType NodeType
 
@@ -44,23 +50,61 @@ type nodeImpl struct {
 	ecaCertPool   *x509.CertPool
 	tcaCertPool   *x509.CertPool
 
+	// ECAs and TCAs of the other organizations trusted
+	trustedCAs trustedCAs
+
 	// 48-bytes identifier
 	id []byte
 
-	// Enrollment Certificate and private key
+	// Enrollment Certificate and private key, swapped under
+	// enrollMutex on re-enrollment
+	enrollMutex    sync.RWMutex
 	enrollID       string
 	enrollCert     *x509.Certificate
 	enrollPrivKey  *ecdsa.PrivateKey
 	enrollCertHash []byte
 
+	// Enrollment key, in place of enrollPrivKey, when enrolled
+	// with another signature scheme than ecdsa
+	enrollAltKey interface{}
+
+	// Enrollment key replaced by a rotation, kept until retireTimer fires
+	// so that the TCerts derived from it can still be used
+	retiredEnrollPrivKey *ecdsa.PrivateKey
+	retireTimer          *time.Timer
+
+	// PKCS#11 token and enrollment key held by it, in HSM mode
+	hsm          *hsm
+	enrollHSMKey *hsmKey
+
+	// TPM and enrollment key sealed to it, in TPM mode
+	tpm          *tpmDevice
+	enrollTPMKey *tpmKey
+
 	// Enrollment Chain
 	enrollChainKey interface{}
 
-	// TLS
-	tlsCert *x509.Certificate
+	// TLS certificate and key, swapped under tlsMutex on renewal
+	tlsMutex   sync.RWMutex
+	tlsCert    *x509.Certificate
+	tlsKeyPair *tls.Certificate
 
 	// Crypto SPI
 	eciesSPI primitives.AsymmetricCipherSPI
+
+	// Cryptographic service provider
+	csp CSP
+
+	// Re-enrollment task
+	reenrollStop chan struct{}
+	reenrollDone chan struct{}
+
+	// TLS certificate renewal task
+	tlsRenewStop chan struct{}
+	tlsRenewDone chan struct{}
+
+	// Serializes re-enrollments and enrollment key rotations
+	reenrollMutex sync.Mutex
 }
 
 func (node *nodeImpl) GetType() NodeType {
@@ -92,10 +136,20 @@ func (node *nodeImpl) register(eType NodeType, name string, pwd []byte, enrollID
 		return err
 	}
 
+	// Init cryptographic service provider
+	if err := node.initCSP(); err != nil {
+		return err
+	}
+
 	// Start registration
 	if node.isRegistered() {
 		return utils.ErrAlreadyRegistered
 	}
+	if node.conf.isKeyStoreReadOnly() {
+		node.Errorf("Cannot register [%s] [%s].", enrollID, utils.ErrKeyStoreReadOnly)
+
+		return utils.ErrKeyStoreReadOnly
+	}
 
 	node.Debugf("Registering node [%s]...", enrollID)
 
@@ -103,12 +157,19 @@ func (node *nodeImpl) register(eType NodeType, name string, pwd []byte, enrollID
 	err := node.initKeyStore(pwd)
 	if err != nil {
 		if err != utils.ErrKeyStoreAlreadyInitialized {
-			node.Error("Keystore already initialized.")
-		} else {
 			node.Errorf("Failed initiliazing keystore [%s].", err.Error())
 
 			return err
 		}
+		node.Error("Keystore already initialized.")
+	}
+
+	// Open PKCS#11 token or TPM
+	if err := node.initHSM(); err != nil {
+		return err
+	}
+	if err := node.initTPM(); err != nil {
+		return err
 	}
 
 	// Register crypto engine
@@ -138,6 +199,11 @@ func (node *nodeImpl) init(eType NodeType, name string, pwd []byte) error {
 		return err
 	}
 
+	// Init cryptographic service provider
+	if err := node.initCSP(); err != nil {
+		return err
+	}
+
 	if !node.isRegistered() {
 		node.Error("Not registered yet.")
 
@@ -149,15 +215,39 @@ func (node *nodeImpl) init(eType NodeType, name string, pwd []byte) error {
 	err := node.initKeyStore(pwd)
 	if err != nil {
 		if err != utils.ErrKeyStoreAlreadyInitialized {
-			node.Error("Keystore already initialized.")
-		} else {
 			node.Errorf("Failed initiliazing keystore [%s].", err.Error())
 
 			return err
 		}
+		node.Error("Keystore already initialized.")
 	}
 	node.Debug("Init keystore...done.")
 
+	// Schedule the compaction of the keystore
+	if interval := node.conf.getKeyStoreCompactInterval(); interval > 0 && !node.conf.isKeyStoreReadOnly() {
+		node.ks.startCompactor(interval, node.conf.getKeyStoreCompactThreshold())
+	}
+
+	// Open PKCS#11 token or TPM
+	if err := node.initHSM(); err != nil {
+		return err
+	}
+	if err := node.initTPM(); err != nil {
+		return err
+	}
+
+	// Complete a re-enrollment or a TLS cert renewal interrupted by a stop
+	if !node.conf.isKeyStoreReadOnly() {
+		if err := node.completeReenrollment(); err != nil {
+			node.Errorf("Failed completing re-enrollment [%s].", err.Error())
+			return err
+		}
+		if err := node.completeTLSRenewal(); err != nil {
+			node.Errorf("Failed completing TLS cert renewal [%s].", err.Error())
+			return err
+		}
+	}
+
 	// Init crypto engine
 	err = node.initCryptoEngine()
 	if err != nil {
@@ -165,6 +255,22 @@ func (node *nodeImpl) init(eType NodeType, name string, pwd []byte) error {
 		return err
 	}
 
+	// Reload the enrollment key retired by a rotation
+	if err := node.initRetiredEnrollmentKey(); err != nil {
+		node.Errorf("Failed loading retired enrollment key [%s].", err.Error())
+		return err
+	}
+
+	// Schedule the re-enrollment of the node
+	if node.conf.isReenrollmentEnabled() && !node.conf.isKeyStoreReadOnly() {
+		node.startReenroller(node.conf.getReenrollmentInterval())
+	}
+
+	// Schedule the renewal of the TLS cert
+	if node.conf.isTLSRenewalEnabled() && !node.conf.isKeyStoreReadOnly() {
+		node.startTLSRenewer(node.conf.getTLSRenewalInterval())
+	}
+
 	// Initialisation complete
 	node.isInitialized = true
 
@@ -174,6 +280,10 @@ func (node *nodeImpl) init(eType NodeType, name string, pwd []byte) error {
 }
 
 func (node *nodeImpl) close() error {
+	node.stopReenroller()
+	node.stopTLSRenewer()
+	node.stopRetireTimer()
+
 	// Close keystore
 	var err error
 
@@ -181,5 +291,43 @@ func (node *nodeImpl) close() error {
 		err = node.ks.close()
 	}
 
+	// Close PKCS#11 token
+	if node.hsm != nil {
+		if hsmErr := node.hsm.close(); hsmErr != nil && err == nil {
+			err = hsmErr
+		}
+		node.hsm = nil
+		node.enrollHSMKey = nil
+		if csp, ok := node.csp.(*hsmCSP); ok {
+			node.csp = csp.CSP
+		}
+	}
+
+	// Close TPM
+	if node.tpm != nil {
+		if tpmErr := node.tpm.close(); tpmErr != nil && err == nil {
+			err = tpmErr
+		}
+		node.tpm = nil
+		node.enrollTPMKey = nil
+		if csp, ok := node.csp.(*tpmCSP); ok {
+			node.csp = csp.CSP
+		}
+	}
+
+	node.wipeEnrollmentKeys()
+
 	return err
 }
+
+// wipeEnrollmentKeys overwrites the software enrollment keys with zeros
+func (node *nodeImpl) wipeEnrollmentKeys() {
+	node.enrollMutex.Lock()
+	defer node.enrollMutex.Unlock()
+
+	utils.ZeroizeKey(node.enrollPrivKey)
+	utils.ZeroizeKey(node.enrollAltKey)
+	utils.ZeroizeKey(node.retiredEnrollPrivKey)
+	utils.ZeroizeKey(node.enrollChainKey)
+	node.enrollPrivKey, node.enrollAltKey, node.retiredEnrollPrivKey, node.enrollChainKey = nil, nil, nil, nil
+}
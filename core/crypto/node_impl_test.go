@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// copyValidator copies the files of the validator, closed, to the ones of
+// a validator named name, enrolled with the same identity
+func copyValidator(t *testing.T, name string) {
+	initNodes()
+	src := validator.(*validatorImpl).conf.configurationPath
+	closeNodes()
+
+	dst := filepath.Join(filepath.Dir(src), name)
+	if err := os.RemoveAll(dst); err != nil {
+		t.Fatalf("Failed removing validator [%s].", err)
+	}
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, strings.TrimPrefix(path, src))
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, raw, info.Mode())
+	})
+	if err != nil {
+		t.Fatalf("Failed copying validator [%s].", err)
+	}
+}
+
+func TestValidatorInitIncompatibleKeyStore(t *testing.T) {
+	copyValidator(t, "validatorFormat")
+
+	// As a newer release would
+	node, err := openNodeKeyStore(NodeValidator, "validatorFormat", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if _, err := node.ks.sqlDB.Exec("UPDATE SchemaVersion SET version = ? WHERE component = ?", ksFormatVersion+1, ksFormatComponent); err != nil {
+		t.Fatalf("Failed updating format version [%s].", err)
+	}
+	node.ks.close()
+
+	v, err := InitValidator("validatorFormat", ksPwd)
+	if _, ok := err.(*ErrIncompatibleKeystore); !ok || v != nil {
+		t.Fatalf("Initializing a validator with a newer keystore should fail with ErrIncompatibleKeystore [%v].", err)
+	}
+}
+
+func TestValidatorInitWrongPassphrase(t *testing.T) {
+	copyValidator(t, "validatorPassphrase")
+
+	// As if encrypted under another passphrase
+	node, err := openNodeKeyStore(NodeValidator, "validatorPassphrase", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if _, err := node.ks.createDataEncryptionKey([]byte("secret")); err != nil {
+		t.Fatalf("Failed creating data encryption key [%s].", err)
+	}
+	node.ks.close()
+
+	viper.Set("security.keystore.encryption.enabled", true)
+	viper.Set("security.keystore.encryption.passphrase", "wrong")
+	defer viper.Set("security.keystore.encryption.enabled", false)
+	defer viper.Set("security.keystore.encryption.passphrase", "")
+
+	if v, err := InitValidator("validatorPassphrase", ksPwd); err == nil || v != nil {
+		t.Fatal("Initializing a validator with a wrong keystore passphrase should fail.")
+	}
+}
+
+func TestValidatorInitSecurityParametersMismatch(t *testing.T) {
+	copyValidator(t, "validatorParams")
+
+	// As if written under other parameters, none of the test scenarios
+	node, err := openNodeKeyStore(NodeValidator, "validatorParams", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	if err := node.ks.PutBlob(securityParamsNamespace, securityParamsKey, []byte("SHA2-512")); err != nil {
+		t.Fatalf("Failed updating security parameters [%s].", err)
+	}
+	node.ks.close()
+
+	v, err := InitValidator("validatorParams", ksPwd)
+	if _, ok := err.(*ErrSecurityParametersMismatch); !ok || v != nil {
+		t.Fatalf("Initializing a validator with other security parameters should fail with ErrSecurityParametersMismatch [%v].", err)
+	}
+}
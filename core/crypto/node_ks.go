@@ -21,7 +21,6 @@ import (
 	"database/sql"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"sync"
 
 	"github.com/hyperledger/fabric/core/crypto/utils"
@@ -73,29 +72,78 @@ func (node *nodeImpl) initKeyStore(pwd []byte) error {
 type keyStore struct {
 	node *nodeImpl
 
-	isOpen bool
-
 	pwd []byte
 
+	// Locked memory holding pwd
+	pwdBuf *utils.LockedBuffer
+
 	// backend
 	sqlDB *sql.DB
 
+	// Cert store, used by peers and validators only
+	certStore CertStore
+
+	// In-flight ECA fetches of certs missing from the cert store, by id
+	certFetches map[string]*certFetch
+
+	// Remote store of keys and certificates, nil if not configured
+	secretStore SecretStore
+
+	// Closed to stop the cert store trim job
+	certStoreTrimStop chan struct{}
+
+	// Closed to stop the compaction job
+	compactStop chan struct{}
+
+	// Path of the DB whose lock is held, empty if none
+	lockPath string
+
+	// Data encryption key of the BLOBs, nil if encryption is disabled,
+	// held by dekBuf and swapped under dekMutex
+	dek      []byte
+	dekBuf   *utils.LockedBuffer
+	dekMutex sync.RWMutex
+
+	// Log of the signatures made by the node, nil if disabled
+	signatureLog *signatureLog
+
 	// Sync
-	m sync.Mutex
+	m        sync.Mutex
+	initOnce sync.Once
+	initErr  error
+	isClosed bool
 }
 
 func (ks *keyStore) init(node *nodeImpl, pwd []byte) error {
-	ks.m.Lock()
-	defer ks.m.Unlock()
+	initialized := true
+	ks.initOnce.Do(func() {
+		initialized = false
+		ks.initErr = ks.initInternal(node, pwd)
+	})
 
-	if ks.isOpen {
+	if initialized && ks.initErr == nil {
 		return utils.ErrKeyStoreAlreadyInitialized
 	}
 
+	return ks.initErr
+}
+
+func (ks *keyStore) initInternal(node *nodeImpl, pwd []byte) (err error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
 	ks.node = node
-	ks.pwd = utils.Clone(pwd)
+	ks.pwdBuf = utils.NewLockedBuffer(pwd)
+	ks.pwd = ks.pwdBuf.Bytes()
+
+	// The keystore is discarded if a step fails
+	defer func() {
+		if err != nil {
+			ks.abortInit()
+		}
+	}()
 
-	err := ks.createKeyStoreIfNotExists()
+	err = ks.createKeyStoreIfNotExists()
 	if err != nil {
 		return err
 	}
@@ -105,6 +153,68 @@ func (ks *keyStore) init(node *nodeImpl, pwd []byte) error {
 		return err
 	}
 
+	err = ks.initKeys()
+	if err != nil {
+		return err
+	}
+
+	err = ks.initBlobs()
+	if err != nil {
+		return err
+	}
+
+	err = ks.initDataEncryption()
+	if err != nil {
+		return err
+	}
+
+	err = ks.initSecurityParameters()
+	if err != nil {
+		return err
+	}
+
+	err = ks.initSecretStore()
+	if err != nil {
+		return err
+	}
+
+	err = ks.initAuditLog()
+	if err != nil {
+		return err
+	}
+
+	err = ks.initSignatureLog()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// abortInit releases the DB, its lock and the secrets held by a keystore
+// whose initialization failed
+func (ks *keyStore) abortInit() {
+	if ks.sqlDB != nil {
+		if err := ks.sqlDB.Close(); err != nil {
+			ks.node.Errorf("Failed closing keystore [%s].", err.Error())
+		}
+		ks.sqlDB = nil
+	}
+	if err := ks.unlockDB(); err != nil {
+		ks.node.Errorf("Failed unlocking keystore [%s].", err.Error())
+	}
+
+	ks.setDataEncryptionKey(nil)
+	ks.pwdBuf.Destroy()
+	ks.pwd, ks.pwdBuf = nil, nil
+}
+
+// checkWritable fails with utils.ErrKeyStoreReadOnly if the keystore is read-only
+func (ks *keyStore) checkWritable() error {
+	if ks.node.conf.isKeyStoreReadOnly() {
+		return utils.ErrKeyStoreReadOnly
+	}
+
 	return nil
 }
 
@@ -124,7 +234,7 @@ func (ks *keyStore) storePrivateKey(alias string, privateKey interface{}) error
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
+	err = ks.storeKeyMaterial(alias, rawKey)
 	if err != nil {
 		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
 		return err
@@ -140,7 +250,7 @@ func (ks *keyStore) storePrivateKeyInClear(alias string, privateKey interface{})
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
+	err = ks.storeKeyMaterial(alias, rawKey)
 	if err != nil {
 		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
 		return err
@@ -153,7 +263,7 @@ func (ks *keyStore) loadPrivateKey(alias string) (interface{}, error) {
 	path := ks.node.conf.getPathForAlias(alias)
 	ks.node.Debugf("Loading private key [%s] at [%s]...", alias, path)
 
-	raw, err := ioutil.ReadFile(path)
+	raw, err := ks.loadKeyMaterial(alias)
 	if err != nil {
 		ks.node.Errorf("Failed loading private key [%s]: [%s].", alias, err.Error())
 
@@ -177,6 +287,10 @@ func (ks *keyStore) storePublicKey(alias string, publicKey interface{}) error {
 		return err
 	}
 
+	if err = ks.checkWritable(); err != nil {
+		return err
+	}
+
 	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), rawKey, 0700)
 	if err != nil {
 		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
@@ -214,7 +328,7 @@ func (ks *keyStore) storeKey(alias string, key []byte) error {
 		return err
 	}
 
-	err = ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), pem, 0700)
+	err = ks.storeKeyMaterial(alias, pem)
 	if err != nil {
 		ks.node.Errorf("Failed storing key [%s]: [%s]", alias, err)
 		return err
@@ -227,7 +341,7 @@ func (ks *keyStore) loadKey(alias string) ([]byte, error) {
 	path := ks.node.conf.getPathForAlias(alias)
 	ks.node.Debugf("Loading key [%s] at [%s]...", alias, path)
 
-	pem, err := ioutil.ReadFile(path)
+	pem, err := ks.loadKeyMaterial(alias)
 	if err != nil {
 		ks.node.Errorf("Failed loading key [%s]: [%s].", alias, err.Error())
 
@@ -245,7 +359,7 @@ func (ks *keyStore) loadKey(alias string) ([]byte, error) {
 }
 
 func (ks *keyStore) storeCert(alias string, der []byte) error {
-	err := ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), primitives.DERCertToPEM(der), 0700)
+	err := ks.storeCertPEM(alias, primitives.DERCertToPEM(der))
 	if err != nil {
 		ks.node.Errorf("Failed storing certificate [%s]: [%s]", alias, err)
 		return err
@@ -258,7 +372,7 @@ func (ks *keyStore) loadCert(alias string) ([]byte, error) {
 	path := ks.node.conf.getPathForAlias(alias)
 	ks.node.Debugf("Loading certificate [%s] at [%s]...", alias, path)
 
-	pem, err := ioutil.ReadFile(path)
+	pem, err := ks.loadCertPEM(alias)
 	if err != nil {
 		ks.node.Errorf("Failed loading certificate [%s]: [%s].", alias, err.Error())
 
@@ -285,7 +399,7 @@ func (ks *keyStore) loadCertX509AndDer(alias string) (*x509.Certificate, []byte,
 	path := ks.node.conf.getPathForAlias(alias)
 	ks.node.Debugf("Loading certificate [%s] at [%s]...", alias, path)
 
-	pem, err := ioutil.ReadFile(path)
+	pem, err := ks.loadCertPEM(alias)
 	if err != nil {
 		ks.node.Errorf("Failed loading certificate [%s]: [%s].", alias, err.Error())
 
@@ -303,16 +417,66 @@ func (ks *keyStore) loadCertX509AndDer(alias string) (*x509.Certificate, []byte,
 }
 
 func (ks *keyStore) close() error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if ks.sqlDB == nil || ks.isClosed {
+		return nil
+	}
+
 	ks.node.Debug("Closing keystore...")
-	err := ks.sqlDB.Close()
+	if ks.certStoreTrimStop != nil {
+		close(ks.certStoreTrimStop)
+	}
+	if ks.compactStop != nil {
+		close(ks.compactStop)
+	}
+	var err error
+	if ks.certStore != nil {
+		if err = ks.certStore.Close(); err != nil {
+			ks.node.Errorf("Failed closing cert store [%s].", err.Error())
+		}
+	}
+	if logErr := ks.closeSignatureLog(); logErr != nil {
+		ks.node.Errorf("Failed closing signature log [%s].", logErr.Error())
+		if err == nil {
+			err = logErr
+		}
+	}
 
-	if err != nil {
-		ks.node.Errorf("Failed closing keystore [%s].", err.Error())
-	} else {
+	// Move the committed transactions from the write-ahead log to the
+	// DB file, so that the keystore is complete without its log
+	if !ks.node.conf.isKeyStoreReadOnly() && !ks.node.conf.isKeyStoreInMemory() {
+		if _, ckptErr := ks.sqlDB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); ckptErr != nil {
+			ks.node.Errorf("Failed checkpointing keystore [%s].", ckptErr.Error())
+			if err == nil {
+				err = ckptErr
+			}
+		}
+	}
+
+	// Wait for the statements in progress to complete
+	if dbErr := ks.sqlDB.Close(); dbErr != nil {
+		ks.node.Errorf("Failed closing keystore [%s].", dbErr.Error())
+		if err == nil {
+			err = dbErr
+		}
+	}
+
+	if lockErr := ks.unlockDB(); lockErr != nil && err == nil {
+		err = lockErr
+	}
+
+	if err == nil {
 		ks.node.Debug("Closing keystore...done!")
 	}
 
-	ks.isOpen = false
+	// Wipe the secrets of the keystore
+	ks.setDataEncryptionKey(nil)
+	ks.pwdBuf.Destroy()
+	ks.pwd, ks.pwdBuf = nil, nil
+
+	ks.isClosed = true
 	return err
 }
 
@@ -324,10 +488,15 @@ func (ks *keyStore) createKeyStoreIfNotExists() error {
 
 	if !missing {
 		// Check keystore file
-		missing, err = utils.FileMissing(ks.node.conf.getKeyStorePath(), ks.node.conf.getKeyStoreFilename())
+		missing, err = utils.FileMissing(ks.node.conf.getKeyStoreDBPath(), ks.node.conf.getKeyStoreFilename())
 		ks.node.Debugf("Keystore [%s] missing [%t]:[%s]", ks.node.conf.getKeyStoreFilePath(), missing, utils.ErrToString(err))
 	}
 
+	if missing && ks.node.conf.isKeyStoreReadOnly() && !ks.node.conf.isKeyStoreInMemory() {
+		ks.node.Errorf("Read-only keystore not found at [%s].", ks.node.conf.getKeyStoreFilePath())
+		return utils.ErrKeyStoreReadOnly
+	}
+
 	if missing {
 		err := ks.createKeyStore()
 		if err != nil {
@@ -344,20 +513,28 @@ func (ks *keyStore) createKeyStore() error {
 	ksPath := ks.node.conf.getKeyStorePath()
 	ks.node.Debugf("Creating Keystore at [%s]...", ksPath)
 
-	missing, err := utils.FileMissing(ksPath, ks.node.conf.getKeyStoreFilename())
-	if !missing {
-		ks.node.Debugf("Creating Keystore at [%s]. Keystore already there", ksPath)
-		return nil
-	}
-
 	os.MkdirAll(ksPath, 0755)
 
 	// Create Raw material folder
 	os.MkdirAll(ks.node.conf.getRawsPath(), 0755)
 
+	if ks.node.conf.isKeyStoreInMemory() {
+		ks.node.Debugf("Keystore created at [%s]. DB kept in memory.", ksPath)
+		return nil
+	}
+
+	// A shared DB may have been created by another node already
+	dbPath := ks.node.conf.getKeyStoreDBPath()
+	missing, err := utils.FileMissing(dbPath, ks.node.conf.getKeyStoreFilename())
+	if !missing {
+		ks.node.Debugf("Creating Keystore at [%s]. Keystore DB already there", ksPath)
+		return nil
+	}
+	os.MkdirAll(dbPath, 0755)
+
 	// Create DB
 	ks.node.Debug("Open Keystore DB...")
-	db, err := sql.Open("sqlite3", filepath.Join(ksPath, ks.node.conf.getKeyStoreFilename()))
+	db, err := ks.openDB()
 	if err != nil {
 		return err
 	}
@@ -382,22 +559,50 @@ func (ks *keyStore) deleteKeyStore() error {
 }
 
 func (ks *keyStore) openKeyStore() error {
-	if ks.isOpen {
-		return nil
-	}
-
 	// Open DB
 	ksPath := ks.node.conf.getKeyStorePath()
 
-	sqlDB, err := sql.Open("sqlite3", filepath.Join(ksPath, ks.node.conf.getKeyStoreFilename()))
+	sqlDB, err := ks.openDB()
 	if err != nil {
 		ks.node.Errorf("Error opening keystore%s", err.Error())
 		return err
 	}
-	ks.isOpen = true
+	if ks.node.conf.isKeyStoreInMemory() {
+		// Every connection to :memory: opens a fresh DB
+		sqlDB.SetMaxOpenConns(1)
+	} else {
+		sqlDB.SetMaxOpenConns(ks.node.conf.getKeyStoreMaxOpenConns())
+	}
+	sqlDB.SetMaxIdleConns(ks.node.conf.getKeyStoreMaxIdleConns())
 	ks.sqlDB = sqlDB
 
+	if err := checkFormatVersion(sqlDB, ks.node.conf.isKeyStoreReadOnly()); err != nil {
+		ks.node.Errorf("Cannot open keystore at [%s]: [%s].", ks.node.conf.getKeyStoreFilePath(), err.Error())
+		return err
+	}
+
+	if mode := ks.node.conf.getKeyStoreFileMode(); mode != 0 && !ks.node.conf.isKeyStoreInMemory() && !ks.node.conf.isKeyStoreReadOnly() {
+		if err := os.Chmod(ks.node.conf.getKeyStoreFilePath(), mode); err != nil {
+			ks.node.Errorf("Failed setting keystore DB file mode [%s].", err.Error())
+			return err
+		}
+	}
+
 	ks.node.Debugf("Keystore opened at [%s]...done", ksPath)
 
 	return nil
 }
+
+// openDB locks the keystore DB, then opens it applying the configured SQLite pragmas
+func (ks *keyStore) openDB() (*sql.DB, error) {
+	if err := ks.lockDB(); err != nil {
+		return nil, err
+	}
+
+	return openSQLite(
+		ks.node.conf.getKeyStoreDataSourceName(),
+		ks.node.conf.getKeyStoreJournalMode(),
+		ks.node.conf.getKeyStoreSynchronous(),
+		ks.node.conf.getKeyStoreDBKey(),
+	)
+}
@@ -0,0 +1,228 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// When enabled, the certificates fetched from the ECA and the TCA, the
+// certificates cached and the verifications failed are recorded in the
+// AuditLog table of the keystore DB, so that the identities a node
+// interacted with can be reconstructed. Recording is best effort:
+// a failure is logged and never fails the audited operation.
+
+// Public Struct
+
+// AuditEvent is the kind of an AuditRecord
+type AuditEvent string
+
+const (
+	// AuditECAFetch is the fetch of an enrollment certificate from the ECA
+	AuditECAFetch AuditEvent = "eca_fetch"
+
+	// AuditTCAFetch is the fetch of a batch of TCerts from the TCA
+	AuditTCAFetch AuditEvent = "tca_fetch"
+
+	// AuditCacheInsert is the insertion of certificates in the keystore
+	AuditCacheInsert AuditEvent = "cache_insert"
+
+	// AuditVerifyFailure is a failed verification of a signature, a transaction
+	// or a certificate fetched from the ECA
+	AuditVerifyFailure AuditEvent = "verify_failure"
+
+	// AuditKeyRotation is the rotation of the enrollment key of the node
+	AuditKeyRotation AuditEvent = "key_rotation"
+)
+
+// AuditRecord is an entry of the audit log
+type AuditRecord struct {
+
+	// Time is when the event occurred
+	Time time.Time
+
+	// Event is the kind of the record
+	Event AuditEvent
+
+	// Subject identifies the certificates involved: the Base64 encoded id
+	// of a node for enrollment certificates, the attributes hash for TCerts
+	Subject string
+
+	// Caller is the function, outside the crypto package, that triggered the event
+	Caller string
+
+	// Detail carries the outcome of the event, such as an error
+	Detail string
+}
+
+// AuditFilter selects records of the audit log.
+// Zero fields match every record.
+type AuditFilter struct {
+
+	// Event selects the records of a kind
+	Event AuditEvent
+
+	// Subject selects the records about a subject
+	Subject string
+
+	// Since and Until bound the time of the records, both included
+	Since time.Time
+	Until time.Time
+
+	// Limit is the maximum number of records returned, the most recent ones
+	Limit int
+}
+
+// Private type and variables
+
+var (
+	auditMigrations = []ksMigration{
+		{1, "create AuditLog table", []string{
+			"CREATE TABLE IF NOT EXISTS AuditLog (id INTEGER, owner VARCHAR NOT NULL DEFAULT '', time INTEGER, event VARCHAR, subject VARCHAR, caller VARCHAR, detail VARCHAR, PRIMARY KEY (id))",
+			"CREATE INDEX IF NOT EXISTS AuditLogTime ON AuditLog (owner, time)",
+		}},
+	}
+
+	// Prefix of the names of the functions of this package
+	auditPackagePrefix = reflect.TypeOf(AuditRecord{}).PkgPath() + "."
+)
+
+// Public Methods
+
+// QueryAuditLog returns, in chronological order, the records of the audit
+// log of the node of type eType named name selected by filter.
+func QueryAuditLog(eType NodeType, name string, pwd []byte, filter *AuditFilter) ([]AuditRecord, error) {
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return nil, err
+	}
+	defer node.ks.close()
+
+	if filter == nil {
+		filter = &AuditFilter{}
+	}
+
+	return node.ks.queryAuditLog(filter)
+}
+
+// Private Methods
+
+func (ks *keyStore) initAuditLog() error {
+	if !ks.node.conf.isAuditEnabled() || ks.node.conf.isKeyStoreReadOnly() {
+		return nil
+	}
+
+	return migrateSchema(ks.sqlDB, "audit", auditMigrations)
+}
+
+// audit records event about subject in the audit log, if enabled
+func (ks *keyStore) audit(event AuditEvent, subject, detail string) {
+	if !ks.node.conf.isAuditEnabled() || ks.node.conf.isKeyStoreReadOnly() {
+		return
+	}
+
+	_, err := ks.sqlDB.Exec("INSERT INTO AuditLog (owner, time, event, subject, caller, detail) VALUES (?, ?, ?, ?, ?, ?)",
+		ks.node.conf.getKeyStoreOwner(), time.Now().UnixNano(), string(event), subject, auditCaller(), detail)
+	if err != nil {
+		ks.node.Errorf("Failed recording [%s] of [%s] in the audit log [%s].", event, subject, err)
+	}
+}
+
+func (ks *keyStore) queryAuditLog(filter *AuditFilter) ([]AuditRecord, error) {
+	query := "SELECT time, event, subject, caller, detail FROM AuditLog WHERE owner = ?"
+	args := []interface{}{ks.node.conf.getKeyStoreOwner()}
+	if filter.Event != "" {
+		query += " AND event = ?"
+		args = append(args, string(filter.Event))
+	}
+	if filter.Subject != "" {
+		query += " AND subject = ?"
+		args = append(args, filter.Subject)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		query += " AND time <= ?"
+		args = append(args, filter.Until.UnixNano())
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := ks.sqlDB.Query(query, args...)
+	if err != nil {
+		ks.node.Errorf("Failed querying the audit log [%s].", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var nanos int64
+		var record AuditRecord
+		var event string
+		if err := rows.Scan(&nanos, &event, &record.Subject, &record.Caller, &record.Detail); err != nil {
+			return nil, err
+		}
+		record.Time = time.Unix(0, nanos)
+		record.Event = AuditEvent(event)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Oldest first
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, nil
+}
+
+// auditCaller returns the name of the function that called into this
+// package or, if the goroutine started in this package, as for the TCert
+// pool, the outermost function of this package
+func auditCaller() string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+
+	caller := "unknown"
+	for _, pc := range pcs[:n] {
+		fn := runtime.FuncForPC(pc - 1)
+		if fn == nil {
+			continue
+		}
+		name := fn.Name()
+		if strings.HasPrefix(name, "runtime.") {
+			break
+		}
+		if !strings.HasPrefix(name, auditPackagePrefix) {
+			return name
+		}
+		caller = name
+	}
+
+	return caller
+}
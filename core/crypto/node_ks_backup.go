@@ -0,0 +1,426 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// A keystore backup is a header followed by a gob encoded ksSnapshot.
+// If the backup is encrypted, the header is followed by the salt and the
+// iteration count used to derive the backup key from the passphrase, and
+// the snapshot is sealed with AES-GCM under that key.
+
+const (
+	ksBackupVersion = 1
+
+	ksBackupPlain     = 0
+	ksBackupEncrypted = 1
+)
+
+var (
+	ksBackupMagic = []byte("FKSB")
+
+	errInvalidKeyStoreBackup = errors.New("Invalid keystore backup.")
+)
+
+type ksSnapshot struct {
+	Version int
+
+	// Raw material, by alias
+	Raws map[string][]byte
+
+	// Tables of the keystore DB
+	Tables []ksSnapshotTable
+}
+
+type ksSnapshotTable struct {
+	Name    string
+	Schema  string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Public Methods
+
+// BackupKeyStore writes to w a snapshot of the keys and certificates of
+// the node of type eType named name. If passphrase is not empty,
+// the snapshot is encrypted under a key derived from it.
+func BackupKeyStore(eType NodeType, name string, pwd, passphrase []byte, w io.Writer) error {
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return err
+	}
+	defer node.ks.close()
+
+	var snapshot bytes.Buffer
+	if err := node.ks.Backup(&snapshot); err != nil {
+		return err
+	}
+
+	header := append(utils.Clone(ksBackupMagic), ksBackupVersion, ksBackupPlain)
+	payload := snapshot.Bytes()
+	if len(passphrase) != 0 {
+		if payload, err = node.ks.sealWithPassphrase(passphrase, payload); err != nil {
+			return err
+		}
+		header[len(header)-1] = ksBackupEncrypted
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+
+	return err
+}
+
+// RestoreKeyStore replaces the keys and certificates of the node of type
+// eType named name with the snapshot read from r. passphrase must be
+// the one used to create the snapshot, if any.
+func RestoreKeyStore(eType NodeType, name string, pwd, passphrase []byte, r io.Reader) error {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	headerLen := len(ksBackupMagic) + 2
+	if len(raw) < headerLen || !bytes.Equal(raw[:len(ksBackupMagic)], ksBackupMagic) {
+		return errInvalidKeyStoreBackup
+	}
+	if raw[len(ksBackupMagic)] != ksBackupVersion {
+		return fmt.Errorf("Unsupported keystore backup version [%d].", raw[len(ksBackupMagic)])
+	}
+
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return err
+	}
+	defer node.ks.close()
+
+	payload := raw[headerLen:]
+	switch raw[headerLen-1] {
+	case ksBackupPlain:
+	case ksBackupEncrypted:
+		if len(passphrase) == 0 {
+			return errors.New("Keystore backup is encrypted. A passphrase is required.")
+		}
+		if len(payload) <= ksKEKParamsLen {
+			return errInvalidKeyStoreBackup
+		}
+		payload, err = node.ks.unsealWithPassphrase(passphrase, payload)
+		if err != nil {
+			return errors.New("Failed decrypting keystore backup. Wrong passphrase?")
+		}
+	default:
+		return errInvalidKeyStoreBackup
+	}
+
+	return node.ks.Restore(bytes.NewReader(payload))
+}
+
+// Private Methods
+
+func openNodeKeyStore(eType NodeType, name string, pwd []byte) (*nodeImpl, error) {
+	node := &nodeImpl{eType: eType}
+	if err := node.initConfiguration(name); err != nil {
+		return nil, err
+	}
+	if err := node.initCSP(); err != nil {
+		return nil, err
+	}
+	if err := node.initKeyStore(pwd); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Backup writes to w a consistent snapshot of the keystore
+func (ks *keyStore) Backup(w io.Writer) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	ks.node.Debug("Backing up keystore...")
+
+	snapshot := ksSnapshot{Version: ksBackupVersion, Raws: make(map[string][]byte)}
+
+	// Raw material
+	files, err := ioutil.ReadDir(ks.node.conf.getRawsPath())
+	if err != nil {
+		ks.node.Errorf("Failed listing raw material [%s].", err)
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		raw, err := ioutil.ReadFile(ks.node.conf.getPathForAlias(file.Name()))
+		if err != nil {
+			ks.node.Errorf("Failed reading raw material [%s]: [%s].", file.Name(), err)
+			return err
+		}
+		snapshot.Raws[file.Name()] = raw
+	}
+
+	// DB, read in a single transaction
+	tx, err := ks.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tables, err := tx.Query("SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		ks.node.Errorf("Failed listing tables [%s].", err)
+		return err
+	}
+	for tables.Next() {
+		var table ksSnapshotTable
+		if err := tables.Scan(&table.Name, &table.Schema); err != nil {
+			tables.Close()
+			return err
+		}
+		snapshot.Tables = append(snapshot.Tables, table)
+	}
+	tables.Close()
+
+	for i := range snapshot.Tables {
+		if err := ks.backupTable(tx, &snapshot.Tables[i]); err != nil {
+			ks.node.Errorf("Failed reading table [%s]: [%s].", snapshot.Tables[i].Name, err)
+			return err
+		}
+	}
+
+	if err := gob.NewEncoder(w).Encode(&snapshot); err != nil {
+		return err
+	}
+
+	ks.node.Debug("Backing up keystore...done!")
+
+	return nil
+}
+
+func (ks *keyStore) backupTable(tx *sql.Tx, table *ksSnapshotTable) error {
+	columnTypes, err := getTableColumns(tx, table.Name)
+	if err != nil {
+		return err
+	}
+	_, owned := columnTypes["owner"]
+
+	// Only the rows of the node, in case the DB is shared
+	query := fmt.Sprintf("SELECT * FROM %s", table.Name)
+	args := []interface{}{}
+	if owned {
+		query += " WHERE owner = ?"
+		args = append(args, ks.node.conf.getKeyStoreOwner())
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if table.Columns, err = rows.Columns(); err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		row := make([]interface{}, len(table.Columns))
+		ptrs := make([]interface{}, len(table.Columns))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		// The driver returns TEXT values as []byte, and gob decodes an
+		// empty []byte to a nil one, which would be restored as NULL
+		for i, column := range table.Columns {
+			if value, ok := row[i].([]byte); ok && !strings.EqualFold(columnTypes[column], "BLOB") {
+				row[i] = string(value)
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return rows.Err()
+}
+
+// Restore replaces the content of the keystore with the snapshot read from r
+func (ks *keyStore) Restore(r io.Reader) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if err := ks.checkWritable(); err != nil {
+		return err
+	}
+
+	ks.node.Debug("Restoring keystore...")
+
+	var snapshot ksSnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		ks.node.Errorf("Failed decoding snapshot [%s].", err)
+		return errInvalidKeyStoreBackup
+	}
+	if snapshot.Version != ksBackupVersion {
+		return fmt.Errorf("Unsupported keystore snapshot version [%d].", snapshot.Version)
+	}
+
+	// DB
+	tx, err := ks.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range snapshot.Tables {
+		if err := ks.restoreTable(tx, &table); err != nil {
+			ks.node.Errorf("Failed restoring table [%s]: [%s].", table.Name, err)
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Raw material
+	os.MkdirAll(ks.node.conf.getRawsPath(), 0755)
+	for alias, raw := range snapshot.Raws {
+		if filepath.Base(alias) != alias {
+			return errInvalidKeyStoreBackup
+		}
+		if err := ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), raw, 0700); err != nil {
+			ks.node.Errorf("Failed restoring raw material [%s]: [%s].", alias, err)
+			return err
+		}
+	}
+
+	ks.node.Debug("Restoring keystore...done!")
+
+	return nil
+}
+
+func (ks *keyStore) restoreTable(tx *sql.Tx, table *ksSnapshotTable) error {
+	if ks.node.conf.isKeyStoreShared() {
+		return ks.restoreOwnedRows(tx, table)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table.Name)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(table.Schema); err != nil {
+		return err
+	}
+	if len(table.Columns) == 0 {
+		return nil
+	}
+
+	placeholders := bytes.Repeat([]byte("?, "), len(table.Columns))
+	statement := fmt.Sprintf("INSERT INTO %s VALUES (%s)", table.Name, placeholders[:len(placeholders)-2])
+	for _, row := range table.Rows {
+		if _, err := tx.Exec(statement, row...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreOwnedRows replaces the rows of the node in a table of a shared
+// keystore DB, leaving those of the other nodes untouched
+func (ks *keyStore) restoreOwnedRows(tx *sql.Tx, table *ksSnapshotTable) error {
+	columnTypes, err := getTableColumns(tx, table.Name)
+	if err != nil {
+		return err
+	}
+	exists := len(columnTypes) != 0
+	_, owned := columnTypes["owner"]
+
+	ownerIndex := -1
+	for i, column := range table.Columns {
+		if column == "owner" {
+			ownerIndex = i
+		}
+	}
+	if ownerIndex < 0 {
+		if owned {
+			return fmt.Errorf("Table [%s] of the snapshot has no owner column. Restore it in a keystore DB not shared.", table.Name)
+		}
+
+		// Not namespaced, as SchemaVersion, hence common to all the nodes
+		return nil
+	}
+	if !exists {
+		// Created by nodes of another type only, it holds cached material
+		ks.node.Warningf("Skipping table [%s], missing from the shared keystore DB.", table.Name)
+		return nil
+	}
+
+	owner := ks.node.conf.getKeyStoreOwner()
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE owner = ?", table.Name), owner); err != nil {
+		return err
+	}
+
+	placeholders := bytes.Repeat([]byte("?, "), len(table.Columns))
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.Name, strings.Join(table.Columns, ", "), placeholders[:len(placeholders)-2])
+	for _, row := range table.Rows {
+		row[ownerIndex] = owner
+		if _, err := tx.Exec(statement, row...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getTableColumns returns the declared type of each column of the table
+// name, none if the table does not exist
+func getTableColumns(tx *sql.Tx, name string) (map[string]string, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var column, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &column, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+
+		columns[column] = columnType
+	}
+
+	return columns, rows.Err()
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Crypto state other than keys and certificates, such as chain keys, state
+// encryption keys or nonces, is stored in the Blobs table of the keystore
+// DB, under a namespace and a key. Like keys, blobs are covered by the
+// keystore encryption and by backups.
+
+var (
+	// Schema of the blob table
+	blobMigrations = []ksMigration{
+		{1, "create Blobs table", []string{
+			"CREATE TABLE IF NOT EXISTS Blobs (owner VARCHAR NOT NULL DEFAULT '', namespace VARCHAR, key VARCHAR, value BLOB, updated INTEGER, PRIMARY KEY (owner, namespace, key))",
+		}},
+	}
+
+	errEmptyBlobName = errors.New("Invalid blob namespace or key. It is empty.")
+)
+
+// Public Methods
+
+// PutBlob stores value under key in namespace, replacing any previous value
+func (ks *keyStore) PutBlob(namespace, key string, value []byte) error {
+	return ks.PutBlobs(namespace, map[string][]byte{key: value})
+}
+
+// PutBlobs stores, in a single transaction, the values of blobs under their
+// keys in namespace. A nil value removes the blob stored under its key.
+func (ks *keyStore) PutBlobs(namespace string, blobs map[string][]byte) error {
+	if namespace == "" {
+		return errEmptyBlobName
+	}
+
+	return ks.WithTx(func(tx CertTx) error {
+		for key, value := range blobs {
+			if err := tx.PutBlob(namespace, key, value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetBlob returns the value stored under key in namespace.
+// If no blob exists, GetBlob returns nil and no error.
+func (ks *keyStore) GetBlob(namespace, key string) ([]byte, error) {
+	if namespace == "" || key == "" {
+		return nil, errEmptyBlobName
+	}
+
+	var blob []byte
+	err := ks.sqlDB.QueryRow("SELECT value FROM Blobs WHERE owner = ? AND namespace = ? AND key = ?", ks.node.conf.getKeyStoreOwner(), namespace, key).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		ks.node.Errorf("Failed loading blob [%s/%s]: [%s].", namespace, key, err)
+
+		return nil, err
+	}
+
+	return ks.decryptBlob(blob)
+}
+
+// DeleteBlob removes the blob stored under key in namespace, if any
+func (ks *keyStore) DeleteBlob(namespace, key string) error {
+	return ks.PutBlobs(namespace, map[string][]byte{key: nil})
+}
+
+// ListBlobs returns, sorted, the keys of the blobs stored in namespace
+func (ks *keyStore) ListBlobs(namespace string) ([]string, error) {
+	if namespace == "" {
+		return nil, errEmptyBlobName
+	}
+
+	rows, err := ks.sqlDB.Query("SELECT key FROM Blobs WHERE owner = ? AND namespace = ? ORDER BY key", ks.node.conf.getKeyStoreOwner(), namespace)
+	if err != nil {
+		ks.node.Errorf("Failed listing blobs [%s]: [%s].", namespace, err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Private Methods
+
+func (ks *keyStore) initBlobs() error {
+	return migrateSchema(ks.sqlDB, "blobs", blobMigrations)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"time"
+)
+
+// SQLite never gives back to the file system the pages freed by deleted
+// rows, such as used TCerts or evicted certificates. The compaction job
+// vacuums the keystore DB once its free pages exceed a threshold, and lets
+// the cert store backend release its own unused storage.
+
+// startCompactor compacts the keystore every interval. The keystore DB is
+// vacuumed only if at least threshold bytes are free.
+func (ks *keyStore) startCompactor(interval time.Duration, threshold int64) {
+	ks.node.Debugf("Compacting keystore every [%s] with threshold [%d].", interval, threshold)
+
+	ks.compactStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ks.compact(threshold)
+			case <-stop:
+				return
+			}
+		}
+	}(ks.compactStop)
+}
+
+func (ks *keyStore) compact(threshold int64) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if ks.isClosed {
+		return
+	}
+
+	free, err := ks.getFreeSpace()
+	if err != nil {
+		ks.node.Errorf("Failed computing keystore free space [%s].", err.Error())
+		return
+	}
+	if free > 0 && free >= threshold {
+		ks.node.Debugf("Vacuuming keystore, [%d] bytes free...", free)
+		if _, err := ks.sqlDB.Exec("VACUUM"); err != nil {
+			ks.node.Errorf("Failed vacuuming keystore [%s].", err.Error())
+			return
+		}
+		// Shrink the write-ahead log as well
+		if _, err := ks.sqlDB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			ks.node.Errorf("Failed checkpointing keystore [%s].", err.Error())
+			return
+		}
+		keyStoreVacuums.inc()
+		ks.node.Debug("Vacuuming keystore...done!")
+	}
+
+	if ks.certStore != nil {
+		if err := ks.certStore.Compact(); err != nil {
+			ks.node.Errorf("Failed compacting cert store [%s].", err.Error())
+			return
+		}
+	}
+}
+
+// getFreeSpace returns the number of bytes in the free pages of the keystore DB
+func (ks *keyStore) getFreeSpace() (int64, error) {
+	var pages, pageSize int64
+	if err := ks.sqlDB.QueryRow("PRAGMA freelist_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := ks.sqlDB.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+
+	return pages * pageSize, nil
+}
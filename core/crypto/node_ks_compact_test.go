@@ -0,0 +1,135 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// freeKeyStorePages fills a scratch table of the keystore DB and drops it,
+// leaving its pages free. It returns the bytes free.
+func freeKeyStorePages(t *testing.T, ks *keyStore) int64 {
+	if _, err := ks.sqlDB.Exec("CREATE TABLE Scratch (value BLOB)"); err != nil {
+		t.Fatalf("Failed creating scratch table [%s].", err)
+	}
+	for i := 0; i < 256; i++ {
+		if _, err := ks.sqlDB.Exec("INSERT INTO Scratch (value) VALUES (?)", make([]byte, 4096)); err != nil {
+			t.Fatalf("Failed filling scratch table [%s].", err)
+		}
+	}
+	if _, err := ks.sqlDB.Exec("DROP TABLE Scratch"); err != nil {
+		t.Fatalf("Failed dropping scratch table [%s].", err)
+	}
+	// Flush the write-ahead log to the DB file
+	if _, err := ks.sqlDB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		t.Fatalf("Failed checkpointing keystore [%s].", err)
+	}
+
+	free, err := ks.getFreeSpace()
+	if err != nil {
+		t.Fatalf("Failed computing free space [%s].", err)
+	}
+	if free < 256*4096 {
+		t.Fatalf("Expected at least [%d] bytes free, got [%d].", 256*4096, free)
+	}
+	return free
+}
+
+// keyStoreFileSize returns the size of the keystore DB file, or -1 if the DB
+// is kept in memory.
+func keyStoreFileSize(t *testing.T, node *nodeImpl) int64 {
+	if node.conf.isKeyStoreInMemory() {
+		return -1
+	}
+	info, err := os.Stat(node.conf.getKeyStoreFilePath())
+	if err != nil {
+		t.Fatalf("Failed reading keystore file size [%s].", err)
+	}
+	return info.Size()
+}
+
+func TestKeyStoreCompaction(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "compact", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+
+	free := freeKeyStorePages(t, node.ks)
+	size := keyStoreFileSize(t, node)
+	vacuums := keyStoreVacuums.get()
+
+	// Below the threshold, the keystore is left as is
+	node.ks.compact(free + 1)
+	if keyStoreVacuums.get() != vacuums {
+		t.Fatal("Keystore should not be vacuumed below the threshold.")
+	}
+	if left, err := node.ks.getFreeSpace(); err != nil || left != free {
+		t.Fatalf("Free space should be left below the threshold [%d][%v].", left, err)
+	}
+
+	// At the threshold, the free pages are given back
+	node.ks.compact(free)
+	if keyStoreVacuums.get() != vacuums+1 {
+		t.Fatal("Keystore should be vacuumed at the threshold.")
+	}
+	if left, err := node.ks.getFreeSpace(); err != nil || left != 0 {
+		t.Fatalf("No space should be left free after vacuuming [%d][%v].", left, err)
+	}
+	if shrunk := keyStoreFileSize(t, node); size != -1 && shrunk >= size {
+		t.Fatalf("Keystore file should shrink, from [%d] to [%d] bytes.", size, shrunk)
+	}
+}
+
+func TestKeyStoreCompactor(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "compactor", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+
+	vacuums := keyStoreVacuums.get()
+	freeKeyStorePages(t, node.ks)
+	node.ks.startCompactor(10*time.Millisecond, 1)
+
+	// The compactor vacuums the keystore on its next tick
+	deadline := time.Now().Add(5 * time.Second)
+	for keyStoreVacuums.get() == vacuums {
+		if time.Now().After(deadline) {
+			t.Fatal("Compactor should vacuum the keystore.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// It stops when the keystore is closed
+	stop := node.ks.compactStop
+	if err := node.ks.close(); err != nil {
+		t.Fatalf("Failed closing keystore [%s].", err)
+	}
+	select {
+	case <-stop:
+	default:
+		t.Fatal("Compactor should be stopped on close.")
+	}
+	vacuums = keyStoreVacuums.get()
+	node.ks.compact(1)
+	time.Sleep(50 * time.Millisecond)
+	if keyStoreVacuums.get() != vacuums {
+		t.Fatal("Closed keystore should not be vacuumed.")
+	}
+}
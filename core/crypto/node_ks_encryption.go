@@ -0,0 +1,241 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// The BLOB columns of the keystore DB are protected by envelope encryption:
+// a random data-encryption key (DEK) encrypts the BLOBs, and
+// the DEK is stored wrapped under a key-encryption key (KEK) derived from
+// the configured passphrase, or from the keystore password if no passphrase
+// is set. The KEK is derived with PBKDF2, whose random salt and iteration
+// count are stored in front of the wrapped DEK, so that the count can be
+// raised without making the existing keystores unreadable.
+
+const (
+	ksKEKSaltLen    = 32
+	ksKEKLen        = 32
+	ksKEKIterations = 100000
+
+	// Salt followed by the 4-byte big-endian iteration count
+	ksKEKParamsLen = ksKEKSaltLen + 4
+)
+
+var (
+	errKeyStoreMissingPassphrase = errors.New("Keystore encryption requires a passphrase or a keystore password.")
+)
+
+func (ks *keyStore) initDataEncryption() error {
+	if !ks.node.conf.isKeyStoreEncryptionEnabled() {
+		ks.node.Debug("Keystore encryption disabled.")
+		return nil
+	}
+
+	passphrase := ks.node.conf.getKeyStoreEncryptionPassphrase()
+	if len(passphrase) == 0 {
+		passphrase = ks.pwd
+	}
+	if len(passphrase) == 0 {
+		ks.node.Error("Keystore encryption enabled but no passphrase available.")
+		return errKeyStoreMissingPassphrase
+	}
+
+	pending, err := ks.getPendingDataEncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	var dek []byte
+	if pending != nil {
+		// A rekey committed before its data encryption key got stored
+		dek, err = ks.unwrapDataEncryptionKey(passphrase, pending)
+		if err == nil && !ks.node.conf.isKeyStoreReadOnly() {
+			err = ks.storeDataEncryptionKey(pending)
+		}
+	} else if ks.isAliasSet(ks.node.conf.getKeyStoreDEKFilename()) {
+		dek, err = ks.loadDataEncryptionKey(passphrase)
+	} else {
+		dek, err = ks.createDataEncryptionKey(passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	ks.setDataEncryptionKey(dek)
+
+	ks.node.Debug("Keystore encryption enabled.")
+
+	return nil
+}
+
+// setDataEncryptionKey copies dek to locked memory and wipes it, together
+// with the DEK it replaces. A nil dek disables the encryption of the BLOBs.
+func (ks *keyStore) setDataEncryptionKey(dek []byte) {
+	ks.dekMutex.Lock()
+	defer ks.dekMutex.Unlock()
+
+	ks.dekBuf.Destroy()
+	ks.dek, ks.dekBuf = nil, nil
+	if dek != nil {
+		ks.dekBuf = utils.NewLockedBuffer(dek)
+		ks.dek = ks.dekBuf.Bytes()
+		utils.Zeroize(dek)
+	}
+}
+
+func (ks *keyStore) createDataEncryptionKey(passphrase []byte) ([]byte, error) {
+	if err := ks.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	ks.node.Debug("Creating keystore data encryption key...")
+
+	dek, err := ks.node.csp.GenerateSecretKey()
+	if err != nil {
+		ks.node.Errorf("Failed generating data encryption key [%s].", err)
+		return nil, err
+	}
+
+	wrapped, err := ks.sealWithPassphrase(passphrase, dek)
+	if err != nil {
+		ks.node.Errorf("Failed wrapping data encryption key [%s].", err)
+		return nil, err
+	}
+
+	path := ks.node.conf.getPathForAlias(ks.node.conf.getKeyStoreDEKFilename())
+	if err := ioutil.WriteFile(path, wrapped, 0600); err != nil {
+		ks.node.Errorf("Failed storing data encryption key [%s].", err)
+		return nil, err
+	}
+
+	ks.node.Debug("Creating keystore data encryption key...done!")
+
+	return dek, nil
+}
+
+func (ks *keyStore) loadDataEncryptionKey(passphrase []byte) ([]byte, error) {
+	path := ks.node.conf.getPathForAlias(ks.node.conf.getKeyStoreDEKFilename())
+	ks.node.Debugf("Loading data encryption key at [%s]...", path)
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		ks.node.Errorf("Failed loading data encryption key [%s].", err)
+		return nil, err
+	}
+
+	return ks.unwrapDataEncryptionKey(passphrase, raw)
+}
+
+// unwrapDataEncryptionKey returns the DEK wrapped in raw, as sealed
+// by sealWithPassphrase
+func (ks *keyStore) unwrapDataEncryptionKey(passphrase, raw []byte) ([]byte, error) {
+	dek, err := ks.unsealWithPassphrase(passphrase, raw)
+	if err != nil {
+		ks.node.Errorf("Failed unwrapping data encryption key. Wrong passphrase? [%s]", err)
+		return nil, err
+	}
+
+	return dek, nil
+}
+
+// sealWithPassphrase encrypts data under a key derived from passphrase and
+// a new random salt. The derivation parameters are prepended to the result.
+func (ks *keyStore) sealWithPassphrase(passphrase, data []byte) ([]byte, error) {
+	params, err := primitives.GetRandomBytes(ksKEKParamsLen)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(params[ksKEKSaltLen:], ksKEKIterations)
+
+	kek, err := ks.node.csp.DeriveKey(passphrase, params[:ksKEKSaltLen], ksKEKIterations, ksKEKLen)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Zeroize(kek)
+
+	ct, err := ks.node.csp.Encrypt(kek, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(params, ct...), nil
+}
+
+// unsealWithPassphrase decrypts data sealed with sealWithPassphrase
+func (ks *keyStore) unsealWithPassphrase(passphrase, sealed []byte) ([]byte, error) {
+	if len(sealed) <= ksKEKParamsLen {
+		return nil, utils.ErrInvalidKey
+	}
+	// A tampered iteration count must not stall the node
+	iterations := binary.BigEndian.Uint32(sealed[ksKEKSaltLen:ksKEKParamsLen])
+	if iterations == 0 || iterations > 100*ksKEKIterations {
+		return nil, utils.ErrInvalidKey
+	}
+
+	kek, err := ks.node.csp.DeriveKey(passphrase, sealed[:ksKEKSaltLen], int(iterations), ksKEKLen)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Zeroize(kek)
+
+	return ks.node.csp.Decrypt(kek, sealed[ksKEKParamsLen:])
+}
+
+// encryptBlob encrypts a BLOB before it is written to the keystore DB.
+// If keystore encryption is disabled, blob is returned unchanged.
+func (ks *keyStore) encryptBlob(blob []byte) ([]byte, error) {
+	ks.dekMutex.RLock()
+	defer ks.dekMutex.RUnlock()
+
+	if ks.dek == nil || blob == nil {
+		return blob, nil
+	}
+
+	ct, err := ks.node.csp.Encrypt(ks.dek, blob)
+	if err != nil {
+		ks.node.Errorf("Failed encrypting blob [%s].", err)
+		return nil, utils.ErrEncrypt
+	}
+
+	return ct, nil
+}
+
+// decryptBlob decrypts a BLOB read from the keystore DB.
+// If keystore encryption is disabled, blob is returned unchanged.
+func (ks *keyStore) decryptBlob(blob []byte) ([]byte, error) {
+	ks.dekMutex.RLock()
+	defer ks.dekMutex.RUnlock()
+
+	if ks.dek == nil || blob == nil {
+		return blob, nil
+	}
+
+	pt, err := ks.node.csp.Decrypt(ks.dek, blob)
+	if err != nil {
+		ks.node.Errorf("Failed decrypting blob [%s].", err)
+		return nil, utils.ErrDecrypt
+	}
+
+	return pt, nil
+}
@@ -0,0 +1,216 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Private and secret keys are stored, PEM encoded, in the Keys table of
+// the keystore DB, where they are covered by the keystore encryption.
+// Keys found as files in the raw material folder, as written by previous
+// versions, are moved to the Keys table the first time they are loaded.
+// If the keystore DB is kept in memory, keys are still stored as files.
+// If a secret store is configured, keys are stored there instead.
+// Keys held by a PKCS#11 token are recorded by handle, with no key material.
+
+var (
+	// Schema of the key table
+	keyMigrations = []ksMigration{
+		{1, "create Keys table", []string{
+			"CREATE TABLE IF NOT EXISTS Keys (alias VARCHAR, usage VARCHAR, key BLOB, created INTEGER, PRIMARY KEY (alias))",
+		}},
+		{2, "add handle column to Keys table", []string{
+			"ALTER TABLE Keys ADD COLUMN handle VARCHAR",
+		}},
+		{3, "namespace Keys table by owner", []string{
+			"CREATE TABLE Keys_v3 (owner VARCHAR NOT NULL DEFAULT '', alias VARCHAR, usage VARCHAR, key BLOB, created INTEGER, handle VARCHAR, PRIMARY KEY (owner, alias))",
+			"INSERT INTO Keys_v3 (alias, usage, key, created, handle) SELECT alias, usage, key, created, handle FROM Keys",
+			"DROP TABLE Keys",
+			"ALTER TABLE Keys_v3 RENAME TO Keys",
+		}},
+	}
+)
+
+func (ks *keyStore) initKeys() error {
+	if !ks.isKeyTableEnabled() {
+		return nil
+	}
+
+	return migrateSchema(ks.sqlDB, "keys", keyMigrations)
+}
+
+func (ks *keyStore) isKeyTableEnabled() bool {
+	return !ks.node.conf.isKeyStoreInMemory()
+}
+
+// getKeyUsage returns the usage recorded with the key stored under alias
+func (ks *keyStore) getKeyUsage(alias string) string {
+	switch alias {
+	case ks.node.conf.getEnrollmentKeyFilename():
+		return "enrollment"
+	case ks.node.conf.getEnrollmentChainKeyFilename():
+		return "enrollment.chain"
+	case ks.node.conf.getTLSKeyFilename():
+		return "tls"
+	case ks.node.conf.getQueryStateKeyFilename():
+		return "querystate"
+	case ks.node.conf.getTCertOwnerKDFKeyFilename():
+		return "tcert.kdf"
+	}
+
+	return ""
+}
+
+// isKeySet returns true if a key is stored under alias
+func (ks *keyStore) isKeySet(alias string) bool {
+	if ks.secretStore != nil {
+		secret, err := ks.secretStore.Get(ks.getSecretPath(alias))
+		return err == nil && secret != nil
+	}
+
+	if ks.isKeyTableEnabled() {
+		var n int
+		err := ks.sqlDB.QueryRow("SELECT COUNT(*) FROM Keys WHERE owner = ? AND alias = ?", ks.node.conf.getKeyStoreOwner(), alias).Scan(&n)
+		if err == nil && n > 0 {
+			return true
+		}
+	}
+
+	return ks.isAliasSet(alias)
+}
+
+// storeKeyMaterial stores the PEM encoded key pem under alias
+func (ks *keyStore) storeKeyMaterial(alias string, pem []byte) error {
+	if err := ks.checkWritable(); err != nil {
+		return err
+	}
+
+	if ks.secretStore != nil {
+		blob, err := ks.encryptBlob(pem)
+		if err != nil {
+			return err
+		}
+
+		return ks.secretStore.Put(ks.getSecretPath(alias), blob)
+	}
+
+	if !ks.isKeyTableEnabled() {
+		return ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), pem, 0700)
+	}
+
+	blob, err := ks.encryptBlob(pem)
+	if err != nil {
+		return err
+	}
+
+	_, err = ks.sqlDB.Exec("INSERT OR REPLACE INTO Keys (owner, alias, usage, key, created) VALUES (?, ?, ?, ?, ?)", ks.node.conf.getKeyStoreOwner(), alias, ks.getKeyUsage(alias), blob, time.Now().Unix())
+
+	return err
+}
+
+// loadKeyMaterial returns the PEM encoded key stored under alias
+func (ks *keyStore) loadKeyMaterial(alias string) ([]byte, error) {
+	if ks.secretStore != nil {
+		blob, err := ks.secretStore.Get(ks.getSecretPath(alias))
+		if err != nil {
+			return nil, err
+		}
+		if blob == nil {
+			return nil, fmt.Errorf("Key [%s] not found in the secret store.", alias)
+		}
+
+		return ks.decryptBlob(blob)
+	}
+
+	if !ks.isKeyTableEnabled() {
+		return ioutil.ReadFile(ks.node.conf.getPathForAlias(alias))
+	}
+
+	var blob []byte
+	err := ks.sqlDB.QueryRow("SELECT key FROM Keys WHERE owner = ? AND alias = ?", ks.node.conf.getKeyStoreOwner(), alias).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return ks.importKeyFile(alias)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ks.decryptBlob(blob)
+}
+
+// storeKeyHandle records under alias the handle of a key held by
+// a PKCS#11 token. Without the Keys table, the handle is stored in
+// place of the key material.
+func (ks *keyStore) storeKeyHandle(alias, handle string) error {
+	if err := ks.checkWritable(); err != nil {
+		return err
+	}
+
+	if ks.secretStore != nil || !ks.isKeyTableEnabled() {
+		return ks.storeKeyMaterial(alias, []byte(handle))
+	}
+
+	_, err := ks.sqlDB.Exec("INSERT OR REPLACE INTO Keys (owner, alias, usage, key, handle, created) VALUES (?, ?, ?, NULL, ?, ?)", ks.node.conf.getKeyStoreOwner(), alias, ks.getKeyUsage(alias), handle, time.Now().Unix())
+
+	return err
+}
+
+// loadKeyHandle returns the handle recorded under alias
+func (ks *keyStore) loadKeyHandle(alias string) (string, error) {
+	if ks.secretStore != nil || !ks.isKeyTableEnabled() {
+		handle, err := ks.loadKeyMaterial(alias)
+		return string(handle), err
+	}
+
+	var handle sql.NullString
+	err := ks.sqlDB.QueryRow("SELECT handle FROM Keys WHERE owner = ? AND alias = ?", ks.node.conf.getKeyStoreOwner(), alias).Scan(&handle)
+	if err != nil {
+		return "", err
+	}
+	if !handle.Valid {
+		return "", fmt.Errorf("Key [%s] is not held by a PKCS#11 token or a TPM.", alias)
+	}
+
+	return handle.String, nil
+}
+
+// importKeyFile moves the key file of alias to the Keys table
+func (ks *keyStore) importKeyFile(alias string) ([]byte, error) {
+	path := ks.node.conf.getPathForAlias(alias)
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ks.node.conf.isKeyStoreReadOnly() {
+		return pem, nil
+	}
+
+	ks.node.Debugf("Moving key [%s] to the keystore DB...", alias)
+	if err := ks.storeKeyMaterial(alias, pem); err != nil {
+		ks.node.Errorf("Failed moving key [%s] to the keystore DB [%s].", alias, err)
+		return pem, nil
+	}
+	if err := os.Remove(path); err != nil {
+		ks.node.Errorf("Failed removing key file [%s] [%s].", path, err)
+	}
+
+	return pem, nil
+}
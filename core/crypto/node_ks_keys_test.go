@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/spf13/viper"
+)
+
+// newKeyPEM returns a new PEM encoded private key
+func newKeyPEM(t *testing.T) []byte {
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	pem, err := primitives.PrivateKeyToPEM(key, nil)
+	if err != nil {
+		t.Fatalf("Failed converting key to PEM [%s].", err)
+	}
+	return pem
+}
+
+func TestKeyStoreKeysEncrypted(t *testing.T) {
+	viper.Set("security.keystore.encryption.enabled", true)
+	viper.Set("security.keystore.encryption.passphrase", "keys")
+	defer viper.Set("security.keystore.encryption.enabled", false)
+	defer viper.Set("security.keystore.encryption.passphrase", "")
+
+	node, err := openNodeKeyStore(NodeValidator, "keys-encrypted", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if !node.ks.isKeyTableEnabled() {
+		t.Skip("Keys are stored as files when the keystore DB is kept in memory.")
+	}
+
+	pem := newKeyPEM(t)
+	if err := node.ks.storeKeyMaterial("key", pem); err != nil {
+		t.Fatalf("Failed storing key [%s].", err)
+	}
+
+	// The Keys column holds the key encrypted
+	var blob []byte
+	if err := node.ks.sqlDB.QueryRow("SELECT key FROM Keys WHERE owner = ? AND alias = ?", node.conf.getKeyStoreOwner(), "key").Scan(&blob); err != nil {
+		t.Fatalf("Failed reading key row [%s].", err)
+	}
+	if len(blob) == 0 || bytes.Contains(blob, pem) || bytes.Contains(blob, []byte("PRIVATE KEY")) {
+		t.Fatal("Key should be encrypted at rest.")
+	}
+
+	loaded, err := node.ks.loadKeyMaterial("key")
+	if err != nil {
+		t.Fatalf("Failed loading key [%s].", err)
+	}
+	if !bytes.Equal(loaded, pem) {
+		t.Fatal("Loaded key should be the stored one.")
+	}
+}
+
+func TestKeyStoreImportKeyFile(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "keys-import", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	defer node.ks.close()
+	if !node.ks.isKeyTableEnabled() {
+		t.Skip("Keys are stored as files when the keystore DB is kept in memory.")
+	}
+
+	// A key file as written by previous versions
+	pem := newKeyPEM(t)
+	path := node.conf.getPathForAlias("legacy")
+	if err := ioutil.WriteFile(path, pem, 0700); err != nil {
+		t.Fatalf("Failed writing key file [%s].", err)
+	}
+
+	loaded, err := node.ks.loadKeyMaterial("legacy")
+	if err != nil {
+		t.Fatalf("Failed loading key [%s].", err)
+	}
+	if !bytes.Equal(loaded, pem) {
+		t.Fatal("Loaded key should be the one of the file.")
+	}
+
+	// The key is moved to the Keys table
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Key file should be removed [%v].", err)
+	}
+	var n int
+	if err := node.ks.sqlDB.QueryRow("SELECT COUNT(*) FROM Keys WHERE owner = ? AND alias = ?", node.conf.getKeyStoreOwner(), "legacy").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("Key should be stored in the Keys table [%d][%v].", n, err)
+	}
+	if loaded, err = node.ks.loadKeyMaterial("legacy"); err != nil || !bytes.Equal(loaded, pem) {
+		t.Fatalf("Key should be loaded from the Keys table [%v].", err)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// A process writing to a keystore DB holds an advisory lock on the
+// <db>.lock file next to it, holding its PID, so that a second process
+// pointed at the same DB fails to open it instead of corrupting it.
+// The nodes of a process share the lock of a DB. Read-only opens, which
+// cannot corrupt the DB, do not take it.
+
+// Private type and variables
+
+type ksFileLock struct {
+	file *os.File
+	refs int
+}
+
+var (
+	// Locks held by this process, by DB path
+	ksFileLocks      = make(map[string]*ksFileLock)
+	ksFileLocksMutex sync.Mutex
+)
+
+// Private Methods
+
+// lockDB acquires, once, the lock of the keystore DB
+func (ks *keyStore) lockDB() error {
+	if ks.lockPath != "" || ks.node.conf.isKeyStoreInMemory() || ks.node.conf.isKeyStoreReadOnly() {
+		return nil
+	}
+
+	path := ks.node.conf.getKeyStoreFilePath()
+	if err := acquireKeyStoreLock(path); err != nil {
+		ks.node.Errorf("Failed locking keystore [%s].", err.Error())
+		return err
+	}
+	ks.lockPath = path
+
+	return nil
+}
+
+// unlockDB releases the lock of the keystore DB, if held
+func (ks *keyStore) unlockDB() error {
+	if ks.lockPath == "" {
+		return nil
+	}
+
+	err := releaseKeyStoreLock(ks.lockPath)
+	ks.lockPath = ""
+
+	return err
+}
+
+func acquireKeyStoreLock(path string) error {
+	ksFileLocksMutex.Lock()
+	defer ksFileLocksMutex.Unlock()
+
+	if lock, ok := ksFileLocks[path]; ok {
+		lock.refs++
+		return nil
+	}
+
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+
+		owner := "unknown"
+		if raw, err := ioutil.ReadAll(file); err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil {
+				owner = strconv.Itoa(pid)
+			}
+		}
+
+		return fmt.Errorf("Keystore [%s] in use by PID [%s]. Stop that process, or point this one to another keystore.", path, owner)
+	}
+
+	if err := file.Truncate(0); err == nil {
+		file.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	}
+	ksFileLocks[path] = &ksFileLock{file: file, refs: 1}
+
+	return nil
+}
+
+func releaseKeyStoreLock(path string) error {
+	ksFileLocksMutex.Lock()
+	defer ksFileLocksMutex.Unlock()
+
+	lock, ok := ksFileLocks[path]
+	if !ok {
+		return nil
+	}
+	lock.refs--
+	if lock.refs > 0 {
+		return nil
+	}
+	delete(ksFileLocks, path)
+
+	// Closing the file releases the lock
+	return lock.file.Close()
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestKeyStoreLockedByAnotherProcess(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "locked", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	path := node.conf.getKeyStoreFilePath()
+
+	// Nodes of the same process share the lock
+	other, err := openNodeKeyStore(NodeValidator, "locked", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore twice in the same process [%s].", err)
+	}
+	other.ks.close()
+	node.ks.close()
+
+	// As another process would
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("Failed opening lock file [%s].", err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("Lock should be released once closed [%s].", err)
+	}
+	file.Truncate(0)
+	file.WriteString("12345\n")
+
+	if _, err := openNodeKeyStore(NodeValidator, "locked", ksPwd); err == nil || !strings.Contains(err.Error(), "PID [12345]") {
+		t.Fatalf("Opening a keystore in use should fail naming its process [%v].", err)
+	}
+}
+
+func TestValidatorInitLockedKeyStore(t *testing.T) {
+	copyValidator(t, "validatorLocked")
+	node, err := openNodeKeyStore(NodeValidator, "validatorLocked", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	path := node.conf.getKeyStoreFilePath()
+	node.ks.close()
+
+	// As another process would
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("Failed opening lock file [%s].", err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("Failed locking keystore [%s].", err)
+	}
+	file.Truncate(0)
+	file.WriteString("12345\n")
+
+	v, err := InitValidator("validatorLocked", ksPwd)
+	if err == nil || !strings.Contains(err.Error(), "in use by PID [12345]") || v != nil {
+		t.Fatalf("Initializing a validator with a keystore in use should fail naming its process [%v].", err)
+	}
+
+	// Once released by the other process
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	v, err = InitValidator("validatorLocked", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed initializing validator once the keystore released [%s].", err)
+	}
+	CloseValidator(v)
+}
+
+func TestKeyStoreInitFailureReleasesLock(t *testing.T) {
+	node, err := openNodeKeyStore(NodeValidator, "initFailure", ksPwd)
+	if err != nil {
+		t.Fatalf("Failed opening keystore [%s].", err)
+	}
+	path := node.conf.getKeyStoreFilePath()
+	if err := node.ks.PutBlob(securityParamsNamespace, securityParamsKey, []byte("SHA2-512")); err != nil {
+		t.Fatalf("Failed updating security parameters [%s].", err)
+	}
+	node.ks.close()
+
+	if _, err := openNodeKeyStore(NodeValidator, "initFailure", ksPwd); err == nil {
+		t.Fatal("Opening the keystore should fail.")
+	}
+
+	ksFileLocksMutex.Lock()
+	_, held := ksFileLocks[path]
+	ksFileLocksMutex.Unlock()
+	if held {
+		t.Fatal("A keystore failing to open should release its lock.")
+	}
+
+	// As another process would
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("Failed opening lock file [%s].", err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("A keystore failing to open should release its lock [%s].", err)
+	}
+}
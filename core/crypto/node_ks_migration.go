@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// ksMigration is a single step of the evolution of a keystore DB schema.
+// Steps are applied in order, each in its own transaction, and the version
+// reached is recorded in the SchemaVersion table. A step must never be
+// modified once released: new columns or tables go in a new step.
+//
+// Every table but SchemaVersion has an owner column, holding the node the
+// row belongs to, so that a shared keystore DB can host several nodes.
+// Rows written before the column was introduced have an empty owner,
+// the owner of the nodes with a keystore DB of their own.
+type ksMigration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// ErrIncompatibleKeystore is returned when opening a keystore DB written by
+// a newer release, whose format or schema this release cannot handle
+type ErrIncompatibleKeystore struct {
+
+	// Component is the versioned part of the keystore DB: its format,
+	// or the schema of one of its components
+	Component string
+
+	// Version is the version found in the keystore DB
+	Version int
+
+	// Supported is the last version handled by this release
+	Supported int
+}
+
+func (err *ErrIncompatibleKeystore) Error() string {
+	return fmt.Sprintf("Keystore [%s] at version [%d], newer than supported [%d]. "+
+		"The keystore was written by a newer release: upgrade this node, or restore a backup taken by this release.",
+		err.Component, err.Version, err.Supported)
+}
+
+const (
+	// Version of the format of the keystore DB, stamped when the DB is created.
+	// It is increased by changes that older releases would misread, unlike
+	// the schemas of the components, which older releases only reject.
+	ksFormatVersion = 1
+
+	ksFormatComponent = "format"
+)
+
+var (
+	// Schema of the client TCert pool
+	clientMigrations = []ksMigration{
+		{1, "create TCert tables", []string{
+			"CREATE TABLE IF NOT EXISTS TCerts (id INTEGER, attrhash VARCHAR, cert BLOB, prkz BLOB, PRIMARY KEY (id))",
+			"CREATE TABLE IF NOT EXISTS UsedTCert (id INTEGER, attrhash VARCHAR, cert BLOB, prkz BLOB, PRIMARY KEY (id))",
+		}},
+		{2, "namespace TCert tables by owner", []string{
+			"ALTER TABLE TCerts ADD COLUMN owner VARCHAR NOT NULL DEFAULT ''",
+			"ALTER TABLE UsedTCert ADD COLUMN owner VARCHAR NOT NULL DEFAULT ''",
+			"CREATE INDEX IF NOT EXISTS TCertsOwner ON TCerts (owner)",
+		}},
+		{3, "track spent TCerts", []string{
+			"ALTER TABLE TCerts ADD COLUMN spent INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE TCerts ADD COLUMN spentat INTEGER",
+			"CREATE INDEX IF NOT EXISTS TCertsUnspent ON TCerts (owner, attrhash, spent)",
+		}},
+	}
+
+	// Schema of the peer and validator cert store
+	certStoreMigrations = []ksMigration{
+		{1, "create Certificates table", []string{
+			"CREATE TABLE IF NOT EXISTS Certificates (id VARCHAR, certsign BLOB, certenc BLOB, PRIMARY KEY (id))",
+		}},
+		{2, "record certificate expiry", []string{
+			"ALTER TABLE Certificates ADD COLUMN notafter INTEGER",
+		}},
+		{3, "record certificate last use", []string{
+			"ALTER TABLE Certificates ADD COLUMN lastused INTEGER",
+		}},
+		{4, "namespace Certificates table by owner", []string{
+			"CREATE TABLE Certificates_v4 (owner VARCHAR NOT NULL DEFAULT '', id VARCHAR, certsign BLOB, certenc BLOB, notafter INTEGER, lastused INTEGER, PRIMARY KEY (owner, id))",
+			"INSERT INTO Certificates_v4 (id, certsign, certenc, notafter, lastused) SELECT id, certsign, certenc, notafter, lastused FROM Certificates",
+			"DROP TABLE Certificates",
+			"ALTER TABLE Certificates_v4 RENAME TO Certificates",
+		}},
+		{5, "record certificate metadata", []string{
+			"ALTER TABLE Certificates ADD COLUMN notbefore INTEGER",
+			"ALTER TABLE Certificates ADD COLUMN certtype VARCHAR",
+			"ALTER TABLE Certificates ADD COLUMN serial VARCHAR",
+			"ALTER TABLE Certificates ADD COLUMN issuer VARCHAR",
+			"CREATE INDEX IF NOT EXISTS CertificatesIssuer ON Certificates (owner, issuer, serial)",
+		}},
+	}
+
+	// Nodes of the same process sharing the keystore DB migrate it one at a time
+	migrationMutex sync.Mutex
+)
+
+// migrateSchema brings the schema of component up to the last of
+// the passed migrations. Components sharing the same DB file are
+// versioned independently.
+func migrateSchema(sqlDB *sql.DB, component string, migrations []ksMigration) error {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	if _, err := sqlDB.Exec("CREATE TABLE IF NOT EXISTS SchemaVersion (component VARCHAR, version INTEGER, PRIMARY KEY (component))"); err != nil {
+		log.Errorf("Failed creating table [SchemaVersion]: [%s].", err)
+		return err
+	}
+
+	current, err := getSchemaVersion(sqlDB, component)
+	if err != nil {
+		return err
+	}
+
+	for i, migration := range migrations {
+		if i > 0 && migration.version <= migrations[i-1].version {
+			return fmt.Errorf("Invalid migration list for [%s]: version [%d] out of order.", component, migration.version)
+		}
+		if migration.version <= current {
+			continue
+		}
+
+		log.Debugf("Migrating [%s] schema to version [%d]: %s...", component, migration.version, migration.description)
+		if err := applyMigration(sqlDB, component, migration); err != nil {
+			log.Errorf("Failed migrating [%s] schema to version [%d]: [%s].", component, migration.version, err)
+			return err
+		}
+		current = migration.version
+	}
+
+	if len(migrations) > 0 && current > migrations[len(migrations)-1].version {
+		return &ErrIncompatibleKeystore{component, current, migrations[len(migrations)-1].version}
+	}
+
+	return nil
+}
+
+// checkFormatVersion rejects keystore DBs of a format newer than
+// ksFormatVersion, and stamps the others with it unless readOnly
+func checkFormatVersion(sqlDB *sql.DB, readOnly bool) error {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	if readOnly {
+		// Keystore DBs created before versioning have no SchemaVersion table
+		var n int
+		if err := sqlDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'SchemaVersion'").Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	} else if _, err := sqlDB.Exec("CREATE TABLE IF NOT EXISTS SchemaVersion (component VARCHAR, version INTEGER, PRIMARY KEY (component))"); err != nil {
+		log.Errorf("Failed creating table [SchemaVersion]: [%s].", err)
+		return err
+	}
+
+	current, err := getSchemaVersion(sqlDB, ksFormatComponent)
+	if err != nil {
+		return err
+	}
+	if current > ksFormatVersion {
+		return &ErrIncompatibleKeystore{ksFormatComponent, current, ksFormatVersion}
+	}
+	if current == ksFormatVersion || readOnly {
+		return nil
+	}
+
+	_, err = sqlDB.Exec("INSERT OR REPLACE INTO SchemaVersion (component, version) VALUES (?, ?)", ksFormatComponent, ksFormatVersion)
+
+	return err
+}
+
+func getSchemaVersion(sqlDB *sql.DB, component string) (int, error) {
+	var version int
+	err := sqlDB.QueryRow("SELECT version FROM SchemaVersion WHERE component = ?", component).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		log.Errorf("Failed reading schema version of [%s]: [%s].", component, err)
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func applyMigration(sqlDB *sql.DB, component string, migration ksMigration) error {
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range migration.statements {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err = tx.Exec("INSERT OR REPLACE INTO SchemaVersion (component, version) VALUES (?, ?)", component, migration.version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// The ids of the nodes, the keys of the cached certificates and the TCert
+// keys are all derived with the hash selected by security.hashAlgorithm and
+// security.level. The keystore records these parameters the first time it
+// is opened, and refuses to be opened later under different ones: its
+// content would silently stop matching.
+
+const (
+	securityParamsNamespace = "security"
+	securityParamsKey       = "parameters"
+)
+
+// ErrSecurityParametersMismatch is returned when opening a keystore
+// written under another hash family or security level than the
+// configured ones
+type ErrSecurityParametersMismatch struct {
+
+	// Recorded are the parameters the keystore was written under
+	Recorded string
+
+	// Configured are the parameters the crypto layer is initialized with
+	Configured string
+}
+
+func (err *ErrSecurityParametersMismatch) Error() string {
+	return fmt.Sprintf("Keystore written under security parameters [%s], configured [%s]. "+
+		"Set security.hashAlgorithm and security.level back to the recorded values.",
+		err.Recorded, err.Configured)
+}
+
+// Private Methods
+
+// securityParameters returns the hash family and the security level the
+// crypto layer is initialized with, in the form SHA3-256
+func securityParameters() string {
+	return fmt.Sprintf("%s-%d", primitives.GetHashAlgorithm(), primitives.GetSecurityLevel())
+}
+
+func (ks *keyStore) initSecurityParameters() error {
+	configured := securityParameters()
+
+	recorded, err := ks.GetBlob(securityParamsNamespace, securityParamsKey)
+	if err != nil {
+		ks.node.Errorf("Failed loading security parameters [%s].", err)
+
+		return err
+	}
+
+	if recorded == nil {
+		// New keystore, or written by a release not recording them
+		if ks.node.conf.isKeyStoreReadOnly() {
+			return nil
+		}
+
+		ks.node.Debugf("Recording security parameters [%s].", configured)
+
+		return ks.PutBlob(securityParamsNamespace, securityParamsKey, []byte(configured))
+	}
+
+	if string(recorded) != configured {
+		ks.node.Errorf("Keystore written under security parameters [%s], configured [%s].", recorded, configured)
+
+		return &ErrSecurityParametersMismatch{string(recorded), configured}
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// The identity of a node, as exchanged with external tooling, is a
+// PKCS#12 bundle holding its enrollment key, its enrollment certificate
+// and the ECA certificates chain. The bundle is encrypted with AES-256
+// under a key derived from the password with PBKDF2.
+
+var (
+	errIdentityHeldByHSM = errors.New("The enrollment key is held by a PKCS#11 token and cannot be exported or imported.")
+	errIdentityHeldByTPM = errors.New("The enrollment key is sealed to a TPM and cannot be exported or imported.")
+)
+
+// Public Methods
+
+// ExportIdentity writes to w the identity of the node of type eType
+// named name, as a PKCS#12 bundle protected by password.
+func ExportIdentity(eType NodeType, name string, pwd []byte, password string, w io.Writer) error {
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return err
+	}
+	defer node.ks.close()
+
+	pfx, err := node.ks.exportIdentity(password)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(pfx)
+
+	return err
+}
+
+// ImportIdentity replaces the identity of the node of type eType named
+// name with the PKCS#12 bundle, protected by password, read from r.
+// The node must be registered already: the enrollment chain key,
+// TCA and TLS material are kept.
+func ImportIdentity(eType NodeType, name string, pwd []byte, password string, r io.Reader) error {
+	pfx, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return err
+	}
+	defer node.ks.close()
+
+	if !node.isRegistered() {
+		return utils.ErrRegistrationRequired
+	}
+
+	return node.ks.importIdentity(pfx, password)
+}
+
+// Private Methods
+
+func (ks *keyStore) exportIdentity(password string) ([]byte, error) {
+	if ks.node.conf.isHSMEnabled() {
+		return nil, errIdentityHeldByHSM
+	}
+	if ks.node.conf.isTPMEnabled() {
+		return nil, errIdentityHeldByTPM
+	}
+
+	ks.node.Debug("Exporting identity...")
+
+	key, err := ks.loadPrivateKey(ks.node.conf.getEnrollmentKeyFilename())
+	if err != nil {
+		ks.node.Errorf("Failed loading enrollment key [%s].", err)
+		return nil, err
+	}
+
+	cert, _, err := ks.loadCertX509AndDer(ks.node.conf.getEnrollmentCertFilename())
+	if err != nil {
+		ks.node.Errorf("Failed loading enrollment certificate [%s].", err)
+		return nil, err
+	}
+
+	chainPEM, err := ks.loadCert(ks.node.conf.getECACertsChainFilename())
+	if err != nil {
+		ks.node.Errorf("Failed loading ECA certificates chain [%s].", err)
+		return nil, err
+	}
+	chain, err := pemToCertificates(chainPEM)
+	if err != nil {
+		ks.node.Errorf("Failed parsing ECA certificates chain [%s].", err)
+		return nil, err
+	}
+
+	pfx, err := pkcs12.Modern.Encode(key, cert, chain, password)
+	if err != nil {
+		ks.node.Errorf("Failed encoding PKCS#12 bundle [%s].", err)
+		return nil, err
+	}
+
+	ks.node.Debug("Exporting identity...done!")
+
+	return pfx, nil
+}
+
+func (ks *keyStore) importIdentity(pfx []byte, password string) error {
+	if ks.node.conf.isHSMEnabled() {
+		return errIdentityHeldByHSM
+	}
+	if ks.node.conf.isTPMEnabled() {
+		return errIdentityHeldByTPM
+	}
+
+	ks.node.Debug("Importing identity...")
+
+	key, cert, chain, err := pkcs12.DecodeChain(pfx, password)
+	if err != nil {
+		ks.node.Errorf("Failed decoding PKCS#12 bundle [%s].", err)
+		return err
+	}
+
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		return utils.ErrInvalidKey
+	}
+	if err := primitives.CheckCertPKAgainstSK(cert, key); err != nil {
+		ks.node.Errorf("Enrollment certificate does not match enrollment key [%s].", err)
+		return err
+	}
+	if len(chain) == 0 {
+		return errors.New("The PKCS#12 bundle does not contain the ECA certificates chain.")
+	}
+	if _, err := primitives.GetCriticalExtension(cert, ECertSubjectRole); err != nil {
+		ks.node.Errorf("Failed parsing ECertSubjectRole in enrollment certificate [%s].", err)
+		return err
+	}
+	certPool := x509.NewCertPool()
+	for _, caCert := range chain {
+		certPool.AddCert(caCert)
+	}
+	if _, err := primitives.CheckCertAgainRoot(cert, certPool); err != nil {
+		ks.node.Errorf("Failed verifying enrollment certificate against ECA certificates chain [%s].", err)
+		return err
+	}
+
+	if err := ks.storePrivateKey(ks.node.conf.getEnrollmentKeyFilename(), key); err != nil {
+		ks.node.Errorf("Failed storing enrollment key [%s].", err)
+		return err
+	}
+	if err := ks.storeCert(ks.node.conf.getEnrollmentCertFilename(), cert.Raw); err != nil {
+		ks.node.Errorf("Failed storing enrollment certificate [%s].", err)
+		return err
+	}
+	var chainPEM bytes.Buffer
+	for _, caCert := range chain {
+		chainPEM.Write(primitives.DERCertToPEM(caCert.Raw))
+	}
+	if err := ks.storeCertPEM(ks.node.conf.getECACertsChainFilename(), chainPEM.Bytes()); err != nil {
+		ks.node.Errorf("Failed storing ECA certificates chain [%s].", err)
+		return err
+	}
+
+	// The common name of enrollment certificates is the enrollment id,
+	// possibly followed by the affiliation
+	enrollID := strings.Split(cert.Subject.CommonName, "\\")[0]
+	if enrollID != "" {
+		if err := ioutil.WriteFile(ks.node.conf.getEnrollmentIDPath(), []byte(enrollID), 0700); err != nil {
+			ks.node.Errorf("Failed storing enrollment id [%s].", err)
+			return err
+		}
+	}
+
+	ks.node.Debug("Importing identity...done!")
+
+	return nil
+}
+
+// pemToCertificates parses the PEM encoded certificates in raw
+func pemToCertificates(raw []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for len(raw) > 0 {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
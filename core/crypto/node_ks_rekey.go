@@ -0,0 +1,294 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// Rekeying replaces the data encryption key (DEK) of the keystore with a
+// new one, wrapped under a key-encryption key derived from a new passphrase.
+// The BLOBs of the node are re-encrypted under the new DEK, and the wrapped
+// DEK journaled, in a single transaction of the keystore DB: a failure
+// leaves the keystore as it was. The wrapped DEK is then stored in place of
+// the old one and the journal cleared. A node stopped in between stores it
+// the next time it is initialized.
+// Entries of a cert store kept outside the keystore DB cannot take part in
+// the transaction: they are dropped beforehand, to be fetched again from the ECA.
+
+// Private type and variables
+
+const (
+	rekeyNamespace = "rekey"
+)
+
+var (
+	// BLOB columns encrypted under the DEK
+	ksEncryptedColumns = []struct{ table, column string }{
+		{"Keys", "key"},
+		{"Blobs", "value"},
+		{"TCerts", "cert"},
+		{"TCerts", "prkz"},
+		{"UsedTCert", "cert"},
+		{"UsedTCert", "prkz"},
+		{"Certificates", "certsign"},
+		{"Certificates", "certenc"},
+	}
+
+	errKeyStoreEncryptionDisabled = errors.New("Keystore encryption disabled. There is no key to rotate.")
+)
+
+// Public Methods
+
+// RekeyKeyStore re-encrypts the keystore of the node of type eType named
+// name under a new data encryption key, protected by newPassphrase.
+// The keystore encryption passphrase must then be set to newPassphrase.
+func RekeyKeyStore(eType NodeType, name string, pwd, newPassphrase []byte) error {
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return err
+	}
+	defer node.ks.close()
+
+	if eType != NodeClient {
+		if err := node.ks.openCertStore(); err != nil {
+			return err
+		}
+	}
+
+	return node.ks.rekey(newPassphrase)
+}
+
+// Private Methods
+
+func (ks *keyStore) rekey(passphrase []byte) (err error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if !ks.node.conf.isKeyStoreEncryptionEnabled() {
+		return errKeyStoreEncryptionDisabled
+	}
+	if len(passphrase) == 0 {
+		return errKeyStoreMissingPassphrase
+	}
+	if err = ks.checkWritable(); err != nil {
+		return
+	}
+
+	ks.node.Debug("Rekeying keystore...")
+
+	// New DEK, wrapped under the new passphrase
+	dek, err := ks.node.csp.GenerateSecretKey()
+	if err != nil {
+		ks.node.Errorf("Failed generating data encryption key [%s].", err)
+		return
+	}
+	defer utils.Zeroize(dek)
+	wrapped, err := ks.sealWithPassphrase(passphrase, dek)
+	if err != nil {
+		ks.node.Errorf("Failed wrapping data encryption key [%s].", err)
+		return
+	}
+
+	tables, err := ks.getTables()
+	if err != nil {
+		return
+	}
+	if ks.certStore != nil && !(ks.node.conf.getCertStoreBackend() == "sqlite" && tables["Certificates"]) {
+		if err = ks.dropCertStoreEntries(); err != nil {
+			return
+		}
+	}
+
+	// Open transaction
+	tx, err := ks.sqlDB.Begin()
+	if err != nil {
+		ks.node.Errorf("Failed beginning transaction [%s].", err)
+
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	for _, column := range ksEncryptedColumns {
+		if !tables[column.table] {
+			continue
+		}
+		if err = ks.reencryptColumn(tx, column.table, column.column, dek); err != nil {
+			ks.node.Errorf("Failed re-encrypting [%s.%s] [%s].", column.table, column.column, err)
+			return
+		}
+	}
+
+	// Journal the new DEK, the commit point of the rekey
+	if _, err = tx.Exec("INSERT OR REPLACE INTO Blobs (owner, namespace, key, value, updated) VALUES (?, ?, ?, ?, ?)",
+		ks.node.conf.getKeyStoreOwner(), rekeyNamespace, "dek", wrapped, time.Now().Unix()); err != nil {
+		ks.node.Errorf("Failed journaling data encryption key [%s].", err)
+		return
+	}
+
+	// Finalize
+	if err = tx.Commit(); err != nil {
+		ks.node.Errorf("Failed commiting [%s].", err)
+
+		return
+	}
+	committed = true
+
+	ks.setDataEncryptionKey(dek)
+
+	if err = ks.storeDataEncryptionKey(wrapped); err != nil {
+		return
+	}
+
+	ks.node.Debug("Rekeying keystore...done!")
+
+	return
+}
+
+// reencryptColumn decrypts, with the DEK in use, the BLOBs of the node
+// in column of table, and encrypts them again under dek
+func (ks *keyStore) reencryptColumn(tx *sql.Tx, table, column string, dek []byte) error {
+	query := "SELECT rowid, " + column + " FROM " + table + " WHERE owner = ? AND " + column + " IS NOT NULL"
+	if table == "Blobs" {
+		query += " AND namespace <> '" + rekeyNamespace + "'"
+	}
+
+	rows, err := tx.Query(query, ks.node.conf.getKeyStoreOwner())
+	if err != nil {
+		return err
+	}
+	blobs := make(map[int64][]byte)
+	for rows.Next() {
+		var rowid int64
+		var blob []byte
+		if err := rows.Scan(&rowid, &blob); err != nil {
+			rows.Close()
+			return err
+		}
+		blobs[rowid] = blob
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for rowid, blob := range blobs {
+		pt, err := ks.decryptBlob(blob)
+		if err != nil {
+			return err
+		}
+		ct, err := ks.node.csp.Encrypt(dek, pt)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE "+table+" SET "+column+" = ? WHERE rowid = ?", ct, rowid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getTables returns the set of tables of the keystore DB
+func (ks *keyStore) getTables() (map[string]bool, error) {
+	rows, err := ks.sqlDB.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables[name] = true
+	}
+
+	return tables, rows.Err()
+}
+
+// dropCertStoreEntries removes all the entries of the cert store
+func (ks *keyStore) dropCertStoreEntries() error {
+	ids := []string{}
+	if err := ks.certStore.ForEach(func(id string, entry *CertEntry) error {
+		ids = append(ids, id)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := ks.certStore.Delete(id); err != nil {
+			ks.node.Errorf("Failed removing cert store entry [%s] [%s].", id, err)
+			return err
+		}
+	}
+	ks.node.Debugf("Removed [%d] cert store entries.", len(ids))
+
+	return nil
+}
+
+// getPendingDataEncryptionKey returns the wrapped DEK journaled by a rekey,
+// if any. It is stored as is, being already encrypted.
+func (ks *keyStore) getPendingDataEncryptionKey() ([]byte, error) {
+	var wrapped []byte
+	err := ks.sqlDB.QueryRow("SELECT value FROM Blobs WHERE owner = ? AND namespace = ? AND key = ?",
+		ks.node.conf.getKeyStoreOwner(), rekeyNamespace, "dek").Scan(&wrapped)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		ks.node.Errorf("Failed reading data encryption key journal [%s].", err)
+		return nil, err
+	}
+
+	return wrapped, nil
+}
+
+// storeDataEncryptionKey replaces the stored DEK with wrapped,
+// then clears the DEK journal
+func (ks *keyStore) storeDataEncryptionKey(wrapped []byte) error {
+	path := ks.node.conf.getPathForAlias(ks.node.conf.getKeyStoreDEKFilename())
+	if err := ioutil.WriteFile(path+".tmp", wrapped, 0600); err != nil {
+		ks.node.Errorf("Failed storing data encryption key [%s].", err)
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		ks.node.Errorf("Failed storing data encryption key [%s].", err)
+		return err
+	}
+
+	if _, err := ks.sqlDB.Exec("DELETE FROM Blobs WHERE owner = ? AND namespace = ?", ks.node.conf.getKeyStoreOwner(), rekeyNamespace); err != nil {
+		ks.node.Errorf("Failed clearing data encryption key journal [%s].", err)
+		return err
+	}
+
+	return nil
+}
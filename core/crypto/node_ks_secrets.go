@@ -0,0 +1,282 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// Public Interfaces
+
+// SecretStore is a remote store of the identity material of a node,
+// its keys and certificates. When a secret store is configured,
+// the keystore reads and writes that material through it
+// instead of keeping it on the local file system.
+type SecretStore interface {
+
+	// Get returns the secret stored at path.
+	// If no secret exists, Get returns nil and no error.
+	Get(path string) ([]byte, error)
+
+	// Put stores secret at path, replacing any previous secret
+	Put(path string, secret []byte) error
+
+	// Delete removes the secret stored at path, if any
+	Delete(path string) error
+}
+
+// SecretStoreConfig carries the parameters passed to a SecretStoreFactory
+type SecretStoreConfig struct {
+
+	// Address is the URL of the secret store
+	Address string
+
+	// Token authenticates the requests to the secret store
+	Token string
+
+	// Path is the prefix of the paths of the secrets
+	Path string
+
+	// Timeout bounds each request to the secret store
+	Timeout time.Duration
+}
+
+// SecretStoreFactory creates a new SecretStore for the passed configuration
+type SecretStoreFactory func(conf *SecretStoreConfig) (SecretStore, error)
+
+// Private type and variables
+
+var (
+	// Map of registered secret store backends
+	secretStoreFactories = make(map[string]SecretStoreFactory)
+
+	// Sync
+	secretStoreFactoriesMutex sync.RWMutex
+)
+
+func init() {
+	RegisterSecretStore("vault", newVaultSecretStore)
+}
+
+// Public Methods
+
+// RegisterSecretStore makes a secret store backend available under name.
+// The backend used by a node is selected
+// by the security.keystore.secrets.backend property.
+func RegisterSecretStore(name string, factory SecretStoreFactory) error {
+	if factory == nil {
+		return utils.ErrNilArgument
+	}
+
+	secretStoreFactoriesMutex.Lock()
+	defer secretStoreFactoriesMutex.Unlock()
+
+	if _, ok := secretStoreFactories[name]; ok {
+		return fmt.Errorf("Secret store backend [%s] already registered.", name)
+	}
+	secretStoreFactories[name] = factory
+
+	return nil
+}
+
+// Private Methods
+
+func newSecretStore(name string, conf *SecretStoreConfig) (SecretStore, error) {
+	secretStoreFactoriesMutex.RLock()
+	factory, ok := secretStoreFactories[name]
+	secretStoreFactoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Secret store backend [%s] not registered.", name)
+	}
+
+	return factory(conf)
+}
+
+func (ks *keyStore) initSecretStore() error {
+	backend := ks.node.conf.getSecretStoreBackend()
+	if backend == "" {
+		return nil
+	}
+
+	ks.node.Debugf("Open secret store [%s] at [%s].", backend, ks.node.conf.getSecretStoreAddress())
+	secretStore, err := newSecretStore(backend, &SecretStoreConfig{
+		Address: ks.node.conf.getSecretStoreAddress(),
+		Token:   ks.node.conf.getSecretStoreToken(),
+		Path:    ks.node.conf.getSecretStorePath(),
+		Timeout: ks.node.conf.getSecretStoreTimeout(),
+	})
+	if err != nil {
+		ks.node.Errorf("Failed opening secret store [%s].", err.Error())
+		return err
+	}
+	ks.secretStore = secretStore
+
+	return nil
+}
+
+// getSecretPath returns the path, in the secret store, of alias
+func (ks *keyStore) getSecretPath(alias string) string {
+	return ks.node.conf.name + "/" + alias
+}
+
+// storeCertPEM stores the PEM encoded certificate pem under alias
+func (ks *keyStore) storeCertPEM(alias string, pem []byte) error {
+	if err := ks.checkWritable(); err != nil {
+		return err
+	}
+
+	if ks.secretStore != nil {
+		return ks.secretStore.Put(ks.getSecretPath(alias), pem)
+	}
+
+	return ioutil.WriteFile(ks.node.conf.getPathForAlias(alias), pem, 0700)
+}
+
+// loadCertPEM returns the PEM encoded certificate stored under alias
+func (ks *keyStore) loadCertPEM(alias string) ([]byte, error) {
+	if ks.secretStore != nil {
+		pem, err := ks.secretStore.Get(ks.getSecretPath(alias))
+		if err != nil {
+			return nil, err
+		}
+		if pem == nil {
+			return nil, fmt.Errorf("Certificate [%s] not found in the secret store.", alias)
+		}
+
+		return pem, nil
+	}
+
+	return ioutil.ReadFile(ks.node.conf.getPathForAlias(alias))
+}
+
+// Vault backend, using the key/value secret engine over HTTP.
+// Secrets are Base64 encoded in the value field of the secret data.
+
+type vaultSecretStore struct {
+	address string
+	token   string
+	path    string
+	client  *http.Client
+}
+
+type vaultSecret struct {
+	Value string `json:"value"`
+}
+
+type vaultResponse struct {
+	Data vaultSecret `json:"data"`
+}
+
+func newVaultSecretStore(conf *SecretStoreConfig) (SecretStore, error) {
+	if conf.Address == "" {
+		return nil, fmt.Errorf("Vault address not specified.")
+	}
+
+	return &vaultSecretStore{
+		address: strings.TrimSuffix(conf.Address, "/"),
+		token:   conf.Token,
+		path:    strings.Trim(conf.Path, "/"),
+		client:  &http.Client{Timeout: conf.Timeout},
+	}, nil
+}
+
+func (store *vaultSecretStore) url(path string) string {
+	return store.address + "/v1/" + store.path + "/" + path
+}
+
+func (store *vaultSecretStore) do(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, store.url(path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", store.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return store.client.Do(req)
+}
+
+func (store *vaultSecretStore) Get(path string) ([]byte, error) {
+	resp, err := store.do("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed reading secret [%s]: [%s].", path, resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var vaultResp vaultResponse
+	if err := json.Unmarshal(raw, &vaultResp); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(vaultResp.Data.Value)
+}
+
+func (store *vaultSecretStore) Put(path string, secret []byte) error {
+	body, err := json.Marshal(&vaultSecret{Value: base64.StdEncoding.EncodeToString(secret)})
+	if err != nil {
+		return err
+	}
+
+	resp, err := store.do("PUT", path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Failed writing secret [%s]: [%s].", path, resp.Status)
+	}
+
+	return nil
+}
+
+func (store *vaultSecretStore) Delete(path string) error {
+	resp, err := store.do("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Failed deleting secret [%s]: [%s].", path, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,436 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// When enabled, every signature made by a node, with its enrollment key or
+// a TCert key, is recorded in the signature log, next to the keystore DB,
+// for forensic review after a suspected key compromise. Each record is
+// encrypted under a log key stored in the keystore and chained to the
+// previous one by its hash, so that a record altered, inserted or removed
+// before the last one is detected when the log is read.
+// The log is appended to only, and rotated when it exceeds its maximum
+// size. Like the audit log, recording is best effort.
+
+// Public Struct
+
+// SignatureRecord is an entry of the signature log
+type SignatureRecord struct {
+
+	// Time is when the signature was made
+	Time time.Time
+
+	// Key identifies the signing key: enrollment or tcert,
+	// followed by the Base64 encoded hash of its certificate
+	Key string
+
+	// Digest is the Base64 encoded hash of the signed message,
+	// the transaction for transaction signatures
+	Digest string
+
+	// Caller is the function, outside the crypto package, that requested the signature
+	Caller string
+}
+
+// SignatureFilter selects records of the signature log.
+// Zero fields match every record.
+type SignatureFilter struct {
+
+	// Key selects the records of a signing key
+	Key string
+
+	// Since and Until bound the time of the records, both included
+	Since time.Time
+	Until time.Time
+
+	// Limit is the maximum number of records returned, the most recent ones
+	Limit int
+}
+
+// ErrSignatureLogTampered is returned when reading a signature log
+// whose record cannot be decrypted or does not chain to the previous one
+type ErrSignatureLogTampered struct {
+
+	// File is the log file holding the record
+	File string
+
+	// Line is the number of the record in File, starting at 1
+	Line int
+}
+
+func (err *ErrSignatureLogTampered) Error() string {
+	return fmt.Sprintf("Signature log tampered at [%s:%d].", err.File, err.Line)
+}
+
+// Private type and variables
+
+const (
+	signatureLogNamespace = "audit"
+	signatureLogKeyName   = "signatures"
+)
+
+// signatureLogEntry is the plaintext of a record of the signature log
+type signatureLogEntry struct {
+	Time   int64
+	Key    string
+	Digest string
+	Caller string
+
+	// Prev is the hash of the previous record, as written in the log
+	Prev []byte
+}
+
+type signatureLog struct {
+	node *nodeImpl
+
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	// Key encrypting the records
+	key []byte
+
+	file *os.File
+	size int64
+	prev []byte
+
+	m sync.Mutex
+}
+
+// Public Methods
+
+// QuerySignatureLog returns, in chronological order, the records of the
+// signature log of the node of type eType named name selected by filter.
+// It fails with ErrSignatureLogTampered if the log has been altered.
+func QuerySignatureLog(eType NodeType, name string, pwd []byte, filter *SignatureFilter) ([]SignatureRecord, error) {
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return nil, err
+	}
+	defer node.ks.close()
+
+	if filter == nil {
+		filter = &SignatureFilter{}
+	}
+
+	return node.ks.querySignatureLog(filter)
+}
+
+// Private Methods
+
+func (ks *keyStore) initSignatureLog() error {
+	if !ks.node.conf.isSignatureLogEnabled() || ks.node.conf.isKeyStoreReadOnly() {
+		return nil
+	}
+
+	key, err := ks.loadSignatureLogKey()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		if key, err = ks.node.csp.GenerateSecretKey(); err != nil {
+			ks.node.Errorf("Failed generating signature log key [%s].", err)
+
+			return err
+		}
+		if err = ks.PutBlob(signatureLogNamespace, signatureLogKeyName, key); err != nil {
+			ks.node.Errorf("Failed storing signature log key [%s].", err)
+
+			return err
+		}
+	}
+
+	sl := &signatureLog{
+		node:     ks.node,
+		path:     ks.node.conf.getSignatureLogPath(),
+		maxSize:  ks.node.conf.getSignatureLogMaxSize(),
+		maxFiles: ks.node.conf.getSignatureLogMaxFiles(),
+		key:      key,
+	}
+	if err := sl.open(); err != nil {
+		ks.node.Errorf("Failed opening signature log [%s].", err)
+
+		return err
+	}
+	ks.signatureLog = sl
+
+	return nil
+}
+
+func (ks *keyStore) loadSignatureLogKey() ([]byte, error) {
+	key, err := ks.GetBlob(signatureLogNamespace, signatureLogKeyName)
+	if err != nil {
+		ks.node.Errorf("Failed loading signature log key [%s].", err)
+	}
+
+	return key, err
+}
+
+func (ks *keyStore) closeSignatureLog() error {
+	if ks.signatureLog == nil {
+		return nil
+	}
+
+	return ks.signatureLog.close()
+}
+
+// logSignature records the signature of msg with the key identified by
+// key in the signature log, if enabled
+func (node *nodeImpl) logSignature(key string, msg []byte) {
+	if node.ks == nil || node.ks.signatureLog == nil {
+		return
+	}
+
+	entry := &signatureLogEntry{
+		Time:   time.Now().UnixNano(),
+		Key:    key,
+		Digest: utils.EncodeBase64(primitives.Hash(msg)),
+		Caller: auditCaller(),
+	}
+	if err := node.ks.signatureLog.append(entry); err != nil {
+		node.Errorf("Failed recording signature with [%s] in the signature log [%s].", key, err)
+	}
+}
+
+func (ks *keyStore) querySignatureLog(filter *SignatureFilter) ([]SignatureRecord, error) {
+	key, err := ks.loadSignatureLogKey()
+	if err != nil {
+		return nil, err
+	}
+	records := []SignatureRecord{}
+	if key == nil {
+		// Nothing ever recorded
+		return records, nil
+	}
+
+	path := ks.node.conf.getSignatureLogPath()
+
+	// Oldest rotated file first
+	files := []string{}
+	for i := 1; ; i++ {
+		rotated := rotatedSignatureLogPath(path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+	files = append(files, path)
+
+	var prev []byte
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			ks.node.Errorf("Failed reading signature log [%s].", err)
+
+			return nil, err
+		}
+
+		line := 0
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		for scanner.Scan() {
+			line++
+			record := scanner.Bytes()
+
+			entry, err := ks.openSignatureLogRecord(key, record)
+			if err != nil || (prev != nil && !bytes.Equal(entry.Prev, prev)) {
+				ks.node.Errorf("Signature log tampered at [%s:%d].", file, line)
+
+				return nil, &ErrSignatureLogTampered{file, line}
+			}
+			prev = primitives.Hash(record)
+
+			t := time.Unix(0, entry.Time)
+			if filter.Key != "" && entry.Key != filter.Key {
+				continue
+			}
+			if !filter.Since.IsZero() && t.Before(filter.Since) {
+				continue
+			}
+			if !filter.Until.IsZero() && t.After(filter.Until) {
+				continue
+			}
+			records = append(records, SignatureRecord{t, entry.Key, entry.Digest, entry.Caller})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+
+	return records, nil
+}
+
+func (ks *keyStore) openSignatureLogRecord(key, record []byte) (*signatureLogEntry, error) {
+	ct, err := utils.DecodeBase64(string(record))
+	if err != nil {
+		return nil, err
+	}
+	pt, err := ks.node.csp.Decrypt(key, ct)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &signatureLogEntry{}
+	if err := json.Unmarshal(pt, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (sl *signatureLog) open() error {
+	file, err := os.OpenFile(sl.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	sl.file = file
+	sl.size = info.Size()
+
+	// Chain to the last record, rotated away if the log is empty
+	sl.prev = lastSignatureLogRecordHash(sl.path)
+	if sl.prev == nil {
+		sl.prev = lastSignatureLogRecordHash(rotatedSignatureLogPath(sl.path, 1))
+	}
+
+	return nil
+}
+
+func (sl *signatureLog) append(entry *signatureLogEntry) error {
+	sl.m.Lock()
+	defer sl.m.Unlock()
+
+	if sl.file == nil {
+		return utils.ErrKeyStoreClosed
+	}
+
+	entry.Prev = sl.prev
+	pt, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ct, err := sl.node.csp.Encrypt(sl.key, pt)
+	if err != nil {
+		return err
+	}
+	record := []byte(utils.EncodeBase64(ct))
+
+	if sl.size > 0 && sl.size+int64(len(record))+1 > sl.maxSize {
+		if err := sl.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := sl.file.Write(append(record, '\n'))
+	sl.size += int64(n)
+	if err != nil {
+		return err
+	}
+	sl.prev = primitives.Hash(record)
+
+	return nil
+}
+
+// rotate renames the log file to its first rotated name, shifting the
+// rotated files and removing the oldest, and starts a new log file.
+// It must be called holding m.
+func (sl *signatureLog) rotate() error {
+	sl.node.Debugf("Rotating signature log [%s].", sl.path)
+
+	if err := sl.file.Close(); err != nil {
+		return err
+	}
+	sl.file = nil
+
+	os.Remove(rotatedSignatureLogPath(sl.path, sl.maxFiles-1))
+	for i := sl.maxFiles - 2; i >= 1; i-- {
+		os.Rename(rotatedSignatureLogPath(sl.path, i), rotatedSignatureLogPath(sl.path, i+1))
+	}
+	if sl.maxFiles > 1 {
+		if err := os.Rename(sl.path, rotatedSignatureLogPath(sl.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(sl.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(sl.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	sl.file = file
+	sl.size = 0
+
+	return nil
+}
+
+func (sl *signatureLog) close() error {
+	sl.m.Lock()
+	defer sl.m.Unlock()
+
+	if sl.file == nil {
+		return nil
+	}
+	err := sl.file.Close()
+	sl.file = nil
+
+	return err
+}
+
+func rotatedSignatureLogPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// lastSignatureLogRecordHash returns the hash of the last record of the
+// log file at path, nil if the file is missing or empty
+func lastSignatureLogRecordHash(path string) []byte {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	lines := bytes.Split(bytes.TrimRight(raw, "\n"), []byte{'\n'})
+	last := lines[len(lines)-1]
+	if len(last) == 0 {
+		return nil
+	}
+
+	return primitives.Hash(last)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// The journal_mode and synchronous pragmas are applied to every connection
+// of the pool through the ConnectHook of a dedicated driver, registered once
+// per combination of values. busy_timeout is passed in the data source name,
+// as the read-only mode, which requires the name to be an URI.
+//
+// If a key is passed, the keystore DB file is encrypted by SQLCipher: the
+// peer must be built with the libsqlite3 tag against a SQLCipher library
+// installed as libsqlite3. The key is applied first on every connection,
+// and a connection fails if SQLite turns out not to be SQLCipher, rather
+// than leaving the file in clear.
+
+var (
+	sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqliteSynchronous  = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+	// Registered drivers, by pragmas
+	sqliteDrivers = make(map[string]string)
+
+	// Sync
+	sqliteDriversMutex sync.Mutex
+
+	// Characters with a meaning in the path of an URI
+	sqliteURIPathEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+	errSQLCipherMissing = errors.New("Keystore DB key set, but SQLite is not SQLCipher. Build with the libsqlite3 tag against SQLCipher.")
+)
+
+func sqliteDataSourceName(path string, busyTimeout int, readOnly bool) string {
+	if readOnly {
+		dataSourceName := "file:" + sqliteURIPathEscaper.Replace(path) + "?mode=ro"
+		if busyTimeout > 0 {
+			dataSourceName += fmt.Sprintf("&_busy_timeout=%d", busyTimeout)
+		}
+
+		return dataSourceName
+	}
+
+	if busyTimeout <= 0 {
+		return path
+	}
+
+	return fmt.Sprintf("%s?_busy_timeout=%d", path, busyTimeout)
+}
+
+// openSQLite opens dataSourceName setting journalMode and synchronous
+// on each new connection, keyed with key. Empty values keep the SQLite
+// defaults, and an empty key leaves the file unencrypted.
+func openSQLite(dataSourceName, journalMode, synchronous, key string) (*sql.DB, error) {
+	driverName, err := sqliteDriverName(journalMode, synchronous, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open(driverName, dataSourceName)
+}
+
+func sqliteDriverName(journalMode, synchronous, key string) (string, error) {
+	journalMode = strings.ToUpper(journalMode)
+	synchronous = strings.ToUpper(synchronous)
+
+	if journalMode == "" && synchronous == "" && key == "" {
+		return "sqlite3", nil
+	}
+	if journalMode != "" && !containsString(sqliteJournalModes, journalMode) {
+		return "", fmt.Errorf("Invalid SQLite journal mode [%s].", journalMode)
+	}
+	if synchronous != "" && !containsString(sqliteSynchronous, synchronous) {
+		return "", fmt.Errorf("Invalid SQLite synchronous mode [%s].", synchronous)
+	}
+
+	sqliteDriversMutex.Lock()
+	defer sqliteDriversMutex.Unlock()
+
+	driverKey := journalMode + "/" + synchronous + "/" + key
+	if name, ok := sqliteDrivers[driverKey]; ok {
+		return name, nil
+	}
+
+	var pragmas []string
+	if key != "" {
+		pragmas = append(pragmas, "PRAGMA key = '"+strings.Replace(key, "'", "''", -1)+"'")
+	}
+	if journalMode != "" {
+		pragmas = append(pragmas, "PRAGMA journal_mode = "+journalMode)
+	}
+	if synchronous != "" {
+		pragmas = append(pragmas, "PRAGMA synchronous = "+synchronous)
+	}
+
+	name := fmt.Sprintf("sqlite3_keystore_%d", len(sqliteDrivers))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if key != "" {
+				if err := checkSQLCipher(conn); err != nil {
+					return err
+				}
+			}
+			for _, pragma := range pragmas {
+				if _, err := conn.Exec(pragma, nil); err != nil {
+					return err
+				}
+			}
+			if key != "" {
+				// Fails now, rather than on first use, if the key is wrong
+				if _, err := conn.Exec("SELECT COUNT(*) FROM sqlite_master", nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	sqliteDrivers[driverKey] = name
+
+	return name, nil
+}
+
+// checkSQLCipher fails with errSQLCipherMissing unless conn is a SQLCipher
+// connection. SQLite silently ignores the cipher pragmas.
+func checkSQLCipher(conn *sqlite3.SQLiteConn) error {
+	rows, err := conn.Query("PRAGMA cipher_version", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if err := rows.Next(make([]driver.Value, len(rows.Columns()))); err != nil {
+		return errSQLCipherMissing
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
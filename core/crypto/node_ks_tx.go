@@ -0,0 +1,215 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// WithTx runs a function against a single transaction of the keystore DB,
+// so that several records, such as a certificate, its key and their
+// metadata, are stored all together or not at all.
+// Only the records kept in the keystore DB take part in the transaction:
+// keys held by a secret store or stored as files cannot be stored through it.
+
+// Public Struct
+
+// CertTx stores records in the keystore DB within a transaction
+type CertTx interface {
+
+	// StoreCertificates stores a batch of unused TCerts
+	StoreCertificates(records []CertRecord) error
+
+	// StorePrivateKey stores privateKey under alias
+	StorePrivateKey(alias string, privateKey interface{}) error
+
+	// PutBlob stores value under key in namespace. A nil value removes the blob.
+	PutBlob(namespace, key string, value []byte) error
+
+	// DeleteUnusedTCerts removes the unspent TCerts of a client
+	DeleteUnusedTCerts() error
+}
+
+// Private type and variables
+
+var (
+	errKeyNotInDB = errors.New("Keys are not stored in the keystore DB and cannot be stored within a transaction.")
+)
+
+type ksTx struct {
+	ks *keyStore
+	tx *sql.Tx
+
+	// Audit records, written once committed
+	audits []func()
+}
+
+// Public Methods
+
+// WithTx calls fn with a transaction of the keystore DB. The transaction is
+// committed if fn returns nil, and rolled back if fn returns an error or panics.
+func (ks *keyStore) WithTx(fn func(tx CertTx) error) (err error) {
+	if err = ks.checkWritable(); err != nil {
+		return
+	}
+
+	// Open transaction
+	tx, err := ks.sqlDB.Begin()
+	if err != nil {
+		ks.node.Errorf("Failed beginning transaction [%s].", err)
+
+		return
+	}
+
+	certTx := &ksTx{ks: ks, tx: tx}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(certTx); err != nil {
+		return
+	}
+
+	// Finalize
+	if err = tx.Commit(); err != nil {
+		ks.node.Errorf("Failed commiting [%s].", err)
+
+		return
+	}
+	committed = true
+
+	for _, audit := range certTx.audits {
+		audit()
+	}
+
+	return
+}
+
+func (certTx *ksTx) StoreCertificates(records []CertRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	ks := certTx.ks
+
+	stmt, err := certTx.tx.Prepare("INSERT INTO TCerts (owner, attrhash, cert, prkz) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		ks.node.Errorf("Failed preparing statement [%s].", err)
+
+		return err
+	}
+	defer stmt.Close()
+
+	// A TCert listed twice is stored once, not to be used twice
+	stored := make(map[string]bool, len(records))
+	for _, record := range records {
+		if stored[string(record.Cert)] {
+			continue
+		}
+		stored[string(record.Cert)] = true
+
+		cert, err := ks.encryptBlob(record.Cert)
+		if err != nil {
+			return err
+		}
+		prek0, err := ks.encryptBlob(record.PreK0)
+		if err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(ks.node.conf.getKeyStoreOwner(), record.AttributesHash, cert, prek0); err != nil {
+			ks.node.Errorf("Failed inserting unused TCert to TCerts: [%s].", err)
+			tCertInsertFailures.inc()
+
+			return err
+		}
+	}
+
+	subject, detail := records[0].AttributesHash, fmt.Sprintf("%d TCerts", len(records))
+	certTx.audits = append(certTx.audits, func() {
+		ks.audit(AuditCacheInsert, subject, detail)
+	})
+
+	return nil
+}
+
+func (certTx *ksTx) StorePrivateKey(alias string, privateKey interface{}) error {
+	ks := certTx.ks
+	if ks.secretStore != nil || !ks.isKeyTableEnabled() {
+		return errKeyNotInDB
+	}
+
+	rawKey, err := primitives.PrivateKeyToPEM(privateKey, ks.pwd)
+	if err != nil {
+		ks.node.Errorf("Failed converting private key to PEM [%s]: [%s]", alias, err)
+		return err
+	}
+	blob, err := ks.encryptBlob(rawKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = certTx.tx.Exec("INSERT OR REPLACE INTO Keys (owner, alias, usage, key, created) VALUES (?, ?, ?, ?, ?)", ks.node.conf.getKeyStoreOwner(), alias, ks.getKeyUsage(alias), blob, time.Now().Unix())
+	if err != nil {
+		ks.node.Errorf("Failed storing private key [%s]: [%s]", alias, err)
+		return err
+	}
+
+	return nil
+}
+
+func (certTx *ksTx) PutBlob(namespace, key string, value []byte) (err error) {
+	if namespace == "" || key == "" {
+		return errEmptyBlobName
+	}
+	ks := certTx.ks
+	owner := ks.node.conf.getKeyStoreOwner()
+
+	if value == nil {
+		_, err = certTx.tx.Exec("DELETE FROM Blobs WHERE owner = ? AND namespace = ? AND key = ?", owner, namespace, key)
+	} else {
+		var blob []byte
+		if blob, err = ks.encryptBlob(value); err != nil {
+			return
+		}
+		_, err = certTx.tx.Exec("INSERT OR REPLACE INTO Blobs (owner, namespace, key, value, updated) VALUES (?, ?, ?, ?, ?)", owner, namespace, key, blob, time.Now().Unix())
+	}
+	if err != nil {
+		ks.node.Errorf("Failed storing blob [%s/%s]: [%s].", namespace, key, err)
+	}
+
+	return
+}
+
+func (certTx *ksTx) DeleteUnusedTCerts() error {
+	ks := certTx.ks
+
+	if _, err := certTx.tx.Exec("DELETE FROM TCerts WHERE owner = ? AND spent = 0", ks.node.conf.getKeyStoreOwner()); err != nil {
+		ks.node.Errorf("Failed cleaning up unused TCert entries: [%s].", err)
+
+		return err
+	}
+
+	return nil
+}
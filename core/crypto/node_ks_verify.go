@@ -0,0 +1,247 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// Public Struct
+
+// KeyStoreReport is the outcome of VerifyKeyStore
+type KeyStoreReport struct {
+
+	// Checked is the number of certificates checked
+	Checked int
+
+	// Problems lists the certificates failing verification
+	Problems []KeyStoreProblem
+
+	// Repaired is the number of corrupt cert store entries removed
+	Repaired int
+}
+
+// KeyStoreProblem describes a certificate failing verification
+type KeyStoreProblem struct {
+
+	// Alias is the alias of a certificate of the node,
+	// or the id of a certificate in the cert store
+	Alias string
+
+	// Cached is true for the certificates in the cert store
+	Cached bool
+
+	// Reason tells why the certificate failed verification
+	Reason string
+}
+
+func (problem KeyStoreProblem) String() string {
+	if problem.Cached {
+		return fmt.Sprintf("cert store entry [%s]: %s", problem.Alias, problem.Reason)
+	}
+
+	return fmt.Sprintf("certificate [%s]: %s", problem.Alias, problem.Reason)
+}
+
+// Public Methods
+
+// VerifyKeyStore checks the certificates in the keystore of the node of
+// type eType named name: they must parse and chain to the ECA certificates
+// chain, and the entries of the cert store must be stored under the id of
+// their certificate. If repair is true, the entries of the cert store
+// failing verification are removed, to be fetched again from the ECA.
+func VerifyKeyStore(eType NodeType, name string, pwd []byte, repair bool) (*KeyStoreReport, error) {
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return nil, err
+	}
+	defer node.ks.close()
+
+	if !node.isRegistered() {
+		return nil, utils.ErrRegistrationRequired
+	}
+
+	if eType != NodeClient {
+		if err := node.ks.openCertStore(); err != nil {
+			return nil, err
+		}
+	}
+
+	return node.ks.verify(repair)
+}
+
+// Private Methods
+
+func (ks *keyStore) verify(repair bool) (*KeyStoreReport, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if repair {
+		if err := ks.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	ks.node.Debug("Verifying keystore...")
+
+	report := &KeyStoreReport{}
+	problem := func(alias string, cached bool, err error) {
+		ks.node.Warningf("Verification of [%s] failed [%s].", alias, err)
+		report.Problems = append(report.Problems, KeyStoreProblem{alias, cached, err.Error()})
+	}
+
+	// CA certificates chains
+	ecaCertPool := x509.NewCertPool()
+	for _, alias := range []string{
+		ks.node.conf.getECACertsChainFilename(),
+		ks.node.conf.getTCACertsChainFilename(),
+		ks.node.conf.getTLSCACertsChainFilename(),
+	} {
+		if !ks.isCertSet(alias) {
+			continue
+		}
+		report.Checked++
+
+		certs, err := ks.loadCertsChain(alias)
+		if err != nil {
+			problem(alias, false, err)
+			continue
+		}
+		if alias == ks.node.conf.getECACertsChainFilename() {
+			for _, cert := range certs {
+				ecaCertPool.AddCert(cert)
+			}
+		}
+	}
+
+	// Enrollment certificate
+	alias := ks.node.conf.getEnrollmentCertFilename()
+	report.Checked++
+	if err := ks.verifyCert(alias, ecaCertPool); err != nil {
+		problem(alias, false, err)
+	}
+
+	// Cert store
+	if ks.certStore == nil {
+		ks.node.Debugf("Verifying keystore...done! [%d] problems.", len(report.Problems))
+		return report, nil
+	}
+
+	corrupt := []string{}
+	err := ks.certStore.ForEach(func(sid string, entry *CertEntry) error {
+		report.Checked++
+		if err := ks.verifyCertEntry(sid, entry, ecaCertPool); err != nil {
+			problem(sid, true, err)
+			corrupt = append(corrupt, sid)
+		}
+
+		return nil
+	})
+	if err != nil {
+		ks.node.Errorf("Failed scanning cert store [%s].", err.Error())
+		return nil, err
+	}
+
+	if repair {
+		for _, sid := range corrupt {
+			if err := ks.certStore.Delete(sid); err != nil {
+				ks.node.Errorf("Failed deleting cert for [%s]: [%s].", sid, err.Error())
+				return report, err
+			}
+			report.Repaired++
+		}
+	}
+
+	ks.node.Debugf("Verifying keystore...done! [%d] problems, [%d] repaired.", len(report.Problems), report.Repaired)
+
+	return report, nil
+}
+
+// isCertSet returns true if a certificate is stored under alias
+func (ks *keyStore) isCertSet(alias string) bool {
+	if ks.secretStore != nil {
+		pem, err := ks.secretStore.Get(ks.getSecretPath(alias))
+		return err == nil && pem != nil
+	}
+
+	return ks.isAliasSet(alias)
+}
+
+// loadCertsChain returns the certificates stored, PEM encoded, under alias
+func (ks *keyStore) loadCertsChain(alias string) ([]*x509.Certificate, error) {
+	pem, err := ks.loadCertPEM(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := pemToCertificates(pem)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate found.")
+	}
+
+	return certs, nil
+}
+
+func (ks *keyStore) verifyCert(alias string, certPool *x509.CertPool) error {
+	cert, _, err := ks.loadCertX509AndDer(alias)
+	if err != nil {
+		return err
+	}
+
+	markFabricExtensionsHandled(cert)
+	_, err = primitives.CheckCertAgainRoot(cert, certPool)
+
+	return err
+}
+
+func (ks *keyStore) verifyCertEntry(sid string, entry *CertEntry, certPool *x509.CertPool) error {
+	certSign, err := ks.decryptBlob(entry.CertSign)
+	if err != nil {
+		return err
+	}
+	x509Cert, err := primitives.DERToX509Certificate(certSign)
+	if err != nil {
+		return err
+	}
+	markFabricExtensionsHandled(x509Cert)
+	if _, err := primitives.CheckCertAgainRoot(x509Cert, certPool); err != nil {
+		return err
+	}
+	if utils.EncodeBase64(primitives.Hash(certSign)) != sid {
+		return errors.New("Certificate does not match its id.")
+	}
+
+	if len(entry.CertEnc) != 0 {
+		certEnc, err := ks.decryptBlob(entry.CertEnc)
+		if err != nil {
+			return err
+		}
+		if _, err := primitives.DERToX509Certificate(certEnc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -16,38 +16,51 @@ limitations under the License.
 
 package crypto
 
+import (
+	"github.com/op/go-logging"
+)
+
 func (node *nodeImpl) prependPrefix(args []interface{}) []interface{} {
 	return append([]interface{}{node.conf.logPrefix}, args...)
 }
 
 func (node *nodeImpl) Infof(format string, args ...interface{}) {
-	log.Infof(node.conf.logPrefix+format, args...)
+	node.logger().Infof(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) Info(args ...interface{}) {
-	log.Info(node.prependPrefix(args)...)
+	node.logger().Info(node.prependPrefix(args)...)
 }
 
 func (node *nodeImpl) Debugf(format string, args ...interface{}) {
-	log.Debugf(node.conf.logPrefix+format, args...)
+	node.logger().Debugf(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) Debug(args ...interface{}) {
-	log.Debug(node.prependPrefix(args)...)
+	node.logger().Debug(node.prependPrefix(args)...)
 }
 
 func (node *nodeImpl) Errorf(format string, args ...interface{}) {
-	log.Errorf(node.conf.logPrefix+format, args...)
+	node.logger().Errorf(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) Error(args ...interface{}) {
-	log.Error(node.prependPrefix(args)...)
+	node.logger().Error(node.prependPrefix(args)...)
 }
 
 func (node *nodeImpl) Warningf(format string, args ...interface{}) {
-	log.Warningf(node.conf.logPrefix+format, args...)
+	node.logger().Warningf(node.conf.logPrefix+format, args...)
 }
 
 func (node *nodeImpl) Warning(args ...interface{}) {
-	log.Warning(node.prependPrefix(args)...)
+	node.logger().Warning(node.prependPrefix(args)...)
+}
+
+// logger returns the logger of the node, the crypto logger by default
+func (node *nodeImpl) logger() *logging.Logger {
+	if node.opts.Logger != nil {
+		return node.opts.Logger
+	}
+
+	return log
 }
@@ -0,0 +1,288 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics of the keystore DBs, rendered in the Prometheus text
+// exposition format by MetricsHandler.
+
+var (
+	certCacheHits = newMetricCounter(
+		"crypto_cert_cache_hits_total",
+		"Enrollment certificates served from the cert store.")
+
+	certHotCacheHits = newMetricCounter(
+		"crypto_cert_hot_cache_hits_total",
+		"Enrollment certificates served from the in-memory cache of hot certificates.")
+
+	certCacheMisses = newMetricCounter(
+		"crypto_cert_cache_misses_total",
+		"Enrollment certificates missing or expiring in the cert store.")
+
+	certCacheEvictions = newMetricCounter(
+		"crypto_cert_cache_evictions_total",
+		"Enrollment certificates evicted from the cert store.")
+
+	certVerifyCacheHits = newMetricCounter(
+		"crypto_cert_verify_cache_hits_total",
+		"Transaction certificates whose chain was already verified.")
+
+	certVerifyCacheMisses = newMetricCounter(
+		"crypto_cert_verify_cache_misses_total",
+		"Transaction certificates whose chain was walked.")
+
+	certFetchesShared = newMetricCounter(
+		"crypto_eca_fetches_shared_total",
+		"Enrollment certificate lookups served by a concurrent ECA fetch.")
+
+	peerCertFetches = newMetricCounter(
+		"crypto_peer_cert_fetches_total",
+		"Enrollment certificates served by the peers themselves instead of the ECA.")
+
+	peerCertFetchFailures = newMetricCounter(
+		"crypto_peer_cert_fetch_failures_total",
+		"Enrollment certificate requests to the peers failed or rejected, and sent to the ECA instead.")
+
+	ecaFetchRetries = newMetricCounter(
+		"crypto_eca_fetch_retries_total",
+		"Enrollment certificate fetches from the ECA retried after a transient failure.")
+
+	certValidationFailures = newMetricCounter(
+		"crypto_cert_validation_failures_total",
+		"Enrollment certificates fetched from the ECA and rejected by validation.")
+
+	reenrollments = newMetricCounter(
+		"crypto_reenrollments_total",
+		"Re-enrollments of the node with the ECA before its enrollment certificate expired.")
+
+	tlsRenewals = newMetricCounter(
+		"crypto_tls_renewals_total",
+		"Renewals of the TLS certificate of the node with the TLSCA before it expired.")
+
+	ocspCacheHits = newMetricCounter(
+		"crypto_ocsp_cache_hits_total",
+		"Revocation checks of enrollment certificates answered by a cached OCSP status.")
+
+	ocspRequests = newMetricCounter(
+		"crypto_ocsp_requests_total",
+		"OCSP requests sent to the responder.")
+
+	ocspFailures = newMetricCounter(
+		"crypto_ocsp_failures_total",
+		"Revocation checks of enrollment certificates for which no OCSP status could be obtained.")
+
+	crlPolls = newMetricCounter(
+		"crypto_crl_polls_total",
+		"CRLs fetched from the ECA and the TCA.")
+
+	crlPollFailures = newMetricCounter(
+		"crypto_crl_poll_failures_total",
+		"CRLs that could not be fetched from the ECA or the TCA, or applied.")
+
+	crlFeedEvents = newMetricCounter(
+		"crypto_crl_feed_events_total",
+		"Revocation events received from the revocation feed of the ECA.")
+
+	crlFeedFailures = newMetricCounter(
+		"crypto_crl_feed_failures_total",
+		"Subscriptions to the revocation feed of the ECA that ended, and CRLs pushed by it that could not be applied.")
+
+	keyStoreVacuums = newMetricCounter(
+		"crypto_keystore_vacuums_total",
+		"Vacuums of the keystore DB.")
+
+	certInsertFailures = newMetricCounter(
+		"crypto_cert_insert_failures_total",
+		"Failed insertions of enrollment certificates in the cert store.")
+
+	tCertInsertFailures = newMetricCounter(
+		"crypto_tcert_insert_failures_total",
+		"Failed insertions of TCerts in the client keystore.")
+
+	tCertPoolRefills = newMetricCounter(
+		"crypto_tcert_pool_refills_total",
+		"Batches of TCerts fetched from the TCA in the background, the pool being below its watermark.")
+
+	tCertPoolMisses = newMetricCounter(
+		"crypto_tcert_pool_misses_total",
+		"TCert requests blocked on the TCA, the pool having no unused TCert.")
+
+	tCertPoolDepth = newMetricGauge(
+		"crypto_tcert_pool_depth",
+		"Unused TCerts of a client, by attribute set.",
+		"client", "attrhash")
+
+	ecaFetchLatency = newMetricHistogram(
+		"crypto_eca_fetch_duration_seconds",
+		"Latency of enrollment certificate fetches from the ECA.",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+
+	metrics = []metric{certCacheHits, certHotCacheHits, certCacheMisses, certCacheEvictions, certVerifyCacheHits, certVerifyCacheMisses, certFetchesShared, peerCertFetches, peerCertFetchFailures, ecaFetchRetries, certValidationFailures, reenrollments, tlsRenewals, ocspCacheHits, ocspRequests, ocspFailures, crlPolls, crlPollFailures, crlFeedEvents, crlFeedFailures, keyStoreVacuums, certInsertFailures, tCertInsertFailures, tCertPoolRefills, tCertPoolMisses, tCertPoolDepth, ecaFetchLatency}
+)
+
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+type metricCounter struct {
+	name  string
+	help  string
+	value uint64
+}
+
+type metricGauge struct {
+	name   string
+	help   string
+	labels []string
+	m      sync.Mutex
+	values map[string]float64
+}
+
+type metricHistogram struct {
+	name    string
+	help    string
+	m       sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Public Methods
+
+// MetricsHandler returns an http.Handler serving the crypto metrics
+// in the Prometheus text exposition format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		for _, m := range metrics {
+			m.write(&buf)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+// Private Methods
+
+func newMetricCounter(name, help string) *metricCounter {
+	return &metricCounter{name: name, help: help}
+}
+
+func (c *metricCounter) inc() {
+	c.add(1)
+}
+
+func (c *metricCounter) add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+func (c *metricCounter) get() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *metricCounter) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	fmt.Fprintf(buf, "%s %d\n", c.name, c.get())
+}
+
+func newMetricGauge(name, help string, labels ...string) *metricGauge {
+	return &metricGauge{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// set sets the value of the gauge for the passed label values
+func (g *metricGauge) set(v float64, labelValues ...string) {
+	var key bytes.Buffer
+	for i, label := range g.labels {
+		if i > 0 {
+			key.WriteString(",")
+		}
+		fmt.Fprintf(&key, "%s=%q", label, labelValues[i])
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.values[key.String()] = v
+}
+
+func (g *metricGauge) write(buf *bytes.Buffer) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	keys := make([]string, 0, len(g.values))
+	for key := range g.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(buf, "%s %g\n", g.name, g.values[key])
+		} else {
+			fmt.Fprintf(buf, "%s{%s} %g\n", g.name, key, g.values[key])
+		}
+	}
+}
+
+func newMetricHistogram(name, help string, bounds []float64) *metricHistogram {
+	return &metricHistogram{name: name, help: help, bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *metricHistogram) observe(d time.Duration) {
+	v := d.Seconds()
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *metricHistogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+func (h *metricHistogram) write(buf *bytes.Buffer) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(buf, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(buf, "%s_sum %g\n", h.name, h.sum)
+	fmt.Fprintf(buf, "%s_count %d\n", h.name, h.count)
+}
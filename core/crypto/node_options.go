@@ -0,0 +1,203 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+)
+
+// Nodes initialized by InitClient, InitPeer and InitValidator are shared
+// by name through the whole process, and read the global viper
+// configuration. The nodes created by NewClient, NewPeer and NewValidator
+// are owned by their caller, who injects their configuration, logger and
+// storage, so that a process can host several identities of the same name
+// and tests can run isolated nodes.
+
+// Public Interfaces
+
+// Config is the source of the settings of a node. A *viper.Viper is a Config.
+type Config interface {
+	IsSet(key string) bool
+	GetString(key string) string
+	GetBool(key string) bool
+	GetInt(key string) int
+	GetFloat64(key string) float64
+	GetDuration(key string) time.Duration
+	GetStringMapString(key string) map[string]string
+	GetStringSlice(key string) []string
+}
+
+// Public Struct
+
+// Options are the dependencies of a node. Their zero value is the
+// dependencies of the nodes initialized by InitClient, InitPeer and
+// InitValidator.
+type Options struct {
+	// Config is read in place of the global viper configuration
+	Config Config
+
+	// Logger logs in place of the crypto logger
+	Logger *logging.Logger
+
+	// DataPath is where the node stores its data, in place of peer.fileSystemPath
+	DataPath string
+
+	// CertStore stores the enrollment certificates of a peer or validator
+	// in place of the backend configured. The node closes it when closed.
+	CertStore CertStore
+}
+
+// Public Methods
+
+// Register registers to the PKI infrastructure a node of type eType with the given options
+func Register(eType NodeType, name string, pwd []byte, enrollID, enrollPWD string, opts *Options) error {
+	var node interface {
+		close() error
+	}
+	var err error
+	switch eType {
+	case NodeClient:
+		client := newClient(opts)
+		node, err = client, client.register(name, pwd, enrollID, enrollPWD)
+	case NodePeer:
+		peer := newPeer(opts)
+		node, err = peer, peer.register(NodePeer, name, pwd, enrollID, enrollPWD)
+	case NodeValidator:
+		validator := newValidator(opts)
+		node, err = validator, validator.register(name, pwd, enrollID, enrollPWD)
+	default:
+		return utils.ErrInvalidNodeType
+	}
+	if closeErr := node.close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// NewClient initializes a client, named name with password pwd, with the
+// given options. Unlike InitClient, it is not shared: it must be closed with Close.
+func NewClient(name string, pwd []byte, opts *Options) (Client, error) {
+	client := newClient(opts)
+	if err := client.init(name, pwd); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// NewPeer initializes a peer, named name with password pwd, with the
+// given options. Unlike InitPeer, it is not shared: it must be closed with Close.
+func NewPeer(name string, pwd []byte, opts *Options) (Peer, error) {
+	peer := newPeer(opts)
+	if err := peer.init(NodePeer, name, pwd); err != nil {
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// NewValidator initializes a validator, named name with password pwd, with the
+// given options. Unlike InitValidator, it is not shared: it must be closed with Close.
+func NewValidator(name string, pwd []byte, opts *Options) (Peer, error) {
+	validator := newValidator(opts)
+	if err := validator.init(name, pwd); err != nil {
+		return nil, err
+	}
+
+	return validator, nil
+}
+
+// Close releases all the resources allocated by a node
+// created by NewClient, NewPeer or NewValidator
+func Close(node Node) error {
+	switch n := node.(type) {
+	case *clientImpl:
+		return n.close()
+	case *validatorImpl:
+		return n.close()
+	case *peerImpl:
+		return n.close()
+	}
+
+	return utils.ErrInvalidReference
+}
+
+// Private type and variables
+
+// globalConfig reads the global viper configuration
+type globalConfig struct{}
+
+func (globalConfig) IsSet(key string) bool {
+	return viper.IsSet(key)
+}
+
+func (globalConfig) GetString(key string) string {
+	return viper.GetString(key)
+}
+
+func (globalConfig) GetBool(key string) bool {
+	return viper.GetBool(key)
+}
+
+func (globalConfig) GetInt(key string) int {
+	return viper.GetInt(key)
+}
+
+func (globalConfig) GetFloat64(key string) float64 {
+	return viper.GetFloat64(key)
+}
+
+func (globalConfig) GetDuration(key string) time.Duration {
+	return viper.GetDuration(key)
+}
+
+func (globalConfig) GetStringMapString(key string) map[string]string {
+	return viper.GetStringMapString(key)
+}
+
+func (globalConfig) GetStringSlice(key string) []string {
+	return viper.GetStringSlice(key)
+}
+
+// Private Methods
+
+func newNode(opts *Options) *nodeImpl {
+	node := &nodeImpl{}
+	if opts != nil {
+		node.opts = *opts
+	}
+
+	return node
+}
+
+func newClient(opts *Options) *clientImpl {
+	return &clientImpl{newNode(opts), false, nil, nil, nil, nil, sync.RWMutex{}, nil, nil}
+}
+
+func newPeer(opts *Options) *peerImpl {
+	return &peerImpl{nodeImpl: newNode(opts)}
+}
+
+func newValidator(opts *Options) *validatorImpl {
+	return &validatorImpl{newPeer(opts), false, nil}
+}
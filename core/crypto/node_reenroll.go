@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// A node re-enrolls with the ECA when its enrollment certificate gets close
+// to expiry, authenticated by its current enrollment key and certificate,
+// or by the re-enrollment secret once the certificate has expired. The new
+// enrollment key and certificate are first journaled, in a single
+// transaction of the keystore DB, then stored in place of the old ones
+// before the journal is cleared. A node stopped in between completes the
+// swap the next time it is initialized. The unused TCerts of a client,
+// whose keys derive from the old enrollment key, are dropped with the swap.
+// A client may also rotate its enrollment key on demand, see node_rotate.go.
+
+// Private type and variables
+
+const (
+	reenrollmentNamespace = "reenrollment"
+)
+
+// Private Methods
+
+// startReenroller checks every interval whether the enrollment cert of
+// the node is due for renewal, and re-enrolls the node if so
+func (node *nodeImpl) startReenroller(interval time.Duration) {
+	node.Debugf("Checking enrollment certificate expiry every [%s].", interval)
+
+	node.reenrollStop = make(chan struct{})
+	node.reenrollDone = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !node.isReenrollmentDue() {
+					continue
+				}
+				if err := node.reenroll(); err != nil {
+					node.Errorf("Failed re-enrolling [%s]. Retrying in [%s].", err, interval)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(node.reenrollStop, node.reenrollDone)
+}
+
+// stopReenroller stops the re-enrollment checks, waiting for
+// a re-enrollment in progress to complete
+func (node *nodeImpl) stopReenroller() {
+	if node.reenrollStop == nil {
+		return
+	}
+
+	close(node.reenrollStop)
+	<-node.reenrollDone
+	node.reenrollStop = nil
+}
+
+// isReenrollmentDue returns true if the enrollment cert
+// expires within the re-enrollment window
+func (node *nodeImpl) isReenrollmentDue() bool {
+	node.enrollMutex.RLock()
+	defer node.enrollMutex.RUnlock()
+
+	return !time.Now().Add(node.conf.getReenrollmentWindow()).Before(node.enrollCert.NotAfter)
+}
+
+func (node *nodeImpl) reenroll() error {
+	node.Infof("Re-enrolling [%s], enrollment certificate expiring [%s]...", node.enrollID, node.enrollCert.NotAfter)
+
+	enrollCert, err := node.renewEnrollment(nil)
+	if err != nil {
+		return err
+	}
+
+	reenrollments.inc()
+	node.Infof("Re-enrolling [%s]...done! Enrollment certificate expiring [%s].", node.enrollID, enrollCert.NotAfter)
+
+	return nil
+}
+
+// renewEnrollment gets a new enrollment key and cert from the ECA and swaps
+// them with the ones in use. The blobs of retired, if any, are stored in the
+// retired enrollment namespace along with the journal.
+func (node *nodeImpl) renewEnrollment(retired map[string][]byte) (*x509.Certificate, error) {
+	node.reenrollMutex.Lock()
+	defer node.reenrollMutex.Unlock()
+
+	// Authenticate with the current enrollment cert while it is valid,
+	// with the re-enrollment secret afterwards
+	node.enrollMutex.RLock()
+	expired := !time.Now().Before(node.enrollCert.NotAfter)
+	node.enrollMutex.RUnlock()
+
+	var key interface{}
+	var enrollCertRaw []byte
+	var err error
+	if expired {
+		key, enrollCertRaw, _, err = node.getEnrollmentCertificateFromECA(node.enrollID, node.conf.getReenrollmentSecret())
+	} else {
+		key, enrollCertRaw, _, err = node.getRenewedEnrollmentCertificateFromECA()
+	}
+	if err != nil {
+		node.Errorf("Failed getting enrollment certificate [id=%s]: [%s]", node.enrollID, err)
+		return nil, err
+	}
+	enrollCert, err := primitives.DERToX509Certificate(enrollCertRaw)
+	if err != nil {
+		node.Errorf("Failed parsing enrollment certificate [%s].", err.Error())
+		return nil, err
+	}
+
+	// Journal the new identity, the commit point of the swap
+	journal := map[string][]byte{"cert": enrollCertRaw}
+	if heldKey, ok := key.(deviceKey); ok {
+		journal["handle"] = []byte(heldKey.handle())
+	} else {
+		journal["key"], err = primitives.PrivateKeyToPEM(key, node.ks.pwd)
+		if err != nil {
+			node.Errorf("Failed converting enrollment key to PEM [%s].", err.Error())
+			return nil, err
+		}
+	}
+	err = node.ks.WithTx(func(tx CertTx) error {
+		for name, value := range journal {
+			if err := tx.PutBlob(reenrollmentNamespace, name, value); err != nil {
+				return err
+			}
+		}
+		for name, value := range retired {
+			if err := tx.PutBlob(retiredEnrollmentNamespace, name, value); err != nil {
+				return err
+			}
+		}
+		if node.eType == NodeClient {
+			return tx.DeleteUnusedTCerts()
+		}
+
+		return nil
+	})
+	if err != nil {
+		node.Errorf("Failed journaling re-enrollment [%s].", err.Error())
+		return nil, err
+	}
+
+	if err := node.completeReenrollment(); err != nil {
+		return nil, err
+	}
+
+	// Swap the identity in use
+	node.enrollMutex.Lock()
+	oldTPMKey := node.enrollTPMKey
+	switch sk := key.(type) {
+	case *hsmKey:
+		node.enrollHSMKey = sk
+	case *tpmKey:
+		node.enrollTPMKey = sk
+	default:
+		node.setEnrollmentKey(key)
+	}
+	node.enrollCert = enrollCert
+	node.id = primitives.Hash(enrollCertRaw)
+	node.enrollCertHash = node.id
+	node.enrollMutex.Unlock()
+
+	// Unload the old key from the TPM
+	if oldTPMKey != nil && oldTPMKey != node.enrollTPMKey {
+		if err := oldTPMKey.flush(); err != nil {
+			node.Warningf("Failed unloading old enrollment key from the TPM [%s].", err.Error())
+		}
+	}
+
+	return enrollCert, nil
+}
+
+// completeReenrollment stores the journaled enrollment key and cert,
+// if any, in place of the old ones, then clears the journal
+func (node *nodeImpl) completeReenrollment() error {
+	enrollCertRaw, err := node.ks.GetBlob(reenrollmentNamespace, "cert")
+	if err != nil || enrollCertRaw == nil {
+		return err
+	}
+
+	node.Debug("Completing re-enrollment...")
+
+	if handle, err := node.ks.GetBlob(reenrollmentNamespace, "handle"); err != nil {
+		return err
+	} else if handle != nil {
+		err = node.ks.storeKeyHandle(node.conf.getEnrollmentKeyFilename(), string(handle))
+	} else {
+		var pem []byte
+		if pem, err = node.ks.GetBlob(reenrollmentNamespace, "key"); err != nil {
+			return err
+		}
+		err = node.ks.storeKeyMaterial(node.conf.getEnrollmentKeyFilename(), pem)
+	}
+	if err != nil {
+		node.Errorf("Failed storing enrollment key [%s].", err.Error())
+		return err
+	}
+
+	if err := node.ks.storeCert(node.conf.getEnrollmentCertFilename(), enrollCertRaw); err != nil {
+		return err
+	}
+
+	if err := node.ks.PutBlobs(reenrollmentNamespace, map[string][]byte{"cert": nil, "key": nil, "handle": nil}); err != nil {
+		node.Errorf("Failed clearing re-enrollment journal [%s].", err.Error())
+		return err
+	}
+
+	node.Debug("Completing re-enrollment...done!")
+
+	return nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// A client rotates its enrollment key on demand, before its enrollment
+// cert expires, by re-enrolling with the ECA, which publishes the new cert
+// for the same identity. The old enrollment key is retired only after the
+// overlap window, so that the TCerts derived from it, still carried by
+// pending transactions, can be handled meanwhile. The retired key is kept
+// in the keystore, journaled along with the new identity, and survives a
+// restart of the node within the window.
+
+// Private type and variables
+
+const (
+	retiredEnrollmentNamespace = "retiredEnrollment"
+)
+
+// Private Methods
+
+// rotateEnrollmentKey replaces the enrollment key and cert of the node with
+// new ones from the ECA, and retires the old key after the overlap window
+func (node *nodeImpl) rotateEnrollmentKey() error {
+	if node.conf.isKeyStoreReadOnly() {
+		return utils.ErrKeyStoreReadOnly
+	}
+
+	node.enrollMutex.RLock()
+	oldKey := node.enrollPrivKey
+	oldID := node.id
+	node.enrollMutex.RUnlock()
+
+	node.Infof("Rotating enrollment key of [%s]...", node.enrollID)
+
+	// Only software ecdsa keys derive TCerts
+	overlap := node.conf.getReenrollmentOverlap()
+	until := time.Now().Add(overlap)
+	var retired map[string][]byte
+	if oldKey != nil && overlap > 0 {
+		pem, err := primitives.PrivateKeyToPEM(oldKey, node.ks.pwd)
+		if err != nil {
+			node.Errorf("Failed converting enrollment key to PEM [%s].", err.Error())
+			return err
+		}
+		retired = map[string][]byte{
+			"key":   pem,
+			"until": []byte(strconv.FormatInt(until.UnixNano(), 10)),
+		}
+	}
+
+	enrollCert, err := node.renewEnrollment(retired)
+	if err != nil {
+		return err
+	}
+
+	if retired != nil {
+		node.scheduleRetirement(oldKey, until)
+	}
+
+	node.enrollMutex.RLock()
+	newID := node.id
+	node.enrollMutex.RUnlock()
+	node.ks.audit(AuditKeyRotation, utils.EncodeBase64(oldID), utils.EncodeBase64(newID))
+
+	node.Infof("Rotating enrollment key of [%s]...done! Old key retired at [%s], enrollment certificate expiring [%s].", node.enrollID, until, enrollCert.NotAfter)
+
+	return nil
+}
+
+// initRetiredEnrollmentKey reloads the enrollment key retired by a rotation,
+// if its overlap window is not over yet
+func (node *nodeImpl) initRetiredEnrollmentKey() error {
+	if node.conf.isKeyStoreReadOnly() {
+		return nil
+	}
+
+	raw, err := node.ks.GetBlob(retiredEnrollmentNamespace, "until")
+	if err != nil || raw == nil {
+		return err
+	}
+	nanos, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		node.Warningf("Invalid retirement time [%s]. Retiring now.", err.Error())
+		return node.retireEnrollmentKey()
+	}
+	until := time.Unix(0, nanos)
+	if !time.Now().Before(until) {
+		return node.retireEnrollmentKey()
+	}
+
+	pem, err := node.ks.GetBlob(retiredEnrollmentNamespace, "key")
+	if err != nil {
+		return err
+	}
+	key, err := primitives.PEMtoPrivateKey(pem, node.ks.pwd)
+	if err != nil {
+		node.Errorf("Failed parsing retired enrollment key [%s].", err.Error())
+		return err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return utils.ErrInvalidKey
+	}
+
+	node.scheduleRetirement(ecdsaKey, until)
+
+	return nil
+}
+
+// scheduleRetirement keeps key as the retired enrollment key until until
+func (node *nodeImpl) scheduleRetirement(key *ecdsa.PrivateKey, until time.Time) {
+	node.Debugf("Retiring enrollment key at [%s].", until)
+
+	node.enrollMutex.Lock()
+	defer node.enrollMutex.Unlock()
+
+	if node.retireTimer != nil {
+		node.retireTimer.Stop()
+	}
+	if node.retiredEnrollPrivKey != key {
+		utils.ZeroizeKey(node.retiredEnrollPrivKey)
+	}
+	node.retiredEnrollPrivKey = key
+	node.retireTimer = time.AfterFunc(until.Sub(time.Now()), func() {
+		if err := node.retireEnrollmentKey(); err != nil {
+			node.Errorf("Failed retiring enrollment key [%s].", err.Error())
+		}
+	})
+}
+
+// retireEnrollmentKey drops the enrollment key retired by a rotation
+func (node *nodeImpl) retireEnrollmentKey() error {
+	node.Debug("Retiring enrollment key...")
+
+	node.enrollMutex.Lock()
+	utils.ZeroizeKey(node.retiredEnrollPrivKey)
+	node.retiredEnrollPrivKey = nil
+	node.enrollMutex.Unlock()
+
+	if err := node.ks.PutBlobs(retiredEnrollmentNamespace, map[string][]byte{"key": nil, "until": nil}); err != nil {
+		node.Errorf("Failed removing retired enrollment key [%s].", err.Error())
+		return err
+	}
+
+	node.Debug("Retiring enrollment key...done!")
+
+	return nil
+}
+
+// stopRetireTimer stops the pending retirement, if any. The retired key
+// stays in the keystore until the node is initialized again.
+func (node *nodeImpl) stopRetireTimer() {
+	node.enrollMutex.Lock()
+	defer node.enrollMutex.Unlock()
+
+	if node.retireTimer != nil {
+		node.retireTimer.Stop()
+		node.retireTimer = nil
+	}
+}
@@ -17,27 +17,122 @@ limitations under the License.
 package crypto
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"math/big"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 )
 
 func (node *nodeImpl) sign(signKey interface{}, msg []byte) ([]byte, error) {
-	return primitives.ECDSASign(signKey, msg)
+	return node.csp.Sign(signKey, msg)
 }
 
 func (node *nodeImpl) signWithEnrollmentKey(msg []byte) ([]byte, error) {
-	return primitives.ECDSASign(node.enrollPrivKey, msg)
+	node.enrollMutex.RLock()
+	defer node.enrollMutex.RUnlock()
+
+	node.logSignature(node.getEnrollmentKeyLabel(), msg)
+
+	return node.csp.Sign(node.getEnrollmentKey(), msg)
 }
 
 func (node *nodeImpl) ecdsaSignWithEnrollmentKey(msg []byte) (*big.Int, *big.Int, error) {
-	return primitives.ECDSASignDirect(node.enrollPrivKey, msg)
+	node.enrollMutex.RLock()
+	defer node.enrollMutex.RUnlock()
+
+	node.logSignature(node.getEnrollmentKeyLabel(), msg)
+
+	return node.csp.SignDigest(node.getEnrollmentKey(), primitives.Hash(msg))
 }
 
 func (node *nodeImpl) verify(verKey interface{}, msg, signature []byte) (bool, error) {
-	return primitives.ECDSAVerify(verKey, msg, signature)
+	return node.csp.Verify(verKey, msg, signature)
 }
 
 func (node *nodeImpl) verifyWithEnrollmentCert(msg, signature []byte) (bool, error) {
-	return primitives.ECDSAVerify(node.enrollCert.PublicKey, msg, signature)
+	node.enrollMutex.RLock()
+	defer node.enrollMutex.RUnlock()
+
+	return node.csp.Verify(node.enrollCert.PublicKey, msg, signature)
+}
+
+// getEnrollmentKey returns the enrollment key held by the token
+// in HSM mode, the software enrollment key otherwise.
+// It must be called holding enrollMutex.
+func (node *nodeImpl) getEnrollmentKey() interface{} {
+	if node.enrollHSMKey != nil {
+		return node.enrollHSMKey
+	}
+	if node.enrollTPMKey != nil {
+		return node.enrollTPMKey
+	}
+	if node.enrollAltKey != nil {
+		return node.enrollAltKey
+	}
+
+	return node.enrollPrivKey
+}
+
+// getEnrollmentKeyLabel identifies the enrollment key in the signature
+// log by the hash of the enrollment certificate.
+// It must be called holding enrollMutex.
+func (node *nodeImpl) getEnrollmentKeyLabel() string {
+	if node.enrollCert == nil {
+		return "enrollment"
+	}
+
+	return "enrollment/" + utils.EncodeBase64(primitives.Hash(node.enrollCert.Raw))
+}
+
+// setEnrollmentKey sets the software enrollment key, of either
+// signature scheme. It must be called holding enrollMutex.
+func (node *nodeImpl) setEnrollmentKey(key interface{}) error {
+	switch sk := key.(type) {
+	case *ecdsa.PrivateKey:
+		node.enrollPrivKey, node.enrollAltKey = sk, nil
+	case ed25519.PrivateKey, *rsa.PrivateKey:
+		node.enrollPrivKey, node.enrollAltKey = nil, sk
+	default:
+		return utils.ErrInvalidKey
+	}
+
+	return nil
+}
+
+// signRequest signs raw, a marshalled request to the membership services,
+// with signKey. ECDSA keys sign the hash of raw, and the signature is
+// carried as (R, S). Ed25519 and RSA keys sign raw as their schemes
+// prescribe, and the signature is carried in R.
+func (node *nodeImpl) signRequest(signKey interface{}, raw []byte) (*membersrvc.Signature, error) {
+	// The key is being enrolled, it has no certificate yet
+	node.logSignature("enrollment", raw)
+
+	var sigType membersrvc.CryptoType
+	switch signKey.(type) {
+	case ed25519.PrivateKey:
+		sigType = membersrvc.CryptoType_ED25519
+	case *rsa.PrivateKey:
+		sigType = membersrvc.CryptoType_RSA
+	}
+	if sigType != membersrvc.CryptoType_ECDSA {
+		sig, err := node.csp.Sign(signKey, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		return &membersrvc.Signature{Type: sigType, R: sig}, nil
+	}
+
+	r, s, err := node.csp.SignDigest(signKey, primitives.Hash(raw))
+	if err != nil {
+		return nil, err
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+
+	return &membersrvc.Signature{Type: membersrvc.CryptoType_ECDSA, R: R, S: S}, nil
 }
@@ -21,34 +21,32 @@ import (
 
 	"errors"
 
-	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
 func (node *nodeImpl) retrieveTCACertsChain(userID string) error {
-	// Retrieve TCA certificate and verify it
-	tcaCertRaw, err := node.getTCACertificate()
+	// Retrieve TCA certificates chain and verify it
+	tcaCertsChain, err := node.getTCACertificateChain()
 	if err != nil {
-		node.Errorf("Failed getting TCA certificate [%s].", err.Error())
+		node.Errorf("Failed getting TCA certificates chain [%s].", err.Error())
 
 		return err
 	}
-	node.Debugf("TCA certificate [% x]", tcaCertRaw)
+	node.Debugf("TCA certificates chain of length [%d]", len(tcaCertsChain))
 
-	// TODO: Test TCA cert againt root CA
-	_, err = primitives.DERToX509Certificate(tcaCertRaw)
+	pem, err := node.verifyCACertsChain(tcaCertsChain)
 	if err != nil {
-		node.Errorf("Failed parsing TCA certificate [%s].", err.Error())
+		node.Errorf("Failed verifying TCA certificates chain [%s].", err.Error())
 
 		return err
 	}
 
-	// Store TCA cert
-	node.Debugf("Storing TCA certificate for [%s]...", userID)
+	// Store TCA certs chain
+	node.Debugf("Storing TCA certificates chain for [%s]...", userID)
 
-	if err := node.ks.storeCert(node.conf.getTCACertsChainFilename(), tcaCertRaw); err != nil {
-		node.Errorf("Failed storing tca certificate [%s].", err.Error())
+	if err := node.ks.storeCertPEM(node.conf.getTCACertsChainFilename(), pem); err != nil {
+		node.Errorf("Failed storing tca certificates chain [%s].", err.Error())
 		return err
 	}
 
@@ -92,31 +90,29 @@ func (node *nodeImpl) getTCAClient() (*grpc.ClientConn, membersrvc.TCAPClient, e
 	return conn, client, nil
 }
 
-func (node *nodeImpl) callTCAReadCACertificate(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.Cert, error) {
+func (node *nodeImpl) callTCAReadCACertificateChain(ctx context.Context, opts ...grpc.CallOption) (*membersrvc.CertChain, error) {
 	// Get a TCA Client
 	sock, tcaP, err := node.getTCAClient()
 	defer sock.Close()
 
 	// Issue the request
-	cert, err := tcaP.ReadCACertificate(ctx, &membersrvc.Empty{}, opts...)
+	chain, err := tcaP.ReadCACertificateChain(ctx, &membersrvc.Empty{}, opts...)
 	if err != nil {
-		node.Errorf("Failed requesting tca read certificate [%s].", err.Error())
+		node.Errorf("Failed requesting tca read certificates chain [%s].", err.Error())
 
 		return nil, err
 	}
 
-	return cert, nil
+	return chain, nil
 }
 
-func (node *nodeImpl) getTCACertificate() ([]byte, error) {
-	response, err := node.callTCAReadCACertificate(context.Background())
+func (node *nodeImpl) getTCACertificateChain() ([][]byte, error) {
+	response, err := node.callTCAReadCACertificateChain(context.Background())
 	if err != nil {
-		node.Errorf("Failed requesting TCA certificate [%s].", err.Error())
+		node.Errorf("Failed requesting TCA certificates chain [%s].", err.Error())
 
 		return nil, err
 	}
 
-	// TODO: check response.Cert against rootCA
-
-	return response.Cert, nil
+	return response.Certs, nil
 }
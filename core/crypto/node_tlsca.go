@@ -20,7 +20,7 @@ import (
 	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
 
 	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"google/protobuf"
@@ -62,17 +62,36 @@ func (node *nodeImpl) retrieveTLSCertificate(id, affiliation string) error {
 func (node *nodeImpl) loadTLSCertificate() error {
 	node.Debug("Loading tls certificate...")
 
-	cert, _, err := node.ks.loadCertX509AndDer(node.conf.getTLSCertFilename())
+	cert, der, err := node.ks.loadCertX509AndDer(node.conf.getTLSCertFilename())
 	if err != nil {
 		node.Errorf("Failed parsing tls certificate [%s].", err.Error())
 
 		return err
 	}
-	node.tlsCert = cert
+	key, err := node.ks.loadPrivateKey(node.conf.getTLSKeyFilename())
+	if err != nil {
+		node.Errorf("Failed loading tls key [%s].", err.Error())
+
+		return err
+	}
+	node.setTLSCertificate(cert, der, key)
 
 	return nil
 }
 
+// setTLSCertificate swaps the TLS cert and key in use
+func (node *nodeImpl) setTLSCertificate(cert *x509.Certificate, der []byte, key interface{}) {
+	node.tlsMutex.Lock()
+	defer node.tlsMutex.Unlock()
+
+	node.tlsCert = cert
+	node.tlsKeyPair = &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+}
+
 func (node *nodeImpl) loadTLSCACertsChain() error {
 	if node.conf.isTLSEnabled() {
 		node.Debug("Loading TLSCA certificates chain...")
@@ -124,9 +143,12 @@ func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interf
 		Pub: &membersrvc.PublicKey{
 			Type: membersrvc.CryptoType_ECDSA,
 			Key:  pubraw,
-		}, Sig: nil}
+		},
+		DnsNames:    node.conf.getTLSDNSNames(),
+		IpAddresses: node.conf.getTLSIPAddresses(),
+		Sig:         nil}
 	rawreq, _ := proto.Marshal(req)
-	r, s, err := ecdsa.Sign(rand.Reader, priv, primitives.Hash(rawreq))
+	r, s, err := ecdsa.Sign(primitives.RandReader, priv, primitives.Hash(rawreq))
 	if err != nil {
 		panic(err)
 	}
@@ -144,8 +166,16 @@ func (node *nodeImpl) getTLSCertificateFromTLSCA(id, affiliation string) (interf
 	node.Debug("Verifing tls certificate...")
 
 	tlsCert, err := primitives.DERToX509Certificate(pbCert.Cert.Cert)
-	certPK := tlsCert.PublicKey.(*ecdsa.PublicKey)
-	primitives.VerifySignCapability(priv, certPK)
+	if err != nil {
+		node.Errorf("Failed parsing tls certificate [%s].", err.Error())
+
+		return nil, nil, err
+	}
+	if err := primitives.VerifySignCapability(priv, tlsCert.PublicKey); err != nil {
+		node.Errorf("Failed verifying tls certificate against its key [%s].", err.Error())
+
+		return nil, nil, err
+	}
 
 	node.Debug("Verifing tls certificate...done!")
 
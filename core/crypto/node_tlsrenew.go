@@ -0,0 +1,182 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// A node renews its TLS certificate with the TLSCA when it gets close to
+// expiry. As for a re-enrollment, the new key and certificate are journaled
+// first, then stored in place of the old ones before the journal is cleared,
+// and a node stopped in between completes the swap when next initialized.
+// The certificate in use is served by GetTLSCertificate, which the gRPC
+// listeners query at each handshake: new connections get the new
+// certificate, while the established ones are left untouched.
+
+// Private type and variables
+
+const (
+	tlsRenewalNamespace = "tlsrenewal"
+)
+
+var (
+	errTLSCertificateMissing = errors.New("TLS certificate not loaded.")
+)
+
+// Public Methods
+
+// GetTLSCertificate returns the TLS certificate in use by the node, and its
+// key. Its signature is the one of tls.Config.GetCertificate, so that
+// listeners pick up the renewed certificates.
+func (node *nodeImpl) GetTLSCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	node.tlsMutex.RLock()
+	defer node.tlsMutex.RUnlock()
+
+	if node.tlsKeyPair == nil {
+		return nil, errTLSCertificateMissing
+	}
+
+	return node.tlsKeyPair, nil
+}
+
+// Private Methods
+
+// startTLSRenewer checks every interval whether the TLS cert of
+// the node is due for renewal, and renews it if so
+func (node *nodeImpl) startTLSRenewer(interval time.Duration) {
+	node.Debugf("Checking TLS certificate expiry every [%s].", interval)
+
+	node.tlsRenewStop = make(chan struct{})
+	node.tlsRenewDone = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !node.isTLSRenewalDue() {
+					continue
+				}
+				if err := node.renewTLSCertificate(); err != nil {
+					node.Errorf("Failed renewing TLS certificate [%s]. Retrying in [%s].", err, interval)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(node.tlsRenewStop, node.tlsRenewDone)
+}
+
+// stopTLSRenewer stops the renewal checks, waiting for
+// a renewal in progress to complete
+func (node *nodeImpl) stopTLSRenewer() {
+	if node.tlsRenewStop == nil {
+		return
+	}
+
+	close(node.tlsRenewStop)
+	<-node.tlsRenewDone
+	node.tlsRenewStop = nil
+}
+
+// isTLSRenewalDue returns true if the TLS cert
+// expires within the renewal window
+func (node *nodeImpl) isTLSRenewalDue() bool {
+	node.tlsMutex.RLock()
+	defer node.tlsMutex.RUnlock()
+
+	return !time.Now().Add(node.conf.getTLSRenewalWindow()).Before(node.tlsCert.NotAfter)
+}
+
+// renewTLSCertificate gets a new TLS key and cert from the TLSCA
+// and swaps them with the ones in use
+func (node *nodeImpl) renewTLSCertificate() error {
+	node.Infof("Renewing TLS certificate of [%s], expiring [%s]...", node.enrollID, node.tlsCert.NotAfter)
+
+	key, tlsCertRaw, err := node.getTLSCertificateFromTLSCA(node.enrollID, "")
+	if err != nil {
+		node.Errorf("Failed getting tls certificate [id=%s] %s", node.enrollID, err)
+		return err
+	}
+	tlsCert, err := primitives.DERToX509Certificate(tlsCertRaw)
+	if err != nil {
+		node.Errorf("Failed parsing tls certificate [%s].", err.Error())
+		return err
+	}
+	pem, err := primitives.PrivateKeyToPEM(key, nil)
+	if err != nil {
+		node.Errorf("Failed converting tls key to PEM [%s].", err.Error())
+		return err
+	}
+
+	// Journal the new key and cert, the commit point of the swap
+	if err := node.ks.PutBlobs(tlsRenewalNamespace, map[string][]byte{"key": pem, "cert": tlsCertRaw}); err != nil {
+		node.Errorf("Failed journaling TLS certificate renewal [%s].", err.Error())
+		return err
+	}
+	if err := node.completeTLSRenewal(); err != nil {
+		return err
+	}
+
+	node.setTLSCertificate(tlsCert, tlsCertRaw, key)
+	tlsRenewals.inc()
+
+	node.Infof("Renewing TLS certificate of [%s]...done! Expiring [%s].", node.enrollID, tlsCert.NotAfter)
+
+	return nil
+}
+
+// completeTLSRenewal stores the journaled TLS key and cert,
+// if any, in place of the old ones, then clears the journal
+func (node *nodeImpl) completeTLSRenewal() error {
+	tlsCertRaw, err := node.ks.GetBlob(tlsRenewalNamespace, "cert")
+	if err != nil || tlsCertRaw == nil {
+		return err
+	}
+
+	node.Debug("Completing TLS certificate renewal...")
+
+	pem, err := node.ks.GetBlob(tlsRenewalNamespace, "key")
+	if err != nil {
+		return err
+	}
+	if err := node.ks.storeKeyMaterial(node.conf.getTLSKeyFilename(), pem); err != nil {
+		node.Errorf("Failed storing tls key [%s].", err.Error())
+		return err
+	}
+	if err := node.ks.storeCert(node.conf.getTLSCertFilename(), tlsCertRaw); err != nil {
+		node.Errorf("Failed storing tls certificate [%s].", err.Error())
+		return err
+	}
+
+	if err := node.ks.PutBlobs(tlsRenewalNamespace, map[string][]byte{"cert": nil, "key": nil}); err != nil {
+		node.Errorf("Failed clearing TLS certificate renewal journal [%s].", err.Error())
+		return err
+	}
+
+	node.Debug("Completing TLS certificate renewal...done!")
+
+	return nil
+}
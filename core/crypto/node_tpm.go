@@ -0,0 +1,205 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/tpm"
+)
+
+// In TPM mode, the enrollment key of peers and validators is created by a
+// TPM 2.0 device, under its primary storage key, and cannot leave it in
+// clear. The keystore records the key sealed by the TPM, its private area
+// encrypted under the primary key, hex encoded, as its handle: it is of no
+// use outside the TPM. The key is loaded into the TPM when the node is
+// initialized, and every signature with it is computed by the TPM. As in
+// HSM mode, clients are not supported.
+
+// tpmDevice is an open TPM and its primary storage key
+type tpmDevice struct {
+	tpm    *tpm.TPM
+	parent tpm.Handle
+}
+
+// tpmCSP is the provider of a node in TPM mode: it generates and uses
+// the signing keys inside the TPM, and delegates the other operations
+// to the configured provider
+type tpmCSP struct {
+	CSP
+
+	dev *tpmDevice
+}
+
+// tpmKey is an ECDSA key pair sealed to, and loaded in, the TPM
+type tpmKey struct {
+	dev    *tpmDevice
+	sealed *tpm.SealedKey
+	loaded tpm.Handle
+	pub    *ecdsa.PublicKey
+}
+
+func (node *nodeImpl) initTPM() error {
+	if !node.conf.isTPMEnabled() || node.tpm != nil {
+		return nil
+	}
+
+	if node.eType == NodeClient {
+		return errors.New("TPM mode is not supported for clients.")
+	}
+	if node.conf.isHSMEnabled() {
+		return errors.New("TPM mode and HSM mode are exclusive.")
+	}
+	if node.conf.getSignatureScheme() != signatureSchemeECDSA {
+		return fmt.Errorf("TPM mode is not supported for signature scheme [%s].", node.conf.getSignatureScheme())
+	}
+
+	node.Debugf("Opening TPM [%s]...", node.conf.getTPMDevice())
+	dev, err := openTPM(node.conf.getTPMDevice())
+	if err != nil {
+		node.Errorf("Failed opening TPM [%s].", err.Error())
+
+		return err
+	}
+	node.tpm = dev
+	node.csp = &tpmCSP{CSP: node.csp, dev: dev}
+
+	return nil
+}
+
+func openTPM(path string) (*tpmDevice, error) {
+	t, err := tpm.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := t.CreatePrimary()
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	return &tpmDevice{tpm: t, parent: parent}, nil
+}
+
+// close closes the TPM. The resource manager flushes the keys loaded.
+func (dev *tpmDevice) close() error {
+	return dev.tpm.Close()
+}
+
+// generateKey creates a new ECDSA key pair, on the default curve,
+// inside the TPM and loads it
+func (dev *tpmDevice) generateKey() (*tpmKey, error) {
+	sealed, pub, err := dev.tpm.CreateKey(dev.parent, primitives.GetDefaultCurve())
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := dev.tpm.Load(dev.parent, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tpmKey{dev: dev, sealed: sealed, loaded: loaded, pub: pub}, nil
+}
+
+// loadKey loads the key pair whose handle, as returned by tpmKey.handle,
+// is passed
+func (dev *tpmDevice) loadKey(handle string) (*tpmKey, error) {
+	raw, err := hex.DecodeString(handle)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := tpm.ParseSealedKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := sealed.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := dev.tpm.Load(dev.parent, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tpmKey{dev: dev, sealed: sealed, loaded: loaded, pub: pub}, nil
+}
+
+// handle returns the handle of the key to be recorded in the keystore
+func (key *tpmKey) handle() string {
+	raw, err := key.sealed.Marshal()
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(raw)
+}
+
+// flush unloads the key from the TPM
+func (key *tpmKey) flush() error {
+	return key.dev.tpm.Flush(key.loaded)
+}
+
+// signDigest signs digest and returns the signature as a pair of integers
+func (key *tpmKey) signDigest(digest []byte) (*big.Int, *big.Int, error) {
+	return key.dev.tpm.Sign(key.loaded, digest)
+}
+
+// sign hashes and signs msg, like primitives.ECDSASign
+func (key *tpmKey) sign(msg []byte) ([]byte, error) {
+	r, s, err := key.signDigest(primitives.Hash(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(primitives.ECDSASignature{R: r, S: s})
+}
+
+// GenerateKey generates a new signing key pair inside the TPM
+func (csp *tpmCSP) GenerateKey() (interface{}, interface{}, error) {
+	key, err := csp.dev.generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, key.pub, nil
+}
+
+// Sign signs with signKey inside the TPM, if held by it
+func (csp *tpmCSP) Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	if key, ok := signKey.(*tpmKey); ok {
+		return key.sign(msg)
+	}
+
+	return csp.CSP.Sign(signKey, msg)
+}
+
+// SignDigest signs with signKey inside the TPM, if held by it
+func (csp *tpmCSP) SignDigest(signKey interface{}, digest []byte) (*big.Int, *big.Int, error) {
+	if key, ok := signKey.(*tpmKey); ok {
+		return key.signDigest(digest)
+	}
+
+	return csp.CSP.SignDigest(signKey, digest)
+}
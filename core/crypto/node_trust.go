@@ -0,0 +1,117 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// A node trusts the certificates issued by the ECA and the TCA it registered
+// with. In a consortium network, where each organization runs its own CA, it
+// also trusts the ECA and TCA roots of the other organizations listed, by
+// organization, under peer.pki.trusted.eca and peer.pki.trusted.tca. A
+// certificate that does not chain to the CAs of the node is verified against
+// the roots of the CA that issued it, looked up by its issuer, instead of
+// against the roots of every organization.
+
+const (
+	trustedECA = "eca"
+	trustedTCA = "tca"
+)
+
+// trustedCA is the ECA or the TCA of a trusted organization
+type trustedCA struct {
+	org   string
+	kind  string
+	roots *x509.CertPool
+}
+
+// trustedCAs are the CAs of the trusted organizations, keyed by the
+// subjects of their certificates
+type trustedCAs map[string][]*trustedCA
+
+// add trusts the CA of kind of org, whose certificates chain, from the CA
+// certificate up to the root, is certs
+func (cas trustedCAs) add(org, kind string, certs []*x509.Certificate) {
+	ca := &trustedCA{org: org, kind: kind, roots: x509.NewCertPool()}
+	for _, cert := range certs {
+		ca.roots.AddCert(cert)
+		cas[string(cert.RawSubject)] = append(cas[string(cert.RawSubject)], ca)
+	}
+}
+
+// verify checks that cert chains to the trusted CA, of kind, that issued it,
+// and returns the organization of that CA
+func (cas trustedCAs) verify(cert *x509.Certificate, kind string) (string, error) {
+	for _, ca := range cas[string(cert.RawIssuer)] {
+		if ca.kind != kind {
+			continue
+		}
+		if _, err := primitives.CheckCertAgainRoot(cert, ca.roots); err == nil {
+			return ca.org, nil
+		}
+	}
+
+	return "", utils.ErrInvalidCertificateChain
+}
+
+// Private Methods
+
+// loadTrustedCAs loads the ECA and TCA roots of the trusted organizations
+func (node *nodeImpl) loadTrustedCAs() error {
+	node.trustedCAs = make(trustedCAs)
+
+	for kind, files := range map[string]map[string]string{
+		trustedECA: node.conf.getTrustedECARootsPaths(),
+		trustedTCA: node.conf.getTrustedTCARootsPaths(),
+	} {
+		for org, path := range files {
+			raw, err := node.ks.loadExternalCert(path)
+			if err != nil {
+				return err
+			}
+			certs, err := pemToCertificates(raw)
+			if err != nil {
+				return err
+			}
+			if len(certs) == 0 {
+				return fmt.Errorf("No %s root certificate of organization [%s] found in [%s].", kind, org, path)
+			}
+			node.trustedCAs.add(org, kind, certs)
+
+			node.Debugf("Trusting the %s of organization [%s].", kind, org)
+		}
+	}
+
+	return nil
+}
+
+// verifyTrustedCert checks that cert is issued by the CA, of kind, of a
+// trusted organization
+func (node *nodeImpl) verifyTrustedCert(cert *x509.Certificate, kind string) error {
+	org, err := node.trustedCAs.verify(cert, kind)
+	if err != nil {
+		return err
+	}
+	node.Debugf("Certificate [%s] issued by the %s of organization [%s].", cert.Subject.CommonName, kind, org)
+
+	return nil
+}
@@ -52,7 +52,7 @@ func RegisterPeer(name string, pwd []byte, enrollID, enrollPWD string) error {
 		return nil
 	}
 
-	peer := newPeer()
+	peer := newPeer(nil)
 	if err := peer.register(NodePeer, name, pwd, enrollID, enrollPWD); err != nil {
 		if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
 			log.Errorf("Failed registering peer [%s] with id [%s] [%s].", enrollID, name, err)
@@ -86,7 +86,7 @@ func InitPeer(name string, pwd []byte) (Peer, error) {
 		return peers[name].peer, nil
 	}
 
-	peer := newPeer()
+	peer := newPeer(nil)
 	if err := peer.init(NodePeer, name, pwd); err != nil {
 		log.Errorf("Failed peer initialization [%s]: [%s]", name, err)
 
@@ -107,31 +107,29 @@ func ClosePeer(peer Peer) error {
 	return closePeerInternal(peer, false)
 }
 
-// CloseAllPeers closes all the peers initialized so far
+// CloseAllPeers closes all the peers initialized so far. It returns true if
+// all were closed, and the errors of the ones failing to close otherwise.
 func CloseAllPeers() (bool, []error) {
 	peerMutex.Lock()
 	defer peerMutex.Unlock()
 
 	log.Info("Closing all peers...")
 
-	errs := make([]error, len(peers))
+	errs := []error{}
 	for _, value := range peers {
 		err := closePeerInternal(value.peer, true)
-
-		errs = append(errs, err)
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	log.Info("Closing all peers...done!")
 
-	return len(errs) != 0, errs
+	return len(errs) == 0, errs
 }
 
 // Private Methods
 
-func newPeer() *peerImpl {
-	return &peerImpl{&nodeImpl{}, sync.RWMutex{}, nil, false}
-}
-
 func closePeerInternal(peer Peer, force bool) error {
 	if peer == nil {
 		return utils.ErrNilArgument
@@ -0,0 +1,172 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// The transactions of a block are verified as a batch, in two rounds.
+// First, each distinct certificate of the batch is parsed, checked against
+// the CRLs and verified against the ECA and the TCA once, however many
+// transactions carry it. Then the signatures are verified, each transaction
+// on its own worker.
+// The signatures cannot be verified together in a single multi-scalar
+// multiplication: an ECDSA signature carries only the x coordinate of R,
+// and R is needed to combine the verification equations.
+
+// batchCert is a distinct certificate of a batch, and the outcome of its checks
+type batchCert struct {
+	raw  []byte
+	cert *x509.Certificate
+	err  error
+}
+
+// Private Methods
+
+// verifyTransactionsBatch verifies the certificates and signatures of txs.
+// The i-th error is the outcome for txs[i].
+func (peer *peerImpl) verifyTransactionsBatch(txs []*obc.Transaction) []error {
+	errs := make([]error, len(txs))
+
+	// Distinct certificates of the batch, in order of appearance
+	certs := []*batchCert{}
+	byFingerprint := make(map[string]*batchCert)
+	txCerts := make([]*batchCert, len(txs))
+	for i, tx := range txs {
+		switch {
+		case tx.Cert == nil:
+			errs[i] = utils.ErrTransactionCertificate
+		case tx.Signature == nil:
+			errs[i] = utils.ErrTransactionSignature
+		case isIdemixCert(tx.Cert):
+			// Verified on its own in the second round
+		default:
+			fingerprint := string(primitives.Hash(tx.Cert))
+			c, ok := byFingerprint[fingerprint]
+			if !ok {
+				c = &batchCert{raw: tx.Cert}
+				byFingerprint[fingerprint] = c
+				certs = append(certs, c)
+			}
+			txCerts[i] = c
+		}
+	}
+	peer.Debugf("Verifying batch of [%d] transactions with [%d] distinct certificates.", len(txs), len(certs))
+
+	parallelFor(len(certs), func(i int) {
+		certs[i].cert, certs[i].err = peer.checkTransactionCert(certs[i].raw)
+	})
+
+	parallelFor(len(txs), func(i int) {
+		if errs[i] != nil {
+			return
+		}
+
+		tx := txs[i]
+		c := txCerts[i]
+		switch {
+		case c == nil:
+			if _, err := peer.verifyIdemixTransaction(tx, peer.idemixIssuer); err != nil {
+				peer.Errorf("TransactionPreExecution: failed verifying idemix signature [%s].", err.Error())
+				errs[i] = err
+			}
+		case c.err != nil:
+			errs[i] = c.err
+		default:
+			errs[i] = peer.verifyTransactionSignature(tx, c.cert)
+		}
+	})
+
+	return errs
+}
+
+// checkTransactionCert parses the certificate of a transaction, and
+// checks that it is not revoked and that it chains to the ECA or the TCA.
+// A certificate already verified is not parsed again.
+func (peer *peerImpl) checkTransactionCert(der []byte) (*x509.Certificate, error) {
+	cert := peer.getVerifiedCert(certFingerprint(der))
+	verified := cert != nil
+	if !verified {
+		var err error
+		if cert, err = primitives.DERToX509Certificate(der); err != nil {
+			peer.Errorf("TransactionPreExecution: failed unmarshalling cert [%s].", err.Error())
+			return nil, err
+		}
+	}
+
+	if peer.isCertRevoked(cert) {
+		peer.Errorf("TransactionPreExecution: cert revoked [% x].", cert.SerialNumber)
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(primitives.Hash(der)), utils.ErrCertRevoked.Error())
+		return nil, utils.ErrCertRevoked
+	}
+	if err := peer.checkOCSP(cert); err != nil {
+		peer.Errorf("TransactionPreExecution: failed checking cert status [%s].", err.Error())
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(primitives.Hash(der)), err.Error())
+		return nil, err
+	}
+	if verified {
+		return cert, nil
+	}
+	if err := peer.verifyCertChain(cert); err != nil {
+		peer.Errorf("TransactionPreExecution: failed verifying cert chain [%s].", err.Error())
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(primitives.Hash(der)), err.Error())
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// verifyTransactionSignature checks the signature of tx, without
+// signature, under cert. tx is not modified, so that the same
+// transaction can be verified concurrently.
+func (peer *peerImpl) verifyTransactionSignature(tx *obc.Transaction, cert *x509.Certificate) error {
+	unsigned := *tx
+	unsigned.Signature = nil
+	rawTx, err := proto.Marshal(&unsigned)
+	if err != nil {
+		peer.Errorf("TransactionPreExecution: failed marshaling tx [%s].", err.Error())
+		return err
+	}
+
+	ok, err := peer.verify(cert.PublicKey, rawTx, tx.Signature)
+	if err != nil {
+		peer.Errorf("TransactionPreExecution: failed verifying signature [%s].", err.Error())
+		return err
+	}
+	if !ok {
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(primitives.Hash(tx.Cert)), utils.ErrInvalidTransactionSignature.Error())
+		return utils.ErrInvalidTransactionSignature
+	}
+
+	return nil
+}
+
+// batchErrors returns n times err
+func batchErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+
+	return errs
+}
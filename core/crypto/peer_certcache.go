@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"container/list"
+	"crypto/x509"
+	"sync"
+)
+
+// certLRU keeps in memory the parsed enrollment certificates most recently
+// used to verify signatures, so that a hot certificate is neither read from
+// the cert store nor decrypted and parsed again. A maxEntries not positive
+// means unbounded.
+type certLRU struct {
+	m          sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type certLRUItem struct {
+	sid  string
+	cert *x509.Certificate
+}
+
+func newCertLRU(maxEntries int) *certLRU {
+	return &certLRU{maxEntries: maxEntries, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *certLRU) get(sid string) *x509.Certificate {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	elem, ok := c.entries[sid]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+
+	return elem.Value.(*certLRUItem).cert
+}
+
+func (c *certLRU) put(sid string, cert *x509.Certificate) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if elem, ok := c.entries[sid]; ok {
+		elem.Value.(*certLRUItem).cert = cert
+		c.lru.MoveToFront(elem)
+
+		return
+	}
+	c.entries[sid] = c.lru.PushFront(&certLRUItem{sid, cert})
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*certLRUItem).sid)
+	}
+}
+
+func (c *certLRU) delete(sid string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if elem, ok := c.entries[sid]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, sid)
+	}
+}
+
+// purge removes the certificates selected by filter, and returns how many were removed
+func (c *certLRU) purge(filter func(cert *x509.Certificate) bool) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	n := 0
+	for sid, elem := range c.entries {
+		if filter(elem.Value.(*certLRUItem).cert) {
+			c.lru.Remove(elem)
+			delete(c.entries, sid)
+			n++
+		}
+	}
+
+	return n
+}
+
+func (c *certLRU) len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.lru.Len()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// The cert store records, next to the encrypted certificates, their type,
+// serial number, issuer and validity period, so that the cached
+// certificates can be listed without decrypting them.
+
+// Public Struct
+
+// CertInfo describes a certificate cached by a peer or a validator
+type CertInfo struct {
+
+	// ID is the id of the peer the certificate belongs to
+	ID []byte
+
+	// Type is the kind of the certificate
+	Type CertType
+
+	// SerialNumber and Issuer identify the certificate as CRLs do:
+	// the decimal serial number and the Base64 encoded DER issuer name
+	SerialNumber string
+	Issuer       string
+
+	// NotBefore and NotAfter bound the validity period of the certificate
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// CertInfoFilter selects cached certificates.
+// Zero fields match every certificate.
+type CertInfoFilter struct {
+
+	// Type selects the certificates of a kind
+	Type CertType
+
+	// Issuer selects the certificates issued by the
+	// Base64 encoded DER issuer name
+	Issuer string
+
+	// SerialNumber selects the certificates with the decimal serial number
+	SerialNumber string
+
+	// ExpiresBefore selects the certificates expiring before that time
+	ExpiresBefore time.Time
+
+	// Limit is the maximum number of certificates returned
+	Limit int
+}
+
+// Public Methods
+
+// ListCertificates returns, ordered by expiry, the certificates selected by
+// filter in the cert store of the node of type eType named name.
+func ListCertificates(eType NodeType, name string, pwd []byte, filter *CertInfoFilter) ([]CertInfo, error) {
+	if eType == NodeClient {
+		return []CertInfo{}, nil
+	}
+
+	node, err := openNodeKeyStore(eType, name, pwd)
+	if err != nil {
+		return nil, err
+	}
+	defer node.ks.close()
+
+	if err := node.ks.openCertStore(); err != nil {
+		return nil, err
+	}
+
+	return node.ks.listCertificates(filter)
+}
+
+// Private Methods
+
+func (filter *CertInfoFilter) match(info *CertInfo) bool {
+	switch {
+	case filter.Type != "" && info.Type != filter.Type:
+		return false
+	case filter.Issuer != "" && info.Issuer != filter.Issuer:
+		return false
+	case filter.SerialNumber != "" && info.SerialNumber != filter.SerialNumber:
+		return false
+	case !filter.ExpiresBefore.IsZero() && !info.NotAfter.Before(filter.ExpiresBefore):
+		return false
+	}
+
+	return true
+}
+
+func (ks *keyStore) listCertificates(filter *CertInfoFilter) ([]CertInfo, error) {
+	if filter == nil {
+		filter = &CertInfoFilter{}
+	}
+
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	infos := []CertInfo{}
+	err := ks.certStore.ForEach(func(sid string, entry *CertEntry) error {
+		if entry.Type == "" {
+			// Entry stored before metadata were recorded,
+			// the cert store caches enrollment certificates only
+			certSign, err := ks.decryptBlob(entry.CertSign)
+			if err != nil {
+				return err
+			}
+			x509Cert, err := primitives.DERToX509Certificate(certSign)
+			if err != nil {
+				ks.node.Errorf("Failed parsing cert for [%s]: [%s].", sid, err.Error())
+				return err
+			}
+			entry.setMetadata(CertTypeECert, x509Cert)
+		}
+
+		id, err := utils.DecodeBase64(sid)
+		if err != nil {
+			return err
+		}
+		info := CertInfo{
+			ID:           id,
+			Type:         entry.Type,
+			SerialNumber: entry.SerialNumber,
+			Issuer:       entry.Issuer,
+			NotBefore:    entry.NotBefore,
+			NotAfter:     entry.NotAfter,
+		}
+		if filter.match(&info) {
+			infos = append(infos, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		ks.node.Errorf("Failed scanning cert store [%s].", err.Error())
+		return nil, err
+	}
+
+	sort.Sort(certInfosByExpiry(infos))
+	if filter.Limit > 0 && len(infos) > filter.Limit {
+		infos = infos[:filter.Limit]
+	}
+
+	return infos, nil
+}
+
+// certInfosByExpiry sorts certificates, first expiring first
+type certInfosByExpiry []CertInfo
+
+func (c certInfosByExpiry) Len() int           { return len(c) }
+func (c certInfosByExpiry) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c certInfosByExpiry) Less(i, j int) bool { return c[i].NotAfter.Before(c[j].NotAfter) }
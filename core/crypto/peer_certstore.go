@@ -0,0 +1,600 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/x509"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// Public Interfaces
+
+// CertStore is the backend used by peers and validators to cache
+// the enrollment certificates fetched from the ECA.
+type CertStore interface {
+
+	// Get returns the entry stored under id.
+	// If no entry exists, Get returns nil and no error.
+	Get(id string) (*CertEntry, error)
+
+	// Put stores entry under id, replacing any previous entry
+	Put(id string, entry *CertEntry) error
+
+	// Delete removes the entry stored under id, if any
+	Delete(id string) error
+
+	// ForEach calls f on a snapshot of the stored entries.
+	// ForEach stops at the first error returned by f.
+	ForEach(f func(id string, entry *CertEntry) error) error
+
+	// Trim evicts the least recently used entries until at most
+	// maxEntries remain, and returns the number of entries evicted
+	Trim(maxEntries int) (int, error)
+
+	// Compact releases the storage left unused by removed entries.
+	// Compact is never called concurrently with the other methods.
+	Compact() error
+
+	// Close releases all the resources allocated by the store
+	Close() error
+}
+
+// CertEntry is an enrollment certificate pair cached by a CertStore
+type CertEntry struct {
+
+	// CertSign is the signing certificate
+	CertSign []byte
+
+	// CertEnc is the encryption certificate, if any
+	CertEnc []byte
+
+	// NotAfter is the expiry of the signing certificate.
+	// The zero value means unknown.
+	NotAfter time.Time
+
+	// NotBefore is the start of validity of the signing certificate
+	NotBefore time.Time
+
+	// Type is the kind of the signing certificate.
+	// It is empty for entries stored before it was recorded.
+	Type CertType
+
+	// SerialNumber and Issuer identify the signing certificate as CRLs do:
+	// the decimal serial number and the Base64 encoded DER issuer name
+	SerialNumber string
+	Issuer       string
+}
+
+// CertType is the kind of a certificate
+type CertType string
+
+const (
+	// CertTypeECert is an enrollment certificate
+	CertTypeECert CertType = "ecert"
+
+	// CertTypeTCert is a transaction certificate
+	CertTypeTCert CertType = "tcert"
+
+	// CertTypeTLS is a TLS certificate
+	CertTypeTLS CertType = "tls"
+)
+
+// CertStoreConfig carries the parameters passed to a CertStoreFactory
+type CertStoreConfig struct {
+
+	// Path is the directory of the keystore owning the store
+	Path string
+
+	// DBPath is the directory of the keystore database file.
+	// It differs from Path if the database is shared by several nodes.
+	DBPath string
+
+	// Filename is the name of the keystore database file inside DBPath
+	Filename string
+
+	// BusyTimeout is the time, in milliseconds, a backend sharing
+	// the keystore database file waits on a locked database
+	BusyTimeout int
+
+	// Name is the name of the node owning the store
+	Name string
+
+	// Owner namespaces the entries of the node in a shared keystore
+	// database. It is empty if the database is not shared.
+	Owner string
+
+	// ReadOnly is true if the store must not be modified, not even
+	// to record the time of last use of its entries
+	ReadOnly bool
+
+	// JournalMode and Synchronous are the SQLite pragmas
+	// of the keystore database file
+	JournalMode string
+	Synchronous string
+
+	// DBKey is the SQLCipher key of the keystore database file, if encrypted
+	DBKey string
+}
+
+// CertStoreFactory creates a new CertStore for the passed configuration
+type CertStoreFactory func(conf *CertStoreConfig) (CertStore, error)
+
+// Private type and variables
+
+var (
+	// Map of registered cert store backends
+	certStoreFactories = make(map[string]CertStoreFactory)
+
+	// Sync
+	certStoreFactoriesMutex sync.RWMutex
+)
+
+func init() {
+	RegisterCertStore("sqlite", newSQLiteCertStore)
+	RegisterCertStore("memory", newMemoryCertStore)
+	RegisterCertStore("bolt", newBoltCertStore)
+}
+
+// Public Methods
+
+// RegisterCertStore makes a cert store backend available under name.
+// The backend used by a peer or validator is selected
+// by the security.keystore.certstore.backend property.
+func RegisterCertStore(name string, factory CertStoreFactory) error {
+	if factory == nil {
+		return utils.ErrNilArgument
+	}
+
+	certStoreFactoriesMutex.Lock()
+	defer certStoreFactoriesMutex.Unlock()
+
+	if _, ok := certStoreFactories[name]; ok {
+		return fmt.Errorf("Cert store backend [%s] already registered.", name)
+	}
+	certStoreFactories[name] = factory
+
+	return nil
+}
+
+// Private Methods
+
+func (entry *CertEntry) clone() *CertEntry {
+	return &CertEntry{
+		CertSign:     utils.Clone(entry.CertSign),
+		CertEnc:      utils.Clone(entry.CertEnc),
+		NotAfter:     entry.NotAfter,
+		NotBefore:    entry.NotBefore,
+		Type:         entry.Type,
+		SerialNumber: entry.SerialNumber,
+		Issuer:       entry.Issuer,
+	}
+}
+
+// setMetadata records in entry the metadata of x509Cert, of type certType
+func (entry *CertEntry) setMetadata(certType CertType, x509Cert *x509.Certificate) {
+	entry.Type = certType
+	entry.SerialNumber = x509Cert.SerialNumber.String()
+	entry.Issuer = utils.EncodeBase64(x509Cert.RawIssuer)
+	entry.NotBefore = x509Cert.NotBefore
+	entry.NotAfter = x509Cert.NotAfter
+}
+
+// certStoreRecord is the serialized form of a CertEntry
+// used by the key-value backends
+type certStoreRecord struct {
+	CertSign []byte
+	CertEnc  []byte
+	NotAfter int64
+	LastUsed int64
+
+	NotBefore    int64
+	Type         string
+	SerialNumber string
+	Issuer       string
+}
+
+func newCertStoreRecord(entry *CertEntry, lastUsed int64) *certStoreRecord {
+	record := &certStoreRecord{
+		CertSign:     entry.CertSign,
+		CertEnc:      entry.CertEnc,
+		LastUsed:     lastUsed,
+		Type:         string(entry.Type),
+		SerialNumber: entry.SerialNumber,
+		Issuer:       entry.Issuer,
+	}
+	if !entry.NotAfter.IsZero() {
+		record.NotAfter = entry.NotAfter.Unix()
+	}
+	if !entry.NotBefore.IsZero() {
+		record.NotBefore = entry.NotBefore.Unix()
+	}
+
+	return record
+}
+
+func decodeCertStoreRecord(raw []byte) (*certStoreRecord, error) {
+	record := &certStoreRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (record *certStoreRecord) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (record *certStoreRecord) toCertEntry() *CertEntry {
+	entry := &CertEntry{
+		CertSign:     record.CertSign,
+		CertEnc:      record.CertEnc,
+		Type:         CertType(record.Type),
+		SerialNumber: record.SerialNumber,
+		Issuer:       record.Issuer,
+	}
+	if record.NotAfter != 0 {
+		entry.NotAfter = time.Unix(record.NotAfter, 0)
+	}
+	if record.NotBefore != 0 {
+		entry.NotBefore = time.Unix(record.NotBefore, 0)
+	}
+
+	return entry
+}
+
+type certStoreUsage struct {
+	id       string
+	lastUsed int64
+}
+
+// certStoreUsages sorts entries, most recently used first
+type certStoreUsages []certStoreUsage
+
+func (u certStoreUsages) Len() int           { return len(u) }
+func (u certStoreUsages) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u certStoreUsages) Less(i, j int) bool { return u[i].lastUsed > u[j].lastUsed }
+
+func newCertStore(name string, conf *CertStoreConfig) (CertStore, error) {
+	certStoreFactoriesMutex.RLock()
+	factory, ok := certStoreFactories[name]
+	certStoreFactoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Cert store backend [%s] not registered.", name)
+	}
+
+	return factory(conf)
+}
+
+// SQLite backend
+
+type sqliteCertStore struct {
+	sqlDB    *sql.DB
+	owner    string
+	readOnly bool
+
+	// Statements run on every verification missing the in-memory
+	// cache, prepared once
+	getStmt    *sql.Stmt
+	touchStmt  *sql.Stmt
+	putStmt    *sql.Stmt
+	deleteStmt *sql.Stmt
+}
+
+func newSQLiteCertStore(conf *CertStoreConfig) (CertStore, error) {
+	sqlDB, err := openSQLite(sqliteDataSourceName(filepath.Join(conf.DBPath, conf.Filename), conf.BusyTimeout, conf.ReadOnly), conf.JournalMode, conf.Synchronous, conf.DBKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateSchema(sqlDB, "certstore", certStoreMigrations); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	store := &sqliteCertStore{sqlDB: sqlDB, owner: conf.Owner, readOnly: conf.ReadOnly}
+	if err := store.prepare(); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (store *sqliteCertStore) prepare() (err error) {
+	if store.getStmt, err = store.sqlDB.Prepare("SELECT " + sqliteCertColumns + " FROM Certificates WHERE owner = ? AND id = ?"); err != nil {
+		return
+	}
+	if store.touchStmt, err = store.sqlDB.Prepare("UPDATE Certificates SET lastused = ? WHERE owner = ? AND id = ?"); err != nil {
+		return
+	}
+	if store.putStmt, err = store.sqlDB.Prepare("INSERT OR REPLACE INTO Certificates (owner, id, certsign, certenc, notafter, lastused, notbefore, certtype, serial, issuer) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"); err != nil {
+		return
+	}
+	store.deleteStmt, err = store.sqlDB.Prepare("DELETE FROM Certificates WHERE owner = ? AND id = ?")
+
+	return
+}
+
+// Columns of the Certificates table scanned by sqliteCertRow
+const sqliteCertColumns = "certsign, certenc, notafter, notbefore, certtype, serial, issuer"
+
+// sqliteCertRow receives the sqliteCertColumns of a Certificates row.
+// Columns added by later migrations are NULL in older rows.
+type sqliteCertRow struct {
+	certSign, certEnc        []byte
+	notAfter, notBefore      sql.NullInt64
+	certType, serial, issuer sql.NullString
+}
+
+func (row *sqliteCertRow) dest() []interface{} {
+	return []interface{}{&row.certSign, &row.certEnc, &row.notAfter, &row.notBefore, &row.certType, &row.serial, &row.issuer}
+}
+
+func (row *sqliteCertRow) toCertEntry() *CertEntry {
+	entry := &CertEntry{
+		CertSign:     row.certSign,
+		CertEnc:      row.certEnc,
+		Type:         CertType(row.certType.String),
+		SerialNumber: row.serial.String,
+		Issuer:       row.issuer.String,
+	}
+	if row.notAfter.Valid && row.notAfter.Int64 != 0 {
+		entry.NotAfter = time.Unix(row.notAfter.Int64, 0)
+	}
+	if row.notBefore.Valid && row.notBefore.Int64 != 0 {
+		entry.NotBefore = time.Unix(row.notBefore.Int64, 0)
+	}
+
+	return entry
+}
+
+func (store *sqliteCertStore) Get(id string) (*CertEntry, error) {
+	row := &sqliteCertRow{}
+	err := store.getStmt.QueryRow(store.owner, id).Scan(row.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entry := row.toCertEntry()
+
+	if store.readOnly {
+		return entry, nil
+	}
+	if _, err := store.touchStmt.Exec(time.Now().Unix(), store.owner, id); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (store *sqliteCertStore) Put(id string, entry *CertEntry) error {
+	var notAfter, notBefore int64
+	if !entry.NotAfter.IsZero() {
+		notAfter = entry.NotAfter.Unix()
+	}
+	if !entry.NotBefore.IsZero() {
+		notBefore = entry.NotBefore.Unix()
+	}
+
+	tx, err := store.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Stmt(store.putStmt).Exec(store.owner, id, entry.CertSign, entry.CertEnc, notAfter, time.Now().Unix(), notBefore, string(entry.Type), entry.SerialNumber, entry.Issuer); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (store *sqliteCertStore) Delete(id string) error {
+	_, err := store.deleteStmt.Exec(store.owner, id)
+
+	return err
+}
+
+func (store *sqliteCertStore) ForEach(f func(id string, entry *CertEntry) error) error {
+	rows, err := store.sqlDB.Query("SELECT id, "+sqliteCertColumns+" FROM Certificates WHERE owner = ?", store.owner)
+	if err != nil {
+		return err
+	}
+
+	// Collect the entries first, f might access the store
+	ids := []string{}
+	entries := []*CertEntry{}
+	for rows.Next() {
+		var id string
+		row := &sqliteCertRow{}
+		if err := rows.Scan(append([]interface{}{&id}, row.dest()...)...); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		entries = append(entries, row.toCertEntry())
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		if err := f(id, entries[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *sqliteCertStore) Trim(maxEntries int) (int, error) {
+	res, err := store.sqlDB.Exec("DELETE FROM Certificates WHERE owner = ? AND id NOT IN (SELECT id FROM Certificates WHERE owner = ? ORDER BY lastused DESC LIMIT ?)", store.owner, store.owner, maxEntries)
+	if err != nil {
+		return 0, err
+	}
+
+	evicted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(evicted), nil
+}
+
+func (store *sqliteCertStore) Compact() error {
+	// The keystore DB file is shared with the keystore,
+	// which vacuums it on its own compaction
+	return nil
+}
+
+func (store *sqliteCertStore) Close() error {
+	for _, stmt := range []*sql.Stmt{store.getStmt, store.touchStmt, store.putStmt, store.deleteStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+
+	return store.sqlDB.Close()
+}
+
+// Map-backed backend, with entries kept in LRU order
+
+type memoryCertStore struct {
+	m       sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryCertStoreItem struct {
+	id    string
+	entry *CertEntry
+}
+
+func newMemoryCertStore(conf *CertStoreConfig) (CertStore, error) {
+	return &memoryCertStore{entries: make(map[string]*list.Element), lru: list.New()}, nil
+}
+
+func (store *memoryCertStore) Get(id string) (*CertEntry, error) {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	elem, ok := store.entries[id]
+	if !ok {
+		return nil, nil
+	}
+	store.lru.MoveToFront(elem)
+
+	return elem.Value.(*memoryCertStoreItem).entry.clone(), nil
+}
+
+func (store *memoryCertStore) Put(id string, entry *CertEntry) error {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	if elem, ok := store.entries[id]; ok {
+		elem.Value.(*memoryCertStoreItem).entry = entry.clone()
+		store.lru.MoveToFront(elem)
+
+		return nil
+	}
+	store.entries[id] = store.lru.PushFront(&memoryCertStoreItem{id, entry.clone()})
+
+	return nil
+}
+
+func (store *memoryCertStore) Delete(id string) error {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	if elem, ok := store.entries[id]; ok {
+		store.lru.Remove(elem)
+		delete(store.entries, id)
+	}
+
+	return nil
+}
+
+func (store *memoryCertStore) ForEach(f func(id string, entry *CertEntry) error) error {
+	store.m.Lock()
+	items := make([]memoryCertStoreItem, 0, store.lru.Len())
+	for elem := store.lru.Front(); elem != nil; elem = elem.Next() {
+		item := elem.Value.(*memoryCertStoreItem)
+		items = append(items, memoryCertStoreItem{item.id, item.entry.clone()})
+	}
+	store.m.Unlock()
+
+	for _, item := range items {
+		if err := f(item.id, item.entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *memoryCertStore) Trim(maxEntries int) (int, error) {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	evicted := 0
+	for store.lru.Len() > maxEntries {
+		elem := store.lru.Back()
+		store.lru.Remove(elem)
+		delete(store.entries, elem.Value.(*memoryCertStoreItem).id)
+		evicted++
+	}
+
+	return evicted, nil
+}
+
+func (store *memoryCertStore) Compact() error {
+	return nil
+}
+
+func (store *memoryCertStore) Close() error {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	store.entries = make(map[string]*list.Element)
+	store.lru.Init()
+
+	return nil
+}
@@ -0,0 +1,254 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// BoltDB backend. Entries are stored as certStoreRecords in the Certificates
+// bucket of a dedicated file next to the keystore DB. To keep lookups
+// read-only, the time of last use is tracked in memory and only persisted
+// by Put.
+
+const boltCertStoreFilename = "certstore.bolt"
+
+var boltCertStoreBucket = []byte("Certificates")
+
+type boltCertStore struct {
+	db      *bolt.DB
+	options *bolt.Options
+
+	m        sync.Mutex
+	lastUsed map[string]int64
+}
+
+func newBoltCertStore(conf *CertStoreConfig) (CertStore, error) {
+	options := &bolt.Options{Timeout: time.Duration(conf.BusyTimeout) * time.Millisecond, ReadOnly: conf.ReadOnly}
+	db, err := bolt.Open(filepath.Join(conf.Path, boltCertStoreFilename), 0600, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ReadOnly {
+		err = db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(boltCertStoreBucket) == nil {
+				return fmt.Errorf("Bucket [%s] not found in read-only cert store.", boltCertStoreBucket)
+			}
+			return nil
+		})
+	} else {
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltCertStoreBucket)
+			return err
+		})
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltCertStore{db: db, options: options, lastUsed: make(map[string]int64)}, nil
+}
+
+func (store *boltCertStore) Get(id string) (*CertEntry, error) {
+	var entry *CertEntry
+	err := store.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltCertStoreBucket).Get([]byte(id))
+		if raw == nil {
+			return nil
+		}
+
+		stored, err := decodeCertStoreRecord(raw)
+		if err != nil {
+			return err
+		}
+		entry = stored.toCertEntry()
+
+		return nil
+	})
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	store.m.Lock()
+	store.lastUsed[id] = time.Now().Unix()
+	store.m.Unlock()
+
+	return entry, nil
+}
+
+func (store *boltCertStore) Put(id string, entry *CertEntry) error {
+	stored := newCertStoreRecord(entry, time.Now().Unix())
+	raw, err := stored.encode()
+	if err != nil {
+		return err
+	}
+
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCertStoreBucket).Put([]byte(id), raw)
+	})
+	if err != nil {
+		return err
+	}
+
+	store.m.Lock()
+	store.lastUsed[id] = stored.LastUsed
+	store.m.Unlock()
+
+	return nil
+}
+
+func (store *boltCertStore) Delete(id string) error {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCertStoreBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+
+	store.m.Lock()
+	delete(store.lastUsed, id)
+	store.m.Unlock()
+
+	return nil
+}
+
+func (store *boltCertStore) ForEach(f func(id string, entry *CertEntry) error) error {
+	// Collect the entries first, f might access the store
+	ids := []string{}
+	entries := []*CertEntry{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCertStoreBucket).ForEach(func(k, v []byte) error {
+			stored, err := decodeCertStoreRecord(v)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, string(k))
+			entries = append(entries, stored.toCertEntry())
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if err := f(id, entries[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *boltCertStore) Trim(maxEntries int) (int, error) {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	evicted := 0
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltCertStoreBucket)
+
+		usages := certStoreUsages{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			lastUsed, ok := store.lastUsed[string(k)]
+			if !ok {
+				stored, err := decodeCertStoreRecord(v)
+				if err != nil {
+					return err
+				}
+				lastUsed = stored.LastUsed
+			}
+			usages = append(usages, certStoreUsage{string(k), lastUsed})
+
+			return nil
+		})
+		if err != nil || len(usages) <= maxEntries {
+			return err
+		}
+
+		sort.Sort(usages)
+		for _, u := range usages[maxEntries:] {
+			if err := bucket.Delete([]byte(u.id)); err != nil {
+				return err
+			}
+			delete(store.lastUsed, u.id)
+			evicted++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return evicted, nil
+}
+
+// Compact rewrites the entries to a new file, as BoltDB
+// never shrinks its file, and swaps it with the current one
+func (store *boltCertStore) Compact() error {
+	path := store.db.Path()
+	compactPath := path + ".compact"
+	os.Remove(compactPath)
+
+	compactDB, err := bolt.Open(compactPath, 0600, store.options)
+	if err != nil {
+		return err
+	}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		return compactDB.Update(func(compactTx *bolt.Tx) error {
+			bucket, err := compactTx.CreateBucketIfNotExists(boltCertStoreBucket)
+			if err != nil {
+				return err
+			}
+
+			return tx.Bucket(boltCertStoreBucket).ForEach(bucket.Put)
+		})
+	})
+	compactDB.Close()
+	if err != nil {
+		os.Remove(compactPath)
+		return err
+	}
+
+	if err := store.db.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(compactPath, path)
+	if renameErr != nil {
+		os.Remove(compactPath)
+	}
+	if store.db, err = bolt.Open(path, 0600, store.options); err != nil {
+		return err
+	}
+
+	return renameErr
+}
+
+func (store *boltCertStore) Close() error {
+	return store.db.Close()
+}
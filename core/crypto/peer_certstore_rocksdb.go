@@ -0,0 +1,201 @@
+//go:build rocksdb
+// +build rocksdb
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/tecbot/gorocksdb"
+)
+
+// RocksDB backend. Entries are stored as certStoreRecords in the crypto
+// column family of the peer's RocksDB, under keys prefixed by the name of
+// the owning node, or by its owner if the keystore DB is shared. The
+// RocksDB instance is shared with the ledger, hence Close does not close
+// it. As for BoltDB, the time of last use is tracked in memory and only
+// persisted by Put.
+//
+// The backend is built only with the rocksdb build tag, as the peer is:
+// other processes hold no RocksDB instance of the ledger to select it for.
+
+func init() {
+	RegisterCertStore("rocksdb", newRocksDBCertStore)
+}
+
+type rocksDBCertStore struct {
+	prefix []byte
+
+	m        sync.Mutex
+	lastUsed map[string]int64
+}
+
+func newRocksDBCertStore(conf *CertStoreConfig) (CertStore, error) {
+	owner := conf.Name
+	if conf.Owner != "" {
+		owner = conf.Owner
+	}
+
+	return &rocksDBCertStore{
+		prefix:   []byte("crypto." + owner + "."),
+		lastUsed: make(map[string]int64),
+	}, nil
+}
+
+func (store *rocksDBCertStore) key(id string) []byte {
+	return append(append([]byte(nil), store.prefix...), id...)
+}
+
+func (store *rocksDBCertStore) Get(id string) (*CertEntry, error) {
+	openchainDB := db.GetDBHandle()
+	raw, err := openchainDB.Get(openchainDB.CryptoCF, store.key(id))
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	record, err := decodeCertStoreRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	store.m.Lock()
+	store.lastUsed[id] = time.Now().Unix()
+	store.m.Unlock()
+
+	return record.toCertEntry(), nil
+}
+
+func (store *rocksDBCertStore) Put(id string, entry *CertEntry) error {
+	record := newCertStoreRecord(entry, time.Now().Unix())
+	raw, err := record.encode()
+	if err != nil {
+		return err
+	}
+
+	openchainDB := db.GetDBHandle()
+	if err := openchainDB.Put(openchainDB.CryptoCF, store.key(id), raw); err != nil {
+		return err
+	}
+
+	store.m.Lock()
+	store.lastUsed[id] = record.LastUsed
+	store.m.Unlock()
+
+	return nil
+}
+
+func (store *rocksDBCertStore) Delete(id string) error {
+	openchainDB := db.GetDBHandle()
+	if err := openchainDB.Delete(openchainDB.CryptoCF, store.key(id)); err != nil {
+		return err
+	}
+
+	store.m.Lock()
+	delete(store.lastUsed, id)
+	store.m.Unlock()
+
+	return nil
+}
+
+func (store *rocksDBCertStore) ForEach(f func(id string, entry *CertEntry) error) error {
+	ids, records, err := store.scan()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if err := f(id, records[i].toCertEntry()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *rocksDBCertStore) Trim(maxEntries int) (int, error) {
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	ids, records, err := store.scan()
+	if err != nil || len(ids) <= maxEntries {
+		return 0, err
+	}
+
+	usages := certStoreUsages{}
+	for i, id := range ids {
+		lastUsed, ok := store.lastUsed[id]
+		if !ok {
+			lastUsed = records[i].LastUsed
+		}
+		usages = append(usages, certStoreUsage{id, lastUsed})
+	}
+	sort.Sort(usages)
+
+	openchainDB := db.GetDBHandle()
+	evicted := 0
+	for _, u := range usages[maxEntries:] {
+		if err := openchainDB.Delete(openchainDB.CryptoCF, store.key(u.id)); err != nil {
+			return evicted, err
+		}
+		delete(store.lastUsed, u.id)
+		evicted++
+	}
+
+	return evicted, nil
+}
+
+// Compact triggers the compaction of the keys of the store
+func (store *rocksDBCertStore) Compact() error {
+	limit := append([]byte(nil), store.prefix...)
+	limit[len(limit)-1]++
+
+	openchainDB := db.GetDBHandle()
+	openchainDB.DB.CompactRangeCF(openchainDB.CryptoCF, gorocksdb.Range{Start: store.prefix, Limit: limit})
+
+	return nil
+}
+
+func (store *rocksDBCertStore) Close() error {
+	return nil
+}
+
+// scan returns the entries of the store
+func (store *rocksDBCertStore) scan() ([]string, []*certStoreRecord, error) {
+	openchainDB := db.GetDBHandle()
+	it := openchainDB.GetIterator(openchainDB.CryptoCF)
+	defer it.Close()
+
+	ids := []string{}
+	records := []*certStoreRecord{}
+	for it.Seek(store.prefix); it.ValidForPrefix(store.prefix); it.Next() {
+		id := string(it.Key().Data()[len(store.prefix):])
+		// copy data from the slice!
+		record, err := decodeCertStoreRecord(append([]byte(nil), it.Value().Data()...))
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		records = append(records, record)
+	}
+
+	return ids, records, nil
+}
@@ -0,0 +1,52 @@
+//go:build rocksdb
+// +build rocksdb
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/spf13/viper"
+)
+
+func TestCertStoreRocksDB(t *testing.T) {
+	// The ledger DB is created under peer.fileSystemPath
+	fileSystemPath := viper.GetString("peer.fileSystemPath")
+	defer viper.Set("peer.fileSystemPath", fileSystemPath)
+	path, err := ioutil.TempDir("", "certstore-rocksdb")
+	if err != nil {
+		t.Fatalf("Failed creating path [%s].", err)
+	}
+	defer os.RemoveAll(path)
+	viper.Set("peer.fileSystemPath", path)
+
+	if err := db.CreateDB(); err != nil {
+		t.Fatalf("Failed creating ledger DB [%s].", err)
+	}
+	defer db.GetDBHandle().CloseDB()
+
+	store, err := newCertStore("rocksdb", &CertStoreConfig{Path: path, Name: "conformance"})
+	if err != nil {
+		t.Fatalf("Failed opening cert store [%s].", err)
+	}
+	testCertStore(t, store)
+}
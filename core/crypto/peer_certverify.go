@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// The certificate of a transaction, a TCert or an ECert, must chain to the
+// TCA or the ECA, of the peer or of a trusted organization. A peer keeps
+// the certificates it verified, keyed by their fingerprint, so that the
+// transactions of a busy counterparty neither parse the same certificate
+// nor walk the same chain again. An entry is dropped when its certificate
+// expires, or when a CRL revokes it.
+
+// Private Methods
+
+// verifyCertChain checks that cert chains to the ECA or the TCA, of the peer
+// or of a trusted organization
+func (peer *peerImpl) verifyCertChain(cert *x509.Certificate) error {
+	fingerprint := certFingerprint(cert.Raw)
+
+	if peer.getVerifiedCert(fingerprint) != nil {
+		return nil
+	}
+	certVerifyCacheMisses.inc()
+
+	markFabricExtensionsHandled(cert)
+
+	_, errECA := primitives.CheckCertAgainRoot(cert, peer.ecaCertPool)
+	if errECA != nil {
+		if _, errTCA := primitives.CheckCertAgainRoot(cert, peer.tcaCertPool); errTCA != nil {
+			if peer.verifyTrustedCert(cert, trustedECA) != nil && peer.verifyTrustedCert(cert, trustedTCA) != nil {
+				peer.Debugf("Certificate [%s] chains neither to the ECA [%s] nor to the TCA [%s].", fingerprint, errECA, errTCA)
+				return utils.ErrInvalidCertificateChain
+			}
+		}
+	}
+
+	peer.verifiedCerts.put(fingerprint, cert)
+
+	return nil
+}
+
+// getVerifiedCert returns the parsed cert whose fingerprint is
+// fingerprint if it was verified and has not expired since
+func (peer *peerImpl) getVerifiedCert(fingerprint string) *x509.Certificate {
+	cached := peer.verifiedCerts.get(fingerprint)
+	if cached == nil {
+		return nil
+	}
+	if !time.Now().Before(cached.NotAfter) {
+		peer.verifiedCerts.delete(fingerprint)
+		return nil
+	}
+	certVerifyCacheHits.inc()
+
+	return cached
+}
+
+// purgeVerifiedCerts drops the verified certs now revoked, and
+// returns how many were dropped
+func (peer *peerImpl) purgeVerifiedCerts() int {
+	return peer.verifiedCerts.purge(peer.isCertRevoked)
+}
+
+// certFingerprint returns the key of the cert whose DER encoding is der
+func certFingerprint(der []byte) string {
+	return utils.EncodeBase64(primitives.Hash(der))
+}
@@ -0,0 +1,300 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// Revoked certificates are identified by issuer and serial number,
+// and recorded in the RevokedCerts table of the keystore DB so that
+// revocations survive restarts. The issuer is the Base64 encoding of
+// the raw subject of the CA that signed the CRL. When
+// security.crl.polling.enabled is set, the CRLs of the ECA and the TCA
+// are fetched every security.crl.polling.interval and processed.
+
+var (
+	errInvalidCRLIssuer = errors.New("CRL not signed by the ECA or the TCA.")
+
+	// Schema of the revocation list
+	crlMigrations = []ksMigration{
+		{1, "create RevokedCerts table", []string{
+			"CREATE TABLE IF NOT EXISTS RevokedCerts (issuer VARCHAR, serial VARCHAR, revokedat INTEGER, PRIMARY KEY (issuer, serial))",
+		}},
+		{2, "namespace RevokedCerts table by owner", []string{
+			"CREATE TABLE RevokedCerts_v2 (owner VARCHAR NOT NULL DEFAULT '', issuer VARCHAR, serial VARCHAR, revokedat INTEGER, PRIMARY KEY (owner, issuer, serial))",
+			"INSERT INTO RevokedCerts_v2 (issuer, serial, revokedat) SELECT issuer, serial, revokedat FROM RevokedCerts",
+			"DROP TABLE RevokedCerts",
+			"ALTER TABLE RevokedCerts_v2 RENAME TO RevokedCerts",
+		}},
+	}
+)
+
+// Public Methods
+
+// ProcessCRL applies the certificate revocation list crl, DER or PEM
+// encoded and signed by the ECA or the TCA. The cached enrollment certs
+// it revokes are dropped and verification against any cert it revokes
+// fails from now on. ProcessCRL returns the number of cached certs dropped.
+func (peer *peerImpl) ProcessCRL(crl []byte) (int, error) {
+	if !peer.isInitialized {
+		return 0, utils.ErrNotInitialized
+	}
+	if len(crl) == 0 {
+		return 0, utils.ErrNilArgument
+	}
+
+	certList, err := x509.ParseCRL(crl)
+	if err != nil {
+		peer.Errorf("Failed parsing CRL [%s].", err.Error())
+		return 0, err
+	}
+
+	issuer, err := peer.getCRLIssuer(certList)
+	if err != nil {
+		peer.Errorf("Failed verifying CRL [%s].", err.Error())
+		return 0, err
+	}
+
+	revoked := certList.TBSCertList.RevokedCertificates
+	peer.Debugf("Processing CRL of [%s] with [%d] entries...", issuer.Subject.CommonName, len(revoked))
+
+	n, err := peer.revokeCerts(utils.EncodeBase64(issuer.RawSubject), revoked)
+	if err != nil {
+		return n, err
+	}
+
+	peer.Debugf("Processing CRL of [%s]...done! Dropped [%d] cached certs.", issuer.Subject.CommonName, n)
+
+	return n, nil
+}
+
+// Private Methods
+
+func (peer *peerImpl) initRevokedCerts() error {
+	if err := migrateSchema(peer.ks.sqlDB, "crl", crlMigrations); err != nil {
+		return err
+	}
+
+	revokedCerts, err := peer.ks.loadRevokedCerts()
+	if err != nil {
+		return err
+	}
+	peer.revokedCerts = revokedCerts
+
+	return nil
+}
+
+// startCRLPoller fetches and processes the CRLs
+// of the ECA and the TCA every interval
+func (peer *peerImpl) startCRLPoller(interval time.Duration) {
+	peer.Debugf("Polling CRLs every [%s].", interval)
+
+	peer.crlPollStop = make(chan struct{})
+	peer.crlPollDone = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				peer.pollCRLs()
+			case <-stop:
+				return
+			}
+		}
+	}(peer.crlPollStop, peer.crlPollDone)
+}
+
+// stopCRLPoller stops the polling, waiting for
+// a CRL being processed to be done
+func (peer *peerImpl) stopCRLPoller() {
+	if peer.crlPollStop == nil {
+		return
+	}
+
+	close(peer.crlPollStop)
+	<-peer.crlPollDone
+	peer.crlPollStop = nil
+}
+
+// pollCRLs fetches the current CRLs of the ECA and the TCA and processes them
+func (peer *peerImpl) pollCRLs() {
+	fetchers := map[string]func() (*membersrvc.CRL, error){
+		"ECA": func() (*membersrvc.CRL, error) {
+			sock, ecaP, err := peer.getECAClient()
+			if err != nil {
+				return nil, err
+			}
+			defer sock.Close()
+
+			return ecaP.ReadCRL(context.Background(), &membersrvc.Empty{})
+		},
+		"TCA": func() (*membersrvc.CRL, error) {
+			sock, tcaP, err := peer.getTCAClient()
+			if err != nil {
+				return nil, err
+			}
+			defer sock.Close()
+
+			return tcaP.ReadCRL(context.Background(), &membersrvc.Empty{})
+		},
+	}
+
+	for ca, fetch := range fetchers {
+		crl, err := fetch()
+		if err != nil {
+			peer.Errorf("Failed fetching the CRL of the %s [%s].", ca, err.Error())
+			crlPollFailures.inc()
+			continue
+		}
+		crlPolls.inc()
+
+		if _, err := peer.ProcessCRL(crl.Crl); err != nil {
+			crlPollFailures.inc()
+		}
+	}
+}
+
+// getCRLIssuer returns the CA cert, ECA or TCA, that signed certList
+func (peer *peerImpl) getCRLIssuer(certList *pkix.CertificateList) (*x509.Certificate, error) {
+	for _, alias := range []string{peer.conf.getECACertsChainFilename(), peer.conf.getTCACertsChainFilename()} {
+		caCert, _, err := peer.ks.loadCertX509AndDer(alias)
+		if err != nil {
+			continue
+		}
+		if caCert.CheckCRLSignature(certList) == nil {
+			return caCert, nil
+		}
+	}
+
+	return nil, errInvalidCRLIssuer
+}
+
+// revokeCerts records the revocation of the certs of issuer listed in
+// revoked, drops the cached ones and returns how many were dropped
+func (peer *peerImpl) revokeCerts(issuer string, revoked []pkix.RevokedCertificate) (int, error) {
+	if err := peer.ks.storeRevokedCerts(issuer, revoked); err != nil {
+		return 0, err
+	}
+
+	peer.revokedCertsMutex.Lock()
+	for _, entry := range revoked {
+		peer.revokedCerts[revokedCertKey(issuer, entry.SerialNumber.String())] = true
+	}
+	peer.revokedCertsMutex.Unlock()
+
+	// Drop the cached certs now revoked
+	ids, err := peer.ks.Purge(func(id []byte, cert *x509.Certificate) bool {
+		return peer.isCertRevoked(cert)
+	})
+	for _, id := range ids {
+		peer.deleteNodeEnrollmentCertificate(utils.EncodeBase64(id))
+	}
+	if n := peer.purgeVerifiedCerts(); n > 0 {
+		peer.Debugf("Dropped [%d] verified transaction certs.", n)
+	}
+
+	return len(ids), err
+}
+
+// isCertRevoked returns true if cert is listed in a processed CRL,
+// or was reported revoked by OCSP
+func (peer *peerImpl) isCertRevoked(cert *x509.Certificate) bool {
+	peer.revokedCertsMutex.RLock()
+	defer peer.revokedCertsMutex.RUnlock()
+
+	return peer.revokedCerts[revokedCertKey(utils.EncodeBase64(cert.RawIssuer), cert.SerialNumber.String())]
+}
+
+func revokedCertKey(issuer, serial string) string {
+	return issuer + "/" + serial
+}
+
+func (ks *keyStore) storeRevokedCerts(issuer string, revoked []pkix.RevokedCertificate) (err error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if err = ks.checkWritable(); err != nil {
+		return
+	}
+
+	// Open transaction
+	tx, err := ks.sqlDB.Begin()
+	if err != nil {
+		ks.node.Errorf("Failed beginning transaction [%s].", err)
+
+		return
+	}
+
+	for _, entry := range revoked {
+		if _, err = tx.Exec("INSERT OR REPLACE INTO RevokedCerts (owner, issuer, serial, revokedat) VALUES (?, ?, ?, ?)", ks.node.conf.getKeyStoreOwner(), issuer, entry.SerialNumber.String(), entry.RevocationTime.Unix()); err != nil {
+			ks.node.Errorf("Failed inserting revoked cert [%s].", err)
+
+			tx.Rollback()
+
+			return
+		}
+	}
+
+	// Finalize
+	err = tx.Commit()
+	if err != nil {
+		ks.node.Errorf("Failed commiting [%s].", err)
+		tx.Rollback()
+
+		return
+	}
+
+	return
+}
+
+func (ks *keyStore) loadRevokedCerts() (map[string]bool, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	rows, err := ks.sqlDB.Query("SELECT issuer, serial FROM RevokedCerts WHERE owner = ?", ks.node.conf.getKeyStoreOwner())
+	if err != nil {
+		ks.node.Errorf("Error during select [%s].", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	revokedCerts := make(map[string]bool)
+	for rows.Next() {
+		var issuer, serial string
+		if err := rows.Scan(&issuer, &serial); err != nil {
+			ks.node.Errorf("Error during scan [%s].", err)
+			return nil, err
+		}
+		revokedCerts[revokedCertKey(issuer, serial)] = true
+	}
+
+	ks.node.Debugf("Loaded [%d] revoked certs.", len(revokedCerts))
+
+	return revokedCerts, rows.Err()
+}
@@ -27,7 +27,7 @@ import (
 	"golang.org/x/net/context"
 )
 
-func (peer *peerImpl) getEnrollmentCert(id []byte) (*x509.Certificate, error) {
+func (peer *peerImpl) getEnrollmentCert(ctx context.Context, id []byte) (*x509.Certificate, error) {
 	if len(id) == 0 {
 		return nil, fmt.Errorf("Invalid peer id. It is empty.")
 	}
@@ -37,13 +37,22 @@ func (peer *peerImpl) getEnrollmentCert(id []byte) (*x509.Certificate, error) {
 	peer.Debugf("Getting enrollment certificate for [%s]", sid)
 
 	if cert := peer.getNodeEnrollmentCertificate(sid); cert != nil {
-		peer.Debugf("Enrollment certificate for [%s] already in memory.", sid)
-		return cert, nil
+		if !peer.conf.isCertRenewalDue(cert.NotAfter) {
+			peer.Debugf("Enrollment certificate for [%s] already in memory.", sid)
+			certHotCacheHits.inc()
+			if err := peer.checkOCSP(cert); err != nil {
+				peer.deleteNodeEnrollmentCertificate(sid)
+
+				return nil, err
+			}
+			return cert, nil
+		}
+		peer.Debugf("Enrollment certificate for [%s] in memory expired or about to expire.", sid)
 	}
 
 	// Retrieve from the DB or from the ECA in case
 	peer.Debugf("Retrieve Enrollment certificate for [%s]...", sid)
-	rawCert, err := peer.ks.GetSignEnrollmentCert(id, peer.getEnrollmentCertByHashFromECA)
+	rawCert, err := peer.ks.GetSignEnrollmentCert(ctx, id, peer.getEnrollmentCertByHash)
 	if err != nil {
 		peer.Errorf("Failed getting enrollment certificate for [%s]: [%s]", sid, err)
 
@@ -57,17 +66,38 @@ func (peer *peerImpl) getEnrollmentCert(id []byte) (*x509.Certificate, error) {
 		return nil, err
 	}
 
+	if peer.isCertRevoked(cert) {
+		peer.Errorf("Enrollment certificate for [%s] revoked.", sid)
+
+		return nil, utils.ErrCertRevoked
+	}
+	if err := peer.checkOCSP(cert); err != nil {
+		peer.Errorf("Failed checking status of enrollment certificate for [%s]: [%s]", sid, err)
+
+		return nil, err
+	}
+
 	peer.putNodeEnrollmentCertificate(sid, cert)
 
 	return cert, nil
 }
 
-func (peer *peerImpl) getEnrollmentCertByHashFromECA(id []byte) ([]byte, []byte, error) {
+// newCertFetchContext returns the context bounding the retrieval
+// of an enrollment certificate, with the configured timeout if any
+func (peer *peerImpl) newCertFetchContext() (context.Context, context.CancelFunc) {
+	if timeout := peer.conf.getCertFetchTimeout(); timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+func (peer *peerImpl) getEnrollmentCertByHashFromECA(ctx context.Context, id []byte) ([]byte, []byte, error) {
 	// Prepare the request
 	peer.Debugf("Reading certificate for hash [% x]", id)
 
 	req := &membersrvc.Hash{Hash: id}
-	response, err := peer.callECAReadCertificateByHash(context.Background(), req)
+	response, err := peer.callECAReadCertificateByHash(ctx, req)
 	if err != nil {
 		peer.Errorf("Failed requesting enrollment certificate [%s].", err.Error())
 
@@ -109,13 +139,13 @@ func (peer *peerImpl) getEnrollmentCertByHashFromECA(id []byte) ([]byte, []byte,
 }
 
 func (peer *peerImpl) getNodeEnrollmentCertificate(sid string) *x509.Certificate {
-	peer.nodeEnrollmentCertificatesMutex.RLock()
-	defer peer.nodeEnrollmentCertificatesMutex.RUnlock()
-	return peer.nodeEnrollmentCertificates[sid]
+	return peer.nodeEnrollmentCertificates.get(sid)
 }
 
 func (peer *peerImpl) putNodeEnrollmentCertificate(sid string, cert *x509.Certificate) {
-	peer.nodeEnrollmentCertificatesMutex.Lock()
-	defer peer.nodeEnrollmentCertificatesMutex.Unlock()
-	peer.nodeEnrollmentCertificates[sid] = cert
+	peer.nodeEnrollmentCertificates.put(sid, cert)
+}
+
+func (peer *peerImpl) deleteNodeEnrollmentCertificate(sid string) {
+	peer.nodeEnrollmentCertificates.delete(sid)
 }
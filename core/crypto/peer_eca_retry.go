@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// A fetch from the ECA failing because the ECA cannot be reached is retried,
+// waiting between attempts a backoff doubling from the initial one up to the
+// maximum, randomized by the jitter. A fetch the ECA rejects, as for an
+// unknown identity, fails at once. Retries stop when the fetch ctx is done.
+
+// fetchWithRetry calls certFetcher until it succeeds, fails permanently or
+// the configured attempts are exhausted
+func (ks *keyStore) fetchWithRetry(ctx context.Context, id []byte, sid string, certFetcher func(ctx context.Context, id []byte) ([]byte, []byte, error)) ([]byte, []byte, error) {
+	conf := ks.node.conf
+	backoff := conf.getCertFetchInitialBackoff()
+
+	for attempt := 1; ; attempt++ {
+		certSign, certEnc, err := certFetcher(ctx, id)
+		if err == nil {
+			return certSign, certEnc, nil
+		}
+		if attempt >= conf.getCertFetchAttempts() || !isRetryableFetchError(err) || ctx.Err() != nil {
+			return nil, nil, err
+		}
+
+		delay := jitter(backoff, conf.getCertFetchJitter())
+		ks.node.Warningf("Failed fetching cert for [%s], attempt [%d] [%s]. Retrying in [%s]...", sid, attempt, err, delay)
+		ecaFetchRetries.inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		}
+
+		if backoff *= 2; backoff > conf.getCertFetchMaxBackoff() {
+			backoff = conf.getCertFetchMaxBackoff()
+		}
+	}
+}
+
+// isRetryableFetchError returns true if err signals that the ECA could not
+// be reached or was temporarily unable to serve the request
+func isRetryableFetchError(err error) bool {
+	if err == grpc.ErrClientConnClosing || err == grpc.ErrClientConnTimeout {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Temporary() || netErr.Timeout()
+	}
+
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		// Connection failures surface as Internal errors
+		return true
+	}
+
+	return false
+}
+
+// jitter randomizes d by up to the fraction f, in both directions
+func jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 {
+		return d
+	}
+
+	return d + time.Duration(f*float64(d)*(2*rand.Float64()-1))
+}
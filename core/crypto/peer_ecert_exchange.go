@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+// A peer serves its own enrollment certificate chain, so that a validator
+// lacking the certificate of a counterpart gets it from the counterpart
+// rather than from the ECA. The chain is not trusted: the certificate must
+// hash to the id it was requested for, carry the role of a peer or a
+// validator, and verify against the ECA certificates of the validator, as
+// one fetched from the ECA. The ECA remains the fallback when the peer does
+// not answer within security.ecertExchange.timeout or serves an invalid
+// chain.
+
+// Public Methods
+
+// GetEnrollmentCertificateChain returns the enrollment certificate of
+// this peer followed by the certificates of the ECA, DER encoded
+func (peer *peerImpl) GetEnrollmentCertificateChain() ([][]byte, error) {
+	if !peer.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	peer.enrollMutex.RLock()
+	chain := [][]byte{utils.Clone(peer.enrollCert.Raw)}
+	peer.enrollMutex.RUnlock()
+
+	ecaCerts, err := peer.ks.loadCertsChain(peer.conf.getECACertsChainFilename())
+	if err != nil {
+		peer.Errorf("Failed loading ECA certificates chain [%s].", err.Error())
+		return nil, err
+	}
+	for _, cert := range ecaCerts {
+		chain = append(chain, cert.Raw)
+	}
+
+	return chain, nil
+}
+
+// SetEnrollmentCertSource has the enrollment certificates missing from
+// the cert store requested from the peers themselves through source
+func (peer *peerImpl) SetEnrollmentCertSource(source EnrollmentCertSource) {
+	peer.certSourceMutex.Lock()
+	defer peer.certSourceMutex.Unlock()
+
+	peer.certSource = source
+}
+
+// Private Methods
+
+// getEnrollmentCertByHash fetches the enrollment cert of id from the
+// peer itself if possible, from the ECA otherwise
+func (peer *peerImpl) getEnrollmentCertByHash(ctx context.Context, id []byte) ([]byte, []byte, error) {
+	peer.certSourceMutex.RLock()
+	source := peer.certSource
+	peer.certSourceMutex.RUnlock()
+
+	if source != nil && peer.conf.isECertExchangeEnabled() {
+		certSign, err := peer.getEnrollmentCertFromPeer(ctx, id, source)
+		if err == nil {
+			peerCertFetches.inc()
+			return certSign, nil, nil
+		}
+		peerCertFetchFailures.inc()
+		peer.Warningf("Failed getting enrollment certificate for [% x] from the peer [%s]. Asking the ECA.", id, err)
+	}
+
+	return peer.getEnrollmentCertByHashFromECA(ctx, id)
+}
+
+// getEnrollmentCertFromPeer requests the enrollment cert chain
+// of id from source, and checks the cert it starts with
+func (peer *peerImpl) getEnrollmentCertFromPeer(ctx context.Context, id []byte, source EnrollmentCertSource) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, peer.conf.getECertExchangeTimeout())
+	defer cancel()
+
+	chain, err := source(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errEmptyCertChain
+	}
+
+	certSign := chain[0]
+	if !bytes.Equal(primitives.Hash(certSign), id) {
+		return nil, errCertChainMismatch
+	}
+	x509Cert, err := primitives.DERToX509Certificate(certSign)
+	if err != nil {
+		return nil, err
+	}
+	if role, ok := enrollmentCertRole(x509Cert); !ok || (role != NodePeer && role != NodeValidator) {
+		return nil, errCertChainRole
+	}
+
+	ecaCertPool, err := peer.ks.getECACertPool()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := primitives.CheckCertAgainRoot(x509Cert, ecaCertPool); err != nil {
+		if peer.verifyTrustedCert(x509Cert, trustedECA) != nil {
+			return nil, err
+		}
+	}
+
+	return certSign, nil
+}
+
+// Private type and variables
+
+var (
+	errEmptyCertChain    = errors.New("Empty enrollment certificate chain.")
+	errCertChainMismatch = errors.New("Enrollment certificate chain of another peer.")
+	errCertChainRole     = errors.New("Enrollment certificate chain of neither a peer nor a validator.")
+)
@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
+)
+
+func TestValidatorEnrollmentCertExchange(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	v := validator.(*validatorImpl)
+	v.conf.eCertExchangeEnabled = true
+	defer func() {
+		v.conf.eCertExchangeEnabled = false
+		v.SetEnrollmentCertSource(nil)
+	}()
+
+	chain, err := peer.GetEnrollmentCertificateChain()
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate chain [%s].", err)
+	}
+	if len(chain) < 2 {
+		t.Fatalf("The chain should hold the enrollment certificate and the ECA certificates, it holds [%d].", len(chain))
+	}
+
+	served := 0
+	v.SetEnrollmentCertSource(func(ctx context.Context, id []byte) ([][]byte, error) {
+		served++
+		return chain, nil
+	})
+
+	certSign, err := v.getEnrollmentCertFromPeer(context.Background(), peer.GetID(), v.certSource)
+	if err != nil {
+		t.Fatalf("Failed getting enrollment certificate from the peer [%s].", err)
+	}
+	if !bytes.Equal(certSign, chain[0]) {
+		t.Fatal("The enrollment certificate served by the peer should be returned.")
+	}
+
+	// The chain of the peer does not hash to the id of the validator
+	if _, err := v.getEnrollmentCertFromPeer(context.Background(), validator.GetID(), v.certSource); err != errCertChainMismatch {
+		t.Fatalf("A chain served for another id should be rejected [%v].", err)
+	}
+
+	// A certificate not issued by the ECA is rejected
+	der, _, err := primitives.NewSelfSignedCert()
+	if err != nil {
+		t.Fatalf("Failed generating self-signed cert [%s].", err)
+	}
+	forged := func(ctx context.Context, id []byte) ([][]byte, error) {
+		return [][]byte{der}, nil
+	}
+	if _, err := v.getEnrollmentCertFromPeer(context.Background(), primitives.Hash(der), forged); err == nil {
+		t.Fatal("A certificate not issued by the ECA should be rejected.")
+	}
+
+	// A peer not answering in time is given up
+	v.conf.eCertExchangeTimeout = 10 * time.Millisecond
+	defer func() { v.conf.eCertExchangeTimeout = 2 * time.Second }()
+	silent := func(ctx context.Context, id []byte) ([][]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if _, err := v.getEnrollmentCertFromPeer(context.Background(), peer.GetID(), silent); err == nil {
+		t.Fatal("A peer not answering should be given up.")
+	}
+
+	// The cert of peer, dropped from the cert store, is fetched from peer
+	msg := []byte("Hello World!!!")
+	signature, err := peer.Sign(msg)
+	if err != nil {
+		t.Fatalf("Failed generating signature [%s].", err)
+	}
+	if err := validator.DeleteEnrollmentCert(peer.GetID()); err != nil {
+		t.Fatalf("Failed deleting enrollment cert [%s].", err)
+	}
+	v.deleteNodeEnrollmentCertificate(utils.EncodeBase64(peer.GetID()))
+	served = 0
+	if err := validator.Verify(peer.GetID(), signature, msg); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+	if served != 1 {
+		t.Fatalf("The enrollment certificate should have been requested from the peer once, requested [%d] times.", served)
+	}
+}
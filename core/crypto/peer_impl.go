@@ -17,22 +17,47 @@ limitations under the License.
 package crypto
 
 import (
-	"crypto/ecdsa"
 	"crypto/x509"
 	"fmt"
 	"sync"
 
-	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/idemix"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	obc "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
 )
 
 type peerImpl struct {
 	*nodeImpl
 
-	nodeEnrollmentCertificatesMutex sync.RWMutex
-	nodeEnrollmentCertificates      map[string]*x509.Certificate
+	nodeEnrollmentCertificates *certLRU
+
+	// Certificates of transactions whose chain was verified, by fingerprint
+	verifiedCerts *certLRU
+
+	revokedCertsMutex sync.RWMutex
+	revokedCerts      map[string]bool
+
+	// CRL polling task
+	crlPollStop chan struct{}
+	crlPollDone chan struct{}
+
+	// Revocation feed subscription
+	revocationFeedCancel context.CancelFunc
+	revocationFeedDone   chan struct{}
+
+	// OCSP statuses of enrollment certificates, by issuer and serial number
+	ocspMutex    sync.RWMutex
+	ocspStatuses map[string]*ocspStatus
+	ocspIssuer   *x509.Certificate
+
+	// Issuer of the anonymous credentials accepted, if any
+	idemixIssuer *idemix.IssuerPublicKey
+
+	// Requests enrollment certificates from the peers themselves, if set
+	certSourceMutex sync.RWMutex
+	certSource      EnrollmentCertSource
 
 	isInitialized bool
 }
@@ -41,6 +66,9 @@ type peerImpl struct {
 
 // GetID returns this peer's identifier
 func (peer *peerImpl) GetID() []byte {
+	peer.enrollMutex.RLock()
+	defer peer.enrollMutex.RUnlock()
+
 	return utils.Clone(peer.id)
 }
 
@@ -60,37 +88,21 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 	//	peer.debug("Pre validating [%s].", tx.String())
 	peer.Debugf("Tx confdential level [%s].", tx.ConfidentialityLevel.String())
 
-	if tx.Cert != nil && tx.Signature != nil {
-		// Verify the transaction
-		// 1. Unmarshal cert
-		cert, err := primitives.DERToX509Certificate(tx.Cert)
-		if err != nil {
-			peer.Errorf("TransactionPreExecution: failed unmarshalling cert [%s].", err.Error())
+	if tx.Cert != nil && tx.Signature != nil && isIdemixCert(tx.Cert) {
+		// Verify the proof of possession of an anonymous credential
+		if _, err := peer.verifyIdemixTransaction(tx, peer.idemixIssuer); err != nil {
+			peer.Errorf("TransactionPreExecution: failed verifying idemix signature [%s].", err.Error())
 			return tx, err
 		}
-
-		// TODO: verify cert
-
-		// 3. Marshall tx without signature
-		signature := tx.Signature
-		tx.Signature = nil
-		rawTx, err := proto.Marshal(tx)
+	} else if tx.Cert != nil && tx.Signature != nil {
+		// Verify the transaction
+		cert, err := peer.checkTransactionCert(tx.Cert)
 		if err != nil {
-			peer.Errorf("TransactionPreExecution: failed marshaling tx [%s].", err.Error())
 			return tx, err
 		}
-		tx.Signature = signature
-
-		// 2. Verify signature
-		ok, err := peer.verify(cert.PublicKey, rawTx, tx.Signature)
-		if err != nil {
-			peer.Errorf("TransactionPreExecution: failed marshaling tx [%s].", err.Error())
+		if err := peer.verifyTransactionSignature(tx, cert); err != nil {
 			return tx, err
 		}
-
-		if !ok {
-			return tx, utils.ErrInvalidTransactionSignature
-		}
 	} else {
 		if tx.Cert == nil {
 			return tx, utils.ErrTransactionCertificate
@@ -104,6 +116,22 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 	return tx, nil
 }
 
+// TransactionsPreValidation runs TransactionPreValidation on the
+// transactions of txs as a batch
+func (peer *peerImpl) TransactionsPreValidation(txs []*obc.Transaction) ([]*obc.Transaction, []error) {
+	return txs, peer.VerifyTransactionsBatch(txs)
+}
+
+// VerifyTransactionsBatch verifies the certificates and signatures of txs
+// as a batch. The i-th error is the outcome for txs[i].
+func (peer *peerImpl) VerifyTransactionsBatch(txs []*obc.Transaction) []error {
+	if !peer.isInitialized {
+		return batchErrors(len(txs), utils.ErrNotInitialized)
+	}
+
+	return peer.verifyTransactionsBatch(txs)
+}
+
 // TransactionPreValidation verifies that the transaction is
 // well formed with the respect to the security layer
 // prescriptions (i.e. signature verification). If this is the case,
@@ -132,24 +160,28 @@ func (peer *peerImpl) Verify(vkID, signature, message []byte) error {
 		return fmt.Errorf("Invalid message. It is empty.")
 	}
 
-	cert, err := peer.getEnrollmentCert(vkID)
+	ctx, cancel := peer.newCertFetchContext()
+	defer cancel()
+
+	cert, err := peer.getEnrollmentCert(ctx, vkID)
 	if err != nil {
 		peer.Errorf("Failed getting enrollment cert for [% x]: [%s]", vkID, err)
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(vkID), err.Error())
 
 		return err
 	}
 
-	vk := cert.PublicKey.(*ecdsa.PublicKey)
-
-	ok, err := peer.verify(vk, message, signature)
+	ok, err := peer.verify(cert.PublicKey, message, signature)
 	if err != nil {
 		peer.Errorf("Failed verifying signature for [% x]: [%s]", vkID, err)
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(vkID), err.Error())
 
 		return err
 	}
 
 	if !ok {
 		peer.Errorf("Failed invalid signature for [% x]", vkID)
+		peer.ks.audit(AuditVerifyFailure, utils.EncodeBase64(vkID), utils.ErrInvalidSignature.Error())
 
 		return utils.ErrInvalidSignature
 	}
@@ -161,10 +193,53 @@ func (peer *peerImpl) GetStateEncryptor(deployTx, invokeTx *obc.Transaction) (St
 	return nil, utils.ErrNotImplemented
 }
 
+func (peer *peerImpl) GrantPayloadAccess(tx *obc.Transaction, request []byte) ([]byte, error) {
+	return nil, utils.ErrNotImplemented
+}
+
 func (peer *peerImpl) GetTransactionBinding(tx *obc.Transaction) ([]byte, error) {
 	return primitives.Hash(append(tx.Cert, tx.Nonce...)), nil
 }
 
+// DeleteEnrollmentCert drops the cached enrollment certificate of
+// the peer identified by id
+func (peer *peerImpl) DeleteEnrollmentCert(id []byte) error {
+	if !peer.isInitialized {
+		return utils.ErrNotInitialized
+	}
+
+	if err := peer.ks.DeleteEnrollmentCert(id); err != nil {
+		return err
+	}
+	peer.deleteNodeEnrollmentCertificate(utils.EncodeBase64(id))
+
+	return nil
+}
+
+// PurgeEnrollmentCerts drops the cached enrollment certificates
+// selected by filter
+func (peer *peerImpl) PurgeEnrollmentCerts(filter CertFilter) (int, error) {
+	if !peer.isInitialized {
+		return 0, utils.ErrNotInitialized
+	}
+
+	ids, err := peer.ks.Purge(filter)
+	for _, id := range ids {
+		peer.deleteNodeEnrollmentCertificate(utils.EncodeBase64(id))
+	}
+
+	return len(ids), err
+}
+
+// ListCertificates describes the cached certificates selected by filter
+func (peer *peerImpl) ListCertificates(filter *CertInfoFilter) ([]CertInfo, error) {
+	if !peer.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	return peer.ks.listCertificates(filter)
+}
+
 // Private methods
 
 func (peer *peerImpl) register(eType NodeType, name string, pwd []byte, enrollID, enrollPWD string) error {
@@ -207,15 +282,49 @@ func (peer *peerImpl) init(eType NodeType, id string, pwd []byte) error {
 	}
 	peer.Debug("Init keystore...done.")
 
+	// Load revoked certs
+	if err := peer.initRevokedCerts(); err != nil {
+		peer.Errorf("Failed loading revoked certs [%s].", err)
+
+		return err
+	}
+
+	// Load the issuer of the enrollment certs checked with OCSP
+	if err := peer.initOCSP(); err != nil {
+		peer.Errorf("Failed initializing OCSP checks [%s].", err)
+
+		return err
+	}
+
+	// Load the issuer of anonymous credentials
+	if path := peer.conf.getIdemixIssuerPublicKeyPath(); path != "" {
+		if peer.idemixIssuer, err = loadIdemixIssuer(path); err != nil {
+			peer.Errorf("Failed loading idemix issuer public key [%s].", err)
+
+			return err
+		}
+	}
+
 	// initialized
 	peer.isInitialized = true
 
 	// EnrollCerts
-	peer.nodeEnrollmentCertificates = make(map[string]*x509.Certificate)
+	peer.nodeEnrollmentCertificates = newCertLRU(peer.conf.getCertHotCacheSize())
+	peer.verifiedCerts = newCertLRU(peer.conf.getCertVerifyCacheSize())
+
+	if peer.conf.isCRLPollingEnabled() {
+		peer.startCRLPoller(peer.conf.getCRLPollingInterval())
+	}
+	if peer.conf.isCRLFeedEnabled() {
+		peer.startRevocationFeed(peer.conf.getCRLFeedRetryInterval())
+	}
 
 	return nil
 }
 
 func (peer *peerImpl) close() error {
+	peer.stopCRLPoller()
+	peer.stopRevocationFeed()
+
 	return peer.nodeImpl.close()
 }
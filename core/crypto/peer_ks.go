@@ -17,117 +17,430 @@ limitations under the License.
 package crypto
 
 import (
-	"database/sql"
+	"bytes"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"golang.org/x/net/context"
 )
 
 func (peer *peerImpl) initKeyStore() error {
-	// create tables
-	peer.Debugf("Create Table [%s] if not exists", "Certificates")
-	if _, err := peer.ks.sqlDB.Exec("CREATE TABLE IF NOT EXISTS Certificates (id VARCHAR, certsign BLOB, certenc BLOB, PRIMARY KEY (id))"); err != nil {
-		peer.Debugf("Failed creating table [%s].", err.Error())
+	// Open the cert store
+	if err := peer.ks.openCertStore(); err != nil {
 		return err
 	}
 
+	if peer.conf.getCertStoreMaxEntries() > 0 && !peer.conf.isKeyStoreReadOnly() {
+		peer.ks.startCertStoreTrimmer(peer.conf.getCertStoreMaxEntries(), peer.conf.getCertStoreTrimInterval())
+	}
+
 	return nil
 }
 
-func (ks *keyStore) GetSignEnrollmentCert(id []byte, certFetcher func(id []byte) ([]byte, []byte, error)) ([]byte, error) {
-	if len(id) == 0 {
-		return nil, fmt.Errorf("Invalid peer id. It is empty.")
+func (ks *keyStore) openCertStore() error {
+	conf := ks.node.conf
+	if ks.node.opts.CertStore != nil {
+		ks.node.Debug("Open injected cert store.")
+		ks.certStore = ks.node.opts.CertStore
+		ks.certFetches = make(map[string]*certFetch)
+
+		return nil
 	}
 
+	ks.node.Debugf("Open cert store [%s] at [%s].", conf.getCertStoreBackend(), conf.getKeyStorePath())
+	certStore, err := newCertStore(conf.getCertStoreBackend(), &CertStoreConfig{
+		Name:        conf.name,
+		Owner:       conf.getKeyStoreOwner(),
+		ReadOnly:    conf.isKeyStoreReadOnly(),
+		Path:        conf.getKeyStorePath(),
+		DBPath:      conf.getKeyStoreDBPath(),
+		Filename:    conf.getKeyStoreFilename(),
+		BusyTimeout: conf.getKeyStoreBusyTimeout(),
+		JournalMode: conf.getKeyStoreJournalMode(),
+		Synchronous: conf.getKeyStoreSynchronous(),
+		DBKey:       conf.getKeyStoreDBKey(),
+	})
+	if err != nil {
+		ks.node.Errorf("Failed opening cert store [%s].", err.Error())
+		return err
+	}
+	ks.certStore = certStore
+	ks.certFetches = make(map[string]*certFetch)
+
+	return nil
+}
+
+// startCertStoreTrimmer bounds the cert store to maxEntries,
+// evicting the least recently used entries every interval
+func (ks *keyStore) startCertStoreTrimmer(maxEntries int, interval time.Duration) {
+	ks.node.Debugf("Trimming cert store to [%d] entries every [%s].", maxEntries, interval)
+
+	ks.certStoreTrimStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ks.trimCertStore(maxEntries)
+			case <-stop:
+				return
+			}
+		}
+	}(ks.certStoreTrimStop)
+}
+
+func (ks *keyStore) trimCertStore(maxEntries int) {
 	ks.m.Lock()
 	defer ks.m.Unlock()
 
+	if ks.isClosed {
+		return
+	}
+
+	evicted, err := ks.certStore.Trim(maxEntries)
+	if err != nil {
+		ks.node.Errorf("Failed trimming cert store [%s].", err.Error())
+		return
+	}
+	if evicted > 0 {
+		certCacheEvictions.add(uint64(evicted))
+		ks.node.Debugf("Evicted [%d] certs from the cert store.", evicted)
+	}
+}
+
+// certFetch is an ECA fetch shared by the concurrent lookups of the same id
+type certFetch struct {
+	done     chan struct{}
+	certSign []byte
+	err      error
+}
+
+// GetSignEnrollmentCert returns the signing enrollment cert of id, fetching it
+// with certFetcher if not in the cert store. The lookup gives up as soon as ctx
+// is done, and ctx is passed to certFetcher to cancel an in-flight fetch.
+// Concurrent lookups missing the same id share a single fetch and store,
+// bound by the ctx of the first of them.
+func (ks *keyStore) GetSignEnrollmentCert(ctx context.Context, id []byte, certFetcher func(ctx context.Context, id []byte) ([]byte, []byte, error)) ([]byte, error) {
+	if len(id) == 0 {
+		return nil, fmt.Errorf("Invalid peer id. It is empty.")
+	}
+
 	sid := utils.EncodeBase64(id)
 
-	certSign, certEnc, err := ks.selectSignEnrollmentCert(sid)
+	ks.m.Lock()
+	entry, err := ks.selectSignEnrollmentCert(ctx, sid)
 	if err != nil {
+		ks.m.Unlock()
 		ks.node.Errorf("Failed selecting enrollment cert [%s].", err.Error())
 
 		return nil, err
 	}
 
-	if certSign == nil {
-		ks.node.Debugf("Cert for [%s] not available. Fetching from ECA....", sid)
+	if entry != nil && ks.node.conf.isCertRenewalDue(entry.NotAfter) {
+		ks.node.Debugf("Cert for [%s] expired or about to expire [%s]. Fetching from ECA....", sid, entry.NotAfter)
+
+		entry = nil
+	}
+
+	if entry != nil {
+		ks.m.Unlock()
+		certCacheHits.inc()
+		ks.node.Debugf("Cert for [%s] = [% x]", sid, entry.CertSign)
 
-		// If No cert is available, fetch from ECA
+		return entry.CertSign, nil
+	}
 
-		// 1. Fetch
-		ks.node.Debug("Fectch Enrollment Certificate from ECA...")
-		certSign, certEnc, err = certFetcher(id)
-		if err != nil {
-			return nil, err
-		}
+	certCacheMisses.inc()
+	fetch, inFlight := ks.certFetches[sid]
+	if !inFlight {
+		fetch = &certFetch{done: make(chan struct{})}
+		ks.certFetches[sid] = fetch
+	}
+	ks.m.Unlock()
 
-		// 2. Store
-		ks.node.Debug("Store certificate...")
-		tx, err := ks.sqlDB.Begin()
-		if err != nil {
-			ks.node.Errorf("Failed beginning transaction [%s].", err.Error())
+	if inFlight {
+		ks.node.Debugf("Cert for [%s] already being fetched from ECA. Waiting...", sid)
+		certFetchesShared.inc()
 
-			return nil, err
+		select {
+		case <-fetch.done:
+			return fetch.certSign, fetch.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
+	}
 
-		ks.node.Debugf("Insert id [%s].", sid)
-		ks.node.Debugf("Insert cert [% x].", certSign)
+	fetch.certSign, fetch.err = ks.fetchSignEnrollmentCert(ctx, id, sid, certFetcher)
 
-		_, err = tx.Exec("INSERT INTO Certificates (id, certsign, certenc) VALUES (?, ?, ?)", sid, certSign, certEnc)
+	ks.m.Lock()
+	delete(ks.certFetches, sid)
+	ks.m.Unlock()
+	close(fetch.done)
 
-		if err != nil {
-			ks.node.Errorf("Failed inserting cert [%s].", err.Error())
+	return fetch.certSign, fetch.err
+}
+
+// DeleteEnrollmentCert removes the cert of id from the cert store
+func (ks *keyStore) DeleteEnrollmentCert(id []byte) error {
+	if len(id) == 0 {
+		return fmt.Errorf("Invalid peer id. It is empty.")
+	}
 
-			tx.Rollback()
+	if err := ks.checkWritable(); err != nil {
+		return err
+	}
 
-			return nil, err
-		}
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	sid := utils.EncodeBase64(id)
+	ks.node.Debugf("Deleting cert for [%s].", sid)
+
+	if err := ks.certStore.Delete(sid); err != nil {
+		ks.node.Errorf("Failed deleting cert for [%s]: [%s].", sid, err.Error())
+		return err
+	}
+
+	return nil
+}
 
-		err = tx.Commit()
+// Purge removes from the cert store the certs selected by filter,
+// and returns the ids of the removed ones
+func (ks *keyStore) Purge(filter CertFilter) ([][]byte, error) {
+	if filter == nil {
+		return nil, utils.ErrNilArgument
+	}
+	if err := ks.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	ks.node.Debug("Purging cert store...")
+
+	selected := []string{}
+	err := ks.certStore.ForEach(func(sid string, entry *CertEntry) error {
+		id, err := utils.DecodeBase64(sid)
+		if err != nil {
+			return err
+		}
+		certSign, err := ks.decryptBlob(entry.CertSign)
 		if err != nil {
-			ks.node.Errorf("Failed committing transaction [%s].", err.Error())
+			return err
+		}
+		x509Cert, err := primitives.DERToX509Certificate(certSign)
+		if err != nil {
+			ks.node.Errorf("Failed parsing cert for [%s]: [%s].", sid, err.Error())
+			return err
+		}
 
-			tx.Rollback()
+		if filter(id, x509Cert) {
+			selected = append(selected, sid)
+		}
 
-			return nil, err
+		return nil
+	})
+	if err != nil {
+		ks.node.Errorf("Failed scanning cert store [%s].", err.Error())
+		return nil, err
+	}
+
+	ids := [][]byte{}
+	for _, sid := range selected {
+		if err := ks.certStore.Delete(sid); err != nil {
+			ks.node.Errorf("Failed deleting cert for [%s]: [%s].", sid, err.Error())
+			return ids, err
 		}
+		id, _ := utils.DecodeBase64(sid)
+		ids = append(ids, id)
+	}
 
-		ks.node.Debug("Fectch Enrollment Certificate from ECA...done!")
+	ks.node.Debugf("Purging cert store...done! Removed [%d] certs.", len(ids))
 
-		certSign, certEnc, err = ks.selectSignEnrollmentCert(sid)
-		if err != nil {
-			ks.node.Errorf("Failed selecting next TCert after fetching [%s].", err.Error())
+	return ids, nil
+}
+
+// fetchSignEnrollmentCert fetches the signing enrollment cert of id from the
+// ECA and stores it in the cert store. The keystore is not locked during the
+// fetch.
+func (ks *keyStore) fetchSignEnrollmentCert(ctx context.Context, id []byte, sid string, certFetcher func(ctx context.Context, id []byte) ([]byte, []byte, error)) ([]byte, error) {
+	ks.node.Debugf("Cert for [%s] not available. Fetching from ECA....", sid)
+
+	// A fetched cert could not be stored
+	if err := ks.checkWritable(); err != nil {
+		ks.node.Errorf("Cannot fetch cert for [%s] [%s].", sid, err)
+		return nil, err
+	}
+
+	// 1. Fetch
+	ks.node.Debug("Fectch Enrollment Certificate from ECA...")
+	start := time.Now()
+	certSign, certEnc, err := ks.fetchWithRetry(ctx, id, sid, certFetcher)
+	ecaFetchLatency.since(start)
+	if err != nil {
+		ks.audit(AuditECAFetch, sid, err.Error())
+		return nil, err
+	}
+	ks.audit(AuditECAFetch, sid, "")
+
+	// 2. Validate
+	x509Cert, err := ks.validateFetchedCert(id, certSign, certEnc)
+	if err != nil {
+		ks.node.Errorf("Invalid cert fetched for [%s] [%s].", sid, err.Error())
+		certValidationFailures.inc()
+		ks.audit(AuditVerifyFailure, sid, err.Error())
+		return nil, err
+	}
+
+	// 3. Store
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if ks.isClosed {
+		return nil, utils.ErrKeyStoreClosed
+	}
+
+	ks.node.Debug("Store certificate...")
+	ks.node.Debugf("Insert id [%s].", sid)
+	ks.node.Debugf("Insert cert [% x].", certSign)
+
+	encCertSign, err := ks.encryptBlob(certSign)
+	if err != nil {
+		return nil, err
+	}
+	encCertEnc, err := ks.encryptBlob(certEnc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		ks.node.Debugf("Giving up storing cert for [%s] [%s].", sid, err)
+		return nil, err
+	}
+
+	entry := &CertEntry{CertSign: encCertSign, CertEnc: encCertEnc}
+	entry.setMetadata(CertTypeECert, x509Cert)
+	err = ks.certStore.Put(sid, entry)
+	if err != nil {
+		ks.node.Errorf("Failed inserting cert [%s].", err.Error())
+		certInsertFailures.inc()
+		return nil, err
+	}
+	ks.audit(AuditCacheInsert, sid, "")
+
+	ks.node.Debug("Fectch Enrollment Certificate from ECA...done!")
+
+	entry, err = ks.selectSignEnrollmentCert(ctx, sid)
+	if err != nil {
+		ks.node.Errorf("Failed selecting next TCert after fetching [%s].", err.Error())
+
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("Cert for [%s] not found after store.", sid)
+	}
+
+	ks.node.Debugf("Cert for [%s] = [% x]", sid, entry.CertSign)
+
+	return entry.CertSign, nil
+}
+
+// validateFetchedCert checks that certSign, fetched for id, is the cert of id
+// and chains to the ECA certificates chain, and returns it parsed
+func (ks *keyStore) validateFetchedCert(id, certSign, certEnc []byte) (*x509.Certificate, error) {
+	x509Cert, err := primitives.DERToX509Certificate(certSign)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ids are the hashes of the certs, as indexed by the ECA
+	if !bytes.Equal(primitives.Hash(certSign), id) {
+		return nil, errors.New("Certificate does not match the requested id.")
+	}
 
+	markFabricExtensionsHandled(x509Cert)
+
+	ecaCertPool, err := ks.getECACertPool()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := primitives.CheckCertAgainRoot(x509Cert, ecaCertPool); err != nil {
+		return nil, err
+	}
+
+	if len(certEnc) != 0 {
+		if _, err := primitives.DERToX509Certificate(certEnc); err != nil {
 			return nil, err
 		}
 	}
 
-	ks.node.Debugf("Cert for [%s] = [% x]", sid, certSign)
+	return x509Cert, nil
+}
+
+// getECACertPool returns the ECA certificates chain of the node or, if the
+// crypto engine of the node is not initialized, the one in the keystore
+func (ks *keyStore) getECACertPool() (*x509.CertPool, error) {
+	if ks.node.ecaCertPool != nil {
+		return ks.node.ecaCertPool, nil
+	}
+
+	certs, err := ks.loadCertsChain(ks.node.conf.getECACertsChainFilename())
+	if err != nil {
+		ks.node.Errorf("Failed loading ECA certificates chain [%s].", err.Error())
+		return nil, err
+	}
+	ecaCertPool := x509.NewCertPool()
+	for _, cert := range certs {
+		ecaCertPool.AddCert(cert)
+	}
 
-	return certSign, nil
+	return ecaCertPool, nil
 }
 
-func (ks *keyStore) selectSignEnrollmentCert(id string) ([]byte, []byte, error) {
+func (ks *keyStore) selectSignEnrollmentCert(ctx context.Context, id string) (*CertEntry, error) {
 	ks.node.Debugf("Select Sign Enrollment Cert for id [%s]", id)
 
-	// Get the first row available
-	var cert []byte
-	row := ks.sqlDB.QueryRow("SELECT certsign FROM Certificates where id = ?", id)
-	err := row.Scan(&cert)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, nil, nil
-	} else if err != nil {
+	entry, err := ks.certStore.Get(id)
+	if err != nil {
 		ks.node.Errorf("Error during select [%s].", err.Error())
 
-		return nil, nil, err
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if entry.CertSign, err = ks.decryptBlob(entry.CertSign); err != nil {
+		return nil, err
+	}
+	if entry.CertEnc, err = ks.decryptBlob(entry.CertEnc); err != nil {
+		return nil, err
+	}
+
+	if entry.NotAfter.IsZero() {
+		// Entry stored before expiries were recorded
+		x509Cert, err := primitives.DERToX509Certificate(entry.CertSign)
+		if err != nil {
+			ks.node.Errorf("Failed parsing cert [%s].", err.Error())
+			return nil, err
+		}
+		entry.NotAfter = x509Cert.NotAfter
 	}
 
-	ks.node.Debugf("Cert [% x].", cert)
+	ks.node.Debugf("Cert [% x].", entry.CertSign)
 
 	ks.node.Debug("Select Enrollment Cert...done!")
 
-	return cert, nil, nil
+	return entry, nil
 }
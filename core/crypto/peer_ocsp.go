@@ -0,0 +1,265 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// On top of the CRLs, the enrollment certificates of counterparties are
+// checked with OCSP, so that a revocation applies before the ECA publishes
+// its next CRL. Statuses come either stapled, handed to ProcessOCSPResponse,
+// or live from the OCSP responder when security.ocsp.enabled is set. They
+// are cached, keyed by issuer and serial number as the revoked certs, until
+// their next update and at most security.ocsp.cacheTTL. A certificate
+// reported revoked is recorded with the revoked certs, as if listed in a
+// CRL. When no status can be obtained, security.ocsp.policy decides:
+// failopen accepts the certificate, failclosed rejects it.
+
+// Public Methods
+
+// ProcessOCSPResponse applies the OCSP response resp, DER encoded and
+// signed by the ECA or a responder it delegated OCSP signing to. It
+// returns the number of statuses of enrollment certs applied.
+func (peer *peerImpl) ProcessOCSPResponse(resp []byte) (int, error) {
+	if !peer.isInitialized {
+		return 0, utils.ErrNotInitialized
+	}
+	if len(resp) == 0 {
+		return 0, utils.ErrNilArgument
+	}
+
+	statuses, err := peer.applyOCSPResponse(resp)
+	if err != nil {
+		peer.Errorf("Failed processing OCSP response [%s].", err.Error())
+		return 0, err
+	}
+
+	return len(statuses), nil
+}
+
+// Private Methods
+
+func (peer *peerImpl) initOCSP() error {
+	peer.ocspStatuses = make(map[string]*ocspStatus)
+
+	ecaCert, _, err := peer.ks.loadCertX509AndDer(peer.conf.getECACertsChainFilename())
+	if err != nil {
+		if peer.conf.isOCSPEnabled() {
+			return err
+		}
+		peer.Debugf("No ECA certificate, OCSP responses will be ignored [%s].", err)
+		return nil
+	}
+	peer.ocspIssuer = ecaCert
+
+	return nil
+}
+
+// checkOCSP checks the OCSP status of cert, if an enrollment cert
+func (peer *peerImpl) checkOCSP(cert *x509.Certificate) error {
+	if peer.ocspIssuer == nil || !bytes.Equal(cert.RawIssuer, peer.ocspIssuer.RawSubject) {
+		return nil
+	}
+
+	status, ok := peer.getOCSPStatus(cert)
+	if ok {
+		ocspCacheHits.inc()
+	} else {
+		if !peer.conf.isOCSPEnabled() {
+			return nil
+		}
+
+		var err error
+		if status, err = peer.fetchOCSPStatus(cert); err != nil {
+			return peer.onOCSPStatusUnavailable(cert, err)
+		}
+	}
+
+	switch status {
+	case primitives.OCSPRevoked:
+		peer.Errorf("Certificate [%s] revoked according to OCSP.", cert.SerialNumber)
+		return utils.ErrCertRevoked
+	case primitives.OCSPUnknown:
+		return peer.onOCSPStatusUnavailable(cert, errOCSPUnknownCert)
+	}
+
+	return nil
+}
+
+// onOCSPStatusUnavailable applies the OCSP policy to cert,
+// whose status could not be obtained because of err
+func (peer *peerImpl) onOCSPStatusUnavailable(cert *x509.Certificate, err error) error {
+	ocspFailures.inc()
+
+	if peer.conf.isOCSPFailClosed() {
+		peer.Errorf("No OCSP status of certificate [%s] [%s]. Rejecting it.", cert.SerialNumber, err)
+		return utils.ErrCertStatusUnavailable
+	}
+	peer.Warningf("No OCSP status of certificate [%s] [%s]. Accepting it.", cert.SerialNumber, err)
+
+	return nil
+}
+
+// fetchOCSPStatus asks the OCSP responder for the status of cert
+func (peer *peerImpl) fetchOCSPStatus(cert *x509.Certificate) (int, error) {
+	url := peer.conf.getOCSPResponder()
+	if url == "" {
+		if len(cert.OCSPServer) == 0 {
+			return 0, errNoOCSPResponder
+		}
+		url = cert.OCSPServer[0]
+	}
+
+	req, err := primitives.CreateOCSPRequest(cert, peer.ocspIssuer)
+	if err != nil {
+		return 0, err
+	}
+
+	peer.Debugf("Requesting OCSP status of certificate [%s] from [%s]...", cert.SerialNumber, url)
+	ocspRequests.inc()
+
+	client := &http.Client{Timeout: peer.conf.getOCSPTimeout()}
+	httpResp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("OCSP responder replied [%s].", httpResp.Status)
+	}
+	resp, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return 0, err
+	}
+
+	statuses, err := peer.applyOCSPResponse(resp)
+	if err != nil {
+		return 0, err
+	}
+	for _, status := range statuses {
+		if status.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			peer.Debugf("Requesting OCSP status of certificate [%s] from [%s]...done! Status [%d].", cert.SerialNumber, url, status.Status)
+			return status.Status, nil
+		}
+	}
+
+	return 0, errOCSPNoStatus
+}
+
+// applyOCSPResponse caches the current statuses of enrollment certs in
+// resp, records the revoked ones and returns the statuses cached
+func (peer *peerImpl) applyOCSPResponse(resp []byte) ([]*primitives.OCSPResponse, error) {
+	if peer.ocspIssuer == nil {
+		return nil, errOCSPIssuerMissing
+	}
+
+	responses, err := primitives.ParseOCSPResponse(resp, peer.ocspIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := utils.EncodeBase64(peer.ocspIssuer.RawSubject)
+	now := time.Now()
+	applied := []*primitives.OCSPResponse{}
+	revoked := []pkix.RevokedCertificate{}
+
+	peer.ocspMutex.Lock()
+	for _, response := range responses {
+		expiry := now.Add(peer.conf.getOCSPCacheTTL())
+		if !response.NextUpdate.IsZero() && response.NextUpdate.Before(expiry) {
+			expiry = response.NextUpdate
+		}
+		if !expiry.After(now) || response.ThisUpdate.After(now.Add(ocspClockSkew)) {
+			peer.Debugf("Skipping OCSP status of certificate [%s] not current.", response.SerialNumber)
+			continue
+		}
+
+		peer.ocspStatuses[revokedCertKey(issuer, response.SerialNumber.String())] = &ocspStatus{status: response.Status, expiry: expiry}
+		if response.Status == primitives.OCSPRevoked {
+			revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: response.SerialNumber, RevocationTime: response.RevokedAt})
+		}
+		applied = append(applied, response)
+	}
+	peer.ocspMutex.Unlock()
+
+	if len(revoked) != 0 {
+		if _, err := peer.revokeCerts(issuer, revoked); err != nil {
+			peer.Errorf("Failed recording certificates revoked according to OCSP [%s].", err.Error())
+		}
+	}
+
+	return applied, nil
+}
+
+// getOCSPStatus returns the cached OCSP status of cert, if any
+func (peer *peerImpl) getOCSPStatus(cert *x509.Certificate) (int, bool) {
+	key := revokedCertKey(utils.EncodeBase64(cert.RawIssuer), cert.SerialNumber.String())
+
+	peer.ocspMutex.RLock()
+	status, ok := peer.ocspStatuses[key]
+	peer.ocspMutex.RUnlock()
+	if !ok {
+		return 0, false
+	}
+
+	if !time.Now().Before(status.expiry) {
+		peer.ocspMutex.Lock()
+		delete(peer.ocspStatuses, key)
+		peer.ocspMutex.Unlock()
+
+		return 0, false
+	}
+
+	return status.status, true
+}
+
+// Private type and variables
+
+type ocspStatus struct {
+	status int
+	expiry time.Time
+}
+
+const (
+	ocspPolicyFailOpen   = "failopen"
+	ocspPolicyFailClosed = "failclosed"
+
+	// Bound of the size of the responses read from the OCSP responder
+	maxOCSPResponseSize = 1 << 20
+
+	// Tolerance on the clock of the OCSP responder
+	ocspClockSkew = 5 * time.Minute
+)
+
+var (
+	errNoOCSPResponder   = errors.New("No OCSP responder configured or named in the certificate.")
+	errOCSPNoStatus      = errors.New("OCSP response without the status of the certificate.")
+	errOCSPUnknownCert   = errors.New("Certificate unknown to the OCSP responder.")
+	errOCSPIssuerMissing = errors.New("ECA certificate not loaded.")
+)
@@ -0,0 +1,108 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"time"
+
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// When security.crl.feed.enabled is set, the peer subscribes to the
+// revocation feed of the ECA, which pushes the revocations of the ECA and
+// the TCA as they happen, so that revoked identities are blocked within
+// seconds instead of at the next poll of the CRLs. Each event carries the
+// new CRL of the CA, which is verified and applied as a polled one. The
+// peer subscribes again every security.crl.feed.retryInterval until the
+// feed is back; the current CRLs are sent first on each subscription, so
+// no revocation is missed while disconnected.
+
+// Private Methods
+
+// startRevocationFeed subscribes to the revocation feed of the ECA,
+// subscribing again retryInterval after the feed ends
+func (peer *peerImpl) startRevocationFeed(retryInterval time.Duration) {
+	peer.Debug("Subscribing to the revocation feed...")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	peer.revocationFeedCancel = cancel
+	peer.revocationFeedDone = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+
+		for {
+			if err := peer.watchRevocations(ctx); err != nil && ctx.Err() == nil {
+				crlFeedFailures.inc()
+				peer.Warningf("Revocation feed ended [%s]. Subscribing again in [%s].", err.Error(), retryInterval)
+			}
+
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}(peer.revocationFeedDone)
+}
+
+// stopRevocationFeed unsubscribes from the revocation feed, waiting for
+// a CRL being processed to be done
+func (peer *peerImpl) stopRevocationFeed() {
+	if peer.revocationFeedCancel == nil {
+		return
+	}
+
+	peer.revocationFeedCancel()
+	<-peer.revocationFeedDone
+	peer.revocationFeedCancel = nil
+}
+
+// watchRevocations applies the CRLs pushed by the revocation feed of the
+// ECA until the feed or ctx ends
+func (peer *peerImpl) watchRevocations(ctx context.Context) error {
+	sock, ecaP, err := peer.getECAClient()
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+
+	feed, err := ecaP.WatchRevocations(ctx, &membersrvc.Empty{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		event, err := feed.Recv()
+		if err != nil {
+			return err
+		}
+		crlFeedEvents.inc()
+
+		if event.Crl == nil {
+			continue
+		}
+		if _, err = peer.ProcessCRL(event.Crl.Crl); err != nil {
+			crlFeedFailures.inc()
+			peer.Errorf("Failed applying the CRL of the %s pushed by the revocation feed [%s].", event.Ca, err.Error())
+			continue
+		}
+		if len(event.Serials) > 0 {
+			peer.Debugf("Applied the revocation of [%d] certs by the %s.", len(event.Serials), event.Ca)
+		}
+	}
+}
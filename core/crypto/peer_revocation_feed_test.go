@@ -0,0 +1,50 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidatorRevocationFeed(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	events, failures := crlFeedEvents.get(), crlFeedFailures.get()
+
+	peer := validator.(*validatorImpl).peerImpl
+	peer.startRevocationFeed(100 * time.Millisecond)
+	defer peer.stopRevocationFeed()
+
+	// The current CRLs of the ECA and the TCA are pushed on subscription
+	deadline := time.Now().Add(5 * time.Second)
+	for crlFeedEvents.get()-events < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := crlFeedEvents.get() - events; n != 2 {
+		t.Fatalf("The CRLs of the ECA and the TCA should have been pushed, pushed [%d].", n)
+	}
+	if n := crlFeedFailures.get() - failures; n != 0 {
+		t.Fatalf("The CRLs of the ECA and the TCA should have been applied, [%d] failures.", n)
+	}
+
+	peer.stopRevocationFeed()
+	if peer.revocationFeedCancel != nil {
+		t.Fatal("The subscription to the revocation feed should have been stopped.")
+	}
+}
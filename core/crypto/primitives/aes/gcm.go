@@ -19,7 +19,6 @@ package aes
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"fmt"
 	"io"
 
@@ -118,7 +117,7 @@ func (spi *aes256GSMStreamCipherSPIImpl) GenerateKey() (primitives.SecretKey, er
 		return nil, err
 	}
 
-	return &aesSecretKeyImpl{key, rand.Reader}, nil
+	return &aesSecretKeyImpl{key, primitives.RandReader}, nil
 }
 
 func (spi *aes256GSMStreamCipherSPIImpl) GenerateKeyAndSerialize() (primitives.SecretKey, []byte, error) {
@@ -127,7 +126,7 @@ func (spi *aes256GSMStreamCipherSPIImpl) GenerateKeyAndSerialize() (primitives.S
 		return nil, nil, err
 	}
 
-	return &aesSecretKeyImpl{key, rand.Reader}, utils.Clone(key), nil
+	return &aesSecretKeyImpl{key, primitives.RandReader}, utils.Clone(key), nil
 }
 
 func (spi *aes256GSMStreamCipherSPIImpl) NewSecretKey(r io.Reader, params interface{}) (primitives.SecretKey, error) {
@@ -137,7 +136,7 @@ func (spi *aes256GSMStreamCipherSPIImpl) NewSecretKey(r io.Reader, params interf
 			return nil, fmt.Errorf("Invalid key lentgh. Len was [%d], expected [32].", len(t))
 		}
 		if r == nil {
-			r = rand.Reader
+			r = primitives.RandReader
 		}
 		return &aesSecretKeyImpl{t, r}, nil
 	default:
@@ -212,7 +211,7 @@ func (spi *aes256GSMStreamCipherSPIImpl) SerializeSecretKey(secret primitives.Se
 // DeserializePrivateKey deserializes to a private key
 func (spi *aes256GSMStreamCipherSPIImpl) DeserializeSecretKey(bytes []byte) (primitives.SecretKey, error) {
 	if len(bytes) >= 32 {
-		return &aesSecretKeyImpl{bytes[:32], rand.Reader}, nil
+		return &aesSecretKeyImpl{bytes[:32], primitives.RandReader}, nil
 	}
 	return nil, primitives.ErrInvalidKeyParameter
 }
@@ -0,0 +1,329 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Every key, nonce, IV and signature of the crypto layer draws its
+// randomness from RandReader, which reads from the source set by
+// SetRandomSource, crypto/rand by default. Deployments held to FIPS-style
+// operation set an HMACDRBG instead: an HMAC_DRBG of NIST SP 800-90A over
+// SHA-256, seeded and reseeded from an entropy source. The DRBG checks
+// itself against a known answer when instantiated, and runs two continuous
+// health tests: consecutive blocks of entropy, and consecutive output blocks,
+// must differ. A failed test, or an entropy source failing to deliver,
+// puts the DRBG in an error state it never leaves: every read fails, and
+// so does every operation needing randomness, until the DRBG is replaced.
+
+var (
+	// ErrEntropySource The entropy source of the DRBG failed
+	ErrEntropySource = errors.New("Entropy source failure.")
+
+	// ErrDRBGHealthTest The DRBG failed a health test
+	ErrDRBGHealthTest = errors.New("DRBG health test failure.")
+
+	// RandReader is the source of randomness of the crypto layer
+	RandReader io.Reader = randReader{}
+
+	randSourceMutex sync.RWMutex
+	randSource      io.Reader = rand.Reader
+
+	drbgSelfTestOnce sync.Once
+	drbgSelfTestErr  error
+)
+
+const (
+	// DefaultDRBGReseedInterval is the number of requests served by
+	// an HMACDRBG between two reseeds
+	DefaultDRBGReseedInterval = 10000
+
+	// drbgSeedLength is the length, in bytes, of the entropy inputs
+	drbgSeedLength = 32
+
+	// drbgNonceLength is the length, in bytes, of the nonce of instantiation
+	drbgNonceLength = 16
+
+	// drbgTestBlockLength is the length, in bytes, of the
+	// blocks of entropy the repetition test compares
+	drbgTestBlockLength = 16
+
+	// drbgMaxRequest is the largest request of SP 800-90A, 2^19 bits
+	drbgMaxRequest = 1 << 16
+)
+
+// SetRandomSource sets the source RandReader reads from.
+// A nil source restores crypto/rand.
+func SetRandomSource(source io.Reader) {
+	randSourceMutex.Lock()
+	defer randSourceMutex.Unlock()
+
+	if source == nil {
+		source = rand.Reader
+	}
+	randSource = source
+}
+
+// GetRandomSource returns the source RandReader reads from
+func GetRandomSource() io.Reader {
+	randSourceMutex.RLock()
+	defer randSourceMutex.RUnlock()
+
+	return randSource
+}
+
+type randReader struct{}
+
+// Read fills p from the random source, or fails
+func (randReader) Read(p []byte) (int, error) {
+	return io.ReadFull(GetRandomSource(), p)
+}
+
+// HMACDRBG is an HMAC_DRBG of NIST SP 800-90A over SHA-256, safe for
+// concurrent use
+type HMACDRBG struct {
+	m sync.Mutex
+
+	entropy        io.Reader
+	reseedInterval uint64
+
+	k, v          []byte
+	reseedCounter uint64
+
+	lastEntropy []byte
+	lastBlock   []byte
+	err         error
+}
+
+// NewHMACDRBG instantiates a DRBG seeded from entropy, crypto/rand if nil,
+// and personalization. reseedInterval is the number of requests served
+// between two reseeds, DefaultDRBGReseedInterval if not positive.
+func NewHMACDRBG(entropy io.Reader, personalization []byte, reseedInterval int) (*HMACDRBG, error) {
+	drbgSelfTestOnce.Do(func() {
+		drbgSelfTestErr = drbgSelfTest()
+	})
+	if drbgSelfTestErr != nil {
+		return nil, drbgSelfTestErr
+	}
+
+	return newHMACDRBG(entropy, personalization, reseedInterval)
+}
+
+// Read fills p with pseudo random bytes
+func (d *HMACDRBG) Read(p []byte) (int, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	n := 0
+	for n < len(p) {
+		end := n + drbgMaxRequest
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := d.generate(p[n:end]); err != nil {
+			return n, err
+		}
+		n = end
+	}
+
+	return n, nil
+}
+
+// Reseed mixes a fresh entropy input, and additional,
+// into the state of the DRBG
+func (d *HMACDRBG) Reseed(additional []byte) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	return d.reseed(additional)
+}
+
+// Err returns the error the DRBG is in, nil if it is operational
+func (d *HMACDRBG) Err() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return d.err
+}
+
+func newHMACDRBG(entropy io.Reader, personalization []byte, reseedInterval int) (*HMACDRBG, error) {
+	if entropy == nil {
+		entropy = rand.Reader
+	}
+	if reseedInterval <= 0 {
+		reseedInterval = DefaultDRBGReseedInterval
+	}
+
+	d := &HMACDRBG{
+		entropy:        entropy,
+		reseedInterval: uint64(reseedInterval),
+		k:              make([]byte, sha256.Size),
+		v:              bytes.Repeat([]byte{1}, sha256.Size),
+	}
+
+	seed, err := d.readEntropy(drbgSeedLength)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := d.readEntropy(drbgNonceLength)
+	if err != nil {
+		return nil, err
+	}
+	d.update(seed, nonce, personalization)
+	d.reseedCounter = 1
+
+	return d, nil
+}
+
+func (d *HMACDRBG) generate(out []byte) error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.reseedCounter > d.reseedInterval {
+		if err := d.reseed(nil); err != nil {
+			return err
+		}
+	}
+
+	mac := hmac.New(sha256.New, d.k)
+	for n := 0; n < len(out); {
+		mac.Reset()
+		mac.Write(d.v)
+		d.v = mac.Sum(d.v[:0])
+
+		// Continuous test: no two consecutive blocks are equal
+		if d.lastBlock != nil && bytes.Equal(d.v, d.lastBlock) {
+			d.err = ErrDRBGHealthTest
+			return d.err
+		}
+		d.lastBlock = append(d.lastBlock[:0], d.v...)
+
+		n += copy(out[n:], d.v)
+	}
+	d.update()
+	d.reseedCounter++
+
+	return nil
+}
+
+func (d *HMACDRBG) reseed(additional []byte) error {
+	seed, err := d.readEntropy(drbgSeedLength)
+	if err != nil {
+		return err
+	}
+	d.update(seed, additional)
+	d.reseedCounter = 1
+
+	return nil
+}
+
+// readEntropy reads an entropy input, failing the DRBG if the
+// source fails or repeats a block of entropy
+func (d *HMACDRBG) readEntropy(n int) ([]byte, error) {
+	input := make([]byte, n)
+	if _, err := io.ReadFull(d.entropy, input); err != nil {
+		d.err = ErrEntropySource
+		return nil, d.err
+	}
+
+	// Repetition test: no two consecutive blocks are equal
+	for i := 0; i+drbgTestBlockLength <= n; i += drbgTestBlockLength {
+		block := input[i : i+drbgTestBlockLength]
+		if d.lastEntropy != nil && bytes.Equal(block, d.lastEntropy) {
+			d.err = ErrDRBGHealthTest
+			return nil, d.err
+		}
+		d.lastEntropy = append(d.lastEntropy[:0], block...)
+	}
+
+	return input, nil
+}
+
+// update is the HMAC_DRBG_Update of SP 800-90A, on the
+// concatenation of provided
+func (d *HMACDRBG) update(provided ...[]byte) {
+	data := []byte{}
+	for _, p := range provided {
+		data = append(data, p...)
+	}
+
+	for _, b := range []byte{0, 1} {
+		mac := hmac.New(sha256.New, d.k)
+		mac.Write(d.v)
+		mac.Write([]byte{b})
+		mac.Write(data)
+		d.k = mac.Sum(nil)
+
+		mac = hmac.New(sha256.New, d.k)
+		mac.Write(d.v)
+		d.v = mac.Sum(nil)
+
+		if len(data) == 0 {
+			return
+		}
+	}
+}
+
+// drbgSelfTest checks the HMAC_DRBG against a known answer of the NIST CAVP
+func drbgSelfTest() error {
+	entropy, err := hex.DecodeString(drbgTestEntropy + drbgTestNonce)
+	if err != nil {
+		return err
+	}
+	d, err := newHMACDRBG(bytes.NewReader(entropy), nil, 0)
+	if err != nil {
+		return err
+	}
+
+	// The known answer is the output of the second request
+	out := make([]byte, len(drbgKnownAnswer)/2)
+	if err := d.generate(out); err != nil {
+		return err
+	}
+	if err := d.generate(out); err != nil {
+		return err
+	}
+	if hex.EncodeToString(out) != drbgKnownAnswer {
+		return ErrDRBGHealthTest
+	}
+
+	return nil
+}
+
+// The known answer test is COUNT = 0 of the SHA-256 HMAC_DRBG vectors of the
+// NIST CAVP without reseed: no personalization string, no additional input,
+// 1024 bits returned
+const (
+	drbgTestEntropy = "ca851911349384bffe89de1cbdc46e6831e44d34a4fb935ee285dd14b71a7488"
+	drbgTestNonce   = "659ba96c601dc69fc902940805ec0ca8"
+	drbgKnownAnswer = "e528e9abf2dece54d47c7e75e5fe302149f817ea9fb4bee6f4199697d04d5b89" +
+		"d54fbb978a15b5c443c9ec21036d2460b6f73ebad0dc2aba6e624abf07745bc1" +
+		"07694bb7547bb0995f70de25d6b29e2d3011bb19d27676c07162c8b5ccde0668" +
+		"961df86803482cb37ed6d5c0bb8d50cf1f50d476aa0458bdaba806f48be9dcb8"
+)
@@ -18,7 +18,6 @@ package primitives
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"encoding/asn1"
 	"math/big"
 )
@@ -30,14 +29,14 @@ type ECDSASignature struct {
 
 // NewECDSAKey generates a new ECDSA Key
 func NewECDSAKey() (*ecdsa.PrivateKey, error) {
-	return ecdsa.GenerateKey(GetDefaultCurve(), rand.Reader)
+	return ecdsa.GenerateKey(GetDefaultCurve(), RandReader)
 }
 
 // ECDSASignDirect signs
 func ECDSASignDirect(signKey interface{}, msg []byte) (*big.Int, *big.Int, error) {
 	temp := signKey.(*ecdsa.PrivateKey)
 	h := Hash(msg)
-	r, s, err := ecdsa.Sign(rand.Reader, temp, h)
+	r, s, err := ecdsa.Sign(RandReader, temp, h)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -47,9 +46,13 @@ func ECDSASignDirect(signKey interface{}, msg []byte) (*big.Int, *big.Int, error
 
 // ECDSASign signs
 func ECDSASign(signKey interface{}, msg []byte) ([]byte, error) {
+	if IsSecp256k1(signKey) {
+		return Secp256k1Sign(signKey, msg)
+	}
+
 	temp := signKey.(*ecdsa.PrivateKey)
 	h := Hash(msg)
-	r, s, err := ecdsa.Sign(rand.Reader, temp, h)
+	r, s, err := ecdsa.Sign(RandReader, temp, h)
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +72,10 @@ func ECDSASign(signKey interface{}, msg []byte) ([]byte, error) {
 
 // ECDSAVerify verifies
 func ECDSAVerify(verKey interface{}, msg, signature []byte) (bool, error) {
+	if IsSecp256k1(verKey) {
+		return Secp256k1Verify(verKey, msg, signature)
+	}
+
 	ecdsaSignature := new(ECDSASignature)
 	_, err := asn1.Unmarshal(signature, ecdsaSignature)
 	if err != nil {
@@ -22,7 +22,6 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/hmac"
-	"crypto/rand"
 	"errors"
 	"io"
 
@@ -41,7 +40,7 @@ func aesEncrypt(key, plain []byte) ([]byte, error) {
 
 	text := make([]byte, aes.BlockSize+len(plain))
 	iv := text[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	if _, err := io.ReadFull(primitives.RandReader, iv); err != nil {
 		return nil, err
 	}
 
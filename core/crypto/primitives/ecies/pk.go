@@ -18,7 +18,6 @@ package ecies
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/x509"
 	"io"
 
@@ -61,5 +60,5 @@ func (pks *publicKeySerializerImpl) FromBytes(bytes []byte) (interface{}, error)
 	}
 
 	// TODO: add params here
-	return &publicKeyImpl{key.(*ecdsa.PublicKey), rand.Reader, nil}, nil
+	return &publicKeyImpl{key.(*ecdsa.PublicKey), primitives.RandReader, nil}, nil
 }
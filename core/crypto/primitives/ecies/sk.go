@@ -18,7 +18,6 @@ package ecies
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/x509"
 	"io"
 
@@ -69,5 +68,5 @@ func (sks *secretKeySerializerImpl) FromBytes(bytes []byte) (interface{}, error)
 	}
 
 	// TODO: add params here
-	return &secretKeyImpl{key, nil, nil, rand.Reader}, nil
+	return &secretKeyImpl{key, nil, nil, primitives.RandReader}, nil
 }
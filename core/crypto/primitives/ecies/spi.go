@@ -19,7 +19,6 @@ package ecies
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"fmt"
 	"io"
 
@@ -28,7 +27,7 @@ import (
 
 func newKeyGeneratorParameter(r io.Reader, curve elliptic.Curve) (primitives.KeyGeneratorParameters, error) {
 	if r == nil {
-		r = rand.Reader
+		r = primitives.RandReader
 	}
 	return &keyGeneratorParameterImpl{r, curve, nil}, nil
 }
@@ -39,7 +38,7 @@ func newKeyGenerator() (primitives.KeyGenerator, error) {
 
 func newKeyGeneratorFromCurve(r io.Reader, curve elliptic.Curve) (primitives.KeyGenerator, error) {
 	if r == nil {
-		r = rand.Reader
+		r = primitives.RandReader
 	}
 	if curve == nil {
 		curve = primitives.GetDefaultCurve()
@@ -65,7 +64,7 @@ func newKeyGeneratorFromCurve(r io.Reader, curve elliptic.Curve) (primitives.Key
 
 func newPublicKeyFromECDSA(r io.Reader, pk *ecdsa.PublicKey) (primitives.PublicKey, error) {
 	if r == nil {
-		r = rand.Reader
+		r = primitives.RandReader
 	}
 	if pk == nil {
 		return nil, fmt.Errorf("Null ECDSA public key")
@@ -76,7 +75,7 @@ func newPublicKeyFromECDSA(r io.Reader, pk *ecdsa.PublicKey) (primitives.PublicK
 
 func newPrivateKeyFromECDSA(r io.Reader, sk *ecdsa.PrivateKey) (primitives.PrivateKey, error) {
 	if r == nil {
-		r = rand.Reader
+		r = primitives.RandReader
 	}
 	if sk == nil {
 		return nil, fmt.Errorf("Null ECDSA secret key")
@@ -137,7 +136,7 @@ func newAsymmetricCipher() (primitives.AsymmetricCipher, error) {
 
 func newPrivateKey(r io.Reader, curve elliptic.Curve) (primitives.PrivateKey, error) {
 	if r == nil {
-		r = rand.Reader
+		r = primitives.RandReader
 	}
 	if curve == nil {
 		curve = primitives.GetDefaultCurve()
@@ -17,13 +17,21 @@ limitations under the License.
 package primitives
 
 import (
+	"crypto"
 	"crypto/hmac"
 	"hash"
+	"sync"
 )
 
 var (
 	defaultHash          func() hash.Hash
+	defaultCryptoHash    crypto.Hash
 	defaultHashAlgorithm string
+	defaultSecurityLevel int
+
+	// hashPool recycles the hashers of defaultHash, verification
+	// hashing every certificate and transaction it checks
+	hashPool *sync.Pool
 )
 
 // GetDefaultHash returns the default hash function used by the crypto layer
@@ -36,6 +44,11 @@ func GetHashAlgorithm() string {
 	return defaultHashAlgorithm
 }
 
+// GetSecurityLevel returns the default security level, in bits
+func GetSecurityLevel() int {
+	return defaultSecurityLevel
+}
+
 // NewHash returns a new hash function
 func NewHash() hash.Hash {
 	return GetDefaultHash()()
@@ -43,9 +56,24 @@ func NewHash() hash.Hash {
 
 // Hash hashes the msh using the predefined hash function
 func Hash(msg []byte) []byte {
-	hash := NewHash()
-	hash.Write(msg)
-	return hash.Sum(nil)
+	pool := hashPool
+	if pool == nil {
+		hash := NewHash()
+		hash.Write(msg)
+		return hash.Sum(nil)
+	}
+
+	h := pool.Get().(hash.Hash)
+	h.Reset()
+	h.Write(msg)
+	digest := h.Sum(nil)
+	pool.Put(h)
+
+	return digest
+}
+
+func newHashPool(newHash func() hash.Hash) *sync.Pool {
+	return &sync.Pool{New: func() interface{} { return newHash() }}
 }
 
 // HMAC hmacs x using key key
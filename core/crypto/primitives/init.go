@@ -17,6 +17,7 @@ limitations under the License.
 package primitives
 
 import (
+	"crypto"
 	"crypto/elliptic"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -36,9 +37,11 @@ func initSHA2(level int) (err error) {
 	case 256:
 		defaultCurve = elliptic.P256()
 		defaultHash = sha256.New
+		defaultCryptoHash = crypto.SHA256
 	case 384:
 		defaultCurve = elliptic.P384()
 		defaultHash = sha512.New384
+		defaultCryptoHash = crypto.SHA384
 	default:
 		err = fmt.Errorf("Security level not supported [%d]", level)
 	}
@@ -51,9 +54,11 @@ func initSHA3(level int) (err error) {
 	case 256:
 		defaultCurve = elliptic.P256()
 		defaultHash = sha3.New256
+		defaultCryptoHash = crypto.SHA3_256
 	case 384:
 		defaultCurve = elliptic.P384()
 		defaultHash = sha3.New384
+		defaultCryptoHash = crypto.SHA3_384
 	default:
 		err = fmt.Errorf("Security level not supported [%d]", level)
 	}
@@ -71,9 +76,9 @@ func SetSecurityLevel(algorithm string, level int) (err error) {
 		err = fmt.Errorf("Algorithm not supported [%s]", algorithm)
 	}
 	if err == nil {
-		// TODO: what's this
 		defaultHashAlgorithm = algorithm
-		//hashLength = level
+		defaultSecurityLevel = level
+		hashPool = newHashPool(defaultHash)
 	}
 	return
 }
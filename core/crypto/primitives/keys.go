@@ -18,7 +18,7 @@ package primitives
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -57,6 +57,19 @@ func PrivateKeyToPEM(privateKey interface{}, pwd []byte) ([]byte, error) {
 				Bytes: raw,
 			},
 		), nil
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+		raw, err := x509.MarshalPKCS8PrivateKey(x)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(
+			&pem.Block{
+				Type:  "PRIVATE KEY",
+				Bytes: raw,
+			},
+		), nil
 	default:
 		return nil, utils.ErrInvalidKey
 	}
@@ -73,7 +86,7 @@ func PrivateKeyToEncryptedPEM(privateKey interface{}, pwd []byte) ([]byte, error
 		}
 
 		block, err := x509.EncryptPEMBlock(
-			rand.Reader,
+			RandReader,
 			"ECDSA PRIVATE KEY",
 			raw,
 			pwd,
@@ -85,6 +98,26 @@ func PrivateKeyToEncryptedPEM(privateKey interface{}, pwd []byte) ([]byte, error
 
 		return pem.EncodeToMemory(block), nil
 
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+		raw, err := x509.MarshalPKCS8PrivateKey(x)
+
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := x509.EncryptPEMBlock(
+			RandReader,
+			"PRIVATE KEY",
+			raw,
+			pwd,
+			x509.PEMCipherAES256)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(block), nil
+
 	default:
 		return nil, utils.ErrInvalidKey
 	}
@@ -100,7 +133,7 @@ func DERToPrivateKey(der []byte) (key interface{}, err error) {
 	//fmt.Printf("DERToPrivateKey Err [%s]\n", err)
 	if key, err = x509.ParsePKCS8PrivateKey(der); err == nil {
 		switch key.(type) {
-		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
 			return
 		default:
 			return nil, errors.New("Found unknown private key type in PKCS#8 wrapping")
@@ -188,7 +221,7 @@ func AEStoEncryptedPEM(raw []byte, pwd []byte) ([]byte, error) {
 	}
 
 	block, err := x509.EncryptPEMBlock(
-		rand.Reader,
+		RandReader,
 		"AES PRIVATE KEY",
 		raw,
 		pwd,
@@ -221,7 +254,7 @@ func PublicKeyToPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 
 	switch x := publicKey.(type) {
 	case *ecdsa.PublicKey:
-		PubASN1, err := x509.MarshalPKIXPublicKey(x)
+		PubASN1, err := marshalPKIXPublicKey(x)
 		if err != nil {
 			return nil, err
 		}
@@ -232,6 +265,18 @@ func PublicKeyToPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 				Bytes: PubASN1,
 			},
 		), nil
+	case *rsa.PublicKey, ed25519.PublicKey:
+		PubASN1, err := x509.MarshalPKIXPublicKey(x)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(
+			&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: PubASN1,
+			},
+		), nil
 
 	default:
 		return nil, utils.ErrInvalidKey
@@ -242,14 +287,14 @@ func PublicKeyToPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 func PublicKeyToEncryptedPEM(publicKey interface{}, pwd []byte) ([]byte, error) {
 	switch x := publicKey.(type) {
 	case *ecdsa.PublicKey:
-		raw, err := x509.MarshalPKIXPublicKey(x)
+		raw, err := marshalPKIXPublicKey(x)
 
 		if err != nil {
 			return nil, err
 		}
 
 		block, err := x509.EncryptPEMBlock(
-			rand.Reader,
+			RandReader,
 			"ECDSA PUBLIC KEY",
 			raw,
 			pwd,
@@ -259,6 +304,25 @@ func PublicKeyToEncryptedPEM(publicKey interface{}, pwd []byte) ([]byte, error)
 			return nil, err
 		}
 
+		return pem.EncodeToMemory(block), nil
+	case *rsa.PublicKey, ed25519.PublicKey:
+		raw, err := x509.MarshalPKIXPublicKey(x)
+
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := x509.EncryptPEMBlock(
+			RandReader,
+			"PUBLIC KEY",
+			raw,
+			pwd,
+			x509.PEMCipherAES256)
+
+		if err != nil {
+			return nil, err
+		}
+
 		return pem.EncodeToMemory(block), nil
 
 	default:
@@ -304,6 +368,21 @@ func PEMtoPublicKey(raw []byte, pwd []byte) (interface{}, error) {
 // DERToPublicKey unmarshals a der to public key
 func DERToPublicKey(derBytes []byte) (pub interface{}, err error) {
 	key, err := x509.ParsePKIXPublicKey(derBytes)
+	if err != nil {
+		if secp256k1Key, secp256k1Err := DERToSecp256k1PublicKey(derBytes); secp256k1Err == nil {
+			return secp256k1Key, nil
+		}
+	}
 
 	return key, err
 }
+
+// marshalPKIXPublicKey marshals an ECDSA public key, of
+// any curve secp256k1 included, to a SubjectPublicKeyInfo
+func marshalPKIXPublicKey(publicKey *ecdsa.PublicKey) ([]byte, error) {
+	if IsSecp256k1(publicKey) {
+		return Secp256k1PublicKeyToDER(publicKey)
+	}
+
+	return x509.MarshalPKIXPublicKey(publicKey)
+}
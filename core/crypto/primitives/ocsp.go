@@ -0,0 +1,400 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"time"
+)
+
+// OCSP requests and responses, as of RFC 6960. Requests are unsigned and
+// ask for the status of a single certificate; only basic responses are
+// supported. A response is signed either by the issuer of the certificates
+// it covers, or by a responder certificate the issuer delegated OCSP
+// signing to.
+
+const (
+	// OCSPGood means the certificate is not revoked
+	OCSPGood = iota
+	// OCSPRevoked means the certificate is revoked
+	OCSPRevoked
+	// OCSPUnknown means the responder does not know the certificate
+	OCSPUnknown
+)
+
+// OCSPResponse is the status of the certificate of serial number
+// SerialNumber, valid from ThisUpdate until NextUpdate, if set
+type OCSPResponse struct {
+	Status       int
+	SerialNumber *big.Int
+	ThisUpdate   time.Time
+	NextUpdate   time.Time
+	RevokedAt    time.Time
+}
+
+// CreateOCSPRequest returns the DER encoding of the request
+// of the status of cert, issued by issuer
+func CreateOCSPRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newOCSPCertID(oidOCSPSHA1, sha1.New, cert.SerialNumber, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspSingleRequest{{CertID: id}},
+		},
+	})
+}
+
+// ParseOCSPResponse parses the DER encoding of an OCSP response, checks
+// that it is signed by issuer, or by a responder issuer delegated OCSP
+// signing to, and returns the statuses it contains of the certificates
+// of issuer
+func ParseOCSPResponse(der []byte, issuer *x509.Certificate) ([]*OCSPResponse, error) {
+	resp := ocspResponse{}
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("Invalid OCSP response. Trailing data.")
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("OCSP request failed with status [%d].", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("Unsupported OCSP response type [%s].", resp.Response.ResponseType)
+	}
+
+	basic := ocspBasicResponse{}
+	if rest, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("Invalid OCSP basic response. Trailing data.")
+	}
+
+	if err := checkOCSPSignature(&basic, issuer); err != nil {
+		return nil, err
+	}
+
+	responses := []*OCSPResponse{}
+	for _, single := range basic.TBSResponseData.Responses {
+		if !single.CertID.issuedBy(issuer) {
+			continue
+		}
+
+		response := &OCSPResponse{
+			SerialNumber: single.CertID.SerialNumber,
+			ThisUpdate:   single.ThisUpdate,
+			NextUpdate:   single.NextUpdate,
+		}
+		switch {
+		case bool(single.Good):
+			response.Status = OCSPGood
+		case bool(single.Unknown):
+			response.Status = OCSPUnknown
+		default:
+			response.Status = OCSPRevoked
+			response.RevokedAt = single.Revoked.RevocationTime
+		}
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
+
+// CreateOCSPResponse returns the DER encoding of a basic OCSP response
+// with the statuses of certificates of issuer, signed by the responder
+// responderCert with key responderKey. responderCert is either issuer
+// or a certificate issuer delegated OCSP signing to.
+func CreateOCSPResponse(issuer, responderCert *x509.Certificate, responderKey crypto.Signer, statuses []*OCSPResponse) ([]byte, error) {
+	algo, hashFunc, err := ocspSignatureAlgorithm(responderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyHash, err := publicKeyHash(sha1.New, responderCert)
+	if err != nil {
+		return nil, err
+	}
+	rawKeyHash, err := asn1.Marshal(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The responder is identified by the hash of its key
+	data := ocspResponseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: rawKeyHash},
+		ProducedAt:     time.Now().UTC().Truncate(time.Second),
+	}
+	for _, status := range statuses {
+		id, err := newOCSPCertID(oidOCSPSHA1, sha1.New, status.SerialNumber, issuer)
+		if err != nil {
+			return nil, err
+		}
+		single := ocspSingleResponse{
+			CertID:     id,
+			ThisUpdate: status.ThisUpdate.UTC(),
+			NextUpdate: status.NextUpdate.UTC(),
+		}
+		switch status.Status {
+		case OCSPGood:
+			single.Good = true
+		case OCSPUnknown:
+			single.Unknown = true
+		case OCSPRevoked:
+			single.Revoked = ocspRevokedInfo{RevocationTime: status.RevokedAt.UTC()}
+		default:
+			return nil, fmt.Errorf("Invalid OCSP status [%d].", status.Status)
+		}
+		data.Responses = append(data.Responses, single)
+	}
+
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	h := hashFunc.New()
+	h.Write(tbs)
+	signature, err := responderKey.Sign(RandReader, h.Sum(nil), hashFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := ocspBasicResponse{
+		TBSResponseData:    ocspResponseData{Raw: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: algo},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	}
+	if !bytes.Equal(responderCert.Raw, issuer.Raw) {
+		basic.Certificates = []asn1.RawValue{{FullBytes: responderCert.Raw}}
+	}
+	rawBasic, err := asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspResponse{
+		Response: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: rawBasic},
+	})
+}
+
+// Private type and variables
+
+var (
+	oidOCSPBasic  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidOCSPSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+	ocspSignatureAlgorithms = []struct {
+		oid  asn1.ObjectIdentifier
+		algo x509.SignatureAlgorithm
+	}{
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	}
+)
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	CertID ocspCertID
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"explicit,tag:0,default:0,optional"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+func newOCSPCertID(algo asn1.ObjectIdentifier, newHash func() hash.Hash, serial *big.Int, issuer *x509.Certificate) (ocspCertID, error) {
+	keyHash, err := publicKeyHash(newHash, issuer)
+	if err != nil {
+		return ocspCertID{}, err
+	}
+	h := newHash()
+	h.Write(issuer.RawSubject)
+
+	return ocspCertID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: algo, Parameters: asn1.NullRawValue},
+		IssuerNameHash: h.Sum(nil),
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   serial,
+	}, nil
+}
+
+// issuedBy returns true if id identifies a certificate of issuer
+func (id *ocspCertID) issuedBy(issuer *x509.Certificate) bool {
+	var newHash func() hash.Hash
+	switch {
+	case id.HashAlgorithm.Algorithm.Equal(oidOCSPSHA1):
+		newHash = sha1.New
+	case id.HashAlgorithm.Algorithm.Equal(oidOCSPSHA256):
+		newHash = sha256.New
+	default:
+		return false
+	}
+
+	expected, err := newOCSPCertID(id.HashAlgorithm.Algorithm, newHash, id.SerialNumber, issuer)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(id.IssuerNameHash, expected.IssuerNameHash) && bytes.Equal(id.IssuerKeyHash, expected.IssuerKeyHash)
+}
+
+// publicKeyHash hashes the bits of the public key of cert
+func publicKeyHash(newHash func() hash.Hash, cert *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	h := newHash()
+	h.Write(spki.PublicKey.RightAlign())
+
+	return h.Sum(nil), nil
+}
+
+// checkOCSPSignature checks that basic is signed by issuer, or by
+// one of its certificates issuer delegated OCSP signing to
+func checkOCSPSignature(basic *ocspBasicResponse, issuer *x509.Certificate) error {
+	algo := x509.UnknownSignatureAlgorithm
+	for _, entry := range ocspSignatureAlgorithms {
+		if entry.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algo = entry.algo
+		}
+	}
+	if algo == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("Unsupported OCSP signature algorithm [%s].", basic.SignatureAlgorithm.Algorithm)
+	}
+
+	tbs := basic.TBSResponseData.Raw
+	signature := basic.Signature.RightAlign()
+	if issuer.CheckSignature(algo, tbs, signature) == nil {
+		return nil
+	}
+
+	for _, raw := range basic.Certificates {
+		responder, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			continue
+		}
+		if responder.CheckSignatureFrom(issuer) != nil || !hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+			continue
+		}
+		if responder.CheckSignature(algo, tbs, signature) == nil {
+			return nil
+		}
+	}
+
+	return errors.New("Invalid OCSP response signature.")
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ocspSignatureAlgorithm(key crypto.Signer) (asn1.ObjectIdentifier, crypto.Hash, error) {
+	var algo x509.SignatureAlgorithm
+	switch key.Public().(type) {
+	case *ecdsa.PublicKey:
+		algo = x509.ECDSAWithSHA256
+	case *rsa.PublicKey:
+		algo = x509.SHA256WithRSA
+	default:
+		return nil, 0, fmt.Errorf("Unsupported OCSP responder key type [%T].", key.Public())
+	}
+
+	for _, entry := range ocspSignatureAlgorithms {
+		if entry.algo == algo {
+			return entry.oid, crypto.SHA256, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("Unsupported OCSP signature algorithm [%s].", algo)
+}
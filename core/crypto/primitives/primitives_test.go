@@ -1,14 +1,21 @@
 package primitives
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type TestParameters struct {
@@ -46,6 +53,10 @@ func TestMain(m *testing.M) {
 			panic(fmt.Errorf("Failed initiliazing crypto layer. Invalid Hash family [%s][%s]", GetHashAlgorithm(), params.hashFamily))
 		}
 
+		if GetSecurityLevel() != params.securityLevel {
+			panic(fmt.Errorf("Failed initiliazing crypto layer. Invalid security level [%d][%d]", GetSecurityLevel(), params.securityLevel))
+		}
+
 	}
 	os.Exit(0)
 }
@@ -373,6 +384,202 @@ func TestECDSAKeys(t *testing.T) {
 	}
 }
 
+func TestEd25519(t *testing.T) {
+	key, err := NewEd25519Key()
+	if err != nil {
+		t.Fatalf("Failed generating Ed25519 key [%s]", err)
+	}
+	msg := []byte("Hello World")
+
+	sigma, err := Ed25519Sign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	ok, err := Ed25519Verify(key.Public(), msg, sigma)
+	if err != nil {
+		t.Fatalf("Failed verifying [%s]", err)
+	}
+	if !ok {
+		t.Fatalf("Failed verification.")
+	}
+	ok, err = Ed25519Verify(key.Public(), msg[:len(msg)-1], sigma)
+	if err != nil {
+		t.Fatalf("Failed verifying [%s]", err)
+	}
+	if ok {
+		t.Fatalf("Verification should fail.")
+	}
+	if _, err = Ed25519Verify(&key, msg, sigma); err == nil {
+		t.Fatalf("Verification with an invalid key should fail.")
+	}
+
+	// Private Key PEM format, in clear and encrypted
+	for _, pwd := range [][]byte{nil, []byte("passwd")} {
+		pem, err := PrivateKeyToPEM(key, pwd)
+		if err != nil {
+			t.Fatalf("Failed converting private key to PEM [%s]", err)
+		}
+		keyFromPEM, err := PEMtoPrivateKey(pem, pwd)
+		if err != nil {
+			t.Fatalf("Failed converting PEM to private key [%s]", err)
+		}
+		if !reflect.DeepEqual(key, keyFromPEM) {
+			t.Fatalf("Failed converting PEM to private key.")
+		}
+	}
+
+	// Public Key PEM format
+	pem, err := PublicKeyToPEM(key.Public(), nil)
+	if err != nil {
+		t.Fatalf("Failed converting public key to PEM [%s]", err)
+	}
+	keyFromPEM, err := PEMtoPublicKey(pem, nil)
+	if err != nil {
+		t.Fatalf("Failed converting PEM to public key [%s]", err)
+	}
+	if !reflect.DeepEqual(key.Public(), keyFromPEM) {
+		t.Fatalf("Failed converting PEM to public key.")
+	}
+}
+
+func TestSecp256k1(t *testing.T) {
+	curve := Secp256k1()
+	params := curve.Params()
+
+	// 2G and nG, the point at infinity
+	x, y := curve.ScalarBaseMult([]byte{2})
+	if x.Text(16) != "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5" ||
+		y.Text(16) != "1ae168fea63dc339a3c58419466ceaeef7f632653266d0e1236431a950cfe52a" {
+		t.Fatalf("Wrong 2G [%x, %x]", x, y)
+	}
+	if dx, dy := curve.Double(params.Gx, params.Gy); dx.Cmp(x) != 0 || dy.Cmp(y) != 0 {
+		t.Fatalf("Double and ScalarBaseMult disagree")
+	}
+	if x, y = curve.ScalarBaseMult(params.N.Bytes()); x.Sign() != 0 || y.Sign() != 0 {
+		t.Fatalf("nG should be the point at infinity")
+	}
+
+	key, err := NewSecp256k1Key()
+	if err != nil {
+		t.Fatalf("Failed generating secp256k1 key [%s]", err)
+	}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		t.Fatalf("Public key not on the curve")
+	}
+	msg := []byte("Hello World")
+
+	sigma, err := Secp256k1Sign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	ok, err := Secp256k1Verify(&key.PublicKey, msg, sigma)
+	if err != nil {
+		t.Fatalf("Failed verifying [%s]", err)
+	}
+	if !ok {
+		t.Fatalf("Failed verification.")
+	}
+	ok, err = Secp256k1Verify(&key.PublicKey, msg[:len(msg)-1], sigma)
+	if err != nil {
+		t.Fatalf("Failed verifying [%s]", err)
+	}
+	if ok {
+		t.Fatalf("Verification should fail.")
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed generating P-256 key [%s]", err)
+	}
+	if _, err = Secp256k1Verify(&p256Key.PublicKey, msg, sigma); err == nil {
+		t.Fatalf("Verification with a key of another curve should fail.")
+	}
+
+	// SEC 1 encodings, compressed and not
+	for _, compressed := range []bool{true, false} {
+		raw, err := Secp256k1PublicKeyToBytes(&key.PublicKey, compressed)
+		if err != nil {
+			t.Fatalf("Failed encoding public key [%s]", err)
+		}
+		pub, err := BytesToSecp256k1PublicKey(raw)
+		if err != nil {
+			t.Fatalf("Failed decoding public key [%s]", err)
+		}
+		if pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+			t.Fatalf("Failed decoding public key, compressed [%v].", compressed)
+		}
+	}
+	if _, err = BytesToSecp256k1PublicKey([]byte{2, 1}); err == nil {
+		t.Fatalf("Decoding an invalid public key should fail.")
+	}
+
+	// Public Key PEM format
+	pem, err := PublicKeyToPEM(&key.PublicKey, nil)
+	if err != nil {
+		t.Fatalf("Failed converting public key to PEM [%s]", err)
+	}
+	keyFromPEM, err := PEMtoPublicKey(pem, nil)
+	if err != nil {
+		t.Fatalf("Failed converting PEM to public key [%s]", err)
+	}
+	if !reflect.DeepEqual(&key.PublicKey, keyFromPEM) {
+		t.Fatalf("Failed converting PEM to public key.")
+	}
+}
+
+func TestRSA(t *testing.T) {
+	if _, err := NewRSAKey(1024); err == nil {
+		t.Fatalf("Generating a 1024 bits RSA key should fail.")
+	}
+
+	key, err := NewRSAKey(2048)
+	if err != nil {
+		t.Fatalf("Failed generating RSA key [%s]", err)
+	}
+	msg := []byte("Hello World")
+
+	sigma, err := RSASign(key, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	ok, err := RSAVerify(&key.PublicKey, msg, sigma)
+	if err != nil {
+		t.Fatalf("Failed verifying [%s]", err)
+	}
+	if !ok {
+		t.Fatalf("Failed verification.")
+	}
+	ok, err = RSAVerify(&key.PublicKey, msg[:len(msg)-1], sigma)
+	if err != nil {
+		t.Fatalf("Failed verifying [%s]", err)
+	}
+	if ok {
+		t.Fatalf("Verification should fail.")
+	}
+
+	// Private and public keys PEM format
+	pem, err := PrivateKeyToPEM(key, []byte("passwd"))
+	if err != nil {
+		t.Fatalf("Failed converting private key to PEM [%s]", err)
+	}
+	keyFromPEM, err := PEMtoPrivateKey(pem, []byte("passwd"))
+	if err != nil {
+		t.Fatalf("Failed converting PEM to private key [%s]", err)
+	}
+	if !key.Equal(keyFromPEM) {
+		t.Fatalf("Failed converting PEM to private key.")
+	}
+	if pem, err = PublicKeyToPEM(&key.PublicKey, nil); err != nil {
+		t.Fatalf("Failed converting public key to PEM [%s]", err)
+	}
+	pubFromPEM, err := PEMtoPublicKey(pem, nil)
+	if err != nil {
+		t.Fatalf("Failed converting PEM to public key [%s]", err)
+	}
+	if !key.PublicKey.Equal(pubFromPEM) {
+		t.Fatalf("Failed converting PEM to public key.")
+	}
+}
+
 func TestRandom(t *testing.T) {
 	nonce, err := GetRandomNonce()
 	if err != nil {
@@ -415,6 +622,128 @@ func TestHMAC(t *testing.T) {
 
 }
 
+func TestHash(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		msg, err := GetRandomBytes(64 * i)
+		if err != nil {
+			t.Fatalf("Failed generating random bytes [%s]", err)
+		}
+
+		h := NewHash()
+		h.Write(msg)
+		if expected := h.Sum(nil); !reflect.DeepEqual(Hash(msg), expected) {
+			t.Fatalf("Wrong hash output [%x][%x]", Hash(msg), expected)
+		}
+	}
+}
+
+// testEntropy is a deterministic entropy source, failing after limit bytes
+type testEntropy struct {
+	counter byte
+	limit   int
+}
+
+func (e *testEntropy) Read(p []byte) (int, error) {
+	for i := range p {
+		if e.limit == 0 {
+			return i, io.ErrUnexpectedEOF
+		}
+		e.limit--
+		e.counter++
+		p[i] = e.counter
+	}
+
+	return len(p), nil
+}
+
+func TestHMACDRBG(t *testing.T) {
+	if err := drbgSelfTest(); err != nil {
+		t.Fatalf("DRBG known answer test failed [%s]", err)
+	}
+
+	// The NIST CAVP vector of the self test, through the exported DRBG
+	entropy, _ := hex.DecodeString(drbgTestEntropy + drbgTestNonce)
+	cavp, err := NewHMACDRBG(bytes.NewReader(entropy), nil, 0)
+	if err != nil {
+		t.Fatalf("Failed instantiating DRBG [%s]", err)
+	}
+	returned := make([]byte, 128)
+	for i := 0; i < 2; i++ {
+		if _, err := cavp.Read(returned); err != nil {
+			t.Fatalf("Failed reading DRBG [%s]", err)
+		}
+	}
+	if hex.EncodeToString(returned) != drbgKnownAnswer {
+		t.Fatalf("DRBG should return the NIST CAVP known answer, got [%x]", returned)
+	}
+
+	// Seeded alike, two DRBGs agree
+	out := make([][]byte, 3)
+	for i, personalization := range []string{"a", "a", "b"} {
+		drbg, err := NewHMACDRBG(&testEntropy{limit: -1}, []byte(personalization), 0)
+		if err != nil {
+			t.Fatalf("Failed instantiating DRBG [%s]", err)
+		}
+		out[i] = make([]byte, drbgMaxRequest+100)
+		if _, err := drbg.Read(out[i]); err != nil {
+			t.Fatalf("Failed reading DRBG [%s]", err)
+		}
+	}
+	if !bytes.Equal(out[0], out[1]) {
+		t.Fatal("DRBGs seeded alike should agree")
+	}
+	if bytes.Equal(out[0], out[2]) {
+		t.Fatal("DRBGs personalized differently should not agree")
+	}
+
+	// A stuck entropy source fails the repetition test
+	if _, err := NewHMACDRBG(bytes.NewReader(make([]byte, 1024)), nil, 0); err != ErrDRBGHealthTest {
+		t.Fatalf("Stuck entropy source should fail the health test [%v]", err)
+	}
+
+	// An entropy source failing at reseed puts the DRBG in an error state
+	drbg, err := NewHMACDRBG(&testEntropy{limit: drbgSeedLength + drbgNonceLength}, nil, 1)
+	if err != nil {
+		t.Fatalf("Failed instantiating DRBG [%s]", err)
+	}
+	buf := make([]byte, 32)
+	if _, err := drbg.Read(buf); err != nil {
+		t.Fatalf("Failed reading DRBG [%s]", err)
+	}
+	if _, err := drbg.Read(buf); err != ErrEntropySource {
+		t.Fatalf("Reseed should fail [%v]", err)
+	}
+	if drbg.Err() != ErrEntropySource || drbg.Reseed(nil) != ErrEntropySource {
+		t.Fatal("DRBG should stay in the error state")
+	}
+
+	// The crypto layer draws from the random source
+	defer SetRandomSource(nil)
+	SetRandomSource(drbg)
+	if _, err := NewECDSAKey(); err == nil {
+		t.Fatal("Key generation should fail with the DRBG in the error state")
+	}
+	if _, err := GetRandomBytes(32); err == nil {
+		t.Fatal("Random bytes should fail with the DRBG in the error state")
+	}
+
+	if drbg, err = NewHMACDRBG(nil, nil, 0); err != nil {
+		t.Fatalf("Failed instantiating DRBG [%s]", err)
+	}
+	SetRandomSource(drbg)
+	key, err := NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	sigma, err := ECDSASign(key, []byte("Hello World"))
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if ok, err := ECDSAVerify(&key.PublicKey, []byte("Hello World"), sigma); err != nil || !ok {
+		t.Fatalf("Failed verifying [%v]", err)
+	}
+}
+
 func TestX509(t *testing.T) {
 
 	// Generate a self signed cert
@@ -484,3 +813,181 @@ func TestX509(t *testing.T) {
 		t.Fatalf("Checking cert vk against sk shoud failed. Invalid VK [%s]", err)
 	}
 }
+
+func newOCSPTestCert(t *testing.T, serial int64, usages []x509.ExtKeyUsage, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating key [%s]", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("cert-%d", serial)},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           usages,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed creating cert [%s]", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed parsing cert [%s]", err)
+	}
+
+	return cert, key
+}
+
+func TestOCSP(t *testing.T) {
+	ca, caKey := newOCSPTestCert(t, 1, nil, nil, nil)
+	good, _ := newOCSPTestCert(t, 2, nil, ca, caKey)
+	revoked, _ := newOCSPTestCert(t, 3, nil, ca, caKey)
+	responder, responderKey := newOCSPTestCert(t, 4, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, ca, caKey)
+	other, otherKey := newOCSPTestCert(t, 5, nil, ca, caKey)
+	otherCA, _ := newOCSPTestCert(t, 1, nil, nil, nil)
+
+	// Requests identify the cert and its issuer
+	raw, err := CreateOCSPRequest(good, ca)
+	if err != nil {
+		t.Fatalf("Failed creating OCSP request [%s]", err)
+	}
+	req := ocspRequest{}
+	if _, err := asn1.Unmarshal(raw, &req); err != nil {
+		t.Fatalf("Failed parsing OCSP request [%s]", err)
+	}
+	id := req.TBSRequest.RequestList[0].CertID
+	if id.SerialNumber.Cmp(good.SerialNumber) != 0 || !id.issuedBy(ca) || id.issuedBy(otherCA) {
+		t.Fatal("OCSP request should identify the cert and its issuer")
+	}
+
+	now := time.Now().Truncate(time.Second)
+	statuses := []*OCSPResponse{
+		{Status: OCSPGood, SerialNumber: good.SerialNumber, ThisUpdate: now, NextUpdate: now.Add(time.Hour)},
+		{Status: OCSPRevoked, SerialNumber: revoked.SerialNumber, ThisUpdate: now, RevokedAt: now.Add(-time.Minute)},
+	}
+
+	// Signed by the issuer, or by a delegated responder
+	for _, signer := range []struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+	}{{ca, caKey}, {responder, responderKey}} {
+		raw, err := CreateOCSPResponse(ca, signer.cert, signer.key, statuses)
+		if err != nil {
+			t.Fatalf("Failed creating OCSP response [%s]", err)
+		}
+		responses, err := ParseOCSPResponse(raw, ca)
+		if err != nil {
+			t.Fatalf("Failed parsing OCSP response [%s]", err)
+		}
+		if len(responses) != 2 {
+			t.Fatalf("Expected 2 statuses, got [%d]", len(responses))
+		}
+		if responses[0].Status != OCSPGood || responses[0].SerialNumber.Cmp(good.SerialNumber) != 0 || !responses[0].NextUpdate.Equal(now.Add(time.Hour)) {
+			t.Fatalf("Invalid status of the good cert [%v]", responses[0])
+		}
+		if responses[1].Status != OCSPRevoked || responses[1].SerialNumber.Cmp(revoked.SerialNumber) != 0 || !responses[1].RevokedAt.Equal(now.Add(-time.Minute)) || !responses[1].NextUpdate.IsZero() {
+			t.Fatalf("Invalid status of the revoked cert [%v]", responses[1])
+		}
+
+		// Responses of another issuer do not verify
+		if _, err := ParseOCSPResponse(raw, otherCA); err == nil {
+			t.Fatal("OCSP response of another issuer should be rejected")
+		}
+	}
+
+	// A cert of the issuer not delegated OCSP signing
+	raw, err = CreateOCSPResponse(ca, other, otherKey, statuses)
+	if err != nil {
+		t.Fatalf("Failed creating OCSP response [%s]", err)
+	}
+	if _, err := ParseOCSPResponse(raw, ca); err == nil {
+		t.Fatal("OCSP response of a responder not delegated should be rejected")
+	}
+
+	// Failed requests
+	raw, err = asn1.Marshal(ocspResponse{Status: 6})
+	if err != nil {
+		t.Fatalf("Failed marshalling OCSP response [%s]", err)
+	}
+	if _, err := ParseOCSPResponse(raw, ca); err == nil {
+		t.Fatal("Unauthorized OCSP response should be rejected")
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	msg := make([]byte, 1024)
+	rand.Read(msg)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Hash(msg)
+	}
+}
+
+func BenchmarkECDSASign(b *testing.B) {
+	key, err := NewECDSAKey()
+	if err != nil {
+		b.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	msg := make([]byte, 1024)
+	rand.Read(msg)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ECDSASign(key, msg)
+	}
+}
+
+func BenchmarkECDSAVerify(b *testing.B) {
+	key, err := NewECDSAKey()
+	if err != nil {
+		b.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	msg := make([]byte, 1024)
+	rand.Read(msg)
+	sigma, err := ECDSASign(key, msg)
+	if err != nil {
+		b.Fatalf("Failed signing [%s]", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ECDSAVerify(&key.PublicKey, msg, sigma)
+	}
+}
+
+func BenchmarkSecp256k1Verify(b *testing.B) {
+	key, err := NewSecp256k1Key()
+	if err != nil {
+		b.Fatalf("Failed generating secp256k1 key [%s]", err)
+	}
+	msg := make([]byte, 1024)
+	rand.Read(msg)
+	sigma, err := Secp256k1Sign(key, msg)
+	if err != nil {
+		b.Fatalf("Failed signing [%s]", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Secp256k1Verify(&key.PublicKey, msg, sigma)
+	}
+}
+
+func BenchmarkDERToX509Certificate(b *testing.B) {
+	der, _, err := NewSelfSignedCert()
+	if err != nil {
+		b.Fatalf("Failed generating self-signed cert [%s]", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DERToX509Certificate(der)
+	}
+}
@@ -16,14 +16,13 @@ limitations under the License.
 
 package primitives
 
-import "crypto/rand"
+import "io"
 
 // GetRandomBytes returns len random looking bytes
 func GetRandomBytes(len int) ([]byte, error) {
 	key := make([]byte, len)
 
-	// TODO: rand could fill less bytes then len
-	_, err := rand.Read(key)
+	_, err := io.ReadFull(RandReader, key)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+const (
+	// RSAMinKeySize is the size, in bits, of the smallest RSA key accepted
+	RSAMinKeySize = 2048
+)
+
+// NewRSAKey generates a new RSA Key of bits bits
+func NewRSAKey(bits int) (*rsa.PrivateKey, error) {
+	if bits < RSAMinKeySize {
+		return nil, fmt.Errorf("RSA key size [%d] too small. It must be at least %d bits.", bits, RSAMinKeySize)
+	}
+
+	return rsa.GenerateKey(RandReader, bits)
+}
+
+// RSASign signs the hash of msg, as PKCS #1 v1.5 prescribes
+func RSASign(signKey interface{}, msg []byte) ([]byte, error) {
+	key, ok := signKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, utils.ErrInvalidKey
+	}
+
+	return rsa.SignPKCS1v15(RandReader, key, defaultCryptoHash, Hash(msg))
+}
+
+// RSAVerify verifies. Keys smaller than RSAMinKeySize bits are rejected.
+func RSAVerify(verKey interface{}, msg, signature []byte) (bool, error) {
+	key, ok := verKey.(*rsa.PublicKey)
+	if !ok || key.N.BitLen() < RSAMinKeySize {
+		return false, utils.ErrInvalidKey
+	}
+
+	return rsa.VerifyPKCS1v15(key, defaultCryptoHash, Hash(msg), signature) == nil, nil
+}
@@ -0,0 +1,416 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/utils"
+)
+
+// secp256k1 is the curve of the signing keys of Bitcoin, Ethereum and
+// most of the blockchains identities and assets are bridged from. Its
+// keys are ECDSA keys, signing and verifying as those of the NIST curves,
+// but the standard library neither implements the curve, whose a
+// coefficient is 0 and not -3, nor encodes its keys. secp256k1 signatures
+// are on the SHA-256 hash of the message, as in those ecosystems, whatever
+// the hash of the crypto layer.
+
+var (
+	errSecp256k1Key   = errors.New("Invalid secp256k1 public key.")
+	errSecp256k1Curve = errors.New("Not a secp256k1 public key.")
+
+	// OIDs of the elliptic curve public keys and of the secp256k1 curve
+	oidPublicKeyECDSA  = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveS256K = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+	secp256k1Once  sync.Once
+	secp256k1Curve *secp256k1
+)
+
+type secp256k1 struct {
+	*elliptic.CurveParams
+}
+
+// publicKeyInfo is a SubjectPublicKeyInfo, as x509 encodes it
+type publicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// Secp256k1 returns the secp256k1 curve, as defined in SEC 2
+func Secp256k1() elliptic.Curve {
+	secp256k1Once.Do(func() {
+		params := &elliptic.CurveParams{Name: "secp256k1", BitSize: 256}
+		params.P, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+		params.N, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+		params.B = big.NewInt(7)
+		params.Gx, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+		params.Gy, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+		secp256k1Curve = &secp256k1{params}
+	})
+
+	return secp256k1Curve
+}
+
+// IsSecp256k1 returns true if key is a secp256k1 public or private key
+func IsSecp256k1(key interface{}) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return k.Curve == Secp256k1()
+	case *ecdsa.PrivateKey:
+		return k.Curve == Secp256k1()
+	}
+
+	return false
+}
+
+// NewSecp256k1Key generates a new secp256k1 key
+func NewSecp256k1Key() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(Secp256k1(), RandReader)
+}
+
+// Secp256k1Sign signs the SHA-256 hash of msg with signKey, a secp256k1
+// key. The signature is ASN.1 encoded, with the low S of BIP 62.
+func Secp256k1Sign(signKey interface{}, msg []byte) ([]byte, error) {
+	key, ok := signKey.(*ecdsa.PrivateKey)
+	if !ok || !IsSecp256k1(key) {
+		return nil, utils.ErrInvalidKey
+	}
+
+	h := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(RandReader, key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	halfN := new(big.Int).Rsh(key.Params().N, 1)
+	if s.Cmp(halfN) > 0 {
+		s.Sub(key.Params().N, s)
+	}
+
+	return asn1.Marshal(ECDSASignature{r, s})
+}
+
+// Secp256k1Verify verifies signature, as returned by Secp256k1Sign,
+// of msg against verKey, a secp256k1 key
+func Secp256k1Verify(verKey interface{}, msg, signature []byte) (bool, error) {
+	key, ok := verKey.(*ecdsa.PublicKey)
+	if !ok || !IsSecp256k1(key) {
+		return false, utils.ErrInvalidKey
+	}
+
+	sig := new(ECDSASignature)
+	if rest, err := asn1.Unmarshal(signature, sig); err != nil || len(rest) != 0 {
+		return false, nil
+	}
+
+	h := sha256.Sum256(msg)
+	return ecdsa.Verify(key, h[:], sig.R, sig.S), nil
+}
+
+// Secp256k1PublicKeyToBytes encodes pub as in SEC 1, compressed
+// on 33 bytes, as Bitcoin does, or not on 65 bytes
+func Secp256k1PublicKeyToBytes(pub *ecdsa.PublicKey, compressed bool) ([]byte, error) {
+	if !IsSecp256k1(pub) {
+		return nil, errSecp256k1Curve
+	}
+	if !compressed {
+		return elliptic.Marshal(pub.Curve, pub.X, pub.Y), nil
+	}
+
+	raw := make([]byte, 33)
+	raw[0] = 2 + byte(pub.Y.Bit(0))
+	xBytes := pub.X.Bytes()
+	copy(raw[33-len(xBytes):], xBytes)
+
+	return raw, nil
+}
+
+// BytesToSecp256k1PublicKey decodes raw, a secp256k1
+// public key encoded as in SEC 1, compressed or not
+func BytesToSecp256k1PublicKey(raw []byte) (*ecdsa.PublicKey, error) {
+	curve := Secp256k1()
+	params := curve.Params()
+
+	var x, y *big.Int
+	switch {
+	case len(raw) == 65 && raw[0] == 4:
+		x = new(big.Int).SetBytes(raw[1:33])
+		y = new(big.Int).SetBytes(raw[33:])
+	case len(raw) == 33 && (raw[0] == 2 || raw[0] == 3):
+		x = new(big.Int).SetBytes(raw[1:])
+		if x.Cmp(params.P) >= 0 {
+			return nil, errSecp256k1Key
+		}
+		// y^2 = x^3 + 7, and p = 3 mod 4
+		y2 := new(big.Int).Exp(x, big.NewInt(3), params.P)
+		y2.Add(y2, params.B)
+		y2.Mod(y2, params.P)
+		exp := new(big.Int).Add(params.P, big.NewInt(1))
+		exp.Rsh(exp, 2)
+		y = new(big.Int).Exp(y2, exp, params.P)
+		if y.Bit(0) != uint(raw[0]&1) {
+			y.Sub(params.P, y)
+		}
+	default:
+		return nil, errSecp256k1Key
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, errSecp256k1Key
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Secp256k1PublicKeyToDER encodes pub as a PKIX SubjectPublicKeyInfo,
+// which x509.MarshalPKIXPublicKey does not for secp256k1 keys
+func Secp256k1PublicKeyToDER(pub *ecdsa.PublicKey) ([]byte, error) {
+	raw, err := Secp256k1PublicKeyToBytes(pub, false)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(oidNamedCurveS256K)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(publicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: raw, BitLength: 8 * len(raw)},
+	})
+}
+
+// DERToSecp256k1PublicKey decodes der, a secp256k1 public
+// key encoded as a PKIX SubjectPublicKeyInfo
+func DERToSecp256k1PublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	var info publicKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) != 0 {
+		return nil, errSecp256k1Key
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) {
+		return nil, errSecp256k1Curve
+	}
+	var namedCurve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &namedCurve); err != nil || !namedCurve.Equal(oidNamedCurveS256K) {
+		return nil, errSecp256k1Curve
+	}
+
+	return BytesToSecp256k1PublicKey(info.PublicKey.RightAlign())
+}
+
+// Arithmetic on secp256k1, in Jacobian coordinates. (x, y) is
+// (X/Z^2, Y/Z^3), and the point at infinity has Z = 0.
+
+func (curve *secp256k1) Params() *elliptic.CurveParams {
+	return curve.CurveParams
+}
+
+// IsOnCurve returns true if y^2 = x^3 + 7
+func (curve *secp256k1) IsOnCurve(x, y *big.Int) bool {
+	p := curve.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, p)
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, curve.B)
+	x3.Mod(x3, p)
+
+	return y2.Cmp(x3) == 0
+}
+
+func (curve *secp256k1) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	z1 := zForAffine(x1, y1)
+	z2 := zForAffine(x2, y2)
+
+	return curve.affineFromJacobian(curve.addJacobian(x1, y1, z1, x2, y2, z2))
+}
+
+func (curve *secp256k1) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	z1 := zForAffine(x1, y1)
+
+	return curve.affineFromJacobian(curve.doubleJacobian(x1, y1, z1))
+}
+
+func (curve *secp256k1) ScalarMult(bx, by *big.Int, k []byte) (*big.Int, *big.Int) {
+	bz := zForAffine(bx, by)
+	x, y, z := new(big.Int), new(big.Int), new(big.Int)
+
+	for _, b := range k {
+		for bit := 0; bit < 8; bit++ {
+			x, y, z = curve.doubleJacobian(x, y, z)
+			if b&0x80 == 0x80 {
+				x, y, z = curve.addJacobian(bx, by, bz, x, y, z)
+			}
+			b <<= 1
+		}
+	}
+
+	return curve.affineFromJacobian(x, y, z)
+}
+
+func (curve *secp256k1) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return curve.ScalarMult(curve.Gx, curve.Gy, k)
+}
+
+// zForAffine returns the Jacobian Z of the affine point (x, y),
+// the point at infinity being (0, 0) in affine coordinates
+func zForAffine(x, y *big.Int) *big.Int {
+	z := new(big.Int)
+	if x.Sign() != 0 || y.Sign() != 0 {
+		z.SetInt64(1)
+	}
+
+	return z
+}
+
+func (curve *secp256k1) affineFromJacobian(x, y, z *big.Int) (*big.Int, *big.Int) {
+	if z.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+
+	p := curve.P
+	zinv := new(big.Int).ModInverse(z, p)
+	zinvsq := new(big.Int).Mul(zinv, zinv)
+
+	xOut := new(big.Int).Mul(x, zinvsq)
+	xOut.Mod(xOut, p)
+	zinvsq.Mul(zinvsq, zinv)
+	yOut := new(big.Int).Mul(y, zinvsq)
+	yOut.Mod(yOut, p)
+
+	return xOut, yOut
+}
+
+// addJacobian adds two points, as in add-2007-bl
+func (curve *secp256k1) addJacobian(x1, y1, z1, x2, y2, z2 *big.Int) (*big.Int, *big.Int, *big.Int) {
+	if z1.Sign() == 0 {
+		return new(big.Int).Set(x2), new(big.Int).Set(y2), new(big.Int).Set(z2)
+	}
+	if z2.Sign() == 0 {
+		return new(big.Int).Set(x1), new(big.Int).Set(y1), new(big.Int).Set(z1)
+	}
+
+	p := curve.P
+	z1z1 := new(big.Int).Mul(z1, z1)
+	z1z1.Mod(z1z1, p)
+	z2z2 := new(big.Int).Mul(z2, z2)
+	z2z2.Mod(z2z2, p)
+
+	u1 := new(big.Int).Mul(x1, z2z2)
+	u1.Mod(u1, p)
+	u2 := new(big.Int).Mul(x2, z1z1)
+	u2.Mod(u2, p)
+	s1 := new(big.Int).Mul(y1, z2)
+	s1.Mul(s1, z2z2)
+	s1.Mod(s1, p)
+	s2 := new(big.Int).Mul(y2, z1)
+	s2.Mul(s2, z1z1)
+	s2.Mod(s2, p)
+
+	h := new(big.Int).Sub(u2, u1)
+	h.Mod(h, p)
+	r := new(big.Int).Sub(s2, s1)
+	r.Mod(r, p)
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return curve.doubleJacobian(x1, y1, z1)
+		}
+		return new(big.Int), new(big.Int), new(big.Int)
+	}
+	r.Lsh(r, 1)
+
+	i := new(big.Int).Lsh(h, 1)
+	i.Mul(i, i)
+	j := new(big.Int).Mul(h, i)
+	v := new(big.Int).Mul(u1, i)
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, j)
+	x3.Sub(x3, v)
+	x3.Sub(x3, v)
+	x3.Mod(x3, p)
+
+	y3 := new(big.Int).Sub(v, x3)
+	y3.Mul(y3, r)
+	s1.Mul(s1, j)
+	s1.Lsh(s1, 1)
+	y3.Sub(y3, s1)
+	y3.Mod(y3, p)
+
+	z3 := new(big.Int).Add(z1, z2)
+	z3.Mul(z3, z3)
+	z3.Sub(z3, z1z1)
+	z3.Sub(z3, z2z2)
+	z3.Mul(z3, h)
+	z3.Mod(z3, p)
+
+	return x3, y3, z3
+}
+
+// doubleJacobian doubles a point, as in dbl-2009-l for a = 0
+func (curve *secp256k1) doubleJacobian(x, y, z *big.Int) (*big.Int, *big.Int, *big.Int) {
+	if z.Sign() == 0 || y.Sign() == 0 {
+		return new(big.Int), new(big.Int), new(big.Int)
+	}
+
+	p := curve.P
+	a := new(big.Int).Mul(x, x)
+	a.Mod(a, p)
+	b := new(big.Int).Mul(y, y)
+	b.Mod(b, p)
+	c := new(big.Int).Mul(b, b)
+	c.Mod(c, p)
+
+	d := new(big.Int).Add(x, b)
+	d.Mul(d, d)
+	d.Sub(d, a)
+	d.Sub(d, c)
+	d.Lsh(d, 1)
+	d.Mod(d, p)
+
+	e := new(big.Int).Lsh(a, 1)
+	e.Add(e, a)
+	f := new(big.Int).Mul(e, e)
+
+	x3 := new(big.Int).Sub(f, d)
+	x3.Sub(x3, d)
+	x3.Mod(x3, p)
+
+	y3 := new(big.Int).Sub(d, x3)
+	y3.Mul(y3, e)
+	c.Lsh(c, 3)
+	y3.Sub(y3, c)
+	y3.Mod(y3, p)
+
+	z3 := new(big.Int).Mul(y, z)
+	z3.Lsh(z3, 1)
+	z3.Mod(z3, p)
+
+	return x3, y3, z3
+}
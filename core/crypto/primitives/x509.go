@@ -18,7 +18,7 @@ package primitives
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -198,7 +198,7 @@ func NewSelfSignedCert() ([]byte, interface{}, error) {
 		},
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &privKey.PublicKey, privKey)
+	cert, err := x509.CreateCertificate(RandReader, &template, &template, &privKey.PublicKey, privKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -226,6 +226,14 @@ func CheckCertPKAgainstSK(x509Cert *x509.Certificate, privateKey interface{}) er
 		if pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
 			return errors.New("Private key does not match public key")
 		}
+	case ed25519.PublicKey:
+		priv, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return errors.New("Private key type does not match public key type")
+		}
+		if !pub.Equal(priv.Public()) {
+			return errors.New("Private key does not match public key")
+		}
 	default:
 		return errors.New("Unknown public key algorithm")
 	}
@@ -0,0 +1,255 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sdk
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Client is a client of the member services and of a peer. Its methods
+// are safe for concurrent use.
+type Client struct {
+	cfg    Config
+	client crypto.Client
+
+	mutex  sync.Mutex
+	conn   *grpc.ClientConn
+	closed bool
+}
+
+// TCert is a transaction certificate of a Client, with its signing key
+type TCert struct {
+	handler crypto.CertificateHandler
+}
+
+// Public Methods
+
+// Register enrolls the identity enrollID, with secret enrollPWD, with the
+// ECA and stores its enrollment key and certificate under name, protected
+// by pwd. Registering a name already registered is a no-op.
+func Register(cfg *Config, name string, pwd []byte, enrollID, enrollPWD string) error {
+	opts, err := cfg.options()
+	if err != nil {
+		return err
+	}
+
+	return crypto.Register(crypto.NodeClient, name, pwd, enrollID, enrollPWD, opts)
+}
+
+// Open opens the client registered under name, with password pwd.
+// It must be closed with Close.
+func Open(cfg *Config, name string, pwd []byte) (*Client, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := crypto.NewClient(name, pwd, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{cfg: *cfg, client: client}, nil
+}
+
+// Close closes the connection to the peer, if any,
+// and releases the resources of the client
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return crypto.Close(c.client)
+}
+
+// Name returns the name the client is registered under
+func (c *Client) Name() string {
+	return c.client.GetName()
+}
+
+// EnrollmentCertificate returns the DER of the enrollment certificate
+func (c *Client) EnrollmentCertificate() ([]byte, error) {
+	handler, err := c.client.GetEnrollmentCertificateHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.GetCertificate(), nil
+}
+
+// Sign signs msg with the enrollment key
+func (c *Client) Sign(msg []byte) ([]byte, error) {
+	handler, err := c.client.GetEnrollmentCertificateHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.Sign(msg)
+}
+
+// GetTCert returns the next unused TCert of the client, carrying attributes
+func (c *Client) GetTCert(attributes ...string) (*TCert, error) {
+	handler, err := c.client.GetTCertificateHandlerNext(attributes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TCert{handler}, nil
+}
+
+// NewDeploy returns a transaction deploying the chaincode of spec,
+// encrypted if spec is confidential
+func (c *Client) NewDeploy(spec *pb.ChaincodeDeploymentSpec, attributes ...string) (*pb.Transaction, error) {
+	return c.client.NewChaincodeDeployTransaction(spec, util.GenerateUUID(), attributes...)
+}
+
+// NewInvoke returns a transaction invoking the chaincode of spec,
+// encrypted if spec is confidential
+func (c *Client) NewInvoke(spec *pb.ChaincodeInvocationSpec, attributes ...string) (*pb.Transaction, error) {
+	return c.client.NewChaincodeExecute(spec, util.GenerateUUID(), attributes...)
+}
+
+// NewQuery returns a transaction querying the chaincode of spec,
+// encrypted if spec is confidential
+func (c *Client) NewQuery(spec *pb.ChaincodeInvocationSpec, attributes ...string) (*pb.Transaction, error) {
+	return c.client.NewChaincodeQuery(spec, util.GenerateUUID(), attributes...)
+}
+
+// DecryptQueryResult decrypts the result of the confidential query tx
+func (c *Client) DecryptQueryResult(tx *pb.Transaction, result []byte) ([]byte, error) {
+	return c.client.DecryptQueryResult(tx, result)
+}
+
+// ChaincodeKey returns the key of a chaincode deployed by the client under
+// confidentiality protocol 1.3, to be handed to the clients invoking it
+func (c *Client) ChaincodeKey(chaincodeID *pb.ChaincodeID) ([]byte, error) {
+	return c.client.GetChaincodeKey(chaincodeID)
+}
+
+// SetChaincodeKey stores the key of a chaincode received from its deployer
+func (c *Client) SetChaincodeKey(chaincodeID *pb.ChaincodeID, chaincodeKey []byte) error {
+	return c.client.SetChaincodeKey(chaincodeID, chaincodeKey)
+}
+
+// Submit submits tx to the peer and returns its response. A response
+// with another status than success is returned along with an error.
+func (c *Client) Submit(ctx context.Context, tx *pb.Transaction) (*pb.Response, error) {
+	conn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := pb.NewPeerClient(conn).ProcessTransaction(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != pb.Response_SUCCESS {
+		return resp, fmt.Errorf("Transaction [%s] failed [%s].", tx.Uuid, string(resp.Msg))
+	}
+
+	return resp, nil
+}
+
+// Certificate returns the DER of the TCert
+func (t *TCert) Certificate() []byte {
+	return t.handler.GetCertificate()
+}
+
+// Sign signs msg with the signing key of the TCert
+func (t *TCert) Sign(msg []byte) ([]byte, error) {
+	return t.handler.Sign(msg)
+}
+
+// NewDeploy returns a transaction, under the TCert,
+// deploying the chaincode of spec
+func (t *TCert) NewDeploy(spec *pb.ChaincodeDeploymentSpec) (*pb.Transaction, error) {
+	handler, err := t.handler.GetTransactionHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.NewChaincodeDeployTransaction(spec, util.GenerateUUID())
+}
+
+// NewInvoke returns a transaction, under the TCert,
+// invoking the chaincode of spec
+func (t *TCert) NewInvoke(spec *pb.ChaincodeInvocationSpec) (*pb.Transaction, error) {
+	handler, err := t.handler.GetTransactionHandler()
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.NewChaincodeExecute(spec, util.GenerateUUID())
+}
+
+// Private Methods
+
+// getConn returns the connection to the peer, dialing it the first time
+func (c *Client) getConn() (*grpc.ClientConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if c.cfg.PeerAddress == "" {
+		return nil, ErrNoPeerAddress
+	}
+
+	opts := []grpc.DialOption{grpc.WithTimeout(c.cfg.getTimeout())}
+	if c.cfg.TLS.Enabled {
+		creds := credentials.NewClientTLSFromCert(nil, c.cfg.TLS.ServerHostOverride)
+		if c.cfg.TLS.RootCertFile != "" {
+			var err error
+			if creds, err = credentials.NewClientTLSFromFile(c.cfg.TLS.RootCertFile, c.cfg.TLS.ServerHostOverride); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.Dial(c.cfg.PeerAddress, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+
+	return conn, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sdk is the client API of the crypto layer for Go applications
+// outside the peer. A Client enrolls with the member services, gets TCerts,
+// signs, creates transactions, encrypted when their spec is confidential,
+// and submits them to a peer. It is configured by a Config only: it reads
+// neither the global viper configuration nor the settings of the peer, so
+// an application imports neither core/peer nor the peer configuration.
+//
+// The exported surface of this package is versioned by Version. Its major
+// number changes only with incompatible changes of that surface.
+//
+//	cfg := &sdk.Config{
+//		DataPath:     "/var/app/crypto",
+//		ECAAddress:   "localhost:7054",
+//		TCAAddress:   "localhost:7054",
+//		TLSCAAddress: "localhost:7054",
+//		PeerAddress:  "localhost:7051",
+//	}
+//	if err := sdk.Register(cfg, "jim", nil, "jim", "6avZQLwcUe9b"); err != nil {
+//		...
+//	}
+//	client, err := sdk.Open(cfg, "jim", nil)
+//	if err != nil {
+//		...
+//	}
+//	defer client.Close()
+//
+//	tx, err := client.NewInvoke(spec)
+//	...
+//	resp, err := client.Submit(context.Background(), tx)
+package sdk
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/spf13/viper"
+)
+
+// Version is the version of the API of this package
+const Version = "1.0.0"
+
+// Config is the configuration of a Client
+type Config struct {
+	// DataPath is the directory the client stores its keys and certificates in
+	DataPath string
+
+	// ECAAddress, TCAAddress and TLSCAAddress are the addresses of the
+	// enrollment, transaction and TLS certificate authorities
+	ECAAddress   string
+	TCAAddress   string
+	TLSCAAddress string
+
+	// PeerAddress is the address of the peer the transactions are submitted to
+	PeerAddress string
+
+	// TLS secures the connections to the member services and to the peer
+	TLS TLSConfig
+
+	// SecurityLevel and HashAlgorithm are the security level, 256 or 384,
+	// and the hash family, SHA2 or SHA3. They default to 256 and SHA3.
+	SecurityLevel int
+	HashAlgorithm string
+
+	// ConfidentialityProtocolVersion is the confidentiality protocol of
+	// the confidential transactions, 1.2 by default
+	ConfidentialityProtocolVersion string
+
+	// Timeout bounds the dial to the peer, 3s by default
+	Timeout time.Duration
+
+	// Settings holds further settings of the crypto layer by key,
+	// as in the security section of core.yaml, e.g.
+	// "security.tcert.batch.size". They override the fields above.
+	Settings map[string]interface{}
+}
+
+// TLSConfig is the TLS configuration of a Client
+type TLSConfig struct {
+	// Enabled enables TLS
+	Enabled bool
+
+	// RootCertFile is the PEM file of the certificate of the TLSCA
+	RootCertFile string
+
+	// ServerHostOverride overrides the name the certificates
+	// of the member services and of the peer are checked against
+	ServerHostOverride string
+}
+
+// Errors of the package
+var (
+	// ErrNilConfig Nil configuration
+	ErrNilConfig = errors.New("Nil configuration.")
+
+	// ErrNoPeerAddress No peer to submit the transactions to
+	ErrNoPeerAddress = errors.New("No peer address configured.")
+
+	// ErrClosed The client is closed
+	ErrClosed = errors.New("Client closed.")
+)
+
+// Private Methods
+
+// options returns the options of the crypto nodes configured by cfg
+func (cfg *Config) options() (*crypto.Options, error) {
+	if cfg == nil {
+		return nil, ErrNilConfig
+	}
+
+	if err := primitives.InitSecurityLevel(cfg.getHashAlgorithm(), cfg.getSecurityLevel()); err != nil {
+		return nil, err
+	}
+
+	return &crypto.Options{Config: cfg.settings(), DataPath: cfg.DataPath}, nil
+}
+
+// settings returns the settings read by the crypto layer, by key
+func (cfg *Config) settings() *viper.Viper {
+	v := viper.New()
+	v.Set("peer.pki.eca.paddr", cfg.ECAAddress)
+	v.Set("peer.pki.tca.paddr", cfg.TCAAddress)
+	v.Set("peer.pki.tlsca.paddr", cfg.TLSCAAddress)
+	v.Set("peer.pki.tls.enabled", cfg.TLS.Enabled)
+	if cfg.TLS.RootCertFile != "" {
+		v.Set("peer.pki.tls.rootcert.file", cfg.TLS.RootCertFile)
+	}
+	if cfg.TLS.ServerHostOverride != "" {
+		v.Set("peer.pki.tls.serverhostoverride", cfg.TLS.ServerHostOverride)
+	}
+	if cfg.ConfidentialityProtocolVersion != "" {
+		v.Set("security.confidentialityProtocolVersion", cfg.ConfidentialityProtocolVersion)
+	}
+	for key, value := range cfg.Settings {
+		v.Set(key, value)
+	}
+
+	return v
+}
+
+func (cfg *Config) getSecurityLevel() int {
+	if cfg.SecurityLevel == 0 {
+		return 256
+	}
+
+	return cfg.SecurityLevel
+}
+
+func (cfg *Config) getHashAlgorithm() string {
+	if cfg.HashAlgorithm == "" {
+		return "SHA3"
+	}
+
+	return cfg.HashAlgorithm
+}
+
+func (cfg *Config) getTimeout() time.Duration {
+	if cfg.Timeout == 0 {
+		return 3 * time.Second
+	}
+
+	return cfg.Timeout
+}
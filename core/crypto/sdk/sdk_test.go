@@ -0,0 +1,60 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sdk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigSettings(t *testing.T) {
+	cfg := &Config{
+		ECAAddress:   "eca:7054",
+		TCAAddress:   "tca:7054",
+		TLSCAAddress: "tlsca:7054",
+		TLS:          TLSConfig{Enabled: true, RootCertFile: "tlsca.cert"},
+		Settings:     map[string]interface{}{"security.tcert.batch.size": 10, "peer.pki.tca.paddr": "other:7054"},
+	}
+
+	v := cfg.settings()
+	if v.GetString("peer.pki.eca.paddr") != "eca:7054" || v.GetString("peer.pki.tlsca.paddr") != "tlsca:7054" {
+		t.Fatal("Addresses of the member services not set.")
+	}
+	if !v.GetBool("peer.pki.tls.enabled") || v.GetString("peer.pki.tls.rootcert.file") != "tlsca.cert" {
+		t.Fatal("TLS settings not set.")
+	}
+	if v.IsSet("peer.pki.tls.serverhostoverride") || v.IsSet("security.confidentialityProtocolVersion") {
+		t.Fatal("Unset fields should leave the defaults of the crypto layer.")
+	}
+	if v.GetInt("security.tcert.batch.size") != 10 {
+		t.Fatal("Further settings not set.")
+	}
+	if v.GetString("peer.pki.tca.paddr") != "other:7054" {
+		t.Fatal("Further settings should override the fields.")
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := &Config{}
+	if cfg.getSecurityLevel() != 256 || cfg.getHashAlgorithm() != "SHA3" || cfg.getTimeout() != 3*time.Second {
+		t.Fatal("Invalid defaults.")
+	}
+
+	if _, err := Open(nil, "client", nil); err != ErrNilConfig {
+		t.Fatalf("Opening without configuration should fail with [%s], got [%v].", ErrNilConfig, err)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package state encrypts the values of the state of a chaincode with
+// AES-256 in GCM mode. Validators use Seal and Open, whose nonces they
+// derive so as to reach consensus on the ciphertexts. Chaincodes use
+// Encrypt and Decrypt, or wrap their stub in an EncryptedStub, whose
+// ciphertexts name the key they are encrypted under, so that values can
+// be rotated to a new key.
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+const (
+	// KeySize is the size, in bytes, of the state keys
+	KeySize = 32
+
+	// NonceSize is the size, in bytes, of the nonces of Seal
+	NonceSize = 12
+
+	// KeyIDSize is the size, in bytes, of the identifiers of the keys
+	KeyIDSize = 4
+
+	// version of the ciphertexts of Encrypt
+	version = 1
+
+	headerSize = 1 + KeyIDSize
+)
+
+var (
+	// ErrDecryption is returned when a value cannot be decrypted:
+	// it was altered, or encrypted under another key or additional data
+	ErrDecryption = errors.New("Failed decrypting state value.")
+
+	// ErrUnknownKey is returned when decrypting a value
+	// encrypted under none of the keys passed
+	ErrUnknownKey = errors.New("State value encrypted under an unknown key.")
+)
+
+// DeriveKey derives from root the state key labeled labels, as
+// HMAC-SHA256(root, len(label1) || label1 || ...). Chaincodes derive the
+// key of each table, or of each confidentiality domain, from a single root.
+func DeriveKey(root []byte, labels ...string) ([]byte, error) {
+	if len(root) == 0 {
+		return nil, errors.New("Invalid root key. It is empty.")
+	}
+
+	mac := hmac.New(sha256.New, root)
+	for _, label := range labels {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(label)))
+		mac.Write(size[:])
+		mac.Write([]byte(label))
+	}
+
+	return mac.Sum(nil), nil
+}
+
+// KeyID returns the identifier of key carried by the ciphertexts of Encrypt
+func KeyID(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("state key id"))
+
+	return mac.Sum(nil)[:KeyIDSize]
+}
+
+// Seal encrypts value under key with nonce, authenticating aad too,
+// and returns nonce || ciphertext. The same nonce must never be used
+// twice with the same key.
+func Seal(key, nonce, value, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("Invalid nonce length. Len was [%d], expected [%d].", len(nonce), gcm.NonceSize())
+	}
+
+	// Seal appends the ciphertext to a copy of the nonce
+	return gcm.Seal(append([]byte{}, nonce...), nonce, value, aad), nil
+}
+
+// Open decrypts raw, the output of Seal with key and aad
+func Open(key, raw, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) <= gcm.NonceSize() {
+		return nil, ErrDecryption
+	}
+
+	out, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	return out, nil
+}
+
+// Encrypt encrypts value under key with a random nonce, authenticating aad
+// too, typically the name the value is stored under
+func Encrypt(key, value, aad []byte) ([]byte, error) {
+	nonce, err := primitives.GetRandomBytes(NonceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{version}, KeyID(key)...)
+	ct, err := Seal(key, nonce, value, append(append([]byte{}, header...), aad...))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, ct...), nil
+}
+
+// Decrypt decrypts raw, the output of Encrypt with aad, under the key of
+// keys it was encrypted under
+func Decrypt(raw, aad []byte, keys ...[]byte) ([]byte, error) {
+	key, err := findKey(raw, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(key, raw[headerSize:], append(append([]byte{}, raw[:headerSize]...), aad...))
+}
+
+// Rotate re-encrypts raw, the output of Encrypt with aad under one of
+// oldKeys, under newKey
+func Rotate(raw, aad, newKey []byte, oldKeys ...[]byte) ([]byte, error) {
+	value, err := Decrypt(raw, aad, oldKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	return Encrypt(newKey, value, aad)
+}
+
+// IsEncryptedUnder returns true if raw, the output of Encrypt, was encrypted under key
+func IsEncryptedUnder(raw, key []byte) bool {
+	return len(raw) > headerSize && raw[0] == version && bytes.Equal(raw[1:headerSize], KeyID(key))
+}
+
+func findKey(raw []byte, keys [][]byte) ([]byte, error) {
+	if len(raw) <= headerSize || raw[0] != version {
+		return nil, ErrDecryption
+	}
+	for _, key := range keys {
+		if IsEncryptedUnder(raw, key) {
+			return key, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("Invalid key length. Len was [%d], expected [%d].", len(key), KeySize)
+	}
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(c)
+}
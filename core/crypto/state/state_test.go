@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"testing"
+)
+
+type mapStub map[string][]byte
+
+func (s mapStub) GetState(key string) ([]byte, error) {
+	return s[key], nil
+}
+
+func (s mapStub) PutState(key string, value []byte) error {
+	s[key] = value
+	return nil
+}
+
+func newKey(t *testing.T, labels ...string) []byte {
+	key, err := DeriveKey([]byte("root"), labels...)
+	if err != nil {
+		t.Fatalf("Failed deriving key [%s].", err)
+	}
+
+	return key
+}
+
+func TestDeriveKey(t *testing.T) {
+	key := newKey(t, "table", "accounts")
+	if len(key) != KeySize {
+		t.Fatalf("Derived key should be KeySize long [%d].", len(key))
+	}
+	if !bytes.Equal(key, newKey(t, "table", "accounts")) {
+		t.Fatal("Derivation should be deterministic.")
+	}
+	if bytes.Equal(key, newKey(t, "tablea", "ccounts")) {
+		t.Fatal("Labels should be delimited.")
+	}
+	if _, err := DeriveKey(nil, "table"); err == nil {
+		t.Fatal("Empty root should be rejected.")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	key := newKey(t)
+	nonce := make([]byte, NonceSize)
+	value := []byte("Hello World!!!")
+
+	raw, err := Seal(key, nonce, value, []byte("aad"))
+	if err != nil {
+		t.Fatalf("Failed sealing [%s].", err)
+	}
+	other, _ := Seal(key, nonce, value, []byte("aad"))
+	if !bytes.Equal(raw, other) {
+		t.Fatal("Seal should be deterministic.")
+	}
+
+	out, err := Open(key, raw, []byte("aad"))
+	if err != nil || !bytes.Equal(out, value) {
+		t.Fatalf("Failed opening [%s][%v].", out, err)
+	}
+	if _, err := Open(key, raw, []byte("other")); err != ErrDecryption {
+		t.Fatalf("Other additional data should be rejected [%v].", err)
+	}
+	if _, err := Open(newKey(t, "other"), raw, []byte("aad")); err != ErrDecryption {
+		t.Fatalf("Other key should be rejected [%v].", err)
+	}
+	if _, err := Seal(key, nonce[1:], value, nil); err == nil {
+		t.Fatal("Short nonce should be rejected.")
+	}
+	if _, err := Seal(key[1:], nonce, value, nil); err == nil {
+		t.Fatal("Short key should be rejected.")
+	}
+}
+
+func TestEncryptRotate(t *testing.T) {
+	oldKey, newKey := newKey(t, "1"), newKey(t, "2")
+	value := []byte("Hello World!!!")
+
+	raw, err := Encrypt(oldKey, value, []byte("a"))
+	if err != nil {
+		t.Fatalf("Failed encrypting [%s].", err)
+	}
+	if !IsEncryptedUnder(raw, oldKey) || IsEncryptedUnder(raw, newKey) {
+		t.Fatal("Ciphertext should name its key.")
+	}
+	if _, err := Decrypt(raw, []byte("a"), newKey); err != ErrUnknownKey {
+		t.Fatalf("Unknown key should be reported [%v].", err)
+	}
+	if _, err := Decrypt(raw, []byte("b"), oldKey); err != ErrDecryption {
+		t.Fatalf("Other additional data should be rejected [%v].", err)
+	}
+
+	raw, err = Rotate(raw, []byte("a"), newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Failed rotating [%s].", err)
+	}
+	out, err := Decrypt(raw, []byte("a"), oldKey, newKey)
+	if err != nil || !bytes.Equal(out, value) || !IsEncryptedUnder(raw, newKey) {
+		t.Fatalf("Failed decrypting rotated value [%s][%v].", out, err)
+	}
+}
+
+func TestEncryptedStub(t *testing.T) {
+	oldKey, newKey := newKey(t, "1"), newKey(t, "2")
+	backend := mapStub{}
+
+	stub, err := NewEncryptedStub(backend, oldKey)
+	if err != nil {
+		t.Fatalf("Failed creating stub [%s].", err)
+	}
+	stub.PutState("a", []byte("alice"))
+	stub.PutState("b", []byte("bob"))
+	if bytes.Contains(backend["a"], []byte("alice")) {
+		t.Fatal("Values should be stored encrypted.")
+	}
+
+	// Values are bound to their key
+	backend["c"] = backend["a"]
+	if _, err := stub.GetState("c"); err != ErrDecryption {
+		t.Fatalf("Moved value should be rejected [%v].", err)
+	}
+	delete(backend, "c")
+
+	stub, err = NewEncryptedStub(backend, newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Failed creating stub [%s].", err)
+	}
+	stub.PutState("b", []byte("bob"))
+	rotated, err := stub.Rotate("a", "b", "c")
+	if err != nil || rotated != 1 {
+		t.Fatalf("Only a should be rotated [%d][%v].", rotated, err)
+	}
+
+	stub, _ = NewEncryptedStub(backend, newKey)
+	for key, expected := range map[string]string{"a": "alice", "b": "bob", "c": ""} {
+		value, err := stub.GetState(key)
+		if err != nil || string(value) != expected {
+			t.Fatalf("Failed reading [%s]: [%s][%v].", key, value, err)
+		}
+	}
+}
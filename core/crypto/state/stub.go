@@ -0,0 +1,99 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+)
+
+// Stub is the part of the chaincode stub reading and writing the state.
+// The ChaincodeStub of the shim implements it.
+type Stub interface {
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+}
+
+// EncryptedStub encrypts the values written through a Stub, binding each
+// to the key it is stored under, and decrypts the values read
+type EncryptedStub struct {
+	stub    Stub
+	key     []byte
+	oldKeys [][]byte
+}
+
+// NewEncryptedStub returns a Stub encrypting under key. Values encrypted
+// under oldKeys are still decrypted, until rotated.
+func NewEncryptedStub(stub Stub, key []byte, oldKeys ...[]byte) (*EncryptedStub, error) {
+	if stub == nil {
+		return nil, errors.New("Invalid stub. It is nil.")
+	}
+	if len(key) != KeySize {
+		return nil, errors.New("Invalid key. Its length should be KeySize.")
+	}
+
+	return &EncryptedStub{stub, key, oldKeys}, nil
+}
+
+// GetState returns the value stored under key, decrypted, or nil if there is none
+func (s *EncryptedStub) GetState(key string) ([]byte, error) {
+	raw, err := s.stub.GetState(key)
+	if err != nil || len(raw) == 0 {
+		return nil, err
+	}
+
+	return Decrypt(raw, []byte(key), s.keys()...)
+}
+
+// PutState stores value, encrypted, under key
+func (s *EncryptedStub) PutState(key string, value []byte) error {
+	raw, err := Encrypt(s.key, value, []byte(key))
+	if err != nil {
+		return err
+	}
+
+	return s.stub.PutState(key, raw)
+}
+
+// Rotate re-encrypts under the current key the values stored under keys,
+// encrypted under an old key, and returns how many it re-encrypted
+func (s *EncryptedStub) Rotate(keys ...string) (int, error) {
+	rotated := 0
+	for _, key := range keys {
+		raw, err := s.stub.GetState(key)
+		if err != nil {
+			return rotated, err
+		}
+		if len(raw) == 0 || IsEncryptedUnder(raw, s.key) {
+			continue
+		}
+
+		raw, err = Rotate(raw, []byte(key), s.key, s.oldKeys...)
+		if err != nil {
+			return rotated, err
+		}
+		if err := s.stub.PutState(key, raw); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	return rotated, nil
+}
+
+func (s *EncryptedStub) keys() [][]byte {
+	return append([][]byte{s.key}, s.oldKeys...)
+}
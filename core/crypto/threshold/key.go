@@ -0,0 +1,210 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package threshold
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// PublicKey is the public key of a group of Players validators, any
+// Threshold of which sign together. N = (2P' + 1)(2Q' + 1) for primes P', Q'.
+// V generates the quadratic residues modulo N, and VerificationKeys[i-1]
+// is V to the key share of index i.
+type PublicKey struct {
+	N                *big.Int
+	E                *big.Int
+	Threshold        int
+	Players          int
+	V                *big.Int
+	VerificationKeys []*big.Int
+}
+
+// KeyShare is the share of the signing key of the validator of index Index,
+// in [1, Players]
+type KeyShare struct {
+	Index int
+	S     *big.Int
+}
+
+// NewKey deals the key shares of a group of players validators, any
+// threshold of which sign together, under a modulus of modulusSize bits.
+// The dealer must forget the shares once handed to the validators.
+func NewKey(threshold, players, modulusSize int) (*PublicKey, []*KeyShare, error) {
+	if modulusSize < MinModulusSize {
+		return nil, nil, fmt.Errorf("Modulus size [%d] too small. It must be at least %d bits.", modulusSize, MinModulusSize)
+	}
+	if threshold < 1 || threshold > players {
+		return nil, nil, fmt.Errorf("Invalid threshold [%d]. It must be in [1, %d].", threshold, players)
+	}
+	if publicExponent.Cmp(big.NewInt(int64(players))) <= 0 {
+		return nil, nil, fmt.Errorf("Too many players [%d].", players)
+	}
+
+	p, pPrime, err := safePrime(modulusSize / 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	q, qPrime := p, pPrime
+	for q.Cmp(p) == 0 {
+		if q, qPrime, err = safePrime(modulusSize - modulusSize/2); err != nil {
+			return nil, nil, err
+		}
+	}
+	n := new(big.Int).Mul(p, q)
+	m := new(big.Int).Mul(pPrime, qPrime)
+
+	d := new(big.Int).ModInverse(publicExponent, m)
+	if d == nil {
+		return nil, nil, fmt.Errorf("Public exponent not invertible.")
+	}
+
+	// f(X) = d + a1 X + ... + a(threshold-1) X^(threshold-1) mod m
+	coefficients := make([]*big.Int, threshold)
+	coefficients[0] = d
+	for i := 1; i < threshold; i++ {
+		if coefficients[i], err = rand.Int(primitives.RandReader, m); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	v, err := quadraticResidue(n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pk := &PublicKey{
+		N:                n,
+		E:                new(big.Int).Set(publicExponent),
+		Threshold:        threshold,
+		Players:          players,
+		V:                v,
+		VerificationKeys: make([]*big.Int, players),
+	}
+	shares := make([]*KeyShare, players)
+	for i := range shares {
+		x := big.NewInt(int64(i + 1))
+		s := new(big.Int)
+		for j := threshold - 1; j >= 0; j-- {
+			s.Mul(s, x)
+			s.Add(s, coefficients[j])
+			s.Mod(s, m)
+		}
+
+		shares[i] = &KeyShare{Index: i + 1, S: s}
+		pk.VerificationKeys[i] = new(big.Int).Exp(v, s, n)
+	}
+
+	return pk, shares, nil
+}
+
+// Bytes marshals the public key
+func (pk *PublicKey) Bytes() ([]byte, error) {
+	return asn1.Marshal(*pk)
+}
+
+// Bytes marshals the key share
+func (share *KeyShare) Bytes() ([]byte, error) {
+	return asn1.Marshal(*share)
+}
+
+// ParsePublicKey unmarshals a public key
+func ParsePublicKey(raw []byte) (*PublicKey, error) {
+	pk := &PublicKey{}
+	if rest, err := asn1.Unmarshal(raw, pk); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("Invalid threshold public key. Trailing data.")
+	}
+	if err := pk.check(); err != nil {
+		return nil, err
+	}
+
+	return pk, nil
+}
+
+// ParseKeyShare unmarshals a key share
+func ParseKeyShare(raw []byte) (*KeyShare, error) {
+	share := &KeyShare{}
+	if rest, err := asn1.Unmarshal(raw, share); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("Invalid key share. Trailing data.")
+	}
+	if share.Index < 1 || share.S == nil || share.S.Sign() < 0 {
+		return nil, fmt.Errorf("Invalid key share.")
+	}
+
+	return share, nil
+}
+
+func (pk *PublicKey) check() error {
+	if pk.N == nil || pk.N.BitLen() < MinModulusSize {
+		return fmt.Errorf("Invalid threshold public key. Modulus too small.")
+	}
+	if pk.E == nil || pk.E.Cmp(big.NewInt(int64(pk.Players))) <= 0 || !pk.E.ProbablyPrime(20) {
+		return fmt.Errorf("Invalid threshold public key. Public exponent must be a prime larger than the number of players.")
+	}
+	if pk.Threshold < 1 || pk.Threshold > pk.Players {
+		return fmt.Errorf("Invalid threshold public key. Threshold out of range.")
+	}
+	if len(pk.VerificationKeys) != pk.Players {
+		return fmt.Errorf("Invalid threshold public key. Expected [%d] verification keys, got [%d].", pk.Players, len(pk.VerificationKeys))
+	}
+	for _, base := range append([]*big.Int{pk.V}, pk.VerificationKeys...) {
+		if base == nil || base.Sign() <= 0 || base.Cmp(pk.N) >= 0 {
+			return fmt.Errorf("Invalid threshold public key. Base out of range.")
+		}
+	}
+
+	return nil
+}
+
+// safePrime returns a prime p of bits bits such that p' = (p - 1) / 2 is prime too
+func safePrime(bits int) (p, pPrime *big.Int, err error) {
+	for {
+		if pPrime, err = rand.Prime(primitives.RandReader, bits-1); err != nil {
+			return nil, nil, err
+		}
+		p = new(big.Int).Lsh(pPrime, 1)
+		p.Add(p, one)
+		if p.BitLen() == bits && p.ProbablyPrime(20) {
+			return p, pPrime, nil
+		}
+	}
+}
+
+// quadraticResidue returns a random quadratic residue modulo n, other than 1
+func quadraticResidue(n *big.Int) (*big.Int, error) {
+	for {
+		x, err := rand.Int(primitives.RandReader, n)
+		if err != nil {
+			return nil, err
+		}
+		if new(big.Int).GCD(nil, nil, x, n).Cmp(one) != 0 {
+			continue
+		}
+		x.Exp(x, big.NewInt(2), n)
+		if x.Cmp(one) != 0 {
+			return x, nil
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package threshold
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// Let x be the representative of the message, and delta = Players!.
+// The validator of index i signs with X_i = x^(2 delta s_i), and proves
+// that log_(x^(4 delta)) X_i^2 = log_V V_i, its verification key.
+// Any set S of Threshold signature shares combines into w = prod X_i^(2 l_i),
+// l_i being delta times the Lagrange coefficient at 0 of i in S, so that
+// w^E = x^(4 delta^2). As E is a prime larger than Players, a E + b 4 delta^2 = 1
+// for some integers a, b, and the signature is y = x^a w^b, with y^E = x.
+
+// SignatureShare is the share of a signature of the validator of index
+// Index, with the proof (C, Z) that it was computed with its key share
+type SignatureShare struct {
+	Index int
+	X     *big.Int
+	C     *big.Int
+	Z     *big.Int
+}
+
+// Sign returns the signature share of msg under share, a key share of pk
+func (share *KeyShare) Sign(pk *PublicKey, msg []byte) (*SignatureShare, error) {
+	if share.Index < 1 || share.Index > pk.Players {
+		return nil, fmt.Errorf("Invalid key share index [%d].", share.Index)
+	}
+
+	delta := factorial(pk.Players)
+	x := messageRepresentative(msg, pk.N)
+	xi := new(big.Int).Exp(x, new(big.Int).Mul(big.NewInt(2), new(big.Int).Mul(delta, share.S)), pk.N)
+
+	// Prove that X_i^2 and V_i share the discrete log s_i to the bases x^(4 delta) and V
+	xTilde := new(big.Int).Exp(x, new(big.Int).Mul(big.NewInt(4), delta), pk.N)
+	r, err := rand.Int(primitives.RandReader, new(big.Int).Lsh(one, uint(pk.N.BitLen()+2*lh+lstat)))
+	if err != nil {
+		return nil, err
+	}
+	vPrime := new(big.Int).Exp(pk.V, r, pk.N)
+	xPrime := new(big.Int).Exp(xTilde, r, pk.N)
+
+	c, err := shareChallenge(pk, share.Index, xTilde, xi, vPrime, xPrime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignatureShare{
+		Index: share.Index,
+		X:     xi,
+		C:     c,
+		Z:     r.Add(r, new(big.Int).Mul(share.S, c)),
+	}, nil
+}
+
+// Verify checks that signatureShare is the signature share of msg
+// under the key share of its index
+func (signatureShare *SignatureShare) Verify(pk *PublicKey, msg []byte) error {
+	if signatureShare.Index < 1 || signatureShare.Index > pk.Players {
+		return ErrInvalidShare
+	}
+	if signatureShare.X == nil || signatureShare.C == nil || signatureShare.Z == nil {
+		return ErrInvalidShare
+	}
+	if signatureShare.X.Sign() <= 0 || signatureShare.X.Cmp(pk.N) >= 0 {
+		return ErrInvalidShare
+	}
+	if signatureShare.Z.Sign() < 0 || signatureShare.Z.BitLen() > pk.N.BitLen()+2*lh+lstat+1 {
+		return ErrInvalidShare
+	}
+
+	delta := factorial(pk.Players)
+	x := messageRepresentative(msg, pk.N)
+	xTilde := new(big.Int).Exp(x, new(big.Int).Mul(big.NewInt(4), delta), pk.N)
+	negC := new(big.Int).Neg(signatureShare.C)
+
+	// V' = V^z V_i^-c, x' = x~^z X_i^-2c
+	vi := pk.VerificationKeys[signatureShare.Index-1]
+	vPrime := new(big.Int).Exp(pk.V, signatureShare.Z, pk.N)
+	vPrime.Mul(vPrime, expMod(vi, negC, pk.N))
+	vPrime.Mod(vPrime, pk.N)
+	xi2 := new(big.Int).Exp(signatureShare.X, big.NewInt(2), pk.N)
+	xPrime := new(big.Int).Exp(xTilde, signatureShare.Z, pk.N)
+	xPrime.Mul(xPrime, expMod(xi2, negC, pk.N))
+	xPrime.Mod(xPrime, pk.N)
+
+	c, err := shareChallenge(pk, signatureShare.Index, xTilde, signatureShare.X, vPrime, xPrime)
+	if err != nil {
+		return err
+	}
+	if c.Cmp(signatureShare.C) != 0 {
+		return ErrInvalidShare
+	}
+
+	return nil
+}
+
+// Combine combines the signature shares of msg into a signature. Invalid
+// shares, and shares of an index already seen, are skipped; the first
+// Threshold valid ones are combined.
+func Combine(pk *PublicKey, msg []byte, shares []*SignatureShare) ([]byte, error) {
+	valid := []*SignatureShare{}
+	seen := map[int]bool{}
+	for _, share := range shares {
+		if len(valid) == pk.Threshold {
+			break
+		}
+		if share == nil || seen[share.Index] || share.Verify(pk, msg) != nil {
+			continue
+		}
+		seen[share.Index] = true
+		valid = append(valid, share)
+	}
+	if len(valid) < pk.Threshold {
+		return nil, ErrNotEnoughShares
+	}
+
+	indices := make([]int, len(valid))
+	for i, share := range valid {
+		indices[i] = share.Index
+	}
+
+	// w = prod X_i^(2 l_i)
+	delta := factorial(pk.Players)
+	w := big.NewInt(1)
+	for _, share := range valid {
+		l := lagrange(delta, share.Index, indices)
+		w.Mul(w, expMod(share.X, l.Lsh(l, 1), pk.N))
+		w.Mod(w, pk.N)
+	}
+
+	// y = x^a w^b, with a E + b 4 delta^2 = 1
+	ePrime := new(big.Int).Mul(delta, delta)
+	ePrime.Lsh(ePrime, 2)
+	a, b := new(big.Int), new(big.Int)
+	if new(big.Int).GCD(a, b, pk.E, ePrime).Cmp(one) != 0 {
+		return nil, fmt.Errorf("Invalid threshold public key. Public exponent not coprime to 4 delta^2.")
+	}
+	x := messageRepresentative(msg, pk.N)
+	y := expMod(x, a, pk.N)
+	y.Mul(y, expMod(w, b, pk.N))
+	y.Mod(y, pk.N)
+
+	signature := y.Bytes()
+	if err := pk.Verify(msg, signature); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+// Verify checks that signature is a signature of msg under pk. Only the
+// modulus and the public exponent are used: a light client needs no more.
+func (pk *PublicKey) Verify(msg, signature []byte) error {
+	y := new(big.Int).SetBytes(signature)
+	if y.Sign() == 0 || y.Cmp(pk.N) >= 0 {
+		return ErrInvalidSignature
+	}
+
+	if new(big.Int).Exp(y, pk.E, pk.N).Cmp(messageRepresentative(msg, pk.N)) != 0 {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Bytes marshals the signature share
+func (signatureShare *SignatureShare) Bytes() ([]byte, error) {
+	return asn1.Marshal(*signatureShare)
+}
+
+// ParseSignatureShare unmarshals a signature share
+func ParseSignatureShare(raw []byte) (*SignatureShare, error) {
+	signatureShare := &SignatureShare{}
+	if rest, err := asn1.Unmarshal(raw, signatureShare); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("Invalid signature share. Trailing data.")
+	}
+
+	return signatureShare, nil
+}
+
+func shareChallenge(pk *PublicKey, index int, xTilde, xi, vPrime, xPrime *big.Int) (*big.Int, error) {
+	xi2 := new(big.Int).Exp(xi, big.NewInt(2), pk.N)
+
+	return challenge(pk.N, pk.V, pk.VerificationKeys[index-1], xTilde, xi2, vPrime, xPrime)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package threshold implements the threshold RSA signatures of Shoup,
+// "Practical Threshold Signatures", for the attestations of validators.
+//
+// A dealer splits an RSA signing key into one share per validator. Any
+// Threshold validators sign a block or a state hash with their share, and
+// the signature shares, each proven correct, are combined by anyone into a
+// single RSA signature. A light client verifies the signature with the
+// public key only, without collecting a signature per validator, and fewer
+// than Threshold validators learn nothing about the signing key.
+package threshold
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const (
+	// DefaultModulusSize is the size, in bits, of the RSA modulus
+	DefaultModulusSize = 2048
+
+	// MinModulusSize is the size, in bits, of the smallest RSA modulus accepted
+	MinModulusSize = 1024
+
+	// Size, in bits, of the challenges of the proofs of correctness
+	lh = 256
+
+	// Statistical zero-knowledge parameter
+	lstat = 128
+)
+
+var (
+	// ErrInvalidShare is returned when a signature share is not the one
+	// of the key share of its index
+	ErrInvalidShare = errors.New("Invalid signature share.")
+
+	// ErrInvalidSignature is returned when a signature does not verify
+	// under the public key
+	ErrInvalidSignature = errors.New("Invalid signature.")
+
+	// ErrNotEnoughShares is returned when combining fewer valid signature
+	// shares, from distinct validators, than the threshold
+	ErrNotEnoughShares = errors.New("Not enough valid signature shares.")
+
+	// publicExponent is the RSA public exponent. It must be a prime larger
+	// than the number of validators.
+	publicExponent = big.NewInt(65537)
+
+	one = big.NewInt(1)
+)
+
+// messageRepresentative hashes msg to an integer modulo n, with a
+// full-domain hash: SHA-256 in counter mode, 128 bits longer than n,
+// reduced modulo n
+func messageRepresentative(msg []byte, n *big.Int) *big.Int {
+	size := (n.BitLen()+lstat)/8 + 1
+	digest := sha256.Sum256(msg)
+
+	buf := make([]byte, 0, size+sha256.Size)
+	counter := make([]byte, 4)
+	for i := uint32(0); len(buf) < size; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		block := sha256.Sum256(append(append(counter, digest[:]...), n.Bytes()...))
+		buf = append(buf, block[:]...)
+	}
+
+	return new(big.Int).Mod(new(big.Int).SetBytes(buf[:size]), n)
+}
+
+// factorial returns n!
+func factorial(n int) *big.Int {
+	return new(big.Int).MulRange(1, int64(n))
+}
+
+// lagrange returns delta times the Lagrange coefficient at 0 of index i
+// in the set of indices, an integer since delta = players!
+func lagrange(delta *big.Int, i int, indices []int) *big.Int {
+	num := new(big.Int).Set(delta)
+	den := big.NewInt(1)
+	for _, j := range indices {
+		if j == i {
+			continue
+		}
+		num.Mul(num, big.NewInt(int64(-j)))
+		den.Mul(den, big.NewInt(int64(i-j)))
+	}
+
+	return num.Quo(num, den)
+}
+
+// expMod computes base^exp mod n, for a possibly negative exp
+func expMod(base, exp, n *big.Int) *big.Int {
+	if exp.Sign() >= 0 {
+		return new(big.Int).Exp(base, exp, n)
+	}
+
+	inv := new(big.Int).ModInverse(base, n)
+	if inv == nil {
+		// base is not invertible, the result is not defined
+		return new(big.Int)
+	}
+
+	return new(big.Int).Exp(inv, new(big.Int).Neg(exp), n)
+}
+
+// challenge hashes values, marshalled in ASN.1, into a challenge
+func challenge(values ...interface{}) (*big.Int, error) {
+	raw, err := asn1.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(raw)
+
+	return new(big.Int).SetBytes(digest[:]), nil
+}
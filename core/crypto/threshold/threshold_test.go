@@ -0,0 +1,173 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package threshold
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var (
+	pk     *PublicKey
+	shares []*KeyShare
+	msg    = []byte("Block 42 state hash")
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	pk, shares, err = NewKey(3, 5, MinModulusSize)
+	if err != nil {
+		fmt.Printf("Failed generating threshold key [%s].\n", err)
+		os.Exit(-1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func signShares(t *testing.T, indices ...int) []*SignatureShare {
+	signatureShares := []*SignatureShare{}
+	for _, i := range indices {
+		signatureShare, err := shares[i-1].Sign(pk, msg)
+		if err != nil {
+			t.Fatalf("Failed signing with share [%d] [%s].", i, err)
+		}
+		if err := signatureShare.Verify(pk, msg); err != nil {
+			t.Fatalf("Failed verifying signature share [%d] [%s].", i, err)
+		}
+		signatureShares = append(signatureShares, signatureShare)
+	}
+
+	return signatureShares
+}
+
+func TestCombine(t *testing.T) {
+	for _, indices := range [][]int{{1, 2, 3}, {5, 3, 1}, {2, 4, 5}, {1, 2, 3, 4, 5}} {
+		signature, err := Combine(pk, msg, signShares(t, indices...))
+		if err != nil {
+			t.Fatalf("Failed combining shares %v [%s].", indices, err)
+		}
+		if err := pk.Verify(msg, signature); err != nil {
+			t.Fatalf("Failed verifying signature of shares %v [%s].", indices, err)
+		}
+		if err := pk.Verify([]byte("Another block"), signature); err != ErrInvalidSignature {
+			t.Fatalf("Signature of another message should not verify [%v].", err)
+		}
+	}
+}
+
+func TestCombineNotEnoughShares(t *testing.T) {
+	signatureShares := signShares(t, 1, 2)
+	if _, err := Combine(pk, msg, signatureShares); err != ErrNotEnoughShares {
+		t.Fatalf("Two shares should not be enough [%v].", err)
+	}
+
+	// The same validator twice counts once
+	signatureShares = append(signatureShares, signatureShares[1])
+	if _, err := Combine(pk, msg, signatureShares); err != ErrNotEnoughShares {
+		t.Fatalf("Duplicate shares should count once [%v].", err)
+	}
+}
+
+func TestInvalidShare(t *testing.T) {
+	signatureShares := signShares(t, 1, 2, 3, 4)
+
+	// A share claiming another index
+	forged := *signatureShares[0]
+	forged.Index = 5
+	if err := forged.Verify(pk, msg); err != ErrInvalidShare {
+		t.Fatalf("Share of another index should be rejected [%v].", err)
+	}
+
+	// A share of another message
+	other, err := shares[1].Sign(pk, []byte("Another block"))
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	if err := other.Verify(pk, msg); err != ErrInvalidShare {
+		t.Fatalf("Share of another message should be rejected [%v].", err)
+	}
+
+	// A tampered share
+	tampered := *signatureShares[2]
+	tampered.X = new(big.Int).Add(tampered.X, one)
+	if err := tampered.Verify(pk, msg); err != ErrInvalidShare {
+		t.Fatalf("Tampered share should be rejected [%v].", err)
+	}
+
+	// Invalid shares are skipped
+	signature, err := Combine(pk, msg, []*SignatureShare{&forged, other, &tampered, signatureShares[1], signatureShares[3], signatureShares[0]})
+	if err != nil {
+		t.Fatalf("Failed combining shares [%s].", err)
+	}
+	if err := pk.Verify(msg, signature); err != nil {
+		t.Fatalf("Failed verifying signature [%s].", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	raw, err := pk.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling public key [%s].", err)
+	}
+	pk2, err := ParsePublicKey(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing public key [%s].", err)
+	}
+	if !reflect.DeepEqual(pk, pk2) {
+		t.Fatal("Public keys should be equal.")
+	}
+
+	raw, err = shares[0].Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling key share [%s].", err)
+	}
+	share, err := ParseKeyShare(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing key share [%s].", err)
+	}
+
+	signatureShare, err := share.Sign(pk2, msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	raw, err = signatureShare.Bytes()
+	if err != nil {
+		t.Fatalf("Failed marshalling signature share [%s].", err)
+	}
+	signatureShare2, err := ParseSignatureShare(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing signature share [%s].", err)
+	}
+	if err := signatureShare2.Verify(pk, msg); err != nil {
+		t.Fatalf("Failed verifying signature share [%s].", err)
+	}
+}
+
+func TestNewKeyInvalidParameters(t *testing.T) {
+	if _, _, err := NewKey(3, 5, MinModulusSize-1); err == nil {
+		t.Fatal("Small moduli should be rejected.")
+	}
+	if _, _, err := NewKey(0, 5, MinModulusSize); err == nil {
+		t.Fatal("Null threshold should be rejected.")
+	}
+	if _, _, err := NewKey(6, 5, MinModulusSize); err == nil {
+		t.Fatal("Threshold above the number of players should be rejected.")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tpm
+
+import (
+	"encoding/binary"
+)
+
+const (
+	tagNoSessions uint16 = 0x8001
+	tagSessions   uint16 = 0x8002
+	stHashcheck   uint16 = 0x8024
+
+	ccCreatePrimary uint32 = 0x00000131
+	ccCreate        uint32 = 0x00000153
+	ccLoad          uint32 = 0x00000157
+	ccSign          uint32 = 0x0000015D
+	ccFlushContext  uint32 = 0x00000165
+
+	rhOwner uint32 = 0x40000001
+	rhNull  uint32 = 0x40000007
+	rsPW    uint32 = 0x40000009
+
+	algAES    uint16 = 0x0006
+	algSHA256 uint16 = 0x000B
+	algSHA384 uint16 = 0x000C
+	algNull   uint16 = 0x0010
+	algECDSA  uint16 = 0x0018
+	algECC    uint16 = 0x0023
+	algCFB    uint16 = 0x0043
+
+	eccNistP256 uint16 = 0x0003
+	eccNistP384 uint16 = 0x0004
+
+	attrFixedTPM            uint32 = 1 << 1
+	attrFixedParent         uint32 = 1 << 4
+	attrSensitiveDataOrigin uint32 = 1 << 5
+	attrUserWithAuth        uint32 = 1 << 6
+	attrNoDA                uint32 = 1 << 10
+	attrRestricted          uint32 = 1 << 16
+	attrDecrypt             uint32 = 1 << 17
+	attrSign                uint32 = 1 << 18
+
+	// Bound of the size of the responses of the TPM
+	maxResponseSize = 4096
+)
+
+var (
+	// TPM curve identifiers, by name of the curve
+	curveIDs = map[string]uint16{
+		"P-256": eccNistP256,
+		"P-384": eccNistP384,
+	}
+)
+
+// eccPublic returns the public area template of an ECC key,
+// with a symmetric AES-128-CFB key if a storage key
+func eccPublic(attrs uint32, storage bool, curveID uint16) []byte {
+	w := &writer{}
+	w.u16(algECC)
+	w.u16(algSHA256)
+	w.u32(attrs)
+	w.b2(nil) // authPolicy
+	if storage {
+		w.u16(algAES)
+		w.u16(128)
+		w.u16(algCFB)
+	} else {
+		w.u16(algNull)
+	}
+	w.u16(algNull) // scheme, chosen when signing
+	w.u16(curveID)
+	w.u16(algNull) // kdf
+	w.b2(nil)      // unique.x
+	w.b2(nil)      // unique.y
+
+	return w.bytes()
+}
+
+// createParams returns the parameters of TPM2_CreatePrimary
+// and TPM2_Create for the public area template public
+func createParams(public []byte) []byte {
+	w := &writer{}
+	// inSensitive, with empty userAuth and data
+	w.u16(4)
+	w.b2(nil)
+	w.b2(nil)
+	w.b2(public)
+	w.b2(nil) // outsideInfo
+	w.u32(0)  // creationPCR, no PCR
+
+	return w.bytes()
+}
+
+// writer marshals TPM structures, big-endian
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) u16(v uint16) {
+	w.buf = append(w.buf, byte(v>>8), byte(v))
+}
+
+func (w *writer) u32(v uint32) {
+	w.buf = append(w.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// b2 marshals b as a TPM2B, prefixed by its size
+func (w *writer) b2(b []byte) {
+	w.u16(uint16(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *writer) bytes() []byte {
+	return w.buf
+}
+
+// reader unmarshals TPM structures. Its first
+// error is sticky, and the values read after it zero.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+
+	return b
+}
+
+func (r *reader) u16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+// b2 unmarshals a TPM2B and returns its content
+func (r *reader) b2() []byte {
+	size := r.u16()
+	b := r.next(int(size))
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,327 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tpm creates ECDSA keys sealed to a TPM 2.0 device and signs with
+// them, speaking the TPM 2.0 command protocol to the device directly.
+//
+// A key is created under a primary storage key of the owner hierarchy, with
+// the fixedTPM and fixedParent attributes: its private area leaves the TPM
+// only encrypted under the primary key, as a SealedKey, which no other TPM
+// can load. The primary key is derived again from the same template each
+// time, so that it needs not be stored. Only password authorizations with
+// an empty password are used.
+package tpm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+)
+
+// Handle is the handle of an object loaded in the TPM
+type Handle uint32
+
+// Error is the response code of a command failed by the TPM
+type Error uint32
+
+func (e Error) Error() string {
+	return fmt.Sprintf("TPM command failed with response code [0x%x].", uint32(e))
+}
+
+// SealedKey is a key created by the TPM: its public area, and
+// its private area encrypted under the key of its parent
+type SealedKey struct {
+	Public  []byte
+	Private []byte
+}
+
+// TPM is a connection to a TPM 2.0 device. Its methods are
+// safe for concurrent use.
+type TPM struct {
+	rw io.ReadWriter
+
+	m sync.Mutex
+}
+
+var (
+	// ErrUnsupportedCurve is returned for curves other than P-256 and P-384
+	ErrUnsupportedCurve = errors.New("Curve not supported by the TPM.")
+
+	// ErrInvalidDigest is returned when signing a digest neither
+	// of 32 nor of 48 bytes
+	ErrInvalidDigest = errors.New("Invalid digest size.")
+
+	errTruncated = errors.New("Truncated TPM structure.")
+)
+
+// Open opens the TPM device at path, a resource manager such as
+// /dev/tpmrm0, so that the objects loaded are flushed when closed
+func Open(path string) (*TPM, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(f), nil
+}
+
+// New returns a TPM sending the commands to, and reading the responses
+// from, rw. Each command is written, and each response read, at once.
+func New(rw io.ReadWriter) *TPM {
+	return &TPM{rw: rw}
+}
+
+// Close closes the device, if closable
+func (t *TPM) Close() error {
+	if c, ok := t.rw.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// CreatePrimary loads the primary storage key of the owner hierarchy
+func (t *TPM) CreatePrimary() (Handle, error) {
+	public := eccPublic(attrFixedTPM|attrFixedParent|attrSensitiveDataOrigin|attrUserWithAuth|attrNoDA|attrRestricted|attrDecrypt, true, eccNistP256)
+
+	handles, _, err := t.run(ccCreatePrimary, []uint32{rhOwner}, true, createParams(public), 1)
+	if err != nil {
+		return 0, err
+	}
+
+	return Handle(handles[0]), nil
+}
+
+// CreateKey creates an ECDSA signing key on curve under the storage key
+// parent, and returns it sealed along with its public key
+func (t *TPM) CreateKey(parent Handle, curve elliptic.Curve) (*SealedKey, *ecdsa.PublicKey, error) {
+	curveID, ok := curveIDs[curve.Params().Name]
+	if !ok {
+		return nil, nil, ErrUnsupportedCurve
+	}
+	public := eccPublic(attrFixedTPM|attrFixedParent|attrSensitiveDataOrigin|attrUserWithAuth|attrNoDA|attrSign, false, curveID)
+
+	_, r, err := t.run(ccCreate, []uint32{uint32(parent)}, true, createParams(public), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := &SealedKey{Private: r.b2(), Public: r.b2()}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	pub, err := key.PublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, pub, nil
+}
+
+// Load loads key under the storage key parent it was created under
+func (t *TPM) Load(parent Handle, key *SealedKey) (Handle, error) {
+	w := &writer{}
+	w.b2(key.Private)
+	w.b2(key.Public)
+
+	handles, _, err := t.run(ccLoad, []uint32{uint32(parent)}, true, w.bytes(), 1)
+	if err != nil {
+		return 0, err
+	}
+
+	return Handle(handles[0]), nil
+}
+
+// Sign signs digest with the ECDSA key loaded at key. The digest must be
+// of 32 or 48 bytes, the TPM binding it to SHA-256 or SHA-384 by its size.
+func (t *TPM) Sign(key Handle, digest []byte) (*big.Int, *big.Int, error) {
+	var hashAlg uint16
+	switch len(digest) {
+	case 32:
+		hashAlg = algSHA256
+	case 48:
+		hashAlg = algSHA384
+	default:
+		return nil, nil, ErrInvalidDigest
+	}
+
+	w := &writer{}
+	w.b2(digest)
+	w.u16(algECDSA)
+	w.u16(hashAlg)
+	// Null ticket: the digest was not computed by the TPM
+	w.u16(stHashcheck)
+	w.u32(rhNull)
+	w.b2(nil)
+
+	_, r, err := t.run(ccSign, []uint32{uint32(key)}, true, w.bytes(), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sigAlg := r.u16(); r.err == nil && sigAlg != algECDSA {
+		return nil, nil, fmt.Errorf("Unexpected signature algorithm [0x%x].", sigAlg)
+	}
+	r.u16()
+	sigR, sigS := r.b2(), r.b2()
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	return new(big.Int).SetBytes(sigR), new(big.Int).SetBytes(sigS), nil
+}
+
+// Flush unloads the object loaded at handle
+func (t *TPM) Flush(handle Handle) error {
+	w := &writer{}
+	w.u32(uint32(handle))
+
+	_, _, err := t.run(ccFlushContext, nil, false, w.bytes(), 0)
+
+	return err
+}
+
+// PublicKey returns the public key of key
+func (key *SealedKey) PublicKey() (*ecdsa.PublicKey, error) {
+	r := &reader{b: key.Public}
+	if typ := r.u16(); r.err == nil && typ != algECC {
+		return nil, fmt.Errorf("Unexpected key type [0x%x].", typ)
+	}
+	r.u16() // nameAlg
+	r.u32() // objectAttributes
+	r.b2()  // authPolicy
+	if sym := r.u16(); sym != algNull {
+		r.u16() // keyBits
+		r.u16() // mode
+	}
+	if scheme := r.u16(); scheme != algNull {
+		r.u16() // hashAlg
+	}
+	curveID := r.u16()
+	if kdf := r.u16(); kdf != algNull {
+		r.u16() // hashAlg
+	}
+	x, y := r.b2(), r.b2()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var curve elliptic.Curve
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		if curveIDs[c.Params().Name] == curveID {
+			curve = c
+		}
+	}
+	if curve == nil {
+		return nil, ErrUnsupportedCurve
+	}
+
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("Invalid public key. Point not on curve.")
+	}
+
+	return pub, nil
+}
+
+// Marshal returns the encoding of key, to be parsed by ParseSealedKey
+func (key *SealedKey) Marshal() ([]byte, error) {
+	return asn1.Marshal(*key)
+}
+
+// ParseSealedKey parses a key encoded by SealedKey.Marshal
+func ParseSealedKey(raw []byte) (*SealedKey, error) {
+	key := new(SealedKey)
+	rest, err := asn1.Unmarshal(raw, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("Trailing data after sealed key.")
+	}
+
+	return key, nil
+}
+
+// run sends the command cc, on handles, authorized with a password session
+// if auth, and returns the nHandles handles and the parameters of the response
+func (t *TPM) run(cc uint32, handles []uint32, auth bool, params []byte, nHandles int) ([]uint32, *reader, error) {
+	body := &writer{}
+	for _, h := range handles {
+		body.u32(h)
+	}
+	tag := tagNoSessions
+	if auth {
+		tag = tagSessions
+		// One password session, with an empty password
+		session := &writer{}
+		session.u32(rsPW)
+		session.b2(nil)
+		session.buf = append(session.buf, 0)
+		session.b2(nil)
+		body.u32(uint32(len(session.buf)))
+		body.buf = append(body.buf, session.buf...)
+	}
+	body.buf = append(body.buf, params...)
+
+	cmd := &writer{}
+	cmd.u16(tag)
+	cmd.u32(uint32(10 + len(body.buf)))
+	cmd.u32(cc)
+	cmd.buf = append(cmd.buf, body.buf...)
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if _, err := t.rw.Write(cmd.buf); err != nil {
+		return nil, nil, err
+	}
+	resp := make([]byte, maxResponseSize)
+	n, err := t.rw.Read(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &reader{b: resp[:n]}
+	respTag := r.u16()
+	if size := r.u32(); r.err == nil && int(size) != n {
+		return nil, nil, errTruncated
+	}
+	if rc := r.u32(); r.err == nil && rc != 0 {
+		return nil, nil, Error(rc)
+	}
+	out := make([]uint32, nHandles)
+	for i := range out {
+		out[i] = r.u32()
+	}
+	if respTag == tagSessions {
+		size := r.u32()
+		if r.err == nil && int(size) > len(r.b) {
+			return nil, nil, errTruncated
+		}
+		r.b = r.b[:size]
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	return out, r, nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tpm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+// fakeTPM records the last command and replies with resp
+type fakeTPM struct {
+	cmd  []byte
+	resp []byte
+}
+
+func (f *fakeTPM) Write(b []byte) (int, error) {
+	f.cmd = append([]byte{}, b...)
+	return len(b), nil
+}
+
+func (f *fakeTPM) Read(b []byte) (int, error) {
+	return copy(b, f.resp), nil
+}
+
+// response returns a successful response, with a password session,
+// carrying handles and params
+func response(handles []uint32, params []byte) []byte {
+	body := &writer{}
+	for _, h := range handles {
+		body.u32(h)
+	}
+	body.u32(uint32(len(params)))
+	body.buf = append(body.buf, params...)
+	body.b2(nil)
+	body.buf = append(body.buf, 0)
+	body.b2(nil)
+
+	w := &writer{}
+	w.u16(tagSessions)
+	w.u32(uint32(10 + len(body.buf)))
+	w.u32(0)
+	w.buf = append(w.buf, body.buf...)
+
+	return w.bytes()
+}
+
+func checkCommand(t *testing.T, cmd []byte, cc uint32, handle uint32) {
+	if binary.BigEndian.Uint16(cmd) != tagSessions {
+		t.Fatal("Command should carry a session.")
+	}
+	if int(binary.BigEndian.Uint32(cmd[2:])) != len(cmd) {
+		t.Fatal("Invalid command size.")
+	}
+	if binary.BigEndian.Uint32(cmd[6:]) != cc {
+		t.Fatalf("Invalid command code [0x%x].", binary.BigEndian.Uint32(cmd[6:]))
+	}
+	if binary.BigEndian.Uint32(cmd[10:]) != handle {
+		t.Fatalf("Invalid handle [0x%x].", binary.BigEndian.Uint32(cmd[10:]))
+	}
+}
+
+func TestCreateAndLoadKey(t *testing.T) {
+	fake := &fakeTPM{}
+	tpm := New(fake)
+
+	fake.resp = response([]uint32{0x80000000}, nil)
+	parent, err := tpm.CreatePrimary()
+	if err != nil {
+		t.Fatalf("Failed creating primary key [%s].", err)
+	}
+	checkCommand(t, fake.cmd, ccCreatePrimary, rhOwner)
+	if parent != 0x80000000 {
+		t.Fatalf("Invalid primary key handle [0x%x].", parent)
+	}
+
+	// The TPM returns the template with the public point
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed generating key [%s].", err)
+	}
+	public := eccPublic(attrFixedTPM|attrSign, false, eccNistP256)
+	public = public[:len(public)-4]
+	w := &writer{buf: public}
+	w.b2(sk.X.Bytes())
+	w.b2(sk.Y.Bytes())
+	params := &writer{}
+	params.b2([]byte("private"))
+	params.b2(w.bytes())
+	fake.resp = response(nil, params.bytes())
+
+	key, pub, err := tpm.CreateKey(parent, elliptic.P256())
+	if err != nil {
+		t.Fatalf("Failed creating key [%s].", err)
+	}
+	checkCommand(t, fake.cmd, ccCreate, uint32(parent))
+	if !bytes.Equal(key.Private, []byte("private")) {
+		t.Fatal("Invalid private area.")
+	}
+	if pub.X.Cmp(sk.X) != 0 || pub.Y.Cmp(sk.Y) != 0 {
+		t.Fatal("Invalid public key.")
+	}
+
+	raw, err := key.Marshal()
+	if err != nil {
+		t.Fatalf("Failed marshalling sealed key [%s].", err)
+	}
+	key, err = ParseSealedKey(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing sealed key [%s].", err)
+	}
+
+	fake.resp = response([]uint32{0x80000001}, []byte{0, 0})
+	handle, err := tpm.Load(parent, key)
+	if err != nil {
+		t.Fatalf("Failed loading key [%s].", err)
+	}
+	checkCommand(t, fake.cmd, ccLoad, uint32(parent))
+	if handle != 0x80000001 {
+		t.Fatalf("Invalid key handle [0x%x].", handle)
+	}
+
+	if _, _, err := tpm.CreateKey(parent, elliptic.P224()); err != ErrUnsupportedCurve {
+		t.Fatalf("Creating a P-224 key should fail with [%s], got [%v].", ErrUnsupportedCurve, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	fake := &fakeTPM{}
+	tpm := New(fake)
+
+	params := &writer{}
+	params.u16(algECDSA)
+	params.u16(algSHA256)
+	params.b2(big.NewInt(12345).Bytes())
+	params.b2(big.NewInt(67890).Bytes())
+	fake.resp = response(nil, params.bytes())
+
+	r, s, err := tpm.Sign(0x80000001, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed signing [%s].", err)
+	}
+	checkCommand(t, fake.cmd, ccSign, 0x80000001)
+	if r.Int64() != 12345 || s.Int64() != 67890 {
+		t.Fatal("Invalid signature.")
+	}
+
+	if _, _, err := tpm.Sign(0x80000001, make([]byte, 20)); err != ErrInvalidDigest {
+		t.Fatalf("Signing a 20 bytes digest should fail with [%s], got [%v].", ErrInvalidDigest, err)
+	}
+}
+
+func TestError(t *testing.T) {
+	w := &writer{}
+	w.u16(tagNoSessions)
+	w.u32(10)
+	w.u32(0x98e)
+	fake := &fakeTPM{resp: w.bytes()}
+
+	if _, err := New(fake).CreatePrimary(); err != Error(0x98e) {
+		t.Fatalf("Failed command should return its response code, got [%v].", err)
+	}
+
+	fake.resp = fake.resp[:8]
+	if err := New(fake).Flush(0x80000000); err == nil {
+		t.Fatal("Truncated response should fail.")
+	}
+}
@@ -61,6 +61,12 @@ var (
 	// ErrKeyStoreAlreadyInitialized Keystore already Initilized
 	ErrKeyStoreAlreadyInitialized = errors.New("Keystore already Initilized.")
 
+	// ErrKeyStoreClosed Keystore closed
+	ErrKeyStoreClosed = errors.New("Keystore closed.")
+
+	// ErrKeyStoreReadOnly Keystore opened read-only
+	ErrKeyStoreReadOnly = errors.New("Keystore opened read-only. Nothing can be stored or removed.")
+
 	// ErrEncrypt Encryption failed
 	ErrEncrypt = errors.New("Encryption failed.")
 
@@ -82,8 +88,41 @@ var (
 	// ErrInvalidTransactionType Invalid transaction type
 	ErrInvalidTransactionType = errors.New("Invalid transaction type")
 
+	// ErrInvalidNodeType Invalid node type
+	ErrInvalidNodeType = errors.New("Invalid node type.")
+
 	// ErrInvalidProtocolVersion Invalid protocol version
 	ErrInvalidProtocolVersion = errors.New("Invalid protocol version")
+
+	// ErrCertRevoked Certificate revoked
+	ErrCertRevoked = errors.New("Certificate revoked.")
+
+	// ErrCertStatusUnavailable Revocation status of a certificate unavailable
+	ErrCertStatusUnavailable = errors.New("Certificate revocation status unavailable.")
+
+	// ErrInvalidCertificateChain Certificate not issued by the ECA or the TCA
+	ErrInvalidCertificateChain = errors.New("Certificate chains neither to the ECA nor to the TCA.")
+
+	// ErrUnknownChaincodeKey Chaincode key not found
+	ErrUnknownChaincodeKey = errors.New("Chaincode key not found. It must be imported from the deployer.")
+
+	// ErrInvalidChaincodeKey Chaincode key different from the deployed one
+	ErrInvalidChaincodeKey = errors.New("Chaincode key is not the one the chaincode was deployed with.")
+
+	// ErrAccessDenied Identity not permitted by the access policy of a transaction
+	ErrAccessDenied = errors.New("Access denied. The access policy of the transaction does not permit the identity.")
+
+	// ErrUnknownTCert TCert not found
+	ErrUnknownTCert = errors.New("TCert not found. Fewer TCerts were used.")
+
+	// ErrForeignTCert TCert not owned by the client
+	ErrForeignTCert = errors.New("TCert not owned by the client. Its signing key cannot be derived.")
+
+	// ErrNoCredential Anonymous credential not found
+	ErrNoCredential = errors.New("No anonymous credential. It must be requested from the issuer first.")
+
+	// ErrUnknownIssuer Anonymous credential issued by an untrusted issuer
+	ErrUnknownIssuer = errors.New("Anonymous credential issued by an untrusted issuer.")
 )
 
 // ErrToString converts and error to a string. If the error is nil, it returns the string "<clean>"
@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+// LockedBuffer is a buffer for secrets, locked in memory where the
+// platform supports it so that it is never swapped to disk
+type LockedBuffer struct {
+	buf    []byte
+	mapped bool
+	locked bool
+}
+
+// NewLockedBuffer returns a LockedBuffer holding a copy of secret. If the
+// memory cannot be locked, the buffer is allocated on the heap.
+func NewLockedBuffer(secret []byte) *LockedBuffer {
+	lb := &LockedBuffer{}
+	if len(secret) > 0 {
+		lb.buf, lb.mapped, lb.locked = allocLocked(len(secret))
+	}
+	if lb.buf == nil {
+		lb.buf = make([]byte, len(secret))
+	}
+	copy(lb.buf, secret)
+
+	return lb
+}
+
+// Bytes returns the secret held by the buffer. It must not be used after Destroy.
+func (lb *LockedBuffer) Bytes() []byte {
+	if lb == nil {
+		return nil
+	}
+
+	return lb.buf
+}
+
+// IsLocked returns true if the buffer is locked in memory
+func (lb *LockedBuffer) IsLocked() bool {
+	return lb != nil && lb.locked
+}
+
+// Destroy overwrites the secret with zeros and releases the buffer
+func (lb *LockedBuffer) Destroy() {
+	if lb == nil || lb.buf == nil {
+		return
+	}
+
+	Zeroize(lb.buf)
+	if lb.mapped {
+		freeLocked(lb.buf, lb.locked)
+	}
+	lb.buf, lb.mapped, lb.locked = nil, false, false
+}
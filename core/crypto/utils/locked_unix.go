@@ -0,0 +1,42 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"syscall"
+)
+
+// allocLocked maps size bytes out of the Go heap, and locks them. When
+// locking fails, typically past RLIMIT_MEMLOCK, the mapping is kept unlocked.
+func allocLocked(size int) (buf []byte, mapped, locked bool) {
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, false, false
+	}
+
+	return buf, true, syscall.Mlock(buf) == nil
+}
+
+func freeLocked(buf []byte, locked bool) {
+	if locked {
+		syscall.Munlock(buf)
+	}
+	syscall.Munmap(buf)
+}
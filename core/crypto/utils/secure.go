@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/subtle"
+	"math/big"
+)
+
+// ConstantTimeEqual returns true if a and b are equal. The time it takes
+// depends on their lengths only, not on their contents.
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Zeroize overwrites buf with zeros
+func Zeroize(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// ZeroizeBigInt overwrites the words of x with zeros, and sets x to zero
+func ZeroizeBigInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+
+	words := x.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	x.SetInt64(0)
+}
+
+// ZeroizeKey overwrites the secret of key, an ecdsa, rsa or ed25519
+// private key or a symmetric key, with zeros. The key is unusable afterwards.
+func ZeroizeKey(key interface{}) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k != nil {
+			ZeroizeBigInt(k.D)
+		}
+	case *rsa.PrivateKey:
+		if k != nil {
+			ZeroizeBigInt(k.D)
+			for _, p := range k.Primes {
+				ZeroizeBigInt(p)
+			}
+			ZeroizeBigInt(k.Precomputed.Dp)
+			ZeroizeBigInt(k.Precomputed.Dq)
+			ZeroizeBigInt(k.Precomputed.Qinv)
+		}
+	case ed25519.PrivateKey:
+		Zeroize(k)
+	case []byte:
+		Zeroize(k)
+	}
+}
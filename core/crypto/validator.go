@@ -52,7 +52,7 @@ func RegisterValidator(name string, pwd []byte, enrollID, enrollPWD string) erro
 		return nil
 	}
 
-	validator := newValidator()
+	validator := newValidator(nil)
 	if err := validator.register(name, pwd, enrollID, enrollPWD); err != nil {
 		if err != utils.ErrAlreadyRegistered && err != utils.ErrAlreadyInitialized {
 			log.Errorf("Failed registering validator [%s] with name [%s] [%s].", enrollID, name, err)
@@ -86,7 +86,7 @@ func InitValidator(name string, pwd []byte) (Peer, error) {
 		return validators[name].validator, nil
 	}
 
-	validator := newValidator()
+	validator := newValidator(nil)
 	if err := validator.init(name, pwd); err != nil {
 		log.Errorf("Failed validator initialization [%s]: [%s]", name, err)
 
@@ -107,31 +107,29 @@ func CloseValidator(peer Peer) error {
 	return closeValidatorInternal(peer, false)
 }
 
-// CloseAllValidators closes all the validators initialized so far
+// CloseAllValidators closes all the validators initialized so far. It returns true if
+// all were closed, and the errors of the ones failing to close otherwise.
 func CloseAllValidators() (bool, []error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
 	log.Info("Closing all validators...")
 
-	errs := make([]error, len(validators))
+	errs := []error{}
 	for _, value := range validators {
 		err := closeValidatorInternal(value.validator, true)
-
-		errs = append(errs, err)
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	log.Info("Closing all validators...done!")
 
-	return len(errs) != 0, errs
+	return len(errs) == 0, errs
 }
 
 // Private Methods
 
-func newValidator() *validatorImpl {
-	return &validatorImpl{&peerImpl{&nodeImpl{}, sync.RWMutex{}, nil, false}, false, nil}
-}
-
 func closeValidatorInternal(peer Peer, force bool) error {
 	if peer == nil {
 		return utils.ErrNilArgument
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/asn1"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// Public Methods
+
+// GrantPayloadAccess returns, if the access policy of tx permits the
+// identity of request, the keys of the payload of tx encrypted to the
+// requester
+func (validator *validatorImpl) GrantPayloadAccess(tx *obc.Transaction, request []byte) ([]byte, error) {
+	if !validator.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+	if tx == nil || len(request) == 0 {
+		return nil, utils.ErrNilArgument
+	}
+	if tx.ConfidentialityLevel != obc.ConfidentialityLevel_CONFIDENTIAL || tx.ConfidentialityProtocolVersion != "1.3" {
+		return nil, errNoAccessPolicy
+	}
+
+	msgToValidators, err := validator.getValidatorMessage1_3(tx)
+	if err != nil {
+		return nil, err
+	}
+	access := msgToValidators.PayloadAccess
+	if len(access.PayloadKey) == 0 {
+		return nil, errNoAccessPolicy
+	}
+
+	requester, err := validator.checkPayloadAccessRequest(tx, request)
+	if err != nil {
+		return nil, err
+	}
+	if !access.Policy.permits(requester.cert) {
+		validator.Errorf("Access to the payload of [%s] denied to [%s].", tx.Uuid, requester.cert.Subject.CommonName)
+		return nil, utils.ErrAccessDenied
+	}
+
+	grant, err := asn1.Marshal(payloadAccessGrant{
+		TxPayloadKey: payloadLayerKey(chaincodeTxKey(msgToValidators.ChaincodeKey, tx.Nonce)),
+		PayloadKey:   payloadLayerKey(access.PayloadKey),
+	})
+	if err != nil {
+		validator.Errorf("Failed marshalling access grant [%s].", err.Error())
+		return nil, err
+	}
+
+	cipher, err := validator.eciesSPI.NewAsymmetricCipherFromSerializedPublicKey(requester.publicKey)
+	if err != nil {
+		validator.Errorf("Failed init encryption engine [%s].", err.Error())
+		return nil, err
+	}
+
+	validator.Debugf("Access to the payload of [%s] granted to [%s].", tx.Uuid, requester.cert.Subject.CommonName)
+
+	return cipher.Process(grant)
+}
+
+// Private Methods
+
+// checkPayloadAccessRequest checks that request is for tx and signed
+// with the key of a valid enrollment certificate
+func (validator *validatorImpl) checkPayloadAccessRequest(tx *obc.Transaction, raw []byte) (*payloadAccessRequester, error) {
+	request := new(payloadAccessRequest)
+	if _, err := asn1.Unmarshal(raw, request); err != nil {
+		validator.Errorf("Failed unmarshalling access request [%s].", err.Error())
+		return nil, err
+	}
+	if !bytes.Equal(request.Nonce, tx.Nonce) {
+		return nil, errInvalidAccessRequest
+	}
+
+	cert, err := primitives.DERToX509Certificate(request.Cert)
+	if err != nil {
+		validator.Errorf("Failed parsing requester certificate [%s].", err.Error())
+		return nil, err
+	}
+	if validator.isCertRevoked(cert) {
+		return nil, utils.ErrCertRevoked
+	}
+	if err := validator.checkOCSP(cert); err != nil {
+		return nil, err
+	}
+	markFabricExtensionsHandled(cert)
+	if _, err := primitives.CheckCertAgainRoot(cert, validator.ecaCertPool); err != nil {
+		if validator.verifyTrustedCert(cert, trustedECA) != nil {
+			validator.Errorf("Failed checking requester certificate against the ECA [%s].", err.Error())
+			return nil, err
+		}
+	}
+
+	signed, err := request.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := validator.verify(cert.PublicKey, signed, request.Signature)
+	if err != nil {
+		validator.Errorf("Failed verifying access request signature [%s].", err.Error())
+		return nil, err
+	}
+	if !ok {
+		return nil, utils.ErrInvalidSignature
+	}
+
+	return &payloadAccessRequester{cert: cert, publicKey: request.PublicKey}, nil
+}
@@ -51,6 +51,8 @@ func (validator *validatorImpl) deepCloneAndDecryptTx(tx *obc.Transaction) (*obc
 		return validator.deepCloneAndDecryptTx1_1(tx)
 	case "1.2":
 		return validator.deepCloneAndDecryptTx1_2(tx)
+	case "1.3":
+		return validator.deepCloneAndDecryptTx1_3(tx)
 	}
 	return nil, utils.ErrInvalidProtocolVersion
 }
@@ -79,36 +81,57 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_1(tx *obc.Transaction) (*
 	//	validator.log.Infof("Encrypted Payload  ", utils.EncodeBase64(tx.EncryptedPayload))
 	//	validator.log.Infof("Encrypted ChaincodeID  ", utils.EncodeBase64(tx.EncryptedChaincodeID))
 
+	if err := validator.decryptTxFields(key, clone); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
+// decryptTxFields decrypts payload, chaincodeID, metadata and attributes
+// keys of tx under keys derived from txKey
+func (validator *validatorImpl) decryptTxFields(txKey []byte, tx *obc.Transaction) error {
 	// Decrypt Payload
-	payloadKey := primitives.HMACAESTruncated(key, []byte{1})
-	payload, err := primitives.CBCPKCS7Decrypt(payloadKey, utils.Clone(clone.Payload))
+	payloadKey := primitives.HMACAESTruncated(txKey, []byte{1})
+	payload, err := primitives.CBCPKCS7Decrypt(payloadKey, utils.Clone(tx.Payload))
 	if err != nil {
 		validator.Errorf("Failed decrypting payload [%s].", err.Error())
-		return nil, err
+		return err
 	}
-	clone.Payload = payload
+	tx.Payload = payload
 
 	// Decrypt ChaincodeID
-	chaincodeIDKey := primitives.HMACAESTruncated(key, []byte{2})
-	chaincodeID, err := primitives.CBCPKCS7Decrypt(chaincodeIDKey, utils.Clone(clone.ChaincodeID))
+	chaincodeIDKey := primitives.HMACAESTruncated(txKey, []byte{2})
+	chaincodeID, err := primitives.CBCPKCS7Decrypt(chaincodeIDKey, utils.Clone(tx.ChaincodeID))
 	if err != nil {
 		validator.Errorf("Failed decrypting chaincode [%s].", err.Error())
-		return nil, err
+		return err
 	}
-	clone.ChaincodeID = chaincodeID
+	tx.ChaincodeID = chaincodeID
 
 	// Decrypt metadata
-	if len(clone.Metadata) != 0 {
-		metadataKey := primitives.HMACAESTruncated(key, []byte{3})
-		metadata, err := primitives.CBCPKCS7Decrypt(metadataKey, utils.Clone(clone.Metadata))
+	if len(tx.Metadata) != 0 {
+		metadataKey := primitives.HMACAESTruncated(txKey, []byte{3})
+		metadata, err := primitives.CBCPKCS7Decrypt(metadataKey, utils.Clone(tx.Metadata))
 		if err != nil {
 			validator.Errorf("Failed decrypting metadata [%s].", err.Error())
-			return nil, err
+			return err
 		}
-		clone.Metadata = metadata
+		tx.Metadata = metadata
 	}
 
-	return clone, nil
+	// Decrypt attributes keys
+	if len(tx.AttributesKeys) != 0 {
+		attributesKeysKey := primitives.HMACAESTruncated(txKey, []byte{4})
+		attributesKeys, err := primitives.CBCPKCS7Decrypt(attributesKeysKey, utils.Clone(tx.AttributesKeys))
+		if err != nil {
+			validator.Errorf("Failed decrypting attributes keys [%s].", err.Error())
+			return err
+		}
+		tx.AttributesKeys = attributesKeys
+	}
+
+	return nil
 }
 
 func (validator *validatorImpl) deepCloneAndDecryptTx1_2(tx *obc.Transaction) (*obc.Transaction, error) {
@@ -189,5 +212,80 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_2(tx *obc.Transaction) (*
 		clone.Metadata = metadata
 	}
 
+	// Decrypt attributes keys
+	if len(clone.AttributesKeys) != 0 {
+		attributesKeys, err := cipher.Process(clone.AttributesKeys)
+		if err != nil {
+			validator.Errorf("Failed decrypting attributes keys [%s].", err.Error())
+			return nil, err
+		}
+		clone.AttributesKeys = attributesKeys
+	}
+
 	return clone, nil
 }
+
+func (validator *validatorImpl) deepCloneAndDecryptTx1_3(tx *obc.Transaction) (*obc.Transaction, error) {
+	if tx.Nonce == nil || len(tx.Nonce) == 0 {
+		return nil, errors.New("Failed decrypting payload. Invalid nonce.")
+	}
+
+	// clone tx
+	clone, err := validator.deepCloneTransaction(tx)
+	if err != nil {
+		validator.Errorf("Failed deep cloning [%s].", err.Error())
+		return nil, err
+	}
+
+	// Unwrap the chaincode key
+	msgToValidators, err := validator.getValidatorMessage1_3(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validator.decryptTxFields(chaincodeTxKey(msgToValidators.ChaincodeKey, clone.Nonce), clone); err != nil {
+		return nil, err
+	}
+
+	// Remove the layer of the access policy, if any
+	if payloadKey := msgToValidators.PayloadAccess.PayloadKey; len(payloadKey) != 0 {
+		payload, err := primitives.CBCPKCS7Decrypt(payloadLayerKey(payloadKey), clone.Payload)
+		if err != nil {
+			validator.Errorf("Failed decrypting restricted payload [%s].", err.Error())
+			return nil, err
+		}
+		clone.Payload = payload
+	}
+
+	return clone, nil
+}
+
+// getValidatorMessage1_3 unwraps, with the chain key, the chaincode key
+// and the query key carried by tx
+func (validator *validatorImpl) getValidatorMessage1_3(tx *obc.Transaction) (*chainCodeValidatorMessage1_3, error) {
+	cipher, err := validator.eciesSPI.NewAsymmetricCipherFromPrivateKey(validator.chainPrivateKey)
+	if err != nil {
+		validator.Errorf("Failed init decryption engine [%s].", err.Error())
+		return nil, err
+	}
+
+	msgToValidatorsRaw, err := cipher.Process(tx.ToValidators)
+	if err != nil {
+		validator.Errorf("Failed decrypting message to validators [% x]: [%s].", tx.ToValidators, err.Error())
+		return nil, err
+	}
+
+	msgToValidators := new(chainCodeValidatorMessage1_3)
+	_, err = asn1.Unmarshal(msgToValidatorsRaw, msgToValidators)
+	if err != nil {
+		validator.Errorf("Failed unmarshalling message to validators [%s].", err.Error())
+		return nil, err
+	}
+
+	if len(msgToValidators.ChaincodeKey) != primitives.AESKeyLength {
+		validator.Errorf("Invalid chaincode key length [%d].", len(msgToValidators.ChaincodeKey))
+		return nil, utils.ErrInvalidChaincodeKey
+	}
+
+	return msgToValidators, nil
+}
@@ -48,6 +48,23 @@ func (validator *validatorImpl) TransactionPreValidation(tx *obc.Transaction) (*
 	return validator.peerImpl.TransactionPreValidation(tx)
 }
 
+// TransactionsPreValidation runs TransactionPreValidation on the
+// transactions of txs as a batch
+func (validator *validatorImpl) TransactionsPreValidation(txs []*obc.Transaction) ([]*obc.Transaction, []error) {
+	return txs, validator.VerifyTransactionsBatch(txs)
+}
+
+// VerifyTransactionsBatch verifies the certificates and signatures of txs,
+// the transactions of a block, as a batch. The i-th error is the outcome
+// for txs[i].
+func (validator *validatorImpl) VerifyTransactionsBatch(txs []*obc.Transaction) []error {
+	if !validator.isInitialized {
+		return batchErrors(len(txs), utils.ErrNotInitialized)
+	}
+
+	return validator.peerImpl.VerifyTransactionsBatch(txs)
+}
+
 // TransactionPreValidation verifies that the transaction is
 // well formed with the respect to the security layer
 // prescriptions (i.e. signature verification). If this is the case,
@@ -102,24 +119,28 @@ func (validator *validatorImpl) Verify(vkID, signature, message []byte) error {
 		return fmt.Errorf("Invalid message. It is empty.")
 	}
 
-	cert, err := validator.getEnrollmentCert(vkID)
+	ctx, cancel := validator.newCertFetchContext()
+	defer cancel()
+
+	cert, err := validator.getEnrollmentCert(ctx, vkID)
 	if err != nil {
 		validator.Errorf("Failed getting enrollment cert for [% x]: [%s]", vkID, err)
+		validator.ks.audit(AuditVerifyFailure, utils.EncodeBase64(vkID), err.Error())
 
 		return err
 	}
 
-	vk := cert.PublicKey.(*ecdsa.PublicKey)
-
-	ok, err := validator.verify(vk, message, signature)
+	ok, err := validator.verify(cert.PublicKey, message, signature)
 	if err != nil {
 		validator.Errorf("Failed verifying signature for [% x]: [%s]", vkID, err)
+		validator.ks.audit(AuditVerifyFailure, utils.EncodeBase64(vkID), err.Error())
 
 		return err
 	}
 
 	if !ok {
 		validator.Errorf("Failed invalid signature for [% x]", vkID)
+		validator.ks.audit(AuditVerifyFailure, utils.EncodeBase64(vkID), utils.ErrInvalidSignature.Error())
 
 		return utils.ErrInvalidSignature
 	}
@@ -20,12 +20,11 @@ import (
 	"errors"
 	"reflect"
 
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/asn1"
 	"encoding/binary"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/state"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	obc "github.com/hyperledger/fabric/protos"
 )
@@ -36,6 +35,8 @@ func (validator *validatorImpl) GetStateEncryptor(deployTx, executeTx *obc.Trans
 		return validator.getStateEncryptor1_1(deployTx, executeTx)
 	case "1.2":
 		return validator.getStateEncryptor1_2(deployTx, executeTx)
+	case "1.3":
+		return validator.getStateEncryptor1_3(deployTx, executeTx)
 	}
 
 	return nil, utils.ErrInvalidConfidentialityLevel
@@ -205,6 +206,82 @@ func (validator *validatorImpl) getStateKeyFromTransaction(tx *obc.Transaction)
 	return msgToValidators.StateKey, nil
 }
 
+func (validator *validatorImpl) getStateEncryptor1_3(deployTx, executeTx *obc.Transaction) (StateEncryptor, error) {
+	// Check nonce
+	if deployTx.Nonce == nil || len(deployTx.Nonce) == 0 {
+		return nil, errors.New("Invalid deploy nonce.")
+	}
+	if executeTx.Nonce == nil || len(executeTx.Nonce) == 0 {
+		return nil, errors.New("Invalid invoke nonce.")
+	}
+	// Check ChaincodeID
+	if deployTx.ChaincodeID == nil {
+		return nil, errors.New("Invalid deploy chaincodeID.")
+	}
+	if executeTx.ChaincodeID == nil {
+		return nil, errors.New("Invalid execute chaincodeID.")
+	}
+	// Check that deployTx and executeTx refers to the same chaincode
+	if !reflect.DeepEqual(deployTx.ChaincodeID, executeTx.ChaincodeID) {
+		return nil, utils.ErrDifferentChaincodeID
+	}
+	// Check the confidentiality protocol version
+	if deployTx.ConfidentialityProtocolVersion != executeTx.ConfidentialityProtocolVersion {
+		return nil, utils.ErrDifferrentConfidentialityProtocolVersion
+	}
+
+	validator.Debugf("Parsing transaction. Type [%s]. Confidentiality Protocol Version [%s]", executeTx.Type.String(), executeTx.ConfidentialityProtocolVersion)
+
+	// Check that executeTx carries the chaincode key deployTx was deployed with
+	deployMsg, err := validator.getValidatorMessage1_3(deployTx)
+	if err != nil {
+		return nil, err
+	}
+	executeMsg, err := validator.getValidatorMessage1_3(executeTx)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.ConstantTimeEqual(deployMsg.ChaincodeKey, executeMsg.ChaincodeKey) {
+		validator.Error("Execute transaction carries another chaincode key than the deployed one.")
+
+		return nil, utils.ErrInvalidChaincodeKey
+	}
+
+	// Compute deployTxKey key from the deploy transaction. This is used to decrypt the actual state
+	// of the chaincode
+	deployTxKey := chaincodeStateKey(deployMsg.ChaincodeKey, deployTx.Nonce)
+
+	if executeTx.Type == obc.Transaction_CHAINCODE_QUERY {
+		validator.Debug("Parsing Query transaction...")
+
+		// Init the state encryptor with the key used to encrypt the result of the query
+		se := queryStateEncryptor{}
+		err = se.init(validator.nodeImpl, executeMsg.QueryKey, deployTxKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return &se, nil
+	}
+
+	// Mask executeTx.Nonce
+	executeTxNonce := primitives.HMACTruncated(deployTxKey, primitives.Hash(executeTx.Nonce), primitives.NonceSize)
+
+	// Compute stateKey to encrypt the states and nonceStateKey to generates IVs. This
+	// allows validators to reach consesus
+	stateKey := primitives.HMACTruncated(deployTxKey, append([]byte{3}, executeTxNonce...), primitives.AESKeyLength)
+	nonceStateKey := primitives.HMAC(deployTxKey, append([]byte{4}, executeTxNonce...))
+
+	// Init the state encryptor
+	se := stateEncryptorImpl{}
+	err = se.init(validator.nodeImpl, stateKey, nonceStateKey, deployTxKey, executeTxNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &se, nil
+}
+
 type stateEncryptorImpl struct {
 	node *nodeImpl
 
@@ -214,9 +291,6 @@ type stateEncryptorImpl struct {
 	stateKey      []byte
 	nonceStateKey []byte
 
-	gcmEnc    cipher.AEAD
-	nonceSize int
-
 	counter uint64
 }
 
@@ -229,20 +303,6 @@ func (se *stateEncryptorImpl) init(node *nodeImpl, stateKey, nonceStateKey, depl
 	se.deployTxKey = deployTxKey
 	se.invokeTxNonce = invokeTxNonce
 
-	// Init aes
-	c, err := aes.NewCipher(se.stateKey)
-	if err != nil {
-		return err
-	}
-
-	// Init gcm for encryption
-	se.gcmEnc, err = cipher.NewGCM(c)
-	if err != nil {
-		return err
-	}
-
-	// Init nonce size
-	se.nonceSize = se.gcmEnc.NonceSize()
 	return nil
 }
 
@@ -251,108 +311,61 @@ func (se *stateEncryptorImpl) Encrypt(msg []byte) ([]byte, error) {
 	binary.BigEndian.PutUint64(b, se.counter)
 
 	se.node.Debugf("Encrypting with counter [% x].", b)
-	//	se.log.Infof("Encrypting with txNonce  ", utils.EncodeBase64(se.txNonce))
 
-	nonce := primitives.HMACTruncated(se.nonceStateKey, b, se.nonceSize)
+	nonce := primitives.HMACTruncated(se.nonceStateKey, b, state.NonceSize)
 
 	se.counter++
 
-	// Seal will append the output to the first argument; the usage
-	// here appends the ciphertext to the nonce. The final parameter
-	// is any additional data to be authenticated.
-	out := se.gcmEnc.Seal(nonce, nonce, msg, se.invokeTxNonce)
-
-	return append(se.invokeTxNonce, out...), nil
-}
-
-func (se *stateEncryptorImpl) Decrypt(raw []byte) ([]byte, error) {
-	if len(raw) == 0 {
-		// A nil ciphertext decrypts to nil
-		return nil, nil
-	}
-
-	if len(raw) <= primitives.NonceSize {
-		return nil, utils.ErrDecrypt
-	}
-
-	// raw consists of (txNonce, ct)
-	txNonce := raw[:primitives.NonceSize]
-	//	se.log.Infof("Decrypting with txNonce  ", utils.EncodeBase64(txNonce))
-	ct := raw[primitives.NonceSize:]
-
-	nonce := make([]byte, se.nonceSize)
-	copy(nonce, ct)
-
-	key := primitives.HMACTruncated(se.deployTxKey, append([]byte{3}, txNonce...), primitives.AESKeyLength)
-	//	se.log.Infof("Decrypting with key  ", utils.EncodeBase64(key))
-	c, err := aes.NewCipher(key)
+	// The invoke nonce is authenticated too
+	out, err := state.Seal(se.stateKey, nonce, msg, se.invokeTxNonce)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(c)
-	if err != nil {
-		return nil, err
-	}
-
-	se.nonceSize = se.gcmEnc.NonceSize()
+	return append(append([]byte{}, se.invokeTxNonce...), out...), nil
+}
 
-	out, err := gcm.Open(nil, nonce, ct[se.nonceSize:], txNonce)
-	if err != nil {
-		return nil, utils.ErrDecrypt
-	}
-	return out, nil
+func (se *stateEncryptorImpl) Decrypt(raw []byte) ([]byte, error) {
+	return decryptState(se.deployTxKey, raw)
 }
 
 type queryStateEncryptor struct {
 	node *nodeImpl
 
 	deployTxKey []byte
-
-	gcmEnc    cipher.AEAD
-	nonceSize int
+	queryKey    []byte
 }
 
 func (se *queryStateEncryptor) init(node *nodeImpl, queryKey, deployTxKey []byte) error {
+	if len(queryKey) != state.KeySize {
+		return utils.ErrInvalidKey
+	}
+
 	// Initi fields
 	se.node = node
 	se.deployTxKey = deployTxKey
+	se.queryKey = queryKey
 
-	//	se.log.Infof("QUERY Encrypting with key  ", utils.EncodeBase64(queryKey))
-
-	// Init aes
-	c, err := aes.NewCipher(queryKey)
-	if err != nil {
-		return err
-	}
-
-	// Init gcm for encryption
-	se.gcmEnc, err = cipher.NewGCM(c)
-	if err != nil {
-		return err
-	}
-
-	// Init nonce size
-	se.nonceSize = se.gcmEnc.NonceSize()
 	return nil
 }
 
 func (se *queryStateEncryptor) Encrypt(msg []byte) ([]byte, error) {
-	nonce, err := primitives.GetRandomBytes(se.nonceSize)
+	nonce, err := primitives.GetRandomBytes(state.NonceSize)
 	if err != nil {
 		se.node.Errorf("Failed getting randomness [%s].", err.Error())
 		return nil, err
 	}
 
-	// Seal will append the output to the first argument; the usage
-	// here appends the ciphertext to the nonce. The final parameter
-	// is any additional data to be authenticated.
-	out := se.gcmEnc.Seal(nonce, nonce, msg, nil)
-
-	return out, nil
+	return state.Seal(se.queryKey, nonce, msg, nil)
 }
 
 func (se *queryStateEncryptor) Decrypt(raw []byte) ([]byte, error) {
+	return decryptState(se.deployTxKey, raw)
+}
+
+// decryptState decrypts raw, a state encrypted by a stateEncryptorImpl
+// initialized from deployTxKey
+func decryptState(deployTxKey, raw []byte) ([]byte, error) {
 	if len(raw) == 0 {
 		// A nil ciphertext decrypts to nil
 		return nil, nil
@@ -364,27 +377,11 @@ func (se *queryStateEncryptor) Decrypt(raw []byte) ([]byte, error) {
 
 	// raw consists of (txNonce, ct)
 	txNonce := raw[:primitives.NonceSize]
-	//	se.log.Infof("Decrypting with txNonce  ", utils.EncodeBase64(txNonce))
 	ct := raw[primitives.NonceSize:]
 
-	nonce := make([]byte, se.nonceSize)
-	copy(nonce, ct)
-
-	key := primitives.HMACTruncated(se.deployTxKey, append([]byte{3}, txNonce...), primitives.AESKeyLength)
-	//	se.log.Infof("Decrypting with key  ", utils.EncodeBase64(key))
-	c, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(c)
-	if err != nil {
-		return nil, err
-	}
-
-	se.nonceSize = se.gcmEnc.NonceSize()
+	key := primitives.HMACTruncated(deployTxKey, append([]byte{3}, txNonce...), primitives.AESKeyLength)
 
-	out, err := gcm.Open(nil, nonce, ct[se.nonceSize:], txNonce)
+	out, err := state.Open(key, ct, txNonce)
 	if err != nil {
 		return nil, utils.ErrDecrypt
 	}
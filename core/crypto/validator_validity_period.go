@@ -42,7 +42,8 @@ func validityPeriodVerificationEnabled() bool {
 }
 
 func (validator *validatorImpl) verifyValidityPeriod(tx *obc.Transaction) (*obc.Transaction, error) {
-	if tx.Cert != nil && tx.Signature != nil {
+	// Anonymous credentials carry no validity period
+	if tx.Cert != nil && tx.Signature != nil && !isIdemixCert(tx.Cert) {
 
 		// Unmarshal cert
 		cert, err := primitives.DERToX509Certificate(tx.Cert)
@@ -51,7 +52,7 @@ func (validator *validatorImpl) verifyValidityPeriod(tx *obc.Transaction) (*obc.
 			return tx, err
 		}
 
-		cid := viper.GetString("pki.validity-period.chaincodeHash")
+		cid := validator.conf.v.GetString("pki.validity-period.chaincodeHash")
 
 		ledger, err := ledger.GetLedger()
 		if err != nil {
@@ -25,6 +25,7 @@ import (
 	"github.com/looplab/fsm"
 	"github.com/spf13/viper"
 
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -43,6 +44,7 @@ type Handler struct {
 	snapshotRequestHandler        *syncStateSnapshotRequestHandler
 	syncStateDeltasRequestHandler *syncStateDeltasHandler
 	syncBlocksRequestHandler      *syncBlocksRequestHandler
+	ecertRequestHandler           *ecertRequestHandler
 }
 
 // NewPeerHandler returns a new Peer handler
@@ -59,6 +61,7 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 	d.snapshotRequestHandler = newSyncStateSnapshotRequestHandler()
 	d.syncStateDeltasRequestHandler = newSyncStateDeltasHandler()
 	d.syncBlocksRequestHandler = newSyncBlocksRequestHandler()
+	d.ecertRequestHandler = newECertRequestHandler()
 	d.FSM = fsm.NewFSM(
 		"created",
 		fsm.Events{
@@ -72,6 +75,8 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 			{Name: pb.Message_SYNC_STATE_SNAPSHOT.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_STATE_GET_DELTAS.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_STATE_DELTAS.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_ECERT_GET.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_ECERT_CHAIN.String(), Src: []string{"established"}, Dst: "established"},
 		},
 		fsm.Callbacks{
 			"enter_state":                                           func(e *fsm.Event) { d.enterState(e) },
@@ -85,6 +90,8 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 			"before_" + pb.Message_SYNC_STATE_SNAPSHOT.String():     func(e *fsm.Event) { d.beforeSyncStateSnapshot(e) },
 			"before_" + pb.Message_SYNC_STATE_GET_DELTAS.String():   func(e *fsm.Event) { d.beforeSyncStateGetDeltas(e) },
 			"before_" + pb.Message_SYNC_STATE_DELTAS.String():       func(e *fsm.Event) { d.beforeSyncStateDeltas(e) },
+			"before_" + pb.Message_ECERT_GET.String():               func(e *fsm.Event) { d.beforeECertGet(e) },
+			"before_" + pb.Message_ECERT_CHAIN.String():             func(e *fsm.Event) { d.beforeECertChain(e) },
 		},
 	)
 
@@ -162,6 +169,11 @@ func (d *Handler) beforeHello(e *fsm.Event) {
 			return
 		}
 		peerLogger.Debugf("Verified signature for %s", e.Event)
+
+		if err := checkSecurityParameters(helloMessage); err != nil {
+			e.Cancel(err)
+			return
+		}
 	}
 
 	if d.initiatedStream == false {
@@ -199,6 +211,22 @@ func (d *Handler) beforeHello(e *fsm.Event) {
 	}
 }
 
+// checkSecurityParameters fails if the peer which sent helloMessage works
+// under another hash family or security level than this peer. Peers not
+// advertising them are accepted.
+func checkSecurityParameters(helloMessage *pb.HelloMessage) error {
+	if helloMessage.HashAlgorithm == "" {
+		return nil
+	}
+
+	if helloMessage.HashAlgorithm != primitives.GetHashAlgorithm() || int(helloMessage.SecurityLevel) != primitives.GetSecurityLevel() {
+		return fmt.Errorf("Security parameters mismatch: peer at [%s-%d], local [%s-%d]",
+			helloMessage.HashAlgorithm, helloMessage.SecurityLevel, primitives.GetHashAlgorithm(), primitives.GetSecurityLevel())
+	}
+
+	return nil
+}
+
 func (d *Handler) beforeGetPeers(e *fsm.Event) {
 	peersMessage, err := d.Coordinator.GetPeers()
 	if err != nil {
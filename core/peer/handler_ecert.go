@@ -0,0 +1,139 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+//-----------------------------------------------------------------------------
+//
+// Enrollment Certificate Handler
+//
+//-----------------------------------------------------------------------------
+
+type ecertRequestHandler struct {
+	syncHandler
+	channel chan *pb.EnrollmentCertificateChain
+}
+
+func (erh *ecertRequestHandler) reset() {
+	if erh.channel != nil {
+		close(erh.channel)
+	}
+	erh.channel = make(chan *pb.EnrollmentCertificateChain, 1)
+	erh.correlationID++
+}
+
+func (erh *ecertRequestHandler) createRequest() *pb.EnrollmentCertificateRequest {
+	return &pb.EnrollmentCertificateRequest{CorrelationId: erh.correlationID}
+}
+
+func newECertRequestHandler() *ecertRequestHandler {
+	erh := &ecertRequestHandler{}
+	erh.reset()
+	return erh
+}
+
+// RequestEnrollmentCertificate requests the enrollment certificate chain of the other PeerEndpoint, will provide it through the returned channel.
+// This will also close the channels created from prior calls to RequestEnrollmentCertificate()
+func (d *Handler) RequestEnrollmentCertificate() (<-chan *pb.EnrollmentCertificateChain, error) {
+	d.ecertRequestHandler.Lock()
+	defer d.ecertRequestHandler.Unlock()
+	// Reset the handler
+	d.ecertRequestHandler.reset()
+
+	ecertRequest := d.ecertRequestHandler.createRequest()
+	ecertRequestBytes, err := proto.Marshal(ecertRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling EnrollmentCertificateRequest: %s", err)
+	}
+	peerLogger.Debugf("Sending %s with correlationId = %d", pb.Message_ECERT_GET, ecertRequest.CorrelationId)
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_ECERT_GET, Payload: ecertRequestBytes}); err != nil {
+		return nil, fmt.Errorf("Error sending %s: %s", pb.Message_ECERT_GET, err)
+	}
+
+	return d.ecertRequestHandler.channel, nil
+}
+
+// beforeECertGet sends back the enrollment certificate chain of this Peer.
+func (d *Handler) beforeECertGet(e *fsm.Event) {
+	peerLogger.Debugf("Received message: %s", e.Event)
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	ecertRequest := &pb.EnrollmentCertificateRequest{}
+	if err := proto.Unmarshal(msg.Payload, ecertRequest); err != nil {
+		e.Cancel(fmt.Errorf("Error unmarshalling EnrollmentCertificateRequest in beforeECertGet: %s", err))
+		return
+	}
+
+	// Without security, an empty chain tells the requestor to ask the ECA
+	ecertChain := &pb.EnrollmentCertificateChain{Request: ecertRequest}
+	if SecurityEnabled() {
+		certificates, err := d.Coordinator.GetSecHelper().GetEnrollmentCertificateChain()
+		if err != nil {
+			peerLogger.Errorf("Error getting enrollment certificate chain: %s", err)
+		} else {
+			ecertChain.Certificates = certificates
+		}
+	}
+
+	ecertChainBytes, err := proto.Marshal(ecertChain)
+	if err != nil {
+		e.Cancel(fmt.Errorf("Error marshalling EnrollmentCertificateChain: %s", err))
+		return
+	}
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_ECERT_CHAIN, Payload: ecertChainBytes}); err != nil {
+		e.Cancel(err)
+	}
+}
+
+// beforeECertChain will write the enrollment certificate chain to the respective channel.
+func (d *Handler) beforeECertChain(e *fsm.Event) {
+	peerLogger.Debugf("Received message: %s", e.Event)
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	ecertChain := &pb.EnrollmentCertificateChain{}
+	if err := proto.Unmarshal(msg.Payload, ecertChain); err != nil {
+		e.Cancel(fmt.Errorf("Error unmarshalling EnrollmentCertificateChain in beforeECertChain: %s", err))
+		return
+	}
+
+	d.ecertRequestHandler.Lock()
+	defer d.ecertRequestHandler.Unlock()
+	// Make sure the correlationID matches
+	if ecertChain.Request == nil || !d.ecertRequestHandler.shouldHandle(ecertChain.Request.CorrelationId) {
+		peerLogger.Warningf("Ignoring EnrollmentCertificateChain message, not matching the current correlationId = %d", d.ecertRequestHandler.correlationID)
+		return
+	}
+	select {
+	case d.ecertRequestHandler.channel <- ecertChain:
+	default:
+		peerLogger.Warningf("Ignoring EnrollmentCertificateChain message with correlationId = %d, already answered", ecertChain.Request.CorrelationId)
+	}
+}
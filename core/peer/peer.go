@@ -17,6 +17,7 @@ limitations under the License.
 package peer
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -35,6 +36,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/comm"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/discovery"
 	"github.com/hyperledger/fabric/core/ledger"
@@ -61,6 +63,11 @@ type StateRetriever interface {
 	RequestStateDeltas(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error)
 }
 
+// CertificateRetriever interface for retrieving the enrollment certificate chain of the remote peer
+type CertificateRetriever interface {
+	RequestEnrollmentCertificate() (<-chan *pb.EnrollmentCertificateChain, error)
+}
+
 // RemoteLedger interface for retrieving remote ledger data.
 type RemoteLedger interface {
 	BlocksRetriever
@@ -103,6 +110,7 @@ type StateAccessor interface {
 // MessageHandler standard interface for handling Openchain messages.
 type MessageHandler interface {
 	RemoteLedger
+	CertificateRetriever
 	HandleMessage(msg *pb.Message) error
 	SendMessage(msg *pb.Message) error
 	To() (pb.PeerEndpoint, error)
@@ -232,6 +240,7 @@ func NewPeerWithHandler(secHelperFunc func() crypto.Peer, handlerFact HandlerFac
 		if peer.secHelper == nil {
 			return nil, fmt.Errorf("Security helper not provided")
 		}
+		peer.secHelper.SetEnrollmentCertSource(peer.requestEnrollmentCertificate)
 	}
 
 	ledgerPtr, err := ledger.GetLedger()
@@ -259,6 +268,7 @@ func NewPeerWithEngine(secHelperFunc func() crypto.Peer, engFactory EngineFactor
 		if peer.secHelper == nil {
 			return nil, fmt.Errorf("Security helper not provided")
 		}
+		peer.secHelper.SetEnrollmentCertSource(peer.requestEnrollmentCertificate)
 	}
 
 	// Initialize the ledger before the engine, as consensus may want to begin interrogating the ledger immediately
@@ -660,7 +670,12 @@ func (p *PeerImpl) newHelloMessage() (*pb.HelloMessage, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error creating hello message, error getting block chain info: %s", err)
 	}
-	return &pb.HelloMessage{PeerEndpoint: endpoint, BlockchainInfo: blockChainInfo}, nil
+	helloMessage := &pb.HelloMessage{PeerEndpoint: endpoint, BlockchainInfo: blockChainInfo}
+	if SecurityEnabled() {
+		helloMessage.HashAlgorithm = primitives.GetHashAlgorithm()
+		helloMessage.SecurityLevel = uint32(primitives.GetSecurityLevel())
+	}
+	return helloMessage, nil
 }
 
 // GetBlockByNumber return a block by block number
@@ -775,6 +790,34 @@ func (p *PeerImpl) GetSecHelper() crypto.Peer {
 	return p.secHelper
 }
 
+// requestEnrollmentCertificate requests the enrollment certificate chain of the connected peer whose PKI id is pkiID
+func (p *PeerImpl) requestEnrollmentCertificate(ctx context.Context, pkiID []byte) ([][]byte, error) {
+	var msgHandler MessageHandler
+	for _, h := range p.cloneHandlerMap(pb.PeerEndpoint_UNDEFINED) {
+		if toPeerEndpoint, err := h.To(); err == nil && bytes.Equal(toPeerEndpoint.PkiID, pkiID) {
+			msgHandler = h
+			break
+		}
+	}
+	if msgHandler == nil {
+		return nil, fmt.Errorf("No connected peer with PKI id %x", pkiID)
+	}
+
+	ecertChannel, err := msgHandler.RequestEnrollmentCertificate()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case ecertChain, ok := <-ecertChannel:
+		if !ok {
+			return nil, fmt.Errorf("Enrollment certificate request superseded")
+		}
+		return ecertChain.Certificates, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // signMessage modifies the passed in Message by setting the Signature based upon the Payload.
 func (p *PeerImpl) signMessageMutating(msg *pb.Message) error {
 	if SecurityEnabled() {
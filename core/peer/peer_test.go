@@ -26,6 +26,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/core/config"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/protos"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -55,6 +56,25 @@ func TestMissingMessageHandlerUnicast(t *testing.T) {
 	}
 }
 
+func TestCheckSecurityParameters(t *testing.T) {
+	if err := primitives.SetSecurityLevel("SHA3", 256); err != nil {
+		t.Fatalf("Failed setting security level: %s", err)
+	}
+
+	if err := checkSecurityParameters(&pb.HelloMessage{HashAlgorithm: "SHA3", SecurityLevel: 256}); err != nil {
+		t.Errorf("Expected matching security parameters to be accepted, got: %s", err)
+	}
+	if err := checkSecurityParameters(&pb.HelloMessage{}); err != nil {
+		t.Errorf("Expected a peer not advertising security parameters to be accepted, got: %s", err)
+	}
+	if err := checkSecurityParameters(&pb.HelloMessage{HashAlgorithm: "SHA2", SecurityLevel: 256}); err == nil {
+		t.Error("Expected a different hash family to be rejected, but there was no error")
+	}
+	if err := checkSecurityParameters(&pb.HelloMessage{HashAlgorithm: "SHA3", SecurityLevel: 384}); err == nil {
+		t.Error("Expected a different security level to be rejected, but there was no error")
+	}
+}
+
 func performChat(t testing.TB, conn *grpc.ClientConn) error {
 	serverClient := pb.NewPeerClient(conn)
 	stream, err := serverClient.Chat(context.Background())
@@ -0,0 +1,652 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"google/protobuf"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Flags of the admin commands.
+var (
+	adminAddress   string
+	adminID        string
+	adminHome      string
+	adminSecret    string
+	adminRole      string
+	adminCertType  string
+	adminUnexpired bool
+	adminLimit     uint32
+	adminReason    string
+	adminCertFile  string
+	adminParent    string
+	adminValidTo   string
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Administers a running CA server.",
+	Long: `Administers a running CA server through its admin services, on behalf of
+an identity enrolled with "admin enroll", whose keys are kept in --home.`,
+}
+
+var adminEnrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Enrolls the identity administering the CA.",
+	Long:  `Enrolls --id with its one-time password and keeps its enrollment key and certificate in --home.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminEnroll()
+	},
+}
+
+var adminUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Lists the registered users.",
+	Long:  `Lists the registered users, of --role if set. The identity must be an auditor.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminUsers()
+	},
+}
+
+var adminCertsCmd = &cobra.Command{
+	Use:   "certs [identity]",
+	Short: "Lists the certificates issued by the CAs.",
+	Long:  `Lists the certificates issued by the ECA, TCA and TLSCA, to the identity if given. The identity must be an auditor.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminCerts(args)
+	},
+}
+
+var adminRevokeCmd = &cobra.Command{
+	Use:   "revoke <identity>",
+	Short: "Revokes an identity or one of its enrollment certificates.",
+	Long:  `Revokes all the certificates of the identity, which can no longer enroll, or only the enrollment certificate in --cert. The identity must be an auditor.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminRevoke(args)
+	},
+}
+
+var adminAffiliationCmd = &cobra.Command{
+	Use:   "affiliation",
+	Short: "Manages the affiliation groups.",
+}
+
+var adminAffiliationListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the affiliation groups.",
+	Long:  `Lists the affiliation groups within the subtree of the identity, which must be a registrar.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminAffiliationList()
+	},
+}
+
+var adminAffiliationAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Adds an affiliation group.",
+	Long:  `Adds an affiliation group under --parent, within the subtree of the identity, which must be a registrar.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminAffiliationAdd(args)
+	},
+}
+
+var adminSecretCmd = &cobra.Command{
+	Use:   "secret <identity>",
+	Short: "Rotates the one-time password of an identity.",
+	Long: `Rotates the one-time password of an identity, which must enroll anew with it; its current
+enrollment certificates are revoked. The identity must be a registrar allowed to register it,
+or the identity itself.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminRotateSecret(args)
+	},
+}
+
+var adminAttributesCmd = &cobra.Command{
+	Use:   "attributes <identity> [name=value...]",
+	Short: "Refreshes or changes the attributes of an identity.",
+	Long: `Has the ACA fetch anew the attributes of an identity from its attribute source, e.g. after
+they were updated there, rather than serve them from its cache. The identity must be a registrar
+allowed to register it, or the identity itself.
+
+Given name=value pairs, sets instead these attributes of the identity, valid until --valid-to if
+given, overriding its attribute source; name= removes an attribute. The TCerts issued to the
+identity are then revoked once tca.tcert.refresh-window is over. The identity must be a registrar
+allowed to register it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return adminUpdateAttributes(args)
+		}
+		return adminRefreshAttributes(args)
+	},
+}
+
+// addAdminCommands adds the admin command set to cmd. The configuration must
+// have been read, as it provides the default address of the CA.
+func addAdminCommands(cmd *cobra.Command) {
+	flags := adminCmd.PersistentFlags()
+	flags.StringVarP(&adminAddress, "address", "a", "localhost"+viper.GetString("server.port"), "Address of the CA server")
+	flags.StringVarP(&adminID, "id", "u", "admin", "Identity administering the CA")
+	flags.StringVarP(&adminHome, "home", "", filepath.Join(os.Getenv("HOME"), ".membersrvc"), "Directory of the enrollment keys and certificates of the identities administering the CA")
+
+	adminEnrollCmd.Flags().StringVarP(&adminSecret, "secret", "s", "", "One-time password of the identity")
+	adminUsersCmd.Flags().StringVarP(&adminRole, "role", "r", "all", "Role of the users to list: client, peer, validator, auditor or all")
+	adminCertsCmd.Flags().StringVarP(&adminCertType, "type", "t", "", "If set, list only the certificates of this type: ecert, tcert or tls")
+	adminCertsCmd.Flags().BoolVarP(&adminUnexpired, "unexpired", "", false, "If true, list only the certificates not expired yet")
+	adminCertsCmd.Flags().Uint32VarP(&adminLimit, "limit", "n", 0, "If set, list at most this number of certificates of each CA, the most recent ones")
+	adminRevokeCmd.Flags().StringVarP(&adminReason, "reason", "r", "unspecified", "Reason of the revocation, e.g. key_compromise or privilege_withdrawn")
+	adminRevokeCmd.Flags().StringVarP(&adminCertFile, "cert", "c", "", "If set, revoke only the PEM encoded enrollment certificate in this file")
+	adminAffiliationAddCmd.Flags().StringVarP(&adminParent, "parent", "p", "", "Parent of the affiliation group, empty for a top-level one")
+	adminAttributesCmd.Flags().StringVarP(&adminValidTo, "valid-to", "", "", "If set, the attributes set expire at this RFC 3339 time")
+
+	adminAffiliationCmd.AddCommand(adminAffiliationListCmd)
+	adminAffiliationCmd.AddCommand(adminAffiliationAddCmd)
+
+	adminCmd.AddCommand(adminEnrollCmd)
+	adminCmd.AddCommand(adminUsersCmd)
+	adminCmd.AddCommand(adminCertsCmd)
+	adminCmd.AddCommand(adminRevokeCmd)
+	adminCmd.AddCommand(adminAffiliationCmd)
+	adminCmd.AddCommand(adminSecretCmd)
+	adminCmd.AddCommand(adminAttributesCmd)
+
+	cmd.AddCommand(adminCmd)
+}
+
+// dialCA connects to the CA server, over TLS if the server uses it.
+func dialCA() (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTimeout(3 * time.Second)}
+	if certFile := viper.GetString("server.tls.cert.file"); certFile != "" {
+		host, _, err := net.SplitHostPort(adminAddress)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := credentials.NewClientTLSFromFile(certFile, host)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	return grpc.Dial(adminAddress, opts...)
+}
+
+func adminKeyPath() string {
+	return filepath.Join(adminHome, adminID+".key")
+}
+
+func adminCertPath() string {
+	return filepath.Join(adminHome, adminID+".pem")
+}
+
+// readAdminKey reads the enrollment signing key of the identity administering the CA.
+func readAdminKey() (*ecdsa.PrivateKey, error) {
+	raw, err := ioutil.ReadFile(adminKeyPath())
+	if err != nil {
+		return nil, fmt.Errorf("%s is not enrolled, see \"membersrvc admin enroll\": %s", adminID, err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("Invalid enrollment key in " + adminKeyPath())
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// signAdminRequest signs msg, whose signature is unset, with the enrollment key of the
+// identity administering the CA.
+func signAdminRequest(priv *ecdsa.PrivateKey, msg proto.Message) (*pb.Signature, error) {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, priv, primitives.Hash(raw))
+	if err != nil {
+		return nil, err
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+
+	return &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}, nil
+}
+
+// requestTime returns the timestamp of a request made now, which the ECA serves once.
+func requestTime() *google_protobuf.Timestamp {
+	now := time.Now()
+	return &google_protobuf.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())}
+}
+
+func adminEnroll() error {
+	if adminSecret == "" {
+		return errors.New("The one-time password of the identity is required, see --secret.")
+	}
+
+	signPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		return err
+	}
+	signPub, err := x509.MarshalPKIXPublicKey(&signPriv.PublicKey)
+	if err != nil {
+		return err
+	}
+	encPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		return err
+	}
+	encPub, err := x509.MarshalPKIXPublicKey(&encPriv.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ecap := pb.NewECAPClient(conn)
+
+	req := &pb.ECertCreateReq{
+		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix()},
+		Id:   &pb.Identity{Id: adminID},
+		Tok:  &pb.Token{Tok: []byte(adminSecret)},
+		Sign: &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: signPub},
+		Enc:  &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: encPub}}
+
+	// the ECA answers with a challenge, encrypted for the encryption key
+	resp, err := ecap.CreateCertificatePair(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	spi := ecies.NewSPI()
+	eciesKey, err := spi.NewPrivateKey(nil, encPriv)
+	if err != nil {
+		return err
+	}
+	cipher, err := spi.NewAsymmetricCipherFromPublicKey(eciesKey)
+	if err != nil {
+		return err
+	}
+	if req.Tok.Tok, err = cipher.Process(resp.Tok.Tok); err != nil {
+		return err
+	}
+
+	if req.Sig, err = signAdminRequest(signPriv, req); err != nil {
+		return err
+	}
+	if resp, err = ecap.CreateCertificatePair(context.Background(), req); err != nil {
+		return err
+	}
+
+	rawKey, err := x509.MarshalECPrivateKey(signPriv)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(adminHome, 0700); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(adminKeyPath(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(adminCertPath(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: resp.Certs.Sign}), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Enrolled %s, its key and certificate are in %s.\n", adminID, adminHome)
+	return nil
+}
+
+var adminRoles = map[string]pb.Role{
+	"client":    pb.Role_CLIENT,
+	"peer":      pb.Role_PEER,
+	"validator": pb.Role_VALIDATOR,
+	"auditor":   pb.Role_AUDITOR,
+	"all":       pb.Role_ALL,
+}
+
+func adminUsers() error {
+	role, ok := adminRoles[strings.ToLower(adminRole)]
+	if !ok {
+		return fmt.Errorf("Unknown role %s.", adminRole)
+	}
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.ReadUserSetReq{Req: &pb.Identity{Id: adminID}, Role: role}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	users, err := pb.NewECAAClient(conn).ReadUserSet(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IDENTITY\tROLE")
+	for _, user := range users.Users {
+		var roles []string
+		for name, r := range adminRoles {
+			if r != pb.Role_ALL && user.Role&r != 0 {
+				roles = append(roles, name)
+			}
+		}
+		sort.Strings(roles)
+		fmt.Fprintf(w, "%s\t%s\n", user.Id.Id, strings.Join(roles, ","))
+	}
+	return w.Flush()
+}
+
+var adminCertTypes = map[string][]pb.CertificateType{
+	"ecert": {pb.CertificateType_ECERT_SIGN, pb.CertificateType_ECERT_ENC},
+	"tcert": {pb.CertificateType_TCERT},
+	"tls":   {pb.CertificateType_TLS_CERT},
+}
+
+func adminCerts(args []string) error {
+	var types []pb.CertificateType
+	if adminCertType != "" {
+		var ok bool
+		if types, ok = adminCertTypes[strings.ToLower(adminCertType)]; !ok {
+			return fmt.Errorf("Unknown certificate type %s.", adminCertType)
+		}
+	}
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	newRequest := func() (*pb.IssuedCertificatesReq, error) {
+		req := &pb.IssuedCertificatesReq{Id: &pb.Identity{Id: adminID}, Types: types, Unexpired: adminUnexpired, Limit: adminLimit}
+		if len(args) > 0 {
+			req.Subject = &pb.Identity{Id: args[0]}
+		}
+		var err error
+		req.Sig, err = signAdminRequest(priv, req)
+		return req, err
+	}
+	readers := []func(*pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error){
+		func(req *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+			return pb.NewECAAClient(conn).ReadIssuedCertificates(context.Background(), req)
+		},
+		func(req *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+			return pb.NewTCAAClient(conn).ReadIssuedCertificates(context.Background(), req)
+		},
+		func(req *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+			return pb.NewTLSCAAClient(conn).ReadIssuedCertificates(context.Background(), req)
+		},
+	}
+
+	var certs []*pb.IssuedCertificate
+	for _, read := range readers {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		set, err := read(req)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, set.Certs...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tIDENTITY\tTYPE\tISSUED\tEXPIRES\tREGISTRAR")
+	for _, cert := range certs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", cert.Serial, cert.Id.Id, cert.Type,
+			formatTimestamp(cert.IssuedAt), formatTimestamp(cert.NotAfter), cert.Registrar)
+	}
+	return w.Flush()
+}
+
+func formatTimestamp(ts *google_protobuf.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return time.Unix(ts.Seconds, 0).UTC().Format(time.RFC3339)
+}
+
+func adminRevoke(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The identity to revoke is required.")
+	}
+	reason, ok := pb.RevocationReason_value[strings.ToUpper(adminReason)]
+	if !ok {
+		return fmt.Errorf("Unknown revocation reason %s.", adminReason)
+	}
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ecaa := pb.NewECAAClient(conn)
+
+	if adminCertFile != "" {
+		raw, err := ioutil.ReadFile(adminCertFile)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return errors.New("Invalid certificate in " + adminCertFile)
+		}
+
+		req := &pb.ECertRevokeReq{Id: &pb.Identity{Id: adminID}, Cert: &pb.Cert{Cert: block.Bytes}, Reason: pb.RevocationReason(reason)}
+		if req.Sig, err = signAdminRequest(priv, req); err != nil {
+			return err
+		}
+		if _, err = ecaa.RevokeCertificate(context.Background(), req); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked the certificate of %s.\n", args[0])
+		return nil
+	}
+
+	req := &pb.IdentityRevokeReq{Id: &pb.Identity{Id: adminID}, Subject: &pb.Identity{Id: args[0]}, Reason: pb.RevocationReason(reason)}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	if _, err = ecaa.RevokeIdentity(context.Background(), req); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %s.\n", args[0])
+	return nil
+}
+
+func adminAffiliationList() error {
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.AffiliationReadReq{Id: &pb.Identity{Id: adminID}}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	set, err := pb.NewECAAClient(conn).ReadAffiliations(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "AFFILIATION\tPARENT")
+	for _, affiliation := range set.Affiliations {
+		fmt.Fprintf(w, "%s\t%s\n", affiliation.Name, affiliation.Parent)
+	}
+	return w.Flush()
+}
+
+func adminAffiliationAdd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The name of the affiliation group is required.")
+	}
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.AffiliationCreateReq{Id: &pb.Identity{Id: adminID}, Affiliation: &pb.Affiliation{Name: args[0], Parent: adminParent}}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	if _, err = pb.NewECAAClient(conn).CreateAffiliation(context.Background(), req); err != nil {
+		return err
+	}
+	fmt.Printf("Added affiliation group %s.\n", args[0])
+	return nil
+}
+
+func adminRotateSecret(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The identity whose secret to rotate is required.")
+	}
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.SecretResetReq{Id: &pb.Identity{Id: adminID}, Subject: &pb.Identity{Id: args[0]}, Ts: requestTime()}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	tok, err := pb.NewECAAClient(conn).ResetSecret(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("New one-time password of %s: %s\n", args[0], tok.Tok)
+	return nil
+}
+
+func adminRefreshAttributes(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The identity whose attributes to refresh is required.")
+	}
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.AttributesRefreshReq{Id: &pb.Identity{Id: adminID}, Subject: &pb.Identity{Id: args[0]}}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	if _, err = pb.NewECAAClient(conn).RefreshAttributes(context.Background(), req); err != nil {
+		return err
+	}
+	fmt.Printf("Refreshed the attributes of %s.\n", args[0])
+	return nil
+}
+
+func adminUpdateAttributes(args []string) error {
+	var validTo *google_protobuf.Timestamp
+	if adminValidTo != "" {
+		t, err := time.Parse(time.RFC3339, adminValidTo)
+		if err != nil {
+			return err
+		}
+		validTo = &google_protobuf.Timestamp{Seconds: t.Unix()}
+	}
+
+	var attrs []*pb.ACAAttribute
+	for _, arg := range args[1:] {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			return fmt.Errorf("Invalid attribute %s, expected name=value.", arg)
+		}
+		attr := &pb.ACAAttribute{AttributeName: arg[:i], AttributeValue: []byte(arg[i+1:])}
+		if i < len(arg)-1 {
+			attr.ValidTo = validTo
+		}
+		attrs = append(attrs, attr)
+	}
+
+	priv, err := readAdminKey()
+	if err != nil {
+		return err
+	}
+	conn, err := dialCA()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.AttributesUpdateReq{Id: &pb.Identity{Id: adminID}, Subject: &pb.Identity{Id: args[0]}, Attributes: attrs, Ts: requestTime()}
+	if req.Sig, err = signAdminRequest(priv, req); err != nil {
+		return err
+	}
+	if _, err = pb.NewECAAClient(conn).UpdateAttributes(context.Background(), req); err != nil {
+		return err
+	}
+	fmt.Printf("Updated the attributes of %s; its TCerts are to be refreshed.\n", args[0])
+	return nil
+}
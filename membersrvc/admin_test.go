@@ -0,0 +1,196 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/membersrvc/ca"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+)
+
+// TestMain serves an ECA from a temporary directory, which the admin commands are run against.
+func TestMain(m *testing.M) {
+	viper.SetConfigName("membersrvc")
+	viper.AddConfigPath("./")
+	if err := viper.ReadInConfig(); err != nil {
+		panic(fmt.Errorf("Fatal error config file: %s \n", err))
+	}
+	rootPath, err := ioutil.TempDir("", "membersrvc")
+	if err != nil {
+		panic(err)
+	}
+	viper.Set("server.rootpath", rootPath)
+	viper.Set("server.tls.cert.file", "")
+	viper.Set("server.tls.key.file", "")
+
+	if err := crypto.Init(); err != nil {
+		panic(fmt.Errorf("Failed initializing the crypto layer [%s]", err))
+	}
+	ca.LogInit(ioutil.Discard, ioutil.Discard, ioutil.Discard, os.Stderr, os.Stdout)
+
+	eca := ca.NewECA()
+	srv := grpc.NewServer()
+	eca.Start(srv)
+	sock, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic(err)
+	}
+	go srv.Serve(sock)
+
+	adminAddress = sock.Addr().String()
+	adminID = "admin"
+	adminHome = rootPath
+	adminReason = "unspecified"
+
+	ret := m.Run()
+
+	srv.Stop()
+	eca.Close()
+	os.RemoveAll(rootPath)
+	os.Exit(ret)
+}
+
+func TestAdminEnroll(t *testing.T) {
+	adminSecret = "Xurw3yU9zI0l"
+	if err := adminEnroll(); err != nil {
+		t.Fatalf("Failed enrolling the admin: %s", err)
+	}
+
+	raw, err := ioutil.ReadFile(adminCertPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		t.Fatal("Invalid enrollment certificate")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// the one-time password is spent
+	if err := adminEnroll(); err == nil {
+		t.Fatal("Enrolling twice with the same one-time password must fail")
+	}
+}
+
+func TestAdminRequestSignature(t *testing.T) {
+	// the ECAA verifies the signature against the enrollment certificate of the admin
+	if err := adminAffiliationList(); err != nil {
+		t.Fatalf("Failed listing the affiliation groups: %s", err)
+	}
+
+	// a request signed with another key is rejected
+	raw, err := ioutil.ReadFile(adminKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ioutil.WriteFile(adminKeyPath(), raw, 0600)
+
+	other, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawOther, err := x509.MarshalECPrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(adminKeyPath(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawOther}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := adminAffiliationList(); err == nil {
+		t.Fatal("A request signed with another key must be rejected")
+	}
+}
+
+func TestAdminRotateSecret(t *testing.T) {
+	if err := adminRotateSecret(nil); err == nil {
+		t.Fatal("Rotating a secret without an identity must fail")
+	}
+	if err := adminRotateSecret([]string{"diego"}); err != nil {
+		t.Fatalf("Failed rotating the secret of diego: %s", err)
+	}
+}
+
+func TestAdminArguments(t *testing.T) {
+	if err := adminRevoke(nil); err == nil {
+		t.Fatal("Revoking without an identity must fail")
+	}
+
+	adminReason = "bogus"
+	err := adminRevoke([]string{"lukas"})
+	adminReason = "unspecified"
+	if err == nil || !strings.Contains(err.Error(), "Unknown revocation reason") {
+		t.Fatalf("Revoking for an unknown reason must fail, got %v", err)
+	}
+
+	adminRole = "bogus"
+	err = adminUsers()
+	adminRole = "all"
+	if err == nil || !strings.Contains(err.Error(), "Unknown role") {
+		t.Fatalf("Listing the users of an unknown role must fail, got %v", err)
+	}
+
+	adminCertType = "bogus"
+	err = adminCerts(nil)
+	adminCertType = ""
+	if err == nil || !strings.Contains(err.Error(), "Unknown certificate type") {
+		t.Fatalf("Listing the certificates of an unknown type must fail, got %v", err)
+	}
+
+	if err := adminAffiliationAdd(nil); err == nil {
+		t.Fatal("Adding an affiliation group without a name must fail")
+	}
+
+	for _, attr := range []string{"role", "=client"} {
+		err := adminUpdateAttributes([]string{"lukas", attr})
+		if err == nil || !strings.Contains(err.Error(), "expected name=value") {
+			t.Fatalf("Setting the attribute %s must fail, got %v", attr, err)
+		}
+	}
+
+	adminValidTo = "tomorrow"
+	err = adminUpdateAttributes([]string{"lukas", "role=client"})
+	adminValidTo = ""
+	if err == nil {
+		t.Fatal("Setting attributes valid to an invalid time must fail")
+	}
+
+	if formatTimestamp(nil) != "" {
+		t.Fatal("A missing timestamp must be formatted as an empty string")
+	}
+}
+
+func TestAdminNotEnrolled(t *testing.T) {
+	adminID = "jim"
+	_, err := readAdminKey()
+	adminID = "admin"
+	if err == nil || !strings.Contains(err.Error(), "is not enrolled") {
+		t.Fatalf("Reading the key of an identity not enrolled must fail, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hyperledger/fabric/membersrvc/ca"
+	"github.com/spf13/cobra"
+)
+
+// Flags of the backup and escrow commands.
+var (
+	backupPassphraseFile string
+	escrowCA             string
+	escrowShares         int
+	escrowThreshold      int
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Backs up the state of the CAs.",
+	Long: `Writes the databases of the CAs and their keys, certificates and secrets to file,
+encrypted under the passphrase in --passphrase-file. The backup is consistent when the
+CA server serves no requests.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup(args)
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restores the state of the CAs from a backup.",
+	Long: `Restores the databases of the CAs and their keys, certificates and secrets from a
+backup encrypted under the passphrase in --passphrase-file. The CA server must be stopped:
+the rows of its databases are replaced with the ones of the backup.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restore(args)
+	},
+}
+
+var escrowCmd = &cobra.Command{
+	Use:   "escrow",
+	Short: "Puts the signing key of a CA in escrow.",
+}
+
+var escrowSplitCmd = &cobra.Command{
+	Use:   "split",
+	Short: "Splits the signing key of a CA into shares.",
+	Long: `Splits the signing key of --ca into --shares shares, one per line, any --threshold
+of which recover the key. Each share should be handed to a different custodian.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return escrowSplit()
+	},
+}
+
+var escrowRecoverCmd = &cobra.Command{
+	Use:   "recover [share...]",
+	Short: "Recovers the signing key of a CA from its shares.",
+	Long: `Recovers the signing key of --ca from the shares given, or read from the standard
+input one per line, and writes it to the state directory of the CA.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return escrowRecover(args)
+	},
+}
+
+// addBackupCommands adds the backup, restore and escrow commands to cmd.
+func addBackupCommands(cmd *cobra.Command) {
+	for _, c := range []*cobra.Command{backupCmd, restoreCmd} {
+		c.Flags().StringVarP(&backupPassphraseFile, "passphrase-file", "p", "", "File holding the passphrase of the backup")
+	}
+	escrowCmd.PersistentFlags().StringVarP(&escrowCA, "ca", "c", "eca", "CA whose signing key to put in escrow: eca, tca, tlsca or aca")
+	escrowSplitCmd.Flags().IntVarP(&escrowShares, "shares", "n", 5, "Number of shares")
+	escrowSplitCmd.Flags().IntVarP(&escrowThreshold, "threshold", "k", 3, "Number of shares recovering the key")
+
+	escrowCmd.AddCommand(escrowSplitCmd)
+	escrowCmd.AddCommand(escrowRecoverCmd)
+
+	cmd.AddCommand(backupCmd)
+	cmd.AddCommand(restoreCmd)
+	cmd.AddCommand(escrowCmd)
+}
+
+func readBackupPassphrase() ([]byte, error) {
+	if backupPassphraseFile == "" {
+		return nil, errors.New("The passphrase of the backup is required, see --passphrase-file.")
+	}
+	raw, err := ioutil.ReadFile(backupPassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(raw, "\r\n"), nil
+}
+
+func backup(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The file of the backup is required.")
+	}
+	passphrase, err := readBackupPassphrase()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err = ca.Backup(file, passphrase); err != nil {
+		file.Close()
+		os.Remove(args[0])
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backed up the CAs to %s.\n", args[0])
+	return nil
+}
+
+func restore(args []string) error {
+	if len(args) != 1 {
+		return errors.New("The file of the backup is required.")
+	}
+	passphrase, err := readBackupPassphrase()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = ca.Restore(file, passphrase); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored the CAs from %s.\n", args[0])
+	return nil
+}
+
+func escrowSplit() error {
+	shares, err := ca.EscrowKey(escrowCA, escrowShares, escrowThreshold)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		fmt.Println(share)
+	}
+	return nil
+}
+
+func escrowRecover(args []string) error {
+	shares := args
+	if len(shares) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				shares = append(shares, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if err := ca.RecoverKey(escrowCA, shares); err != nil {
+		return err
+	}
+	fmt.Printf("Recovered the signing key of the %s.\n", escrowCA)
+	return nil
+}
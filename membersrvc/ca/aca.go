@@ -26,9 +26,6 @@ import (
 
 	"crypto/x509"
 
-	"database/sql"
-
-	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
@@ -39,9 +36,19 @@ var (
 	ACAAttribute = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 10}
 )
 
+const (
+	//RoleAttributeName is the name of the attribute holding the role of its owner in its affiliation.
+	RoleAttributeName = "role"
+
+	//AffiliationAttributeName is the name of the attribute holding the affiliation of its owner.
+	AffiliationAttributeName = "affiliation"
+)
+
 // ACA is the attribute certificate authority.
 type ACA struct {
 	*CA
+	source attributeSource
+	cache  *attributeCache
 }
 
 // ACAA serves the administrator GRPC interface of the ACA.
@@ -65,10 +72,13 @@ func IsAttributeOID(oid asn1.ObjectIdentifier) bool {
 	return ACAAttribute[l-1] < oid[l-1]
 }
 
-func initializeACATables(db *sql.DB) error {
+func initializeACATables(db *Database) error {
 	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Attributes (row INTEGER PRIMARY KEY, id VARCHAR(64), affiliation VARCHAR(64), attributeName VARCHAR(64), validFrom DATETIME, validTo DATETIME,  attributeValue BLOB)"); err != nil {
 		return err
 	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS AttributeUpdates (row INTEGER PRIMARY KEY, id VARCHAR(64), affiliation VARCHAR(64), attributeName VARCHAR(64), validFrom DATETIME, validTo DATETIME, attributeValue BLOB)"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -199,6 +209,26 @@ func (attrPair *AttributePair) SetValidTo(date time.Time) {
 	attrPair.validTo = date
 }
 
+//newEnrollmentAttributePair creates, when attributeName is RoleAttributeName or AffiliationAttributeName, an attribute pair holding the
+//role or the affiliation of <attrOwner>, as recorded in its enrollment ID. It returns nil for any other attribute name.
+func newEnrollmentAttributePair(attrOwner *AttributeOwner, role, attributeName string) *AttributePair {
+	var value string
+	switch attributeName {
+	case RoleAttributeName:
+		value = role
+	case AffiliationAttributeName:
+		value = attrOwner.affiliation
+	default:
+		return nil
+	}
+
+	var attrPair = new(AttributePair)
+	attrPair.SetOwner(attrOwner)
+	attrPair.SetAttributeName(attributeName)
+	attrPair.SetAttributeValue([]byte(value))
+	return attrPair
+}
+
 //ToACAAttribute converts the receiver to the protobuf format.
 func (attrPair *AttributePair) ToACAAttribute() *pb.ACAAttribute {
 	var from, to *google_protobuf.Timestamp
@@ -218,7 +248,13 @@ func (attrPair *AttributePair) ToACAAttribute() *pb.ACAAttribute {
 
 // NewACA sets up a new ACA.
 func NewACA() *ACA {
-	aca := &ACA{NewCA("aca", initializeACATables)}
+	aca := &ACA{NewCA("aca", initializeACATables), nil, newAttributeCache()}
+
+	source, err := newAttributeSource()
+	if err != nil {
+		Panic.Panicln(err)
+	}
+	aca.source = source
 
 	return aca
 }
@@ -239,40 +275,113 @@ func (aca *ACA) getTCACertificate() (*x509.Certificate, error) {
 	return x509.ParseCertificate(raw)
 }
 
+// fetchAttributes reads the current attributes of a user from the attribute source,
+// overridden by the updates of its attributes requested through the ECA.
 func (aca *ACA) fetchAttributes(id, affiliation string) ([]*AttributePair, error) {
-	// TODO this attributes should be readed from the outside world in place of configuration file.
-	var attributes = make([]*AttributePair, 0)
-	attrs := viper.GetStringMapString("aca.attributes")
-
-	for _, flds := range attrs {
-		vals := strings.Fields(flds)
-		if len(vals) >= 1 {
-			val := ""
-			for _, eachVal := range vals {
-				val = val + " " + eachVal
-			}
-			attributeVals := strings.Split(val, ";")
-			if len(attributeVals) >= 6 {
-				attrPair, err := NewAttributePair(attributeVals, nil)
-				if err != nil {
-					return nil, errors.New("Invalid attribute entry " + val + " " + err.Error())
-				}
-				if attrPair.GetID() != id || attrPair.GetAffiliation() != affiliation {
-					continue
-				}
-				attributes = append(attributes, attrPair)
-			} else {
-				Error.Printf("Invalid attribute entry '%v'", vals[0])
-			}
+	attrs, err := aca.source.fetch(id, affiliation)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := aca.readAttributeUpdates(&AttributeOwner{id, affiliation})
+	if err != nil {
+		return nil, err
+	}
+	return mergeAttributeUpdates(attrs, updates), nil
+}
+
+// mergeAttributeUpdates replaces the attributes with the updates of the same name. The
+// updates without value remove the attributes.
+func mergeAttributeUpdates(attrs, updates []*AttributePair) []*AttributePair {
+	if len(updates) == 0 {
+		return attrs
+	}
+
+	updated := make(map[string]bool)
+	for _, update := range updates {
+		updated[update.GetAttributeName()] = true
+	}
+	merged := make([]*AttributePair, 0, len(attrs)+len(updates))
+	for _, attr := range attrs {
+		if !updated[attr.GetAttributeName()] {
+			merged = append(merged, attr)
 		}
 	}
+	for _, update := range updates {
+		if len(update.GetAttributeValue()) > 0 {
+			merged = append(merged, update)
+		}
+	}
+	return merged
+}
+
+// newAttributeUpdate converts attr, an update of the attributes of owner, which is valid
+// from now unless told otherwise.
+func newAttributeUpdate(owner *AttributeOwner, attr *pb.ACAAttribute, now time.Time) (*AttributePair, error) {
+	if attr == nil || strings.TrimSpace(attr.AttributeName) == "" {
+		return nil, errors.New("The name of the attribute is required.")
+	}
 
-	fmt.Printf("%v %v", id, attributes)
+	update := &AttributePair{owner, strings.TrimSpace(attr.AttributeName), attr.AttributeValue, now, time.Time{}}
+	if attr.ValidFrom != nil && attr.ValidFrom.Seconds != 0 {
+		update.SetValidFrom(time.Unix(attr.ValidFrom.Seconds, 0))
+	}
+	if attr.ValidTo != nil && attr.ValidTo.Seconds != 0 {
+		update.SetValidTo(time.Unix(attr.ValidTo.Seconds, 0))
+		if !update.GetValidTo().After(update.GetValidFrom()) {
+			return nil, fmt.Errorf("The attribute [%s] would never be valid.", update.GetAttributeName())
+		}
+	}
+	return update, nil
+}
+
+// updateAttributes records updates of the attributes of owner, which override the
+// attribute source from then on.
+func (aca *ACA) updateAttributes(owner *AttributeOwner, updates []*AttributePair) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tx, err := aca.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		if _, err = tx.Exec("DELETE FROM AttributeUpdates WHERE id=? AND affiliation=? AND attributeName=?", owner.GetID(), owner.GetAffiliation(), update.GetAttributeName()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec("INSERT INTO AttributeUpdates (id, affiliation, attributeName, validFrom, validTo, attributeValue) VALUES (?,?,?,?,?,?)",
+			owner.GetID(), owner.GetAffiliation(), update.GetAttributeName(), update.GetValidFrom(), update.GetValidTo(), update.GetAttributeValue()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
 
-	return attributes, nil
+// readAttributeUpdates reads the updates of the attributes of owner.
+func (aca *ACA) readAttributeUpdates(owner *AttributeOwner) ([]*AttributePair, error) {
+	rows, err := aca.db.Query("SELECT attributeName, attributeValue, validFrom, validTo FROM AttributeUpdates WHERE id=? AND affiliation=?", owner.GetID(), owner.GetAffiliation())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []*AttributePair
+	for rows.Next() {
+		var name string
+		var value []byte
+		var validFrom, validTo time.Time
+		if err = rows.Scan(&name, &value, &validFrom, &validTo); err != nil {
+			return nil, err
+		}
+		updates = append(updates, &AttributePair{owner, name, value, validFrom, validTo})
+	}
+	return updates, rows.Err()
 }
 
-func (aca *ACA) populateAttributes(attrs []*AttributePair) error {
+// populateAttributes stores the attributes of owner, removing those the
+// attribute source no longer supplies.
+func (aca *ACA) populateAttributes(owner *AttributeOwner, attrs []*AttributePair) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -280,6 +389,13 @@ func (aca *ACA) populateAttributes(attrs []*AttributePair) error {
 	if dberr != nil {
 		return dberr
 	}
+	if err := aca.removeStaleAttributes(tx, owner, attrs); err != nil {
+		dberr = tx.Rollback()
+		if dberr != nil {
+			return dberr
+		}
+		return err
+	}
 	for _, attr := range attrs {
 		if err := aca.populateAttribute(tx, attr); err != nil {
 			dberr = tx.Rollback()
@@ -296,9 +412,43 @@ func (aca *ACA) populateAttributes(attrs []*AttributePair) error {
 	return nil
 }
 
-func (aca *ACA) populateAttribute(tx *sql.Tx, attr *AttributePair) error {
-	fmt.Printf("*********************** ATTR %v %v %v\n", attr.GetID(), attr.attributeName, string(attr.attributeValue))
+func (aca *ACA) removeStaleAttributes(tx *DatabaseTx, owner *AttributeOwner, attrs []*AttributePair) error {
+	rows, err := tx.Query("SELECT DISTINCT attributeName FROM Attributes WHERE id=? AND affiliation =?", owner.GetID(), owner.GetAffiliation())
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		found := false
+		for _, attr := range attrs {
+			if attr.GetAttributeName() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			stale = append(stale, name)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range stale {
+		if _, err = tx.Exec("DELETE FROM Attributes WHERE id=? AND affiliation =? AND attributeName =?", owner.GetID(), owner.GetAffiliation(), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (aca *ACA) populateAttribute(tx *DatabaseTx, attr *AttributePair) error {
 	var count int
 	err := tx.QueryRow("SELECT count(row) AS cant FROM Attributes WHERE id=? AND affiliation =? AND attributeName =?",
 		attr.GetID(), attr.GetAffiliation(), attr.GetAttributeName()).Scan(&count)
@@ -308,7 +458,7 @@ func (aca *ACA) populateAttribute(tx *sql.Tx, attr *AttributePair) error {
 	}
 
 	if count > 0 {
-		_, err = tx.Exec("UPDATE Attributes SET validFrom = ?, validTo = ?,  attributeValue = ? WHERE  id=? AND affiliation =? AND attributeName =? AND validFrom < ?",
+		_, err = tx.Exec("UPDATE Attributes SET validFrom = ?, validTo = ?,  attributeValue = ? WHERE  id=? AND affiliation =? AND attributeName =? AND validFrom <= ?",
 			attr.GetValidFrom(), attr.GetValidTo(), attr.GetAttributeValue(), attr.GetID(), attr.GetAffiliation(), attr.GetAttributeName(), attr.GetValidFrom())
 		if err != nil {
 			return err
@@ -329,13 +479,38 @@ func (aca *ACA) fetchAndPopulateAttributes(id, affiliation string) error {
 	if err != nil {
 		return err
 	}
-	err = aca.populateAttributes(attrs)
+	err = aca.populateAttributes(&AttributeOwner{id, affiliation}, attrs)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// refreshAttributes fetches the attributes of a user from the attribute source and
+// stores them, unless they were fetched less than aca.source.cache.ttl ago.
+func (aca *ACA) refreshAttributes(id, affiliation string) error {
+	owner := AttributeOwner{id, affiliation}
+	fresh, generation := aca.cache.lookup(owner)
+	if fresh {
+		attributeLookups.inc("cached")
+		return nil
+	}
+
+	attributeLookups.inc("fetched")
+	fetched := time.Now()
+	if err := aca.fetchAndPopulateAttributes(id, affiliation); err != nil {
+		return err
+	}
+	aca.cache.add(owner, fetched, generation)
+	return nil
+}
+
+// invalidateAttributes makes the next lookup of the attributes of a user fetch them
+// from the attribute source.
+func (aca *ACA) invalidateAttributes(id, affiliation string) {
+	aca.cache.invalidate(AttributeOwner{id, affiliation})
+}
+
 func (aca *ACA) findAttribute(owner *AttributeOwner, attributeName string) (*AttributePair, error) {
 	var count int
 
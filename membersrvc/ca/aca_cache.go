@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultAttributeCacheSize = 10000
+
+// attributeCache remembers when the attributes of each user were last fetched
+// from the attribute source and stored by the ACA, so that TCert requests
+// fetch them again only once they are older than the TTL. The entry of a user
+// is invalidated when its attributes are known to have changed, and attributes
+// fetched before an invalidation are not cached.
+//
+type attributeCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mutex      sync.Mutex
+	fetched    map[AttributeOwner]time.Time
+	generation uint64
+}
+
+// newAttributeCache reads the aca.source.cache section of the configuration,
+// and returns nil if the attributes are not cached.
+//
+func newAttributeCache() *attributeCache {
+	ttl := viper.GetDuration("aca.source.cache.ttl")
+	if ttl <= 0 {
+		return nil
+	}
+	size := viper.GetInt("aca.source.cache.size")
+	if size <= 0 {
+		size = defaultAttributeCacheSize
+	}
+	return &attributeCache{ttl: ttl, size: size, now: time.Now, fetched: make(map[AttributeOwner]time.Time)}
+}
+
+// lookup tells whether the attributes of owner were fetched less than the TTL
+// ago, and returns the current generation of the cache, to pass to add.
+//
+func (c *attributeCache) lookup(owner AttributeOwner) (bool, uint64) {
+	if c == nil {
+		return false, 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fetched, ok := c.fetched[owner]
+	return ok && c.now().Sub(fetched) < c.ttl, c.generation
+}
+
+// add records that the attributes of owner were fetched at time fetched, unless
+// the cache was invalidated since the generation returned by lookup. The expired
+// entries, or else the oldest one, are evicted when the cache is full.
+//
+func (c *attributeCache) add(owner AttributeOwner, fetched time.Time, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if _, ok := c.fetched[owner]; !ok && len(c.fetched) >= c.size {
+		now := c.now()
+		var oldest AttributeOwner
+		var oldestTime time.Time
+		for o, t := range c.fetched {
+			if now.Sub(t) >= c.ttl {
+				delete(c.fetched, o)
+			} else if oldestTime.IsZero() || t.Before(oldestTime) {
+				oldest, oldestTime = o, t
+			}
+		}
+		if len(c.fetched) >= c.size {
+			delete(c.fetched, oldest)
+		}
+	}
+	c.fetched[owner] = fetched
+}
+
+// invalidate forgets the attributes of owner, which are fetched anew on the next lookup.
+//
+func (c *attributeCache) invalidate(owner AttributeOwner) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.fetched, owner)
+	c.generation++
+}
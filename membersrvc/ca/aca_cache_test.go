@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// countingAttributeSource counts the fetches of the attributes.
+type countingAttributeSource struct {
+	fetches int
+}
+
+func (src *countingAttributeSource) fetch(id, affiliation string) ([]*AttributePair, error) {
+	src.fetches++
+	return nil, nil
+}
+
+func TestAttributeCache(t *testing.T) {
+	viper.Set("aca.source.cache.ttl", "1m")
+	viper.Set("aca.source.cache.size", 2)
+	defer viper.Set("aca.source.cache.ttl", nil)
+	defer viper.Set("aca.source.cache.size", nil)
+
+	c := newAttributeCache()
+	if c == nil {
+		t.Fatal("The attributes should be cached")
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	alice, bob, carol := AttributeOwner{"alice", "bank_a"}, AttributeOwner{"bob", "bank_a"}, AttributeOwner{"carol", "bank_a"}
+
+	fresh, generation := c.lookup(alice)
+	if fresh {
+		t.Fatal("Nothing should be cached yet")
+	}
+	c.add(alice, now, generation)
+	if fresh, _ = c.lookup(alice); !fresh {
+		t.Fatal("The attributes just fetched should be fresh")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if fresh, _ = c.lookup(alice); fresh {
+		t.Fatal("The attributes older than the TTL should be stale")
+	}
+
+	// attributes fetched before an invalidation are not cached
+	_, generation = c.lookup(bob)
+	c.invalidate(alice)
+	c.add(bob, now, generation)
+	if fresh, _ = c.lookup(bob); fresh {
+		t.Fatal("The attributes fetched before an invalidation should not be cached")
+	}
+
+	// the expired entry of alice, then the oldest entry, are evicted when full
+	_, generation = c.lookup(bob)
+	c.add(alice, now.Add(-2*time.Minute), generation)
+	c.add(bob, now.Add(-time.Second), generation)
+	c.add(carol, now, generation)
+	if _, ok := c.fetched[alice]; ok {
+		t.Fatal("The expired entry should be evicted")
+	}
+	c.add(alice, now, generation)
+	if len(c.fetched) != 2 {
+		t.Fatalf("The cache holds %d entries, expected 2", len(c.fetched))
+	}
+	if fresh, _ = c.lookup(bob); fresh {
+		t.Fatal("The oldest entry should be evicted")
+	}
+
+	viper.Set("aca.source.cache.ttl", "0s")
+	if newAttributeCache() != nil {
+		t.Fatal("The attributes should not be cached without a TTL")
+	}
+}
+
+func TestRefreshAttributesCached(t *testing.T) {
+	viper.Set("aca.source.cache.ttl", "1m")
+	defer viper.Set("aca.source.cache.ttl", nil)
+
+	src := &countingAttributeSource{}
+	source, cache := aca.source, aca.cache
+	aca.source, aca.cache = src, newAttributeCache()
+	defer func() { aca.source, aca.cache = source, cache }()
+
+	for i := 0; i < 3; i++ {
+		if err := aca.refreshAttributes("cached_user", "bank_a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if src.fetches != 1 {
+		t.Fatalf("The attributes were fetched %d times, expected once", src.fetches)
+	}
+
+	aca.invalidateAttributes("cached_user", "bank_a")
+	if err := aca.refreshAttributes("cached_user", "bank_a"); err != nil {
+		t.Fatal(err)
+	}
+	if src.fetches != 2 {
+		t.Fatalf("The attributes were fetched %d times, expected twice", src.fetches)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const defaultAttributeSourceQuery = "SELECT attributeName, attributeValue, validFrom, validTo FROM Attributes WHERE id=? AND affiliation=?"
+
+// attributeSource supplies the attributes of the users. The ACA queries it
+// every time it issues attributes, or once per TTL with an attributeCache, so
+// that changes made at the source take effect without restarting the CA.
+//
+type attributeSource interface {
+	fetch(id, affiliation string) ([]*AttributePair, error)
+}
+
+// configAttributeSource reads the attributes listed in the aca.attributes
+// section of the configuration.
+//
+type configAttributeSource struct{}
+
+// sqlAttributeSource reads the attributes from an external database. Its
+// query takes the enrollment ID and the affiliation of a user and returns
+// the name, value, valid from and valid to of each of its attributes.
+//
+type sqlAttributeSource struct {
+	db    *sql.DB
+	query string
+}
+
+// restAttributeSource reads the attributes from a REST service answering
+// GET <url>?id=<EnrollmentID>&affiliation=<Affiliation> with a JSON array
+// of restAttribute.
+//
+type restAttributeSource struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// restAttribute is an attribute as returned by a REST attribute source. The
+// dates are in RFC 3339 format and may be empty.
+type restAttribute struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	ValidFrom string `json:"validFrom"`
+	ValidTo   string `json:"validTo"`
+}
+
+// newAttributeSource reads the aca.source section of the configuration. The
+// attributes listed in the configuration are used unless another source is
+// given.
+//
+func newAttributeSource() (attributeSource, error) {
+	switch kind := viper.GetString("aca.source.type"); kind {
+	case "", "config":
+		return configAttributeSource{}, nil
+	case "sql":
+		driver := viper.GetString("aca.source.sql.driver")
+		if driver == "" {
+			driver = "sqlite3"
+		}
+		db, err := sql.Open(driver, viper.GetString("aca.source.sql.datasource"))
+		if err != nil {
+			return nil, err
+		}
+		query := viper.GetString("aca.source.sql.query")
+		if query == "" {
+			query = defaultAttributeSourceQuery
+		}
+		return &sqlAttributeSource{db, query}, nil
+	case "rest":
+		u, err := url.Parse(viper.GetString("aca.source.rest.url"))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("Unsupported attribute source URL scheme [%s]", u.Scheme)
+		}
+		timeout := viper.GetDuration("aca.source.rest.timeout")
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		return &restAttributeSource{u, &http.Client{Timeout: timeout}}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported attribute source [%s]", kind)
+	}
+}
+
+func (src configAttributeSource) fetch(id, affiliation string) ([]*AttributePair, error) {
+	var attributes = make([]*AttributePair, 0)
+	attrs := viper.GetStringMapString("aca.attributes")
+
+	for _, flds := range attrs {
+		vals := strings.Fields(flds)
+		if len(vals) >= 1 {
+			val := ""
+			for _, eachVal := range vals {
+				val = val + " " + eachVal
+			}
+			attributeVals := strings.Split(val, ";")
+			if len(attributeVals) >= 6 {
+				attrPair, err := NewAttributePair(attributeVals, nil)
+				if err != nil {
+					return nil, errors.New("Invalid attribute entry " + val + " " + err.Error())
+				}
+				if attrPair.GetID() != id || attrPair.GetAffiliation() != affiliation {
+					continue
+				}
+				attributes = append(attributes, attrPair)
+			} else {
+				Error.Printf("Invalid attribute entry '%v'", vals[0])
+			}
+		}
+	}
+
+	return attributes, nil
+}
+
+func (src *sqlAttributeSource) fetch(id, affiliation string) ([]*AttributePair, error) {
+	rows, err := src.db.Query(src.query, id, affiliation)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owner := &AttributeOwner{id, affiliation}
+	var attributes = make([]*AttributePair, 0)
+	for rows.Next() {
+		var name string
+		var value, validFrom, validTo sql.NullString
+		if err := rows.Scan(&name, &value, &validFrom, &validTo); err != nil {
+			return nil, err
+		}
+		attrPair, err := NewAttributePair([]string{id, affiliation, name, value.String, validFrom.String, validTo.String}, owner)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid attribute [%s] of user [%s]: %s", name, id, err)
+		}
+		attributes = append(attributes, attrPair)
+	}
+
+	return attributes, rows.Err()
+}
+
+func (src *restAttributeSource) fetch(id, affiliation string) ([]*AttributePair, error) {
+	u := *src.url
+	query := u.Query()
+	query.Set("id", id)
+	query.Set("affiliation", affiliation)
+	u.RawQuery = query.Encode()
+
+	resp, err := src.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// users unknown to the source have no attributes
+	if resp.StatusCode == http.StatusNotFound {
+		return make([]*AttributePair, 0), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Attribute source replied [%s]", resp.Status)
+	}
+
+	var attrs []restAttribute
+	if err := json.NewDecoder(resp.Body).Decode(&attrs); err != nil {
+		return nil, err
+	}
+
+	owner := &AttributeOwner{id, affiliation}
+	var attributes = make([]*AttributePair, 0, len(attrs))
+	for _, attr := range attrs {
+		attrPair, err := NewAttributePair([]string{id, affiliation, attr.Name, attr.Value, attr.ValidFrom, attr.ValidTo}, owner)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid attribute [%s] of user [%s]: %s", attr.Name, id, err)
+		}
+		attributes = append(attributes, attrPair)
+	}
+
+	return attributes, nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"database/sql"
+	"fmt"
+	"google/protobuf"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+func TestConfigAttributeSource(t *testing.T) {
+	attrs, err := configAttributeSource{}.fetch("test_user0", "bank_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 4 {
+		t.Fatalf("Expected 4 attribute entries, got %d", len(attrs))
+	}
+}
+
+func TestSQLAttributeSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acasource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite3", dir+"/attributes.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE UserAttributes (uid TEXT, org TEXT, name TEXT, value TEXT, since TEXT, until TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO UserAttributes VALUES ('sql_user', 'bank_a', 'company', 'ACompany', '2015-01-01T00:00:00-03:00', NULL), ('sql_user', 'bank_b', 'company', 'BCompany', NULL, NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("aca.source.type", "sql")
+	viper.Set("aca.source.sql.datasource", dir+"/attributes.db")
+	viper.Set("aca.source.sql.query", "SELECT name, value, since, until FROM UserAttributes WHERE uid=? AND org=?")
+	defer viper.Set("aca.source.type", "")
+
+	source, err := newAttributeSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := source.fetch("sql_user", "bank_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 1 || attrs[0].GetAttributeName() != "company" || string(attrs[0].GetAttributeValue()) != "ACompany" {
+		t.Fatalf("Unexpected attributes %v", attrs)
+	}
+	if !attrs[0].GetValidTo().IsZero() || attrs[0].GetValidFrom().Year() != 2015 {
+		t.Fatalf("Unexpected validity [%v, %v]", attrs[0].GetValidFrom(), attrs[0].GetValidTo())
+	}
+}
+
+func TestRESTAttributeSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("id") != "rest_user" || req.URL.Query().Get("affiliation") != "bank_a" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(rw, `[{"name": "position", "value": "Teller", "validFrom": "2016-01-01T00:00:00Z"}]`)
+	}))
+	defer srv.Close()
+
+	viper.Set("aca.source.type", "rest")
+	viper.Set("aca.source.rest.url", srv.URL+"/attributes")
+	defer viper.Set("aca.source.type", "")
+
+	source, err := newAttributeSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err := source.fetch("rest_user", "bank_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 1 || attrs[0].GetID() != "rest_user" || attrs[0].GetAttributeName() != "position" || string(attrs[0].GetAttributeValue()) != "Teller" {
+		t.Fatalf("Unexpected attributes %v", attrs)
+	}
+
+	attrs, err = source.fetch("unknown_user", "bank_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 0 {
+		t.Fatalf("Expected no attributes for an unknown user, got %v", attrs)
+	}
+}
+
+func TestUnsupportedAttributeSource(t *testing.T) {
+	viper.Set("aca.source.type", "ldap")
+	defer viper.Set("aca.source.type", "")
+
+	if _, err := newAttributeSource(); err == nil {
+		t.Fatal("An unsupported attribute source should be rejected")
+	}
+}
+
+func TestPopulateAttributesRemovesStale(t *testing.T) {
+	owner := &AttributeOwner{"stale_user", "bank_a"}
+	from := time.Now().Add(-time.Hour)
+
+	attrs := []*AttributePair{
+		{owner, "company", []byte("ACompany"), from, time.Time{}},
+		{owner, "position", []byte("Teller"), from, time.Time{}},
+	}
+	if err := aca.populateAttributes(owner, attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	// the source changes the value of an attribute and drops the other one
+	attrs = []*AttributePair{{owner, "position", []byte("Manager"), from, time.Time{}}}
+	if err := aca.populateAttributes(owner, attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, count, err := readAttributesFromDB(owner.GetID(), owner.GetAffiliation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || string(stored["position"]) != "Manager" {
+		t.Fatalf("Unexpected stored attributes %v", stored)
+	}
+}
+
+func TestAttributeUpdates(t *testing.T) {
+	owner := &AttributeOwner{"updated_user", "bank_a"}
+	now := time.Now()
+
+	attrs := []*AttributePair{
+		{owner, "company", []byte("ACompany"), now.Add(-time.Hour), time.Time{}},
+		{owner, "position", []byte("Teller"), now.Add(-time.Hour), time.Time{}},
+		{owner, "account", []byte("12345"), now.Add(-time.Hour), time.Time{}},
+	}
+
+	var updates []*AttributePair
+	for _, attr := range []*pb.ACAAttribute{
+		{AttributeName: "position", AttributeValue: []byte("Manager")},
+		{AttributeName: "account"},
+		{AttributeName: "branch", AttributeValue: []byte("North")},
+	} {
+		update, err := newAttributeUpdate(owner, attr, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updates = append(updates, update)
+	}
+	if err := aca.updateAttributes(owner, updates); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := aca.readAttributeUpdates(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := make(map[string]string)
+	for _, attr := range mergeAttributeUpdates(attrs, stored) {
+		merged[attr.GetAttributeName()] = string(attr.GetAttributeValue())
+	}
+	if len(merged) != 3 || merged["company"] != "ACompany" || merged["position"] != "Manager" || merged["branch"] != "North" {
+		t.Fatalf("Unexpected attributes %v", merged)
+	}
+
+	expired := &pb.ACAAttribute{AttributeName: "position", AttributeValue: []byte("Manager"), ValidTo: &google_protobuf.Timestamp{Seconds: now.Add(-time.Minute).Unix()}}
+	if _, err = newAttributeUpdate(owner, expired, now); err == nil {
+		t.Fatal("An update never valid should be rejected")
+	}
+}
@@ -614,6 +614,79 @@ func TestRequestAttributes_PartialAttributes(t *testing.T) {
 	}
 }
 
+func TestRequestAttributes_EnrollmentAttributes(t *testing.T) {
+
+	cert, err := loadECert(identity)
+	if err != nil {
+		t.Fatalf("Error loading ECert: %v", err)
+	}
+	ecert := cert.Raw
+
+	sock, acaP, err := GetACAClient()
+	if err != nil {
+		t.Fatalf("Error executing test: %v", err)
+	}
+	defer sock.Close()
+
+	var attributes []*pb.TCertAttribute
+	attributes = append(attributes, &pb.TCertAttribute{AttributeName: RoleAttributeName})
+	attributes = append(attributes, &pb.TCertAttribute{AttributeName: AffiliationAttributeName})
+
+	req := &pb.ACAAttrReq{
+		Ts:         &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:         &pb.Identity{Id: identity},
+		ECert:      &pb.Cert{Cert: ecert},
+		Attributes: attributes,
+		Signature:  nil}
+
+	rawReq, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("Error executing test: %v", err)
+	}
+
+	r, s, err := primitives.ECDSASignDirect(tca.priv, rawReq)
+	if err != nil {
+		t.Fatalf("Error executing test: %v", err)
+	}
+
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+
+	req.Signature = &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}
+
+	resp, err := acaP.RequestAttributes(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Error executing test: %v", err)
+	}
+
+	if resp.Status != pb.ACAAttrResp_FULL_SUCCESSFUL {
+		t.Fatalf("Role and affiliation should be found for every enrollment ID.")
+	}
+
+	aCert, err := primitives.DERToX509Certificate(resp.Cert.Cert)
+	if err != nil {
+		t.Fatalf("Error executing test: %v", err)
+	}
+
+	valueMap := make(map[string]string)
+	for _, eachExtension := range aCert.Extensions {
+		if IsAttributeOID(eachExtension.Id) {
+			var attribute pb.ACAAttribute
+			proto.Unmarshal(eachExtension.Value, &attribute)
+			valueMap[attribute.AttributeName] = string(attribute.AttributeValue)
+		}
+	}
+
+	enrollIDSections := strings.Split(cert.Subject.CommonName, "\\")
+	if valueMap[AffiliationAttributeName] != enrollIDSections[1] {
+		t.Fatalf("The affiliation should be the one of the enrollment ID, found [%s].", valueMap[AffiliationAttributeName])
+	}
+
+	if valueMap[RoleAttributeName] != enrollIDSections[2] {
+		t.Fatalf("The role should be the one of the enrollment ID, found [%s].", valueMap[RoleAttributeName])
+	}
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -20,6 +20,7 @@ import (
 	"encoding/asn1"
 	"errors"
 	"math/big"
+	"time"
 
 	"crypto/ecdsa"
 	"crypto/x509"
@@ -77,7 +78,24 @@ func (acap *ACAP) FetchAttributes(ctx context.Context, in *pb.ACAFetchAttrReq) (
 		return &pb.ACAFetchAttrResp{Status: pb.ACAFetchAttrResp_FAILURE}, err
 	}
 
-	err = acap.aca.fetchAndPopulateAttributes(id, affiliation)
+	if len(in.Attributes) > 0 {
+		owner := &AttributeOwner{id, affiliation}
+		now := time.Now()
+		updates := make([]*AttributePair, len(in.Attributes))
+		for i, attr := range in.Attributes {
+			if updates[i], err = newAttributeUpdate(owner, attr, now); err != nil {
+				return &pb.ACAFetchAttrResp{Status: pb.ACAFetchAttrResp_FAILURE, Msg: err.Error()}, nil
+			}
+		}
+		if err = acap.aca.updateAttributes(owner, updates); err != nil {
+			return &pb.ACAFetchAttrResp{Status: pb.ACAFetchAttrResp_FAILURE}, err
+		}
+	}
+
+	// the ECA asks for the attributes on enrollment and when they are updated, so they
+	// are fetched anew rather than from the cache
+	acap.aca.invalidateAttributes(id, affiliation)
+	err = acap.aca.refreshAttributes(id, affiliation)
 	if err != nil {
 		return &pb.ACAFetchAttrResp{Status: pb.ACAFetchAttrResp_FAILURE}, err
 	}
@@ -92,7 +110,7 @@ func (acap *ACAP) createRequestAttributeResponse(status pb.ACAAttrResp_StatusCod
 		return &pb.ACAAttrResp{Status: pb.ACAAttrResp_FAILURE, Cert: nil, Signature: nil}
 	}
 
-	r, s, err := primitives.ECDSASignDirect(acap.aca.priv, rawReq)
+	r, s, err := acap.aca.signDirect(rawReq)
 	if err != nil {
 		return &pb.ACAAttrResp{Status: pb.ACAAttrResp_FAILURE, Cert: nil, Signature: nil}
 	}
@@ -160,13 +178,13 @@ func (acap *ACAP) RequestAttributes(ctx context.Context, in *pb.ACAAttrReq) (*pb
 	if err != nil {
 		return acap.createRequestAttributeResponse(pb.ACAAttrResp_FAILURE, nil), err
 	}
-	var id, affiliation string
-	id, _, affiliation, err = acap.aca.parseEnrollID(cert.Subject.CommonName)
+	var id, role, affiliation string
+	id, role, affiliation, err = acap.aca.parseEnrollID(cert.Subject.CommonName)
 	if err != nil {
 		return acap.createRequestAttributeResponse(pb.ACAAttrResp_FAILURE, nil), err
 	}
-	//Before continue with the request we perform a refresh of the attributes.
-	err = acap.aca.fetchAndPopulateAttributes(id, affiliation)
+	//Before continue with the request we perform a refresh of the attributes, unless recently done.
+	err = acap.aca.refreshAttributes(id, affiliation)
 	if err != nil {
 		return acap.createRequestAttributeResponse(pb.ACAAttrResp_FAILURE, nil), err
 	}
@@ -176,6 +194,10 @@ func (acap *ACAP) RequestAttributes(ctx context.Context, in *pb.ACAAttrReq) (*pb
 	owner := &AttributeOwner{id, affiliation}
 	for _, attrPair := range in.Attributes {
 		verifiedPair, _ := acap.aca.findAttribute(owner, attrPair.AttributeName)
+		if verifiedPair == nil {
+			// Role and affiliation, unless given by the attributes source, come from the enrollment ID
+			verifiedPair = newEnrollmentAttributePair(owner, role, attrPair.AttributeName)
+		}
 		if verifiedPair != nil {
 			verifyCounter++
 			attributes = append(attributes, *verifiedPair)
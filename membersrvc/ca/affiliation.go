@@ -0,0 +1,427 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/x509/pkix"
+	"database/sql"
+	"errors"
+	"strings"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// The affiliation groups form a forest, populated from eca.affiliations and
+// managed at runtime through the ECAA. A registrar with an affiliation may only
+// register users, and manage affiliation groups, within the subtree rooted at
+// its own affiliation group; a registrar without one is not restricted. The
+// path of the affiliation group of a member, from the top-level group down, is
+// embedded in its enrollment certificates.
+
+// affiliationPathSeparator separates the names of the groups of an affiliation
+// path, as embedded in enrollment certificates.
+const affiliationPathSeparator = "/"
+
+// maxAffiliationDepth bounds the walk up the affiliation groups.
+const maxAffiliationDepth = 64
+
+// readAffiliationPath returns the names of the affiliation groups from the
+// top-level group down to name.
+//
+func (ca *CA) readAffiliationPath(name string) ([]string, error) {
+	var path []string
+	for name != "" {
+		if len(path) == maxAffiliationDepth {
+			return nil, errors.New("Affiliation group hierarchy is too deep.")
+		}
+
+		var parent sql.NullString
+		err := ca.db.QueryRow("SELECT p.name FROM AffiliationGroups g LEFT JOIN AffiliationGroups p ON g.parent=p.row WHERE g.name=?", name).Scan(&parent)
+		if err == sql.ErrNoRows {
+			return nil, errors.New("Invalid affiliation group " + name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		path = append([]string{name}, path...)
+		name = parent.String
+	}
+
+	return path, nil
+}
+
+// readAffiliationSubtree returns the names of the affiliation group name and
+// of all its descendants.
+//
+func (ca *CA) readAffiliationSubtree(name string) ([]string, error) {
+	groups, err := ca.readAffiliationGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var subtree []string
+	for _, group := range groups {
+		for g, depth := group, 0; g != nil && depth < maxAffiliationDepth; g, depth = g.parent, depth+1 {
+			if g.name == name {
+				subtree = append(subtree, group.name)
+				break
+			}
+		}
+	}
+
+	return subtree, nil
+}
+
+// isWithinAffiliation returns true if the affiliation group name is ancestor
+// or one of its descendants.
+//
+func (ca *CA) isWithinAffiliation(name, ancestor string) (bool, error) {
+	path, err := ca.readAffiliationPath(name)
+	if err != nil {
+		return false, err
+	}
+
+	return strContained(ancestor, path), nil
+}
+
+// readMemberAffiliation returns the affiliation group of the member id, or the
+// empty string if it has none.
+//
+func (ca *CA) readMemberAffiliation(id string) (string, error) {
+	var enrollID sql.NullString
+	if err := ca.db.QueryRow("SELECT enrollmentId FROM Users WHERE id=?", id).Scan(&enrollID); err != nil {
+		return "", err
+	}
+	if enrollID.String == "" {
+		return "", nil
+	}
+
+	_, _, affiliation, err := ca.parseEnrollID(enrollID.String)
+	return affiliation, err
+}
+
+// affiliationExtensions returns the extension embedding, in the enrollment
+// certificates of a member with enrollment ID enrollID, the path of its
+// affiliation group. Members without an affiliation get none.
+//
+func (ca *CA) affiliationExtensions(enrollID string) ([]pkix.Extension, error) {
+	if enrollID == "" {
+		return nil, nil
+	}
+
+	_, _, affiliation, err := ca.parseEnrollID(enrollID)
+	if err != nil {
+		return nil, err
+	}
+	path, err := ca.readAffiliationPath(affiliation)
+	if err != nil {
+		return nil, err
+	}
+
+	return []pkix.Extension{{Id: ECertSubjectAffiliation, Critical: false, Value: []byte(strings.Join(path, affiliationPathSeparator))}}, nil
+}
+
+// checkAffiliationScope checks that the affiliation group name is within the
+// subtree of registrar.
+//
+func (ca *CA) checkAffiliationScope(registrar, name string) error {
+	scope, err := ca.readMemberAffiliation(registrar)
+	if err != nil {
+		return err
+	}
+	if scope == "" {
+		return nil
+	}
+
+	within, err := ca.isWithinAffiliation(name, scope)
+	if err != nil {
+		return err
+	}
+	if !within {
+		Trace.Printf("CA.checkAffiliationScope: %s is outside the subtree of %s\n", name, registrar)
+		return errors.New("member " + registrar + " may not act on affiliation group " + name)
+	}
+
+	return nil
+}
+
+// checkAffiliationManagement checks that registrar may create, change or
+// delete the affiliation group name, that is name is strictly within its
+// subtree.
+//
+func (ca *CA) checkAffiliationManagement(registrar, name string) error {
+	if err := ca.checkAffiliationScope(registrar, name); err != nil {
+		return err
+	}
+
+	scope, err := ca.readMemberAffiliation(registrar)
+	if err != nil {
+		return err
+	}
+	if scope == name {
+		return errors.New("member " + registrar + " may not change its own affiliation group")
+	}
+
+	return nil
+}
+
+// countAffiliationMembers returns the number of members whose affiliation group
+// is one of groups.
+//
+func (ca *CA) countAffiliationMembers(groups []string) (int, error) {
+	rows, err := ca.db.Query("SELECT enrollmentId FROM Users WHERE enrollmentId IS NOT NULL AND enrollmentId != ''")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var enrollID string
+		if err = rows.Scan(&enrollID); err != nil {
+			return 0, err
+		}
+		if _, _, affiliation, err := ca.parseEnrollID(enrollID); err == nil && strContained(affiliation, groups) {
+			count++
+		}
+	}
+
+	return count, rows.Err()
+}
+
+// checkAffiliationName checks that name can name an affiliation group.
+//
+func checkAffiliationName(name string) error {
+	if name == "" {
+		return errors.New("Affiliation group name is required.")
+	}
+	if strings.Contains(name, "\\") || strings.Contains(name, affiliationPathSeparator) {
+		return errors.New("Do not include \\ nor " + affiliationPathSeparator + " in affiliation group names")
+	}
+
+	return nil
+}
+
+// updateAffiliationGroup renames the affiliation group name to newName and
+// moves it under newParent. Groups whose subtree has members cannot be
+// changed, since their enrollment IDs and the TCA pre-keys derive from it.
+//
+func (ca *CA) updateAffiliationGroup(name, newName, newParent string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	Trace.Println("Updating affiliation group " + name + " to " + newName + " parent " + newParent + ".")
+
+	if err := checkAffiliationName(newName); err != nil {
+		return err
+	}
+
+	var row int64
+	if err := ca.db.QueryRow("SELECT row FROM AffiliationGroups WHERE name=?", name).Scan(&row); err != nil {
+		return errors.New("Invalid affiliation group " + name)
+	}
+	if newName != name {
+		var count int
+		if err := ca.db.QueryRow("SELECT count(row) FROM AffiliationGroups WHERE name=?", newName).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("Affiliation group is already registered")
+		}
+	}
+
+	subtree, err := ca.readAffiliationSubtree(name)
+	if err != nil {
+		return err
+	}
+	var parentID int64
+	if newParent != "" {
+		if strContained(newParent, subtree) {
+			return errors.New("Affiliation group " + name + " cannot be moved under its own subtree")
+		}
+		if err = ca.db.QueryRow("SELECT row FROM AffiliationGroups WHERE name=?", newParent).Scan(&parentID); err != nil {
+			return errors.New("Invalid affiliation group " + newParent)
+		}
+	}
+
+	members, err := ca.countAffiliationMembers(subtree)
+	if err != nil {
+		return err
+	}
+	if members > 0 {
+		return errors.New("Affiliation group " + name + " has members")
+	}
+
+	if _, err = ca.db.Exec("UPDATE AffiliationGroups SET name=?, parent=? WHERE row=?", newName, parentID, row); err != nil {
+		Error.Println(err)
+	}
+
+	return err
+}
+
+// deleteAffiliationGroup deletes the affiliation group name, which must have
+// neither children nor members.
+//
+func (ca *CA) deleteAffiliationGroup(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	Trace.Println("Deleting affiliation group " + name + ".")
+
+	var row int64
+	if err := ca.db.QueryRow("SELECT row FROM AffiliationGroups WHERE name=?", name).Scan(&row); err != nil {
+		return errors.New("Invalid affiliation group " + name)
+	}
+
+	var children int
+	if err := ca.db.QueryRow("SELECT count(row) FROM AffiliationGroups WHERE parent=?", row).Scan(&children); err != nil {
+		return err
+	}
+	if children > 0 {
+		return errors.New("Affiliation group " + name + " has child groups")
+	}
+
+	members, err := ca.countAffiliationMembers([]string{name})
+	if err != nil {
+		return err
+	}
+	if members > 0 {
+		return errors.New("Affiliation group " + name + " has members")
+	}
+
+	if _, err = ca.db.Exec("DELETE FROM AffiliationGroups WHERE row=?", row); err != nil {
+		Error.Println(err)
+	}
+
+	return err
+}
+
+// checkRegistrarRequest checks that id is a registrar, allowed to register at
+// least one role, and that sig, over msg, was made with its enrollment signing
+// key.
+//
+func (eca *ECA) checkRegistrarRequest(id string, msg []byte, sig *pb.Signature) error {
+	var metadata string
+	if err := eca.db.QueryRow("SELECT metadata FROM Users WHERE id=?", id).Scan(&metadata); err != nil {
+		return errors.New("Access denied.")
+	}
+	if mm, err := newMemberMetadata(metadata); err != nil || mm == nil || len(mm.Registrar.Roles) == 0 {
+		return errors.New("member " + id + " is not a registrar")
+	}
+
+	return eca.verifyEnrollmentSignature(id, msg, sig)
+}
+
+// refreshPreKeys recomputes the TCA pre-keys after the affiliation groups
+// changed.
+//
+func (eca *ECA) refreshPreKeys() {
+	if eca.tca == nil {
+		return
+	}
+	if err := eca.tca.initializePreKeyTree(); err != nil {
+		Error.Printf("Failed refreshing TCA pre-keys: %s\n", err)
+	}
+	// the pre-generated TCerts embed the enrollment IDs encrypted under the previous ones
+	eca.tca.pools.discardAll()
+}
+
+// createAffiliation registers the affiliation group name under parent on
+// behalf of registrar.
+//
+func (eca *ECA) createAffiliation(registrar, name, parent string) error {
+	if err := checkAffiliationName(name); err != nil {
+		return err
+	}
+	if parent == "" {
+		if scope, err := eca.readMemberAffiliation(registrar); err != nil || scope != "" {
+			return errors.New("member " + registrar + " may not create top-level affiliation groups")
+		}
+	} else if err := eca.checkAffiliationScope(registrar, parent); err != nil {
+		return err
+	}
+
+	if err := eca.registerAffiliationGroup(name, parent); err != nil {
+		return err
+	}
+	eca.refreshPreKeys()
+
+	return nil
+}
+
+// readAffiliations returns the affiliation groups within the subtree of
+// registrar.
+//
+func (eca *ECA) readAffiliations(registrar string) ([]*pb.Affiliation, error) {
+	groups, err := eca.readAffiliationGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var affiliations []*pb.Affiliation
+	for _, group := range groups {
+		if eca.checkAffiliationScope(registrar, group.name) != nil {
+			continue
+		}
+		affiliation := &pb.Affiliation{Name: group.name}
+		if group.parent != nil {
+			affiliation.Parent = group.parent.name
+		}
+		affiliations = append(affiliations, affiliation)
+	}
+
+	return affiliations, nil
+}
+
+// updateAffiliation renames and moves the affiliation group name on behalf of
+// registrar.
+//
+func (eca *ECA) updateAffiliation(registrar, name, newName, newParent string) error {
+	if err := eca.checkAffiliationManagement(registrar, name); err != nil {
+		return err
+	}
+	if newParent == "" {
+		if scope, err := eca.readMemberAffiliation(registrar); err != nil || scope != "" {
+			return errors.New("member " + registrar + " may not create top-level affiliation groups")
+		}
+	} else if err := eca.checkAffiliationScope(registrar, newParent); err != nil {
+		return err
+	}
+
+	if err := eca.updateAffiliationGroup(name, newName, newParent); err != nil {
+		return err
+	}
+	eca.refreshPreKeys()
+
+	return nil
+}
+
+// deleteAffiliation deletes the affiliation group name on behalf of registrar.
+//
+func (eca *ECA) deleteAffiliation(registrar, name string) error {
+	if err := eca.checkAffiliationManagement(registrar, name); err != nil {
+		return err
+	}
+
+	if err := eca.deleteAffiliationGroup(name); err != nil {
+		return err
+	}
+	eca.refreshPreKeys()
+
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"google/protobuf"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// maxIssuedCertificates is the largest number of issued certificates returned by a query.
+const maxIssuedCertificates = 1000
+
+func initializeAuditTables(db *Database) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS IssuedCertificates (row INTEGER PRIMARY KEY, serial VARCHAR(64), id VARCHAR(64), subject VARCHAR(256), type INTEGER, notBefore INTEGER, notAfter INTEGER, issuedAt INTEGER, registrar VARCHAR(64))"); err != nil {
+		return err
+	}
+
+	// databases created before the registrars were recorded lack the column
+	if _, err := db.Exec("SELECT registrar FROM Users WHERE 1=0"); err != nil {
+		if _, err = db.Exec("ALTER TABLE Users ADD COLUMN registrar VARCHAR(64)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRegistrar returns the registrar that registered id, or "" if the identity was
+// registered from the configuration.
+//
+func (eca *ECA) readRegistrar(id string) string {
+	if eca == nil {
+		return ""
+	}
+
+	var registrar []byte
+	eca.db.QueryRow("SELECT registrar FROM Users WHERE id=?", id).Scan(&registrar)
+
+	return string(registrar)
+}
+
+// recordIssuedCertificates records the certificates raws, of type typ, issued to id,
+// which was registered by registrar. The certificates are recorded at once.
+//
+func (ca *CA) recordIssuedCertificates(id string, typ pb.CertificateType, registrar string, raws ...[]byte) error {
+	issuedAt := time.Now().Unix()
+
+	tx, err := ca.db.Begin()
+	if err != nil {
+		Error.Println(err)
+		return err
+	}
+
+	serials := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		serials = append(serials, cert.SerialNumber.String())
+
+		_, err = tx.Exec("INSERT INTO IssuedCertificates (serial, id, subject, type, notBefore, notAfter, issuedAt, registrar) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			cert.SerialNumber.String(), id, cert.Subject.CommonName, typ, cert.NotBefore.Unix(), cert.NotAfter.Unix(), issuedAt, registrar)
+		if err != nil {
+			Error.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	countIssuedCertificates(typ, len(raws))
+	notifyWebhooks(webhookCertificateIssued, map[string]interface{}{
+		"ca": ca.db.name, "id": id, "type": strings.ToLower(typ.String()), "serials": serials, "registrar": registrar,
+	})
+	return nil
+}
+
+// recordCertificatePair records the enrollment certificate pair sraw and eraw issued to id.
+//
+func (eca *ECA) recordCertificatePair(id string, sraw, eraw []byte) error {
+	registrar := eca.readRegistrar(id)
+	if err := eca.recordIssuedCertificates(id, pb.CertificateType_ECERT_SIGN, registrar, sraw); err != nil {
+		return err
+	}
+	return eca.recordIssuedCertificates(id, pb.CertificateType_ECERT_ENC, registrar, eraw)
+}
+
+// readIssuedCertificates returns the most recently issued certificates matching the
+// filter of the request in, up to its limit.
+//
+func (ca *CA) readIssuedCertificates(in *pb.IssuedCertificatesReq) ([]*pb.IssuedCertificate, error) {
+	var conds []string
+	var args []interface{}
+
+	if in.Subject != nil && in.Subject.Id != "" {
+		conds = append(conds, "id=?")
+		args = append(args, in.Subject.Id)
+	}
+	if len(in.Types) > 0 {
+		marks := make([]string, len(in.Types))
+		for i, typ := range in.Types {
+			marks[i] = "?"
+			args = append(args, typ)
+		}
+		conds = append(conds, "type IN ("+strings.Join(marks, ", ")+")")
+	}
+	if in.IssuedAfter != nil && in.IssuedAfter.Seconds > 0 {
+		conds = append(conds, "issuedAt>=?")
+		args = append(args, in.IssuedAfter.Seconds)
+	}
+	if in.IssuedBefore != nil && in.IssuedBefore.Seconds > 0 {
+		conds = append(conds, "issuedAt<?")
+		args = append(args, in.IssuedBefore.Seconds)
+	}
+	if in.Unexpired {
+		conds = append(conds, "notAfter>?")
+		args = append(args, time.Now().Unix())
+	}
+
+	limit := int(in.Limit)
+	if limit == 0 || limit > maxIssuedCertificates {
+		limit = maxIssuedCertificates
+	}
+
+	query := "SELECT serial, id, subject, type, notBefore, notAfter, issuedAt, registrar FROM IssuedCertificates"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY issuedAt DESC, row DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := ca.db.Query(query, args...)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := []*pb.IssuedCertificate{}
+	for rows.Next() {
+		var serial, id, subject, registrar string
+		var typ int32
+		var notBefore, notAfter, issuedAt int64
+		if err = rows.Scan(&serial, &id, &subject, &typ, &notBefore, &notAfter, &issuedAt, &registrar); err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, &pb.IssuedCertificate{
+			Serial:    serial,
+			Id:        &pb.Identity{Id: id},
+			Subject:   subject,
+			Type:      pb.CertificateType(typ),
+			NotBefore: &google_protobuf.Timestamp{Seconds: notBefore},
+			NotAfter:  &google_protobuf.Timestamp{Seconds: notAfter},
+			IssuedAt:  &google_protobuf.Timestamp{Seconds: issuedAt},
+			Registrar: registrar,
+		})
+	}
+	return certs, rows.Err()
+}
+
+// readIssuedCertificateSet authenticates the auditor making the request in, through
+// the ECA eca, and returns the certificates issued by ca matching its filter.
+//
+func (ca *CA) readIssuedCertificateSet(eca *ECA, in *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+	if in.Id == nil {
+		return nil, errors.New("Identity is required.")
+	}
+	if eca == nil {
+		return nil, errors.New("Access denied.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := eca.checkAuditorSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	certs, err := ca.readIssuedCertificates(in)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.IssuedCertificateSet{Certs: certs}, nil
+}
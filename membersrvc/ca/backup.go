@@ -0,0 +1,500 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// A backup holds the rows of the databases of the CAs and the files of their state
+// directory: the keys, certificates and secrets. Each database is read within a
+// transaction, hence consistent; the backup of the whole CA is consistent when taken
+// while it serves no requests. The backup is encrypted with AES-GCM, under a key
+// derived from a passphrase.
+
+const (
+	backupMagic      = "MSRVCBAK"
+	backupVersion    = 1
+	backupSaltSize   = 16
+	backupIterations = 100000
+)
+
+// backupCAs are the CAs whose databases are backed up, with the initializers of their tables.
+var backupCAs = []struct {
+	name       string
+	initTables TableInitializer
+}{
+	{"eca", initializeECATables},
+	{"tca", initializeTCATables},
+	{"tlsca", initializeTLSCATables},
+	{"aca", initializeACATables},
+}
+
+type backupArchive struct {
+	Version   int
+	CreatedAt int64
+	Databases []backupDatabase
+	Files     []backupFile
+}
+
+type backupDatabase struct {
+	Name   string
+	Tables []backupTable
+}
+
+type backupTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+type backupFile struct {
+	Name string
+	Mode os.FileMode
+	Data []byte
+}
+
+func init() {
+	// the values of DATETIME columns
+	gob.Register(time.Time{})
+}
+
+// caStatePath returns the directory where the CAs keep their state.
+//
+func caStatePath() string {
+	return viper.GetString("server.rootpath") + "/" + viper.GetString("server.cadir")
+}
+
+// Backup writes an encrypted backup of the databases and the state directory of the CAs
+// to w. The backup is encrypted under passphrase.
+//
+func Backup(w io.Writer, passphrase []byte) error {
+	path := caStatePath()
+	archive := &backupArchive{Version: backupVersion, CreatedAt: time.Now().Unix()}
+	kind := viper.GetString("server.database.type")
+
+	for _, ca := range backupCAs {
+		// skip the CAs never started, rather than creating their sqlite files
+		if kind == "" || kind == "sqlite3" {
+			if _, err := os.Stat(path + "/" + ca.name + ".db"); err != nil {
+				continue
+			}
+		}
+
+		db, err := openDatabase(path, ca.name)
+		if err != nil {
+			return err
+		}
+		tables, err := backupDatabaseTables(db)
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("Failed backing up the database of the %s: %s", ca.name, err)
+		}
+		archive.Databases = append(archive.Databases, backupDatabase{Name: ca.name, Tables: tables})
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || isDatabaseFile(entry.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return err
+		}
+		archive.Files = append(archive.Files, backupFile{Name: entry.Name(), Mode: entry.Mode().Perm(), Data: data})
+	}
+	if isHSMEnabled() {
+		Warning.Println("The signing keys of the CAs are held by the PKCS#11 token and are not part of the backup.")
+	}
+
+	var plain bytes.Buffer
+	if err = gob.NewEncoder(&plain).Encode(archive); err != nil {
+		return err
+	}
+	sealed, err := sealBackup(plain.Bytes(), passphrase)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(sealed)
+	return err
+}
+
+// Restore restores the databases and the state directory of the CAs from the backup
+// read from r, encrypted under passphrase. The CAs must not be running: the rows of
+// their databases are replaced with the ones of the backup, then their files.
+//
+func Restore(r io.Reader, passphrase []byte) error {
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	plain, err := openBackup(sealed, passphrase)
+	if err != nil {
+		return err
+	}
+	archive := new(backupArchive)
+	if err = gob.NewDecoder(bytes.NewReader(plain)).Decode(archive); err != nil {
+		return err
+	}
+	if archive.Version != backupVersion {
+		return fmt.Errorf("Unsupported backup version %d.", archive.Version)
+	}
+
+	path := caStatePath()
+	if err = os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	// the files replace the current ones once the databases are restored
+	staged, err := stageBackupFiles(path, archive.Files)
+	defer removeFiles(staged)
+	if err != nil {
+		return err
+	}
+
+	for _, database := range archive.Databases {
+		initTables := findTableInitializer(database.Name)
+		if initTables == nil {
+			return fmt.Errorf("Invalid database %s in the backup.", database.Name)
+		}
+
+		db, err := openDatabase(path, database.Name)
+		if err != nil {
+			return err
+		}
+		if err = initTables(db); err == nil {
+			err = restoreDatabaseTables(db, database.Tables)
+		}
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("Failed restoring the database of the %s: %s", database.Name, err)
+		}
+	}
+
+	for i, file := range archive.Files {
+		if err = os.Rename(staged[i], filepath.Join(path, file.Name)); err != nil {
+			return err
+		}
+	}
+
+	Info.Printf("Restored the backup of %s.\n", time.Unix(archive.CreatedAt, 0).UTC().Format(time.RFC3339))
+	return nil
+}
+
+// stageBackupFiles writes files to temporary files of dir, and returns their names, those
+// written so far on failure.
+//
+func stageBackupFiles(dir string, files []backupFile) ([]string, error) {
+	var staged []string
+	for _, file := range files {
+		if file.Name != filepath.Base(file.Name) || isDatabaseFile(file.Name) {
+			return staged, fmt.Errorf("Invalid file %s in the backup.", file.Name)
+		}
+
+		f, err := ioutil.TempFile(dir, ".restore-"+file.Name)
+		if err != nil {
+			return staged, err
+		}
+		staged = append(staged, f.Name())
+		if _, err = f.Write(file.Data); err == nil {
+			err = f.Chmod(file.Mode)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return staged, err
+		}
+	}
+	return staged, nil
+}
+
+// removeFiles removes the files named, which may be gone already.
+//
+func removeFiles(names []string) {
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+func findTableInitializer(name string) TableInitializer {
+	for _, ca := range backupCAs {
+		if ca.name == name {
+			return ca.initTables
+		}
+	}
+	return nil
+}
+
+// isDatabaseFile returns true if name is an sqlite database of a CA, or one of its journals.
+//
+func isDatabaseFile(name string) bool {
+	for _, suffix := range []string{".db", ".db-journal", ".db-wal", ".db-shm"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// backupDatabaseTables reads all the rows of all the tables of db, within a transaction.
+//
+func backupDatabaseTables(db *Database) ([]backupTable, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names, err := listDatabaseTables(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []backupTable
+	for _, name := range names {
+		table, err := backupTableRows(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// listDatabaseTables returns the names of the tables of the database of tx.
+//
+func listDatabaseTables(tx *DatabaseTx) ([]string, error) {
+	rows, err := tx.Query(tx.dialect.tables)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// listTableColumns returns the names of the columns of the table name.
+//
+func listTableColumns(tx *DatabaseTx, name string) ([]string, error) {
+	rows, err := tx.Query("SELECT * FROM " + name + " WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows.Columns()
+}
+
+// checkBackupTable fails unless table is one of tables, and its columns are columns of it:
+// the names of the backup are built into the statements restoring the rows.
+//
+func checkBackupTable(tx *DatabaseTx, tables []string, table backupTable) error {
+	if !containsName(tables, table.Name) {
+		return fmt.Errorf("Invalid table %q in the backup.", table.Name)
+	}
+
+	if len(table.Columns) == 0 && len(table.Rows) > 0 {
+		return fmt.Errorf("No columns for the rows of table %s in the backup.", table.Name)
+	}
+	columns, err := listTableColumns(tx, table.Name)
+	if err != nil {
+		return err
+	}
+	for _, column := range table.Columns {
+		if !containsName(columns, column) {
+			return fmt.Errorf("Invalid column %q of table %s in the backup.", column, table.Name)
+		}
+	}
+	return nil
+}
+
+// containsName returns true if names contains name, ignoring the case as SQL does.
+//
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func backupTableRows(tx *DatabaseTx, name string) (backupTable, error) {
+	table := backupTable{Name: name}
+
+	rows, err := tx.Query("SELECT * FROM " + name)
+	if err != nil {
+		return table, err
+	}
+	defer rows.Close()
+
+	if table.Columns, err = rows.Columns(); err != nil {
+		return table, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(table.Columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err = rows.Scan(ptrs...); err != nil {
+			return table, err
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, rows.Err()
+}
+
+// restoreDatabaseTables replaces the rows of the tables of db with the ones of tables,
+// within a transaction.
+//
+func restoreDatabaseTables(db *Database, tables []backupTable) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names, err := listDatabaseTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err = checkBackupTable(tx, names, table); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tables {
+		if _, err = tx.Exec("DELETE FROM " + table.Name); err != nil {
+			return err
+		}
+		if len(table.Rows) == 0 {
+			continue
+		}
+
+		insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (?%s)", table.Name,
+			strings.Join(table.Columns, ", "), strings.Repeat(", ?", len(table.Columns)-1))
+		for _, row := range table.Rows {
+			if _, err = tx.Exec(insert, row...); err != nil {
+				return err
+			}
+		}
+
+		if db.dialect.resetSequence != nil {
+			if _, err = tx.Tx.Exec(db.dialect.resetSequence(table.Name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func backupKey(passphrase, salt []byte) []byte {
+	return pbkdf2.Key(passphrase, salt, backupIterations, 32, sha256.New)
+}
+
+// sealBackup encrypts plain under passphrase. The result is the magic, the salt of the
+// key, the nonce and the ciphertext, authenticated along with the magic.
+//
+func sealBackup(plain, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("The passphrase of the backup is empty.")
+	}
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := newBackupAEAD(backupKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append([]byte(backupMagic), salt...)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plain, []byte(backupMagic)), nil
+}
+
+// openBackup decrypts a backup sealed under passphrase.
+//
+func openBackup(sealed, passphrase []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, []byte(backupMagic)) {
+		return nil, errors.New("Not a backup of the CA.")
+	}
+	sealed = sealed[len(backupMagic):]
+	if len(sealed) < backupSaltSize {
+		return nil, errors.New("Truncated backup.")
+	}
+
+	aead, err := newBackupAEAD(backupKey(passphrase, sealed[:backupSaltSize]))
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[backupSaltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("Truncated backup.")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(backupMagic))
+	if err != nil {
+		return nil, errors.New("Failed decrypting the backup, wrong passphrase or corrupted backup.")
+	}
+	return plain, nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
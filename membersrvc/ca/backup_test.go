@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useCADir makes the CAs keep their state in dir, and returns a function
+// restoring the previous directory and removing dir.
+func useCADir(dir string) func() {
+	previous := viper.GetString("server.cadir")
+	viper.Set("server.cadir", dir)
+
+	return func() {
+		viper.Set("server.cadir", previous)
+		os.RemoveAll(viper.GetString("server.rootpath") + "/" + dir)
+	}
+}
+
+func countUsers(t *testing.T, db *Database) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Users").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBackupRestore(t *testing.T) {
+	passphrase := []byte("backup passphrase")
+
+	var buf bytes.Buffer
+	if err := Backup(&buf, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openBackup(buf.Bytes(), []byte("wrong passphrase")); err == nil {
+		t.Fatal("The backup should not decrypt with a wrong passphrase")
+	}
+	if bytes.Contains(buf.Bytes(), []byte(testAdmin.enrollID)) {
+		t.Fatal("The backup should be encrypted")
+	}
+
+	priv, err := ioutil.ReadFile(eca.path + "/eca.priv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := countUsers(t, eca.db)
+
+	defer useCADir(".ca-restored")()
+	if err = Restore(bytes.NewReader(buf.Bytes()), passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := ioutil.ReadFile(caStatePath() + "/eca.priv")
+	if err != nil || !bytes.Equal(restored, priv) {
+		t.Fatal("The signing key of the ECA was not restored")
+	}
+	db, err := openDatabase(caStatePath(), "eca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := countUsers(t, db); n != users {
+		t.Fatalf("Restored %d users, expected %d", n, users)
+	}
+
+	// restoring again replaces the rows rather than adding to them
+	if err = Restore(bytes.NewReader(buf.Bytes()), passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if n := countUsers(t, db); n != users {
+		t.Fatalf("Restored %d users, expected %d", n, users)
+	}
+}
+
+func TestRestoreInvalidTables(t *testing.T) {
+	users := countUsers(t, eca.db)
+
+	invalid := []backupTable{
+		{Name: "Users; DROP TABLE Users", Columns: []string{"id"}},
+		{Name: "Users", Columns: []string{"id) VALUES ('x'); DROP TABLE Users; --"}},
+		{Name: "Users", Rows: [][]interface{}{{"x"}}},
+	}
+	for _, table := range invalid {
+		if err := restoreDatabaseTables(eca.db, []backupTable{table}); err == nil {
+			t.Fatalf("Restoring the table %q with the columns %q should fail", table.Name, table.Columns)
+		}
+	}
+	if n := countUsers(t, eca.db); n != users {
+		t.Fatalf("Found %d users after the failed restores, expected %d", n, users)
+	}
+}
+
+func TestRestoreFailureKeepsFiles(t *testing.T) {
+	passphrase := []byte("backup passphrase")
+	archive := &backupArchive{
+		Version:   backupVersion,
+		Databases: []backupDatabase{{Name: "eca", Tables: []backupTable{{Name: "Nonexistent", Columns: []string{"id"}}}}},
+		Files:     []backupFile{{Name: "eca.priv", Mode: 0600, Data: []byte("restored key")}},
+	}
+	var plain bytes.Buffer
+	if err := gob.NewEncoder(&plain).Encode(archive); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealBackup(plain.Bytes(), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer useCADir(".ca-failed-restore")()
+	if err = os.MkdirAll(caStatePath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(caStatePath()+"/eca.priv", []byte("current key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Restore(bytes.NewReader(sealed), passphrase); err == nil {
+		t.Fatal("Restoring an invalid table should fail")
+	}
+	if key, err := ioutil.ReadFile(caStatePath() + "/eca.priv"); err != nil || string(key) != "current key" {
+		t.Fatal("The files should not be replaced when the databases fail to restore")
+	}
+	entries, err := ioutil.ReadDir(caStatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".restore-") {
+			t.Fatalf("The staged file %s should be removed", entry.Name())
+		}
+	}
+}
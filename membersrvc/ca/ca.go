@@ -17,16 +17,19 @@ limitations under the License.
 package ca
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/asn1"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -36,19 +39,28 @@ import (
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 
-	_ "github.com/mattn/go-sqlite3" // This blank import is required to load sqlite3 driver
 	"github.com/spf13/viper"
 )
 
 // CA is the base certificate authority.
 type CA struct {
-	db *sql.DB
+	db *Database
 
 	path string
 
-	priv *ecdsa.PrivateKey
-	cert *x509.Certificate
-	raw  []byte
+	priv  crypto.Signer // *ecdsa.PrivateKey, or key held by the PKCS#11 token of the CAs
+	cert  *x509.Certificate
+	raw   []byte
+	chain [][]byte
+
+	crlMutex sync.RWMutex
+	crl      []byte
+	crlStop  chan struct{}
+
+	feedMutex      sync.Mutex
+	lastRevocation int64
+
+	profiles []*certificateProfile
 }
 
 // CertificateSpec defines the parameter used to create a new certificate.
@@ -61,6 +73,9 @@ type CertificateSpec struct {
 	NotBefore    *time.Time
 	NotAfter     *time.Time
 	ext          *[]pkix.Extension
+	profile      *certificateProfile
+	dnsNames     []string
+	ips          []net.IP
 }
 
 // AffiliationGroup struct
@@ -103,20 +118,36 @@ func NewDefaultPeriodCertificateSpecWithCommonName(id string, commonName string,
 	return NewCertificateSpec(id, commonName, serialNumber, pub, usage, &notBefore, &notAfter, opt...)
 }
 
-// NewDefaultCertificateSpec creates a new certificate spec with serialNumber = 1, notBefore a minute ago and not after 90 days from notBefore.
+// NewDefaultCertificateSpec creates a new certificate spec with a random serialNumber, notBefore a minute ago and not after 90 days from notBefore.
 //
 func NewDefaultCertificateSpec(id string, pub interface{}, usage x509.KeyUsage, opt ...pkix.Extension) *CertificateSpec {
-	serialNumber := big.NewInt(1)
+	serialNumber := newSerialNumber()
 	return NewDefaultPeriodCertificateSpec(id, serialNumber, pub, usage, opt...)
 }
 
-// NewDefaultCertificateSpecWithCommonName creates a new certificate spec with serialNumber = 1, notBefore a minute ago and not after 90 days from notBefore and a specific commonName.
+// NewDefaultCertificateSpecWithCommonName creates a new certificate spec with a random serialNumber, notBefore a minute ago and not after 90 days from notBefore and a specific commonName.
 //
 func NewDefaultCertificateSpecWithCommonName(id string, commonName string, pub interface{}, usage x509.KeyUsage, opt ...pkix.Extension) *CertificateSpec {
-	serialNumber := big.NewInt(1)
+	serialNumber := newSerialNumber()
 	return NewDefaultPeriodCertificateSpecWithCommonName(id, commonName, serialNumber, pub, usage, opt...)
 }
 
+// newSerialNumber returns a random positive serial number of 128 bits at most. Replicas
+// of a CA sharing a database allocate serial numbers this way without coordination.
+//
+func newSerialNumber() *big.Int {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	for {
+		serialNumber, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		if serialNumber.Sign() > 0 {
+			return serialNumber
+		}
+	}
+}
+
 // GetID returns the spec's ID field/value
 //
 func (spec *CertificateSpec) GetID() string {
@@ -190,25 +221,28 @@ func (spec *CertificateSpec) GetExtensions() *[]pkix.Extension {
 }
 
 // TableInitializer is a function type for table initialization
-type TableInitializer func(*sql.DB) error
+type TableInitializer func(*Database) error
 
-func initializeCommonTables(db *sql.DB) error {
+func initializeCommonTables(db *Database) error {
 	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Certificates (row INTEGER PRIMARY KEY, id VARCHAR(64), timestamp INTEGER, usage INTEGER, cert BLOB, hash BLOB, kdfkey BLOB)"); err != nil {
 		return err
 	}
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Users (row INTEGER PRIMARY KEY, id VARCHAR(64), enrollmentId VARCHAR(100), role INTEGER, metadata VARCHAR(256), token BLOB, state INTEGER, key BLOB)"); err != nil {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Users (row INTEGER PRIMARY KEY, id VARCHAR(64) UNIQUE, enrollmentId VARCHAR(100), role INTEGER, metadata VARCHAR(256), token BLOB, state INTEGER, key BLOB, registrar VARCHAR(64), tokenExpiry INTEGER DEFAULT 0, tokenAttempts INTEGER DEFAULT 0)"); err != nil {
 		return err
 	}
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS AffiliationGroups (row INTEGER PRIMARY KEY, name VARCHAR(64), parent INTEGER, FOREIGN KEY(parent) REFERENCES AffiliationGroups(row))"); err != nil {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS Revocations (row INTEGER PRIMARY KEY, serial VARCHAR(64) UNIQUE, revokedAt INTEGER, reason INTEGER, invalidAt INTEGER)"); err != nil {
 		return err
 	}
-	return nil
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS AffiliationGroups (row INTEGER PRIMARY KEY, name VARCHAR(64) UNIQUE, parent INTEGER)"); err != nil {
+		return err
+	}
+	return initializeAuditTables(db)
 }
 
 // NewCA sets up a new CA.
 func NewCA(name string, initTables TableInitializer) *CA {
 	ca := new(CA)
-	ca.path = viper.GetString("server.rootpath") + "/" + viper.GetString("server.cadir")
+	ca.path = caStatePath()
 
 	if _, err := os.Stat(ca.path); err != nil {
 		Info.Println("Fresh start; creating databases, key pairs, and certificates.")
@@ -219,31 +253,45 @@ func NewCA(name string, initTables TableInitializer) *CA {
 	}
 
 	// open or create certificate database
-	db, err := sql.Open("sqlite3", ca.path+"/"+name+".db")
+	db, err := openDatabase(ca.path, name)
 	if err != nil {
 		Panic.Panicln(err)
 	}
 
-	if err = db.Ping(); err != nil {
+	if err = initTables(db); err != nil {
 		Panic.Panicln(err)
 	}
+	ca.db = db
 
-	if err = initTables(db); err != nil {
+	if ca.profiles, err = readCertificateProfiles(); err != nil {
 		Panic.Panicln(err)
 	}
-	ca.db = db
 
-	// read or create signing key pair
-	priv, err := ca.readCAPrivateKey(name)
-	if err != nil {
-		priv = ca.createCAKeyPair(name)
+	// read the signing key pair and certificate issued by an external CA, if any
+	if viper.GetString("pki."+name+".cert.file") != "" {
+		if err = ca.readExternalCA(name); err != nil {
+			Panic.Panicln(err)
+		}
+		return ca
+	}
+
+	// read or create signing key pair, in the PKCS#11 token if enabled
+	if isHSMEnabled() {
+		if ca.priv, err = readHSMKey(name, true); err != nil {
+			Panic.Panicln(err)
+		}
+	} else {
+		priv, err := ca.readCAPrivateKey(name)
+		if err != nil {
+			priv = ca.createCAKeyPair(name)
+		}
+		ca.priv = priv
 	}
-	ca.priv = priv
 
 	// read CA certificate, or create a self-signed CA certificate
 	raw, err := ca.readCACertificate(name)
 	if err != nil {
-		raw = ca.createCACertificate(name, &ca.priv.PublicKey)
+		raw = ca.createCACertificate(name, ca.priv.Public().(*ecdsa.PublicKey))
 	}
 	cert, err := x509.ParseCertificate(raw)
 	if err != nil {
@@ -252,12 +300,14 @@ func NewCA(name string, initTables TableInitializer) *CA {
 
 	ca.raw = raw
 	ca.cert = cert
+	ca.chain = [][]byte{raw}
 
 	return ca
 }
 
 // Close closes down the CA.
 func (ca *CA) Close() {
+	ca.stopCRLPublisher()
 	ca.db.Close()
 }
 
@@ -309,10 +359,25 @@ func (ca *CA) readCAPrivateKey(name string) (*ecdsa.PrivateKey, error) {
 	return x509.ParseECPrivateKey(block.Bytes)
 }
 
+// signDirect hashes and signs msg with the signing key of the CA, like primitives.ECDSASignDirect.
+//
+func (ca *CA) signDirect(msg []byte) (*big.Int, *big.Int, error) {
+	raw, err := ca.priv.Sign(rand.Reader, primitives.Hash(msg), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sig primitives.ECDSASignature
+	if _, err = asn1.Unmarshal(raw, &sig); err != nil {
+		return nil, nil, err
+	}
+	return sig.R, sig.S, nil
+}
+
 func (ca *CA) createCACertificate(name string, pub *ecdsa.PublicKey) []byte {
 	Trace.Println("Creating CA certificate.")
 
-	raw, err := ca.newCertificate(name, pub, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign, nil)
+	raw, err := ca.newCertificate(name, pub, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign|x509.KeyUsageCRLSign, nil)
 	if err != nil {
 		Panic.Panicln(err)
 	}
@@ -411,6 +476,11 @@ func (ca *CA) newCertificateFromSpec(spec *CertificateSpec) ([]byte, error) {
 		tmpl.Extensions = *spec.GetExtensions()
 		tmpl.ExtraExtensions = *spec.GetExtensions()
 	}
+	tmpl.DNSNames = spec.dnsNames
+	tmpl.IPAddresses = spec.ips
+	if spec.profile != nil {
+		spec.profile.apply(&tmpl, spec.GetID())
+	}
 	if isCA {
 		parent = &tmpl
 	}
@@ -558,22 +628,29 @@ func (ca *CA) registerUser(id, affiliation, affiliationRole string, role pb.Role
 		if err != nil {
 			return "", err
 		}
+		// and to register it within the subtree of its own affiliation group
+		if ca.requireAffiliation(role) {
+			err = ca.checkAffiliationScope(registrar, affiliation)
+			if err != nil {
+				return "", err
+			}
+		}
 	}
 
 	enrollID, err = ca.validateAndGenerateEnrollID(id, affiliation, affiliationRole, role)
 	if err != nil {
 		return "", err
 	}
-	tok, err = ca.registerUserWithEnrollID(id, enrollID, role, memberMetadata, opt...)
+	tok, err = ca.registerUserWithEnrollID(id, enrollID, role, registrar, memberMetadata, opt...)
 	if err != nil {
 		return "", err
 	}
 	return tok, nil
 }
 
-// registerUserWithEnrollID registers a new user and its enrollmentID, role and state
+// registerUserWithEnrollID registers a new user and its enrollmentID, role, registrar and state
 //
-func (ca *CA) registerUserWithEnrollID(id string, enrollID string, role pb.Role, memberMetadata string, opt ...string) (string, error) {
+func (ca *CA) registerUserWithEnrollID(id string, enrollID string, role pb.Role, registrar, memberMetadata string, opt ...string) (string, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -593,13 +670,35 @@ func (ca *CA) registerUserWithEnrollID(id string, enrollID string, role pb.Role,
 		return "", errors.New("User is already registered")
 	}
 
-	_, err = ca.db.Exec("INSERT INTO Users (id, enrollmentId, token, role, metadata, state) VALUES (?, ?, ?, ?, ?, ?)", id, enrollID, tok, role, memberMetadata, 0)
+	_, err = ca.db.Exec("INSERT INTO Users (id, enrollmentId, token, role, metadata, state, registrar, tokenExpiry, tokenAttempts) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, enrollID, tok, role, memberMetadata, 0, registrar, secretExpiry(), 0)
 
 	if err != nil {
 		Error.Println(err)
+		return "", err
 	}
 
-	return tok, err
+	notifyWebhooks(webhookUserRegistered, map[string]interface{}{"id": id, "enrollmentId": enrollID, "role": roleStr, "registrar": registrar})
+	return tok, nil
+}
+
+// transitionUser executes query, an update of a user conditioned on its current state. It
+// fails if nothing was updated: a concurrent request, possibly served by another replica
+// of the CA sharing the database, changed the state of the user first.
+//
+func (ca *CA) transitionUser(query string, args ...interface{}) error {
+	res, err := ca.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("Identity state changed concurrently.")
+	}
+	return nil
 }
 
 // registerAffiliationGroup registers a new affiliation group
@@ -17,12 +17,11 @@ limitations under the License.
 package ca
 
 import (
+	"crypto/ecdsa"
 	"io/ioutil"
 	"os"
 	"testing"
 
-	"database/sql"
-
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/spf13/viper"
@@ -92,7 +91,35 @@ func TestNewCA(t *testing.T) {
 
 }
 
+func TestNewSerialNumber(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		serialNumber := newSerialNumber()
+		if serialNumber.Sign() <= 0 || serialNumber.BitLen() > 128 {
+			t.Fatalf("Invalid serial number %s", serialNumber)
+		}
+		if seen[serialNumber.String()] {
+			t.Fatalf("Serial number %s allocated twice", serialNumber)
+		}
+		seen[serialNumber.String()] = true
+	}
+}
+
+func TestSignDirect(t *testing.T) {
+	msg := []byte("request to sign")
+	r, s, err := eca.signDirect(msg)
+	if err != nil {
+		t.Fatalf("Failed signing [%s]", err)
+	}
+	if !ecdsa.Verify(eca.priv.Public().(*ecdsa.PublicKey), primitives.Hash(msg), r, s) {
+		t.Fatal("The signature of the CA does not verify")
+	}
+	if !ecdsa.Verify(eca.cert.PublicKey.(*ecdsa.PublicKey), primitives.Hash(msg), r, s) {
+		t.Fatal("The signature of the CA does not verify against its certificate")
+	}
+}
+
 // Empty initializer for CA
-func initializeTables(db *sql.DB) error {
+func initializeTables(db *Database) error {
 	return nil
 }
@@ -0,0 +1,188 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+)
+
+// A CA signs either with the self-signed certificate it creates on its first
+// start, or with a certificate issued by an external, e.g. enterprise, CA. In
+// the latter case it operates as an intermediate of that CA: its certificate
+// and signing key are read from pki.<name>.cert.file and pki.<name>.key.file,
+// and the certificates of its issuers, up to the root, from the rest of the
+// certificate file or from pki.<name>.chain.file. The chain is served to the
+// enrollees and validators by the ReadCACertificateChain calls and by the
+// REST facade, which also serves the chains of all the CAs as a single PEM
+// bundle along with its SHA-256 hash, so that its recipients can check it
+// against the hash published by the operators of the CAs.
+
+// readExternalCA reads the signing key and certificate, and the chain, of a CA
+// operating under an external CA.
+//
+func (ca *CA) readExternalCA(name string) error {
+	Trace.Println("Reading externally issued CA certificate.")
+
+	key := "pki." + name
+	certs, err := readPEMCertificates(viper.GetString(key + ".cert.file"))
+	if err != nil {
+		return err
+	}
+	if file := viper.GetString(key + ".chain.file"); file != "" {
+		issuers, err := readPEMCertificates(file)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, issuers...)
+	}
+
+	// the signing key is in the key file, or was imported into the PKCS#11 token under the name of the CA
+	var priv crypto.Signer
+	if isHSMEnabled() {
+		priv, err = readHSMKey(name, false)
+	} else {
+		priv, err = readPEMPrivateKey(viper.GetString(key + ".key.file"))
+	}
+	if err != nil {
+		return err
+	}
+
+	cert := certs[0]
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("The certificate of the %s is not a CA certificate.", name)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	keyPub, _ := priv.Public().(*ecdsa.PublicKey)
+	if !ok || keyPub == nil || pub.X.Cmp(keyPub.X) != 0 || pub.Y.Cmp(keyPub.Y) != 0 {
+		return fmt.Errorf("The signing key of the %s does not match its certificate.", name)
+	}
+
+	chain := [][]byte{cert.Raw}
+	for i := 1; i < len(certs); i++ {
+		if err = certs[i-1].CheckSignatureFrom(certs[i]); err != nil {
+			return fmt.Errorf("Broken certificate chain of the %s: %s", name, err)
+		}
+		chain = append(chain, certs[i].Raw)
+	}
+	if root := certs[len(certs)-1]; root.CheckSignatureFrom(root) != nil {
+		Warning.Printf("The certificate chain of the %s does not end with a root certificate.\n", name)
+	}
+
+	ca.priv = priv
+	ca.cert = cert
+	ca.raw = cert.Raw
+	ca.chain = chain
+
+	return nil
+}
+
+// readRootCertificate returns the root certificate of the chain of the CA,
+// DER encoded. This is the certificate of the CA if it is self-signed.
+//
+func (ca *CA) readRootCertificate() []byte {
+	return ca.chain[len(ca.chain)-1]
+}
+
+// caBundle returns the PEM encoded certificate chains of cas, in order and each
+// certificate once, and the hexadecimal SHA-256 hash of the bundle.
+//
+func caBundle(cas ...*CA) ([]byte, string) {
+	var bundle bytes.Buffer
+	seen := make(map[string]bool)
+	for _, ca := range cas {
+		for _, raw := range ca.chain {
+			if seen[string(raw)] {
+				continue
+			}
+			seen[string(raw)] = true
+			pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: raw})
+		}
+	}
+
+	hash := sha256.Sum256(bundle.Bytes())
+	return bundle.Bytes(), hex.EncodeToString(hash[:])
+}
+
+func readPEMCertificates(file string) ([]*x509.Certificate, error) {
+	cooked, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, cooked = pem.Decode(cooked)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate found in " + file)
+	}
+
+	return certs, nil
+}
+
+func readPEMPrivateKey(file string) (*ecdsa.PrivateKey, error) {
+	cooked, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// skip the curve parameters that may precede the key
+	block, rest := pem.Decode(cooked)
+	for block != nil && block.Type == "EC PARAMETERS" {
+		block, rest = pem.Decode(rest)
+	}
+	if block == nil {
+		return nil, errors.New("No private key found in " + file)
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("The private key in " + file + " is not an ECDSA key.")
+		}
+		return priv, nil
+	}
+
+	return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/spf13/viper"
+)
+
+const extCAName = "TestExtCA"
+
+// newExternalCA issues a CA certificate for pub, signed by parent with key
+// priv, or self-signed if parent is nil.
+func newExternalCA(t *testing.T, cn string, pub *ecdsa.PublicKey, parent *x509.Certificate, priv *ecdsa.PrivateKey) *x509.Certificate {
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA: true,
+	}
+	if parent == nil {
+		parent = tmpl
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeExternalCA writes an enterprise root and an intermediate CA issued by
+// it, with its key, to dir and configures them for extCAName. It returns the
+// root and intermediate certificates.
+func writeExternalCA(t *testing.T, dir string) (*x509.Certificate, *x509.Certificate) {
+	rootPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newExternalCA(t, "Enterprise Root", &rootPriv.PublicKey, nil, rootPriv)
+
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newExternalCA(t, "Enterprise Membership Services", &priv.PublicKey, root, rootPriv)
+
+	rawKey, _ := x509.MarshalECPrivateKey(priv)
+	ioutil.WriteFile(dir+"/ca.key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	ioutil.WriteFile(dir+"/ca.cert", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+	ioutil.WriteFile(dir+"/root.cert", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0644)
+
+	viper.Set("pki."+extCAName+".cert.file", dir+"/ca.cert")
+	viper.Set("pki."+extCAName+".key.file", dir+"/ca.key")
+	viper.Set("pki."+extCAName+".chain.file", dir+"/root.cert")
+
+	return root, cert
+}
+
+func TestNewCAExternallyIssued(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, cert := writeExternalCA(t, dir)
+	defer viper.Set("pki."+extCAName+".cert.file", "")
+
+	extCA := NewCA(extCAName, initializeTables)
+	defer extCA.Close()
+
+	if !bytes.Equal(extCA.raw, cert.Raw) {
+		t.Fatal("The CA does not sign with the externally issued certificate")
+	}
+	if len(extCA.chain) != 2 || !bytes.Equal(extCA.readRootCertificate(), root.Raw) {
+		t.Fatalf("Expected a chain up to the enterprise root, got %d certificates", len(extCA.chain))
+	}
+
+	// certificates issued by the CA verify up to the enterprise root
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := extCA.newCertificate("extUser", &priv.PublicKey, x509.KeyUsageDigitalSignature, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(cert)
+	if _, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatalf("Certificate does not verify up to the enterprise root: [%s]", err)
+	}
+}
+
+func TestReadExternalCABadConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeExternalCA(t, dir)
+	defer viper.Set("pki."+extCAName+".cert.file", "")
+
+	// the key of another CA
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, _ := x509.MarshalECPrivateKey(priv)
+	ioutil.WriteFile(dir+"/other.key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	viper.Set("pki."+extCAName+".key.file", dir+"/other.key")
+	if err = new(CA).readExternalCA(extCAName); err == nil {
+		t.Fatal("A signing key not matching the CA certificate should be rejected")
+	}
+
+	// a chain that does not link to the CA certificate
+	viper.Set("pki."+extCAName+".key.file", dir+"/ca.key")
+	viper.Set("pki."+extCAName+".chain.file", dir+"/ca.cert")
+	if err = new(CA).readExternalCA(extCAName); err == nil {
+		t.Fatal("A broken certificate chain should be rejected")
+	}
+}
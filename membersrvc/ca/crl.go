@@ -0,0 +1,268 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"google/protobuf"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// Revoked certificates are recorded, by serial number, in the Revocations
+// table of the CA that issued them, with the reason of the revocation and the
+// time since when they are invalid. The ECA and the TCA publish them in a CRL
+// they sign, regenerated on each revocation and every server.crl.interval,
+// and valid for two intervals so that peers polling it never hold an expired
+// one. The current CRL is served by the ReadCRL call of the public gRPC
+// services and by the REST facade.
+
+var (
+	// oidReasonCode is the ASN1 object identifier of the CRL entry reason code extension.
+	oidReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+	// oidInvalidityDate is the ASN1 object identifier of the CRL entry invalidity date extension.
+	oidInvalidityDate = asn1.ObjectIdentifier{2, 5, 29, 24}
+)
+
+// userStateRevoked is the state of the users whose identity has been revoked.
+// They can no longer enroll nor obtain TCerts.
+const userStateRevoked = 3
+
+// revokeCertificate revokes raw, a certificate issued by the CA. Unless
+// admin is set, raw must have been issued to id.
+//
+func (ca *CA) revokeCertificate(id string, raw []byte, admin bool, reason pb.RevocationReason, invalidAt time.Time) error {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return err
+	}
+	if err = cert.CheckSignatureFrom(ca.cert); err != nil {
+		return errors.New("Certificate not issued by this CA.")
+	}
+
+	if !admin {
+		hash := primitives.NewHash()
+		hash.Write(raw)
+
+		var owner string
+		if err = ca.db.QueryRow("SELECT id FROM Certificates WHERE hash=?", hash.Sum(nil)).Scan(&owner); err != nil || owner != id {
+			return errors.New("Access denied.")
+		}
+	}
+
+	Trace.Printf("Revoking certificate %s of %s.\n", cert.SerialNumber, id)
+
+	return ca.revokeSerials([]string{cert.SerialNumber.String()}, reason, invalidAt)
+}
+
+// revokeSerials revokes the certificates with the given serial numbers, for reason
+// and as of invalidAt, and publishes a new CRL. Certificates already revoked keep
+// their first revocation.
+//
+func (ca *CA) revokeSerials(serials []string, reason pb.RevocationReason, invalidAt time.Time) error {
+	tx, err := ca.db.Begin()
+	if err != nil {
+		Error.Println(err)
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, serial := range serials {
+		if _, err = tx.Exec("INSERT OR IGNORE INTO Revocations (serial, revokedAt, reason, invalidAt) VALUES (?, ?, ?, ?)", serial, now, int(reason), invalidAt.Unix()); err != nil {
+			Error.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		Error.Println(err)
+		return err
+	}
+	if len(serials) > 0 {
+		notifyWebhooks(webhookCertificateRevoked, map[string]interface{}{
+			"ca": ca.db.name, "serials": serials, "reason": strings.ToLower(reason.String()), "invalidAt": invalidAt.UTC(),
+		})
+	}
+
+	if len(serials) == 0 {
+		_, err = ca.generateCRL()
+		return err
+	}
+	return ca.publishNewRevocations()
+}
+
+// isCertificateRevoked returns true if cert, issued by the CA, has been revoked.
+//
+func (ca *CA) isCertificateRevoked(cert *x509.Certificate) bool {
+	var count int
+	if err := ca.db.QueryRow("SELECT count(row) FROM Revocations WHERE serial=?", cert.SerialNumber.String()).Scan(&count); err != nil {
+		Error.Println(err)
+		return true
+	}
+
+	return count > 0
+}
+
+// generateCRL signs a new CRL listing all the revoked certificates and makes it the current one.
+//
+func (ca *CA) generateCRL() ([]byte, error) {
+	ca.crlMutex.Lock()
+	defer ca.crlMutex.Unlock()
+
+	rows, err := ca.db.Query("SELECT serial, revokedAt, reason, invalidAt FROM Revocations ORDER BY row")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revoked []pkix.RevokedCertificate
+	for rows.Next() {
+		var serial string
+		var revokedAt, reason, invalidAt int64
+		if err = rows.Scan(&serial, &revokedAt, &reason, &invalidAt); err != nil {
+			return nil, err
+		}
+		sn, ok := new(big.Int).SetString(serial, 10)
+		if !ok {
+			return nil, errors.New("Invalid serial number " + serial)
+		}
+
+		entry := pkix.RevokedCertificate{SerialNumber: sn, RevocationTime: time.Unix(revokedAt, 0).UTC()}
+		if reason != int64(pb.RevocationReason_UNSPECIFIED) {
+			value, _ := asn1.Marshal(asn1.Enumerated(reason))
+			entry.Extensions = append(entry.Extensions, pkix.Extension{Id: oidReasonCode, Value: value})
+		}
+		if invalidAt < revokedAt {
+			value, _ := asn1.MarshalWithParams(time.Unix(invalidAt, 0).UTC(), "generalized")
+			entry.Extensions = append(entry.Extensions, pkix.Extension{Id: oidInvalidityDate, Value: value})
+		}
+		revoked = append(revoked, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	crl, err := ca.cert.CreateCRL(rand.Reader, ca.priv, revoked, now, now.Add(2*getCRLInterval()))
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	ca.crl = crl
+
+	Trace.Printf("Generated CRL with %d entries.\n", len(revoked))
+
+	return crl, nil
+}
+
+// readCRL returns the current CRL, DER encoded.
+//
+func (ca *CA) readCRL() ([]byte, error) {
+	ca.crlMutex.RLock()
+	crl := ca.crl
+	ca.crlMutex.RUnlock()
+
+	if crl == nil {
+		return ca.generateCRL()
+	}
+	return crl, nil
+}
+
+// startCRLPublisher generates a CRL now and then every server.crl.interval. The
+// revocations recorded since by the replicas of the CA sharing its database are
+// published to the revocation feed every server.crl.poll.
+//
+func (ca *CA) startCRLPublisher() {
+	if _, err := ca.generateCRL(); err != nil {
+		Error.Println("Failed generating CRL: ", err)
+	}
+	if err := ca.skipPublishedRevocations(); err != nil {
+		Error.Println("Failed reading the revocations: ", err)
+	}
+
+	ca.crlStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(getCRLInterval())
+		defer ticker.Stop()
+		poll := time.NewTicker(getRevocationPollInterval())
+		defer poll.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := ca.generateCRL(); err != nil {
+					Error.Println("Failed generating CRL: ", err)
+				}
+			case <-poll.C:
+				if err := ca.publishNewRevocations(); err != nil {
+					Error.Println("Failed publishing the revocations: ", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(ca.crlStop)
+}
+
+func (ca *CA) stopCRLPublisher() {
+	if ca.crlStop != nil {
+		close(ca.crlStop)
+		ca.crlStop = nil
+	}
+}
+
+// revocationTime returns the time since when the certificates revoked by a request
+// are invalid: effective, if set, or now. It cannot be in the future.
+//
+func revocationTime(effective *google_protobuf.Timestamp) (time.Time, error) {
+	now := time.Now()
+	if effective == nil || effective.Seconds == 0 {
+		return now, nil
+	}
+
+	t := time.Unix(effective.Seconds, int64(effective.Nanos))
+	if t.After(now) {
+		return now, errors.New("Effective time of the revocation is in the future.")
+	}
+	return t, nil
+}
+
+func getCRLInterval() time.Duration {
+	if interval := viper.GetDuration("server.crl.interval"); interval > 0 {
+		return interval
+	}
+	return time.Hour
+}
+
+func getRevocationPollInterval() time.Duration {
+	if interval := viper.GetDuration("server.crl.poll"); interval > 0 {
+		return interval
+	}
+	return 2 * time.Second
+}
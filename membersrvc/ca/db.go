@@ -0,0 +1,237 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql" // This blank import is required to load the MySQL driver
+	_ "github.com/lib/pq"              // This blank import is required to load the PostgreSQL driver
+	_ "github.com/mattn/go-sqlite3"    // This blank import is required to load sqlite3 driver
+	"github.com/spf13/viper"
+)
+
+// caNamePlaceholder is replaced with the name of a CA in the data source of
+// a database server, so that each CA keeps its tables in its own database.
+const caNamePlaceholder = "{ca}"
+
+// reservedColumns are the column names that are reserved words of MySQL or
+// PostgreSQL, and have to be quoted.
+var reservedColumns = regexp.MustCompile(`\b(row|key|usage)\b`)
+
+// sqlDialect rewrites the statements of the CAs, written for sqlite, for
+// another database.
+//
+type sqlDialect struct {
+	driver string
+	// quote quotes the reserved column names
+	quote string
+	// types translates the column types of the CREATE TABLE statements
+	types *strings.Replacer
+	// numbered is true if placeholders are numbered ($1, $2, ...) rather than '?'
+	numbered bool
+	// insertIgnore rewrites an INSERT statement to skip the rows violating a
+	// unique constraint
+	insertIgnore func(query string) string
+	// tables lists the tables of the database
+	tables string
+	// resetSequence, if set, moves the sequence of the row column of a table to
+	// its largest value, once rows are inserted with their row column set. The
+	// statement is already written for the dialect.
+	resetSequence func(table string) string
+}
+
+var dialects = map[string]*sqlDialect{
+	"sqlite3": {
+		driver: "sqlite3",
+		tables: "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'",
+	},
+	"postgres": {
+		driver:   "postgres",
+		quote:    `"`,
+		numbered: true,
+		types: strings.NewReplacer(
+			"row INTEGER PRIMARY KEY", "row BIGSERIAL PRIMARY KEY",
+			" INTEGER", " BIGINT",
+			" BLOB", " BYTEA",
+			" DATETIME", " TIMESTAMP WITH TIME ZONE",
+		),
+		insertIgnore: func(query string) string {
+			return strings.Replace(query, "INSERT OR IGNORE INTO", "INSERT INTO", 1) + " ON CONFLICT DO NOTHING"
+		},
+		tables: "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()",
+		resetSequence: func(table string) string {
+			return fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%s', 'row'), COALESCE(MAX("row"), 1)) FROM %s`, strings.ToLower(table), table)
+		},
+	},
+	"mysql": {
+		driver: "mysql",
+		quote:  "`",
+		types: strings.NewReplacer(
+			"row INTEGER PRIMARY KEY", "row BIGINT PRIMARY KEY AUTO_INCREMENT",
+			" INTEGER", " BIGINT",
+		),
+		insertIgnore: func(query string) string {
+			return strings.Replace(query, "INSERT OR IGNORE INTO", "INSERT IGNORE INTO", 1)
+		},
+		tables: "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()",
+	},
+}
+
+// rebind rewrites query for the dialect.
+func (d *sqlDialect) rebind(query string) string {
+	if d.driver == "sqlite3" {
+		return query
+	}
+
+	if strings.HasPrefix(query, "CREATE TABLE") {
+		query = d.types.Replace(query)
+	}
+	if strings.HasPrefix(query, "INSERT OR IGNORE INTO") {
+		query = d.insertIgnore(query)
+	}
+	query = reservedColumns.ReplaceAllString(query, d.quote+"$1"+d.quote)
+
+	if d.numbered {
+		var buf []byte
+		n := 0
+		for i := 0; i < len(query); i++ {
+			if query[i] == '?' {
+				n++
+				buf = append(buf, '$')
+				buf = strconv.AppendInt(buf, int64(n), 10)
+			} else {
+				buf = append(buf, query[i])
+			}
+		}
+		query = string(buf)
+	}
+
+	return query
+}
+
+// Database is the database of a CA. It rewrites the statements of the CA for
+// the configured database.
+//
+type Database struct {
+	*sql.DB
+	dialect *sqlDialect
+	name    string
+}
+
+// DatabaseTx is a transaction on the database of a CA.
+//
+type DatabaseTx struct {
+	*sql.Tx
+	dialect *sqlDialect
+	name    string
+}
+
+// openDatabase opens the database of the CA name as configured in the
+// server.database section: an sqlite file in the directory path by default,
+// or a database of a PostgreSQL or MySQL server.
+//
+func openDatabase(path, name string) (*Database, error) {
+	kind := viper.GetString("server.database.type")
+	if kind == "" {
+		kind = "sqlite3"
+	}
+	dialect, ok := dialects[kind]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported database type [%s]", kind)
+	}
+
+	source := path + "/" + name + ".db"
+	if kind != "sqlite3" {
+		source = viper.GetString("server.database.datasource")
+		if !strings.Contains(source, caNamePlaceholder) {
+			return nil, fmt.Errorf("The database data source must contain %s to separate the databases of the CAs", caNamePlaceholder)
+		}
+		source = strings.Replace(source, caNamePlaceholder, name, -1)
+	}
+
+	db, err := sql.Open(dialect.driver, source)
+	if err != nil {
+		return nil, err
+	}
+	if n := viper.GetInt("server.database.maxOpenConnections"); n > 0 {
+		db.SetMaxOpenConns(n)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Database{db, dialect, name}, nil
+}
+
+// Exec executes a statement without returning any rows.
+func (db *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeStatement(db.name, "exec", time.Now())
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+// Query executes a query returning rows.
+func (db *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeStatement(db.name, "query", time.Now())
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+// QueryRow executes a query returning at most one row.
+func (db *Database) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeStatement(db.name, "query", time.Now())
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+// Begin starts a transaction.
+func (db *Database) Begin() (*DatabaseTx, error) {
+	defer observeStatement(db.name, "begin", time.Now())
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &DatabaseTx{tx, db.dialect, db.name}, nil
+}
+
+// Exec executes a statement without returning any rows.
+func (tx *DatabaseTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeStatement(tx.name, "exec", time.Now())
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+// Query executes a query returning rows.
+func (tx *DatabaseTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeStatement(tx.name, "query", time.Now())
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+// QueryRow executes a query returning at most one row.
+func (tx *DatabaseTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeStatement(tx.name, "query", time.Now())
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// Commit commits the transaction.
+func (tx *DatabaseTx) Commit() error {
+	defer observeStatement(tx.name, "commit", time.Now())
+	return tx.Tx.Commit()
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRebindSqlite(t *testing.T) {
+	query := "UPDATE Users SET token=?, state=?, key=? WHERE id=?"
+	if dialects["sqlite3"].rebind(query) != query {
+		t.Fatal("sqlite statements should be left unchanged")
+	}
+}
+
+func TestRebindPostgres(t *testing.T) {
+	d := dialects["postgres"]
+
+	if q := d.rebind("UPDATE Users SET token=?, state=?, key=? WHERE id=?"); q != `UPDATE Users SET token=$1, state=$2, "key"=$3 WHERE id=$4` {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+	if q := d.rebind("CREATE TABLE IF NOT EXISTS TCertificates (row INTEGER PRIMARY KEY, enrollmentID VARCHAR(64), notAfter INTEGER, kdfkey BLOB)"); q != `CREATE TABLE IF NOT EXISTS TCertificates ("row" BIGSERIAL PRIMARY KEY, enrollmentID VARCHAR(64), notAfter BIGINT, kdfkey BYTEA)` {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+	if q := d.rebind("INSERT OR IGNORE INTO Revocations (serial) VALUES (?)"); q != "INSERT INTO Revocations (serial) VALUES ($1) ON CONFLICT DO NOTHING" {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+}
+
+func TestResetSequencePostgres(t *testing.T) {
+	if q := dialects["postgres"].resetSequence("TCertificates"); q != `SELECT setval(pg_get_serial_sequence('tcertificates', 'row'), COALESCE(MAX("row"), 1)) FROM TCertificates` {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+	if dialects["sqlite3"].resetSequence != nil || dialects["mysql"].resetSequence != nil {
+		t.Fatal("Only the sequences of PostgreSQL should be reset")
+	}
+}
+
+func TestRebindMySQL(t *testing.T) {
+	d := dialects["mysql"]
+
+	if q := d.rebind("SELECT cert, kdfkey FROM Certificates WHERE id=? AND timestamp=? ORDER BY usage"); q != "SELECT cert, kdfkey FROM Certificates WHERE id=? AND timestamp=? ORDER BY `usage`" {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+	if q := d.rebind("CREATE TABLE IF NOT EXISTS AffiliationGroups (row INTEGER PRIMARY KEY, name VARCHAR(64), parent INTEGER)"); q != "CREATE TABLE IF NOT EXISTS AffiliationGroups (`row` BIGINT PRIMARY KEY AUTO_INCREMENT, name VARCHAR(64), parent BIGINT)" {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+	if q := d.rebind("INSERT OR IGNORE INTO Revocations (serial) VALUES (?)"); q != "INSERT IGNORE INTO Revocations (serial) VALUES (?)" {
+		t.Fatalf("Unexpected statement [%s]", q)
+	}
+}
+
+func TestOpenDatabaseBadConfiguration(t *testing.T) {
+	defer viper.Set("server.database.type", "")
+
+	viper.Set("server.database.type", "oracle")
+	if _, err := openDatabase(".", "eca"); err == nil {
+		t.Fatal("An unsupported database type should be rejected")
+	}
+
+	// the CAs cannot share a single database
+	viper.Set("server.database.type", "postgres")
+	viper.Set("server.database.datasource", "host=localhost dbname=membersrvc")
+	if _, err := openDatabase(".", "eca"); err == nil {
+		t.Fatal("A data source without the name of the CA should be rejected")
+	}
+}
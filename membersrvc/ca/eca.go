@@ -24,9 +24,11 @@ import (
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
@@ -38,6 +40,23 @@ var (
 	// ECertSubjectRole is the ASN1 object identifier of the subject's role.
 	//
 	ECertSubjectRole = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 7}
+
+	// ECertSignatureScheme is the ASN1 object identifier of the signature scheme
+	// of the key certified by an enrollment certificate for signing.
+	//
+	ECertSignatureScheme = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 8}
+
+	// ECertSubjectAffiliation is the ASN1 object identifier of the path of the
+	// subject's affiliation group, from the top-level group down, separated by /.
+	//
+	ECertSubjectAffiliation = asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 9}
+
+	// Signature schemes of the keys the ECA certifies for signing, by key type
+	ecertSignatureSchemes = map[pb.CryptoType]string{
+		pb.CryptoType_ECDSA:   "ecdsa",
+		pb.CryptoType_ED25519: "ed25519",
+		pb.CryptoType_RSA:     "rsa",
+	}
 )
 
 // ECA is the enrollment certificate authority.
@@ -46,16 +65,48 @@ type ECA struct {
 	*CA
 	obcKey          []byte
 	obcPriv, obcPub []byte
+	directory       *ldapDirectory
+	policies        []*issuancePolicy
+	guard           *enrollmentGuard
+	tca             *TCA
 }
 
-func initializeECATables(db *sql.DB) error {
-	return initializeCommonTables(db)
+func initializeECATables(db *Database) error {
+	if err := initializeCommonTables(db); err != nil {
+		return err
+	}
+	return initializeEnrollmentGuardTables(db)
 }
 
 // NewECA sets up a new ECA.
 //
 func NewECA() *ECA {
-	eca := &ECA{NewCA("eca", initializeECATables), nil, nil, nil}
+	eca := &ECA{NewCA("eca", initializeECATables), nil, nil, nil, nil, nil, newEnrollmentGuard(), nil}
+
+	{
+		// users of an LDAP directory, if any, register on their first enrollment
+		dir, err := newLDAPDirectory()
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		eca.directory = dir
+	}
+
+	{
+		// issuance policies by affiliation group and role
+		policies, err := readIssuancePolicies()
+		if err != nil {
+			Panic.Panicln(err)
+		}
+		eca.policies = policies
+	}
+
+	{
+		// webhooks notified of the registrations, issuances and revocations, if any
+		if err := startWebhooks(); err != nil {
+			Panic.Panicln(err)
+		}
+	}
 
 	{
 		// read or create global symmetric encryption key
@@ -150,6 +201,252 @@ func (eca *ECA) populateUsersTable() {
 	}
 }
 
+// registerDirectoryUser authenticates id with pwd against the LDAP directory
+// and registers it with the role and affiliation of its directory groups.
+//
+func (eca *ECA) registerDirectoryUser(id string, pwd []byte) error {
+	group, err := eca.directory.authenticate(id, pwd)
+	if err != nil {
+		Trace.Printf("Directory authentication failed for %s: %s\n", id, err)
+		return errors.New("Identity or token does not match.")
+	}
+
+	_, err = eca.registerUser(id, group.affiliation, group.affiliationRole, group.role, "", "")
+	return err
+}
+
+// verifyEnrollmentSignature checks that sig, over msg, was made with the
+// enrollment signing key of id.
+//
+func (eca *ECA) verifyEnrollmentSignature(id string, msg []byte, sig *pb.Signature) error {
+	raw, err := eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return err
+	}
+
+	if !verifySignature(cert.PublicKey, msg, sig) {
+		return errors.New("Signature verification failed.")
+	}
+	return nil
+}
+
+// checkAuditorSignature checks that id is an auditor and that sig, over msg, was
+// made with its enrollment signing key.
+//
+func (eca *ECA) checkAuditorSignature(id string, msg []byte, sig *pb.Signature) error {
+	if eca.readRole(id)&int(pb.Role_AUDITOR) == 0 {
+		return errors.New("Access denied.")
+	}
+
+	return eca.verifyEnrollmentSignature(id, msg, sig)
+}
+
+// revokeIdentity revokes all the enrollment certificates of id and, through the
+// TCA, its unexpired transaction certificates, for reason and as of invalidAt.
+// The identity can no longer enroll nor obtain new transaction certificates.
+//
+func (eca *ECA) revokeIdentity(id string, reason pb.RevocationReason, invalidAt time.Time) error {
+	var state int
+	if err := eca.db.QueryRow("SELECT state FROM Users WHERE id=?", id).Scan(&state); err != nil {
+		return errors.New("Identity lookup error: " + err.Error())
+	}
+
+	Trace.Printf("Revoking identity %s.\n", id)
+
+	if _, err := eca.db.Exec("UPDATE Users SET token=?, state=? WHERE id=?", nil, userStateRevoked, id); err != nil {
+		Error.Println(err)
+		return err
+	}
+
+	serials, err := eca.readCertificateSerials(id)
+	if err != nil {
+		return err
+	}
+	if err = eca.revokeSerials(serials, reason, invalidAt); err != nil {
+		return err
+	}
+
+	if eca.tca != nil {
+		return eca.tca.revokeCertificates(id, reason, invalidAt)
+	}
+	return nil
+}
+
+// readCertificateSerials returns the serial numbers of the enrollment certificates of id.
+//
+func (eca *ECA) readCertificateSerials(id string) ([]string, error) {
+	rows, err := eca.db.Query("SELECT cert FROM Certificates WHERE id=?", id)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var serials []string
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(raw); err != nil {
+			return nil, err
+		}
+		serials = append(serials, cert.SerialNumber.String())
+	}
+	return serials, rows.Err()
+}
+
+// isRevoked returns whether the identity id has been revoked.
+//
+func (eca *ECA) isRevoked(id string) bool {
+	var state int
+	if err := eca.db.QueryRow("SELECT state FROM Users WHERE id=?", id).Scan(&state); err != nil {
+		return false
+	}
+	return state == userStateRevoked
+}
+
+// resetSecret rotates, on behalf of registrar, the one-time password of id and returns the
+// new one. The current enrollment certificates of id are revoked, as superseded by the ones
+// it enrolls for anew, and its enrollment failures cleared. This also regenerates expired or
+// void passwords.
+//
+func (eca *ECA) resetSecret(registrar, id string) (string, error) {
+	if _, err := eca.checkRegistrarScope(registrar, id); err != nil {
+		return "", err
+	}
+
+	tok := randomString(12)
+	if err := eca.transitionUser("UPDATE Users SET token=?, state=?, key=?, tokenExpiry=?, tokenAttempts=? WHERE id=? AND state<>?", tok, 0, nil, secretExpiry(), 0, id, userStateRevoked); err != nil {
+		return "", errors.New("Identity has been revoked.")
+	}
+	serials, err := eca.readCertificateSerials(id)
+	if err != nil {
+		return "", err
+	}
+	if len(serials) > 0 {
+		if err = eca.revokeSerials(serials, pb.RevocationReason_SUPERSEDED, time.Now()); err != nil {
+			return "", err
+		}
+	}
+	if _, err := eca.db.Exec("DELETE FROM Certificates Where id=?", id); err != nil {
+		Error.Println(err)
+		return "", err
+	}
+	if _, err := eca.db.Exec("DELETE FROM EnrollmentFailures WHERE id=?", id); err != nil {
+		Error.Println(err)
+	}
+
+	Info.Printf("Secret of %s reset by %s.\n", id, registrar)
+	securityEvent("secret.reset", map[string]interface{}{"id": id, "registrar": registrar})
+
+	return tok, nil
+}
+
+// checkRegistrarScope checks that registrar is id itself, or may register identities of the
+// role and affiliation of id, and returns the role of id.
+//
+func (eca *ECA) checkRegistrarScope(registrar, id string) (pb.Role, error) {
+	var role int
+	var metadata sql.NullString
+	if err := eca.db.QueryRow("SELECT role, metadata FROM Users WHERE id=?", id).Scan(&role, &metadata); err != nil {
+		return 0, errors.New("Identity lookup error: " + err.Error())
+	}
+
+	if registrar != id {
+		if err := eca.canRegister(registrar, role2String(role), metadata.String); err != nil {
+			return 0, err
+		}
+		if eca.requireAffiliation(pb.Role(role)) {
+			affiliation, err := eca.readMemberAffiliation(id)
+			if err != nil {
+				return 0, err
+			}
+			if err = eca.checkAffiliationScope(registrar, affiliation); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return pb.Role(role), nil
+}
+
+// refreshAttributes has the ACA fetch anew, on behalf of registrar, the attributes of id from
+// the attribute source, e.g. after they were updated there, rather than serve them from its
+// cache.
+//
+func (eca *ECA) refreshAttributes(registrar, id string) error {
+	raw, err := eca.readAttributesOwnerCertificate(registrar, id)
+	if err != nil {
+		return err
+	}
+	if err = (&ECAP{eca}).fetchAttributes(&pb.Cert{Cert: raw}); err != nil {
+		return err
+	}
+
+	Info.Printf("Attributes of %s refreshed by %s.\n", id, registrar)
+	return nil
+}
+
+// updateAttributes has the ACA record, on behalf of registrar, updates of the attributes of id
+// overriding the attribute source, and flags the TCerts issued to id until then for refresh,
+// as they carry its former attributes.
+//
+func (eca *ECA) updateAttributes(registrar, id string, attrs []*pb.ACAAttribute) error {
+	if len(attrs) == 0 {
+		return errors.New("No attribute to update.")
+	}
+	raw, err := eca.readAttributesOwnerCertificate(registrar, id)
+	if err != nil {
+		return err
+	}
+	if err = (&ECAP{eca}).fetchAttributes(&pb.Cert{Cert: raw}, attrs...); err != nil {
+		return err
+	}
+
+	var flagged int64
+	if eca.tca != nil {
+		if flagged, err = eca.tca.flagCertificatesForRefresh(id); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, len(attrs))
+	for i, attr := range attrs {
+		names[i] = attr.AttributeName
+	}
+	Info.Printf("Attributes %v of %s updated by %s, %d TCerts to refresh.\n", names, id, registrar, flagged)
+	securityEvent("attributes.updated", map[string]interface{}{"id": id, "registrar": registrar, "attributes": names, "tcerts": flagged})
+
+	return nil
+}
+
+// readAttributesOwnerCertificate checks that registrar may manage the attributes of id, and
+// returns the enrollment certificate of id. Only clients have attributes, and only once enrolled.
+//
+func (eca *ECA) readAttributesOwnerCertificate(registrar, id string) ([]byte, error) {
+	if !viper.GetBool("aca.enabled") {
+		return nil, errors.New("The ACA is not enabled.")
+	}
+	role, err := eca.checkRegistrarScope(registrar, id)
+	if err != nil {
+		return nil, err
+	}
+	if role != pb.Role_CLIENT {
+		return nil, errors.New("Only clients have attributes.")
+	}
+
+	raw, err := eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return nil, errors.New("Identity has not enrolled.")
+	}
+	return raw, nil
+}
+
 // populateAffiliationGroup populates the affiliation groups table.
 //
 func (eca *ECA) populateAffiliationGroup(name, parent, key string, level int) {
@@ -184,6 +481,7 @@ func (eca *ECA) populateAffiliationGroupsTable() {
 func (eca *ECA) Start(srv *grpc.Server) {
 	eca.startECAP(srv)
 	eca.startECAA(srv)
+	eca.startCRLPublisher()
 
 	Info.Println("ECA started.")
 }
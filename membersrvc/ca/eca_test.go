@@ -17,9 +17,12 @@ limitations under the License.
 package ca
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"os"
 	"testing"
@@ -31,6 +34,7 @@ import (
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 )
 
@@ -49,6 +53,15 @@ var (
 	testUser    = User{enrollID: "testUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
 	testUser2   = User{enrollID: "testUser2", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
 	testAuditor = User{enrollID: "testAuditor", role: 8}
+	crlUser     = User{enrollID: "crlUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	revokedUser = User{enrollID: "revokedUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	affUser     = User{enrollID: "affUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	bankUser    = User{enrollID: "bankUser", role: 1, affiliation: "bank_a", affiliationRole: "00001"}
+	renewUser   = User{enrollID: "renewUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	replicaUser = User{enrollID: "replicaUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	auditedUser = User{enrollID: "auditedUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	secretUser  = User{enrollID: "secretUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	csrUser     = User{enrollID: "csrUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
 )
 
 //helper function for multiple tests
@@ -298,6 +311,19 @@ func TestReadCACertificate(t *testing.T) {
 	}
 }
 
+func TestReadCACertificateChain(t *testing.T) {
+	ecap := &ECAP{eca}
+	chain, err := ecap.ReadCACertificateChain(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatalf("Failed to read the CA certificate chain of the ECA: [%s]: ", err.Error())
+	}
+
+	// a self-signed ECA is its own root
+	if len(chain.Certs) != 1 || !bytes.Equal(chain.Certs[0], eca.raw) {
+		t.Fatal("The chain of a self-signed ECA should hold only its certificate")
+	}
+}
+
 func TestReadCertificatePair(t *testing.T) {
 
 	ecap := &ECAP{eca}
@@ -471,26 +497,714 @@ func TestRevokeCertificatePair(t *testing.T) {
 	ecap := &ECAP{eca}
 
 	_, err := ecap.RevokeCertificatePair(context.Background(), &pb.ECertRevokeReq{})
-	if err.Error() != "ECAP:RevokeCertificate method not (yet) implemented" {
-		t.Fatalf("Expected error was not returned: [%s]", err.Error())
+	if err == nil {
+		t.Fatal("Expected an error when revoking without identity and certificate")
+	}
+
+	if err = registerUser(testAdmin, &crlUser); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = enrollUser(&crlUser); err != nil {
+		t.Fatalf("Failed to enroll crlUser: [%s]", err.Error())
+	}
+	pair, err := ecap.ReadCertificatePair(context.Background(), &pb.ECertReadReq{Id: &pb.Identity{Id: crlUser.enrollID}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// a user cannot revoke the certificate of another
+	req := &pb.ECertRevokeReq{Id: &pb.Identity{Id: testUser.enrollID}, Cert: &pb.Cert{Cert: pair.Sign}}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+	if _, err = ecap.RevokeCertificatePair(context.Background(), req); err == nil {
+		t.Fatal("A user should not be able to revoke the certificate of another")
+	}
+
+	req = &pb.ECertRevokeReq{Id: &pb.Identity{Id: crlUser.enrollID}, Cert: &pb.Cert{Cert: pair.Sign}}
+	req.Sig = signRequest(t, crlUser.enrollPrivKey, req)
+	if _, err = ecap.RevokeCertificatePair(context.Background(), req); err != nil {
+		t.Fatalf("Failed revoking own certificate: [%s]", err.Error())
 	}
+
+	checkRevoked(t, pair.Sign)
 }
 
 func TestRevokeCertificate(t *testing.T) {
 
 	ecaa := &ECAA{eca}
+	ecap := &ECAP{eca}
 
-	_, err := ecaa.RevokeCertificate(context.Background(), &pb.ECertRevokeReq{})
-	if err.Error() != "ECAA:RevokeCertificate method not (yet) implemented" {
-		t.Fatalf("Expected error was not returned: [%s]", err.Error())
+	pair, err := ecap.ReadCertificatePair(context.Background(), &pb.ECertReadReq{Id: &pb.Identity{Id: crlUser.enrollID}})
+	if err != nil {
+		t.Fatal(err.Error())
 	}
+
+	// only auditors may revoke any certificate
+	req := &pb.ECertRevokeReq{Id: &pb.Identity{Id: testUser.enrollID}, Cert: &pb.Cert{Cert: pair.Enc}}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+	if _, err = ecaa.RevokeCertificate(context.Background(), req); err == nil || err.Error() != "Access denied." {
+		t.Fatalf("Expected access to be denied, got [%v]", err)
+	}
+
+	req = &pb.ECertRevokeReq{Id: &pb.Identity{Id: testAuditor.enrollID}, Cert: &pb.Cert{Cert: pair.Enc}}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	if _, err = ecaa.RevokeCertificate(context.Background(), req); err != nil {
+		t.Fatalf("Failed revoking certificate: [%s]", err.Error())
+	}
+
+	checkRevoked(t, pair.Enc)
 }
 
 func TestPublishCRL(t *testing.T) {
 	ecaa := &ECAA{eca}
 
-	_, err := ecaa.PublishCRL(context.Background(), &pb.ECertCRLReq{})
-	if err.Error() != "ECAA:PublishCRL method not (yet) implemented" {
-		t.Fatalf("Expected error was not returned: [%s]", err.Error())
+	req := &pb.ECertCRLReq{Id: &pb.Identity{Id: testUser.enrollID}}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+	if _, err := ecaa.PublishCRL(context.Background(), req); err == nil {
+		t.Fatal("Only auditors should be able to publish a CRL")
+	}
+
+	req = &pb.ECertCRLReq{Id: &pb.Identity{Id: testAuditor.enrollID}}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	if _, err := ecaa.PublishCRL(context.Background(), req); err != nil {
+		t.Fatalf("Failed publishing CRL: [%s]", err.Error())
+	}
+}
+
+func TestRevokeIdentity(t *testing.T) {
+	ecaa := &ECAA{eca}
+	ecap := &ECAP{eca}
+	tcap := &TCAP{tca}
+
+	if err := registerUser(testAdmin, &revokedUser); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := enrollUser(&revokedUser); err != nil {
+		t.Fatalf("Failed to enroll revokedUser: [%s]", err.Error())
+	}
+	pair, err := ecap.ReadCertificatePair(context.Background(), &pb.ECertReadReq{Id: &pb.Identity{Id: revokedUser.enrollID}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tcertReq, err := buildCertificateSetRequest(revokedUser.enrollID, revokedUser.enrollPrivKey, 2, -1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tcerts, err := tcap.CreateCertificateSet(context.Background(), tcertReq)
+	if err != nil {
+		t.Fatalf("Failed creating TCerts: [%s]", err.Error())
+	}
+
+	effective := time.Now().Add(-time.Hour).Unix()
+
+	// only auditors may revoke an identity
+	req := &pb.IdentityRevokeReq{
+		Id:        &pb.Identity{Id: testUser.enrollID},
+		Subject:   &pb.Identity{Id: revokedUser.enrollID},
+		Reason:    pb.RevocationReason_KEY_COMPROMISE,
+		Effective: &google_protobuf.Timestamp{Seconds: effective}}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+	if _, err = ecaa.RevokeIdentity(context.Background(), req); err == nil || err.Error() != "Access denied." {
+		t.Fatalf("Expected access to be denied, got [%v]", err)
+	}
+
+	// revocations cannot take effect in the future
+	req = &pb.IdentityRevokeReq{
+		Id:        &pb.Identity{Id: testAuditor.enrollID},
+		Subject:   &pb.Identity{Id: revokedUser.enrollID},
+		Effective: &google_protobuf.Timestamp{Seconds: time.Now().Add(time.Hour).Unix()}}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	if _, err = ecaa.RevokeIdentity(context.Background(), req); err == nil {
+		t.Fatal("A revocation effective in the future should be rejected")
+	}
+
+	req = &pb.IdentityRevokeReq{
+		Id:        &pb.Identity{Id: testAuditor.enrollID},
+		Subject:   &pb.Identity{Id: revokedUser.enrollID},
+		Reason:    pb.RevocationReason_KEY_COMPROMISE,
+		Effective: &google_protobuf.Timestamp{Seconds: effective}}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	if _, err = ecaa.RevokeIdentity(context.Background(), req); err != nil {
+		t.Fatalf("Failed revoking identity: [%s]", err.Error())
+	}
+
+	entry := checkRevoked(t, pair.Sign)
+	checkRevoked(t, pair.Enc)
+	for _, tcert := range tcerts.Certs.Certs {
+		findRevoked(t, tca.CA, tcert.Cert)
+	}
+
+	var reason asn1.Enumerated
+	var invalidAt time.Time
+	for _, ext := range entry.Extensions {
+		switch {
+		case ext.Id.Equal(oidReasonCode):
+			asn1.Unmarshal(ext.Value, &reason)
+		case ext.Id.Equal(oidInvalidityDate):
+			asn1.Unmarshal(ext.Value, &invalidAt)
+		}
+	}
+	if reason != asn1.Enumerated(pb.RevocationReason_KEY_COMPROMISE) {
+		t.Fatalf("Expected reason %d, got %d", pb.RevocationReason_KEY_COMPROMISE, reason)
+	}
+	if invalidAt.Unix() != effective {
+		t.Fatalf("Expected invalidity date %d, got %d", effective, invalidAt.Unix())
+	}
+
+	// a revoked identity can neither obtain TCerts nor re-enroll
+	if err = signCertificateSetRequest(tcertReq, revokedUser.enrollPrivKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = tcap.CreateCertificateSet(context.Background(), tcertReq); err == nil {
+		t.Fatal("A revoked identity should not obtain TCerts")
+	}
+	if _, err = ecap.reenroll(newReenrollRequest(t, revokedUser, revokedUser.enrollPrivKey)); err == nil {
+		t.Fatal("A revoked identity should not re-enroll")
+	}
+}
+
+// checkRevoked checks that raw is listed in the CRL of the ECA
+func checkRevoked(t *testing.T, raw []byte) pkix.RevokedCertificate {
+	ecap := &ECAP{eca}
+
+	resp, err := ecap.ReadCRL(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatalf("Failed reading the CRL: [%s]", err.Error())
+	}
+	if !bytes.Equal(resp.Crl, eca.crl) {
+		t.Fatal("ReadCRL did not return the current CRL")
+	}
+
+	return findRevoked(t, eca.CA, raw)
+}
+
+// findRevoked checks that raw is listed in the current CRL of ca and returns its entry
+func findRevoked(t *testing.T, ca *CA, raw []byte) pkix.RevokedCertificate {
+	der, err := ca.readCRL()
+	if err != nil {
+		t.Fatalf("Failed reading the CRL: [%s]", err.Error())
+	}
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = ca.cert.CheckCRLSignature(crl); err != nil {
+		t.Fatalf("Invalid CRL signature: [%s]", err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("Failed parsing the certificate: [%s]", err.Error())
+	}
+	for _, entry := range crl.TBSCertList.RevokedCertificates {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return entry
+		}
+	}
+	t.Fatalf("Certificate %s not listed in the CRL", cert.SerialNumber)
+	return pkix.RevokedCertificate{}
+}
+
+// a registrar may only register users within the subtree of its affiliation group
+func TestRegisterUserOutsideAffiliation(t *testing.T) {
+	if err := registerUser(testAdmin, &bankUser); err == nil {
+		t.Fatal("A registrar of institution_a should not be able to register a user of bank_a")
+	}
+}
+
+func TestAffiliationManagement(t *testing.T) {
+	ecaa := &ECAA{eca}
+
+	create := func(name, parent string) error {
+		req := &pb.AffiliationCreateReq{
+			Id:          &pb.Identity{Id: testAdmin.enrollID},
+			Affiliation: &pb.Affiliation{Name: name, Parent: parent}}
+		req.Sig = signRequest(t, testAdmin.enrollPrivKey, req)
+		_, err := ecaa.CreateAffiliation(context.Background(), req)
+		return err
+	}
+
+	if err := create("institution_a_dept", "institution_a"); err != nil {
+		t.Fatalf("Failed creating affiliation group: [%s]", err.Error())
+	}
+	if err := create("bank_a_dept", "bank_a"); err == nil {
+		t.Fatal("A registrar of institution_a should not be able to create groups under bank_a")
+	}
+	if err := create("top_level", ""); err == nil {
+		t.Fatal("A registrar with an affiliation should not be able to create top-level groups")
+	}
+	if _, ok := tca.preKeys["institution_a_dept"]; !ok {
+		t.Fatal("The TCA pre-keys were not refreshed")
+	}
+
+	// the registrar only reads its own subtree
+	read := &pb.AffiliationReadReq{Id: &pb.Identity{Id: testAdmin.enrollID}}
+	read.Sig = signRequest(t, testAdmin.enrollPrivKey, read)
+	set, err := ecaa.ReadAffiliations(context.Background(), read)
+	if err != nil {
+		t.Fatalf("Failed reading affiliation groups: [%s]", err.Error())
+	}
+	names := make(map[string]string)
+	for _, affiliation := range set.Affiliations {
+		names[affiliation.Name] = affiliation.Parent
+	}
+	if len(names) != 2 || names["institution_a"] != "institutions" || names["institution_a_dept"] != "institution_a" {
+		t.Fatalf("Unexpected affiliation groups: %v", names)
+	}
+
+	update := &pb.AffiliationUpdateReq{
+		Id:          &pb.Identity{Id: testAdmin.enrollID},
+		Name:        "institution_a_dept",
+		Affiliation: &pb.Affiliation{Name: "institution_a_unit", Parent: "institution_a"}}
+	update.Sig = signRequest(t, testAdmin.enrollPrivKey, update)
+	if _, err = ecaa.UpdateAffiliation(context.Background(), update); err != nil {
+		t.Fatalf("Failed renaming affiliation group: [%s]", err.Error())
+	}
+
+	// groups with members cannot be changed, nor can the registrar's own group
+	update = &pb.AffiliationUpdateReq{
+		Id:          &pb.Identity{Id: testAdmin.enrollID},
+		Name:        "institution_a",
+		Affiliation: &pb.Affiliation{Name: "institution_b", Parent: "institutions"}}
+	update.Sig = signRequest(t, testAdmin.enrollPrivKey, update)
+	if _, err = ecaa.UpdateAffiliation(context.Background(), update); err == nil {
+		t.Fatal("A registrar should not be able to rename its own affiliation group")
+	}
+	if err = eca.updateAffiliationGroup("institution_a", "institution_b", "institutions"); err == nil {
+		t.Fatal("An affiliation group with members should not be renamed")
+	}
+
+	del := &pb.AffiliationDeleteReq{Id: &pb.Identity{Id: testAdmin.enrollID}, Name: "institution_a_unit"}
+	del.Sig = signRequest(t, testAdmin.enrollPrivKey, del)
+	if _, err = ecaa.DeleteAffiliation(context.Background(), del); err != nil {
+		t.Fatalf("Failed deleting affiliation group: [%s]", err.Error())
+	}
+	if valid, _ := eca.isValidAffiliation("institution_a_unit"); valid {
+		t.Fatal("The affiliation group was not deleted")
+	}
+
+	// a user without registrar metadata cannot manage affiliation groups
+	del = &pb.AffiliationDeleteReq{Id: &pb.Identity{Id: testUser.enrollID}, Name: "bank_a"}
+	del.Sig = signRequest(t, testUser.enrollPrivKey, del)
+	if _, err = ecaa.DeleteAffiliation(context.Background(), del); err == nil {
+		t.Fatal("A user without registrar metadata should not be able to delete affiliation groups")
+	}
+}
+
+// the enrollment certificates embed the path of the affiliation group of their subject
+func TestECertAffiliationExtension(t *testing.T) {
+	if err := registerUser(testAdmin, &affUser); err != nil {
+		t.Fatalf("Failed to register affUser: [%s]", err.Error())
+	}
+	if err := enrollUser(&affUser); err != nil {
+		t.Fatalf("Failed to enroll affUser: [%s]", err.Error())
+	}
+
+	for _, usage := range []x509.KeyUsage{x509.KeyUsageDigitalSignature, x509.KeyUsageDataEncipherment} {
+		raw, err := eca.readCertificateByKeyUsage(affUser.enrollID, usage)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var path string
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(ECertSubjectAffiliation) {
+				path = string(ext.Value)
+			}
+		}
+		if path != "banks_and_institutions/institutions/institution_a" {
+			t.Fatalf("Unexpected affiliation path in enrollment certificate: [%s]", path)
+		}
+	}
+}
+
+// newRenewRequest returns a renewal request for user signed with its current key and, if newPriv is
+// set, for newPriv signed with it
+func newRenewRequest(t *testing.T, user User, newPriv *ecdsa.PrivateKey) *pb.ECertRenewReq {
+	req := &pb.ECertRenewReq{
+		Ts: &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id: &pb.Identity{Id: user.enrollID}}
+	if newPriv != nil {
+		pub, _ := x509.MarshalPKIXPublicKey(&newPriv.PublicKey)
+		req.Sign = &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: pub}
+		req.SignSig = signRequest(t, newPriv, req)
+	}
+	req.Sig = signRequest(t, user.enrollPrivKey, &pb.ECertRenewReq{Ts: req.Ts, Id: req.Id, Sign: req.Sign})
+
+	return req
+}
+
+func TestRenewCertificatePair(t *testing.T) {
+	ecap := &ECAP{eca}
+
+	if err := registerUser(testAdmin, &renewUser); err != nil {
+		t.Fatalf("Failed to register renewUser: [%s]", err.Error())
+	}
+	if err := enrollUser(&renewUser); err != nil {
+		t.Fatalf("Failed to enroll renewUser: [%s]", err.Error())
+	}
+	raw, _ := eca.readCertificateByKeyUsage(renewUser.enrollID, x509.KeyUsageDigitalSignature)
+	current, _ := x509.ParseCertificate(raw)
+
+	// a fresh certificate for the current key
+	resp, err := ecap.RenewCertificatePair(context.Background(), newRenewRequest(t, renewUser, nil))
+	if err != nil {
+		t.Fatalf("Failed renewing the certificate pair: [%s]", err.Error())
+	}
+	renewed, err := x509.ParseCertificate(resp.Certs.Sign)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if renewed.SerialNumber.Cmp(current.SerialNumber) == 0 || renewed.Subject.CommonName != current.Subject.CommonName {
+		t.Fatal("Expected a new certificate for the same identity")
+	}
+	if !renewed.PublicKey.(*ecdsa.PublicKey).Equal(&renewUser.enrollPrivKey.PublicKey) {
+		t.Fatal("The renewed certificate does not certify the current key")
+	}
+	if raw, _ = eca.readCertificateByKeyUsage(renewUser.enrollID, x509.KeyUsageDigitalSignature); !bytes.Equal(raw, resp.Certs.Sign) {
+		t.Fatal("The renewed certificate did not replace the current one")
+	}
+
+	// a new key must be signed for
+	newPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req := newRenewRequest(t, renewUser, newPriv)
+	req.SignSig = nil
+	if _, err = ecap.RenewCertificatePair(context.Background(), req); err == nil {
+		t.Fatal("A new signing key without proof of possession should be rejected")
+	}
+
+	// a fresh certificate for a new key
+	if resp, err = ecap.RenewCertificatePair(context.Background(), newRenewRequest(t, renewUser, newPriv)); err != nil {
+		t.Fatalf("Failed renewing the certificate pair for a new key: [%s]", err.Error())
+	}
+	renewed, _ = x509.ParseCertificate(resp.Certs.Sign)
+	if !renewed.PublicKey.(*ecdsa.PublicKey).Equal(&newPriv.PublicKey) {
+		t.Fatal("The renewed certificate does not certify the new key")
+	}
+
+	// the old key no longer authenticates the identity
+	if _, err = ecap.RenewCertificatePair(context.Background(), newRenewRequest(t, renewUser, nil)); err == nil {
+		t.Fatal("The replaced key should not authenticate a renewal")
+	}
+	renewUser.enrollPrivKey = newPriv
+	if _, err = ecap.RenewCertificatePair(context.Background(), newRenewRequest(t, renewUser, nil)); err != nil {
+		t.Fatalf("Failed renewing the certificate pair with the new key: [%s]", err.Error())
+	}
+}
+
+func newCertificateRequest(t *testing.T, priv *ecdsa.PrivateKey) []byte {
+	raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "ignored"}}, priv)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return raw
+}
+
+func TestCreateCertificatePairFromCSR(t *testing.T) {
+	ecap := &ECAP{eca}
+
+	if err := registerUser(testAdmin, &csrUser); err != nil {
+		t.Fatalf("Failed to register csrUser: [%s]", err.Error())
+	}
+
+	signPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	encPriv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req := &pb.ECertCSRReq{
+		Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:   &pb.Identity{Id: csrUser.enrollID},
+		Tok:  &pb.Token{Tok: csrUser.enrollPwd},
+		Sign: newCertificateRequest(t, signPriv),
+		Enc:  newCertificateRequest(t, encPriv),
+	}
+
+	// a request whose signature does not verify is rejected, without consuming the password
+	sign := req.Sign
+	req.Sign = append([]byte{}, sign...)
+	req.Sign[len(req.Sign)-1] ^= 0xff
+	if _, err = ecap.CreateCertificatePairFromCSR(context.Background(), req); err == nil {
+		t.Fatal("A certificate request without proof of possession should be rejected")
+	}
+	req.Sign = sign
+
+	resp, err := ecap.CreateCertificatePairFromCSR(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed enrolling with certificate requests: [%s]", err.Error())
+	}
+	scert, err := x509.ParseCertificate(resp.Certs.Sign)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var enrollID string
+	if err = eca.db.QueryRow("SELECT enrollmentId FROM Users WHERE id=?", csrUser.enrollID).Scan(&enrollID); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !scert.PublicKey.(*ecdsa.PublicKey).Equal(&signPriv.PublicKey) || scert.Subject.CommonName != enrollID {
+		t.Fatal("The enrollment certificate should certify the requested signing key for the identity")
+	}
+	ecert, err := x509.ParseCertificate(resp.Certs.Enc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !ecert.PublicKey.(*ecdsa.PublicKey).Equal(&encPriv.PublicKey) {
+		t.Fatal("The enrollment certificate should certify the requested encryption key")
+	}
+
+	// the one-time password is consumed
+	if _, err = ecap.CreateCertificatePairFromCSR(context.Background(), req); err == nil {
+		t.Fatal("The one-time password should be consumed by the enrollment")
+	}
+}
+
+func TestTransitionUserConcurrently(t *testing.T) {
+	if err := registerUser(testAdmin, &replicaUser); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// another replica of the ECA issued the challenge first
+	if err := eca.transitionUser("UPDATE Users SET state=? WHERE id=? AND state=?", 1, replicaUser.enrollID, 0); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := eca.transitionUser("UPDATE Users SET state=? WHERE id=? AND state=?", 1, replicaUser.enrollID, 0); err == nil {
+		t.Fatal("A state transition from a stale state should fail")
+	}
+
+	// only one request consumes the challenge
+	if err := eca.transitionUser("UPDATE Users SET state=? WHERE id=? AND state=?", 2, replicaUser.enrollID, 1); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := eca.transitionUser("UPDATE Users SET state=? WHERE id=? AND state=?", 2, replicaUser.enrollID, 1); err == nil {
+		t.Fatal("A consumed challenge should not be consumed again")
+	}
+}
+
+func TestReadIssuedCertificates(t *testing.T) {
+	ecaa := &ECAA{eca}
+	ecap := &ECAP{eca}
+	tcaa := &TCAA{tca}
+	tcap := &TCAP{tca}
+
+	if err := registerUser(testAdmin, &auditedUser); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := enrollUser(&auditedUser); err != nil {
+		t.Fatalf("Failed to enroll auditedUser: [%s]", err.Error())
+	}
+	pair, err := ecap.ReadCertificatePair(context.Background(), &pb.ECertReadReq{Id: &pb.Identity{Id: auditedUser.enrollID}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tcertReq, err := buildCertificateSetRequest(auditedUser.enrollID, auditedUser.enrollPrivKey, 3, -1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = tcap.CreateCertificateSet(context.Background(), tcertReq); err != nil {
+		t.Fatalf("Failed creating TCerts: [%s]", err.Error())
+	}
+
+	// only auditors may read the issued certificates
+	req := &pb.IssuedCertificatesReq{Id: &pb.Identity{Id: testUser.enrollID}, Subject: &pb.Identity{Id: auditedUser.enrollID}}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+	if _, err = ecaa.ReadIssuedCertificates(context.Background(), req); err == nil || err.Error() != "Access denied." {
+		t.Fatalf("Expected access to be denied, got [%v]", err)
+	}
+
+	req = &pb.IssuedCertificatesReq{Id: &pb.Identity{Id: testAuditor.enrollID}, Subject: &pb.Identity{Id: auditedUser.enrollID}}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	set, err := ecaa.ReadIssuedCertificates(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed reading the issued certificates: [%s]", err.Error())
+	}
+	if len(set.Certs) != 2 {
+		t.Fatalf("Expected an enrollment certificate pair, got %d certificates", len(set.Certs))
+	}
+	signCert, _ := x509.ParseCertificate(pair.Sign)
+	found := false
+	for _, cert := range set.Certs {
+		if cert.Id.Id != auditedUser.enrollID || cert.Registrar != testAdmin.enrollID {
+			t.Fatalf("Unexpected identity [%s] or registrar [%s]", cert.Id.Id, cert.Registrar)
+		}
+		if cert.Type == pb.CertificateType_ECERT_SIGN && cert.Serial == signCert.SerialNumber.String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("The enrollment certificate for signing was not recorded")
+	}
+
+	// the TCA records its own certificates, which can be filtered by type and validity
+	req = &pb.IssuedCertificatesReq{
+		Id:        &pb.Identity{Id: testAuditor.enrollID},
+		Subject:   &pb.Identity{Id: auditedUser.enrollID},
+		Types:     []pb.CertificateType{pb.CertificateType_TCERT},
+		Unexpired: true,
+		Limit:     2}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	set, err = tcaa.ReadIssuedCertificates(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed reading the issued certificates: [%s]", err.Error())
+	}
+	if len(set.Certs) != 2 || set.Certs[0].Type != pb.CertificateType_TCERT {
+		t.Fatalf("Expected 2 transaction certificates, got %v", set.Certs)
+	}
+
+	// the certificates issued in the future are none
+	req = &pb.IssuedCertificatesReq{
+		Id:          &pb.Identity{Id: testAuditor.enrollID},
+		Subject:     &pb.Identity{Id: auditedUser.enrollID},
+		IssuedAfter: &google_protobuf.Timestamp{Seconds: time.Now().Add(time.Hour).Unix()}}
+	req.Sig = signRequest(t, testAuditor.enrollPrivKey, req)
+	if set, err = ecaa.ReadIssuedCertificates(context.Background(), req); err != nil || len(set.Certs) != 0 {
+		t.Fatalf("Expected no certificates, got %v [%v]", set, err)
+	}
+}
+
+func TestResetSecret(t *testing.T) {
+	ecaa := &ECAA{eca}
+
+	if err := registerUser(testAdmin, &secretUser); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := enrollUser(&secretUser); err != nil {
+		t.Fatalf("Failed to enroll secretUser: [%s]", err.Error())
+	}
+
+	// only registrars may rotate the secret of another identity
+	req := &pb.SecretResetReq{Id: &pb.Identity{Id: testUser.enrollID}, Subject: &pb.Identity{Id: secretUser.enrollID}, Ts: requestTime()}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+	if _, err := ecaa.ResetSecret(context.Background(), req); err == nil {
+		t.Fatal("Only registrars should be able to reset a secret")
+	}
+
+	// requests out of the allowed clock skew are rejected
+	req = &pb.SecretResetReq{Id: &pb.Identity{Id: testAdmin.enrollID}, Subject: &pb.Identity{Id: secretUser.enrollID}, Ts: &google_protobuf.Timestamp{Seconds: time.Now().Add(-time.Hour).Unix()}}
+	req.Sig = signRequest(t, testAdmin.enrollPrivKey, req)
+	if _, err := ecaa.ResetSecret(context.Background(), req); err == nil {
+		t.Fatal("A request out of the allowed clock skew should be rejected")
+	}
+
+	serials, err := eca.readCertificateSerials(secretUser.enrollID)
+	if err != nil || len(serials) == 0 {
+		t.Fatalf("Failed reading the certificates of secretUser: [%v]", err)
+	}
+
+	req = &pb.SecretResetReq{Id: &pb.Identity{Id: testAdmin.enrollID}, Subject: &pb.Identity{Id: secretUser.enrollID}, Ts: requestTime()}
+	sig := signRequest(t, testAdmin.enrollPrivKey, req)
+	req.Sig = sig
+	tok, err := ecaa.ResetSecret(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed resetting the secret: [%s]", err.Error())
+	}
+	if bytes.Equal(tok.Tok, secretUser.enrollPwd) {
+		t.Fatal("The secret should have been rotated")
+	}
+
+	// the discarded certificates are revoked
+	for _, serial := range serials {
+		var count int
+		if err = eca.db.QueryRow("SELECT count(row) FROM Revocations WHERE serial=?", serial).Scan(&count); err != nil || count != 1 {
+			t.Fatalf("The certificate %s should have been revoked: [%v]", serial, err)
+		}
+	}
+
+	// a request is served once
+	req.Sig = sig
+	if _, err = ecaa.ResetSecret(context.Background(), req); err == nil || err.Error() != "Request already served." {
+		t.Fatalf("A replayed request should be rejected, got %v", err)
+	}
+
+	// the identity enrolls anew with the new secret only
+	if err = enrollUser(&secretUser); err == nil {
+		t.Fatal("The previous secret should no longer be accepted")
+	}
+	secretUser.enrollPwd = tok.Tok
+	if err = enrollUser(&secretUser); err != nil {
+		t.Fatalf("Failed to enroll with the new secret: [%s]", err.Error())
+	}
+}
+
+func TestUpdateAttributesReplay(t *testing.T) {
+	viper.Set("aca.enabled", true)
+	defer viper.Set("aca.enabled", false)
+	ecaa := &ECAA{eca}
+
+	newRequest := func(ts *google_protobuf.Timestamp) *pb.AttributesUpdateReq {
+		req := &pb.AttributesUpdateReq{
+			Id:         &pb.Identity{Id: testAdmin.enrollID},
+			Subject:    &pb.Identity{Id: secretUser.enrollID},
+			Attributes: []*pb.ACAAttribute{{AttributeName: "position", AttributeValue: []byte("Manager")}},
+			Ts:         ts,
+		}
+		req.Sig = signRequest(t, testAdmin.enrollPrivKey, req)
+		return req
+	}
+
+	// requests out of the allowed clock skew are rejected
+	if _, err := ecaa.UpdateAttributes(context.Background(), newRequest(&google_protobuf.Timestamp{Seconds: time.Now().Add(-time.Hour).Unix()})); err == nil {
+		t.Fatal("A request out of the allowed clock skew should be rejected")
+	}
+
+	req := newRequest(requestTime())
+	sig := req.Sig
+	if _, err := ecaa.UpdateAttributes(context.Background(), req); err != nil {
+		t.Fatalf("Failed updating the attributes: [%s]", err.Error())
+	}
+
+	// a request is served once
+	req.Sig = sig
+	if _, err := ecaa.UpdateAttributes(context.Background(), req); err == nil || err.Error() != "Request already served." {
+		t.Fatalf("A replayed request should be rejected, got %v", err)
+	}
+}
+
+func TestIssuanceLimits(t *testing.T) {
+	policies := eca.policies
+	defer func() { eca.policies = policies }()
+
+	eca.policies = []*issuancePolicy{
+		{name: "all", affiliation: "banks_and_institutions", ecertValidity: 48 * time.Hour, tcertMaxBatchSize: 10},
+		{name: "clients", role: pb.Role_CLIENT, tcertMaxBatchSize: 20, keyTypes: []string{"ed25519"}},
+		{name: "institution_a", affiliation: "institution_a", tcertValidity: time.Hour},
+		{name: "institution_a_clients", affiliation: "institution_a", role: pb.Role_CLIENT, tcertMaxBatchSize: 5},
+		{name: "banks", affiliation: "banks", ecertValidity: time.Hour},
+	}
+
+	limits, err := eca.issuanceLimits(testUser.enrollID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if limits.ecertValidity != 48*time.Hour {
+		t.Fatalf("Unexpected ECert validity period: [%s]", limits.ecertValidity)
+	}
+	if limits.tcertValidity != time.Hour {
+		t.Fatalf("Unexpected TCert validity period: [%s]", limits.tcertValidity)
+	}
+	if limits.tcertMaxBatchSize != 5 {
+		t.Fatalf("Unexpected TCert batch size: [%d]", limits.tcertMaxBatchSize)
+	}
+	if limits.allowsKeyType("ecdsa") || !limits.allowsKeyType("ed25519") {
+		t.Fatalf("Unexpected key types: [%v]", limits.keyTypes)
+	}
+
+	// the global settings apply to identities no policy applies to
+	eca.policies = eca.policies[4:]
+	limits, err = eca.issuanceLimits(testUser.enrollID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if limits.ecertValidity != defaultECertValidityPeriod || limits.tcertValidity != getTCertValidityPeriod() ||
+		limits.tcertMaxBatchSize != getTCertMaxBatchSize() || !limits.allowsKeyType("ecdsa") {
+		t.Fatal("The global settings should apply")
 	}
 }
@@ -17,18 +17,20 @@ limitations under the License.
 package ca
 
 import (
-	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
-	"math/big"
+	"fmt"
+	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 	"golang.org/x/net/context"
 )
 
+// maxBulkRegistration is the maximum number of users of a RegisterUsers call.
+const maxBulkRegistration = 1000
+
 // ECAA serves the administrator GRPC interface of the ECA.
 //
 type ECAA struct {
@@ -38,11 +40,12 @@ type ECAA struct {
 // RegisterUser registers a new user with the ECA.  If the user had been registered before
 // an error is returned.
 //
-func (ecaa *ECAA) RegisterUser(ctx context.Context, in *pb.RegisterUserReq) (*pb.Token, error) {
+func (ecaa *ECAA) RegisterUser(ctx context.Context, in *pb.RegisterUserReq) (resp *pb.Token, err error) {
 	Trace.Println("gRPC ECAA:RegisterUser")
+	defer observeRequest("eca", "register", time.Now(), &err)
 
 	// Check the signature
-	err := ecaa.checkRegistrarSignature(in)
+	err = ecaa.checkRegistrarSignature(in)
 	if err != nil {
 		return nil, err
 	}
@@ -50,12 +53,10 @@ func (ecaa *ECAA) RegisterUser(ctx context.Context, in *pb.RegisterUserReq) (*pb
 	// Register the user
 	registrarID := in.Registrar.Id.Id
 	in.Registrar.Id = nil
-	registrar := pb.RegisterUserReq{Registrar: in.Registrar}
-	json, err := json.Marshal(registrar)
+	jsonStr, err := memberMetadataOf(in.Registrar)
 	if err != nil {
 		return nil, err
 	}
-	jsonStr := string(json)
 	Trace.Println("gRPC ECAA:RegisterUser: json=" + jsonStr)
 	tok, err := ecaa.eca.registerUser(in.Id.Id, in.Account, in.Affiliation, in.Role, registrarID, jsonStr)
 
@@ -64,6 +65,79 @@ func (ecaa *ECAA) RegisterUser(ctx context.Context, in *pb.RegisterUserReq) (*pb
 
 }
 
+// RegisterUsers registers many users with the ECA in one call on behalf of a single registrar,
+// whose signature covers the whole request. Each user is registered as by RegisterUser; the
+// users that cannot be registered are reported in their result and do not fail the others.
+//
+func (ecaa *ECAA) RegisterUsers(ctx context.Context, in *pb.RegisterUsersReq) (*pb.RegisterUsersResp, error) {
+	Trace.Println("gRPC ECAA:RegisterUsers")
+
+	if in.Registrar == nil || in.Registrar.Id == nil || in.Registrar.Id.Id == "" {
+		return nil, errors.New("no registrar was specified")
+	}
+	if len(in.Users) > maxBulkRegistration {
+		return nil, fmt.Errorf("At most %d users can be registered in one call.", maxBulkRegistration)
+	}
+
+	// Check the signature
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	registrarID := in.Registrar.Id.Id
+	if err := ecaa.eca.verifyEnrollmentSignature(registrarID, raw, sig); err != nil {
+		Trace.Printf("gRPC ECAA:RegisterUsers: signature failure for %s\n", registrarID)
+		return nil, err
+	}
+
+	// Register the users
+	results := make([]*pb.RegisterUserResult, len(in.Users))
+	for i, user := range in.Users {
+		results[i] = ecaa.registerBulkUser(registrarID, user)
+	}
+
+	return &pb.RegisterUsersResp{Results: results}, nil
+}
+
+// registerBulkUser registers user, an entry of a RegisterUsers request, on behalf of registrar.
+//
+func (ecaa *ECAA) registerBulkUser(registrar string, user *pb.RegisterUserReq) *pb.RegisterUserResult {
+	if user == nil || user.Id == nil || user.Id.Id == "" {
+		return &pb.RegisterUserResult{Error: "no identity was specified"}
+	}
+	result := &pb.RegisterUserResult{Id: &pb.Identity{Id: user.Id.Id}}
+
+	if user.Registrar != nil {
+		user.Registrar.Id = nil
+	}
+	jsonStr, err := memberMetadataOf(user.Registrar)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	tok, err := ecaa.eca.registerUser(user.Id.Id, user.Account, user.Affiliation, user.Role, registrar, jsonStr)
+	if err != nil {
+		Trace.Printf("gRPC ECAA:RegisterUsers: failed registering %s: %s\n", user.Id.Id, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Tok = &pb.Token{Tok: []byte(tok)}
+	return result
+}
+
+// memberMetadataOf returns the member metadata, granting the registrar privileges of registrar,
+// of a user to register.
+//
+func memberMetadataOf(registrar *pb.Registrar) (string, error) {
+	raw, err := json.Marshal(pb.RegisterUserReq{Registrar: registrar})
+	if err != nil {
+		return "", err
+	}
+
+	return string(raw), nil
+}
+
 func (ecaa *ECAA) checkRegistrarSignature(in *pb.RegisterUserReq) error {
 	Trace.Println("ECAA.checkRegistrarSignature")
 
@@ -91,16 +165,10 @@ func (ecaa *ECAA) checkRegistrarSignature(in *pb.RegisterUserReq) error {
 	in.Sig = nil
 
 	// Marshall the raw bytes
-	r, s := big.NewInt(0), big.NewInt(0)
-	r.UnmarshalText(sig.R)
-	s.UnmarshalText(sig.S)
-
-	hash := primitives.NewHash()
 	raw, _ = proto.Marshal(in)
-	hash.Write(raw)
 
 	// Check the signature
-	if ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
+	if !verifySignature(cert.PublicKey, raw, sig) {
 		// Signature verification failure
 		Trace.Printf("ECAA.checkRegistrarSignature: failure for %s\n", registrar)
 		return errors.New("Signature verification failed.")
@@ -133,14 +201,8 @@ func (ecaa *ECAA) ReadUserSet(ctx context.Context, in *pb.ReadUserSetReq) (*pb.U
 	sig := in.Sig
 	in.Sig = nil
 
-	r, s := big.NewInt(0), big.NewInt(0)
-	r.UnmarshalText(sig.R)
-	s.UnmarshalText(sig.S)
-
-	hash := primitives.NewHash()
 	raw, _ = proto.Marshal(in)
-	hash.Write(raw)
-	if ecdsa.Verify(cert.PublicKey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
+	if !verifySignature(cert.PublicKey, raw, sig) {
 		return nil, errors.New("Signature verification failed.")
 	}
 
@@ -165,18 +227,272 @@ func (ecaa *ECAA) ReadUserSet(ctx context.Context, in *pb.ReadUserSetReq) (*pb.U
 	return &pb.UserSet{Users: users}, err
 }
 
-// RevokeCertificate revokes a certificate from the ECA.  Not yet implemented.
+// RevokeCertificate revokes any enrollment certificate from the ECA. The requester must be an auditor.
 //
-func (ecaa *ECAA) RevokeCertificate(context.Context, *pb.ECertRevokeReq) (*pb.CAStatus, error) {
+func (ecaa *ECAA) RevokeCertificate(ctx context.Context, in *pb.ECertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC ECAA:RevokeCertificate")
 
-	return nil, errors.New("ECAA:RevokeCertificate method not (yet) implemented")
+	if in.Id == nil || in.Cert == nil {
+		return nil, errors.New("Identity and certificate are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkAuditorSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	invalidAt, err := revocationTime(in.Effective)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ecaa.eca.revokeCertificate(in.Id.Id, in.Cert.Cert, true, in.Reason, invalidAt); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
 }
 
-// PublishCRL requests the creation of a certificate revocation list from the ECA.  Not yet implemented.
+// RevokeIdentity revokes all the enrollment certificates of an identity from the ECA, and its
+// unexpired transaction certificates from the TCA. The identity can no longer enroll. The
+// requester must be an auditor.
 //
-func (ecaa *ECAA) PublishCRL(context.Context, *pb.ECertCRLReq) (*pb.CAStatus, error) {
+func (ecaa *ECAA) RevokeIdentity(ctx context.Context, in *pb.IdentityRevokeReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:RevokeIdentity")
+
+	if in.Id == nil || in.Subject == nil {
+		return nil, errors.New("Identity and subject are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkAuditorSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	invalidAt, err := revocationTime(in.Effective)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ecaa.eca.revokeIdentity(in.Subject.Id, in.Reason, invalidAt); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// PublishCRL requests the creation of a certificate revocation list from the ECA. The requester must be an auditor.
+//
+func (ecaa *ECAA) PublishCRL(ctx context.Context, in *pb.ECertCRLReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC ECAA:CreateCRL")
 
-	return nil, errors.New("ECAA:PublishCRL method not (yet) implemented")
+	if in.Id == nil {
+		return nil, errors.New("Identity is required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkAuditorSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if _, err := ecaa.eca.generateCRL(); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// ReadIssuedCertificates returns the certificates issued by the ECA matching the filter of the
+// request, e.g. the ones issued to an identity. The requester must be an auditor.
+//
+func (ecaa *ECAA) ReadIssuedCertificates(ctx context.Context, in *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+	Trace.Println("gRPC ECAA:ReadIssuedCertificates")
+
+	return ecaa.eca.readIssuedCertificateSet(ecaa.eca, in)
+}
+
+// ResetSecret rotates the one-time password of an identity, which can then enroll anew. The
+// requester must be a registrar allowed to register the identity, or the identity itself. The
+// request must be no older than the allowed clock skew, and is served once.
+//
+func (ecaa *ECAA) ResetSecret(ctx context.Context, in *pb.SecretResetReq) (*pb.Token, error) {
+	Trace.Println("gRPC ECAA:ResetSecret")
+
+	if in.Id == nil || in.Subject == nil || in.Ts == nil {
+		return nil, errors.New("Identity, subject and timestamp are required.")
+	}
+	if err := checkRequestTime(in.Ts); err != nil {
+		return nil, err
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+	if err := ecaa.eca.checkRequestReplay(in.Id.Id, raw, in.Ts); err != nil {
+		return nil, err
+	}
+
+	tok, err := ecaa.eca.resetSecret(in.Id.Id, in.Subject.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Token{Tok: []byte(tok)}, nil
+}
+
+// RefreshAttributes has the ACA fetch anew the attributes of an identity from the attribute
+// source, e.g. after they were updated there. The requester must be a registrar allowed to
+// register the identity, or the identity itself.
+//
+func (ecaa *ECAA) RefreshAttributes(ctx context.Context, in *pb.AttributesRefreshReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:RefreshAttributes")
+
+	if in.Id == nil || in.Subject == nil {
+		return nil, errors.New("Identity and subject are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if err := ecaa.eca.refreshAttributes(in.Id.Id, in.Subject.Id); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// UpdateAttributes changes the attributes of an identity, overriding its attribute source, and
+// flags the TCerts issued to it for refresh. The requester must be a registrar allowed to
+// register the identity. The request must be no older than the allowed clock skew, and is
+// served once, so that a replay cannot restore attributes a later update changed.
+//
+func (ecaa *ECAA) UpdateAttributes(ctx context.Context, in *pb.AttributesUpdateReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:UpdateAttributes")
+
+	if in.Id == nil || in.Subject == nil || in.Ts == nil {
+		return nil, errors.New("Identity, subject and timestamp are required.")
+	}
+	if in.Id.Id == in.Subject.Id {
+		return nil, errors.New("Identities cannot change their own attributes.")
+	}
+	if err := checkRequestTime(in.Ts); err != nil {
+		return nil, err
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+	if err := ecaa.eca.checkRequestReplay(in.Id.Id, raw, in.Ts); err != nil {
+		return nil, err
+	}
+
+	if err := ecaa.eca.updateAttributes(in.Id.Id, in.Subject.Id, in.Attributes); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// CreateAffiliation registers a new affiliation group with the ECA. The requester must be a
+// registrar, and the parent group within the subtree of its own affiliation group.
+//
+func (ecaa *ECAA) CreateAffiliation(ctx context.Context, in *pb.AffiliationCreateReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:CreateAffiliation")
+
+	if in.Id == nil || in.Affiliation == nil {
+		return nil, errors.New("Identity and affiliation are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if err := ecaa.eca.createAffiliation(in.Id.Id, in.Affiliation.Name, in.Affiliation.Parent); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// ReadAffiliations returns the affiliation groups within the subtree of the requester, which
+// must be a registrar.
+//
+func (ecaa *ECAA) ReadAffiliations(ctx context.Context, in *pb.AffiliationReadReq) (*pb.AffiliationSet, error) {
+	Trace.Println("gRPC ECAA:ReadAffiliations")
+
+	if in.Id == nil {
+		return nil, errors.New("Identity is required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	affiliations, err := ecaa.eca.readAffiliations(in.Id.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AffiliationSet{Affiliations: affiliations}, nil
+}
+
+// UpdateAffiliation renames an affiliation group and moves it under another parent. The
+// requester must be a registrar, and both groups strictly within its subtree. Groups with
+// members in their subtree cannot be changed.
+//
+func (ecaa *ECAA) UpdateAffiliation(ctx context.Context, in *pb.AffiliationUpdateReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:UpdateAffiliation")
+
+	if in.Id == nil || in.Affiliation == nil {
+		return nil, errors.New("Identity and affiliation are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if err := ecaa.eca.updateAffiliation(in.Id.Id, in.Name, in.Affiliation.Name, in.Affiliation.Parent); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// DeleteAffiliation deletes an affiliation group without child groups nor members. The
+// requester must be a registrar, and the group strictly within its subtree.
+//
+func (ecaa *ECAA) DeleteAffiliation(ctx context.Context, in *pb.AffiliationDeleteReq) (*pb.CAStatus, error) {
+	Trace.Println("gRPC ECAA:DeleteAffiliation")
+
+	if in.Id == nil {
+		return nil, errors.New("Identity is required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecaa.eca.checkRegistrarRequest(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if err := ecaa.eca.deleteAffiliation(in.Id.Id, in.Name); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
 }
@@ -19,10 +19,13 @@ package ca
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"database/sql"
 	"errors"
+	"fmt"
 	"google/protobuf"
 	"math/big"
 	"strconv"
@@ -51,7 +54,18 @@ func (ecap *ECAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.Cert
 	return &pb.Cert{Cert: ecap.eca.raw}, nil
 }
 
-func (ecap *ECAP) fetchAttributes(cert *pb.Cert) error {
+// ReadCACertificateChain reads the certificate chain of the ECA, from its certificate up to the root.
+//
+func (ecap *ECAP) ReadCACertificateChain(ctx context.Context, in *pb.Empty) (*pb.CertChain, error) {
+	Trace.Println("gRPC ECAP:ReadCACertificateChain")
+
+	return &pb.CertChain{Certs: ecap.eca.chain}, nil
+}
+
+// fetchAttributes has the ACA fetch the attributes of the owner of cert, after recording
+// the updates of its attributes, if any.
+//
+func (ecap *ECAP) fetchAttributes(cert *pb.Cert, updates ...*pb.ACAAttribute) error {
 	//TODO we are creating a new client connection per each ecert request. We should implement a connections pool.
 	sock, acaP, err := GetACAClient()
 	if err != nil {
@@ -60,9 +74,10 @@ func (ecap *ECAP) fetchAttributes(cert *pb.Cert) error {
 	defer sock.Close()
 
 	req := &pb.ACAFetchAttrReq{
-		Ts:        &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
-		ECert:     cert,
-		Signature: nil}
+		Ts:         &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		ECert:      cert,
+		Signature:  nil,
+		Attributes: updates}
 
 	var rawReq []byte
 	rawReq, err = proto.Marshal(req)
@@ -72,7 +87,7 @@ func (ecap *ECAP) fetchAttributes(cert *pb.Cert) error {
 
 	var r, s *big.Int
 
-	r, s, err = primitives.ECDSASignDirect(ecap.eca.priv, rawReq)
+	r, s, err = ecap.eca.signDirect(rawReq)
 
 	if err != nil {
 		return err
@@ -91,29 +106,22 @@ func (ecap *ECAP) fetchAttributes(cert *pb.Cert) error {
 	if resp.Status != pb.ACAFetchAttrResp_FAILURE {
 		return nil
 	}
+	if resp.Msg != "" {
+		return errors.New("Error fetching attributes: " + resp.Msg)
+	}
 	return errors.New("Error fetching attributes.")
 }
 
 // CreateCertificatePair requests the creation of a new enrollment certificate pair by the ECA.
 //
-func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateReq) (*pb.ECertCreateResp, error) {
+func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateReq) (resp *pb.ECertCreateResp, err error) {
 	Trace.Println("gRPC ECAP:CreateCertificate")
-
-	// validate token
-	var tok, prev []byte
-	var role, state int
-	var enrollID string
+	defer observeRequest("eca", "enroll", time.Now(), &err)
 
 	id := in.Id.Id
-	err := ecap.eca.readUser(id).Scan(&role, &tok, &state, &prev, &enrollID)
+	e, err := ecap.authenticateEnrollment(ctx, id, in.Tok)
 	if err != nil {
-		errMsg := "Identity lookup error: " + err.Error()
-		Trace.Println(errMsg)
-		return nil, errors.New(errMsg)
-	}
-	if !bytes.Equal(tok, in.Tok.Tok) {
-		Trace.Printf("id or token mismatch: id=%s\n", id)
-		return nil, errors.New("Identity or token does not match.")
+		return nil, err
 	}
 
 	ekey, err := x509.ParsePKIXPublicKey(in.Enc.Key)
@@ -121,13 +129,12 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 		return nil, err
 	}
 
-	fetchResult := pb.FetchAttrsResult{Status: pb.FetchAttrsResult_SUCCESS, Msg: ""}
 	switch {
-	case state == 0:
+	case e.state == 0:
 		// initial request, create encryption challenge
-		tok = []byte(randomString(12))
+		tok := []byte(randomString(12))
 
-		_, err = ecap.eca.db.Exec("UPDATE Users SET token=?, state=?, key=? WHERE id=?", tok, 1, in.Enc.Key, id)
+		err = ecap.eca.consumeSecret(id, tok, in.Enc.Key, 1)
 		if err != nil {
 			Error.Println(err)
 			return nil, err
@@ -148,9 +155,9 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 
 		return &pb.ECertCreateResp{Certs: nil, Chain: nil, Pkchain: nil, Tok: &pb.Token{Tok: out}}, err
 
-	case state == 1:
+	case e.state == 1:
 		// ensure that the same encryption key is signed that has been used for the challenge
-		if subtle.ConstantTimeCompare(in.Enc.Key, prev) != 1 {
+		if subtle.ConstantTimeCompare(in.Enc.Key, e.prev) != 1 {
 			return nil, errors.New("Encryption keys do not match.")
 		}
 
@@ -158,71 +165,201 @@ func (ecap *ECAP) CreateCertificatePair(ctx context.Context, in *pb.ECertCreateR
 		sig := in.Sig
 		in.Sig = nil
 
-		r, s := big.NewInt(0), big.NewInt(0)
-		r.UnmarshalText(sig.R)
-		s.UnmarshalText(sig.S)
-
-		if in.Sign.Type != pb.CryptoType_ECDSA {
-			return nil, errors.New("Unsupported (signing) key type.")
-		}
-		skey, err := x509.ParsePKIXPublicKey(in.Sign.Key)
+		skey, scheme, err := parseSigningKey(in.Sign, sig)
 		if err != nil {
 			return nil, err
 		}
 
-		hash := primitives.NewHash()
 		raw, _ := proto.Marshal(in)
-		hash.Write(raw)
-		if ecdsa.Verify(skey.(*ecdsa.PublicKey), hash.Sum(nil), r, s) == false {
+		if !verifySignature(skey, raw, sig) {
 			return nil, errors.New("Signature verification failed.")
 		}
 
-		// create new certificate pair
-		ts := time.Now().Add(-1 * time.Minute).UnixNano()
-
-		spec := NewDefaultCertificateSpecWithCommonName(id, enrollID, skey.(*ecdsa.PublicKey), x509.KeyUsageDigitalSignature, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
-		sraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil, true)
-		if err != nil {
+		// consume the challenge before issuing the certificates, so that concurrent requests,
+		// possibly served by other replicas of the ECA, get a single certificate pair
+		if err = ecap.eca.transitionUser("UPDATE Users SET state=? WHERE id=? AND state=?", 2, id, 1); err != nil {
 			Error.Println(err)
 			return nil, err
 		}
-
-		spec = NewDefaultCertificateSpecWithCommonName(id, enrollID, ekey.(*ecdsa.PublicKey), x509.KeyUsageDataEncipherment, pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(ecap.eca.readRole(id)))})
-		eraw, err := ecap.eca.createCertificateFromSpec(spec, ts, nil, true)
-		if err != nil {
+		abort := func(err error) (*pb.ECertCreateResp, error) {
 			ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
+			ecap.eca.db.Exec("UPDATE Users SET state=? WHERE id=? AND state=?", 1, id, 2)
 			Error.Println(err)
 			return nil, err
 		}
 
-		_, err = ecap.eca.db.Exec("UPDATE Users SET state=? WHERE id=?", 2, id)
+		// create new certificate pair
+		sraw, eraw, err := ecap.eca.issueCertificatePair(id, e.enrollID, skey, scheme, ekey.(*ecdsa.PublicKey))
 		if err != nil {
-			ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
-			Error.Println(err)
+			return abort(err)
+		}
+		ecap.eca.recordEnrollmentSuccess(id, e.source)
+
+		return ecap.certificatePairResponse(e.role, sraw, eraw), nil
+	}
+
+	return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+}
+
+// enrollee is a member authenticated by its one-time password, or by the challenge of its
+// enrollment, as recorded by the ECA along with its encryption key prev.
+//
+type enrollee struct {
+	tok, prev   []byte
+	role, state int
+	enrollID    string
+	source      string
+	directory   bool // authenticated by the directory instead
+}
+
+// authenticateEnrollment admits an enrollment request of id, with context ctx, and checks tok
+// against the one-time password of id or, once the password consumed, the enrollment challenge.
+//
+func (ecap *ECAP) authenticateEnrollment(ctx context.Context, id string, tok *pb.Token) (*enrollee, error) {
+	e := &enrollee{source: requestSource(ctx)}
+	if err := ecap.eca.admitEnrollment(id, e.source); err != nil {
+		return nil, err
+	}
+
+	err := ecap.eca.readUser(id).Scan(&e.role, &e.tok, &e.state, &e.prev, &e.enrollID)
+	if err == sql.ErrNoRows && ecap.eca.directory != nil && tok != nil {
+		// the token of a directory user is its directory password
+		if err = ecap.eca.registerDirectoryUser(id, tok.Tok); err == nil {
+			err = ecap.eca.readUser(id).Scan(&e.role, &e.tok, &e.state, &e.prev, &e.enrollID)
+			e.directory = true
+		}
+	}
+	if err != nil {
+		securityEvent("enrollment.unknown", map[string]interface{}{"id": id, "source": e.source})
+		errMsg := "Identity lookup error: " + err.Error()
+		Trace.Println(errMsg)
+		return nil, errors.New(errMsg)
+	}
+	if e.state == 0 {
+		if err = ecap.eca.checkSecret(id, e.source); err != nil {
 			return nil, err
 		}
+	}
+	if tok == nil || !e.directory && !bytes.Equal(e.tok, tok.Tok) {
+		Trace.Printf("id or token mismatch: id=%s\n", id)
+		ecap.eca.recordEnrollmentFailure(id, e.source)
+		return nil, errors.New("Identity or token does not match.")
+	}
+
+	return e, nil
+}
 
-		var obcECKey []byte
-		if role == int(pb.Role_VALIDATOR) {
-			obcECKey = ecap.eca.obcPriv
-		} else {
-			obcECKey = ecap.eca.obcPub
+// parseSigningKey parses the signing key pub, to be certified, that made sig, and returns it with
+// its signature scheme.
+//
+func parseSigningKey(pub *pb.PublicKey, sig *pb.Signature) (interface{}, string, error) {
+	if pub == nil {
+		return nil, "", errors.New("Signing key is required.")
+	}
+	skey, err := x509.ParsePKIXPublicKey(pub.Key)
+	if err != nil {
+		return nil, "", err
+	}
+	scheme, ok := ecertSignatureSchemes[pub.Type]
+	if !ok || sig == nil || sig.Type != pub.Type {
+		return nil, "", errors.New("Unsupported (signing) key type.")
+	}
+	if rsaKey, ok := skey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < primitives.RSAMinKeySize {
+		return nil, "", fmt.Errorf("RSA (signing) keys must be at least %d bits.", primitives.RSAMinKeySize)
+	}
+
+	return skey, scheme, nil
+}
+
+// certSignatureScheme returns the signature scheme recorded in the enrollment certificate cert.
+// Certificates issued before schemes were recorded are ECDSA ones.
+//
+func certSignatureScheme(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ECertSignatureScheme) {
+			return string(ext.Value)
 		}
-		if role == int(pb.Role_CLIENT) {
-			//Only client have to fetch attributes.
-			if viper.GetBool("aca.enabled") {
-				err = ecap.fetchAttributes(&pb.Cert{Cert: sraw})
-				if err != nil {
-					fetchResult = pb.FetchAttrsResult{Status: pb.FetchAttrsResult_FAILURE, Msg: err.Error()}
-
-				}
+	}
+
+	return ecertSignatureSchemes[pb.CryptoType_ECDSA]
+}
+
+// certificatePairSpecs returns the specifications of the enrollment certificates of id, for
+// signing with skey under scheme and for encrypting with ekey.
+//
+func (eca *ECA) certificatePairSpecs(id, enrollID string, skey interface{}, scheme string, ekey *ecdsa.PublicKey) (*CertificateSpec, *CertificateSpec, error) {
+	affiliation, err := eca.affiliationExtensions(enrollID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limits, err := eca.issuanceLimits(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !limits.allowsKeyType(scheme) {
+		return nil, nil, errors.New("Unsupported (signing) key type " + scheme + " for this identity.")
+	}
+
+	subjectRole := pkix.Extension{Id: ECertSubjectRole, Critical: true, Value: []byte(strconv.Itoa(eca.readRole(id)))}
+	sext := append([]pkix.Extension{subjectRole, {Id: ECertSignatureScheme, Critical: false, Value: []byte(scheme)}}, affiliation...)
+	eext := append([]pkix.Extension{subjectRole}, affiliation...)
+
+	notBefore := time.Now().Add(-1 * time.Minute)
+	notAfter := notBefore.Add(limits.ecertValidity)
+	sspec := NewCertificateSpec(id, enrollID, newSerialNumber(), skey, x509.KeyUsageDigitalSignature, &notBefore, &notAfter, sext...)
+	sspec.profile = eca.certificateProfile(profileECert, pb.Role(eca.readRole(id)))
+
+	return sspec, NewCertificateSpec(id, enrollID, newSerialNumber(), ekey, x509.KeyUsageDataEncipherment, &notBefore, &notAfter, eext...), nil
+}
+
+// issueCertificatePair issues the enrollment certificate pair of id for signing with skey under
+// scheme and for encrypting with ekey, and records it.
+//
+func (eca *ECA) issueCertificatePair(id, enrollID string, skey interface{}, scheme string, ekey *ecdsa.PublicKey) ([]byte, []byte, error) {
+	ts := time.Now().Add(-1 * time.Minute).UnixNano()
+
+	sspec, espec, err := eca.certificatePairSpecs(id, enrollID, skey, scheme, ekey)
+	if err != nil {
+		return nil, nil, err
+	}
+	sraw, err := eca.createCertificateFromSpec(sspec, ts, nil, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	eraw, err := eca.createCertificateFromSpec(espec, ts, nil, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = eca.recordCertificatePair(id, sraw, eraw); err != nil {
+		return nil, nil, err
+	}
+
+	return sraw, eraw, nil
+}
+
+// certificatePairResponse returns the response carrying the new enrollment certificate pair of a
+// member of role, along with the chain key. The attributes of clients are fetched by the ACA.
+//
+func (ecap *ECAP) certificatePairResponse(role int, sraw, eraw []byte) *pb.ECertCreateResp {
+	var obcECKey []byte
+	if role == int(pb.Role_VALIDATOR) {
+		obcECKey = ecap.eca.obcPriv
+	} else {
+		obcECKey = ecap.eca.obcPub
+	}
+
+	fetchResult := pb.FetchAttrsResult{Status: pb.FetchAttrsResult_SUCCESS, Msg: ""}
+	if role == int(pb.Role_CLIENT) {
+		//Only client have to fetch attributes.
+		if viper.GetBool("aca.enabled") {
+			if err := ecap.fetchAttributes(&pb.Cert{Cert: sraw}); err != nil {
+				fetchResult = pb.FetchAttrsResult{Status: pb.FetchAttrsResult_FAILURE, Msg: err.Error()}
 			}
 		}
-
-		return &pb.ECertCreateResp{Certs: &pb.CertPair{Sign: sraw, Enc: eraw}, Chain: &pb.Token{Tok: ecap.eca.obcKey}, Pkchain: obcECKey, Tok: nil, FetchResult: &fetchResult}, nil
 	}
 
-	return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+	return &pb.ECertCreateResp{Certs: &pb.CertPair{Sign: sraw, Enc: eraw}, Chain: &pb.Token{Tok: ecap.eca.obcKey}, Pkchain: obcECKey, Tok: nil, FetchResult: &fetchResult}
 }
 
 // ReadCertificatePair reads an enrollment certificate pair from the ECA.
@@ -231,24 +368,27 @@ func (ecap *ECAP) ReadCertificatePair(ctx context.Context, in *pb.ECertReadReq)
 	Trace.Println("gRPC ECAP:ReadCertificate")
 
 	rows, err := ecap.eca.readCertificates(in.Id.Id)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	hasResults := false
 	var certs [][]byte
-	if err == nil {
-		for rows.Next() {
-			hasResults = true
-			var raw []byte
-			err = rows.Scan(&raw)
-			certs = append(certs, raw)
+	for rows.Next() {
+		var raw, kdfKey []byte
+		if err = rows.Scan(&raw, &kdfKey); err != nil {
+			return nil, err
 		}
-		err = rows.Err()
+		certs = append(certs, raw)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	if !hasResults {
+	if len(certs) < 2 {
 		return nil, errors.New("No certificates for the given identity were found.")
 	}
-	return &pb.CertPair{Sign: certs[0], Enc: certs[1]}, err
+	return &pb.CertPair{Sign: certs[0], Enc: certs[1]}, nil
 }
 
 // ReadCertificateByHash reads a single enrollment certificate by hash from the ECA.
@@ -260,10 +400,263 @@ func (ecap *ECAP) ReadCertificateByHash(ctx context.Context, hash *pb.Hash) (*pb
 	return &pb.Cert{Cert: raw}, err
 }
 
-// RevokeCertificatePair revokes a certificate pair from the ECA.  Not yet implemented.
+// RevokeCertificatePair revokes an enrollment certificate of the requester from the ECA.
 //
-func (ecap *ECAP) RevokeCertificatePair(context.Context, *pb.ECertRevokeReq) (*pb.CAStatus, error) {
+func (ecap *ECAP) RevokeCertificatePair(ctx context.Context, in *pb.ECertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("gRPC ECAP:RevokeCertificate")
 
-	return nil, errors.New("ECAP:RevokeCertificate method not (yet) implemented")
+	if in.Id == nil || in.Cert == nil {
+		return nil, errors.New("Identity and certificate are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := ecap.eca.verifyEnrollmentSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	invalidAt, err := revocationTime(in.Effective)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ecap.eca.revokeCertificate(in.Id.Id, in.Cert.Cert, false, in.Reason, invalidAt); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
+}
+
+// ReadCRL reads the current certificate revocation list of the ECA.
+//
+func (ecap *ECAP) ReadCRL(ctx context.Context, in *pb.Empty) (*pb.CRL, error) {
+	Trace.Println("gRPC ECAP:ReadCRL")
+
+	crl, err := ecap.eca.readCRL()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CRL{Crl: crl}, nil
+}
+
+// reenroll exchanges proof of possession of the current enrollment signing key of in.Id
+// for a fresh one-time enrollment token. The request must be signed with that key
+// and be no older than the allowed clock skew. The current certificate pair is discarded:
+// the holder enrolls again, with the returned token, to obtain a new one.
+//
+func (ecap *ECAP) reenroll(in *pb.ECertCreateReq) (*pb.Token, error) {
+	Trace.Println("ECAP:reenroll")
+
+	if in.Id == nil || in.Id.Id == "" || in.Ts == nil {
+		return nil, errors.New("Identity and timestamp are required.")
+	}
+	id := in.Id.Id
+	if ecap.eca.isRevoked(id) {
+		return nil, errors.New("Identity has been revoked.")
+	}
+
+	if err := checkRequestTime(in.Ts); err != nil {
+		return nil, err
+	}
+
+	raw, err := ecap.eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return nil, errors.New("Identity is not enrolled.")
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ = proto.Marshal(in)
+	if !verifySignature(cert.PublicKey, raw, sig) {
+		Trace.Printf("ECAP:reenroll: signature verification failed for %s\n", id)
+		return nil, errors.New("Signature verification failed.")
+	}
+
+	tok := randomString(12)
+	if err = ecap.eca.transitionUser("UPDATE Users SET token=?, state=?, key=?, tokenExpiry=?, tokenAttempts=? WHERE id=? AND state=?", tok, 0, nil, secretExpiry(), 0, id, 2); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	if _, err = ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+
+	return &pb.Token{Tok: []byte(tok)}, nil
+}
+
+// RenewCertificatePair re-enrolls a member authenticated with its current, unexpired and unrevoked,
+// enrollment certificate for signing, without a one-time password. It issues a new certificate pair
+// for the same identity, certifying the new keys of the request or, if unset, the current ones. The
+// new pair replaces the current one at the ECA; the current certificates remain valid until expiry.
+//
+func (ecap *ECAP) RenewCertificatePair(ctx context.Context, in *pb.ECertRenewReq) (resp *pb.ECertCreateResp, err error) {
+	Trace.Println("gRPC ECAP:RenewCertificatePair")
+	defer observeRequest("eca", "renew", time.Now(), &err)
+
+	if in.Id == nil || in.Id.Id == "" || in.Ts == nil {
+		return nil, errors.New("Identity and timestamp are required.")
+	}
+	id := in.Id.Id
+	if ecap.eca.isRevoked(id) {
+		return nil, errors.New("Identity has been revoked.")
+	}
+	if err := checkRequestTime(in.Ts); err != nil {
+		return nil, err
+	}
+
+	var tok, prev []byte
+	var role, state int
+	var enrollID string
+	if err := ecap.eca.readUser(id).Scan(&role, &tok, &state, &prev, &enrollID); err != nil {
+		return nil, errors.New("Identity lookup error: " + err.Error())
+	}
+	if state != 2 {
+		return nil, errors.New("Identity is not enrolled.")
+	}
+
+	// authenticate the request with the current certificate pair
+	scert, err := ecap.eca.readCurrentCertificate(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return nil, err
+	}
+	ecert, err := ecap.eca.readCurrentCertificate(id, x509.KeyUsageDataEncipherment)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, signSig := in.Sig, in.SignSig
+	in.Sig, in.SignSig = nil, nil
+	raw, _ := proto.Marshal(in)
+	if !verifySignature(scert.PublicKey, raw, sig) {
+		Trace.Printf("ECAP:RenewCertificatePair: signature verification failed for %s\n", id)
+		return nil, errors.New("Signature verification failed.")
+	}
+
+	// the keys to certify, along with the proof of possession of a new signing key
+	skey, scheme := scert.PublicKey, certSignatureScheme(scert)
+	if in.Sign != nil {
+		if skey, scheme, err = parseSigningKey(in.Sign, signSig); err != nil {
+			return nil, err
+		}
+		if !verifySignature(skey, raw, signSig) {
+			return nil, errors.New("Signature verification failed.")
+		}
+	}
+	ekey, ok := ecert.PublicKey.(*ecdsa.PublicKey)
+	if in.Enc != nil {
+		key, err := x509.ParsePKIXPublicKey(in.Enc.Key)
+		if err != nil {
+			return nil, err
+		}
+		if ekey, ok = key.(*ecdsa.PublicKey); !ok {
+			return nil, errors.New("Unsupported (encryption) key type.")
+		}
+	}
+	if !ok {
+		return nil, errors.New("Unsupported (encryption) key type.")
+	}
+
+	// issue the new certificate pair in place of the current one
+	ts := time.Now().Add(-1 * time.Minute).UnixNano()
+
+	sspec, espec, err := ecap.eca.certificatePairSpecs(id, enrollID, skey, scheme, ekey)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	sraw, err := ecap.eca.createCertificateFromSpec(sspec, ts, nil, false)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	eraw, err := ecap.eca.createCertificateFromSpec(espec, ts, nil, false)
+	if err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	if err = ecap.eca.recordCertificatePair(id, sraw, eraw); err != nil {
+		return nil, err
+	}
+
+	if err = ecap.eca.replaceCertificatePair(id, ts, sraw, eraw); err != nil {
+		return nil, err
+	}
+
+	Info.Printf("Renewed the enrollment certificates of %s.\n", id)
+
+	return ecap.certificatePairResponse(role, sraw, eraw), nil
+}
+
+// readCurrentCertificate returns the enrollment certificate of id for usage, provided it is neither
+// expired nor revoked.
+//
+func (eca *ECA) readCurrentCertificate(id string, usage x509.KeyUsage) (*x509.Certificate, error) {
+	raw, err := eca.readCertificateByKeyUsage(id, usage)
+	if err != nil {
+		return nil, errors.New("Identity is not enrolled.")
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(cert.NotAfter) {
+		return nil, errors.New("Enrollment certificate has expired.")
+	}
+	if eca.isCertificateRevoked(cert) {
+		return nil, errors.New("Enrollment certificate has been revoked.")
+	}
+
+	return cert, nil
+}
+
+// replaceCertificatePair stores the enrollment certificate pair sraw and eraw, issued at ts, in
+// place of the current one of id.
+//
+func (eca *ECA) replaceCertificatePair(id string, ts int64, sraw, eraw []byte) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tx, err := eca.db.Begin()
+	if err != nil {
+		Error.Println(err)
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM Certificates WHERE id=?", id); err != nil {
+		Error.Println(err)
+		tx.Rollback()
+		return err
+	}
+	for usage, raw := range map[x509.KeyUsage][]byte{x509.KeyUsageDigitalSignature: sraw, x509.KeyUsageDataEncipherment: eraw} {
+		if _, err = tx.Exec("INSERT INTO Certificates (id, timestamp, usage, cert, hash, kdfkey) VALUES (?, ?, ?, ?, ?, ?)", id, ts, usage, raw, primitives.Hash(raw), nil); err != nil {
+			Error.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		Error.Println(err)
+	}
+	return err
+}
+
+// checkRequestTime checks that the timestamp ts of a request is within the allowed clock skew.
+//
+func checkRequestTime(ts *google_protobuf.Timestamp) error {
+	skew := time.Duration(ts.Seconds-time.Now().Unix()) * time.Second
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > viper.GetDuration("server.rest.maxClockSkew") {
+		return errors.New("Request timestamp out of range.")
+	}
+
+	return nil
 }
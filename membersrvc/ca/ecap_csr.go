@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"golang.org/x/net/context"
+)
+
+// Members may also enroll in a single round with PKCS#10 certificate requests for the keys
+// they generated, e.g. with openssl or inside a hardware token: the one-time password
+// authenticates the member, and the signature of each request proves the possession of
+// its private key, which is neither generated by nor sent to the ECA.
+
+// CreateCertificatePairFromCSR creates the enrollment certificate pair of a member from the
+// certificate requests for its signing and encryption keys. Only the keys of the requests
+// are certified: the certificates are issued as by CreateCertificatePair.
+//
+func (ecap *ECAP) CreateCertificatePairFromCSR(ctx context.Context, in *pb.ECertCSRReq) (resp *pb.ECertCreateResp, err error) {
+	Trace.Println("gRPC ECAP:CreateCertificatePairFromCSR")
+	defer observeRequest("eca", "enroll_csr", time.Now(), &err)
+
+	if in.Id == nil {
+		return nil, errors.New("Identity is required.")
+	}
+	id := in.Id.Id
+	e, err := ecap.authenticateEnrollment(ctx, id, in.Tok)
+	if err != nil {
+		return nil, err
+	}
+	if e.state != 0 {
+		return nil, errors.New("Invalid (=expired) certificate creation token provided.")
+	}
+
+	csr, err := parseCertificateRequest(in.Sign)
+	if err != nil {
+		return nil, err
+	}
+	skey, scheme, err := signingRequestKey(csr)
+	if err != nil {
+		return nil, err
+	}
+	if csr, err = parseCertificateRequest(in.Enc); err != nil {
+		return nil, err
+	}
+	ekey, ok := csr.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Unsupported (encryption) key type.")
+	}
+	ekeyRaw, err := x509.MarshalPKIXPublicKey(ekey)
+	if err != nil {
+		return nil, err
+	}
+
+	// consume the one-time password before issuing the certificates, so that concurrent requests,
+	// possibly served by other replicas of the ECA, get a single certificate pair
+	if err = ecap.eca.consumeSecret(id, []byte(randomString(12)), ekeyRaw, 2); err != nil {
+		Error.Println(err)
+		return nil, err
+	}
+	abort := func(err error) (*pb.ECertCreateResp, error) {
+		ecap.eca.db.Exec("DELETE FROM Certificates Where id=?", id)
+		ecap.eca.db.Exec("UPDATE Users SET token=?, state=? WHERE id=? AND state=?", e.tok, 0, id, 2)
+		Error.Println(err)
+		return nil, err
+	}
+
+	sraw, eraw, err := ecap.eca.issueCertificatePair(id, e.enrollID, skey, scheme, ekey)
+	if err != nil {
+		return abort(err)
+	}
+	ecap.eca.recordEnrollmentSuccess(id, e.source)
+
+	return ecap.certificatePairResponse(e.role, sraw, eraw), nil
+}
+
+// parseCertificateRequest parses the PKCS#10 request raw, DER or PEM encoded, and checks its
+// signature, which proves the possession of the private key of the requested key.
+//
+func parseCertificateRequest(raw []byte) (*x509.CertificateRequest, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("Certificate request is required.")
+	}
+	if block, _ := pem.Decode(raw); block != nil {
+		raw = block.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, errors.New("Signature verification of the certificate request failed.")
+	}
+
+	return csr, nil
+}
+
+// signingRequestKey returns the signing key requested by csr, with its signature scheme.
+//
+func signingRequestKey(csr *x509.CertificateRequest) (interface{}, string, error) {
+	switch key := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return key, ecertSignatureSchemes[pb.CryptoType_ECDSA], nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < primitives.RSAMinKeySize {
+			return nil, "", fmt.Errorf("RSA (signing) keys must be at least %d bits.", primitives.RSAMinKeySize)
+		}
+		return key, ecertSignatureSchemes[pb.CryptoType_RSA], nil
+	}
+
+	return nil, "", errors.New("Unsupported (signing) key type.")
+}
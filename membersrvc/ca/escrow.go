@@ -0,0 +1,204 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// The signing key of a CA may be put in escrow by splitting it into shares with
+// Shamir's secret sharing over GF(2^8): any threshold of the shares recovers the
+// key, fewer reveal nothing about it. A share is the threshold, the x coordinate
+// of the share and the y coordinates of the bytes of the key, hex encoded.
+
+// gfMul multiplies a and b in GF(2^8), modulo the AES polynomial x^8 + x^4 + x^3 + x + 1.
+func gfMul(a, b byte) byte {
+	var p byte
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the inverse of a, not zero, in GF(2^8), that is a^254.
+func gfInv(a byte) byte {
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, a)
+	}
+	return inv
+}
+
+// splitSecret splits secret into n shares, any threshold of which recover it.
+//
+func splitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, errors.New("The threshold must be at least 2 and at most the number of shares, at most 255.")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 2, 2+len(secret))
+		shares[i][0] = byte(threshold)
+		shares[i][1] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold-1)
+	for _, b := range secret {
+		if _, err := io.ReadFull(rand.Reader, coefficients); err != nil {
+			return nil, err
+		}
+		for j, share := range shares {
+			// evaluate the polynomial at x with Horner's method
+			x, y := share[1], byte(0)
+			for i := len(coefficients) - 1; i >= 0; i-- {
+				y = gfMul(y, x) ^ coefficients[i]
+			}
+			y = gfMul(y, x) ^ b
+			shares[j] = append(share, y)
+		}
+	}
+	return shares, nil
+}
+
+// combineShares recovers the secret from at least the threshold of its shares.
+//
+func combineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("No share.")
+	}
+	threshold, size := int(shares[0][0]), len(shares[0])
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%d shares are required, %d given.", threshold, len(shares))
+	}
+	shares = shares[:threshold]
+
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != size || int(share[0]) != threshold || share[1] == 0 || seen[share[1]] {
+			return nil, errors.New("The shares do not belong together.")
+		}
+		seen[share[1]] = true
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, size-2)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other[1], gfInv(other[1]^share[1])))
+			}
+		}
+		for k := range secret {
+			secret[k] ^= gfMul(basis, share[2+k])
+		}
+	}
+	return secret, nil
+}
+
+// EscrowKey splits the signing key of the CA name into n hex encoded shares, any
+// threshold of which recover it with RecoverKey.
+//
+func EscrowKey(name string, n, threshold int) ([]string, error) {
+	if isHSMEnabled() {
+		return nil, errors.New("The signing keys of the CAs are held by the PKCS#11 token and cannot be put in escrow.")
+	}
+
+	cooked, err := ioutil.ReadFile(caStatePath() + "/" + name + ".priv")
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(cooked)
+	if block == nil {
+		return nil, fmt.Errorf("Invalid signing key of the %s.", name)
+	}
+
+	shares, err := splitSecret(block.Bytes, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]string, len(shares))
+	for i, share := range shares {
+		encoded[i] = hex.EncodeToString(share)
+	}
+	return encoded, nil
+}
+
+// RecoverKey recovers the signing key of the CA name from the hex encoded shares
+// returned by EscrowKey, checks it against the certificate of the CA, if any, and
+// writes it to the state directory, where the CA has no signing key anymore.
+//
+func RecoverKey(name string, shares []string) error {
+	path := caStatePath() + "/" + name + ".priv"
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("The %s already has a signing key, %s.", name, path)
+	}
+
+	var raw [][]byte
+	for _, share := range shares {
+		b, err := hex.DecodeString(strings.TrimSpace(share))
+		if err != nil || len(b) < 3 {
+			return errors.New("Invalid share.")
+		}
+		raw = append(raw, b)
+	}
+	secret, err := combineShares(raw)
+	if err != nil {
+		return err
+	}
+	priv, err := x509.ParseECPrivateKey(secret)
+	if err != nil {
+		return errors.New("The shares do not recover a signing key.")
+	}
+
+	if cooked, err := ioutil.ReadFile(caStatePath() + "/" + name + ".cert"); err == nil {
+		block, _ := pem.Decode(cooked)
+		if block == nil {
+			return fmt.Errorf("Invalid certificate of the %s.", name)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok || pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+			return fmt.Errorf("The recovered key does not match the certificate of the %s.", name)
+		}
+	}
+
+	cooked := pem.EncodeToMemory(&pem.Block{Type: "ECDSA PRIVATE KEY", Bytes: secret})
+	return ioutil.WriteFile(path, cooked, 0600)
+}
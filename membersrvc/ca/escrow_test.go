@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSplitSecret(t *testing.T) {
+	secret := []byte("a secret of some length\x00\xff")
+
+	shares, err := splitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var given [][]byte
+		for _, i := range subset {
+			given = append(given, shares[i])
+		}
+		recovered, err := combineShares(given)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(recovered, secret) {
+			t.Fatalf("Shares %v did not recover the secret", subset)
+		}
+	}
+
+	if _, err = combineShares([][]byte{shares[0], shares[1]}); err == nil {
+		t.Fatal("Fewer shares than the threshold should not recover the secret")
+	}
+	if _, err = combineShares([][]byte{shares[0], shares[0], shares[1]}); err == nil {
+		t.Fatal("A share given twice should be rejected")
+	}
+	if _, err = splitSecret(secret, 2, 3); err == nil {
+		t.Fatal("A threshold above the number of shares should be rejected")
+	}
+}
+
+func TestEscrowKey(t *testing.T) {
+	shares, err := EscrowKey("eca", 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ioutil.ReadFile(eca.path + "/eca.priv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ioutil.ReadFile(eca.path + "/eca.cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the ECA host is lost but for its certificate
+	defer useCADir(".ca-recovered")()
+	if err = os.MkdirAll(caStatePath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(caStatePath()+"/eca.cert", cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = RecoverKey("eca", shares[:1]); err == nil {
+		t.Fatal("A single share should not recover the key")
+	}
+	if err = RecoverKey("eca", []string{shares[3], shares[1]}); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := ioutil.ReadFile(caStatePath() + "/eca.priv")
+	if err != nil || !bytes.Equal(recovered, priv) {
+		t.Fatal("The signing key of the ECA was not recovered")
+	}
+
+	if err = RecoverKey("eca", shares); err == nil {
+		t.Fatal("The recovered key should not overwrite the signing key of the ECA")
+	}
+}
@@ -0,0 +1,381 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"google/protobuf"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/transport"
+)
+
+// maxRateLimitKeys is the number of identities or sources tracked by a rate
+// limiter beyond which the least recently seen ones are forgotten.
+const maxRateLimitKeys = 10000
+
+// sourceKey is the context key of the network address a request comes from,
+// for requests not received through gRPC.
+type sourceKey struct{}
+
+// bucket is the token bucket of an identity or a source.
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter allows burst attempts per key at once, and burst attempts per
+// interval in the long run. A burst of 0 allows any number of attempts. At
+// most maxKeys buckets are kept, whatever the keys the attempts are made
+// with: the least recently used one is dropped to make room for a new key
+// once refilled, as a new bucket would be. Until then, the attempts of the
+// keys without a bucket share the overflow bucket.
+//
+type rateLimiter struct {
+	burst    float64
+	interval time.Duration
+	maxKeys  int
+	buckets  map[string]*list.Element
+	lru      *list.List
+	overflow bucket
+}
+
+// enrollmentGuard protects the enrollment of the ECA against brute-force
+// attacks on the one-time passwords: it limits the rate of the enrollment
+// attempts of each identity and from each source, and locks identities out
+// after repeated failures. The rates are limited by each replica of the ECA,
+// while the lockouts are recorded in the database.
+//
+type enrollmentGuard struct {
+	mutex       sync.Mutex
+	identities  *rateLimiter
+	sources     *rateLimiter
+	maxFailures int
+	lockout     time.Duration
+}
+
+func initializeEnrollmentGuardTables(db *Database) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS EnrollmentFailures (row INTEGER PRIMARY KEY, id VARCHAR(64) UNIQUE, failures INTEGER, lockedUntil INTEGER)"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS RequestDigests (row INTEGER PRIMARY KEY, digest VARCHAR(64) UNIQUE, expiry INTEGER)"); err != nil {
+		return err
+	}
+
+	// databases created before the one-time passwords expired lack the columns
+	if _, err := db.Exec("SELECT tokenExpiry, tokenAttempts FROM Users WHERE 1=0"); err != nil {
+		if _, err = db.Exec("ALTER TABLE Users ADD COLUMN tokenExpiry INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+		if _, err = db.Exec("ALTER TABLE Users ADD COLUMN tokenAttempts INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newRateLimiter(burst int, interval time.Duration) *rateLimiter {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &rateLimiter{float64(burst), interval, maxRateLimitKeys, make(map[string]*list.Element), list.New(), bucket{}}
+}
+
+// allow consumes an attempt of key at now, and returns false if none is left.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	if l.burst <= 0 || key == "" {
+		return true
+	}
+
+	var b *bucket
+	if elem, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(elem)
+		b = elem.Value.(*bucket)
+	} else if l.lru.Len() < l.maxKeys || l.refill(l.lru.Back().Value.(*bucket), now) >= l.burst {
+		if l.lru.Len() >= l.maxKeys {
+			l.forget(l.lru.Back())
+		}
+		b = &bucket{key, l.burst, now}
+		l.buckets[key] = l.lru.PushFront(b)
+	} else {
+		// forgetting a bucket not refilled yet would let its key start over with a full burst
+		b = &l.overflow
+	}
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill adds to b the attempts earned since its last one, up to the burst, and
+// returns the attempts left.
+func (l *rateLimiter) refill(b *bucket, now time.Time) float64 {
+	b.tokens += now.Sub(b.last).Seconds() / l.interval.Seconds() * l.burst
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	return b.tokens
+}
+
+// forget drops the bucket of elem.
+func (l *rateLimiter) forget(elem *list.Element) {
+	l.lru.Remove(elem)
+	delete(l.buckets, elem.Value.(*bucket).key)
+}
+
+// newEnrollmentGuard reads the eca.enrollment section of the configuration.
+// Unset limits disable the corresponding protection.
+//
+func newEnrollmentGuard() *enrollmentGuard {
+	interval := viper.GetDuration("eca.enrollment.limits.interval")
+	return &enrollmentGuard{
+		identities:  newRateLimiter(viper.GetInt("eca.enrollment.limits.identity"), interval),
+		sources:     newRateLimiter(viper.GetInt("eca.enrollment.limits.source"), interval),
+		maxFailures: viper.GetInt("eca.enrollment.lockout.failures"),
+		lockout:     viper.GetDuration("eca.enrollment.lockout.duration"),
+	}
+}
+
+// admitEnrollment checks that an enrollment attempt of id from source is
+// within the rate limits and that id is not locked out.
+//
+func (eca *ECA) admitEnrollment(id, source string) error {
+	g := eca.guard
+	now := time.Now()
+
+	g.mutex.Lock()
+	identityAllowed := g.identities.allow(id, now)
+	sourceAllowed := identityAllowed && g.sources.allow(source, now)
+	g.mutex.Unlock()
+
+	if !identityAllowed || !sourceAllowed {
+		limit := "identity"
+		if identityAllowed {
+			limit = "source"
+		}
+		securityEvent("enrollment.throttled", map[string]interface{}{"id": id, "source": source, "limit": limit})
+		enrollmentRejected.inc("throttled")
+		return errors.New("Too many enrollment attempts, retry later.")
+	}
+
+	var lockedUntil int64
+	err := eca.db.QueryRow("SELECT lockedUntil FROM EnrollmentFailures WHERE id=?", id).Scan(&lockedUntil)
+	if err == nil && lockedUntil > now.Unix() {
+		securityEvent("enrollment.locked", map[string]interface{}{"id": id, "source": source, "until": time.Unix(lockedUntil, 0).UTC()})
+		enrollmentRejected.inc("locked")
+		return errors.New("Identity is locked out after repeated enrollment failures.")
+	}
+
+	return nil
+}
+
+// recordEnrollmentFailure records a failed use of the one-time password of
+// id from source, and locks id out if too many failures followed.
+//
+func (eca *ECA) recordEnrollmentFailure(id, source string) {
+	g := eca.guard
+	enrollmentFailures.inc()
+
+	// a wrong one-time password counts against its attempts
+	if _, err := eca.db.Exec("UPDATE Users SET tokenAttempts=tokenAttempts+1 WHERE id=? AND state=?", id, 0); err != nil {
+		Error.Println(err)
+	}
+
+	failures, err := eca.countEnrollmentFailure(id)
+	if err != nil {
+		Error.Println(err)
+		return
+	}
+	securityEvent("enrollment.failed", map[string]interface{}{"id": id, "source": source, "failures": failures})
+
+	if g.maxFailures <= 0 || failures < g.maxFailures {
+		return
+	}
+
+	until := time.Now().Add(g.lockout)
+	if _, err = eca.db.Exec("UPDATE EnrollmentFailures SET failures=?, lockedUntil=? WHERE id=?", 0, until.Unix(), id); err != nil {
+		Error.Println(err)
+		return
+	}
+	securityEvent("enrollment.lockout", map[string]interface{}{"id": id, "source": source, "until": until.UTC()})
+	enrollmentLockouts.inc()
+}
+
+// countEnrollmentFailure increments the count of the consecutive enrollment
+// failures of id and returns it.
+//
+func (eca *ECA) countEnrollmentFailure(id string) (int, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// the row is created once, even by failures served at once by replicas sharing the database
+	if _, err := eca.db.Exec("INSERT OR IGNORE INTO EnrollmentFailures (id, failures, lockedUntil) VALUES (?, ?, ?)", id, 0, 0); err != nil {
+		return 0, err
+	}
+	if _, err := eca.db.Exec("UPDATE EnrollmentFailures SET failures=failures+1 WHERE id=?", id); err != nil {
+		return 0, err
+	}
+
+	var failures int
+	err := eca.db.QueryRow("SELECT failures FROM EnrollmentFailures WHERE id=?", id).Scan(&failures)
+	return failures, err
+}
+
+// recordEnrollmentSuccess clears the enrollment failures of id.
+//
+func (eca *ECA) recordEnrollmentSuccess(id, source string) {
+	if _, err := eca.db.Exec("DELETE FROM EnrollmentFailures WHERE id=?", id); err != nil {
+		Error.Println(err)
+	}
+	securityEvent("enrollment.succeeded", map[string]interface{}{"id": id, "source": source})
+}
+
+// getSecretValidityPeriod returns the validity period of the one-time passwords, or 0 if
+// they do not expire.
+func getSecretValidityPeriod() time.Duration {
+	return viper.GetDuration("eca.enrollment.secret.validity-period")
+}
+
+// getSecretMaxAttempts returns the number of failed attempts after which a one-time
+// password is void, or 0 for no limit.
+func getSecretMaxAttempts() int {
+	return viper.GetInt("eca.enrollment.secret.max-attempts")
+}
+
+// secretExpiry returns when a one-time password issued now expires, in seconds since
+// the epoch, or 0 if it does not expire.
+//
+func secretExpiry() int64 {
+	validity := getSecretValidityPeriod()
+	if validity <= 0 {
+		return 0
+	}
+	return time.Now().Add(validity).Unix()
+}
+
+// checkSecret checks that the one-time password of id, used from source, has neither
+// expired nor been voided by too many failed attempts. An expired or void password has
+// to be reset by a registrar.
+//
+func (eca *ECA) checkSecret(id, source string) error {
+	var expiry, attempts int64
+	if err := eca.db.QueryRow("SELECT tokenExpiry, tokenAttempts FROM Users WHERE id=?", id).Scan(&expiry, &attempts); err != nil {
+		return errors.New("Identity lookup error: " + err.Error())
+	}
+
+	if expiry > 0 && expiry <= time.Now().Unix() {
+		securityEvent("secret.expired", map[string]interface{}{"id": id, "source": source})
+		return errors.New("Enrollment secret has expired.")
+	}
+	if max := getSecretMaxAttempts(); max > 0 && attempts >= int64(max) {
+		securityEvent("secret.exhausted", map[string]interface{}{"id": id, "source": source, "attempts": attempts})
+		return errors.New("Enrollment secret is void after too many failed attempts.")
+	}
+	return nil
+}
+
+// consumeSecret replaces the one-time password of id with tok, the enrollment challenge
+// for the encryption key key, and moves id to state. The update is conditioned on the
+// password being unused, unexpired and not void, so that it is used at most once, even
+// by concurrent requests served by replicas of the ECA sharing the database.
+//
+func (eca *ECA) consumeSecret(id string, tok, key []byte, state int) error {
+	maxAttempts := getSecretMaxAttempts()
+	if maxAttempts <= 0 {
+		maxAttempts = math.MaxInt32
+	}
+
+	return eca.transitionUser("UPDATE Users SET token=?, state=?, key=? WHERE id=? AND state=? AND (tokenExpiry=0 OR tokenExpiry>?) AND tokenAttempts<?",
+		tok, state, key, id, 0, time.Now().Unix(), maxAttempts)
+}
+
+// checkRequestReplay fails if the request raw of id, with timestamp ts, was already served,
+// by this ECA or by a replica sharing its database, and records it otherwise. Requests are
+// remembered until their timestamp is out of the allowed clock skew, from when
+// checkRequestTime rejects them.
+//
+func (eca *ECA) checkRequestReplay(id string, raw []byte, ts *google_protobuf.Timestamp) error {
+	if _, err := eca.db.Exec("DELETE FROM RequestDigests WHERE expiry<?", time.Now().Unix()); err != nil {
+		Error.Println(err)
+	}
+
+	sum := sha256.Sum256(raw)
+	digest := hex.EncodeToString(sum[:])
+	expiry := ts.Seconds + int64(viper.GetDuration("server.rest.maxClockSkew")/time.Second) + 1
+	if _, err := eca.db.Exec("INSERT INTO RequestDigests (digest, expiry) VALUES (?, ?)", digest, expiry); err != nil {
+		var n int
+		if countErr := eca.db.QueryRow("SELECT COUNT(*) FROM RequestDigests WHERE digest=?", digest).Scan(&n); countErr != nil || n == 0 {
+			Error.Println(err)
+			return err
+		}
+		securityEvent("request.replayed", map[string]interface{}{"id": id})
+		return errors.New("Request already served.")
+	}
+	return nil
+}
+
+// withRequestSource returns a copy of ctx carrying the network address source
+// of a request.
+//
+func withRequestSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// requestSource returns the host a request, with context ctx, comes from.
+//
+func requestSource(ctx context.Context) string {
+	addr, ok := ctx.Value(sourceKey{}).(string)
+	if !ok {
+		stream, ok := transport.StreamFromContext(ctx)
+		if !ok || stream.ServerTransport() == nil || stream.ServerTransport().RemoteAddr() == nil {
+			return ""
+		}
+		addr = stream.ServerTransport().RemoteAddr().String()
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// securityEvent logs a security event with its fields as a JSON object.
+//
+func securityEvent(event string, fields map[string]interface{}) {
+	fields["event"] = event
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		Error.Println(err)
+		return
+	}
+	Security.Println(string(raw))
+}
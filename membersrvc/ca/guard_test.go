@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"fmt"
+	"google/protobuf"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+var (
+	lockUser    = User{enrollID: "lockUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	expiredUser = User{enrollID: "expiredUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+	voidUser    = User{enrollID: "voidUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(2, time.Minute)
+
+	if !l.allow("alice", now) || !l.allow("alice", now) {
+		t.Fatal("The attempts of a burst should be allowed")
+	}
+	if l.allow("alice", now) {
+		t.Fatal("An attempt beyond the burst should be denied")
+	}
+	if !l.allow("bob", now) {
+		t.Fatal("The attempts of each key should be limited separately")
+	}
+	if !l.allow("alice", now.Add(30*time.Second)) || l.allow("alice", now.Add(30*time.Second)) {
+		t.Fatal("A single attempt should be allowed after half the interval")
+	}
+
+	unlimited := newRateLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow("alice", now) {
+			t.Fatal("A rate limiter without burst should allow any attempt")
+		}
+	}
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(1, time.Hour)
+	l.maxKeys = 2
+
+	if !l.allow("alice", now) || !l.allow("bob", now) || l.allow("alice", now) {
+		t.Fatal("The attempts of alice should be limited")
+	}
+	for i := 0; i < 100; i++ {
+		l.allow(fmt.Sprintf("user%d", i), now)
+		if len(l.buckets) > l.maxKeys || l.lru.Len() > l.maxKeys {
+			t.Fatalf("Tracked %d keys, expected at most %d", len(l.buckets), l.maxKeys)
+		}
+	}
+
+	// the buckets not refilled yet are kept, the other keys sharing the overflow bucket
+	if _, ok := l.buckets["alice"]; !ok {
+		t.Fatal("The bucket of alice should be kept until refilled")
+	}
+	if l.allow("alice", now) || l.allow("carol", now) {
+		t.Fatal("The attempts of alice and of the keys without a bucket should be limited")
+	}
+
+	// the least recently used keys are forgotten first, once refilled
+	later := now.Add(time.Hour)
+	l.allow("bob", later)
+	l.allow("carol", later)
+	if _, ok := l.buckets["alice"]; ok {
+		t.Fatal("The least recently used key should be forgotten")
+	}
+	if l.allow("carol", later) || l.allow("bob", later) {
+		t.Fatal("The attempts of the recently used keys should still be limited")
+	}
+}
+
+func TestRequestSource(t *testing.T) {
+	if source := requestSource(withRequestSource(context.Background(), "10.0.0.1:4242")); source != "10.0.0.1" {
+		t.Fatalf("Unexpected request source [%s]", source)
+	}
+	if source := requestSource(context.Background()); source != "" {
+		t.Fatalf("Unexpected request source [%s]", source)
+	}
+}
+
+func TestEnrollmentLockout(t *testing.T) {
+	if err := registerUser(testAdmin, &lockUser); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	guard := eca.guard
+	eca.guard = &enrollmentGuard{identities: newRateLimiter(0, 0), sources: newRateLimiter(0, 0), maxFailures: 2, lockout: time.Hour}
+	defer func() { eca.guard = guard }()
+
+	ecap := &ECAP{eca}
+	newRequest := func(tok []byte) *pb.ECertCreateReq {
+		return &pb.ECertCreateReq{
+			Ts:   &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+			Id:   &pb.Identity{Id: lockUser.enrollID},
+			Tok:  &pb.Token{Tok: tok},
+			Sign: &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: []byte{0}},
+			Enc:  &pb.PublicKey{Type: pb.CryptoType_ECDSA, Key: []byte{0}},
+			Sig:  nil}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ecap.CreateCertificatePair(context.Background(), newRequest([]byte("badPassword"))); err == nil || err.Error() != "Identity or token does not match." {
+			t.Fatalf("Expected a token mismatch, got [%v]", err)
+		}
+	}
+
+	// the right password does not help while the identity is locked out
+	if _, err := ecap.CreateCertificatePair(context.Background(), newRequest(lockUser.enrollPwd)); err == nil || err.Error() != "Identity is locked out after repeated enrollment failures." {
+		t.Fatalf("Expected a lockout, got [%v]", err)
+	}
+
+	// once the lockout expires, enrolling clears the failures
+	if _, err := eca.db.Exec("UPDATE EnrollmentFailures SET lockedUntil=? WHERE id=?", time.Now().Add(-time.Minute).Unix(), lockUser.enrollID); err != nil {
+		t.Fatal(err)
+	}
+	if err := enrollUser(&lockUser); err != nil {
+		t.Fatalf("Failed to enroll lockUser: [%s]", err.Error())
+	}
+	var count int
+	if err := eca.db.QueryRow("SELECT count(row) FROM EnrollmentFailures WHERE id=?", lockUser.enrollID).Scan(&count); err != nil || count != 0 {
+		t.Fatal("The enrollment failures should be cleared after an enrollment")
+	}
+}
+
+func TestEnrollmentThrottled(t *testing.T) {
+	guard := eca.guard
+	eca.guard = &enrollmentGuard{identities: newRateLimiter(0, 0), sources: newRateLimiter(1, time.Hour)}
+	defer func() { eca.guard = guard }()
+
+	ctx := withRequestSource(context.Background(), "192.0.2.1:5000")
+	if err := eca.admitEnrollment("alice", requestSource(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := eca.admitEnrollment("bob", requestSource(ctx)); err == nil {
+		t.Fatal("The attempts from a source beyond its rate should be denied")
+	}
+}
+
+// requestTime returns the timestamp of a request made now.
+func requestTime() *google_protobuf.Timestamp {
+	now := time.Now()
+	return &google_protobuf.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())}
+}
+
+// regenerateSecret resets the one-time password of user on behalf of testAdmin.
+func regenerateSecret(t *testing.T, user *User) {
+	req := &pb.SecretResetReq{Id: &pb.Identity{Id: testAdmin.enrollID}, Subject: &pb.Identity{Id: user.enrollID}, Ts: requestTime()}
+	req.Sig = signRequest(t, testAdmin.enrollPrivKey, req)
+	tok, err := (&ECAA{eca}).ResetSecret(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed regenerating the secret: [%s]", err.Error())
+	}
+	user.enrollPwd = tok.Tok
+}
+
+func TestSecretExpiry(t *testing.T) {
+	viper.Set("eca.enrollment.secret.validity-period", "1h")
+	defer viper.Set("eca.enrollment.secret.validity-period", "0")
+
+	if err := registerUser(testAdmin, &expiredUser); err != nil {
+		t.Fatal(err.Error())
+	}
+	var expiry int64
+	if err := eca.db.QueryRow("SELECT tokenExpiry FROM Users WHERE id=?", expiredUser.enrollID).Scan(&expiry); err != nil {
+		t.Fatal(err)
+	}
+	if expiry <= time.Now().Unix() || expiry > time.Now().Add(time.Hour).Unix() {
+		t.Fatalf("Unexpected expiry of the secret [%d]", expiry)
+	}
+
+	if _, err := eca.db.Exec("UPDATE Users SET tokenExpiry=? WHERE id=?", time.Now().Add(-time.Minute).Unix(), expiredUser.enrollID); err != nil {
+		t.Fatal(err)
+	}
+	if err := enrollUser(&expiredUser); err == nil || err.Error() != "Enrollment secret has expired." {
+		t.Fatalf("Expected an expired secret, got [%v]", err)
+	}
+
+	regenerateSecret(t, &expiredUser)
+	if err := enrollUser(&expiredUser); err != nil {
+		t.Fatalf("Failed to enroll with the regenerated secret: [%s]", err.Error())
+	}
+
+	// the secret is used once
+	if err := enrollUser(&expiredUser); err == nil {
+		t.Fatal("A secret should not be usable twice")
+	}
+}
+
+func TestSecretMaxAttempts(t *testing.T) {
+	viper.Set("eca.enrollment.secret.max-attempts", 2)
+	defer viper.Set("eca.enrollment.secret.max-attempts", 0)
+
+	guard := eca.guard
+	eca.guard = &enrollmentGuard{identities: newRateLimiter(0, 0), sources: newRateLimiter(0, 0)}
+	defer func() { eca.guard = guard }()
+
+	if err := registerUser(testAdmin, &voidUser); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pwd := voidUser.enrollPwd
+	voidUser.enrollPwd = []byte("badPassword")
+	for i := 0; i < 2; i++ {
+		if err := enrollUser(&voidUser); err == nil || err.Error() != "Identity or token does not match." {
+			t.Fatalf("Expected a token mismatch, got [%v]", err)
+		}
+	}
+
+	// the right password is void after too many failed attempts
+	voidUser.enrollPwd = pwd
+	if err := enrollUser(&voidUser); err == nil || err.Error() != "Enrollment secret is void after too many failed attempts." {
+		t.Fatalf("Expected a void secret, got [%v]", err)
+	}
+
+	regenerateSecret(t, &voidUser)
+	if err := enrollUser(&voidUser); err != nil {
+		t.Fatalf("Failed to enroll with the regenerated secret: [%s]", err.Error())
+	}
+}
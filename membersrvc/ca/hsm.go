@@ -0,0 +1,308 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+)
+
+// When pki.hsm.enabled is set, the signing key of each CA is generated inside
+// the PKCS#11 token labelled pki.hsm.label, under the name of the CA, and never
+// leaves it: the certificates, CRLs and requests of the CA are signed by the
+// token. The CAs share a single session with the token.
+
+var (
+	// Named curves supported for the keys held by the token
+	hsmCurveOIDs = map[string]asn1.ObjectIdentifier{
+		"P-256": {1, 2, 840, 10045, 3, 1, 7},
+		"P-384": {1, 3, 132, 0, 34},
+	}
+
+	caHSM     *hsm
+	caHSMErr  error
+	caHSMOnce sync.Once
+)
+
+// hsm is a logged in session with the configured token.
+// PKCS#11 sessions are not safe for concurrent use, hence the mutex.
+type hsm struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+
+	mutex sync.Mutex
+}
+
+// hsmKey is an ECDSA signing key held by the token. It implements crypto.Signer.
+type hsmKey struct {
+	hsm  *hsm
+	priv pkcs11.ObjectHandle
+	pub  *ecdsa.PublicKey
+}
+
+// isHSMEnabled returns true if the signing keys of the CAs are held by a PKCS#11 token.
+//
+func isHSMEnabled() bool {
+	return viper.GetBool("pki.hsm.enabled")
+}
+
+// getHSM returns the session with the PKCS#11 token of the CAs, opened on first use.
+//
+func getHSM() (*hsm, error) {
+	caHSMOnce.Do(func() {
+		caHSM, caHSMErr = openHSM(viper.GetString("pki.hsm.library"), viper.GetString("pki.hsm.label"), viper.GetString("pki.hsm.pin"))
+	})
+	return caHSM, caHSMErr
+}
+
+func openHSM(library, label, pin string) (*hsm, error) {
+	ctx := pkcs11.New(library)
+	if ctx == nil {
+		return nil, fmt.Errorf("Failed loading PKCS#11 library [%s].", library)
+	}
+
+	err := ctx.Initialize()
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, err
+	}
+
+	slot, err := findHSMSlot(ctx, label)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	err = ctx.Login(session, pkcs11.CKU_USER, pin)
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return &hsm{ctx: ctx, session: session}, nil
+}
+
+func findHSMSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+
+	return 0, fmt.Errorf("PKCS#11 token [%s] not found.", label)
+}
+
+// generateKey generates a new ECDSA key pair labelled name, on the default curve,
+// inside the token.
+//
+func (hsm *hsm) generateKey(name string) (*hsmKey, error) {
+	curve := primitives.GetDefaultCurve()
+	oid, ok := hsmCurveOIDs[curve.Params().Name]
+	if !ok {
+		return nil, fmt.Errorf("Curve [%s] not supported by the PKCS#11 token.", curve.Params().Name)
+	}
+	params, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, name),
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, name),
+	}
+
+	hsm.mutex.Lock()
+	defer hsm.mutex.Unlock()
+
+	pubHandle, privHandle, err := hsm.ctx.GenerateKeyPair(
+		hsm.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		pubTemplate, privTemplate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := hsm.getPublicKey(pubHandle, curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hsmKey{hsm: hsm, priv: privHandle, pub: pub}, nil
+}
+
+// findKey returns the key pair labelled name.
+//
+func (hsm *hsm) findKey(name string) (*hsmKey, error) {
+	hsm.mutex.Lock()
+	defer hsm.mutex.Unlock()
+
+	privHandle, err := hsm.findObject(pkcs11.CKO_PRIVATE_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+	pubHandle, err := hsm.findObject(pkcs11.CKO_PUBLIC_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := hsm.getPublicKey(pubHandle, primitives.GetDefaultCurve())
+	if err != nil {
+		return nil, err
+	}
+
+	return &hsmKey{hsm: hsm, priv: privHandle, pub: pub}, nil
+}
+
+func (hsm *hsm) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := hsm.ctx.FindObjectsInit(hsm.session, template); err != nil {
+		return 0, err
+	}
+	defer hsm.ctx.FindObjectsFinal(hsm.session)
+
+	handles, _, err := hsm.ctx.FindObjects(hsm.session, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("Key [%s] not found in the PKCS#11 token.", label)
+	}
+
+	return handles[0], nil
+}
+
+func (hsm *hsm) getPublicKey(handle pkcs11.ObjectHandle, curve elliptic.Curve) (*ecdsa.PublicKey, error) {
+	attrs, err := hsm.ctx.GetAttributeValue(hsm.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// CKA_EC_POINT is a DER encoded octet string, some tokens
+	// return the raw point instead
+	point := attrs[0].Value
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("Failed unmarshalling EC point.")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Public returns the public key of key.
+//
+func (key *hsmKey) Public() crypto.PublicKey {
+	return key.pub
+}
+
+// Sign signs digest inside the token and returns the ASN.1 encoded signature,
+// like ecdsa.PrivateKey.Sign.
+//
+func (key *hsmKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key.hsm.mutex.Lock()
+	defer key.hsm.mutex.Unlock()
+
+	err := key.hsm.ctx.SignInit(key.hsm.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, key.priv)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := key.hsm.ctx.Sign(key.hsm.session, digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("Invalid ECDSA signature returned by the PKCS#11 token.")
+	}
+
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+
+	return asn1.Marshal(primitives.ECDSASignature{R: r, S: s})
+}
+
+// readHSMKey returns the signing key of the CA name held by the token,
+// generating it if create is set and the token holds none.
+//
+func readHSMKey(name string, create bool) (crypto.Signer, error) {
+	hsm, err := getHSM()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hsm.findKey(name)
+	if err != nil && create {
+		Info.Printf("Generating the signing key of the %s in the PKCS#11 token.\n", name)
+		key, err = hsm.generateKey(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,452 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// BER tags of the LDAPv3 (RFC 4511) messages used by the ECA
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berBoolean     = 0x01
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchResultEntry = 0x64
+	ldapSearchResultDone  = 0x65
+	ldapSimpleAuth        = 0x80
+	ldapEqualityMatch     = 0xa3
+
+	ldapScopeSubtree = 2
+	ldapSuccess      = 0
+)
+
+// ldapGroup is the role and affiliation granted to the members of a directory group.
+type ldapGroup struct {
+	role            pb.Role
+	affiliation     string
+	affiliationRole string
+}
+
+// ldapDirectory authenticates users against an LDAP (or Active Directory)
+// server and maps the groups they are member of to roles and affiliations.
+//
+type ldapDirectory struct {
+	url            *url.URL
+	base           string
+	userAttribute  string
+	groupAttribute string
+	bindDN         string
+	bindPassword   string
+	timeout        time.Duration
+	groups         map[string]ldapGroup
+}
+
+// ldapEntry is a directory entry as returned by a search.
+type ldapEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// berElement is a decoded BER TLV with definite length.
+type berElement struct {
+	tag   byte
+	value []byte
+}
+
+// newLDAPDirectory reads the eca.ldap section of the configuration. It returns
+// nil if no directory is enabled.
+//
+func newLDAPDirectory() (*ldapDirectory, error) {
+	if !viper.GetBool("eca.ldap.enabled") {
+		return nil, nil
+	}
+
+	u, err := url.Parse(viper.GetString("eca.ldap.url"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("Unsupported LDAP URL scheme [%s]", u.Scheme)
+	}
+
+	dir := &ldapDirectory{
+		url:            u,
+		base:           viper.GetString("eca.ldap.base"),
+		userAttribute:  viper.GetString("eca.ldap.userAttribute"),
+		groupAttribute: viper.GetString("eca.ldap.groupAttribute"),
+		bindDN:         viper.GetString("eca.ldap.bind.dn"),
+		bindPassword:   viper.GetString("eca.ldap.bind.password"),
+		timeout:        viper.GetDuration("eca.ldap.timeout"),
+		groups:         make(map[string]ldapGroup),
+	}
+	if dir.userAttribute == "" {
+		dir.userAttribute = "uid"
+	}
+	if dir.groupAttribute == "" {
+		dir.groupAttribute = "memberOf"
+	}
+	if dir.timeout == 0 {
+		dir.timeout = 5 * time.Second
+	}
+
+	// The fields of each group are as follows:
+	//    <GroupDN>: <system_role> <Affiliation> <Affiliation_Role>
+	for dn, flds := range viper.GetStringMapString("eca.ldap.groups") {
+		vals := strings.Fields(flds)
+		if len(vals) == 0 {
+			return nil, fmt.Errorf("No role given for LDAP group [%s]", dn)
+		}
+		role, err := strconv.Atoi(vals[0])
+		if err != nil {
+			return nil, err
+		}
+		group := ldapGroup{role: pb.Role(role)}
+		if len(vals) >= 3 {
+			group.affiliation = vals[1]
+			group.affiliationRole = vals[2]
+		}
+		dir.groups[normalizeDN(dn)] = group
+	}
+
+	return dir, nil
+}
+
+// authenticate checks pwd against the directory entry of id and returns the
+// role and affiliation granted by the groups of that entry.
+//
+func (dir *ldapDirectory) authenticate(id string, pwd []byte) (*ldapGroup, error) {
+	if id == "" || len(pwd) == 0 {
+		// An empty password would be an unauthenticated bind (RFC 4513, 5.1.2)
+		return nil, errors.New("Identity and password are required.")
+	}
+
+	conn, err := dir.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if dir.bindDN != "" {
+		if err = conn.bind(dir.bindDN, dir.bindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := conn.search(dir.base, dir.userAttribute, id, []string{dir.groupAttribute})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("Expected one directory entry for [%s], found %d", id, len(entries))
+	}
+
+	if err = conn.bind(entries[0].dn, string(pwd)); err != nil {
+		return nil, err
+	}
+
+	return dir.mapGroups(entries[0].attributes[strings.ToLower(dir.groupAttribute)])
+}
+
+// mapGroups combines the roles of all the mapped groups in groups. The
+// affiliation is the one of the first mapped group that has one.
+//
+func (dir *ldapDirectory) mapGroups(groups []string) (*ldapGroup, error) {
+	mapped := &ldapGroup{}
+	for _, dn := range groups {
+		group, ok := dir.groups[normalizeDN(dn)]
+		if !ok {
+			continue
+		}
+		mapped.role |= group.role
+		if mapped.affiliation == "" {
+			mapped.affiliation = group.affiliation
+			mapped.affiliationRole = group.affiliationRole
+		}
+	}
+
+	if mapped.role == pb.Role_NONE {
+		return nil, errors.New("User is not member of any mapped directory group.")
+	}
+	return mapped, nil
+}
+
+func normalizeDN(dn string) string {
+	rdns := strings.Split(dn, ",")
+	for i, rdn := range rdns {
+		rdns[i] = strings.TrimSpace(rdn)
+	}
+	return strings.ToLower(strings.Join(rdns, ","))
+}
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn      net.Conn
+	messageID int
+}
+
+func (dir *ldapDirectory) dial() (*ldapConn, error) {
+	host := dir.url.Host
+	dialer := &net.Dialer{Timeout: dir.timeout}
+
+	var conn net.Conn
+	var err error
+	if dir.url.Scheme == "ldaps" {
+		if dir.url.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: dir.url.Hostname()})
+	} else {
+		if dir.url.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dir.timeout))
+
+	return &ldapConn{conn: conn}, nil
+}
+
+func (c *ldapConn) close() {
+	c.send(berEncode(ldapUnbindRequest))
+	c.conn.Close()
+}
+
+func (c *ldapConn) send(op []byte) error {
+	c.messageID++
+	_, err := c.conn.Write(berEncode(berSequence, berEncodeInt(berInteger, c.messageID), op))
+	return err
+}
+
+// receive reads the next message and returns its protocol operation.
+func (c *ldapConn) receive() (*berElement, error) {
+	msg, err := readBER(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := parseBER(msg.value)
+	if err != nil {
+		return nil, err
+	}
+	if msg.tag != berSequence || len(elems) < 2 || elems[0].tag != berInteger {
+		return nil, errors.New("Malformed LDAP message")
+	}
+	if berDecodeInt(elems[0].value) != c.messageID {
+		return nil, errors.New("Unexpected LDAP message ID")
+	}
+	return &elems[1], nil
+}
+
+func (c *ldapConn) bind(dn, pwd string) error {
+	err := c.send(berEncode(ldapBindRequest,
+		berEncodeInt(berInteger, 3),
+		berEncode(berOctetString, []byte(dn)),
+		berEncode(ldapSimpleAuth, []byte(pwd))))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return errors.New("Unexpected LDAP response to bind")
+	}
+	return ldapResultError(op.value)
+}
+
+func (c *ldapConn) search(base, attribute, value string, attributes []string) ([]*ldapEntry, error) {
+	var attrs [][]byte
+	for _, attr := range attributes {
+		attrs = append(attrs, berEncode(berOctetString, []byte(attr)))
+	}
+
+	err := c.send(berEncode(ldapSearchRequest,
+		berEncode(berOctetString, []byte(base)),
+		berEncodeInt(berEnumerated, ldapScopeSubtree),
+		berEncodeInt(berEnumerated, 0),
+		berEncodeInt(berInteger, 2),
+		berEncodeInt(berInteger, 0),
+		berEncode(berBoolean, []byte{0}),
+		berEncode(ldapEqualityMatch,
+			berEncode(berOctetString, []byte(attribute)),
+			berEncode(berOctetString, []byte(value))),
+		berEncode(berSequence, attrs...)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ldapEntry
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.tag {
+		case ldapSearchResultEntry:
+			entry, err := parseLDAPEntry(op.value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchResultDone:
+			return entries, ldapResultError(op.value)
+		}
+	}
+}
+
+func parseLDAPEntry(b []byte) (*ldapEntry, error) {
+	elems, err := parseBER(b)
+	if err != nil || len(elems) != 2 {
+		return nil, errors.New("Malformed LDAP search result entry")
+	}
+
+	entry := &ldapEntry{dn: string(elems[0].value), attributes: make(map[string][]string)}
+	attrs, err := parseBER(elems[1].value)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		fields, err := parseBER(attr.value)
+		if err != nil || len(fields) != 2 {
+			return nil, errors.New("Malformed LDAP attribute")
+		}
+		vals, err := parseBER(fields[1].value)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(string(fields[0].value))
+		for _, val := range vals {
+			entry.attributes[name] = append(entry.attributes[name], string(val.value))
+		}
+	}
+
+	return entry, nil
+}
+
+// ldapResultError returns the error reported by an LDAPResult, if any.
+func ldapResultError(b []byte) error {
+	elems, err := parseBER(b)
+	if err != nil || len(elems) < 3 {
+		return errors.New("Malformed LDAP result")
+	}
+	if code := berDecodeInt(elems[0].value); code != ldapSuccess {
+		return fmt.Errorf("LDAP error %d: %s", code, elems[2].value)
+	}
+	return nil
+}
+
+func berEncode(tag byte, contents ...[]byte) []byte {
+	var value []byte
+	for _, c := range contents {
+		value = append(value, c...)
+	}
+
+	out := []byte{tag}
+	if l := len(value); l < 0x80 {
+		out = append(out, byte(l))
+	} else {
+		var lb []byte
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		out = append(out, 0x80|byte(len(lb)))
+		out = append(out, lb...)
+	}
+	return append(out, value...)
+}
+
+func berEncodeInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berEncode(tag, b)
+}
+
+func berDecodeInt(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func readBER(r io.Reader) (*berElement, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	l := int(hdr[1])
+	if l&0x80 != 0 {
+		n := l & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("Unsupported BER length")
+		}
+		lb := make([]byte, n)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return nil, err
+		}
+		l = berDecodeInt(lb)
+	}
+
+	value := make([]byte, l)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return &berElement{tag: hdr[0], value: value}, nil
+}
+
+func parseBER(b []byte) ([]berElement, error) {
+	var elems []berElement
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		elem, err := readBER(r)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, *elem)
+	}
+	return elems, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+const ldapTestUserDN = "uid=alice,ou=people,dc=example,dc=com"
+
+// serveLDAP answers the bind and search requests of a single connection from
+// a directory holding one user.
+func serveLDAP(conn net.Conn) {
+	defer conn.Close()
+
+	reply := func(id []byte, op []byte) {
+		conn.Write(berEncode(berSequence, berEncode(berInteger, id), op))
+	}
+	result := func(tag byte, code int) []byte {
+		return berEncode(tag, berEncodeInt(berEnumerated, code), berEncode(berOctetString), berEncode(berOctetString))
+	}
+
+	for {
+		msg, err := readBER(conn)
+		if err != nil {
+			return
+		}
+		elems, _ := parseBER(msg.value)
+		id, op := elems[0].value, elems[1]
+
+		switch op.tag {
+		case ldapBindRequest:
+			fields, _ := parseBER(op.value)
+			dn, pwd := string(fields[1].value), string(fields[2].value)
+			if (dn == "cn=reader,dc=example,dc=com" && pwd == "reader") || (dn == ldapTestUserDN && pwd == "secret") {
+				reply(id, result(ldapBindResponse, ldapSuccess))
+			} else {
+				reply(id, result(ldapBindResponse, 49))
+			}
+		case ldapSearchRequest:
+			fields, _ := parseBER(op.value)
+			filter, _ := parseBER(fields[6].value)
+			if string(filter[1].value) == "alice" {
+				reply(id, berEncode(ldapSearchResultEntry,
+					berEncode(berOctetString, []byte(ldapTestUserDN)),
+					berEncode(berSequence,
+						berEncode(berSequence,
+							berEncode(berOctetString, []byte("memberOf")),
+							berEncode(0x31,
+								berEncode(berOctetString, []byte("cn=staff,ou=groups,dc=example,dc=com")),
+								berEncode(berOctetString, []byte("cn=Bank_A_Clients, ou=groups,dc=example,dc=com")),
+								berEncode(berOctetString, []byte("cn=bank_a_peers,ou=groups,dc=example,dc=com")))))))
+			}
+			reply(id, result(ldapSearchResultDone, ldapSuccess))
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func newTestLDAPDirectory(t *testing.T) *ldapDirectory {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serveLDAP(conn)
+		}
+	}()
+
+	u, _ := url.Parse("ldap://" + lis.Addr().String())
+	return &ldapDirectory{
+		url:            u,
+		base:           "ou=people,dc=example,dc=com",
+		userAttribute:  "uid",
+		groupAttribute: "memberOf",
+		bindDN:         "cn=reader,dc=example,dc=com",
+		bindPassword:   "reader",
+		timeout:        5 * time.Second,
+		groups: map[string]ldapGroup{
+			"cn=bank_a_clients,ou=groups,dc=example,dc=com": {pb.Role_CLIENT, "bank_a", "00001"},
+			"cn=bank_a_peers,ou=groups,dc=example,dc=com":   {pb.Role_PEER, "bank_a", "00002"},
+		},
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	dir := newTestLDAPDirectory(t)
+
+	group, err := dir.authenticate("alice", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed authenticating against the directory: [%s]", err)
+	}
+	if group.role != pb.Role_CLIENT|pb.Role_PEER {
+		t.Fatalf("Expected role %d, got %d", pb.Role_CLIENT|pb.Role_PEER, group.role)
+	}
+	if group.affiliation != "bank_a" || group.affiliationRole != "00001" {
+		t.Fatalf("Unexpected affiliation %s %s", group.affiliation, group.affiliationRole)
+	}
+}
+
+func TestLDAPAuthenticateBadPassword(t *testing.T) {
+	dir := newTestLDAPDirectory(t)
+
+	if _, err := dir.authenticate("alice", []byte("wrong")); err == nil {
+		t.Fatal("Authentication with a wrong password should fail")
+	}
+	if _, err := dir.authenticate("alice", nil); err == nil {
+		t.Fatal("Authentication with an empty password should fail")
+	}
+}
+
+func TestLDAPAuthenticateUnknownUser(t *testing.T) {
+	dir := newTestLDAPDirectory(t)
+
+	if _, err := dir.authenticate("bob", []byte("secret")); err == nil {
+		t.Fatal("Authentication of a user not in the directory should fail")
+	}
+}
+
+func TestLDAPMapGroupsNoMapping(t *testing.T) {
+	dir := newTestLDAPDirectory(t)
+
+	if _, err := dir.mapGroups([]string{"cn=staff,ou=groups,dc=example,dc=com"}); err == nil {
+		t.Fatal("Users without a mapped group should be rejected")
+	}
+}
+
+func TestLDAPEnrollDirectoryUser(t *testing.T) {
+	eca.directory = newTestLDAPDirectory(t)
+	defer func() { eca.directory = nil }()
+
+	alice := User{enrollID: "alice", enrollPwd: []byte("wrong")}
+	if err := enrollUser(&alice); err == nil {
+		t.Fatal("Enrollment with a wrong directory password should fail")
+	}
+
+	alice.enrollPwd = []byte("secret")
+	if err := enrollUser(&alice); err != nil {
+		t.Fatalf("Failed to enroll directory user: [%s]", err)
+	}
+	if role := eca.readRole("alice"); role != int(pb.Role_CLIENT|pb.Role_PEER) {
+		t.Fatalf("Expected role %d, got %d", pb.Role_CLIENT|pb.Role_PEER, role)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// The metrics of the CAs are served in the Prometheus text format on
+// server.metrics.address, when server.metrics.enabled is set.
+
+var (
+	certificatesIssued = newMetric("membersrvc_certificates_issued_total", "counter", "Certificates issued by the CAs, by type.", "type")
+	requestDuration    = newMetric("membersrvc_request_duration_seconds", "summary", "Duration of the issuance requests served by the CAs.", "ca", "request")
+	requestErrors      = newMetric("membersrvc_request_errors_total", "counter", "Issuance requests the CAs failed to serve.", "ca", "request")
+	enrollmentFailures = newMetric("membersrvc_enrollment_failures_total", "counter", "Enrollment attempts with a wrong one-time password.")
+	enrollmentLockouts = newMetric("membersrvc_enrollment_lockouts_total", "counter", "Identities locked out after repeated enrollment failures.")
+	enrollmentRejected = newMetric("membersrvc_enrollment_rejected_total", "counter", "Enrollment attempts rejected by the rate limits or a lockout, by reason.", "reason")
+	databaseDuration   = newMetric("membersrvc_database_duration_seconds", "summary", "Duration of the database statements of the CAs.", "ca", "statement")
+	tcertPoolSize      = newMetric("membersrvc_tcert_pool_size", "gauge", "TCerts pre-generated by the TCA, by identity.", "id")
+	webhookDeliveries  = newMetric("membersrvc_webhook_deliveries_total", "counter", "Webhook event deliveries, by result: delivered, failed or dropped.", "result")
+	attributeLookups   = newMetric("membersrvc_attribute_lookups_total", "counter", "Attribute lookups of the ACA, by result: cached or fetched from the attribute source.", "result")
+	revocationEvents   = newMetric("membersrvc_revocation_events_total", "counter", "Revocation events pushed to the subscribers of the revocation feed, by result: sent or dropped.", "result")
+)
+
+var metrics = []*metric{
+	certificatesIssued,
+	requestDuration,
+	requestErrors,
+	enrollmentFailures,
+	enrollmentLockouts,
+	enrollmentRejected,
+	databaseDuration,
+	tcertPoolSize,
+	webhookDeliveries,
+	attributeLookups,
+	revocationEvents,
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metric is a metric of the CAs, with a value for each combination of the
+// values of its labels. The value of a summary is the sum of its observations.
+type metric struct {
+	name   string
+	kind   string
+	help   string
+	labels []string
+
+	mutex  sync.Mutex
+	values map[string]*metricValue
+}
+
+type metricValue struct {
+	labels []string
+	value  float64
+	count  uint64
+}
+
+func newMetric(name, kind, help string, labels ...string) *metric {
+	return &metric{name: name, kind: kind, help: help, labels: labels, values: make(map[string]*metricValue)}
+}
+
+// value returns the value for the label values labels. The mutex must be held.
+func (m *metric) value(labels []string) *metricValue {
+	key := strings.Join(labels, "\xff")
+	v, ok := m.values[key]
+	if !ok {
+		v = &metricValue{labels: labels}
+		m.values[key] = v
+	}
+	return v
+}
+
+// inc increments the counter m for the label values labels.
+func (m *metric) inc(labels ...string) {
+	m.add(1, labels...)
+}
+
+// add adds x to the counter m for the label values labels.
+func (m *metric) add(x float64, labels ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.value(labels).value += x
+}
+
+// observe adds the observation x to the summary m for the label values labels.
+func (m *metric) observe(x float64, labels ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v := m.value(labels)
+	v.value += x
+	v.count++
+}
+
+// set replaces the values of the gauge m with values, keyed by the value of its label.
+func (m *metric) set(values map[string]float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.values = make(map[string]*metricValue)
+	for label, x := range values {
+		m.value([]string{label}).value = x
+	}
+}
+
+// write writes m in the Prometheus text format.
+func (m *metric) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v := m.values[key]
+		labels := m.formatLabels(v.labels)
+		if m.kind == "summary" {
+			fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", m.name, labels, v.value, m.name, labels, v.count)
+		} else {
+			fmt.Fprintf(w, "%s%s %g\n", m.name, labels, v.value)
+		}
+	}
+}
+
+func (m *metric) formatLabels(values []string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, label := range m.labels {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, "%s=\"%s\"", label, labelEscaper.Replace(values[i]))
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// observeRequest records the duration of the request of the CA ca that started at start
+// and, if *err is set, its failure. It is meant to be deferred by the request handlers.
+func observeRequest(ca, request string, start time.Time, err *error) {
+	requestDuration.observe(time.Since(start).Seconds(), ca, request)
+	if *err != nil {
+		requestErrors.inc(ca, request)
+	}
+}
+
+// observeStatement records the duration of a database statement of the CA ca that
+// started at start.
+func observeStatement(ca, statement string, start time.Time) {
+	databaseDuration.observe(time.Since(start).Seconds(), ca, statement)
+}
+
+// countIssuedCertificates counts n certificates of type typ as issued.
+func countIssuedCertificates(typ pb.CertificateType, n int) {
+	certificatesIssued.add(float64(n), strings.ToLower(typ.String()))
+}
+
+// writeMetrics writes the metrics of the CAs, and the sizes of the TCert pools of tca,
+// in the Prometheus text format.
+func writeMetrics(w io.Writer, tca *TCA) {
+	if tca != nil {
+		tcertPoolSize.set(tca.pools.sizes())
+	}
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// StartMetricsServer serves the metrics of the CAs, and of the TCert pools of tca,
+// on server.metrics.address at /metrics.
+//
+func StartMetricsServer(tca *TCA) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(rw, tca)
+	})
+
+	if err := http.ListenAndServe(viper.GetString("server.metrics.address"), mux); err != nil {
+		Error.Printf("Fail to start CA metrics server: %s", err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricFormat(t *testing.T) {
+	counter := newMetric("test_total", "counter", "Test counter.", "id")
+	counter.inc(`a"b`)
+	counter.add(2, `a"b`)
+	counter.inc("c")
+
+	summary := newMetric("test_seconds", "summary", "Test summary.", "ca", "request")
+	summary.observe(0.5, "eca", "enroll")
+	summary.observe(1.5, "eca", "enroll")
+
+	var buf bytes.Buffer
+	counter.write(&buf)
+	summary.write(&buf)
+
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{id="a\"b"} 3
+test_total{id="c"} 1
+# HELP test_seconds Test summary.
+# TYPE test_seconds summary
+test_seconds_sum{ca="eca",request="enroll"} 2
+test_seconds_count{ca="eca",request="enroll"} 2
+`
+	if buf.String() != expected {
+		t.Fatalf("Unexpected metrics:\n%s", buf.String())
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	err := errors.New("failed")
+	observeRequest("test", "failing", time.Now(), &err)
+	err = nil
+	observeRequest("test", "failing", time.Now(), &err)
+
+	var buf bytes.Buffer
+	requestDuration.write(&buf)
+	requestErrors.write(&buf)
+
+	for _, line := range []string{
+		`membersrvc_request_duration_seconds_count{ca="test",request="failing"} 2`,
+		`membersrvc_request_errors_total{ca="test",request="failing"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("Metric [%s] missing from:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, tca)
+
+	// the users enrolled by the tests of the ECA were counted
+	for _, line := range []string{
+		`membersrvc_certificates_issued_total{type="ecert_sign"}`,
+		`membersrvc_request_duration_seconds_count{ca="eca",request="enroll"}`,
+		`membersrvc_database_duration_seconds_count{ca="eca",statement="query"}`,
+		`# TYPE membersrvc_tcert_pool_size gauge`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("Metric [%s] missing from:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// Issuance policies, listed under policies, override the global settings of the
+// certificates issued to the members of an affiliation group, including its
+// subgroups, and/or of a role. Where several policies apply to a member, each
+// setting is taken from the most specific policy setting it: the one of the
+// deepest affiliation group, then the one also restricted to a role.
+
+// defaultECertValidityPeriod is the validity period of the ECerts, unless set by a policy.
+const defaultECertValidityPeriod = 90 * 24 * time.Hour
+
+// issuancePolicy is an issuance policy, as configured.
+type issuancePolicy struct {
+	name              string
+	affiliation       string
+	role              pb.Role
+	ecertValidity     time.Duration
+	keyTypes          []string
+	tcertValidity     time.Duration
+	tcertMaxBatchSize int
+}
+
+// issuanceLimits are the settings in effect for the certificates of a member.
+type issuanceLimits struct {
+	ecertValidity     time.Duration
+	keyTypes          []string // signature schemes of the signing keys allowed, or all if empty
+	tcertValidity     time.Duration
+	tcertMaxBatchSize int
+}
+
+var policyRoles = map[string]pb.Role{
+	"client":    pb.Role_CLIENT,
+	"peer":      pb.Role_PEER,
+	"validator": pb.Role_VALIDATOR,
+	"auditor":   pb.Role_AUDITOR,
+}
+
+// readIssuancePolicies reads the issuance policies of the configuration.
+//
+func readIssuancePolicies() ([]*issuancePolicy, error) {
+	var names []string
+	for name := range viper.GetStringMap("policies") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var policies []*issuancePolicy
+	for _, name := range names {
+		key := "policies." + name + "."
+		policy := &issuancePolicy{
+			name:              name,
+			affiliation:       viper.GetString(key + "affiliation"),
+			ecertValidity:     viper.GetDuration(key + "ecert.validity-period"),
+			keyTypes:          viper.GetStringSlice(key + "ecert.key-types"),
+			tcertValidity:     viper.GetDuration(key + "tcert.validity-period"),
+			tcertMaxBatchSize: viper.GetInt(key + "tcert.max-batch-size"),
+		}
+
+		if role := viper.GetString(key + "role"); role != "" {
+			var ok bool
+			if policy.role, ok = policyRoles[strings.ToLower(role)]; !ok {
+				return nil, fmt.Errorf("Invalid role %s of issuance policy %s.", role, name)
+			}
+		}
+		if policy.affiliation == "" && policy.role == pb.Role_NONE {
+			return nil, errors.New("Issuance policy " + name + " applies to neither an affiliation group nor a role.")
+		}
+		for i, keyType := range policy.keyTypes {
+			policy.keyTypes[i] = strings.ToLower(keyType)
+			if !isSignatureScheme(policy.keyTypes[i]) {
+				return nil, fmt.Errorf("Invalid key type %s of issuance policy %s.", keyType, name)
+			}
+		}
+		if policy.ecertValidity < 0 || policy.tcertValidity < 0 || policy.tcertMaxBatchSize < 0 {
+			return nil, errors.New("Invalid settings of issuance policy " + name + ".")
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+func isSignatureScheme(scheme string) bool {
+	for _, s := range ecertSignatureSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+type policyMatch struct {
+	policy      *issuancePolicy
+	specificity int
+}
+
+// policyMatches sorts the policies applying to a member by increasing specificity.
+type policyMatches []policyMatch
+
+func (m policyMatches) Len() int           { return len(m) }
+func (m policyMatches) Less(i, j int) bool { return m[i].specificity < m[j].specificity }
+func (m policyMatches) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// issuanceLimits returns the settings in effect for the certificates of the member id.
+//
+func (eca *ECA) issuanceLimits(id string) (*issuanceLimits, error) {
+	limits := &issuanceLimits{
+		ecertValidity:     defaultECertValidityPeriod,
+		tcertValidity:     getTCertValidityPeriod(),
+		tcertMaxBatchSize: getTCertMaxBatchSize(),
+	}
+	if eca == nil || len(eca.policies) == 0 {
+		return limits, nil
+	}
+
+	role := pb.Role(eca.readRole(id))
+	affiliation, err := eca.readMemberAffiliation(id)
+	if err != nil {
+		return nil, err
+	}
+	var path []string
+	if affiliation != "" {
+		if path, err = eca.readAffiliationPath(affiliation); err != nil {
+			return nil, err
+		}
+	}
+
+	// the policies applying to the member, the most specific ones last so that their settings prevail
+	var matches policyMatches
+	for _, policy := range eca.policies {
+		specificity := 0
+		if policy.affiliation != "" {
+			depth := -1
+			for i, group := range path {
+				if group == policy.affiliation {
+					depth = i
+				}
+			}
+			if depth < 0 {
+				continue
+			}
+			specificity = 2 * (depth + 1)
+		}
+		if policy.role != pb.Role_NONE {
+			if role&policy.role == 0 {
+				continue
+			}
+			specificity++
+		}
+		matches = append(matches, policyMatch{policy, specificity})
+	}
+	sort.Stable(matches)
+
+	for _, m := range matches {
+		p := m.policy
+		if p.ecertValidity > 0 {
+			limits.ecertValidity = p.ecertValidity
+		}
+		if len(p.keyTypes) > 0 {
+			limits.keyTypes = p.keyTypes
+		}
+		if p.tcertValidity > 0 {
+			limits.tcertValidity = p.tcertValidity
+		}
+		if p.tcertMaxBatchSize > 0 {
+			limits.tcertMaxBatchSize = p.tcertMaxBatchSize
+		}
+	}
+
+	return limits, nil
+}
+
+// allowsKeyType returns true if signing keys of the signature scheme scheme may be certified.
+//
+func (limits *issuanceLimits) allowsKeyType(scheme string) bool {
+	if len(limits.keyTypes) == 0 {
+		return true
+	}
+	return strContained(scheme, limits.keyTypes)
+}
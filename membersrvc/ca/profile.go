@@ -0,0 +1,229 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// Certificate profiles, listed under pki.profiles, set the key usages, extended key
+// usages, subject alternative names and custom extensions of the certificates of a
+// kind, ecert (the enrollment certificates for signing) or tls, issued to the members
+// of some roles, or of any role. The profile restricted to the role of a member
+// prevails over the one of any role.
+
+const (
+	profileECert = "ecert"
+	profileTLS   = "tls"
+
+	// placeholder replaced with the enrollment ID of the member in the subject alternative names
+	profileIDPlaceholder = "{id}"
+)
+
+var profileKeyUsages = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+var profileExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// certificateProfile is a certificate profile, as configured.
+type certificateProfile struct {
+	name        string
+	kind        string
+	roles       pb.Role // any role if none
+	keyUsage    x509.KeyUsage
+	extKeyUsage []x509.ExtKeyUsage
+	dnsNames    []string
+	emails      []string
+	ips         []net.IP
+	extensions  []pkix.Extension
+}
+
+// readCertificateProfiles reads the certificate profiles of the configuration.
+//
+func readCertificateProfiles() ([]*certificateProfile, error) {
+	var names []string
+	for name := range viper.GetStringMap("pki.profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var profiles []*certificateProfile
+	for _, name := range names {
+		key := "pki.profiles." + name + "."
+		profile := &certificateProfile{
+			name:     name,
+			kind:     strings.ToLower(viper.GetString(key + "certificate")),
+			dnsNames: viper.GetStringSlice(key + "dns-names"),
+			emails:   viper.GetStringSlice(key + "emails"),
+		}
+		if profile.kind != profileECert && profile.kind != profileTLS {
+			return nil, fmt.Errorf("Invalid certificate %s of certificate profile %s.", profile.kind, name)
+		}
+
+		for _, role := range viper.GetStringSlice(key + "roles") {
+			r, ok := policyRoles[strings.ToLower(role)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid role %s of certificate profile %s.", role, name)
+			}
+			profile.roles |= r
+		}
+		for _, usage := range viper.GetStringSlice(key + "key-usages") {
+			u, ok := profileKeyUsages[strings.ToLower(usage)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid key usage %s of certificate profile %s.", usage, name)
+			}
+			profile.keyUsage |= u
+		}
+		for _, usage := range viper.GetStringSlice(key + "ext-key-usages") {
+			u, ok := profileExtKeyUsages[strings.ToLower(usage)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid extended key usage %s of certificate profile %s.", usage, name)
+			}
+			profile.extKeyUsage = append(profile.extKeyUsage, u)
+		}
+		for _, addr := range viper.GetStringSlice(key + "ip-addresses") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address %s of certificate profile %s.", addr, name)
+			}
+			profile.ips = append(profile.ips, ip)
+		}
+
+		entries := viper.GetStringMapString(key + "extensions")
+		var entryNames []string
+		for entryName := range entries {
+			entryNames = append(entryNames, entryName)
+		}
+		sort.Strings(entryNames)
+		for _, entryName := range entryNames {
+			ext, err := parseProfileExtension(entries[entryName])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid extension %s of certificate profile %s: %s", entryName, name, err)
+			}
+			profile.extensions = append(profile.extensions, ext)
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// parseProfileExtension parses a custom extension entry {OID};{critical};{value}. The value
+// is DER encoded as a UTF8String, unless given in hexadecimal, already DER encoded, after hex:.
+//
+func parseProfileExtension(entry string) (pkix.Extension, error) {
+	var ext pkix.Extension
+
+	fields := strings.SplitN(entry, ";", 3)
+	if len(fields) != 3 {
+		return ext, errors.New("expected {OID};{critical};{value}")
+	}
+
+	for _, arc := range strings.Split(strings.TrimSpace(fields[0]), ".") {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return ext, errors.New("malformed OID")
+		}
+		ext.Id = append(ext.Id, n)
+	}
+	if len(ext.Id) < 2 {
+		return ext, errors.New("malformed OID")
+	}
+
+	critical, err := strconv.ParseBool(strings.TrimSpace(fields[1]))
+	if err != nil {
+		return ext, err
+	}
+	ext.Critical = critical
+
+	if value := fields[2]; strings.HasPrefix(value, "hex:") {
+		ext.Value, err = hex.DecodeString(strings.TrimPrefix(value, "hex:"))
+	} else {
+		ext.Value, err = asn1.MarshalWithParams(value, "utf8")
+	}
+	return ext, err
+}
+
+// certificateProfile returns the profile of the certificates of kind issued to the members
+// of role, or nil if none applies.
+//
+func (ca *CA) certificateProfile(kind string, role pb.Role) *certificateProfile {
+	var anyRole *certificateProfile
+	for _, profile := range ca.profiles {
+		if profile.kind != kind {
+			continue
+		}
+		if profile.roles == pb.Role_NONE {
+			if anyRole == nil {
+				anyRole = profile
+			}
+			continue
+		}
+		if profile.roles&role != 0 {
+			return profile
+		}
+	}
+	return anyRole
+}
+
+// apply sets the extensions of the profile in tmpl, the template of a certificate of id.
+// The key usages of the profile add to the one the certificate is issued for.
+//
+func (profile *certificateProfile) apply(tmpl *x509.Certificate, id string) {
+	tmpl.KeyUsage |= profile.keyUsage
+	tmpl.ExtKeyUsage = profile.extKeyUsage
+
+	for _, name := range profile.dnsNames {
+		tmpl.DNSNames = append(tmpl.DNSNames, strings.Replace(name, profileIDPlaceholder, id, -1))
+	}
+	for _, email := range profile.emails {
+		tmpl.EmailAddresses = append(tmpl.EmailAddresses, strings.Replace(email, profileIDPlaceholder, id, -1))
+	}
+	tmpl.IPAddresses = append(tmpl.IPAddresses, profile.ips...)
+
+	tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, profile.extensions...)
+}
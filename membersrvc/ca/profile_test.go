@@ -0,0 +1,160 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+func TestParseProfileExtension(t *testing.T) {
+	ext, err := parseProfileExtension("1.3.6.1.4.1.99999.1;true;ACompany")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if _, err = asn1.Unmarshal(ext.Value, &value); err != nil || value != "ACompany" {
+		t.Fatalf("Unexpected extension value [%x]", ext.Value)
+	}
+	if !ext.Critical || !ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}) {
+		t.Fatalf("Unexpected extension [%v]", ext)
+	}
+
+	if ext, err = parseProfileExtension("1.2.3;false;hex:0500"); err != nil || len(ext.Value) != 2 {
+		t.Fatalf("Failed parsing a DER encoded value [%v]", err)
+	}
+
+	for _, entry := range []string{"1.2.3;false", "1.x.3;false;v", "1;false;v", "1.2.3;maybe;v", "1.2.3;false;hex:zz"} {
+		if _, err = parseProfileExtension(entry); err == nil {
+			t.Fatalf("Extension [%s] should be rejected", entry)
+		}
+	}
+}
+
+// setProfiles configures the certificate profiles, each given by its settings, and
+// returns a function removing them.
+func setProfiles(profiles map[string]map[string]interface{}) func() {
+	var keys []string
+	names := make(map[string]interface{})
+	for name, settings := range profiles {
+		names[name] = settings
+		for setting, value := range settings {
+			key := "pki.profiles." + name + "." + setting
+			viper.Set(key, value)
+			keys = append(keys, key)
+		}
+	}
+	viper.Set("pki.profiles", names)
+
+	return func() {
+		for _, key := range keys {
+			viper.Set(key, nil)
+		}
+		viper.Set("pki.profiles", nil)
+	}
+}
+
+func TestCertificateProfiles(t *testing.T) {
+	defer setProfiles(map[string]map[string]interface{}{
+		"any-tls": {
+			"certificate":    "tls",
+			"ext-key-usages": "clientAuth",
+		},
+		"peer-tls": {
+			"certificate":    "tls",
+			"roles":          "peer validator",
+			"key-usages":     "keyEncipherment",
+			"ext-key-usages": "serverAuth clientAuth",
+			"dns-names":      "{id} {id}.example.com",
+			"ip-addresses":   "127.0.0.1",
+			"extensions":     map[string]interface{}{"extension-0": "1.3.6.1.4.1.99999.1;false;ACompany"},
+		},
+	})()
+
+	profiles, err := readCertificateProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCA := &CA{cert: eca.cert, priv: eca.priv, profiles: profiles}
+
+	if profile := tlsCA.certificateProfile(profileTLS, pb.Role_CLIENT); profile == nil || profile.name != "any-tls" {
+		t.Fatal("The profile of any role should apply to clients")
+	}
+	if profile := tlsCA.certificateProfile(profileECert, pb.Role_CLIENT); profile != nil {
+		t.Fatal("No profile should apply to enrollment certificates")
+	}
+
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := NewDefaultCertificateSpec("peer0", &priv.PublicKey, x509.KeyUsageDigitalSignature)
+	spec.profile = tlsCA.certificateProfile(profileTLS, pb.Role_VALIDATOR)
+	raw, err := tlsCA.newCertificateFromSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment {
+		t.Fatalf("Unexpected key usage [%v]", cert.KeyUsage)
+	}
+	if len(cert.ExtKeyUsage) != 2 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Fatalf("Unexpected extended key usages [%v]", cert.ExtKeyUsage)
+	}
+	if len(cert.DNSNames) != 2 || cert.DNSNames[0] != "peer0" || cert.DNSNames[1] != "peer0.example.com" {
+		t.Fatalf("Unexpected DNS names [%v]", cert.DNSNames)
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "127.0.0.1" {
+		t.Fatalf("Unexpected IP addresses [%v]", cert.IPAddresses)
+	}
+	if err = cert.VerifyHostname("peer0.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, ext := range cert.Extensions {
+		found = found || ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1})
+	}
+	if !found {
+		t.Fatal("The custom extension of the profile is missing")
+	}
+}
+
+func TestCertificateProfilesInvalid(t *testing.T) {
+	for _, profile := range []map[string]interface{}{
+		{"certificate": "tcert"},
+		{"certificate": "tls", "roles": "admin"},
+		{"certificate": "tls", "key-usages": "signEverything"},
+		{"certificate": "tls", "ip-addresses": "localhost"},
+	} {
+		unset := setProfiles(map[string]map[string]interface{}{"invalid": profile})
+		_, err := readCertificateProfiles()
+		unset()
+		if err == nil {
+			t.Fatalf("Profile [%v] should be rejected", profile)
+		}
+	}
+}
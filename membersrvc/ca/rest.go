@@ -0,0 +1,429 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gocraft/web"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+// restECA, restTCA and restTLSCA hold the CAs served by the REST facade. This is necessary
+// due to how the gocraft/web package implements context initialization.
+var restECA *ECA
+var restTCA *TCA
+var restTLSCA *TLSCA
+
+// CAREST is the REST facade of the ECA. It exposes user registration, single or bulk,
+// the regeneration of one-time passwords, enrollment, re-enrollment, renewal and the CA chain as HTTP/JSON endpoints so that
+// clients without gRPC stubs can onboard identities. Request and response
+// bodies are the JSON mapping of the messages in ca.proto. It is also the
+// HTTP distribution point of the CRLs of the ECA and the TCA.
+//
+type CAREST struct {
+	ecap *ECAP
+	ecaa *ECAA
+	tcap *TCAP
+}
+
+// maxRESTRequestSize is the maximum size of the body of a REST request, enough for a bulk
+// registration of maxBulkRegistration users.
+const maxRESTRequestSize = 1 << 20
+
+// restResult is the response payload of a failed REST request.
+type restResult struct {
+	OK    string `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+// caChain is the response payload of the CA chain endpoint.
+type caChain struct {
+	Chain []string `json:"chain"`
+}
+
+// caBundleResult is the response payload of the CA bundle endpoint: the PEM bundle
+// of the chains of the CAs, its hexadecimal SHA-256 hash, and the chain of each CA.
+type caBundleResult struct {
+	Bundle string              `json:"bundle"`
+	SHA256 string              `json:"sha256"`
+	Chains map[string][]string `json:"chains"`
+}
+
+// SetCAs sets the ECA and TCA services on the request context.
+//
+func (s *CAREST) SetCAs(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	s.ecap = &ECAP{restECA}
+	s.ecaa = &ECAA{restECA}
+	s.tcap = &TCAP{restTCA}
+
+	next(rw, req)
+}
+
+// SetResponseType sets the content type of every response to JSON.
+//
+func (s *CAREST) SetResponseType(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	next(rw, req)
+}
+
+// RegisterUser registers a new user on behalf of a registrar and returns the
+// one-time enrollment password. The body is a signed RegisterUserReq.
+//
+func (s *CAREST) RegisterUser(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:RegisterUser")
+
+	in := &pb.RegisterUserReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	tok, err := s.ecaa.RegisterUser(context.Background(), in)
+	writeRESTResponse(rw, tok, err)
+}
+
+// RegisterUsers registers many users on behalf of a registrar in one call and
+// returns the result, with the one-time enrollment password, of each user. The
+// body is a RegisterUsersReq, signed by the registrar, listing the users.
+//
+func (s *CAREST) RegisterUsers(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:RegisterUsers")
+
+	in := &pb.RegisterUsersReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	resp, err := s.ecaa.RegisterUsers(context.Background(), in)
+	writeRESTResponse(rw, resp, err)
+}
+
+// ResetSecret regenerates the one-time enrollment password of an identity, e.g.
+// once expired or void, and returns it. The body is a SecretResetReq signed by
+// a registrar allowed to register the identity.
+//
+func (s *CAREST) ResetSecret(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:ResetSecret")
+
+	in := &pb.SecretResetReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	tok, err := s.ecaa.ResetSecret(context.Background(), in)
+	writeRESTResponse(rw, tok, err)
+}
+
+// RefreshAttributes has the ACA fetch anew the attributes of an identity, e.g.
+// from the system updating them at the attribute source. The body is an
+// AttributesRefreshReq signed by a registrar allowed to register the identity.
+//
+func (s *CAREST) RefreshAttributes(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:RefreshAttributes")
+
+	in := &pb.AttributesRefreshReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	status, err := s.ecaa.RefreshAttributes(context.Background(), in)
+	writeRESTResponse(rw, status, err)
+}
+
+// UpdateAttributes changes the attributes of an identity and flags its TCerts
+// for refresh. The body is an AttributesUpdateReq signed by a registrar
+// allowed to register the identity.
+//
+func (s *CAREST) UpdateAttributes(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:UpdateAttributes")
+
+	in := &pb.AttributesUpdateReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	status, err := s.ecaa.UpdateAttributes(context.Background(), in)
+	writeRESTResponse(rw, status, err)
+}
+
+// Enroll runs one phase of the enrollment protocol. The body is an
+// ECertCreateReq carrying the one-time password. The first phase returns the
+// encrypted challenge, the second, signed, phase the certificate pair.
+//
+func (s *CAREST) Enroll(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:Enroll")
+
+	in := &pb.ECertCreateReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	resp, err := s.ecap.CreateCertificatePair(withRequestSource(context.Background(), req.RemoteAddr), in)
+	writeRESTResponse(rw, resp, err)
+}
+
+// EnrollCSR enrolls a member in a single round, with its one-time password and
+// certificate requests for the keys it generated. The body is an ECertCSRReq.
+//
+func (s *CAREST) EnrollCSR(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:EnrollCSR")
+
+	in := &pb.ECertCSRReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	resp, err := s.ecap.CreateCertificatePairFromCSR(withRequestSource(context.Background(), req.RemoteAddr), in)
+	writeRESTResponse(rw, resp, err)
+}
+
+// Reenroll exchanges a request signed with the current enrollment signing key
+// for a new one-time password, to be used with Enroll. The body is an
+// ECertCreateReq carrying the timestamp, the identity and the signature.
+//
+func (s *CAREST) Reenroll(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:Reenroll")
+
+	in := &pb.ECertCreateReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	tok, err := s.ecap.reenroll(in)
+	writeRESTResponse(rw, tok, err)
+}
+
+// Renew re-enrolls a member authenticated with its current enrollment
+// certificate for signing and returns the new certificate pair. The body is
+// an ECertRenewReq.
+//
+func (s *CAREST) Renew(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:Renew")
+
+	in := &pb.ECertRenewReq{}
+	if !readRESTRequest(rw, req, in) {
+		return
+	}
+
+	resp, err := s.ecap.RenewCertificatePair(context.Background(), in)
+	writeRESTResponse(rw, resp, err)
+}
+
+// GetCAChain returns the PEM encoded certificate chain of the ECA, from its
+// certificate up to the root.
+//
+func (s *CAREST) GetCAChain(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:GetCAChain")
+
+	certs, _ := s.ecap.ReadCACertificateChain(context.Background(), &pb.Empty{})
+	chain := caChain{}
+	for _, cert := range certs.Certs {
+		chain.Chain = append(chain.Chain, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})))
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(chain)
+}
+
+// GetCABundle returns the PEM encoded certificate chains of the ECA, TCA and TLSCA,
+// as a bundle along with its SHA-256 hash, and one by one.
+//
+func (s *CAREST) GetCABundle(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:GetCABundle")
+
+	result := caBundleResult{Chains: make(map[string][]string)}
+	cas := restBundleCAs()
+	for _, ca := range cas {
+		for _, raw := range ca.chain {
+			result.Chains[ca.db.name] = append(result.Chains[ca.db.name], string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})))
+		}
+	}
+	bundle, hash := caBundle(cas...)
+	result.Bundle = string(bundle)
+	result.SHA256 = hash
+
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(result)
+}
+
+// GetCABundlePEM returns the PEM encoded certificate chains of the ECA, TCA and TLSCA
+// as a bundle, e.g. for curl and the TLS stacks. Its SHA-256 hash is the ETag.
+//
+func (s *CAREST) GetCABundlePEM(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:GetCABundlePEM")
+
+	bundle, hash := caBundle(restBundleCAs()...)
+
+	rw.Header().Set("Content-Type", "application/x-pem-file")
+	rw.Header().Set("ETag", `"`+hash+`"`)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bundle)
+}
+
+// restBundleCAs returns the CAs served by the REST facade whose chains are bundled.
+func restBundleCAs() []*CA {
+	cas := []*CA{restECA.CA}
+	if restTCA != nil {
+		cas = append(cas, restTCA.CA)
+	}
+	if restTLSCA != nil {
+		cas = append(cas, restTLSCA.CA)
+	}
+	return cas
+}
+
+// GetECACRL returns the current CRL of the ECA, DER encoded.
+//
+func (s *CAREST) GetECACRL(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:GetECACRL")
+
+	crl, err := s.ecap.ReadCRL(context.Background(), &pb.Empty{})
+	writeCRL(rw, crl, err)
+}
+
+// GetTCACRL returns the current CRL of the TCA, DER encoded.
+//
+func (s *CAREST) GetTCACRL(rw web.ResponseWriter, req *web.Request) {
+	Trace.Println("REST CA:GetTCACRL")
+
+	if s.tcap.tca == nil {
+		s.NotFound(rw, req)
+		return
+	}
+
+	crl, err := s.tcap.ReadCRL(context.Background(), &pb.Empty{})
+	writeCRL(rw, crl, err)
+}
+
+func writeCRL(rw web.ResponseWriter, crl *pb.CRL, err error) {
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(restResult{Error: err.Error()})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/pkix-crl")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(crl.Crl)
+}
+
+// NotFound returns a custom landing page when a given CA REST resource does not exist.
+//
+func (s *CAREST) NotFound(rw web.ResponseWriter, req *web.Request) {
+	rw.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(rw).Encode(restResult{Error: "CA REST resource not found."})
+}
+
+// readRESTRequest decodes the JSON body of req, of maxRESTRequestSize bytes at most, into
+// msg. On failure it writes a bad request response and returns false.
+//
+func readRESTRequest(rw web.ResponseWriter, req *web.Request, msg proto.Message) bool {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, maxRESTRequestSize))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(restResult{Error: fmt.Sprintf("Failed reading request body of %d bytes at most.", maxRESTRequestSize)})
+		Error.Printf("Failed reading CA REST request body: %s", err)
+		return false
+	}
+
+	if err = jsonpb.UnmarshalString(string(body), msg); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(restResult{Error: "Invalid JSON request: " + err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// writeRESTResponse writes msg as JSON, or err as a bad request if it is set.
+//
+func writeRESTResponse(rw web.ResponseWriter, msg proto.Message, err error) {
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(restResult{Error: err.Error()})
+		return
+	}
+
+	m := jsonpb.Marshaler{}
+	js, err := m.MarshalToString(msg)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(restResult{Error: "Internal JSON error when marshalling response."})
+		Error.Printf("Failed marshalling CA REST response: %s", err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte(js))
+}
+
+func buildCARESTRouter() *web.Router {
+	router := web.New(CAREST{})
+
+	router.Middleware((*CAREST).SetCAs)
+	router.Middleware((*CAREST).SetResponseType)
+
+	router.Post("/registrar", (*CAREST).RegisterUser)
+	router.Post("/registrar/bulk", (*CAREST).RegisterUsers)
+	router.Post("/registrar/secret", (*CAREST).ResetSecret)
+	router.Post("/registrar/attributes", (*CAREST).RefreshAttributes)
+	router.Put("/registrar/attributes", (*CAREST).UpdateAttributes)
+	router.Post("/enroll", (*CAREST).Enroll)
+	router.Post("/enroll/csr", (*CAREST).EnrollCSR)
+	router.Post("/reenroll", (*CAREST).Reenroll)
+	router.Post("/renew", (*CAREST).Renew)
+	router.Get("/cachain", (*CAREST).GetCAChain)
+	router.Get("/cabundle", (*CAREST).GetCABundle)
+	router.Get("/cabundle.pem", (*CAREST).GetCABundlePEM)
+	router.Get("/eca/crl", (*CAREST).GetECACRL)
+	router.Get("/tca/crl", (*CAREST).GetTCACRL)
+
+	router.NotFound((*CAREST).NotFound)
+
+	return router
+}
+
+// StartRESTServer serves the REST facade of eca, the CRL of tca, and the
+// bundle of the certificate chains of eca, tca and tlsca, on
+// server.rest.address. It uses the TLS credentials of the gRPC server when
+// these are configured.
+//
+func StartRESTServer(eca *ECA, tca *TCA, tlsca *TLSCA) {
+	restECA = eca
+	restTCA = tca
+	restTLSCA = tlsca
+	router := buildCARESTRouter()
+
+	var err error
+	if viper.GetString("server.tls.cert.file") != "" {
+		err = http.ListenAndServeTLS(viper.GetString("server.rest.address"), viper.GetString("server.tls.cert.file"), viper.GetString("server.tls.key.file"), router)
+	} else {
+		err = http.ListenAndServe(viper.GetString("server.rest.address"), router)
+	}
+	if err != nil {
+		Error.Printf("Fail to start CA REST server: %s", err)
+	}
+}
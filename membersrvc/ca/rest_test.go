@@ -0,0 +1,328 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google/protobuf"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+var restUser = User{enrollID: "restUser", role: 1, affiliation: "institution_a", affiliationRole: "00001"}
+
+func performRESTRequest(t *testing.T, method, path string, msg proto.Message) *httptest.ResponseRecorder {
+	restECA = eca
+	restTCA = tca
+	router := buildCARESTRouter()
+
+	body := ""
+	if msg != nil {
+		m := jsonpb.Marshaler{}
+		js, err := m.MarshalToString(msg)
+		if err != nil {
+			t.Fatalf("Failed marshalling request: [%s]", err)
+		}
+		body = js
+	}
+
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed creating request: [%s]", err)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	return resp
+}
+
+func signRequest(t *testing.T, priv *ecdsa.PrivateKey, msg proto.Message) *pb.Signature {
+	raw, _ := proto.Marshal(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, priv, primitives.Hash(raw))
+	if err != nil {
+		t.Fatalf("Failed signing request: [%s]", err)
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+
+	return &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}
+}
+
+func newReenrollRequest(t *testing.T, user User, priv *ecdsa.PrivateKey) *pb.ECertCreateReq {
+	req := &pb.ECertCreateReq{
+		Ts: &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id: &pb.Identity{Id: user.enrollID}}
+	req.Sig = signRequest(t, priv, req)
+
+	return req
+}
+
+func TestRESTGetCAChain(t *testing.T) {
+	resp := performRESTRequest(t, "GET", "/cachain", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	var chain caChain
+	if err := json.Unmarshal(resp.Body.Bytes(), &chain); err != nil {
+		t.Fatalf("Failed decoding CA chain: [%s]", err)
+	}
+	if len(chain.Chain) != 1 {
+		t.Fatalf("Expected a chain of length 1, got %d", len(chain.Chain))
+	}
+	block, _ := pem.Decode([]byte(chain.Chain[0]))
+	if block == nil || !bytes.Equal(block.Bytes, eca.raw) {
+		t.Fatal("CA chain does not contain the ECA certificate")
+	}
+}
+
+func TestRESTGetCABundle(t *testing.T) {
+	resp := performRESTRequest(t, "GET", "/cabundle", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	var result caBundleResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed decoding CA bundle: [%s]", err)
+	}
+	if len(result.Chains["eca"]) != 1 || len(result.Chains["tca"]) != 1 {
+		t.Fatalf("Expected the chains of the ECA and the TCA, got %v", result.Chains)
+	}
+	hash := sha256.Sum256([]byte(result.Bundle))
+	if result.SHA256 != hex.EncodeToString(hash[:]) {
+		t.Fatal("The hash does not match the bundle")
+	}
+
+	var certs [][]byte
+	for rest := []byte(result.Bundle); ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		certs = append(certs, block.Bytes)
+	}
+	if len(certs) != 2 || !bytes.Equal(certs[0], eca.raw) || !bytes.Equal(certs[1], tca.raw) {
+		t.Fatal("The bundle should hold the certificates of the ECA and the TCA")
+	}
+
+	resp = performRESTRequest(t, "GET", "/cabundle.pem", nil)
+	if resp.Code != http.StatusOK || resp.Body.String() != result.Bundle {
+		t.Fatalf("Expected the PEM bundle, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if resp.Header().Get("ETag") != `"`+result.SHA256+`"` {
+		t.Fatalf("Unexpected ETag %s", resp.Header().Get("ETag"))
+	}
+}
+
+func TestRESTRegisterAndEnrollUser(t *testing.T) {
+	req := &pb.RegisterUserReq{
+		Id:          &pb.Identity{Id: restUser.enrollID},
+		Role:        pb.Role(restUser.role),
+		Account:     restUser.affiliation,
+		Affiliation: restUser.affiliationRole,
+		Registrar:   &pb.Registrar{Id: &pb.Identity{Id: testAdmin.enrollID}}}
+	req.Sig = signRequest(t, testAdmin.enrollPrivKey, req)
+
+	resp := performRESTRequest(t, "POST", "/registrar", req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	tok := &pb.Token{}
+	if err := jsonpb.UnmarshalString(resp.Body.String(), tok); err != nil {
+		t.Fatalf("Failed decoding token: [%s]", err)
+	}
+	if len(tok.Tok) == 0 {
+		t.Fatal("Expected a one-time password")
+	}
+	restUser.enrollPwd = tok.Tok
+
+	if err := enrollUser(&restUser); err != nil {
+		t.Fatalf("Failed to enroll restUser: [%s]", err)
+	}
+}
+
+func TestRESTEnrollBadToken(t *testing.T) {
+	req := &pb.ECertCreateReq{
+		Ts:  &google_protobuf.Timestamp{Seconds: time.Now().Unix(), Nanos: 0},
+		Id:  &pb.Identity{Id: restUser.enrollID},
+		Tok: &pb.Token{Tok: []byte("badPassword")}}
+
+	resp := performRESTRequest(t, "POST", "/enroll", req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, resp.Code)
+	}
+}
+
+func TestRESTReenroll(t *testing.T) {
+	resp := performRESTRequest(t, "POST", "/reenroll", newReenrollRequest(t, restUser, restUser.enrollPrivKey))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	tok := &pb.Token{}
+	if err := jsonpb.UnmarshalString(resp.Body.String(), tok); err != nil {
+		t.Fatalf("Failed decoding token: [%s]", err)
+	}
+	restUser.enrollPwd = tok.Tok
+
+	if err := enrollUser(&restUser); err != nil {
+		t.Fatalf("Failed to enroll restUser again: [%s]", err)
+	}
+}
+
+func TestRESTReenrollBadSignature(t *testing.T) {
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := performRESTRequest(t, "POST", "/reenroll", newReenrollRequest(t, restUser, priv))
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, resp.Code)
+	}
+}
+
+func TestRESTRequestTooLarge(t *testing.T) {
+	restECA = eca
+	restTCA = tca
+	router := buildCARESTRouter()
+
+	body := `{"id":{"id":"` + strings.Repeat("a", maxRESTRequestSize) + `"}}`
+	req, err := http.NewRequest("POST", "/enroll", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed creating request: [%s]", err)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "bytes at most") {
+		t.Fatalf("Expected status %d for a request too large, got %d: %s", http.StatusBadRequest, resp.Code, resp.Body.String())
+	}
+}
+
+func TestRESTNotFound(t *testing.T) {
+	resp := performRESTRequest(t, "GET", "/nonexistent", nil)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, resp.Code)
+	}
+}
+
+func TestRESTGetCRL(t *testing.T) {
+	for _, ca := range []*CA{eca.CA, tca.CA} {
+		path := "/eca/crl"
+		if ca == tca.CA {
+			path = "/tca/crl"
+		}
+
+		resp := performRESTRequest(t, "GET", path, nil)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+		}
+		crl, err := x509.ParseCRL(resp.Body.Bytes())
+		if err != nil {
+			t.Fatalf("Failed parsing CRL: [%s]", err)
+		}
+		if err = ca.cert.CheckCRLSignature(crl); err != nil {
+			t.Fatalf("Invalid CRL signature: [%s]", err)
+		}
+	}
+}
+
+func TestRESTRegisterUsers(t *testing.T) {
+	bulkUsers := []User{
+		{enrollID: "bulkUser1", role: 1, affiliation: "institution_a", affiliationRole: "00001"},
+		{enrollID: "bulkUser2", role: 2, affiliation: "institution_a", affiliationRole: "00002"},
+		{enrollID: "bulkUser3", role: 1, affiliation: "bank_a", affiliationRole: "00001"},
+		testUser,
+	}
+
+	req := &pb.RegisterUsersReq{Registrar: &pb.Registrar{Id: &pb.Identity{Id: testAdmin.enrollID}}}
+	for _, user := range bulkUsers {
+		req.Users = append(req.Users, &pb.RegisterUserReq{
+			Id:          &pb.Identity{Id: user.enrollID},
+			Role:        pb.Role(user.role),
+			Account:     user.affiliation,
+			Affiliation: user.affiliationRole})
+	}
+	req.Sig = signRequest(t, testAdmin.enrollPrivKey, req)
+
+	resp := performRESTRequest(t, "POST", "/registrar/bulk", req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.Code, resp.Body.String())
+	}
+
+	results := &pb.RegisterUsersResp{}
+	if err := jsonpb.UnmarshalString(resp.Body.String(), results); err != nil {
+		t.Fatalf("Failed decoding results: [%s]", err)
+	}
+	if len(results.Results) != len(bulkUsers) {
+		t.Fatalf("Expected %d results, got %d", len(bulkUsers), len(results.Results))
+	}
+	for i, result := range results.Results {
+		if result.Id.Id != bulkUsers[i].enrollID {
+			t.Fatalf("Result %d is for %s, expected %s", i, result.Id.Id, bulkUsers[i].enrollID)
+		}
+		registered := result.Tok != nil && len(result.Tok.Tok) > 0
+		// the user outside the registrar's affiliation and the duplicate one are refused
+		if registered != (i < 2) || registered == (result.Error != "") {
+			t.Fatalf("Unexpected result for %s: %v", result.Id.Id, result)
+		}
+	}
+
+	bulkUser := bulkUsers[0]
+	bulkUser.enrollPwd = results.Results[0].Tok.Tok
+	if err := enrollUser(&bulkUser); err != nil {
+		t.Fatalf("Failed to enroll %s: [%s]", bulkUser.enrollID, err)
+	}
+}
+
+func TestRESTRegisterUsersBadSignature(t *testing.T) {
+	req := &pb.RegisterUsersReq{
+		Users: []*pb.RegisterUserReq{{
+			Id:          &pb.Identity{Id: "bulkUser4"},
+			Role:        pb.Role_CLIENT,
+			Account:     "institution_a",
+			Affiliation: "00001"}},
+		Registrar: &pb.Registrar{Id: &pb.Identity{Id: testAdmin.enrollID}}}
+	req.Sig = signRequest(t, testUser.enrollPrivKey, req)
+
+	resp := performRESTRequest(t, "POST", "/registrar/bulk", req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, resp.Code)
+	}
+	if err := eca.readUser("bulkUser4").Scan(new(int), new([]byte), new(int), new([]byte), new(string)); err == nil {
+		t.Fatal("No user should be registered with a bad signature")
+	}
+}
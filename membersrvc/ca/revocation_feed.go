@@ -0,0 +1,197 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"google/protobuf"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// Validators subscribe to the revocation feed of the ECAP, instead of waiting for their next
+// poll of the CRLs, to block revoked identities within seconds. The feed pushes the rows added
+// to the Revocations tables of the ECA and the TCA, along with the new CRL of the CA, which the
+// subscribers verify as a polled one: the revocations served by this instance at once, those
+// served by the instances sharing its databases within server.crl.poll. A subscriber too slow
+// to keep up is disconnected, and polls the CRLs on its next subscription.
+
+// Revocation events buffered for each subscriber of the revocation feed
+const revocationFeedBuffer = 64
+
+// revocations pushes the revocations of the CAs to the subscribers of the revocation feed.
+var revocations = newRevocationFeed()
+
+// revocationFeed fans the revocation events out to its subscribers.
+type revocationFeed struct {
+	mutex       sync.Mutex
+	subscribers map[chan *pb.RevocationEvent]struct{}
+}
+
+func newRevocationFeed() *revocationFeed {
+	return &revocationFeed{subscribers: make(map[chan *pb.RevocationEvent]struct{})}
+}
+
+// subscribe returns the channel of the events published from now on. The channel is
+// closed if the subscriber falls behind by more than revocationFeedBuffer events.
+//
+func (f *revocationFeed) subscribe() chan *pb.RevocationEvent {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	events := make(chan *pb.RevocationEvent, revocationFeedBuffer)
+	f.subscribers[events] = struct{}{}
+	return events
+}
+
+// unsubscribe closes events, unless already closed by publish.
+//
+func (f *revocationFeed) unsubscribe(events chan *pb.RevocationEvent) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.subscribers[events]; ok {
+		delete(f.subscribers, events)
+		close(events)
+	}
+}
+
+// publish sends event to the subscribers, without waiting for them: the subscribers whose
+// buffer is full are dropped.
+//
+func (f *revocationFeed) publish(event *pb.RevocationEvent) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for events := range f.subscribers {
+		select {
+		case events <- event:
+			revocationEvents.inc("sent")
+		default:
+			revocationEvents.inc("dropped")
+			Warning.Println("Revocation feed subscriber dropped, too many events waiting for delivery.")
+			delete(f.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// skipPublishedRevocations marks the revocations recorded so far as published, the
+// subscribers being sent the current CRL first.
+//
+func (ca *CA) skipPublishedRevocations() error {
+	ca.feedMutex.Lock()
+	defer ca.feedMutex.Unlock()
+
+	var last sql.NullInt64
+	if err := ca.db.QueryRow("SELECT max(row) FROM Revocations").Scan(&last); err != nil {
+		return err
+	}
+	ca.lastRevocation = last.Int64
+	return nil
+}
+
+// publishNewRevocations publishes the revocations recorded since the last ones published,
+// by this CA or by a replica sharing its database, along with a new CRL. Serials revoked
+// together, for the same reason and as of the same time, are published in one event.
+//
+func (ca *CA) publishNewRevocations() error {
+	ca.feedMutex.Lock()
+	defer ca.feedMutex.Unlock()
+
+	rows, err := ca.db.Query("SELECT row, serial, reason, invalidAt FROM Revocations WHERE row>? ORDER BY row", ca.lastRevocation)
+	if err != nil {
+		return err
+	}
+	var events []*pb.RevocationEvent
+	last := ca.lastRevocation
+	for rows.Next() {
+		var row, reason, invalidAt int64
+		var serial string
+		if err = rows.Scan(&row, &serial, &reason, &invalidAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if n := len(events); n == 0 || events[n-1].Reason != pb.RevocationReason(reason) || events[n-1].InvalidAt.Seconds != invalidAt {
+			events = append(events, &pb.RevocationEvent{
+				Ca:        ca.db.name,
+				Reason:    pb.RevocationReason(reason),
+				InvalidAt: &google_protobuf.Timestamp{Seconds: invalidAt},
+			})
+		}
+		event := events[len(events)-1]
+		event.Serials = append(event.Serials, serial)
+		last = row
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || len(events) == 0 {
+		return err
+	}
+
+	crl, err := ca.generateCRL()
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		event.Crl = &pb.CRL{Crl: crl}
+		revocations.publish(event)
+	}
+	ca.lastRevocation = last
+	return nil
+}
+
+// WatchRevocations streams the revocations of the ECA and the TCA, as they happen. The
+// current CRLs of the CAs are sent first, so that the subscriber misses no revocation.
+//
+func (ecap *ECAP) WatchRevocations(in *pb.Empty, stream pb.ECAP_WatchRevocationsServer) error {
+	Trace.Println("gRPC ECAP:WatchRevocations")
+
+	events := revocations.subscribe()
+	defer revocations.unsubscribe(events)
+
+	cas := []*CA{ecap.eca.CA}
+	if ecap.eca.tca != nil {
+		cas = append(cas, ecap.eca.tca.CA)
+	}
+	for _, ca := range cas {
+		crl, err := ca.readCRL()
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(&pb.RevocationEvent{Ca: ca.db.name, Crl: &pb.CRL{Crl: crl}}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return errors.New("Revocation feed subscriber too slow, subscribe again.")
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
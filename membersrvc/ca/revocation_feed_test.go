@@ -0,0 +1,160 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+)
+
+// revocationStream passes on the revocation events streamed by the ECAP.
+type revocationStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pb.RevocationEvent
+}
+
+func (stream *revocationStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *revocationStream) Send(event *pb.RevocationEvent) error {
+	stream.events <- event
+	return nil
+}
+
+func TestRevocationFeed(t *testing.T) {
+	feed := newRevocationFeed()
+	fast, slow := feed.subscribe(), feed.subscribe()
+
+	for i := 0; i < revocationFeedBuffer; i++ {
+		feed.publish(&pb.RevocationEvent{Ca: "eca"})
+	}
+	for i := 0; i < revocationFeedBuffer; i++ {
+		<-fast
+	}
+
+	// the subscriber whose buffer is full is dropped, the others are not
+	feed.publish(&pb.RevocationEvent{Ca: "tca"})
+	if event := <-fast; event.Ca != "tca" {
+		t.Fatalf("Expected the event of the TCA, got the one of %s", event.Ca)
+	}
+	for range slow {
+	}
+	if len(feed.subscribers) != 1 {
+		t.Fatal("The slow subscriber should be dropped")
+	}
+
+	feed.unsubscribe(slow)
+	feed.unsubscribe(fast)
+	if _, ok := <-fast; ok || len(feed.subscribers) != 0 {
+		t.Fatal("The subscriber should be unsubscribed")
+	}
+}
+
+func TestWatchRevocations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &revocationStream{ctx: ctx, events: make(chan *pb.RevocationEvent, 8)}
+	done := make(chan error)
+	go func() {
+		done <- (&ECAP{eca}).WatchRevocations(&pb.Empty{}, stream)
+	}()
+
+	// the current CRLs of the ECA and the TCA are sent first
+	for _, name := range []string{"eca", "tca"} {
+		event := <-stream.events
+		if event.Ca != name || event.Crl == nil || len(event.Crl.Crl) == 0 {
+			t.Fatalf("Expected the current CRL of the %s, got %v", name, event)
+		}
+	}
+
+	invalidAt := time.Now().Add(-time.Hour)
+	if err := tca.revokeSerials([]string{"424242"}, pb.RevocationReason_KEY_COMPROMISE, invalidAt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-stream.events:
+		if event.Ca != "tca" || len(event.Serials) != 1 || event.Serials[0] != "424242" || event.Reason != pb.RevocationReason_KEY_COMPROMISE || event.InvalidAt.Seconds != invalidAt.Unix() {
+			t.Fatalf("Unexpected revocation event %v", event)
+		}
+		crl, err := x509.ParseCRL(event.Crl.Crl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tca.cert.CheckCRLSignature(crl); err != nil {
+			t.Fatal(err)
+		}
+		revoked := crl.TBSCertList.RevokedCertificates
+		if len(revoked) == 0 || revoked[len(revoked)-1].SerialNumber.String() != "424242" {
+			t.Fatal("The CRL of the event should list the revoked certificate")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The revocation should be pushed to the subscriber")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected the feed to end with the stream, got %v", err)
+	}
+}
+
+func TestWatchRevocationsOfReplica(t *testing.T) {
+	events := revocations.subscribe()
+	defer revocations.unsubscribe(events)
+
+	// a replica sharing the database of the TCA records a revocation
+	invalidAt := time.Now().Add(-time.Minute).Unix()
+	if _, err := tca.db.Exec("INSERT INTO Revocations (serial, revokedAt, reason, invalidAt) VALUES (?, ?, ?, ?)", "434343", time.Now().Unix(), int(pb.RevocationReason_SUPERSEDED), invalidAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := tca.publishNewRevocations(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Ca != "tca" || len(event.Serials) != 1 || event.Serials[0] != "434343" || event.Reason != pb.RevocationReason_SUPERSEDED || event.InvalidAt.Seconds != invalidAt {
+			t.Fatalf("Unexpected revocation event %v", event)
+		}
+		crl, err := x509.ParseCRL(event.Crl.Crl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revoked := crl.TBSCertList.RevokedCertificates
+		if len(revoked) == 0 || revoked[len(revoked)-1].SerialNumber.String() != "434343" {
+			t.Fatal("The CRL of the event should list the revoked certificate")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The revocation of the replica should be pushed to the subscriber")
+	}
+
+	// it is published once
+	if err := tca.publishNewRevocations(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("Unexpected revocation event %v", event)
+	default:
+	}
+}
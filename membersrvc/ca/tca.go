@@ -17,6 +17,7 @@ limitations under the License.
 package ca
 
 import (
+	"crypto/ecdsa"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/x509"
@@ -25,6 +26,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"io/ioutil"
+	"sync"
+	"time"
 
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
@@ -48,13 +51,29 @@ var (
 	RootPreKeySize = 48
 )
 
+const (
+	// Longest validity period of the TCerts, unless tca.tcert.validity-period is set
+	defaultTCertValidityPeriod = 90 * 24 * time.Hour
+
+	// Largest number of TCerts issued at once, unless tca.tcert.max-batch-size is set
+	defaultTCertMaxBatchSize = 1000
+
+	// Largest number of TCerts streamed in response to a single request, unless tca.tcert.max-stream-size is set
+	defaultTCertMaxStreamSize = 100000
+)
+
 // TCA is the transaction certificate authority.
 type TCA struct {
 	*CA
 	eca        *ECA
 	hmacKey    []byte
 	rootPreKey []byte
-	preKeys    map[string][]byte
+
+	preKeysMutex sync.RWMutex
+	preKeys      map[string][]byte
+
+	pools       *tcertPools
+	refreshStop chan struct{}
 }
 
 // TCertSet contains relevant information of a set of tcerts
@@ -65,7 +84,7 @@ type TCertSet struct {
 	Key          []byte
 }
 
-func initializeTCATables(db *sql.DB) error {
+func initializeTCATables(db *Database) error {
 	var err error
 
 	err = initializeCommonTables(db)
@@ -76,15 +95,34 @@ func initializeTCATables(db *sql.DB) error {
 	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS TCertificateSets (row INTEGER PRIMARY KEY, enrollmentID VARCHAR(64), timestamp INTEGER, nonce BLOB, kdfkey BLOB)"); err != nil {
 		return err
 	}
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS TCertificates (row INTEGER PRIMARY KEY, enrollmentID VARCHAR(64), serial VARCHAR(64) UNIQUE, notAfter INTEGER, refreshBy INTEGER DEFAULT 0)"); err != nil {
+		return err
+	}
+
+	// databases created before the TCerts were flagged for refresh lack the column
+	if _, err = db.Exec("SELECT refreshBy FROM TCertificates WHERE 1=0"); err != nil {
+		if _, err = db.Exec("ALTER TABLE TCertificates ADD COLUMN refreshBy INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+	}
 
 	return err
 }
 
 // NewTCA sets up a new TCA.
 func NewTCA(eca *ECA) *TCA {
-	tca := &TCA{NewCA("tca", initializeTCATables), eca, nil, nil, nil}
+	tca := &TCA{CA: NewCA("tca", initializeTCATables), eca: eca, pools: newTCertPools()}
+	if eca != nil {
+		// the ECA revokes the TCerts of the identities it revokes
+		eca.tca = tca
+	}
 
-	err := tca.readHmacKey()
+	err := checkTCertConfig()
+	if err != nil {
+		Panic.Panicln(err)
+	}
+
+	err = tca.readHmacKey()
 	if err != nil {
 		Panic.Panicln(err)
 	}
@@ -143,6 +181,14 @@ func (tca *TCA) readRootPreKey() error {
 	return err
 }
 
+// kdfKeyOf returns the key from which the keys of the TCerts of the enrollment key pub are derived.
+func (tca *TCA) kdfKeyOf(pub *ecdsa.PublicKey) []byte {
+	mac := hmac.New(primitives.GetDefaultHash(), tca.hmacKey)
+	raw, _ := x509.MarshalPKIXPublicKey(pub)
+	mac.Write(raw)
+	return mac.Sum(nil)
+}
+
 func (tca *TCA) calculatePreKey(variant []byte, preKey []byte) ([]byte, error) {
 	mac := hmac.New(primitives.GetDefaultHash(), preKey)
 	_, err := mac.Write(variant)
@@ -177,7 +223,7 @@ func (tca *TCA) initializePreKeyTree() error {
 	if err != nil {
 		return err
 	}
-	tca.preKeys = make(map[string][]byte)
+	preKeys := make(map[string][]byte)
 	for _, group := range groups {
 		if group.preKey == nil {
 			err = tca.initializePreKeyGroup(group)
@@ -186,18 +232,35 @@ func (tca *TCA) initializePreKeyTree() error {
 			}
 		}
 		Trace.Println("Initializing PK group ", group.name)
-		tca.preKeys[group.name] = group.preKey
+		preKeys[group.name] = group.preKey
 	}
+	tca.preKeysMutex.Lock()
+	tca.preKeys = preKeys
+	tca.preKeysMutex.Unlock()
 
 	return nil
 }
 
+func (tca *TCA) readPreKey(affiliation string) []byte {
+	tca.preKeysMutex.RLock()
+	defer tca.preKeysMutex.RUnlock()
+
+	return tca.preKeys[affiliation]
+}
+
 func (tca *TCA) getPreKFrom(enrollmentCertificate *x509.Certificate) ([]byte, error) {
 	_, _, affiliation, err := tca.eca.parseEnrollID(enrollmentCertificate.Subject.CommonName)
 	if err != nil {
 		return nil, err
 	}
-	preK := tca.preKeys[affiliation]
+	preK := tca.readPreKey(affiliation)
+	if preK == nil {
+		// the affiliation group may have been created by another replica of the ECA
+		if err = tca.initializePreKeyTree(); err != nil {
+			return nil, err
+		}
+		preK = tca.readPreKey(affiliation)
+	}
 	if preK == nil {
 		return nil, errors.New("Could not be found a pre-k to the affiliation group " + affiliation + ".")
 	}
@@ -208,10 +271,20 @@ func (tca *TCA) getPreKFrom(enrollmentCertificate *x509.Certificate) ([]byte, er
 func (tca *TCA) Start(srv *grpc.Server) {
 	tca.startTCAP(srv)
 	tca.startTCAA(srv)
+	tca.startCRLPublisher()
+	tca.startTCertPools()
+	tca.startTCertRefresher()
 
 	Info.Println("TCA started.")
 }
 
+// Close closes down the TCA.
+func (tca *TCA) Close() {
+	tca.stopTCertPools()
+	tca.stopTCertRefresher()
+	tca.CA.Close()
+}
+
 func (tca *TCA) startTCAP(srv *grpc.Server) {
 	pb.RegisterTCAPServer(srv, &TCAP{tca})
 }
@@ -249,13 +322,65 @@ func (tca *TCA) getCertificateSets(enrollmentID string) ([]*TCertSet, error) {
 	return sets, nil
 }
 
-func (tca *TCA) persistCertificateSet(enrollmentID string, timestamp int64, nonce []byte, kdfKey []byte) error {
-	var err error
+// persistCertificateSet records a batch of TCerts issued to enrollmentID, along
+// with their serial numbers, valid until notAfter, so that they can be revoked
+// with the identity. The batch is recorded at once, as replicas of the TCA
+// sharing the database may record batches of the same identity concurrently.
+func (tca *TCA) persistCertificateSet(enrollmentID string, timestamp int64, nonce []byte, kdfKey []byte, serials []string, notAfter time.Time) error {
+	tx, err := tca.db.Begin()
+	if err != nil {
+		Error.Println(err)
+		return err
+	}
 
-	if _, err = tca.db.Exec("INSERT INTO TCertificateSets (enrollmentID, timestamp, nonce, kdfkey) VALUES (?, ?, ?, ?)", enrollmentID, timestamp, nonce, kdfKey); err != nil {
+	if _, err = tx.Exec("INSERT INTO TCertificateSets (enrollmentID, timestamp, nonce, kdfkey) VALUES (?, ?, ?, ?)", enrollmentID, timestamp, nonce, kdfKey); err != nil {
 		Error.Println(err)
+		tx.Rollback()
+		return err
 	}
-	return err
+	for _, serial := range serials {
+		if _, err = tx.Exec("INSERT INTO TCertificates (enrollmentID, serial, notAfter) VALUES (?, ?, ?)", enrollmentID, serial, notAfter.Unix()); err != nil {
+			Error.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// revokeCertificates revokes the unexpired TCerts issued to enrollmentID. The
+// records of the expired ones, and the TCerts pre-generated for it, are discarded.
+func (tca *TCA) revokeCertificates(enrollmentID string, reason pb.RevocationReason, invalidAt time.Time) error {
+	tca.pools.discard(enrollmentID)
+
+	now := time.Now().Unix()
+	if _, err := tca.db.Exec("DELETE FROM TCertificates WHERE enrollmentID=? AND notAfter<=?", enrollmentID, now); err != nil {
+		Error.Println(err)
+		return err
+	}
+
+	rows, err := tca.db.Query("SELECT serial FROM TCertificates WHERE enrollmentID=?", enrollmentID)
+	if err != nil {
+		Error.Println(err)
+		return err
+	}
+	defer rows.Close()
+
+	var serials []string
+	for rows.Next() {
+		var serial string
+		if err = rows.Scan(&serial); err != nil {
+			return err
+		}
+		serials = append(serials, serial)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	Trace.Printf("Revoking %d transaction certificates of %s.\n", len(serials), enrollmentID)
+
+	return tca.revokeSerials(serials, reason, invalidAt)
 }
 
 func (tca *TCA) retrieveCertificateSets(enrollmentID string) (*sql.Rows, error) {
@@ -0,0 +1,294 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+const (
+	// Period of the checks for idle time to fill the pools, unless tca.pool.interval is set
+	defaultTCertPoolInterval = 10 * time.Second
+
+	// Longest time pre-generated TCerts are kept, unless tca.pool.max-age is set
+	defaultTCertPoolMaxAge = 24 * time.Hour
+)
+
+// tcertBatch is a batch of TCerts of an identity, sharing the nonce of their TCertIndex.
+type tcertBatch struct {
+	nonce    []byte
+	certs    []*pb.TCert
+	serials  []string
+	notAfter time.Time
+	created  time.Time
+}
+
+// tcertPool holds the TCerts pre-generated for an identity, whose keys are derived with kdfKey.
+type tcertPool struct {
+	kdfKey  []byte
+	batches []*tcertBatch
+}
+
+// tcertPools holds the TCerts the TCA pre-generates, while idle, for the identities
+// listed in tca.pool.identities, up to tca.pool.size each. Pre-generated TCerts are
+// issued and recorded only once served.
+type tcertPools struct {
+	mutex      sync.Mutex
+	pools      map[string]*tcertPool
+	identities []string
+	size       int
+	maxAge     time.Duration
+	interval   time.Duration
+
+	// number of TCert requests being served
+	busy int32
+	stop chan struct{}
+}
+
+func newTCertPools() *tcertPools {
+	p := &tcertPools{
+		pools:      make(map[string]*tcertPool),
+		identities: viper.GetStringSlice("tca.pool.identities"),
+		size:       viper.GetInt("tca.pool.size"),
+		maxAge:     defaultTCertPoolMaxAge,
+		interval:   defaultTCertPoolInterval,
+	}
+	if viper.IsSet("tca.pool.max-age") {
+		p.maxAge = viper.GetDuration("tca.pool.max-age")
+	}
+	if viper.IsSet("tca.pool.interval") {
+		p.interval = viper.GetDuration("tca.pool.interval")
+	}
+	return p
+}
+
+func (p *tcertPools) enabled() bool {
+	return len(p.identities) > 0 && p.size > 0
+}
+
+func (p *tcertPools) begin() {
+	atomic.AddInt32(&p.busy, 1)
+}
+
+func (p *tcertPools) end() {
+	atomic.AddInt32(&p.busy, -1)
+}
+
+func (p *tcertPools) idle() bool {
+	return atomic.LoadInt32(&p.busy) == 0
+}
+
+// pool returns the pool of id for kdfKey, discarding the TCerts expired from it
+// or derived from another key. The mutex must be held.
+func (p *tcertPools) pool(id string, kdfKey []byte) *tcertPool {
+	pool, ok := p.pools[id]
+	if !ok || !bytes.Equal(pool.kdfKey, kdfKey) {
+		pool = &tcertPool{kdfKey: kdfKey}
+		p.pools[id] = pool
+	}
+
+	oldest := time.Now().Add(-p.maxAge)
+	batches := pool.batches[:0]
+	for _, batch := range pool.batches {
+		if batch.created.After(oldest) {
+			batches = append(batches, batch)
+		}
+	}
+	pool.batches = batches
+
+	return pool
+}
+
+// available returns the number of TCerts pre-generated for id with kdfKey.
+func (p *tcertPools) available(id string, kdfKey []byte) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	n := 0
+	for _, batch := range p.pool(id, kdfKey).batches {
+		n += len(batch.certs)
+	}
+	return n
+}
+
+// sizes returns the number of TCerts pre-generated for each identity.
+func (p *tcertPools) sizes() map[string]float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sizes := make(map[string]float64)
+	for id, pool := range p.pools {
+		for _, batch := range pool.batches {
+			sizes[id] += float64(len(batch.certs))
+		}
+	}
+	return sizes
+}
+
+// put adds batch, generated for id with kdfKey, to the pool of id.
+func (p *tcertPools) put(id string, kdfKey []byte, batch *tcertBatch) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pool := p.pool(id, kdfKey)
+	pool.batches = append(pool.batches, batch)
+}
+
+// take removes up to num TCerts from the pool of id, provided they were generated
+// with kdfKey, and returns them.
+func (p *tcertPools) take(id string, kdfKey []byte, num int) []*tcertBatch {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.pools[id]; !ok {
+		return nil
+	}
+	pool := p.pool(id, kdfKey)
+
+	var taken []*tcertBatch
+	for num > 0 && len(pool.batches) > 0 {
+		batch := pool.batches[0]
+		if len(batch.certs) <= num {
+			taken = append(taken, batch)
+			pool.batches = pool.batches[1:]
+			num -= len(batch.certs)
+			continue
+		}
+
+		taken = append(taken, &tcertBatch{
+			nonce:    batch.nonce,
+			certs:    batch.certs[:num],
+			serials:  batch.serials[:num],
+			notAfter: batch.notAfter,
+			created:  batch.created,
+		})
+		batch.certs = batch.certs[num:]
+		batch.serials = batch.serials[num:]
+		num = 0
+	}
+	return taken
+}
+
+// discard discards the TCerts pre-generated for id.
+func (p *tcertPools) discard(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.pools, id)
+}
+
+// discardAll discards all the pre-generated TCerts.
+func (p *tcertPools) discardAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.pools = make(map[string]*tcertPool)
+}
+
+// startTCertPools fills the pools of TCerts every tca.pool.interval the TCA is idle.
+func (tca *TCA) startTCertPools() {
+	p := tca.pools
+	if !p.enabled() {
+		return
+	}
+
+	p.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if p.idle() {
+					tca.fillTCertPools()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(p.stop)
+}
+
+func (tca *TCA) stopTCertPools() {
+	if tca.pools.stop != nil {
+		close(tca.pools.stop)
+		tca.pools.stop = nil
+	}
+}
+
+// fillTCertPools generates the TCerts missing from the pools, without attributes and valid
+// for the period of the TCA, or of the issuance policy of their identity. It stops as soon
+// as a request is to be served.
+func (tca *TCA) fillTCertPools() {
+	tcap := &TCAP{tca}
+
+	for _, id := range tca.pools.identities {
+		if tca.eca.isRevoked(id) {
+			tca.pools.discard(id)
+			continue
+		}
+		raw, err := tca.eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
+		if err != nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			continue
+		}
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			continue
+		}
+		kdfKey := tca.kdfKeyOf(pub)
+		limits, err := tca.eca.issuanceLimits(id)
+		if err != nil {
+			continue
+		}
+
+		for missing := tca.pools.size - tca.pools.available(id, kdfKey); missing > 0; {
+			if !tca.pools.idle() {
+				return
+			}
+
+			num := missing
+			if num > limits.tcertMaxBatchSize {
+				num = limits.tcertMaxBatchSize
+			}
+			if num <= 0 {
+				break
+			}
+			batch, err := tcap.generateCertificateSet(id, cert, pub, kdfKey, nil, num, limits.tcertValidity)
+			if err != nil {
+				Error.Printf("Failed pre-generating TCerts of %s: %s\n", id, err)
+				break
+			}
+			tca.pools.put(id, kdfKey, batch)
+			missing -= num
+
+			Trace.Printf("Pre-generated %d TCerts of %s.\n", num, id)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"time"
+
+	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
+)
+
+// When the attributes of an identity are updated, the TCerts issued to it until then,
+// which carry its former attributes, are flagged for refresh: they are revoked, as
+// superseded, once tca.tcert.refresh-window is over. Their owner has that long to
+// obtain new TCerts, which carry the updated attributes.
+
+const (
+	// Time left to replace the TCerts flagged for refresh, unless tca.tcert.refresh-window is set
+	defaultTCertRefreshWindow = time.Hour
+
+	// Interval between the revocations of the TCerts whose refresh window is over
+	tcertRefreshInterval = time.Minute
+)
+
+func getTCertRefreshWindow() time.Duration {
+	if window := viper.GetDuration("tca.tcert.refresh-window"); window > 0 {
+		return window
+	}
+	return defaultTCertRefreshWindow
+}
+
+// flagCertificatesForRefresh flags the unexpired TCerts issued to enrollmentID for refresh,
+// unless already flagged earlier, and discards the TCerts pre-generated for it. It returns
+// the number of TCerts flagged.
+func (tca *TCA) flagCertificatesForRefresh(enrollmentID string) (int64, error) {
+	tca.pools.discard(enrollmentID)
+
+	now := time.Now()
+	refreshBy := now.Add(getTCertRefreshWindow()).Unix()
+	res, err := tca.db.Exec("UPDATE TCertificates SET refreshBy=? WHERE enrollmentID=? AND notAfter>? AND (refreshBy=0 OR refreshBy>?)", refreshBy, enrollmentID, now.Unix(), refreshBy)
+	if err != nil {
+		Error.Println(err)
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	Trace.Printf("Flagged %d transaction certificates of %s for refresh.\n", n, enrollmentID)
+
+	return n, nil
+}
+
+// revokeRefreshedCertificates revokes the TCerts whose refresh window is over at now.
+func (tca *TCA) revokeRefreshedCertificates(now time.Time) error {
+	rows, err := tca.db.Query("SELECT serial FROM TCertificates WHERE refreshBy>0 AND refreshBy<=? AND notAfter>?", now.Unix(), now.Unix())
+	if err != nil {
+		Error.Println(err)
+		return err
+	}
+	defer rows.Close()
+
+	var serials []string
+	for rows.Next() {
+		var serial string
+		if err = rows.Scan(&serial); err != nil {
+			return err
+		}
+		serials = append(serials, serial)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(serials) > 0 {
+		Trace.Printf("Revoking %d transaction certificates not refreshed.\n", len(serials))
+
+		if err = tca.revokeSerials(serials, pb.RevocationReason_SUPERSEDED, now); err != nil {
+			return err
+		}
+	}
+	_, err = tca.db.Exec("UPDATE TCertificates SET refreshBy=0 WHERE refreshBy>0 AND refreshBy<=?", now.Unix())
+	return err
+}
+
+// startTCertRefresher revokes the TCerts whose refresh window is over every tcertRefreshInterval.
+func (tca *TCA) startTCertRefresher() {
+	tca.refreshStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(tcertRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := tca.revokeRefreshedCertificates(now); err != nil {
+					Error.Println("Failed revoking the TCerts not refreshed: ", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(tca.refreshStop)
+}
+
+func (tca *TCA) stopTCertRefresher() {
+	if tca.refreshStop != nil {
+		close(tca.refreshStop)
+		tca.refreshStop = nil
+	}
+}
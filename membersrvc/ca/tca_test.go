@@ -28,11 +28,13 @@ import (
 	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
 )
 
 func TestNewTCA(t *testing.T) {
@@ -120,6 +122,215 @@ func TestCreateCertificateSet(t *testing.T) {
 	}
 }
 
+func TestCreateCertificateSetLimits(t *testing.T) {
+	tca, err := initTCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enrollmentID := "test_user0"
+	enrollmentPassword := "MS9qrN8hFjlE"
+
+	ecertRaw, priv, err := loadECertAndEnrollmentPrivateKey(enrollmentID, enrollmentPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcap := &TCAP{tca}
+
+	// A shorter validity period than the one of the TCA is honored
+	certificateSetRequest, err := buildCertificateSetRequest(enrollmentID, priv, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificateSetRequest.Validity = 3600
+	if err := signCertificateSetRequest(certificateSetRequest, priv); err != nil {
+		t.Fatal(err)
+	}
+	response, err := tcap.createCertificateSet(context.Background(), ecertRaw, certificateSetRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcert, err := x509.ParseCertificate(response.GetCerts().Certs[0].Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity := tcert.NotAfter.Sub(tcert.NotBefore); validity != time.Hour {
+		t.Fatalf("Invalid TCert validity period. Expected: %v, Actual: %v", time.Hour, validity)
+	}
+
+	// A batch larger than the one of the TCA is rejected
+	viper.Set("tca.tcert.max-batch-size", 2)
+	defer viper.Set("tca.tcert.max-batch-size", defaultTCertMaxBatchSize)
+
+	certificateSetRequest, err = buildCertificateSetRequest(enrollmentID, priv, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tcap.createCertificateSet(context.Background(), ecertRaw, certificateSetRequest); err == nil {
+		t.Fatal("A batch of TCerts larger than the maximum should be rejected")
+	}
+}
+
+// certificateSetStream collects the chunks of TCerts streamed by the TCA.
+type certificateSetStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	chunks []*protos.TCertCreateSetResp
+}
+
+func (stream *certificateSetStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *certificateSetStream) Send(resp *protos.TCertCreateSetResp) error {
+	stream.chunks = append(stream.chunks, resp)
+	return nil
+}
+
+func TestCreateCertificateSetStream(t *testing.T) {
+	enrollmentID := "test_user0"
+	enrollmentPassword := "MS9qrN8hFjlE"
+
+	ecertRaw, priv, err := loadECertAndEnrollmentPrivateKey(enrollmentID, enrollmentPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcap := &TCAP{tca}
+
+	viper.Set("tca.tcert.max-batch-size", 2)
+	defer viper.Set("tca.tcert.max-batch-size", defaultTCertMaxBatchSize)
+	viper.Set("tca.tcert.max-stream-size", 5)
+	defer viper.Set("tca.tcert.max-stream-size", defaultTCertMaxStreamSize)
+
+	certSets, err := tca.getCertificateSets(enrollmentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certSetsCountBefore := len(certSets)
+
+	// The TCerts are streamed in chunks of at most the maximum batch size
+	certificateSetRequest, err := buildCertificateSetRequest(enrollmentID, priv, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &certificateSetStream{ctx: context.Background()}
+	if err = tcap.createCertificateSetStream(stream, ecertRaw, certificateSetRequest); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.chunks) != 3 {
+		t.Fatalf("Expected 3 chunks of TCerts, got %d", len(stream.chunks))
+	}
+	ncerts := 0
+	for _, chunk := range stream.chunks {
+		if len(chunk.Certs.Certs) > 2 {
+			t.Fatalf("A chunk of %d TCerts exceeds the maximum batch size", len(chunk.Certs.Certs))
+		}
+		ncerts += len(chunk.Certs.Certs)
+	}
+	if ncerts != 5 {
+		t.Fatalf("Expected 5 TCerts, got %d", ncerts)
+	}
+
+	certSets, err = tca.getCertificateSets(enrollmentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certSets) != certSetsCountBefore+3 {
+		t.Fatal("Each chunk of TCerts should be recorded as a TCertSet")
+	}
+
+	// A stream larger than the one of the TCA is rejected
+	certificateSetRequest, err = buildCertificateSetRequest(enrollmentID, priv, 6, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tcap.createCertificateSetStream(&certificateSetStream{ctx: context.Background()}, ecertRaw, certificateSetRequest); err == nil {
+		t.Fatal("A stream of TCerts larger than the maximum should be rejected")
+	}
+
+	// No TCerts are issued once the client is gone
+	certificateSetRequest, err = buildCertificateSetRequest(enrollmentID, priv, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream = &certificateSetStream{ctx: ctx}
+	if err = tcap.createCertificateSetStream(stream, ecertRaw, certificateSetRequest); err == nil || len(stream.chunks) != 0 {
+		t.Fatal("No TCerts should be streamed once the stream is done")
+	}
+}
+
+func TestPersistCertificateSetAtomic(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour)
+
+	if err := tca.persistCertificateSet("replicaUser", 1, []byte("nonce"), []byte("key"), []string{"1001", "1002"}, notAfter); err != nil {
+		t.Fatal(err)
+	}
+
+	// a serial number already recorded rejects the whole batch
+	if err := tca.persistCertificateSet("replicaUser", 2, []byte("nonce"), []byte("key"), []string{"1003", "1002"}, notAfter); err == nil {
+		t.Fatal("A batch reusing a serial number should be rejected")
+	}
+
+	sets, err := tca.getCertificateSets("replicaUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("Expected a single recorded batch, got %d", len(sets))
+	}
+	var count int
+	if err = tca.db.QueryRow("SELECT count(row) FROM TCertificates WHERE serial=?", "1003").Scan(&count); err != nil || count != 0 {
+		t.Fatal("The serial numbers of a rejected batch should not be recorded")
+	}
+}
+
+func TestFlagCertificatesForRefresh(t *testing.T) {
+	now := time.Now()
+	if err := tca.persistCertificateSet("refreshUser", 1, []byte("nonce"), []byte("key"), []string{"2001", "2002"}, now.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tca.persistCertificateSet("refreshUser", 2, []byte("nonce"), []byte("key"), []string{"2003"}, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := tca.flagCertificatesForRefresh("refreshUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Flagged %d TCerts, expected the 2 unexpired ones", n)
+	}
+
+	revoked := func(serial string) bool {
+		var count int
+		if err := tca.db.QueryRow("SELECT count(row) FROM Revocations WHERE serial=?", serial).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count > 0
+	}
+
+	// the TCerts are left valid during the refresh window
+	if err = tca.revokeRefreshedCertificates(now); err != nil {
+		t.Fatal(err)
+	}
+	if revoked("2001") {
+		t.Fatal("The TCerts should not be revoked before the end of the refresh window")
+	}
+
+	if err = tca.revokeRefreshedCertificates(now.Add(getTCertRefreshWindow() + time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if !revoked("2001") || !revoked("2002") || revoked("2003") {
+		t.Fatal("The unexpired TCerts should be revoked at the end of the refresh window")
+	}
+	var count int
+	if err = tca.db.QueryRow("SELECT count(row) FROM TCertificates WHERE enrollmentID=? AND refreshBy>0", "refreshUser").Scan(&count); err != nil || count != 0 {
+		t.Fatal("The TCerts revoked should no longer be flagged")
+	}
+}
+
 func loadECertAndEnrollmentPrivateKey(enrollmentID string, password string) ([]byte, *ecdsa.PrivateKey, error) {
 	cooked, err := ioutil.ReadFile("./test_resources/key_" + enrollmentID + ".dump")
 	if err != nil {
@@ -193,19 +404,82 @@ func buildCertificateSetRequest(enrollID string, enrollmentPrivKey *ecdsa.Privat
 		Sig:        nil,
 	}
 
+	if err := signCertificateSetRequest(req, enrollmentPrivKey); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func signCertificateSetRequest(req *protos.TCertCreateSetReq, enrollmentPrivKey *ecdsa.PrivateKey) error {
+	req.Sig = nil
 	rawReq, err := proto.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("Failed marshaling request [%v].", err)
+		return fmt.Errorf("Failed marshaling request [%v].", err)
 	}
 
 	r, s, err := primitives.ECDSASignDirect(enrollmentPrivKey, rawReq)
 	if err != nil {
-		return nil, fmt.Errorf("Failed creating signature for [%v]: [%v].", rawReq, err)
+		return fmt.Errorf("Failed creating signature for [%v]: [%v].", rawReq, err)
 	}
 
 	R, _ := r.MarshalText()
 	S, _ := s.MarshalText()
 
 	req.Sig = &protos.Signature{Type: protos.CryptoType_ECDSA, R: R, S: S}
-	return req, nil
+	return nil
+}
+
+func TestTCertPool(t *testing.T) {
+	tcap := &TCAP{tca}
+	defer tca.pools.discardAll()
+	tca.pools.identities = []string{testUser.enrollID}
+	tca.pools.size = 5
+	defer func() {
+		tca.pools.identities = nil
+		tca.pools.size = 0
+	}()
+
+	tca.fillTCertPools()
+
+	raw, err := tca.eca.readCertificateByKeyUsage(testUser.enrollID, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdfKey := tca.kdfKeyOf(cert.PublicKey.(*ecdsa.PublicKey))
+	if n := tca.pools.available(testUser.enrollID, kdfKey); n != 5 {
+		t.Fatalf("Expected 5 pre-generated TCerts, got %d", n)
+	}
+
+	// the TCerts are served from the pool first, then generated
+	for _, num := range []int{3, 4} {
+		req, err := buildCertificateSetRequest(testUser.enrollID, testUser.enrollPrivKey, num, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tcap.CreateCertificateSet(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Failed creating TCerts: [%s]", err)
+		}
+		if len(resp.Certs.Certs) != num {
+			t.Fatalf("Expected %d TCerts, got %d", num, len(resp.Certs.Certs))
+		}
+		for _, tcert := range resp.Certs.Certs {
+			if _, err = x509.ParseCertificate(tcert.Cert); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := tca.pools.available(testUser.enrollID, kdfKey); n != 0 {
+		t.Fatalf("Expected the pool to be empty, got %d TCerts", n)
+	}
+
+	// TCerts derived from another enrollment key are not served
+	tca.fillTCertPools()
+	if batches := tca.pools.take(testUser.enrollID, []byte("another key"), 1); len(batches) != 0 {
+		t.Fatal("TCerts derived from another key should not be served")
+	}
 }
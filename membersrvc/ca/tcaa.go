@@ -18,7 +18,9 @@ package ca
 
 import (
 	"errors"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 	"golang.org/x/net/context"
 )
@@ -28,11 +30,25 @@ type TCAA struct {
 	tca *TCA
 }
 
-// RevokeCertificate revokes a certificate from the TCA.  Not yet implemented.
-func (tcaa *TCAA) RevokeCertificate(context.Context, *pb.TCertRevokeReq) (*pb.CAStatus, error) {
+// RevokeCertificate revokes any transaction certificate from the TCA. The requester must be an auditor.
+func (tcaa *TCAA) RevokeCertificate(ctx context.Context, in *pb.TCertRevokeReq) (*pb.CAStatus, error) {
 	Trace.Println("grpc TCAA:RevokeCertificate")
 
-	return nil, errors.New("not yet implemented")
+	if in.Id == nil || in.Cert == nil {
+		return nil, errors.New("Identity and certificate are required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := tcaa.tca.eca.checkAuditorSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if err := tcaa.tca.revokeCertificate(in.Id.Id, in.Cert.Cert, true, pb.RevocationReason_UNSPECIFIED, time.Now()); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
 }
 
 // RevokeCertificateSet revokes a certificate set from the TCA.  Not yet implemented.
@@ -42,9 +58,31 @@ func (tcaa *TCAA) RevokeCertificateSet(context.Context, *pb.TCertRevokeSetReq) (
 	return nil, errors.New("not yet implemented")
 }
 
-// PublishCRL requests the creation of a certificate revocation list from the TCA.  Not yet implemented.
-func (tcaa *TCAA) PublishCRL(context.Context, *pb.TCertCRLReq) (*pb.CAStatus, error) {
+// ReadIssuedCertificates returns the certificates issued by the TCA matching the filter of the
+// request. The requester must be an auditor.
+func (tcaa *TCAA) ReadIssuedCertificates(ctx context.Context, in *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+	Trace.Println("grpc TCAA:ReadIssuedCertificates")
+
+	return tcaa.tca.readIssuedCertificateSet(tcaa.tca.eca, in)
+}
+
+// PublishCRL requests the creation of a certificate revocation list from the TCA. The requester must be an auditor.
+func (tcaa *TCAA) PublishCRL(ctx context.Context, in *pb.TCertCRLReq) (*pb.CAStatus, error) {
 	Trace.Println("grpc TCAA:CreateCRL")
 
-	return nil, errors.New("not yet implemented")
+	if in.Id == nil {
+		return nil, errors.New("Identity is required.")
+	}
+
+	sig := in.Sig
+	in.Sig = nil
+	raw, _ := proto.Marshal(in)
+	if err := tcaa.tca.eca.checkAuditorSignature(in.Id.Id, raw, sig); err != nil {
+		return nil, err
+	}
+
+	if _, err := tcaa.tca.generateCRL(); err != nil {
+		return nil, err
+	}
+	return &pb.CAStatus{Status: pb.CAStatus_OK}, nil
 }
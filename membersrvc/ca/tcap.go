@@ -52,6 +52,14 @@ func (tcap *TCAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.Cert
 	return &pb.Cert{Cert: tcap.tca.raw}, nil
 }
 
+// ReadCACertificateChain reads the certificate chain of the TCA, from its certificate up to the root.
+//
+func (tcap *TCAP) ReadCACertificateChain(ctx context.Context, in *pb.Empty) (*pb.CertChain, error) {
+	Trace.Println("grpc TCAP:ReadCACertificateChain")
+
+	return &pb.CertChain{Certs: tcap.tca.chain}, nil
+}
+
 func (tcap *TCAP) selectValidAttributes(certRaw []byte) ([]*pb.ACAAttribute, error) {
 	cert, err := x509.ParseCertificate(certRaw)
 	if err != nil {
@@ -121,7 +129,7 @@ func (tcap *TCAP) requestAttributes(id string, ecert []byte, attrs []*pb.TCertAt
 
 	var r, s *big.Int
 
-	r, s, err = primitives.ECDSASignDirect(tcap.tca.priv, rawReq)
+	r, s, err = tcap.tca.signDirect(rawReq)
 
 	if err != nil {
 		return nil, err
@@ -145,10 +153,15 @@ func (tcap *TCAP) requestAttributes(id string, ecert []byte, attrs []*pb.TCertAt
 }
 
 // CreateCertificateSet requests the creation of a new transaction certificate set by the TCA.
-func (tcap *TCAP) CreateCertificateSet(ctx context.Context, in *pb.TCertCreateSetReq) (*pb.TCertCreateSetResp, error) {
+func (tcap *TCAP) CreateCertificateSet(ctx context.Context, in *pb.TCertCreateSetReq) (resp *pb.TCertCreateSetResp, err error) {
 	Trace.Println("grpc TCAP:CreateCertificateSet")
+	defer observeRequest("tca", "tcerts", time.Now(), &err)
 
 	id := in.Id.Id
+	if tcap.tca.eca.isRevoked(id) {
+		return nil, errors.New("Identity has been revoked.")
+	}
+
 	raw, err := tcap.tca.eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
 	if err != nil {
 		return nil, err
@@ -158,11 +171,105 @@ func (tcap *TCAP) CreateCertificateSet(ctx context.Context, in *pb.TCertCreateSe
 }
 
 func (tcap *TCAP) createCertificateSet(ctx context.Context, raw []byte, in *pb.TCertCreateSetReq) (*pb.TCertCreateSetResp, error) {
+	req, err := tcap.verifyCertificateSetRequest(raw, in)
+	if err != nil {
+		return nil, err
+	}
+
+	num := req.num()
+	if max := req.limits.tcertMaxBatchSize; num > max {
+		return nil, fmt.Errorf("Invalid number of TCerts requested [%d]. It must be at most %d.", num, max)
+	}
+
+	set, err := tcap.issueCertificateSet(req, num)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TCertCreateSetResp{Certs: &pb.CertSet{Ts: in.Ts, Id: in.Id, Key: req.kdfKey, Certs: set}}, nil
+}
+
+// CreateCertificateSetStream requests the creation of a large set of transaction certificates by
+// the TCA, which are streamed back in chunks of at most the maximum batch size, each chunk being
+// issued once the previous one has been sent.
+func (tcap *TCAP) CreateCertificateSetStream(in *pb.TCertCreateSetReq, stream pb.TCAP_CreateCertificateSetStreamServer) (err error) {
+	Trace.Println("grpc TCAP:CreateCertificateSetStream")
+	defer observeRequest("tca", "tcert_stream", time.Now(), &err)
+
+	id := in.Id.Id
+	if tcap.tca.eca.isRevoked(id) {
+		return errors.New("Identity has been revoked.")
+	}
+
+	raw, err := tcap.tca.eca.readCertificateByKeyUsage(id, x509.KeyUsageDigitalSignature)
+	if err != nil {
+		return err
+	}
+
+	return tcap.createCertificateSetStream(stream, raw, in)
+}
+
+func (tcap *TCAP) createCertificateSetStream(stream pb.TCAP_CreateCertificateSetStreamServer, raw []byte, in *pb.TCertCreateSetReq) error {
+	req, err := tcap.verifyCertificateSetRequest(raw, in)
+	if err != nil {
+		return err
+	}
+
+	num := req.num()
+	if max := getTCertMaxStreamSize(); num > max {
+		return fmt.Errorf("Invalid number of TCerts requested [%d]. It must be at most %d.", num, max)
+	}
+
+	for num > 0 {
+		// the client may have given up, or the connection been lost, while the previous chunk was sent
+		if err = stream.Context().Err(); err != nil {
+			return err
+		}
+
+		chunk := num
+		if max := req.limits.tcertMaxBatchSize; chunk > max {
+			chunk = max
+		}
+		set, err := tcap.issueCertificateSet(req, chunk)
+		if err != nil {
+			return err
+		}
+
+		// Send blocks until the client has room for the chunk, which paces the issuance
+		if err = stream.Send(&pb.TCertCreateSetResp{Certs: &pb.CertSet{Ts: in.Ts, Id: in.Id, Key: req.kdfKey, Certs: set}}); err != nil {
+			return err
+		}
+		num -= chunk
+	}
+
+	return nil
+}
+
+// tcertRequest is a request for TCerts whose signature has been verified.
+type tcertRequest struct {
+	in       *pb.TCertCreateSetReq
+	cert     *x509.Certificate
+	pub      *ecdsa.PublicKey
+	kdfKey   []byte
+	attrs    []*pb.ACAAttribute
+	limits   *issuanceLimits
+	validity time.Duration
+}
+
+// num returns the number of TCerts requested.
+func (req *tcertRequest) num() int {
+	if req.in.Num == 0 {
+		return 1
+	}
+	return int(req.in.Num)
+}
+
+// verifyCertificateSetRequest verifies the signature of in under the enrollment certificate raw,
+// and fetches the attributes requested from the ACA.
+func (tcap *TCAP) verifyCertificateSetRequest(raw []byte, in *pb.TCertCreateSetReq) (*tcertRequest, error) {
 	var attrs = []*pb.ACAAttribute{}
 	var err error
 	var id = in.Id.Id
-	var timestamp = in.Ts.Seconds
-	const TCERT_SUBJECT_COMMON_NAME_VALUE string = "Transaction Certificate"
 
 	if in.Attributes != nil && viper.GetBool("aca.enabled") {
 		attrs, err = tcap.requestAttributes(id, raw, in.Attributes)
@@ -176,7 +283,11 @@ func (tcap *TCAP) createCertificateSet(ctx context.Context, raw []byte, in *pb.T
 		return nil, err
 	}
 
-	pub := cert.PublicKey.(*ecdsa.PublicKey)
+	// TCert keys are derived from ECDSA enrollment keys only
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Transaction certificates require an ECDSA enrollment certificate.")
+	}
 
 	r, s := big.NewInt(0), big.NewInt(0)
 	r.UnmarshalText(in.Sig.R)
@@ -192,22 +303,84 @@ func (tcap *TCAP) createCertificateSet(ctx context.Context, raw []byte, in *pb.T
 		return nil, errors.New("signature does not verify")
 	}
 
-	// Generate nonce for TCertIndex
-	nonce := make([]byte, 16) // 8 bytes rand, 8 bytes timestamp
-	rand.Reader.Read(nonce[:8])
+	// the issuance policy of the identity may override the settings of the TCA
+	limits, err := tcap.tca.eca.issuanceLimits(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The TCerts are valid for the period requested, up to the one of the TCA
+	validity := limits.tcertValidity
+	if requested := time.Duration(in.Validity) * time.Second; requested > 0 && requested < validity {
+		validity = requested
+	}
 
-	mac := hmac.New(primitives.GetDefaultHash(), tcap.tca.hmacKey)
-	raw, _ = x509.MarshalPKIXPublicKey(pub)
-	mac.Write(raw)
-	kdfKey := mac.Sum(nil)
+	return &tcertRequest{
+		in:       in,
+		cert:     cert,
+		pub:      pub,
+		kdfKey:   tcap.tca.kdfKeyOf(pub),
+		attrs:    attrs,
+		limits:   limits,
+		validity: validity,
+	}, nil
+}
 
-	num := int(in.Num)
-	if num == 0 {
-		num = 1
+// issueCertificateSet issues num TCerts in response to req, and records them.
+func (tcap *TCAP) issueCertificateSet(req *tcertRequest, num int) ([]*pb.TCert, error) {
+	var id = req.in.Id.Id
+	var timestamp = req.in.Ts.Seconds
+
+	tcap.tca.pools.begin()
+	defer tcap.tca.pools.end()
+
+	// TCerts without attributes, valid for the period of the TCA, may have been pre-generated
+	var batches []*tcertBatch
+	if len(req.attrs) == 0 && req.validity == req.limits.tcertValidity {
+		batches = tcap.tca.pools.take(id, req.kdfKey, num)
+	}
+	for _, batch := range batches {
+		num -= len(batch.certs)
+	}
+	if num > 0 {
+		batch, err := tcap.generateCertificateSet(id, req.cert, req.pub, req.kdfKey, req.attrs, num, req.validity)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
 	}
 
-	// the batch of TCerts
 	var set []*pb.TCert
+	var certs [][]byte
+	for _, batch := range batches {
+		set = append(set, batch.certs...)
+		for _, tcert := range batch.certs {
+			certs = append(certs, tcert.Cert)
+		}
+	}
+
+	if err := tcap.tca.recordIssuedCertificates(id, pb.CertificateType_TCERT, tcap.tca.eca.readRegistrar(id), certs...); err != nil {
+		return nil, err
+	}
+	for _, batch := range batches {
+		if err := tcap.tca.persistCertificateSet(id, timestamp, batch.nonce, req.kdfKey, batch.serials, batch.notAfter); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// generateCertificateSet generates a batch of num TCerts of id, with the attributes attrs and valid
+// for validity, whose keys are derived from pub, the key of the enrollment certificate cert, and kdfKey.
+func (tcap *TCAP) generateCertificateSet(id string, cert *x509.Certificate, pub *ecdsa.PublicKey, kdfKey []byte, attrs []*pb.ACAAttribute, num int, validity time.Duration) (*tcertBatch, error) {
+	const TCERT_SUBJECT_COMMON_NAME_VALUE string = "Transaction Certificate"
+
+	// Generate nonce for TCertIndex
+	nonce := make([]byte, 16) // 8 bytes rand, 8 bytes timestamp
+	rand.Reader.Read(nonce[:8])
+
+	batch := &tcertBatch{nonce: nonce, created: time.Now()}
 
 	for i := 0; i < num; i++ {
 		tcertid := util.GenerateIntUUID()
@@ -247,18 +420,21 @@ func (tcap *TCAP) createCertificateSet(ctx context.Context, raw []byte, in *pb.T
 			return nil, err
 		}
 
-		spec := NewDefaultPeriodCertificateSpecWithCommonName(id, TCERT_SUBJECT_COMMON_NAME_VALUE, tcertid, &txPub, x509.KeyUsageDigitalSignature, extensions...)
-		if raw, err = tcap.tca.createCertificateFromSpec(spec, timestamp, kdfKey, false); err != nil {
+		notBefore := time.Now().Add(-1 * time.Minute)
+		notAfter := notBefore.Add(validity)
+		spec := NewCertificateSpec(id, TCERT_SUBJECT_COMMON_NAME_VALUE, tcertid, &txPub, x509.KeyUsageDigitalSignature, &notBefore, &notAfter, extensions...)
+		raw, err := tcap.tca.createCertificateFromSpec(spec, 0, kdfKey, false)
+		if err != nil {
 			Error.Println(err)
 			return nil, err
 		}
 
-		set = append(set, &pb.TCert{Cert: raw, Prek0: preK0})
+		batch.certs = append(batch.certs, &pb.TCert{Cert: raw, Prek0: preK0})
+		batch.serials = append(batch.serials, tcertid.String())
+		batch.notAfter = notAfter
 	}
 
-	tcap.tca.persistCertificateSet(id, timestamp, nonce, kdfKey)
-
-	return &pb.TCertCreateSetResp{Certs: &pb.CertSet{Ts: in.Ts, Id: in.Id, Key: kdfKey, Certs: set}}, nil
+	return batch, nil
 }
 
 // Generate encrypted extensions to be included into the TCert (TCertIndex, EnrollmentID and attributes).
@@ -353,8 +529,59 @@ func (tcap *TCAP) RevokeCertificateSet(context.Context, *pb.TCertRevokeSetReq) (
 	return nil, errors.New("not yet implemented")
 }
 
+// ReadCRL reads the current certificate revocation list of the TCA.
+func (tcap *TCAP) ReadCRL(ctx context.Context, in *pb.Empty) (*pb.CRL, error) {
+	Trace.Println("grpc TCAP:ReadCRL")
+
+	crl, err := tcap.tca.readCRL()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CRL{Crl: crl}, nil
+}
+
 func isEnabledAttributesEncryption() bool {
-	//TODO this code is commented because attributes encryption is not yet implemented.
-	//return viper.GetBool("tca.attribute-encryption.enabled")
-	return false
+	return viper.GetBool("tca.attribute-encryption.enabled")
+}
+
+// getTCertValidityPeriod returns the longest validity period of the TCerts
+func getTCertValidityPeriod() time.Duration {
+	if viper.IsSet("tca.tcert.validity-period") {
+		return viper.GetDuration("tca.tcert.validity-period")
+	}
+
+	return defaultTCertValidityPeriod
+}
+
+// getTCertMaxBatchSize returns the largest number of TCerts issued at once
+func getTCertMaxBatchSize() int {
+	if viper.IsSet("tca.tcert.max-batch-size") {
+		return viper.GetInt("tca.tcert.max-batch-size")
+	}
+
+	return defaultTCertMaxBatchSize
+}
+
+// getTCertMaxStreamSize returns the largest number of TCerts streamed in response to a single request
+func getTCertMaxStreamSize() int {
+	if viper.IsSet("tca.tcert.max-stream-size") {
+		return viper.GetInt("tca.tcert.max-stream-size")
+	}
+
+	return defaultTCertMaxStreamSize
+}
+
+// checkTCertConfig checks the TCert settings of the TCA
+func checkTCertConfig() error {
+	if period := getTCertValidityPeriod(); period <= 0 {
+		return fmt.Errorf("Invalid TCert validity period [%s]. It must be positive.", period)
+	}
+	if size := getTCertMaxBatchSize(); size <= 0 {
+		return fmt.Errorf("Invalid TCert max batch size [%d]. It must be positive.", size)
+	}
+	if size := getTCertMaxStreamSize(); size <= 0 {
+		return fmt.Errorf("Invalid TCert max stream size [%d]. It must be positive.", size)
+	}
+
+	return nil
 }
@@ -19,22 +19,39 @@ package ca
 import (
 	"crypto/ecdsa"
 	"crypto/x509"
-	"database/sql"
 	"errors"
+	"fmt"
 	"math/big"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
+	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
+// The requests for TLS certificates may ask for subject alternative names, e.g. the
+// names of the load balancers in front of a peer, within the ones tlsca.san allows:
+// dns-names lists the DNS names allowed, where a leading *. stands for any single
+// label, and ip-addresses the IP addresses or networks (CIDR) allowed. None is allowed
+// unless configured, and wildcard names never are.
+
 // TLSCA is the tls certificate authority.
 //
 type TLSCA struct {
 	*CA
 	eca *ECA
+	san *sanPolicy
+}
+
+// sanPolicy is the policy of the subject alternative names of the TLS certificates.
+type sanPolicy struct {
+	dnsNames []string
+	ipNets   []*net.IPNet
 }
 
 // TLSCAP serves the public GRPC interface of the TLSCA.
@@ -49,14 +66,20 @@ type TLSCAA struct {
 	tlsca *TLSCA
 }
 
-func initializeTLSCATables(db *sql.DB) error {
+func initializeTLSCATables(db *Database) error {
 	return initializeCommonTables(db)
 }
 
 // NewTLSCA sets up a new TLSCA.
 //
 func NewTLSCA(eca *ECA) *TLSCA {
-	tlsca := &TLSCA{NewCA("tlsca", initializeTLSCATables), eca}
+	tlsca := &TLSCA{NewCA("tlsca", initializeTLSCATables), eca, nil}
+
+	san, err := readSANPolicy()
+	if err != nil {
+		Panic.Panicln(err)
+	}
+	tlsca.san = san
 
 	return tlsca
 }
@@ -86,10 +109,19 @@ func (tlscap *TLSCAP) ReadCACertificate(ctx context.Context, in *pb.Empty) (*pb.
 	return &pb.Cert{Cert: tlscap.tlsca.raw}, nil
 }
 
+// ReadCACertificateChain reads the certificate chain of the TLSCA, from its certificate up to the root.
+//
+func (tlscap *TLSCAP) ReadCACertificateChain(ctx context.Context, in *pb.Empty) (*pb.CertChain, error) {
+	Trace.Println("grpc TLSCAP:ReadCACertificateChain")
+
+	return &pb.CertChain{Certs: tlscap.tlsca.chain}, nil
+}
+
 // CreateCertificate requests the creation of a new enrollment certificate by the TLSCA.
 //
-func (tlscap *TLSCAP) CreateCertificate(ctx context.Context, in *pb.TLSCertCreateReq) (*pb.TLSCertCreateResp, error) {
+func (tlscap *TLSCAP) CreateCertificate(ctx context.Context, in *pb.TLSCertCreateReq) (resp *pb.TLSCertCreateResp, err error) {
 	Trace.Println("grpc TLSCAP:CreateCertificate")
+	defer observeRequest("tlsca", "tlscert", time.Now(), &err)
 
 	id := in.Id.Id
 
@@ -116,12 +148,24 @@ func (tlscap *TLSCAP) CreateCertificate(ctx context.Context, in *pb.TLSCertCreat
 		return nil, errors.New("signature does not verify")
 	}
 
-	if raw, err = tlscap.tlsca.createCertificate(id, pub.(*ecdsa.PublicKey), x509.KeyUsageDigitalSignature, in.Ts.Seconds, nil); err != nil {
+	ips, err := tlscap.tlsca.san.check(in.DnsNames, in.IpAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := NewDefaultCertificateSpec(id, pub.(*ecdsa.PublicKey), x509.KeyUsageDigitalSignature)
+	spec.dnsNames = in.DnsNames
+	spec.ips = ips
+	spec.profile = tlscap.tlsca.certificateProfile(profileTLS, pb.Role(tlscap.tlsca.eca.readRole(id)))
+	if raw, err = tlscap.tlsca.createCertificateFromSpec(spec, in.Ts.Seconds, nil, true); err != nil {
 		Error.Println(err)
 		return nil, err
 	}
+	if err = tlscap.tlsca.recordIssuedCertificates(id, pb.CertificateType_TLS_CERT, tlscap.tlsca.eca.readRegistrar(id), raw); err != nil {
+		return nil, err
+	}
 
-	return &pb.TLSCertCreateResp{Cert: &pb.Cert{Cert: raw}, RootCert: &pb.Cert{Cert: tlscap.tlsca.raw}}, nil
+	return &pb.TLSCertCreateResp{Cert: &pb.Cert{Cert: raw}, RootCert: &pb.Cert{Cert: tlscap.tlsca.readRootCertificate()}}, nil
 }
 
 // ReadCertificate reads an enrollment certificate from the TLSCA.
@@ -152,3 +196,88 @@ func (tlscaa *TLSCAA) RevokeCertificate(context.Context, *pb.TLSCertRevokeReq) (
 
 	return nil, errors.New("not yet implemented")
 }
+
+// ReadIssuedCertificates returns the certificates issued by the TLSCA matching the filter
+// of the request. The requester must be an auditor.
+//
+func (tlscaa *TLSCAA) ReadIssuedCertificates(ctx context.Context, in *pb.IssuedCertificatesReq) (*pb.IssuedCertificateSet, error) {
+	Trace.Println("grpc TLSCAA:ReadIssuedCertificates")
+
+	return tlscaa.tlsca.readIssuedCertificateSet(tlscaa.tlsca.eca, in)
+}
+
+// readSANPolicy reads the policy of the subject alternative names of the configuration.
+//
+func readSANPolicy() (*sanPolicy, error) {
+	policy := new(sanPolicy)
+	for _, name := range viper.GetStringSlice("tlsca.san.dns-names") {
+		policy.dnsNames = append(policy.dnsNames, strings.ToLower(name))
+	}
+
+	for _, addr := range viper.GetStringSlice("tlsca.san.ip-addresses") {
+		if ip := net.ParseIP(addr); ip != nil {
+			// a single address
+			policy.ipNets = append(policy.ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid IP address %s of tlsca.san.ip-addresses.", addr)
+		}
+		policy.ipNets = append(policy.ipNets, ipNet)
+	}
+
+	return policy, nil
+}
+
+// check checks that the DNS names and IP addresses requested are allowed, and returns
+// the IP addresses.
+//
+func (policy *sanPolicy) check(dnsNames, ipAddresses []string) ([]net.IP, error) {
+	for _, name := range dnsNames {
+		if !policy.allowsDNSName(strings.ToLower(name)) {
+			return nil, fmt.Errorf("DNS name %s not allowed.", name)
+		}
+	}
+
+	var ips []net.IP
+	for _, addr := range ipAddresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP address %s.", addr)
+		}
+		if !policy.allowsIP(ip) {
+			return nil, fmt.Errorf("IP address %s not allowed.", addr)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+func (policy *sanPolicy) allowsDNSName(name string) bool {
+	if name == "" || strings.Contains(name, "*") {
+		return false
+	}
+	for _, pattern := range policy.dnsNames {
+		if name == pattern {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:]) {
+			label := strings.TrimSuffix(name, pattern[1:])
+			if label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (policy *sanPolicy) allowsIP(ip net.IP) bool {
+	for _, ipNet := range policy.ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
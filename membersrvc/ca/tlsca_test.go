@@ -182,3 +182,56 @@ func storeCert(alias string, der []byte, t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSANPolicy(t *testing.T) {
+	viper.Set("tlsca.san.dns-names", "peer0.example.com *.lb.example.com")
+	viper.Set("tlsca.san.ip-addresses", "10.0.0.0/8 192.168.1.1")
+	defer viper.Set("tlsca.san.dns-names", nil)
+	defer viper.Set("tlsca.san.ip-addresses", nil)
+
+	policy, err := readSANPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ips, err := policy.check([]string{"peer0.example.com", "Peer0.LB.example.com"}, []string{"10.1.2.3", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[1].Equal(net.ParseIP("192.168.1.1")) {
+		t.Fatalf("Unexpected IP addresses [%v]", ips)
+	}
+
+	for _, names := range [][]string{{"peer1.example.com"}, {"a.b.lb.example.com"}, {"lb.example.com"}, {"*.lb.example.com"}} {
+		if _, err = policy.check(names, nil); err == nil {
+			t.Fatalf("DNS names %v should not be allowed", names)
+		}
+	}
+	for _, addr := range []string{"192.168.1.2", "11.0.0.1", "peer0"} {
+		if _, err = policy.check(nil, []string{addr}); err == nil {
+			t.Fatalf("IP address %s should not be allowed", addr)
+		}
+	}
+
+	// the certificate verifies for the names requested
+	priv, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := NewDefaultCertificateSpec("peer0", &priv.PublicKey, x509.KeyUsageDigitalSignature)
+	spec.dnsNames = []string{"peer0.example.com", "peer0.lb.example.com"}
+	spec.ips = ips
+	raw, err := (&CA{cert: eca.cert, priv: eca.priv}).newCertificateFromSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"peer0.lb.example.com", "10.1.2.3"} {
+		if err = cert.VerifyHostname(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -17,12 +17,16 @@ limitations under the License.
 package ca
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"errors"
 	"io"
 	"log"
+	"math/big"
 	mrand "math/rand"
 	"time"
 
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 )
 
@@ -44,6 +48,8 @@ var (
 	Error *log.Logger
 	// Panic is a panic logger.
 	Panic *log.Logger
+	// Security is the logger of the security events, written along the warnings.
+	Security *log.Logger
 )
 
 // LogInit initializes the various loggers.
@@ -54,6 +60,7 @@ func LogInit(trace, info, warning, error, panic io.Writer) {
 	Warning = log.New(warning, "WARNING: ", log.LstdFlags|log.Lshortfile)
 	Error = log.New(error, "ERROR: ", log.LstdFlags|log.Lshortfile)
 	Panic = log.New(panic, "PANIC: ", log.LstdFlags|log.Lshortfile)
+	Security = log.New(warning, "SECURITY: ", log.LstdFlags)
 }
 
 var rnd = mrand.NewSource(time.Now().UnixNano())
@@ -90,3 +97,41 @@ func MemberRoleToString(role pb.Role) (string, error) {
 
 	return roleStr, nil
 }
+
+//
+// verifySignature checks sig, of the scheme of type sig.Type, of msg against pub.
+// ECDSA signatures, as (R, S), are over the hash of msg. Ed25519 signatures,
+// in R, are over msg itself. RSA signatures, in R, are PKCS #1 v1.5 ones.
+//
+func verifySignature(pub interface{}, msg []byte, sig *pb.Signature) bool {
+	if sig == nil {
+		return false
+	}
+
+	switch sig.Type {
+	case pb.CryptoType_ECDSA:
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+
+		r, s := big.NewInt(0), big.NewInt(0)
+		r.UnmarshalText(sig.R)
+		s.UnmarshalText(sig.S)
+
+		return ecdsa.Verify(key, primitives.Hash(msg), r, s)
+	case pb.CryptoType_ED25519:
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok || len(key) != ed25519.PublicKeySize {
+			return false
+		}
+
+		return ed25519.Verify(key, msg, sig.R)
+	case pb.CryptoType_RSA:
+		ok, err := primitives.RSAVerify(pub, msg, sig.R)
+
+		return err == nil && ok
+	}
+
+	return false
+}
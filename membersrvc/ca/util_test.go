@@ -17,9 +17,11 @@ limitations under the License.
 package ca
 
 import (
+	"crypto/ed25519"
 	"reflect"
 	"testing"
 
+	"github.com/hyperledger/fabric/core/crypto/primitives"
 	pb "github.com/hyperledger/fabric/membersrvc/protos"
 )
 
@@ -48,3 +50,57 @@ func TestMemberRoleToStringNone(t *testing.T) {
 	}
 
 }
+
+func TestVerifySignature(t *testing.T) {
+	msg := []byte("request")
+
+	ecdsaKey, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := primitives.ECDSASignDirect(ecdsaKey, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	R, _ := r.MarshalText()
+	S, _ := s.MarshalText()
+	ecdsaSig := &pb.Signature{Type: pb.CryptoType_ECDSA, R: R, S: S}
+
+	edKey, err := primitives.NewEd25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := &pb.Signature{Type: pb.CryptoType_ED25519, R: ed25519.Sign(edKey, msg)}
+
+	if !verifySignature(&ecdsaKey.PublicKey, msg, ecdsaSig) {
+		t.Error("The ECDSA signature should verify.")
+	}
+	if !verifySignature(edKey.Public(), msg, edSig) {
+		t.Error("The Ed25519 signature should verify.")
+	}
+	if verifySignature(edKey.Public(), []byte("tampered"), edSig) {
+		t.Error("The Ed25519 signature of another message should not verify.")
+	}
+	rsaKey, err := primitives.NewRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaRaw, err := primitives.RSASign(rsaKey, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig := &pb.Signature{Type: pb.CryptoType_RSA, R: rsaRaw}
+
+	if !verifySignature(&rsaKey.PublicKey, msg, rsaSig) {
+		t.Error("The RSA signature should verify.")
+	}
+	if verifySignature(&rsaKey.PublicKey, []byte("tampered"), rsaSig) {
+		t.Error("The RSA signature of another message should not verify.")
+	}
+	if verifySignature(&ecdsaKey.PublicKey, msg, edSig) || verifySignature(edKey.Public(), msg, ecdsaSig) || verifySignature(edKey.Public(), msg, rsaSig) {
+		t.Error("A signature of another scheme than the key should not verify.")
+	}
+	if verifySignature(edKey.Public(), msg, nil) {
+		t.Error("A missing signature should not verify.")
+	}
+}
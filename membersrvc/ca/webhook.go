@@ -0,0 +1,226 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The CAs notify the webhooks listed in server.webhooks.urls of the registrations, and of
+// the certificates issued and revoked, so that external systems stay in sync. Each event
+// is POSTed as a JSON object, signed with HMAC-SHA256 under server.webhooks.secret. The
+// events are delivered in the background, in order, and retried with an exponential
+// backoff; they are dropped when the queue of undelivered events is full.
+
+const (
+	webhookUserRegistered     = "user.registered"
+	webhookCertificateIssued  = "certificate.issued"
+	webhookCertificateRevoked = "certificate.revoked"
+
+	// header of the HMAC-SHA256 signature of the body, sha256={hex}
+	webhookSignatureHeader = "X-Membersrvc-Signature"
+	// header of the type of the event
+	webhookEventHeader = "X-Membersrvc-Event"
+)
+
+var webhookEvents = map[string]bool{
+	webhookUserRegistered:     true,
+	webhookCertificateIssued:  true,
+	webhookCertificateRevoked: true,
+}
+
+// webhooks notifies the configured webhooks, nil if none is.
+var webhooks *webhookNotifier
+
+// webhookEvent is the JSON body POSTed to the webhooks.
+type webhookEvent struct {
+	ID   string                 `json:"id"`
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// webhookNotifier delivers the events to the webhooks.
+type webhookNotifier struct {
+	urls    []string
+	secret  []byte
+	events  map[string]bool
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	queue   chan *webhookEvent
+}
+
+// startWebhooks starts notifying the webhooks configured in server.webhooks, if any
+// and unless already started.
+//
+func startWebhooks() error {
+	if webhooks != nil {
+		return nil
+	}
+
+	urls := viper.GetStringSlice("server.webhooks.urls")
+	if len(urls) == 0 {
+		return nil
+	}
+	secret := viper.GetString("server.webhooks.secret")
+	if secret == "" {
+		return errors.New("The webhooks require a secret to sign the events, see server.webhooks.secret.")
+	}
+
+	events := viper.GetStringSlice("server.webhooks.events")
+	for _, event := range events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("Unknown webhook event %s.", event)
+		}
+	}
+
+	timeout := viper.GetDuration("server.webhooks.timeout")
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	queue := viper.GetInt("server.webhooks.queue")
+	if queue <= 0 {
+		queue = 1000
+	}
+
+	webhooks = newWebhookNotifier(urls, []byte(secret), events, timeout, viper.GetInt("server.webhooks.retries"), queue)
+	return nil
+}
+
+// newWebhookNotifier returns a notifier of the webhooks urls, signing the events under
+// secret, and starts its delivery. Only the events listed are notified, all if none is.
+//
+func newWebhookNotifier(urls []string, secret []byte, events []string, timeout time.Duration, retries, queue int) *webhookNotifier {
+	n := &webhookNotifier{
+		urls:    urls,
+		secret:  secret,
+		events:  make(map[string]bool),
+		retries: retries,
+		backoff: time.Second,
+		client:  &http.Client{Timeout: timeout},
+		queue:   make(chan *webhookEvent, queue),
+	}
+	for _, event := range events {
+		n.events[event] = true
+	}
+
+	go n.run()
+	return n
+}
+
+// notifyWebhooks notifies the webhooks, if any, of the event typ with data.
+//
+func notifyWebhooks(typ string, data map[string]interface{}) {
+	if webhooks != nil {
+		webhooks.notify(typ, data)
+	}
+}
+
+// notify queues the event typ with data, unless the webhooks are not interested in it
+// or the queue is full.
+//
+func (n *webhookNotifier) notify(typ string, data map[string]interface{}) {
+	if len(n.events) > 0 && !n.events[typ] {
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := &webhookEvent{ID: hex.EncodeToString(id), Type: typ, Time: time.Now().UTC(), Data: data}
+
+	select {
+	case n.queue <- event:
+	default:
+		webhookDeliveries.inc("dropped")
+		Warning.Printf("Webhook event %s %s dropped, too many events waiting for delivery.\n", typ, event.ID)
+	}
+}
+
+func (n *webhookNotifier) run() {
+	for event := range n.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			Error.Println(err)
+			continue
+		}
+
+		for _, url := range n.urls {
+			if err = n.deliver(url, event.Type, body); err != nil {
+				webhookDeliveries.inc("failed")
+				Error.Printf("Failed delivering webhook event %s %s to %s: %s\n", event.Type, event.ID, url, err)
+			} else {
+				webhookDeliveries.inc("delivered")
+			}
+		}
+	}
+}
+
+// deliver POSTs body, the event typ, to url, retrying on failure.
+//
+func (n *webhookNotifier) deliver(url, typ string, body []byte) error {
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(n.backoff << uint(attempt-1))
+		}
+		if err = n.post(url, typ, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (n *webhookNotifier) post(url, typ string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, typ)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(n.secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhookPayload returns the hexadecimal HMAC-SHA256 of body under secret.
+//
+func signWebhookPayload(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
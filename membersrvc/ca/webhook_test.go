@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ca
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	secret := []byte("webhook secret")
+	received := make(chan *webhookEvent, 10)
+	failures := 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// the first delivery fails, and is retried
+		if failures > 0 {
+			failures--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if req.Header.Get(webhookSignatureHeader) != "sha256="+signWebhookPayload(secret, body) {
+			t.Error("Invalid signature of the webhook event")
+		}
+		event := new(webhookEvent)
+		if err = json.Unmarshal(body, event); err != nil {
+			t.Error(err)
+			return
+		}
+		if req.Header.Get(webhookEventHeader) != event.Type {
+			t.Errorf("Unexpected event header [%s]", req.Header.Get(webhookEventHeader))
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	n := newWebhookNotifier([]string{srv.URL}, secret, []string{webhookUserRegistered, webhookCertificateRevoked}, time.Second, 1, 10)
+	n.backoff = time.Millisecond
+
+	n.notify(webhookCertificateIssued, map[string]interface{}{"id": "alice"})
+	n.notify(webhookUserRegistered, map[string]interface{}{"id": "alice"})
+	n.notify(webhookCertificateRevoked, map[string]interface{}{"serials": []string{"1", "2"}})
+
+	for _, expected := range []string{webhookUserRegistered, webhookCertificateRevoked} {
+		select {
+		case event := <-received:
+			if event.Type != expected || event.ID == "" {
+				t.Fatalf("Unexpected event [%v], expected %s", event, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event %s not delivered", expected)
+		}
+	}
+	select {
+	case event := <-received:
+		t.Fatalf("Unexpected event [%v]", event)
+	default:
+	}
+}
@@ -19,14 +19,32 @@ It has these top-level messages:
 	Signature
 	Registrar
 	RegisterUserReq
+	RegisterUsersReq
+	RegisterUserResult
+	RegisterUsersResp
 	ReadUserSetReq
 	User
 	UserSet
 	ECertCreateReq
+	ECertRenewReq
+	ECertCSRReq
 	ECertCreateResp
 	ECertReadReq
 	ECertRevokeReq
+	IdentityRevokeReq
 	ECertCRLReq
+	IssuedCertificate
+	IssuedCertificatesReq
+	IssuedCertificateSet
+	SecretResetReq
+	AttributesRefreshReq
+	AttributesUpdateReq
+	Affiliation
+	AffiliationCreateReq
+	AffiliationReadReq
+	AffiliationSet
+	AffiliationUpdateReq
+	AffiliationDeleteReq
 	TCertCreateReq
 	TCertCreateResp
 	TCertCreateSetReq
@@ -41,6 +59,9 @@ It has these top-level messages:
 	TLSCertReadReq
 	TLSCertRevokeReq
 	Cert
+	CertChain
+	CRL
+	RevocationEvent
 	TCert
 	CertSet
 	CertSets
@@ -73,20 +94,23 @@ var _ = math.Inf
 type CryptoType int32
 
 const (
-	CryptoType_ECDSA CryptoType = 0
-	CryptoType_RSA   CryptoType = 1
-	CryptoType_DSA   CryptoType = 2
+	CryptoType_ECDSA   CryptoType = 0
+	CryptoType_RSA     CryptoType = 1
+	CryptoType_DSA     CryptoType = 2
+	CryptoType_ED25519 CryptoType = 3
 )
 
 var CryptoType_name = map[int32]string{
 	0: "ECDSA",
 	1: "RSA",
 	2: "DSA",
+	3: "ED25519",
 }
 var CryptoType_value = map[string]int32{
-	"ECDSA": 0,
-	"RSA":   1,
-	"DSA":   2,
+	"ECDSA":   0,
+	"RSA":     1,
+	"DSA":     2,
+	"ED25519": 3,
 }
 
 func (x CryptoType) String() string {
@@ -127,6 +151,71 @@ func (x Role) String() string {
 	return proto.EnumName(Role_name, int32(x))
 }
 
+// Certificate revocation.
+//
+type RevocationReason int32
+
+const (
+	RevocationReason_UNSPECIFIED            RevocationReason = 0
+	RevocationReason_KEY_COMPROMISE         RevocationReason = 1
+	RevocationReason_CA_COMPROMISE          RevocationReason = 2
+	RevocationReason_AFFILIATION_CHANGED    RevocationReason = 3
+	RevocationReason_SUPERSEDED             RevocationReason = 4
+	RevocationReason_CESSATION_OF_OPERATION RevocationReason = 5
+	RevocationReason_PRIVILEGE_WITHDRAWN    RevocationReason = 9
+)
+
+var RevocationReason_name = map[int32]string{
+	0: "UNSPECIFIED",
+	1: "KEY_COMPROMISE",
+	2: "CA_COMPROMISE",
+	3: "AFFILIATION_CHANGED",
+	4: "SUPERSEDED",
+	5: "CESSATION_OF_OPERATION",
+	9: "PRIVILEGE_WITHDRAWN",
+}
+var RevocationReason_value = map[string]int32{
+	"UNSPECIFIED":            0,
+	"KEY_COMPROMISE":         1,
+	"CA_COMPROMISE":          2,
+	"AFFILIATION_CHANGED":    3,
+	"SUPERSEDED":             4,
+	"CESSATION_OF_OPERATION": 5,
+	"PRIVILEGE_WITHDRAWN":    9,
+}
+
+func (x RevocationReason) String() string {
+	return proto.EnumName(RevocationReason_name, int32(x))
+}
+
+// Audit of the issued certificates.
+//
+type CertificateType int32
+
+const (
+	CertificateType_ECERT_SIGN CertificateType = 0
+	CertificateType_ECERT_ENC  CertificateType = 1
+	CertificateType_TCERT      CertificateType = 2
+	CertificateType_TLS_CERT   CertificateType = 3
+)
+
+var CertificateType_name = map[int32]string{
+	0: "ECERT_SIGN",
+	1: "ECERT_ENC",
+	2: "TCERT",
+	3: "TLS_CERT",
+}
+var CertificateType_value = map[string]int32{
+	"ECERT_SIGN": 0,
+	"ECERT_ENC":  1,
+	"TCERT":      2,
+	"TLS_CERT":   3,
+}
+
+func (x CertificateType) String() string {
+	return proto.EnumName(CertificateType_name, int32(x))
+}
+
 type CAStatus_StatusCode int32
 
 const (
@@ -369,6 +458,76 @@ func (m *RegisterUserReq) GetSig() *Signature {
 	return nil
 }
 
+type RegisterUsersReq struct {
+	Users     []*RegisterUserReq `protobuf:"bytes,1,rep,name=users" json:"users,omitempty"`
+	Registrar *Registrar         `protobuf:"bytes,2,opt,name=registrar" json:"registrar,omitempty"`
+	Sig       *Signature         `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *RegisterUsersReq) Reset()         { *m = RegisterUsersReq{} }
+func (m *RegisterUsersReq) String() string { return proto.CompactTextString(m) }
+func (*RegisterUsersReq) ProtoMessage()    {}
+
+func (m *RegisterUsersReq) GetUsers() []*RegisterUserReq {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+func (m *RegisterUsersReq) GetRegistrar() *Registrar {
+	if m != nil {
+		return m.Registrar
+	}
+	return nil
+}
+
+func (m *RegisterUsersReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type RegisterUserResult struct {
+	Id    *Identity `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Tok   *Token    `protobuf:"bytes,2,opt,name=tok" json:"tok,omitempty"`
+	Error string    `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *RegisterUserResult) Reset()         { *m = RegisterUserResult{} }
+func (m *RegisterUserResult) String() string { return proto.CompactTextString(m) }
+func (*RegisterUserResult) ProtoMessage()    {}
+
+func (m *RegisterUserResult) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *RegisterUserResult) GetTok() *Token {
+	if m != nil {
+		return m.Tok
+	}
+	return nil
+}
+
+type RegisterUsersResp struct {
+	Results []*RegisterUserResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *RegisterUsersResp) Reset()         { *m = RegisterUsersResp{} }
+func (m *RegisterUsersResp) String() string { return proto.CompactTextString(m) }
+func (*RegisterUsersResp) ProtoMessage()    {}
+
+func (m *RegisterUsersResp) GetResults() []*RegisterUserResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
 type ReadUserSetReq struct {
 	Req  *Identity  `protobuf:"bytes,1,opt,name=req" json:"req,omitempty"`
 	Role Role       `protobuf:"varint,2,opt,name=role,enum=protos.Role" json:"role,omitempty"`
@@ -481,6 +640,94 @@ func (m *ECertCreateReq) GetSig() *Signature {
 	return nil
 }
 
+type ECertRenewReq struct {
+	Ts      *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id      *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Sign    *PublicKey                 `protobuf:"bytes,3,opt,name=sign" json:"sign,omitempty"`
+	Enc     *PublicKey                 `protobuf:"bytes,4,opt,name=enc" json:"enc,omitempty"`
+	Sig     *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
+	SignSig *Signature                 `protobuf:"bytes,6,opt,name=signSig" json:"signSig,omitempty"`
+}
+
+func (m *ECertRenewReq) Reset()         { *m = ECertRenewReq{} }
+func (m *ECertRenewReq) String() string { return proto.CompactTextString(m) }
+func (*ECertRenewReq) ProtoMessage()    {}
+
+func (m *ECertRenewReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *ECertRenewReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *ECertRenewReq) GetSign() *PublicKey {
+	if m != nil {
+		return m.Sign
+	}
+	return nil
+}
+
+func (m *ECertRenewReq) GetEnc() *PublicKey {
+	if m != nil {
+		return m.Enc
+	}
+	return nil
+}
+
+func (m *ECertRenewReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+func (m *ECertRenewReq) GetSignSig() *Signature {
+	if m != nil {
+		return m.SignSig
+	}
+	return nil
+}
+
+type ECertCSRReq struct {
+	Ts   *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id   *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Tok  *Token                     `protobuf:"bytes,3,opt,name=tok" json:"tok,omitempty"`
+	Sign []byte                     `protobuf:"bytes,4,opt,name=sign,proto3" json:"sign,omitempty"`
+	Enc  []byte                     `protobuf:"bytes,5,opt,name=enc,proto3" json:"enc,omitempty"`
+}
+
+func (m *ECertCSRReq) Reset()         { *m = ECertCSRReq{} }
+func (m *ECertCSRReq) String() string { return proto.CompactTextString(m) }
+func (*ECertCSRReq) ProtoMessage()    {}
+
+func (m *ECertCSRReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *ECertCSRReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *ECertCSRReq) GetTok() *Token {
+	if m != nil {
+		return m.Tok
+	}
+	return nil
+}
+
 type ECertCreateResp struct {
 	Certs       *CertPair         `protobuf:"bytes,1,opt,name=certs" json:"certs,omitempty"`
 	Chain       *Token            `protobuf:"bytes,2,opt,name=chain" json:"chain,omitempty"`
@@ -537,9 +784,11 @@ func (m *ECertReadReq) GetId() *Identity {
 }
 
 type ECertRevokeReq struct {
-	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Cert *Cert      `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
-	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+	Id        *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Cert      *Cert                      `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
+	Sig       *Signature                 `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+	Reason    RevocationReason           `protobuf:"varint,4,opt,name=reason,enum=protos.RevocationReason" json:"reason,omitempty"`
+	Effective *google_protobuf.Timestamp `protobuf:"bytes,5,opt,name=effective" json:"effective,omitempty"`
 }
 
 func (m *ECertRevokeReq) Reset()         { *m = ECertRevokeReq{} }
@@ -567,6 +816,53 @@ func (m *ECertRevokeReq) GetSig() *Signature {
 	return nil
 }
 
+func (m *ECertRevokeReq) GetEffective() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Effective
+	}
+	return nil
+}
+
+type IdentityRevokeReq struct {
+	Id        *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Subject   *Identity                  `protobuf:"bytes,2,opt,name=subject" json:"subject,omitempty"`
+	Reason    RevocationReason           `protobuf:"varint,3,opt,name=reason,enum=protos.RevocationReason" json:"reason,omitempty"`
+	Effective *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=effective" json:"effective,omitempty"`
+	Sig       *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *IdentityRevokeReq) Reset()         { *m = IdentityRevokeReq{} }
+func (m *IdentityRevokeReq) String() string { return proto.CompactTextString(m) }
+func (*IdentityRevokeReq) ProtoMessage()    {}
+
+func (m *IdentityRevokeReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *IdentityRevokeReq) GetSubject() *Identity {
+	if m != nil {
+		return m.Subject
+	}
+	return nil
+}
+
+func (m *IdentityRevokeReq) GetEffective() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Effective
+	}
+	return nil
+}
+
+func (m *IdentityRevokeReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
 type ECertCRLReq struct {
 	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
@@ -590,889 +886,1710 @@ func (m *ECertCRLReq) GetSig() *Signature {
 	return nil
 }
 
-type TCertCreateReq struct {
-	Ts  *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	Id  *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
-	Pub *PublicKey                 `protobuf:"bytes,3,opt,name=pub" json:"pub,omitempty"`
-	Sig *Signature                 `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
+type IssuedCertificate struct {
+	Serial    string                     `protobuf:"bytes,1,opt,name=serial" json:"serial,omitempty"`
+	Id        *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Subject   string                     `protobuf:"bytes,3,opt,name=subject" json:"subject,omitempty"`
+	Type      CertificateType            `protobuf:"varint,4,opt,name=type,enum=protos.CertificateType" json:"type,omitempty"`
+	NotBefore *google_protobuf.Timestamp `protobuf:"bytes,5,opt,name=notBefore" json:"notBefore,omitempty"`
+	NotAfter  *google_protobuf.Timestamp `protobuf:"bytes,6,opt,name=notAfter" json:"notAfter,omitempty"`
+	IssuedAt  *google_protobuf.Timestamp `protobuf:"bytes,7,opt,name=issuedAt" json:"issuedAt,omitempty"`
+	Registrar string                     `protobuf:"bytes,8,opt,name=registrar" json:"registrar,omitempty"`
 }
 
-func (m *TCertCreateReq) Reset()         { *m = TCertCreateReq{} }
-func (m *TCertCreateReq) String() string { return proto.CompactTextString(m) }
-func (*TCertCreateReq) ProtoMessage()    {}
+func (m *IssuedCertificate) Reset()         { *m = IssuedCertificate{} }
+func (m *IssuedCertificate) String() string { return proto.CompactTextString(m) }
+func (*IssuedCertificate) ProtoMessage()    {}
 
-func (m *TCertCreateReq) GetTs() *google_protobuf.Timestamp {
+func (m *IssuedCertificate) GetId() *Identity {
 	if m != nil {
-		return m.Ts
+		return m.Id
 	}
 	return nil
 }
 
-func (m *TCertCreateReq) GetId() *Identity {
+func (m *IssuedCertificate) GetNotBefore() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Id
+		return m.NotBefore
 	}
 	return nil
 }
 
-func (m *TCertCreateReq) GetPub() *PublicKey {
+func (m *IssuedCertificate) GetNotAfter() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Pub
+		return m.NotAfter
 	}
 	return nil
 }
 
-func (m *TCertCreateReq) GetSig() *Signature {
+func (m *IssuedCertificate) GetIssuedAt() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Sig
+		return m.IssuedAt
 	}
 	return nil
 }
 
-type TCertCreateResp struct {
-	Cert *Cert `protobuf:"bytes,1,opt,name=cert" json:"cert,omitempty"`
+type IssuedCertificatesReq struct {
+	Id           *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Subject      *Identity                  `protobuf:"bytes,2,opt,name=subject" json:"subject,omitempty"`
+	Types        []CertificateType          `protobuf:"varint,3,rep,name=types,enum=protos.CertificateType" json:"types,omitempty"`
+	IssuedAfter  *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=issuedAfter" json:"issuedAfter,omitempty"`
+	IssuedBefore *google_protobuf.Timestamp `protobuf:"bytes,5,opt,name=issuedBefore" json:"issuedBefore,omitempty"`
+	Unexpired    bool                       `protobuf:"varint,6,opt,name=unexpired" json:"unexpired,omitempty"`
+	Limit        uint32                     `protobuf:"varint,7,opt,name=limit" json:"limit,omitempty"`
+	Sig          *Signature                 `protobuf:"bytes,8,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *TCertCreateResp) Reset()         { *m = TCertCreateResp{} }
-func (m *TCertCreateResp) String() string { return proto.CompactTextString(m) }
-func (*TCertCreateResp) ProtoMessage()    {}
+func (m *IssuedCertificatesReq) Reset()         { *m = IssuedCertificatesReq{} }
+func (m *IssuedCertificatesReq) String() string { return proto.CompactTextString(m) }
+func (*IssuedCertificatesReq) ProtoMessage()    {}
 
-func (m *TCertCreateResp) GetCert() *Cert {
+func (m *IssuedCertificatesReq) GetId() *Identity {
 	if m != nil {
-		return m.Cert
+		return m.Id
 	}
 	return nil
 }
 
-type TCertCreateSetReq struct {
-	Ts         *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	Id         *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
-	Num        uint32                     `protobuf:"varint,3,opt,name=num" json:"num,omitempty"`
-	Attributes []*TCertAttribute          `protobuf:"bytes,4,rep,name=attributes" json:"attributes,omitempty"`
-	Sig        *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
-}
-
-func (m *TCertCreateSetReq) Reset()         { *m = TCertCreateSetReq{} }
-func (m *TCertCreateSetReq) String() string { return proto.CompactTextString(m) }
-func (*TCertCreateSetReq) ProtoMessage()    {}
-
-func (m *TCertCreateSetReq) GetTs() *google_protobuf.Timestamp {
+func (m *IssuedCertificatesReq) GetSubject() *Identity {
 	if m != nil {
-		return m.Ts
+		return m.Subject
 	}
 	return nil
 }
 
-func (m *TCertCreateSetReq) GetId() *Identity {
+func (m *IssuedCertificatesReq) GetIssuedAfter() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Id
+		return m.IssuedAfter
 	}
 	return nil
 }
 
-func (m *TCertCreateSetReq) GetAttributes() []*TCertAttribute {
+func (m *IssuedCertificatesReq) GetIssuedBefore() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Attributes
+		return m.IssuedBefore
 	}
 	return nil
 }
 
-func (m *TCertCreateSetReq) GetSig() *Signature {
+func (m *IssuedCertificatesReq) GetSig() *Signature {
 	if m != nil {
 		return m.Sig
 	}
 	return nil
 }
 
-type TCertAttribute struct {
-	AttributeName string `protobuf:"bytes,1,opt,name=attributeName" json:"attributeName,omitempty"`
-}
-
-func (m *TCertAttribute) Reset()         { *m = TCertAttribute{} }
-func (m *TCertAttribute) String() string { return proto.CompactTextString(m) }
-func (*TCertAttribute) ProtoMessage()    {}
-
-type TCertCreateSetResp struct {
-	Certs *CertSet `protobuf:"bytes,1,opt,name=certs" json:"certs,omitempty"`
+type IssuedCertificateSet struct {
+	Certs []*IssuedCertificate `protobuf:"bytes,1,rep,name=certs" json:"certs,omitempty"`
 }
 
-func (m *TCertCreateSetResp) Reset()         { *m = TCertCreateSetResp{} }
-func (m *TCertCreateSetResp) String() string { return proto.CompactTextString(m) }
-func (*TCertCreateSetResp) ProtoMessage()    {}
+func (m *IssuedCertificateSet) Reset()         { *m = IssuedCertificateSet{} }
+func (m *IssuedCertificateSet) String() string { return proto.CompactTextString(m) }
+func (*IssuedCertificateSet) ProtoMessage()    {}
 
-func (m *TCertCreateSetResp) GetCerts() *CertSet {
+func (m *IssuedCertificateSet) GetCerts() []*IssuedCertificate {
 	if m != nil {
 		return m.Certs
 	}
 	return nil
 }
 
-type TCertReadSetsReq struct {
-	Begin *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=begin" json:"begin,omitempty"`
-	End   *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=end" json:"end,omitempty"`
-	Req   *Identity                  `protobuf:"bytes,3,opt,name=req" json:"req,omitempty"`
-	Role  Role                       `protobuf:"varint,4,opt,name=role,enum=protos.Role" json:"role,omitempty"`
-	Sig   *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
+type SecretResetReq struct {
+	Id      *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Subject *Identity                  `protobuf:"bytes,2,opt,name=subject" json:"subject,omitempty"`
+	Sig     *Signature                 `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+	Ts      *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=ts" json:"ts,omitempty"`
 }
 
-func (m *TCertReadSetsReq) Reset()         { *m = TCertReadSetsReq{} }
-func (m *TCertReadSetsReq) String() string { return proto.CompactTextString(m) }
-func (*TCertReadSetsReq) ProtoMessage()    {}
+func (m *SecretResetReq) Reset()         { *m = SecretResetReq{} }
+func (m *SecretResetReq) String() string { return proto.CompactTextString(m) }
+func (*SecretResetReq) ProtoMessage()    {}
 
-func (m *TCertReadSetsReq) GetBegin() *google_protobuf.Timestamp {
+func (m *SecretResetReq) GetId() *Identity {
 	if m != nil {
-		return m.Begin
+		return m.Id
 	}
 	return nil
 }
 
-func (m *TCertReadSetsReq) GetEnd() *google_protobuf.Timestamp {
+func (m *SecretResetReq) GetSubject() *Identity {
 	if m != nil {
-		return m.End
+		return m.Subject
 	}
 	return nil
 }
 
-func (m *TCertReadSetsReq) GetReq() *Identity {
+func (m *SecretResetReq) GetSig() *Signature {
 	if m != nil {
-		return m.Req
+		return m.Sig
 	}
 	return nil
 }
 
-func (m *TCertReadSetsReq) GetSig() *Signature {
+func (m *SecretResetReq) GetTs() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Sig
+		return m.Ts
 	}
 	return nil
 }
 
-type TCertRevokeReq struct {
-	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Cert *Cert      `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
-	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+type AttributesRefreshReq struct {
+	Id      *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Subject *Identity  `protobuf:"bytes,2,opt,name=subject" json:"subject,omitempty"`
+	Sig     *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *TCertRevokeReq) Reset()         { *m = TCertRevokeReq{} }
-func (m *TCertRevokeReq) String() string { return proto.CompactTextString(m) }
-func (*TCertRevokeReq) ProtoMessage()    {}
+func (m *AttributesRefreshReq) Reset()         { *m = AttributesRefreshReq{} }
+func (m *AttributesRefreshReq) String() string { return proto.CompactTextString(m) }
+func (*AttributesRefreshReq) ProtoMessage()    {}
 
-func (m *TCertRevokeReq) GetId() *Identity {
+func (m *AttributesRefreshReq) GetId() *Identity {
 	if m != nil {
 		return m.Id
 	}
 	return nil
 }
 
-func (m *TCertRevokeReq) GetCert() *Cert {
+func (m *AttributesRefreshReq) GetSubject() *Identity {
 	if m != nil {
-		return m.Cert
+		return m.Subject
 	}
 	return nil
 }
 
-func (m *TCertRevokeReq) GetSig() *Signature {
+func (m *AttributesRefreshReq) GetSig() *Signature {
 	if m != nil {
 		return m.Sig
 	}
 	return nil
 }
 
-type TCertRevokeSetReq struct {
-	Id  *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Ts  *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
-	Sig *Signature                 `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+type AttributesUpdateReq struct {
+	Id         *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Subject    *Identity                  `protobuf:"bytes,2,opt,name=subject" json:"subject,omitempty"`
+	Attributes []*ACAAttribute            `protobuf:"bytes,3,rep,name=attributes" json:"attributes,omitempty"`
+	Sig        *Signature                 `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
+	Ts         *google_protobuf.Timestamp `protobuf:"bytes,5,opt,name=ts" json:"ts,omitempty"`
 }
 
-func (m *TCertRevokeSetReq) Reset()         { *m = TCertRevokeSetReq{} }
-func (m *TCertRevokeSetReq) String() string { return proto.CompactTextString(m) }
-func (*TCertRevokeSetReq) ProtoMessage()    {}
+func (m *AttributesUpdateReq) Reset()         { *m = AttributesUpdateReq{} }
+func (m *AttributesUpdateReq) String() string { return proto.CompactTextString(m) }
+func (*AttributesUpdateReq) ProtoMessage()    {}
 
-func (m *TCertRevokeSetReq) GetId() *Identity {
+func (m *AttributesUpdateReq) GetId() *Identity {
 	if m != nil {
 		return m.Id
 	}
 	return nil
 }
 
-func (m *TCertRevokeSetReq) GetTs() *google_protobuf.Timestamp {
+func (m *AttributesUpdateReq) GetSubject() *Identity {
 	if m != nil {
-		return m.Ts
+		return m.Subject
 	}
 	return nil
 }
 
-func (m *TCertRevokeSetReq) GetSig() *Signature {
+func (m *AttributesUpdateReq) GetAttributes() []*ACAAttribute {
 	if m != nil {
-		return m.Sig
+		return m.Attributes
 	}
 	return nil
 }
 
-type TCertCRLReq struct {
-	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
-}
-
-func (m *TCertCRLReq) Reset()         { *m = TCertCRLReq{} }
-func (m *TCertCRLReq) String() string { return proto.CompactTextString(m) }
-func (*TCertCRLReq) ProtoMessage()    {}
-
-func (m *TCertCRLReq) GetId() *Identity {
+func (m *AttributesUpdateReq) GetSig() *Signature {
 	if m != nil {
-		return m.Id
+		return m.Sig
 	}
 	return nil
 }
 
-func (m *TCertCRLReq) GetSig() *Signature {
+func (m *AttributesUpdateReq) GetTs() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Sig
+		return m.Ts
 	}
 	return nil
 }
 
-type TLSCertCreateReq struct {
-	Ts  *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	Id  *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
-	Pub *PublicKey                 `protobuf:"bytes,3,opt,name=pub" json:"pub,omitempty"`
-	Sig *Signature                 `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
+type Affiliation struct {
+	Name   string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Parent string `protobuf:"bytes,2,opt,name=parent" json:"parent,omitempty"`
 }
 
-func (m *TLSCertCreateReq) Reset()         { *m = TLSCertCreateReq{} }
-func (m *TLSCertCreateReq) String() string { return proto.CompactTextString(m) }
-func (*TLSCertCreateReq) ProtoMessage()    {}
+func (m *Affiliation) Reset()         { *m = Affiliation{} }
+func (m *Affiliation) String() string { return proto.CompactTextString(m) }
+func (*Affiliation) ProtoMessage()    {}
 
-func (m *TLSCertCreateReq) GetTs() *google_protobuf.Timestamp {
-	if m != nil {
-		return m.Ts
-	}
-	return nil
+type AffiliationCreateReq struct {
+	Id          *Identity    `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Affiliation *Affiliation `protobuf:"bytes,2,opt,name=affiliation" json:"affiliation,omitempty"`
+	Sig         *Signature   `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *TLSCertCreateReq) GetId() *Identity {
+func (m *AffiliationCreateReq) Reset()         { *m = AffiliationCreateReq{} }
+func (m *AffiliationCreateReq) String() string { return proto.CompactTextString(m) }
+func (*AffiliationCreateReq) ProtoMessage()    {}
+
+func (m *AffiliationCreateReq) GetId() *Identity {
 	if m != nil {
 		return m.Id
 	}
 	return nil
 }
 
-func (m *TLSCertCreateReq) GetPub() *PublicKey {
+func (m *AffiliationCreateReq) GetAffiliation() *Affiliation {
 	if m != nil {
-		return m.Pub
+		return m.Affiliation
 	}
 	return nil
 }
 
-func (m *TLSCertCreateReq) GetSig() *Signature {
+func (m *AffiliationCreateReq) GetSig() *Signature {
 	if m != nil {
 		return m.Sig
 	}
 	return nil
 }
 
-type TLSCertCreateResp struct {
-	Cert     *Cert `protobuf:"bytes,1,opt,name=cert" json:"cert,omitempty"`
-	RootCert *Cert `protobuf:"bytes,2,opt,name=rootCert" json:"rootCert,omitempty"`
+type AffiliationReadReq struct {
+	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *TLSCertCreateResp) Reset()         { *m = TLSCertCreateResp{} }
-func (m *TLSCertCreateResp) String() string { return proto.CompactTextString(m) }
-func (*TLSCertCreateResp) ProtoMessage()    {}
+func (m *AffiliationReadReq) Reset()         { *m = AffiliationReadReq{} }
+func (m *AffiliationReadReq) String() string { return proto.CompactTextString(m) }
+func (*AffiliationReadReq) ProtoMessage()    {}
 
-func (m *TLSCertCreateResp) GetCert() *Cert {
+func (m *AffiliationReadReq) GetId() *Identity {
 	if m != nil {
-		return m.Cert
+		return m.Id
 	}
 	return nil
 }
 
-func (m *TLSCertCreateResp) GetRootCert() *Cert {
+func (m *AffiliationReadReq) GetSig() *Signature {
 	if m != nil {
-		return m.RootCert
+		return m.Sig
 	}
 	return nil
 }
 
-type TLSCertReadReq struct {
-	Id *Identity `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+type AffiliationSet struct {
+	Affiliations []*Affiliation `protobuf:"bytes,1,rep,name=affiliations" json:"affiliations,omitempty"`
 }
 
-func (m *TLSCertReadReq) Reset()         { *m = TLSCertReadReq{} }
-func (m *TLSCertReadReq) String() string { return proto.CompactTextString(m) }
-func (*TLSCertReadReq) ProtoMessage()    {}
+func (m *AffiliationSet) Reset()         { *m = AffiliationSet{} }
+func (m *AffiliationSet) String() string { return proto.CompactTextString(m) }
+func (*AffiliationSet) ProtoMessage()    {}
 
-func (m *TLSCertReadReq) GetId() *Identity {
+func (m *AffiliationSet) GetAffiliations() []*Affiliation {
 	if m != nil {
-		return m.Id
+		return m.Affiliations
 	}
 	return nil
 }
 
-type TLSCertRevokeReq struct {
-	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Cert *Cert      `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
-	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+type AffiliationUpdateReq struct {
+	Id          *Identity    `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Name        string       `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Affiliation *Affiliation `protobuf:"bytes,3,opt,name=affiliation" json:"affiliation,omitempty"`
+	Sig         *Signature   `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *TLSCertRevokeReq) Reset()         { *m = TLSCertRevokeReq{} }
-func (m *TLSCertRevokeReq) String() string { return proto.CompactTextString(m) }
-func (*TLSCertRevokeReq) ProtoMessage()    {}
+func (m *AffiliationUpdateReq) Reset()         { *m = AffiliationUpdateReq{} }
+func (m *AffiliationUpdateReq) String() string { return proto.CompactTextString(m) }
+func (*AffiliationUpdateReq) ProtoMessage()    {}
 
-func (m *TLSCertRevokeReq) GetId() *Identity {
+func (m *AffiliationUpdateReq) GetId() *Identity {
 	if m != nil {
 		return m.Id
 	}
 	return nil
 }
 
-func (m *TLSCertRevokeReq) GetCert() *Cert {
+func (m *AffiliationUpdateReq) GetAffiliation() *Affiliation {
 	if m != nil {
-		return m.Cert
+		return m.Affiliation
 	}
 	return nil
 }
 
-func (m *TLSCertRevokeReq) GetSig() *Signature {
+func (m *AffiliationUpdateReq) GetSig() *Signature {
 	if m != nil {
 		return m.Sig
 	}
 	return nil
 }
 
-// Certificate issued by either the ECA or TCA.
-//
-type Cert struct {
-	Cert []byte `protobuf:"bytes,1,opt,name=cert,proto3" json:"cert,omitempty"`
+type AffiliationDeleteReq struct {
+	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Name string     `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *Cert) Reset()         { *m = Cert{} }
-func (m *Cert) String() string { return proto.CompactTextString(m) }
-func (*Cert) ProtoMessage()    {}
+func (m *AffiliationDeleteReq) Reset()         { *m = AffiliationDeleteReq{} }
+func (m *AffiliationDeleteReq) String() string { return proto.CompactTextString(m) }
+func (*AffiliationDeleteReq) ProtoMessage()    {}
 
-// TCert
-//
-type TCert struct {
-	Cert  []byte `protobuf:"bytes,1,opt,name=cert,proto3" json:"cert,omitempty"`
-	Prek0 []byte `protobuf:"bytes,2,opt,name=prek0,proto3" json:"prek0,omitempty"`
+func (m *AffiliationDeleteReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
 }
 
-func (m *TCert) Reset()         { *m = TCert{} }
-func (m *TCert) String() string { return proto.CompactTextString(m) }
-func (*TCert) ProtoMessage()    {}
+func (m *AffiliationDeleteReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
 
-type CertSet struct {
-	Ts    *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	Id    *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
-	Key   []byte                     `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
-	Certs []*TCert                   `protobuf:"bytes,4,rep,name=certs" json:"certs,omitempty"`
+type TCertCreateReq struct {
+	Ts  *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id  *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Pub *PublicKey                 `protobuf:"bytes,3,opt,name=pub" json:"pub,omitempty"`
+	Sig *Signature                 `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *CertSet) Reset()         { *m = CertSet{} }
-func (m *CertSet) String() string { return proto.CompactTextString(m) }
-func (*CertSet) ProtoMessage()    {}
+func (m *TCertCreateReq) Reset()         { *m = TCertCreateReq{} }
+func (m *TCertCreateReq) String() string { return proto.CompactTextString(m) }
+func (*TCertCreateReq) ProtoMessage()    {}
 
-func (m *CertSet) GetTs() *google_protobuf.Timestamp {
+func (m *TCertCreateReq) GetTs() *google_protobuf.Timestamp {
 	if m != nil {
 		return m.Ts
 	}
 	return nil
 }
 
-func (m *CertSet) GetId() *Identity {
+func (m *TCertCreateReq) GetId() *Identity {
 	if m != nil {
 		return m.Id
 	}
 	return nil
 }
 
-func (m *CertSet) GetCerts() []*TCert {
+func (m *TCertCreateReq) GetPub() *PublicKey {
 	if m != nil {
-		return m.Certs
+		return m.Pub
 	}
 	return nil
 }
 
-type CertSets struct {
-	Sets []*CertSet `protobuf:"bytes,1,rep,name=sets" json:"sets,omitempty"`
-}
-
-func (m *CertSets) Reset()         { *m = CertSets{} }
-func (m *CertSets) String() string { return proto.CompactTextString(m) }
-func (*CertSets) ProtoMessage()    {}
-
-func (m *CertSets) GetSets() []*CertSet {
+func (m *TCertCreateReq) GetSig() *Signature {
 	if m != nil {
-		return m.Sets
+		return m.Sig
 	}
 	return nil
 }
 
-type CertPair struct {
-	Sign []byte `protobuf:"bytes,1,opt,name=sign,proto3" json:"sign,omitempty"`
-	Enc  []byte `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`
-}
-
-func (m *CertPair) Reset()         { *m = CertPair{} }
-func (m *CertPair) String() string { return proto.CompactTextString(m) }
-func (*CertPair) ProtoMessage()    {}
-
-// ACAAttrReq is sent to request an ACert (attributes certificate) to the Attribute Certificate Authority (ACA).
-type ACAAttrReq struct {
-	// Request time
-	Ts *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	// User identity
-	Id *Identity `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
-	// Enrollment certificate
-	ECert *Cert `protobuf:"bytes,3,opt,name=eCert" json:"eCert,omitempty"`
-	// Collection of requested attributes including the attribute name and its respective value hash.
-	Attributes []*TCertAttribute `protobuf:"bytes,4,rep,name=attributes" json:"attributes,omitempty"`
-	// The request is signed by the TCA.
-	Signature *Signature `protobuf:"bytes,5,opt,name=signature" json:"signature,omitempty"`
+type TCertCreateResp struct {
+	Cert *Cert `protobuf:"bytes,1,opt,name=cert" json:"cert,omitempty"`
 }
 
-func (m *ACAAttrReq) Reset()         { *m = ACAAttrReq{} }
-func (m *ACAAttrReq) String() string { return proto.CompactTextString(m) }
-func (*ACAAttrReq) ProtoMessage()    {}
+func (m *TCertCreateResp) Reset()         { *m = TCertCreateResp{} }
+func (m *TCertCreateResp) String() string { return proto.CompactTextString(m) }
+func (*TCertCreateResp) ProtoMessage()    {}
 
-func (m *ACAAttrReq) GetTs() *google_protobuf.Timestamp {
+func (m *TCertCreateResp) GetCert() *Cert {
 	if m != nil {
-		return m.Ts
+		return m.Cert
 	}
 	return nil
 }
 
-func (m *ACAAttrReq) GetId() *Identity {
+type TCertCreateSetReq struct {
+	Ts         *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id         *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Num        uint32                     `protobuf:"varint,3,opt,name=num" json:"num,omitempty"`
+	Attributes []*TCertAttribute          `protobuf:"bytes,4,rep,name=attributes" json:"attributes,omitempty"`
+	Sig        *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
+	Validity   uint64                     `protobuf:"varint,6,opt,name=validity" json:"validity,omitempty"`
+}
+
+func (m *TCertCreateSetReq) Reset()         { *m = TCertCreateSetReq{} }
+func (m *TCertCreateSetReq) String() string { return proto.CompactTextString(m) }
+func (*TCertCreateSetReq) ProtoMessage()    {}
+
+func (m *TCertCreateSetReq) GetTs() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Id
+		return m.Ts
 	}
 	return nil
 }
 
-func (m *ACAAttrReq) GetECert() *Cert {
+func (m *TCertCreateSetReq) GetId() *Identity {
 	if m != nil {
-		return m.ECert
+		return m.Id
 	}
 	return nil
 }
 
-func (m *ACAAttrReq) GetAttributes() []*TCertAttribute {
+func (m *TCertCreateSetReq) GetAttributes() []*TCertAttribute {
 	if m != nil {
 		return m.Attributes
 	}
 	return nil
 }
 
-func (m *ACAAttrReq) GetSignature() *Signature {
+func (m *TCertCreateSetReq) GetSig() *Signature {
 	if m != nil {
-		return m.Signature
+		return m.Sig
 	}
 	return nil
 }
 
-// ACAAttrResp is the response of Attribute Certificate Authority (ACA) to the attribute request. Is composed by the following fields:
-type ACAAttrResp struct {
-	// Indicates the request process status.
-	Status ACAAttrResp_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.ACAAttrResp_StatusCode" json:"status,omitempty"`
-	// Attribute certificate. Include all the attributes certificated.
-	Cert *Cert `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
-	// The response is signed by the ACA.
-	Signature *Signature `protobuf:"bytes,3,opt,name=signature" json:"signature,omitempty"`
+type TCertAttribute struct {
+	AttributeName string `protobuf:"bytes,1,opt,name=attributeName" json:"attributeName,omitempty"`
 }
 
-func (m *ACAAttrResp) Reset()         { *m = ACAAttrResp{} }
-func (m *ACAAttrResp) String() string { return proto.CompactTextString(m) }
-func (*ACAAttrResp) ProtoMessage()    {}
+func (m *TCertAttribute) Reset()         { *m = TCertAttribute{} }
+func (m *TCertAttribute) String() string { return proto.CompactTextString(m) }
+func (*TCertAttribute) ProtoMessage()    {}
 
-func (m *ACAAttrResp) GetCert() *Cert {
-	if m != nil {
-		return m.Cert
-	}
-	return nil
+type TCertCreateSetResp struct {
+	Certs *CertSet `protobuf:"bytes,1,opt,name=certs" json:"certs,omitempty"`
 }
 
-func (m *ACAAttrResp) GetSignature() *Signature {
+func (m *TCertCreateSetResp) Reset()         { *m = TCertCreateSetResp{} }
+func (m *TCertCreateSetResp) String() string { return proto.CompactTextString(m) }
+func (*TCertCreateSetResp) ProtoMessage()    {}
+
+func (m *TCertCreateSetResp) GetCerts() *CertSet {
 	if m != nil {
-		return m.Signature
+		return m.Certs
 	}
 	return nil
 }
 
-// ACAFetchAttrReq is a request to the Attribute Certificate Authority (ACA) to refresh attributes values from the sources.
-type ACAFetchAttrReq struct {
-	// Request timestamp
-	Ts *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
-	// Enrollment certificate.
-	ECert *Cert `protobuf:"bytes,2,opt,name=eCert" json:"eCert,omitempty"`
-	// The request is signed by the ECA.
-	Signature *Signature `protobuf:"bytes,3,opt,name=signature" json:"signature,omitempty"`
+type TCertReadSetsReq struct {
+	Begin *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=begin" json:"begin,omitempty"`
+	End   *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=end" json:"end,omitempty"`
+	Req   *Identity                  `protobuf:"bytes,3,opt,name=req" json:"req,omitempty"`
+	Role  Role                       `protobuf:"varint,4,opt,name=role,enum=protos.Role" json:"role,omitempty"`
+	Sig   *Signature                 `protobuf:"bytes,5,opt,name=sig" json:"sig,omitempty"`
 }
 
-func (m *ACAFetchAttrReq) Reset()         { *m = ACAFetchAttrReq{} }
-func (m *ACAFetchAttrReq) String() string { return proto.CompactTextString(m) }
-func (*ACAFetchAttrReq) ProtoMessage()    {}
+func (m *TCertReadSetsReq) Reset()         { *m = TCertReadSetsReq{} }
+func (m *TCertReadSetsReq) String() string { return proto.CompactTextString(m) }
+func (*TCertReadSetsReq) ProtoMessage()    {}
 
-func (m *ACAFetchAttrReq) GetTs() *google_protobuf.Timestamp {
+func (m *TCertReadSetsReq) GetBegin() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.Ts
+		return m.Begin
 	}
 	return nil
 }
 
-func (m *ACAFetchAttrReq) GetECert() *Cert {
+func (m *TCertReadSetsReq) GetEnd() *google_protobuf.Timestamp {
 	if m != nil {
-		return m.ECert
+		return m.End
 	}
 	return nil
 }
 
-func (m *ACAFetchAttrReq) GetSignature() *Signature {
+func (m *TCertReadSetsReq) GetReq() *Identity {
 	if m != nil {
-		return m.Signature
+		return m.Req
 	}
 	return nil
 }
 
-// ACAFetchAttrReq is the answer of the Attribute Certificate Authority (ACA) to the refresh request.
-type ACAFetchAttrResp struct {
+func (m *TCertReadSetsReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TCertRevokeReq struct {
+	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Cert *Cert      `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
+	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TCertRevokeReq) Reset()         { *m = TCertRevokeReq{} }
+func (m *TCertRevokeReq) String() string { return proto.CompactTextString(m) }
+func (*TCertRevokeReq) ProtoMessage()    {}
+
+func (m *TCertRevokeReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TCertRevokeReq) GetCert() *Cert {
+	if m != nil {
+		return m.Cert
+	}
+	return nil
+}
+
+func (m *TCertRevokeReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TCertRevokeSetReq struct {
+	Id  *Identity                  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Ts  *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
+	Sig *Signature                 `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TCertRevokeSetReq) Reset()         { *m = TCertRevokeSetReq{} }
+func (m *TCertRevokeSetReq) String() string { return proto.CompactTextString(m) }
+func (*TCertRevokeSetReq) ProtoMessage()    {}
+
+func (m *TCertRevokeSetReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TCertRevokeSetReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *TCertRevokeSetReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TCertCRLReq struct {
+	Id  *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Sig *Signature `protobuf:"bytes,2,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TCertCRLReq) Reset()         { *m = TCertCRLReq{} }
+func (m *TCertCRLReq) String() string { return proto.CompactTextString(m) }
+func (*TCertCRLReq) ProtoMessage()    {}
+
+func (m *TCertCRLReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TCertCRLReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TLSCertCreateReq struct {
+	Ts          *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id          *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Pub         *PublicKey                 `protobuf:"bytes,3,opt,name=pub" json:"pub,omitempty"`
+	Sig         *Signature                 `protobuf:"bytes,4,opt,name=sig" json:"sig,omitempty"`
+	DnsNames    []string                   `protobuf:"bytes,5,rep,name=dnsNames" json:"dnsNames,omitempty"`
+	IpAddresses []string                   `protobuf:"bytes,6,rep,name=ipAddresses" json:"ipAddresses,omitempty"`
+}
+
+func (m *TLSCertCreateReq) Reset()         { *m = TLSCertCreateReq{} }
+func (m *TLSCertCreateReq) String() string { return proto.CompactTextString(m) }
+func (*TLSCertCreateReq) ProtoMessage()    {}
+
+func (m *TLSCertCreateReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *TLSCertCreateReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TLSCertCreateReq) GetPub() *PublicKey {
+	if m != nil {
+		return m.Pub
+	}
+	return nil
+}
+
+func (m *TLSCertCreateReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+type TLSCertCreateResp struct {
+	Cert     *Cert `protobuf:"bytes,1,opt,name=cert" json:"cert,omitempty"`
+	RootCert *Cert `protobuf:"bytes,2,opt,name=rootCert" json:"rootCert,omitempty"`
+}
+
+func (m *TLSCertCreateResp) Reset()         { *m = TLSCertCreateResp{} }
+func (m *TLSCertCreateResp) String() string { return proto.CompactTextString(m) }
+func (*TLSCertCreateResp) ProtoMessage()    {}
+
+func (m *TLSCertCreateResp) GetCert() *Cert {
+	if m != nil {
+		return m.Cert
+	}
+	return nil
+}
+
+func (m *TLSCertCreateResp) GetRootCert() *Cert {
+	if m != nil {
+		return m.RootCert
+	}
+	return nil
+}
+
+type TLSCertReadReq struct {
+	Id *Identity `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *TLSCertReadReq) Reset()         { *m = TLSCertReadReq{} }
+func (m *TLSCertReadReq) String() string { return proto.CompactTextString(m) }
+func (*TLSCertReadReq) ProtoMessage()    {}
+
+func (m *TLSCertReadReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+type TLSCertRevokeReq struct {
+	Id   *Identity  `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Cert *Cert      `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
+	Sig  *Signature `protobuf:"bytes,3,opt,name=sig" json:"sig,omitempty"`
+}
+
+func (m *TLSCertRevokeReq) Reset()         { *m = TLSCertRevokeReq{} }
+func (m *TLSCertRevokeReq) String() string { return proto.CompactTextString(m) }
+func (*TLSCertRevokeReq) ProtoMessage()    {}
+
+func (m *TLSCertRevokeReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *TLSCertRevokeReq) GetCert() *Cert {
+	if m != nil {
+		return m.Cert
+	}
+	return nil
+}
+
+func (m *TLSCertRevokeReq) GetSig() *Signature {
+	if m != nil {
+		return m.Sig
+	}
+	return nil
+}
+
+// Certificate issued by either the ECA or TCA.
+//
+type Cert struct {
+	Cert []byte `protobuf:"bytes,1,opt,name=cert,proto3" json:"cert,omitempty"`
+}
+
+func (m *Cert) Reset()         { *m = Cert{} }
+func (m *Cert) String() string { return proto.CompactTextString(m) }
+func (*Cert) ProtoMessage()    {}
+
+// Certificate chain of either the ECA, TCA or TLSCA.
+//
+type CertChain struct {
+	Certs [][]byte `protobuf:"bytes,1,rep,name=certs,proto3" json:"certs,omitempty"`
+}
+
+func (m *CertChain) Reset()         { *m = CertChain{} }
+func (m *CertChain) String() string { return proto.CompactTextString(m) }
+func (*CertChain) ProtoMessage()    {}
+
+// Certificate revocation list of either the ECA or TCA.
+//
+type CRL struct {
+	Crl []byte `protobuf:"bytes,1,opt,name=crl,proto3" json:"crl,omitempty"`
+}
+
+func (m *CRL) Reset()         { *m = CRL{} }
+func (m *CRL) String() string { return proto.CompactTextString(m) }
+func (*CRL) ProtoMessage()    {}
+
+// Revocation of certificates by the ECA or the TCA, pushed to the subscribers of the
+// revocation feed along with the new CRL of the CA.
+//
+type RevocationEvent struct {
+	Ca        string                     `protobuf:"bytes,1,opt,name=ca" json:"ca,omitempty"`
+	Serials   []string                   `protobuf:"bytes,2,rep,name=serials" json:"serials,omitempty"`
+	Reason    RevocationReason           `protobuf:"varint,3,opt,name=reason,enum=protos.RevocationReason" json:"reason,omitempty"`
+	InvalidAt *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=invalidAt" json:"invalidAt,omitempty"`
+	Crl       *CRL                       `protobuf:"bytes,5,opt,name=crl" json:"crl,omitempty"`
+}
+
+func (m *RevocationEvent) Reset()         { *m = RevocationEvent{} }
+func (m *RevocationEvent) String() string { return proto.CompactTextString(m) }
+func (*RevocationEvent) ProtoMessage()    {}
+
+func (m *RevocationEvent) GetInvalidAt() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.InvalidAt
+	}
+	return nil
+}
+
+func (m *RevocationEvent) GetCrl() *CRL {
+	if m != nil {
+		return m.Crl
+	}
+	return nil
+}
+
+// TCert
+//
+type TCert struct {
+	Cert  []byte `protobuf:"bytes,1,opt,name=cert,proto3" json:"cert,omitempty"`
+	Prek0 []byte `protobuf:"bytes,2,opt,name=prek0,proto3" json:"prek0,omitempty"`
+}
+
+func (m *TCert) Reset()         { *m = TCert{} }
+func (m *TCert) String() string { return proto.CompactTextString(m) }
+func (*TCert) ProtoMessage()    {}
+
+type CertSet struct {
+	Ts    *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	Id    *Identity                  `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Key   []byte                     `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Certs []*TCert                   `protobuf:"bytes,4,rep,name=certs" json:"certs,omitempty"`
+}
+
+func (m *CertSet) Reset()         { *m = CertSet{} }
+func (m *CertSet) String() string { return proto.CompactTextString(m) }
+func (*CertSet) ProtoMessage()    {}
+
+func (m *CertSet) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *CertSet) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *CertSet) GetCerts() []*TCert {
+	if m != nil {
+		return m.Certs
+	}
+	return nil
+}
+
+type CertSets struct {
+	Sets []*CertSet `protobuf:"bytes,1,rep,name=sets" json:"sets,omitempty"`
+}
+
+func (m *CertSets) Reset()         { *m = CertSets{} }
+func (m *CertSets) String() string { return proto.CompactTextString(m) }
+func (*CertSets) ProtoMessage()    {}
+
+func (m *CertSets) GetSets() []*CertSet {
+	if m != nil {
+		return m.Sets
+	}
+	return nil
+}
+
+type CertPair struct {
+	Sign []byte `protobuf:"bytes,1,opt,name=sign,proto3" json:"sign,omitempty"`
+	Enc  []byte `protobuf:"bytes,2,opt,name=enc,proto3" json:"enc,omitempty"`
+}
+
+func (m *CertPair) Reset()         { *m = CertPair{} }
+func (m *CertPair) String() string { return proto.CompactTextString(m) }
+func (*CertPair) ProtoMessage()    {}
+
+// ACAAttrReq is sent to request an ACert (attributes certificate) to the Attribute Certificate Authority (ACA).
+type ACAAttrReq struct {
+	// Request time
+	Ts *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	// User identity
+	Id *Identity `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	// Enrollment certificate
+	ECert *Cert `protobuf:"bytes,3,opt,name=eCert" json:"eCert,omitempty"`
+	// Collection of requested attributes including the attribute name and its respective value hash.
+	Attributes []*TCertAttribute `protobuf:"bytes,4,rep,name=attributes" json:"attributes,omitempty"`
+	// The request is signed by the TCA.
+	Signature *Signature `protobuf:"bytes,5,opt,name=signature" json:"signature,omitempty"`
+}
+
+func (m *ACAAttrReq) Reset()         { *m = ACAAttrReq{} }
+func (m *ACAAttrReq) String() string { return proto.CompactTextString(m) }
+func (*ACAAttrReq) ProtoMessage()    {}
+
+func (m *ACAAttrReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *ACAAttrReq) GetId() *Identity {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *ACAAttrReq) GetECert() *Cert {
+	if m != nil {
+		return m.ECert
+	}
+	return nil
+}
+
+func (m *ACAAttrReq) GetAttributes() []*TCertAttribute {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+func (m *ACAAttrReq) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// ACAAttrResp is the response of Attribute Certificate Authority (ACA) to the attribute request. Is composed by the following fields:
+type ACAAttrResp struct {
+	// Indicates the request process status.
+	Status ACAAttrResp_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.ACAAttrResp_StatusCode" json:"status,omitempty"`
+	// Attribute certificate. Include all the attributes certificated.
+	Cert *Cert `protobuf:"bytes,2,opt,name=cert" json:"cert,omitempty"`
+	// The response is signed by the ACA.
+	Signature *Signature `protobuf:"bytes,3,opt,name=signature" json:"signature,omitempty"`
+}
+
+func (m *ACAAttrResp) Reset()         { *m = ACAAttrResp{} }
+func (m *ACAAttrResp) String() string { return proto.CompactTextString(m) }
+func (*ACAAttrResp) ProtoMessage()    {}
+
+func (m *ACAAttrResp) GetCert() *Cert {
+	if m != nil {
+		return m.Cert
+	}
+	return nil
+}
+
+func (m *ACAAttrResp) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// ACAFetchAttrReq is a request to the Attribute Certificate Authority (ACA) to refresh attributes values from the sources.
+type ACAFetchAttrReq struct {
+	// Request timestamp
+	Ts *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=ts" json:"ts,omitempty"`
+	// Enrollment certificate.
+	ECert *Cert `protobuf:"bytes,2,opt,name=eCert" json:"eCert,omitempty"`
+	// The request is signed by the ECA.
+	Signature *Signature `protobuf:"bytes,3,opt,name=signature" json:"signature,omitempty"`
+	// Attributes to set before the refresh, overriding the sources, or to remove if without value.
+	Attributes []*ACAAttribute `protobuf:"bytes,4,rep,name=attributes" json:"attributes,omitempty"`
+}
+
+func (m *ACAFetchAttrReq) Reset()         { *m = ACAFetchAttrReq{} }
+func (m *ACAFetchAttrReq) String() string { return proto.CompactTextString(m) }
+func (*ACAFetchAttrReq) ProtoMessage()    {}
+
+func (m *ACAFetchAttrReq) GetTs() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Ts
+	}
+	return nil
+}
+
+func (m *ACAFetchAttrReq) GetECert() *Cert {
+	if m != nil {
+		return m.ECert
+	}
+	return nil
+}
+
+func (m *ACAFetchAttrReq) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *ACAFetchAttrReq) GetAttributes() []*ACAAttribute {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+// ACAFetchAttrReq is the answer of the Attribute Certificate Authority (ACA) to the refresh request.
+type ACAFetchAttrResp struct {
 	// Status of the fetch process.
 	Status ACAFetchAttrResp_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.ACAFetchAttrResp_StatusCode" json:"status,omitempty"`
 	// Error message.
 	Msg string `protobuf:"bytes,2,opt,name=Msg" json:"Msg,omitempty"`
 }
 
-func (m *ACAFetchAttrResp) Reset()         { *m = ACAFetchAttrResp{} }
-func (m *ACAFetchAttrResp) String() string { return proto.CompactTextString(m) }
-func (*ACAFetchAttrResp) ProtoMessage()    {}
+func (m *ACAFetchAttrResp) Reset()         { *m = ACAFetchAttrResp{} }
+func (m *ACAFetchAttrResp) String() string { return proto.CompactTextString(m) }
+func (*ACAFetchAttrResp) ProtoMessage()    {}
+
+// FetchAttrsResult is returned within the ECertCreateResp indicating the results of the fetch attributes invoked during enroll.
+type FetchAttrsResult struct {
+	// Status of the fetch process.
+	Status FetchAttrsResult_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.FetchAttrsResult_StatusCode" json:"status,omitempty"`
+	// Error message.
+	Msg string `protobuf:"bytes,2,opt,name=Msg" json:"Msg,omitempty"`
+}
+
+func (m *FetchAttrsResult) Reset()         { *m = FetchAttrsResult{} }
+func (m *FetchAttrsResult) String() string { return proto.CompactTextString(m) }
+func (*FetchAttrsResult) ProtoMessage()    {}
+
+// ACAAttribute is an instance of an attribute with the time constraints. Is used to marshal attributes to be stored within the certificate extensions.
+type ACAAttribute struct {
+	// Name of the attribute.
+	AttributeName string `protobuf:"bytes,1,opt,name=attributeName" json:"attributeName,omitempty"`
+	// Value of the attribute.
+	AttributeValue []byte `protobuf:"bytes,2,opt,name=attributeValue,proto3" json:"attributeValue,omitempty"`
+	// The timestamp which attribute is valid from.
+	ValidFrom *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=validFrom" json:"validFrom,omitempty"`
+	// The timestamp which attribute is valid to.
+	ValidTo *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=validTo" json:"validTo,omitempty"`
+}
+
+func (m *ACAAttribute) Reset()         { *m = ACAAttribute{} }
+func (m *ACAAttribute) String() string { return proto.CompactTextString(m) }
+func (*ACAAttribute) ProtoMessage()    {}
+
+func (m *ACAAttribute) GetValidFrom() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.ValidFrom
+	}
+	return nil
+}
+
+func (m *ACAAttribute) GetValidTo() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.ValidTo
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("protos.CryptoType", CryptoType_name, CryptoType_value)
+	proto.RegisterEnum("protos.Role", Role_name, Role_value)
+	proto.RegisterEnum("protos.RevocationReason", RevocationReason_name, RevocationReason_value)
+	proto.RegisterEnum("protos.CertificateType", CertificateType_name, CertificateType_value)
+	proto.RegisterEnum("protos.CAStatus_StatusCode", CAStatus_StatusCode_name, CAStatus_StatusCode_value)
+	proto.RegisterEnum("protos.ACAAttrResp_StatusCode", ACAAttrResp_StatusCode_name, ACAAttrResp_StatusCode_value)
+	proto.RegisterEnum("protos.ACAFetchAttrResp_StatusCode", ACAFetchAttrResp_StatusCode_name, ACAFetchAttrResp_StatusCode_value)
+	proto.RegisterEnum("protos.FetchAttrsResult_StatusCode", FetchAttrsResult_StatusCode_name, FetchAttrsResult_StatusCode_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for ECAP service
+
+type ECAPClient interface {
+	ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error)
+	ReadCACertificateChain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CertChain, error)
+	CreateCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
+	RenewCertificatePair(ctx context.Context, in *ECertRenewReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
+	CreateCertificatePairFromCSR(ctx context.Context, in *ECertCSRReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
+	ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error)
+	ReadCertificateByHash(ctx context.Context, in *Hash, opts ...grpc.CallOption) (*Cert, error)
+	RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error)
+	WatchRevocations(ctx context.Context, in *Empty, opts ...grpc.CallOption) (ECAP_WatchRevocationsClient, error)
+}
+
+type eCAPClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewECAPClient(cc *grpc.ClientConn) ECAPClient {
+	return &eCAPClient{cc}
+}
+
+func (c *eCAPClient) ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error) {
+	out := new(Cert)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCACertificate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) ReadCACertificateChain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CertChain, error) {
+	out := new(CertChain)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCACertificateChain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) CreateCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
+	out := new(ECertCreateResp)
+	err := grpc.Invoke(ctx, "/protos.ECAP/CreateCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) RenewCertificatePair(ctx context.Context, in *ECertRenewReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
+	out := new(ECertCreateResp)
+	err := grpc.Invoke(ctx, "/protos.ECAP/RenewCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) CreateCertificatePairFromCSR(ctx context.Context, in *ECertCSRReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
+	out := new(ECertCreateResp)
+	err := grpc.Invoke(ctx, "/protos.ECAP/CreateCertificatePairFromCSR", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error) {
+	out := new(CertPair)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) ReadCertificateByHash(ctx context.Context, in *Hash, opts ...grpc.CallOption) (*Cert, error) {
+	out := new(Cert)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCertificateByHash", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAP/RevokeCertificatePair", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error) {
+	out := new(CRL)
+	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCRL", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eCAPClient) WatchRevocations(ctx context.Context, in *Empty, opts ...grpc.CallOption) (ECAP_WatchRevocationsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_ECAP_serviceDesc.Streams[0], c.cc, "/protos.ECAP/WatchRevocations", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eCAPWatchRevocationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ECAP_WatchRevocationsClient interface {
+	Recv() (*RevocationEvent, error)
+	grpc.ClientStream
+}
+
+type eCAPWatchRevocationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *eCAPWatchRevocationsClient) Recv() (*RevocationEvent, error) {
+	m := new(RevocationEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for ECAP service
+
+type ECAPServer interface {
+	ReadCACertificate(context.Context, *Empty) (*Cert, error)
+	ReadCACertificateChain(context.Context, *Empty) (*CertChain, error)
+	CreateCertificatePair(context.Context, *ECertCreateReq) (*ECertCreateResp, error)
+	RenewCertificatePair(context.Context, *ECertRenewReq) (*ECertCreateResp, error)
+	CreateCertificatePairFromCSR(context.Context, *ECertCSRReq) (*ECertCreateResp, error)
+	ReadCertificatePair(context.Context, *ECertReadReq) (*CertPair, error)
+	ReadCertificateByHash(context.Context, *Hash) (*Cert, error)
+	RevokeCertificatePair(context.Context, *ECertRevokeReq) (*CAStatus, error)
+	ReadCRL(context.Context, *Empty) (*CRL, error)
+	WatchRevocations(*Empty, ECAP_WatchRevocationsServer) error
+}
+
+func RegisterECAPServer(s *grpc.Server, srv ECAPServer) {
+	s.RegisterService(&_ECAP_serviceDesc, srv)
+}
+
+func _ECAP_ReadCACertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReadCACertificate(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_ReadCACertificateChain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReadCACertificateChain(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_CreateCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertCreateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).CreateCertificatePair(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_RenewCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertRenewReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).RenewCertificatePair(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_CreateCertificatePairFromCSR_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertCSRReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).CreateCertificatePairFromCSR(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ECAP_ReadCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertReadReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReadCertificatePair(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-// FetchAttrsResult is returned within the ECertCreateResp indicating the results of the fetch attributes invoked during enroll.
-type FetchAttrsResult struct {
-	// Status of the fetch process.
-	Status FetchAttrsResult_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.FetchAttrsResult_StatusCode" json:"status,omitempty"`
-	// Error message.
-	Msg string `protobuf:"bytes,2,opt,name=Msg" json:"Msg,omitempty"`
+func _ECAP_ReadCertificateByHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Hash)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReadCertificateByHash(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (m *FetchAttrsResult) Reset()         { *m = FetchAttrsResult{} }
-func (m *FetchAttrsResult) String() string { return proto.CompactTextString(m) }
-func (*FetchAttrsResult) ProtoMessage()    {}
+func _ECAP_RevokeCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertRevokeReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).RevokeCertificatePair(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-// ACAAttribute is an instance of an attribute with the time constraints. Is used to marshal attributes to be stored within the certificate extensions.
-type ACAAttribute struct {
-	// Name of the attribute.
-	AttributeName string `protobuf:"bytes,1,opt,name=attributeName" json:"attributeName,omitempty"`
-	// Value of the attribute.
-	AttributeValue []byte `protobuf:"bytes,2,opt,name=attributeValue,proto3" json:"attributeValue,omitempty"`
-	// The timestamp which attribute is valid from.
-	ValidFrom *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=validFrom" json:"validFrom,omitempty"`
-	// The timestamp which attribute is valid to.
-	ValidTo *google_protobuf.Timestamp `protobuf:"bytes,4,opt,name=validTo" json:"validTo,omitempty"`
+func _ECAP_ReadCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAPServer).ReadCRL(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (m *ACAAttribute) Reset()         { *m = ACAAttribute{} }
-func (m *ACAAttribute) String() string { return proto.CompactTextString(m) }
-func (*ACAAttribute) ProtoMessage()    {}
+func _ECAP_WatchRevocations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ECAPServer).WatchRevocations(m, &eCAPWatchRevocationsServer{stream})
+}
 
-func (m *ACAAttribute) GetValidFrom() *google_protobuf.Timestamp {
-	if m != nil {
-		return m.ValidFrom
+type ECAP_WatchRevocationsServer interface {
+	Send(*RevocationEvent) error
+	grpc.ServerStream
+}
+
+type eCAPWatchRevocationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *eCAPWatchRevocationsServer) Send(m *RevocationEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _ECAP_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.ECAP",
+	HandlerType: (*ECAPServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReadCACertificate",
+			Handler:    _ECAP_ReadCACertificate_Handler,
+		},
+		{
+			MethodName: "ReadCACertificateChain",
+			Handler:    _ECAP_ReadCACertificateChain_Handler,
+		},
+		{
+			MethodName: "CreateCertificatePair",
+			Handler:    _ECAP_CreateCertificatePair_Handler,
+		},
+		{
+			MethodName: "RenewCertificatePair",
+			Handler:    _ECAP_RenewCertificatePair_Handler,
+		},
+		{
+			MethodName: "CreateCertificatePairFromCSR",
+			Handler:    _ECAP_CreateCertificatePairFromCSR_Handler,
+		},
+		{
+			MethodName: "ReadCertificatePair",
+			Handler:    _ECAP_ReadCertificatePair_Handler,
+		},
+		{
+			MethodName: "ReadCertificateByHash",
+			Handler:    _ECAP_ReadCertificateByHash_Handler,
+		},
+		{
+			MethodName: "RevokeCertificatePair",
+			Handler:    _ECAP_RevokeCertificatePair_Handler,
+		},
+		{
+			MethodName: "ReadCRL",
+			Handler:    _ECAP_ReadCRL_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRevocations",
+			Handler:       _ECAP_WatchRevocations_Handler,
+			ServerStreams: true,
+		},
+	},
+}
+
+// Client API for ECAA service
+
+type ECAAClient interface {
+	RegisterUser(ctx context.Context, in *RegisterUserReq, opts ...grpc.CallOption) (*Token, error)
+	RegisterUsers(ctx context.Context, in *RegisterUsersReq, opts ...grpc.CallOption) (*RegisterUsersResp, error)
+	ReadUserSet(ctx context.Context, in *ReadUserSetReq, opts ...grpc.CallOption) (*UserSet, error)
+	RevokeCertificate(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...grpc.CallOption) (*CAStatus, error)
+	RevokeIdentity(ctx context.Context, in *IdentityRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	CreateAffiliation(ctx context.Context, in *AffiliationCreateReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadAffiliations(ctx context.Context, in *AffiliationReadReq, opts ...grpc.CallOption) (*AffiliationSet, error)
+	UpdateAffiliation(ctx context.Context, in *AffiliationUpdateReq, opts ...grpc.CallOption) (*CAStatus, error)
+	DeleteAffiliation(ctx context.Context, in *AffiliationDeleteReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadIssuedCertificates(ctx context.Context, in *IssuedCertificatesReq, opts ...grpc.CallOption) (*IssuedCertificateSet, error)
+	ResetSecret(ctx context.Context, in *SecretResetReq, opts ...grpc.CallOption) (*Token, error)
+	RefreshAttributes(ctx context.Context, in *AttributesRefreshReq, opts ...grpc.CallOption) (*CAStatus, error)
+	UpdateAttributes(ctx context.Context, in *AttributesUpdateReq, opts ...grpc.CallOption) (*CAStatus, error)
+}
+
+type eCAAClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewECAAClient(cc *grpc.ClientConn) ECAAClient {
+	return &eCAAClient{cc}
+}
+
+func (c *eCAAClient) RegisterUser(ctx context.Context, in *RegisterUserReq, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RegisterUser", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return out, nil
 }
 
-func (m *ACAAttribute) GetValidTo() *google_protobuf.Timestamp {
-	if m != nil {
-		return m.ValidTo
+func (c *eCAAClient) RegisterUsers(ctx context.Context, in *RegisterUsersReq, opts ...grpc.CallOption) (*RegisterUsersResp, error) {
+	out := new(RegisterUsersResp)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RegisterUsers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return out, nil
 }
 
-func init() {
-	proto.RegisterEnum("protos.CryptoType", CryptoType_name, CryptoType_value)
-	proto.RegisterEnum("protos.Role", Role_name, Role_value)
-	proto.RegisterEnum("protos.CAStatus_StatusCode", CAStatus_StatusCode_name, CAStatus_StatusCode_value)
-	proto.RegisterEnum("protos.ACAAttrResp_StatusCode", ACAAttrResp_StatusCode_name, ACAAttrResp_StatusCode_value)
-	proto.RegisterEnum("protos.ACAFetchAttrResp_StatusCode", ACAFetchAttrResp_StatusCode_name, ACAFetchAttrResp_StatusCode_value)
-	proto.RegisterEnum("protos.FetchAttrsResult_StatusCode", FetchAttrsResult_StatusCode_name, FetchAttrsResult_StatusCode_value)
+func (c *eCAAClient) ReadUserSet(ctx context.Context, in *ReadUserSetReq, opts ...grpc.CallOption) (*UserSet, error) {
+	out := new(UserSet)
+	err := grpc.Invoke(ctx, "/protos.ECAA/ReadUserSet", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
+func (c *eCAAClient) RevokeCertificate(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RevokeCertificate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-// Client API for ECAP service
+func (c *eCAAClient) PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/PublishCRL", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-type ECAPClient interface {
-	ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error)
-	CreateCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error)
-	ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error)
-	ReadCertificateByHash(ctx context.Context, in *Hash, opts ...grpc.CallOption) (*Cert, error)
-	RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+func (c *eCAAClient) RevokeIdentity(ctx context.Context, in *IdentityRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RevokeIdentity", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-type eCAPClient struct {
-	cc *grpc.ClientConn
+func (c *eCAAClient) CreateAffiliation(ctx context.Context, in *AffiliationCreateReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/CreateAffiliation", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func NewECAPClient(cc *grpc.ClientConn) ECAPClient {
-	return &eCAPClient{cc}
+func (c *eCAAClient) ReadAffiliations(ctx context.Context, in *AffiliationReadReq, opts ...grpc.CallOption) (*AffiliationSet, error) {
+	out := new(AffiliationSet)
+	err := grpc.Invoke(ctx, "/protos.ECAA/ReadAffiliations", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (c *eCAPClient) ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error) {
-	out := new(Cert)
-	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCACertificate", in, out, c.cc, opts...)
+func (c *eCAAClient) UpdateAffiliation(ctx context.Context, in *AffiliationUpdateReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/UpdateAffiliation", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAPClient) CreateCertificatePair(ctx context.Context, in *ECertCreateReq, opts ...grpc.CallOption) (*ECertCreateResp, error) {
-	out := new(ECertCreateResp)
-	err := grpc.Invoke(ctx, "/protos.ECAP/CreateCertificatePair", in, out, c.cc, opts...)
+func (c *eCAAClient) DeleteAffiliation(ctx context.Context, in *AffiliationDeleteReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/DeleteAffiliation", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAPClient) ReadCertificatePair(ctx context.Context, in *ECertReadReq, opts ...grpc.CallOption) (*CertPair, error) {
-	out := new(CertPair)
-	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCertificatePair", in, out, c.cc, opts...)
+func (c *eCAAClient) ReadIssuedCertificates(ctx context.Context, in *IssuedCertificatesReq, opts ...grpc.CallOption) (*IssuedCertificateSet, error) {
+	out := new(IssuedCertificateSet)
+	err := grpc.Invoke(ctx, "/protos.ECAA/ReadIssuedCertificates", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAPClient) ReadCertificateByHash(ctx context.Context, in *Hash, opts ...grpc.CallOption) (*Cert, error) {
-	out := new(Cert)
-	err := grpc.Invoke(ctx, "/protos.ECAP/ReadCertificateByHash", in, out, c.cc, opts...)
+func (c *eCAAClient) ResetSecret(ctx context.Context, in *SecretResetReq, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := grpc.Invoke(ctx, "/protos.ECAA/ResetSecret", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAPClient) RevokeCertificatePair(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
+func (c *eCAAClient) RefreshAttributes(ctx context.Context, in *AttributesRefreshReq, opts ...grpc.CallOption) (*CAStatus, error) {
 	out := new(CAStatus)
-	err := grpc.Invoke(ctx, "/protos.ECAP/RevokeCertificatePair", in, out, c.cc, opts...)
+	err := grpc.Invoke(ctx, "/protos.ECAA/RefreshAttributes", in, out, c.cc, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-// Server API for ECAP service
+func (c *eCAAClient) UpdateAttributes(ctx context.Context, in *AttributesUpdateReq, opts ...grpc.CallOption) (*CAStatus, error) {
+	out := new(CAStatus)
+	err := grpc.Invoke(ctx, "/protos.ECAA/UpdateAttributes", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
-type ECAPServer interface {
-	ReadCACertificate(context.Context, *Empty) (*Cert, error)
-	CreateCertificatePair(context.Context, *ECertCreateReq) (*ECertCreateResp, error)
-	ReadCertificatePair(context.Context, *ECertReadReq) (*CertPair, error)
-	ReadCertificateByHash(context.Context, *Hash) (*Cert, error)
-	RevokeCertificatePair(context.Context, *ECertRevokeReq) (*CAStatus, error)
+// Server API for ECAA service
+
+type ECAAServer interface {
+	RegisterUser(context.Context, *RegisterUserReq) (*Token, error)
+	RegisterUsers(context.Context, *RegisterUsersReq) (*RegisterUsersResp, error)
+	ReadUserSet(context.Context, *ReadUserSetReq) (*UserSet, error)
+	RevokeCertificate(context.Context, *ECertRevokeReq) (*CAStatus, error)
+	PublishCRL(context.Context, *ECertCRLReq) (*CAStatus, error)
+	RevokeIdentity(context.Context, *IdentityRevokeReq) (*CAStatus, error)
+	CreateAffiliation(context.Context, *AffiliationCreateReq) (*CAStatus, error)
+	ReadAffiliations(context.Context, *AffiliationReadReq) (*AffiliationSet, error)
+	UpdateAffiliation(context.Context, *AffiliationUpdateReq) (*CAStatus, error)
+	DeleteAffiliation(context.Context, *AffiliationDeleteReq) (*CAStatus, error)
+	ReadIssuedCertificates(context.Context, *IssuedCertificatesReq) (*IssuedCertificateSet, error)
+	ResetSecret(context.Context, *SecretResetReq) (*Token, error)
+	RefreshAttributes(context.Context, *AttributesRefreshReq) (*CAStatus, error)
+	UpdateAttributes(context.Context, *AttributesUpdateReq) (*CAStatus, error)
 }
 
-func RegisterECAPServer(s *grpc.Server, srv ECAPServer) {
-	s.RegisterService(&_ECAP_serviceDesc, srv)
+func RegisterECAAServer(s *grpc.Server, srv ECAAServer) {
+	s.RegisterService(&_ECAA_serviceDesc, srv)
+}
+
+func _ECAA_RegisterUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(RegisterUserReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).RegisterUser(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func _ECAP_ReadCACertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(Empty)
+func _ECAA_RegisterUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(RegisterUsersReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAPServer).ReadCACertificate(ctx, in)
+	out, err := srv.(ECAAServer).RegisterUsers(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAP_CreateCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(ECertCreateReq)
+func _ECAA_ReadUserSet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ReadUserSetReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAPServer).CreateCertificatePair(ctx, in)
+	out, err := srv.(ECAAServer).ReadUserSet(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAP_ReadCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(ECertReadReq)
+func _ECAA_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertRevokeReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAPServer).ReadCertificatePair(ctx, in)
+	out, err := srv.(ECAAServer).RevokeCertificate(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAP_ReadCertificateByHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(Hash)
+func _ECAA_PublishCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ECertCRLReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAPServer).ReadCertificateByHash(ctx, in)
+	out, err := srv.(ECAAServer).PublishCRL(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAP_RevokeCertificatePair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(ECertRevokeReq)
+func _ECAA_RevokeIdentity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(IdentityRevokeReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAPServer).RevokeCertificatePair(ctx, in)
+	out, err := srv.(ECAAServer).RevokeIdentity(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-var _ECAP_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "protos.ECAP",
-	HandlerType: (*ECAPServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "ReadCACertificate",
-			Handler:    _ECAP_ReadCACertificate_Handler,
-		},
-		{
-			MethodName: "CreateCertificatePair",
-			Handler:    _ECAP_CreateCertificatePair_Handler,
-		},
-		{
-			MethodName: "ReadCertificatePair",
-			Handler:    _ECAP_ReadCertificatePair_Handler,
-		},
-		{
-			MethodName: "ReadCertificateByHash",
-			Handler:    _ECAP_ReadCertificateByHash_Handler,
-		},
-		{
-			MethodName: "RevokeCertificatePair",
-			Handler:    _ECAP_RevokeCertificatePair_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{},
-}
-
-// Client API for ECAA service
-
-type ECAAClient interface {
-	RegisterUser(ctx context.Context, in *RegisterUserReq, opts ...grpc.CallOption) (*Token, error)
-	ReadUserSet(ctx context.Context, in *ReadUserSetReq, opts ...grpc.CallOption) (*UserSet, error)
-	RevokeCertificate(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
-	PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...grpc.CallOption) (*CAStatus, error)
-}
-
-type eCAAClient struct {
-	cc *grpc.ClientConn
-}
-
-func NewECAAClient(cc *grpc.ClientConn) ECAAClient {
-	return &eCAAClient{cc}
-}
-
-func (c *eCAAClient) RegisterUser(ctx context.Context, in *RegisterUserReq, opts ...grpc.CallOption) (*Token, error) {
-	out := new(Token)
-	err := grpc.Invoke(ctx, "/protos.ECAA/RegisterUser", in, out, c.cc, opts...)
+func _ECAA_CreateAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AffiliationCreateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).CreateAffiliation(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAAClient) ReadUserSet(ctx context.Context, in *ReadUserSetReq, opts ...grpc.CallOption) (*UserSet, error) {
-	out := new(UserSet)
-	err := grpc.Invoke(ctx, "/protos.ECAA/ReadUserSet", in, out, c.cc, opts...)
+func _ECAA_ReadAffiliations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AffiliationReadReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).ReadAffiliations(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAAClient) RevokeCertificate(ctx context.Context, in *ECertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
-	out := new(CAStatus)
-	err := grpc.Invoke(ctx, "/protos.ECAA/RevokeCertificate", in, out, c.cc, opts...)
+func _ECAA_UpdateAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AffiliationUpdateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).UpdateAffiliation(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eCAAClient) PublishCRL(ctx context.Context, in *ECertCRLReq, opts ...grpc.CallOption) (*CAStatus, error) {
-	out := new(CAStatus)
-	err := grpc.Invoke(ctx, "/protos.ECAA/PublishCRL", in, out, c.cc, opts...)
+func _ECAA_DeleteAffiliation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AffiliationDeleteReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ECAAServer).DeleteAffiliation(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-// Server API for ECAA service
-
-type ECAAServer interface {
-	RegisterUser(context.Context, *RegisterUserReq) (*Token, error)
-	ReadUserSet(context.Context, *ReadUserSetReq) (*UserSet, error)
-	RevokeCertificate(context.Context, *ECertRevokeReq) (*CAStatus, error)
-	PublishCRL(context.Context, *ECertCRLReq) (*CAStatus, error)
-}
-
-func RegisterECAAServer(s *grpc.Server, srv ECAAServer) {
-	s.RegisterService(&_ECAA_serviceDesc, srv)
-}
-
-func _ECAA_RegisterUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(RegisterUserReq)
+func _ECAA_ReadIssuedCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(IssuedCertificatesReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAAServer).RegisterUser(ctx, in)
+	out, err := srv.(ECAAServer).ReadIssuedCertificates(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAA_ReadUserSet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(ReadUserSetReq)
+func _ECAA_ResetSecret_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(SecretResetReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAAServer).ReadUserSet(ctx, in)
+	out, err := srv.(ECAAServer).ResetSecret(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAA_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(ECertRevokeReq)
+func _ECAA_RefreshAttributes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AttributesRefreshReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAAServer).RevokeCertificate(ctx, in)
+	out, err := srv.(ECAAServer).RefreshAttributes(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func _ECAA_PublishCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
-	in := new(ECertCRLReq)
+func _ECAA_UpdateAttributes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AttributesUpdateReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
-	out, err := srv.(ECAAServer).PublishCRL(ctx, in)
+	out, err := srv.(ECAAServer).UpdateAttributes(ctx, in)
 	if err != nil {
 		return nil, err
 	}
@@ -1487,6 +2604,10 @@ var _ECAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterUser",
 			Handler:    _ECAA_RegisterUser_Handler,
 		},
+		{
+			MethodName: "RegisterUsers",
+			Handler:    _ECAA_RegisterUsers_Handler,
+		},
 		{
 			MethodName: "ReadUserSet",
 			Handler:    _ECAA_ReadUserSet_Handler,
@@ -1499,6 +2620,42 @@ var _ECAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "PublishCRL",
 			Handler:    _ECAA_PublishCRL_Handler,
 		},
+		{
+			MethodName: "RevokeIdentity",
+			Handler:    _ECAA_RevokeIdentity_Handler,
+		},
+		{
+			MethodName: "CreateAffiliation",
+			Handler:    _ECAA_CreateAffiliation_Handler,
+		},
+		{
+			MethodName: "ReadAffiliations",
+			Handler:    _ECAA_ReadAffiliations_Handler,
+		},
+		{
+			MethodName: "UpdateAffiliation",
+			Handler:    _ECAA_UpdateAffiliation_Handler,
+		},
+		{
+			MethodName: "DeleteAffiliation",
+			Handler:    _ECAA_DeleteAffiliation_Handler,
+		},
+		{
+			MethodName: "ReadIssuedCertificates",
+			Handler:    _ECAA_ReadIssuedCertificates_Handler,
+		},
+		{
+			MethodName: "ResetSecret",
+			Handler:    _ECAA_ResetSecret_Handler,
+		},
+		{
+			MethodName: "RefreshAttributes",
+			Handler:    _ECAA_RefreshAttributes_Handler,
+		},
+		{
+			MethodName: "UpdateAttributes",
+			Handler:    _ECAA_UpdateAttributes_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -1507,9 +2664,12 @@ var _ECAA_serviceDesc = grpc.ServiceDesc{
 
 type TCAPClient interface {
 	ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error)
+	ReadCACertificateChain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CertChain, error)
 	CreateCertificateSet(ctx context.Context, in *TCertCreateSetReq, opts ...grpc.CallOption) (*TCertCreateSetResp, error)
+	CreateCertificateSetStream(ctx context.Context, in *TCertCreateSetReq, opts ...grpc.CallOption) (TCAP_CreateCertificateSetStreamClient, error)
 	RevokeCertificate(ctx context.Context, in *TCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
 	RevokeCertificateSet(ctx context.Context, in *TCertRevokeSetReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error)
 }
 
 type tCAPClient struct {
@@ -1529,6 +2689,15 @@ func (c *tCAPClient) ReadCACertificate(ctx context.Context, in *Empty, opts ...g
 	return out, nil
 }
 
+func (c *tCAPClient) ReadCACertificateChain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CertChain, error) {
+	out := new(CertChain)
+	err := grpc.Invoke(ctx, "/protos.TCAP/ReadCACertificateChain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *tCAPClient) CreateCertificateSet(ctx context.Context, in *TCertCreateSetReq, opts ...grpc.CallOption) (*TCertCreateSetResp, error) {
 	out := new(TCertCreateSetResp)
 	err := grpc.Invoke(ctx, "/protos.TCAP/CreateCertificateSet", in, out, c.cc, opts...)
@@ -1538,6 +2707,38 @@ func (c *tCAPClient) CreateCertificateSet(ctx context.Context, in *TCertCreateSe
 	return out, nil
 }
 
+func (c *tCAPClient) CreateCertificateSetStream(ctx context.Context, in *TCertCreateSetReq, opts ...grpc.CallOption) (TCAP_CreateCertificateSetStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_TCAP_serviceDesc.Streams[0], c.cc, "/protos.TCAP/CreateCertificateSetStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tCAPCreateCertificateSetStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TCAP_CreateCertificateSetStreamClient interface {
+	Recv() (*TCertCreateSetResp, error)
+	grpc.ClientStream
+}
+
+type tCAPCreateCertificateSetStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *tCAPCreateCertificateSetStreamClient) Recv() (*TCertCreateSetResp, error) {
+	m := new(TCertCreateSetResp)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *tCAPClient) RevokeCertificate(ctx context.Context, in *TCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error) {
 	out := new(CAStatus)
 	err := grpc.Invoke(ctx, "/protos.TCAP/RevokeCertificate", in, out, c.cc, opts...)
@@ -1556,13 +2757,25 @@ func (c *tCAPClient) RevokeCertificateSet(ctx context.Context, in *TCertRevokeSe
 	return out, nil
 }
 
+func (c *tCAPClient) ReadCRL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CRL, error) {
+	out := new(CRL)
+	err := grpc.Invoke(ctx, "/protos.TCAP/ReadCRL", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for TCAP service
 
 type TCAPServer interface {
 	ReadCACertificate(context.Context, *Empty) (*Cert, error)
+	ReadCACertificateChain(context.Context, *Empty) (*CertChain, error)
 	CreateCertificateSet(context.Context, *TCertCreateSetReq) (*TCertCreateSetResp, error)
+	CreateCertificateSetStream(*TCertCreateSetReq, TCAP_CreateCertificateSetStreamServer) error
 	RevokeCertificate(context.Context, *TCertRevokeReq) (*CAStatus, error)
 	RevokeCertificateSet(context.Context, *TCertRevokeSetReq) (*CAStatus, error)
+	ReadCRL(context.Context, *Empty) (*CRL, error)
 }
 
 func RegisterTCAPServer(s *grpc.Server, srv TCAPServer) {
@@ -1581,6 +2794,18 @@ func _TCAP_ReadCACertificate_Handler(srv interface{}, ctx context.Context, dec f
 	return out, nil
 }
 
+func _TCAP_ReadCACertificateChain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TCAPServer).ReadCACertificateChain(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _TCAP_CreateCertificateSet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(TCertCreateSetReq)
 	if err := dec(in); err != nil {
@@ -1593,6 +2818,27 @@ func _TCAP_CreateCertificateSet_Handler(srv interface{}, ctx context.Context, de
 	return out, nil
 }
 
+func _TCAP_CreateCertificateSetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TCertCreateSetReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TCAPServer).CreateCertificateSetStream(m, &tCAPCreateCertificateSetStreamServer{stream})
+}
+
+type TCAP_CreateCertificateSetStreamServer interface {
+	Send(*TCertCreateSetResp) error
+	grpc.ServerStream
+}
+
+type tCAPCreateCertificateSetStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *tCAPCreateCertificateSetStreamServer) Send(m *TCertCreateSetResp) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _TCAP_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(TCertRevokeReq)
 	if err := dec(in); err != nil {
@@ -1617,6 +2863,18 @@ func _TCAP_RevokeCertificateSet_Handler(srv interface{}, ctx context.Context, de
 	return out, nil
 }
 
+func _TCAP_ReadCRL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TCAPServer).ReadCRL(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _TCAP_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TCAP",
 	HandlerType: (*TCAPServer)(nil),
@@ -1625,6 +2883,10 @@ var _TCAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ReadCACertificate",
 			Handler:    _TCAP_ReadCACertificate_Handler,
 		},
+		{
+			MethodName: "ReadCACertificateChain",
+			Handler:    _TCAP_ReadCACertificateChain_Handler,
+		},
 		{
 			MethodName: "CreateCertificateSet",
 			Handler:    _TCAP_CreateCertificateSet_Handler,
@@ -1637,8 +2899,18 @@ var _TCAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificateSet",
 			Handler:    _TCAP_RevokeCertificateSet_Handler,
 		},
+		{
+			MethodName: "ReadCRL",
+			Handler:    _TCAP_ReadCRL_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CreateCertificateSetStream",
+			Handler:       _TCAP_CreateCertificateSetStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams: []grpc.StreamDesc{},
 }
 
 // Client API for TCAA service
@@ -1647,6 +2919,7 @@ type TCAAClient interface {
 	RevokeCertificate(ctx context.Context, in *TCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
 	RevokeCertificateSet(ctx context.Context, in *TCertRevokeSetReq, opts ...grpc.CallOption) (*CAStatus, error)
 	PublishCRL(ctx context.Context, in *TCertCRLReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadIssuedCertificates(ctx context.Context, in *IssuedCertificatesReq, opts ...grpc.CallOption) (*IssuedCertificateSet, error)
 }
 
 type tCAAClient struct {
@@ -1684,12 +2957,22 @@ func (c *tCAAClient) PublishCRL(ctx context.Context, in *TCertCRLReq, opts ...gr
 	return out, nil
 }
 
+func (c *tCAAClient) ReadIssuedCertificates(ctx context.Context, in *IssuedCertificatesReq, opts ...grpc.CallOption) (*IssuedCertificateSet, error) {
+	out := new(IssuedCertificateSet)
+	err := grpc.Invoke(ctx, "/protos.TCAA/ReadIssuedCertificates", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for TCAA service
 
 type TCAAServer interface {
 	RevokeCertificate(context.Context, *TCertRevokeReq) (*CAStatus, error)
 	RevokeCertificateSet(context.Context, *TCertRevokeSetReq) (*CAStatus, error)
 	PublishCRL(context.Context, *TCertCRLReq) (*CAStatus, error)
+	ReadIssuedCertificates(context.Context, *IssuedCertificatesReq) (*IssuedCertificateSet, error)
 }
 
 func RegisterTCAAServer(s *grpc.Server, srv TCAAServer) {
@@ -1732,6 +3015,18 @@ func _TCAA_PublishCRL_Handler(srv interface{}, ctx context.Context, dec func(int
 	return out, nil
 }
 
+func _TCAA_ReadIssuedCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(IssuedCertificatesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TCAAServer).ReadIssuedCertificates(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _TCAA_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TCAA",
 	HandlerType: (*TCAAServer)(nil),
@@ -1748,6 +3043,10 @@ var _TCAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "PublishCRL",
 			Handler:    _TCAA_PublishCRL_Handler,
 		},
+		{
+			MethodName: "ReadIssuedCertificates",
+			Handler:    _TCAA_ReadIssuedCertificates_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -1756,6 +3055,7 @@ var _TCAA_serviceDesc = grpc.ServiceDesc{
 
 type TLSCAPClient interface {
 	ReadCACertificate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Cert, error)
+	ReadCACertificateChain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CertChain, error)
 	CreateCertificate(ctx context.Context, in *TLSCertCreateReq, opts ...grpc.CallOption) (*TLSCertCreateResp, error)
 	ReadCertificate(ctx context.Context, in *TLSCertReadReq, opts ...grpc.CallOption) (*Cert, error)
 	RevokeCertificate(ctx context.Context, in *TLSCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
@@ -1778,6 +3078,15 @@ func (c *tLSCAPClient) ReadCACertificate(ctx context.Context, in *Empty, opts ..
 	return out, nil
 }
 
+func (c *tLSCAPClient) ReadCACertificateChain(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CertChain, error) {
+	out := new(CertChain)
+	err := grpc.Invoke(ctx, "/protos.TLSCAP/ReadCACertificateChain", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *tLSCAPClient) CreateCertificate(ctx context.Context, in *TLSCertCreateReq, opts ...grpc.CallOption) (*TLSCertCreateResp, error) {
 	out := new(TLSCertCreateResp)
 	err := grpc.Invoke(ctx, "/protos.TLSCAP/CreateCertificate", in, out, c.cc, opts...)
@@ -1809,6 +3118,7 @@ func (c *tLSCAPClient) RevokeCertificate(ctx context.Context, in *TLSCertRevokeR
 
 type TLSCAPServer interface {
 	ReadCACertificate(context.Context, *Empty) (*Cert, error)
+	ReadCACertificateChain(context.Context, *Empty) (*CertChain, error)
 	CreateCertificate(context.Context, *TLSCertCreateReq) (*TLSCertCreateResp, error)
 	ReadCertificate(context.Context, *TLSCertReadReq) (*Cert, error)
 	RevokeCertificate(context.Context, *TLSCertRevokeReq) (*CAStatus, error)
@@ -1830,6 +3140,18 @@ func _TLSCAP_ReadCACertificate_Handler(srv interface{}, ctx context.Context, dec
 	return out, nil
 }
 
+func _TLSCAP_ReadCACertificateChain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TLSCAPServer).ReadCACertificateChain(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _TLSCAP_CreateCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(TLSCertCreateReq)
 	if err := dec(in); err != nil {
@@ -1874,6 +3196,10 @@ var _TLSCAP_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ReadCACertificate",
 			Handler:    _TLSCAP_ReadCACertificate_Handler,
 		},
+		{
+			MethodName: "ReadCACertificateChain",
+			Handler:    _TLSCAP_ReadCACertificateChain_Handler,
+		},
 		{
 			MethodName: "CreateCertificate",
 			Handler:    _TLSCAP_CreateCertificate_Handler,
@@ -1894,6 +3220,7 @@ var _TLSCAP_serviceDesc = grpc.ServiceDesc{
 
 type TLSCAAClient interface {
 	RevokeCertificate(ctx context.Context, in *TLSCertRevokeReq, opts ...grpc.CallOption) (*CAStatus, error)
+	ReadIssuedCertificates(ctx context.Context, in *IssuedCertificatesReq, opts ...grpc.CallOption) (*IssuedCertificateSet, error)
 }
 
 type tLSCAAClient struct {
@@ -1913,10 +3240,20 @@ func (c *tLSCAAClient) RevokeCertificate(ctx context.Context, in *TLSCertRevokeR
 	return out, nil
 }
 
+func (c *tLSCAAClient) ReadIssuedCertificates(ctx context.Context, in *IssuedCertificatesReq, opts ...grpc.CallOption) (*IssuedCertificateSet, error) {
+	out := new(IssuedCertificateSet)
+	err := grpc.Invoke(ctx, "/protos.TLSCAA/ReadIssuedCertificates", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for TLSCAA service
 
 type TLSCAAServer interface {
 	RevokeCertificate(context.Context, *TLSCertRevokeReq) (*CAStatus, error)
+	ReadIssuedCertificates(context.Context, *IssuedCertificatesReq) (*IssuedCertificateSet, error)
 }
 
 func RegisterTLSCAAServer(s *grpc.Server, srv TLSCAAServer) {
@@ -1935,6 +3272,18 @@ func _TLSCAA_RevokeCertificate_Handler(srv interface{}, ctx context.Context, dec
 	return out, nil
 }
 
+func _TLSCAA_ReadIssuedCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(IssuedCertificatesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(TLSCAAServer).ReadIssuedCertificates(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _TLSCAA_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.TLSCAA",
 	HandlerType: (*TLSCAAServer)(nil),
@@ -1943,6 +3292,10 @@ var _TLSCAA_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RevokeCertificate",
 			Handler:    _TLSCAA_RevokeCertificate_Handler,
 		},
+		{
+			MethodName: "ReadIssuedCertificates",
+			Handler:    _TLSCAA_ReadIssuedCertificates_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -29,6 +29,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/membersrvc/ca"
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -36,6 +37,16 @@ import (
 
 const envPrefix = "MEMBERSRVC_CA"
 
+// The main command serves the CAs, unless a subcommand is given.
+var mainCmd = &cobra.Command{
+	Use:   "membersrvc",
+	Short: "Membership services: the ECA, TCA, TLSCA and ACA.",
+	Long:  `Serves the enrollment, transaction, TLS and attribute certificate authorities.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		serve()
+	},
+}
+
 func main() {
 	viper.SetEnvPrefix(envPrefix)
 	viper.AutomaticEnv()
@@ -88,6 +99,15 @@ func main() {
 	}
 
 	ca.LogInit(iotrace, ioinfo, iowarning, ioerror, iopanic)
+
+	addAdminCommands(mainCmd)
+	addBackupCommands(mainCmd)
+	if err := mainCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func serve() {
 	ca.Info.Println("CA Server (" + viper.GetString("server.version") + ")")
 
 	aca := ca.NewACA()
@@ -119,6 +139,13 @@ func main() {
 	tca.Start(srv)
 	tlsca.Start(srv)
 
+	if viper.GetBool("server.rest.enabled") {
+		go ca.StartRESTServer(eca, tca, tlsca)
+	}
+	if viper.GetBool("server.metrics.enabled") {
+		go ca.StartMetricsServer(tca)
+	}
+
 	if sock, err := net.Listen("tcp", viper.GetString("server.port")); err != nil {
 		ca.Error.Println("Fail to start CA Server: ", err)
 		os.Exit(1)
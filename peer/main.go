@@ -18,6 +18,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -67,6 +69,7 @@ const fabric = "hyperledger"
 const nodeFuncName = "node"
 const networkFuncName = "network"
 const chainFuncName = "chaincode"
+const cryptoFuncName = "crypto"
 const cmdRoot = "core"
 const undefinedParamValue = ""
 
@@ -119,6 +122,137 @@ var nodeStopCmd = &cobra.Command{
 	},
 }
 
+// Keystore backup related variables.
+var (
+	keyStorePassphrase string
+)
+
+var nodeBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backs up the node's keystore.",
+	Long:  `Writes a snapshot of the node's enrollment keys and certificates to the file given as parameter. The node must not be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup(args)
+	},
+}
+
+var nodeRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restores the node's keystore.",
+	Long:  `Replaces the node's enrollment keys and certificates with the snapshot read from the file given as parameter. The node must not be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restore(args)
+	},
+}
+
+// Identity export related variables.
+var (
+	identityPassword string
+)
+
+var nodeExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the node's identity.",
+	Long:  `Writes the node's enrollment key, enrollment certificate and ECA certificates chain to the file given as parameter, as a password protected PKCS#12 bundle. The node must not be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return exportIdentity(args)
+	},
+}
+
+var nodeImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports the node's identity.",
+	Long:  `Replaces the node's enrollment key, enrollment certificate and ECA certificates chain with the PKCS#12 bundle read from the file given as parameter. The node must be registered and must not be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return importIdentity(args)
+	},
+}
+
+var cryptoCmd = &cobra.Command{
+	Use:   cryptoFuncName,
+	Short: fmt.Sprintf("%s specific commands.", cryptoFuncName),
+	Long:  fmt.Sprintf("%s specific commands.", cryptoFuncName),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		core.LoggingInit(cryptoFuncName)
+	},
+}
+
+// Keystore verification related variables.
+var (
+	keyStoreRepair bool
+)
+
+var cryptoVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies the node's keystore.",
+	Long:  `Checks that every certificate in the node's keystore parses, chains to the ECA certificates chain and, for cached certificates, matches the id it is stored under. With --repair, failing cached certificates are removed, to be fetched again from the ECA. The node must not be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return verifyKeyStore(args)
+	},
+}
+
+// Audit log query related variables.
+var (
+	auditEvent string
+	auditSince time.Duration
+	auditLimit int
+)
+
+var cryptoAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Lists the node's audit log.",
+	Long:  `Prints the records of the node's audit log, oldest first: the certificates fetched from the ECA and the TCA, the certificates cached and the failed verifications. The audit log must be enabled with security.keystore.audit.enabled.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return queryAuditLog(args)
+	},
+}
+
+// Signature log query related variables.
+var (
+	signaturesKey   string
+	signaturesSince time.Duration
+	signaturesLimit int
+)
+
+var cryptoSignaturesCmd = &cobra.Command{
+	Use:   "signatures",
+	Short: "Lists the node's signature log.",
+	Long:  `Prints the records of the node's signature log, oldest first: the signing key, the hash of the signed message and the caller of every signature made by the node. Fails if the log has been tampered with. The signature log must be enabled with security.keystore.audit.signatures.enabled.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return querySignatureLog(args)
+	},
+}
+
+// Cached certificates listing related variables.
+var (
+	certsType          string
+	certsExpiresWithin time.Duration
+	certsLimit         int
+)
+
+var cryptoCertsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Lists the node's cached certificates.",
+	Long:  `Prints the certificates cached by the node, first expiring first: the id of their owner, their type, serial number and validity period.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listCertificates(args)
+	},
+}
+
+// Keystore rekey related variables.
+var (
+	newKeyStorePassphrase string
+)
+
+var cryptoRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Rotates the encryption key of the node's keystore.",
+	Long:  `Re-encrypts the keys and certificates of the node's keystore under a new data encryption key, protected by the passphrase given with --new-passphrase, in a single transaction: on failure the keystore is left unchanged. Cached certificates not stored in the keystore DB are removed, to be fetched again from the ECA. security.keystore.encryption.passphrase must then be set to the new passphrase. The node must not be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rekeyKeyStore(args)
+	},
+}
+
 var networkCmd = &cobra.Command{
 	Use:   networkFuncName,
 	Short: fmt.Sprintf("%s specific commands.", networkFuncName),
@@ -265,6 +399,16 @@ func main() {
 	nodeStopCmd.Flags().StringVar(&stopPidFile, "stop-peer-pid-file", viper.GetString("peer.fileSystemPath"), "Location of peer pid local file, for forces kill")
 	nodeCmd.AddCommand(nodeStopCmd)
 
+	nodeBackupCmd.Flags().StringVarP(&keyStorePassphrase, "passphrase", "", undefinedParamValue, "Passphrase encrypting the keystore backup. If not specified, the backup is not encrypted.")
+	nodeRestoreCmd.Flags().StringVarP(&keyStorePassphrase, "passphrase", "", undefinedParamValue, "Passphrase of the keystore backup, if encrypted.")
+	nodeCmd.AddCommand(nodeBackupCmd)
+	nodeCmd.AddCommand(nodeRestoreCmd)
+
+	nodeExportCmd.Flags().StringVarP(&identityPassword, "password", "p", undefinedParamValue, "Password protecting the PKCS#12 bundle.")
+	nodeImportCmd.Flags().StringVarP(&identityPassword, "password", "p", undefinedParamValue, "Password of the PKCS#12 bundle.")
+	nodeCmd.AddCommand(nodeExportCmd)
+	nodeCmd.AddCommand(nodeImportCmd)
+
 	mainCmd.AddCommand(nodeCmd)
 
 	// Set the flags on the login command.
@@ -296,6 +440,25 @@ func main() {
 
 	mainCmd.AddCommand(chaincodeCmd)
 
+	cryptoVerifyCmd.Flags().BoolVarP(&keyStoreRepair, "repair", "", false, "If true, remove the cached certificates failing verification")
+	cryptoCmd.AddCommand(cryptoVerifyCmd)
+	cryptoAuditCmd.Flags().StringVarP(&auditEvent, "event", "e", "", "Kind of the records to list: eca_fetch, tca_fetch, cache_insert, verify_failure or key_rotation")
+	cryptoAuditCmd.Flags().DurationVarP(&auditSince, "since", "s", 0, "If set, list only the records of this last period, e.g. 24h")
+	cryptoAuditCmd.Flags().IntVarP(&auditLimit, "limit", "n", 0, "If set, list at most this number of records, the most recent ones")
+	cryptoCmd.AddCommand(cryptoAuditCmd)
+	cryptoSignaturesCmd.Flags().StringVarP(&signaturesKey, "key", "k", "", "If set, list only the signatures made with this key, e.g. enrollment/<certificate hash>")
+	cryptoSignaturesCmd.Flags().DurationVarP(&signaturesSince, "since", "s", 0, "If set, list only the records of this last period, e.g. 24h")
+	cryptoSignaturesCmd.Flags().IntVarP(&signaturesLimit, "limit", "n", 0, "If set, list at most this number of records, the most recent ones")
+	cryptoCmd.AddCommand(cryptoSignaturesCmd)
+	cryptoCertsCmd.Flags().StringVarP(&certsType, "type", "t", "", "Kind of the certificates to list: ecert, tcert or tls")
+	cryptoCertsCmd.Flags().DurationVarP(&certsExpiresWithin, "expires-within", "x", 0, "If set, list only the certificates expiring within this period, e.g. 720h")
+	cryptoCertsCmd.Flags().IntVarP(&certsLimit, "limit", "n", 0, "If set, list at most this number of certificates")
+	cryptoCmd.AddCommand(cryptoCertsCmd)
+	cryptoRekeyCmd.Flags().StringVarP(&newKeyStorePassphrase, "new-passphrase", "", undefinedParamValue, "Passphrase protecting the new keystore encryption key.")
+	cryptoCmd.AddCommand(cryptoRekeyCmd)
+
+	mainCmd.AddCommand(cryptoCmd)
+
 	runtime.GOMAXPROCS(viper.GetInt("peer.gomaxprocs"))
 
 	// Init the crypto layer
@@ -311,7 +474,7 @@ func main() {
 	logger.Info("Exiting.....")
 }
 
-func createEventHubServer() (net.Listener, *grpc.Server, error) {
+func createEventHubServer(secHelper crypto.Peer) (net.Listener, *grpc.Server, error) {
 	var lis net.Listener
 	var grpcServer *grpc.Server
 	var err error
@@ -324,7 +487,7 @@ func createEventHubServer() (net.Listener, *grpc.Server, error) {
 		//TODO - do we need different SSL material for events ?
 		var opts []grpc.ServerOption
 		if comm.TLSEnabled() {
-			creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+			creds, err := serverCredentials(secHelper)
 			if err != nil {
 				return nil, nil, fmt.Errorf("Failed to generate credentials %v", err)
 			}
@@ -338,6 +501,17 @@ func createEventHubServer() (net.Listener, *grpc.Server, error) {
 	return lis, grpcServer, err
 }
 
+// serverCredentials returns the TLS credentials of the listeners. When TLS
+// certificate renewal is enabled, the certificate is the one the TLSCA issued
+// to secHelper, picked up again at each handshake so that renewals apply
+// without a restart.
+func serverCredentials(secHelper crypto.Peer) (credentials.TransportAuthenticator, error) {
+	if secHelper != nil && viper.GetBool("security.tlsrenewal.enabled") {
+		return credentials.NewTLS(&tls.Config{GetCertificate: secHelper.GetTLSCertificate}), nil
+	}
+	return credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+}
+
 var once sync.Once
 
 //this should be called exactly once and the result cached
@@ -412,7 +586,12 @@ func serve(args []string) error {
 		grpclog.Fatalf("Failed to listen: %v", err)
 	}
 
-	ehubLis, ehubGrpcServer, err := createEventHubServer()
+	secHelper, err := getSecHelper()
+	if err != nil {
+		return err
+	}
+
+	ehubLis, ehubGrpcServer, err := createEventHubServer(secHelper)
 	if err != nil {
 		grpclog.Fatalf("Failed to create ehub server: %v", err)
 	}
@@ -430,7 +609,7 @@ func serve(args []string) error {
 
 	var opts []grpc.ServerOption
 	if comm.TLSEnabled() {
-		creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+		creds, err := serverCredentials(secHelper)
 		if err != nil {
 			grpclog.Fatalf("Failed to generate credentials %v", err)
 		}
@@ -439,11 +618,6 @@ func serve(args []string) error {
 
 	grpcServer := grpc.NewServer(opts...)
 
-	secHelper, err := getSecHelper()
-	if err != nil {
-		return err
-	}
-
 	secHelperFunc := func() crypto.Peer {
 		return secHelper
 	}
@@ -532,6 +706,9 @@ func serve(args []string) error {
 	}
 
 	if viper.GetBool("peer.profile.enabled") {
+		// Crypto metrics are served next to the pprof handlers
+		http.Handle("/metrics", crypto.MetricsHandler())
+
 		go func() {
 			profileListenAddress := viper.GetString("peer.profile.listenAddress")
 			logger.Infof("Starting profiling server with listenAddress = %s", profileListenAddress)
@@ -542,7 +719,34 @@ func serve(args []string) error {
 	}
 
 	// Block until grpc server exits
-	return <-serve
+	err = <-serve
+
+	// Stop serving, then close the crypto keystores so that
+	// no write is cut in the middle of a transaction
+	grpcServer.Stop()
+	if ehubGrpcServer != nil {
+		ehubGrpcServer.Stop()
+	}
+	closeCrypto()
+
+	return err
+}
+
+// closeCrypto closes the crypto clients, peers and validators of the peer
+func closeCrypto() {
+	if !core.SecurityEnabled() {
+		return
+	}
+
+	logger.Info("Closing crypto...")
+	for _, closeAll := range []func() (bool, []error){crypto.CloseAllClients, crypto.CloseAllPeers, crypto.CloseAllValidators} {
+		if ok, errs := closeAll(); !ok {
+			for _, err := range errs {
+				logger.Errorf("Failed closing crypto: %s", err)
+			}
+		}
+	}
+	logger.Info("Closing crypto...done")
 }
 
 func status() (err error) {
@@ -600,6 +804,243 @@ func stop() (err error) {
 	return err
 }
 
+// getKeyStoreNodeType returns the crypto node type of this peer
+func getKeyStoreNodeType() crypto.NodeType {
+	if peer.ValidatorEnabled() {
+		return crypto.NodeValidator
+	}
+	return crypto.NodePeer
+}
+
+// backup writes a snapshot of the keystore of this peer to the file given as parameter.
+func backup(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the backup file as the 1st and only parameter")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no keystore to backup")
+	}
+
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("Error creating backup file %s: %s", args[0], err)
+	}
+	defer file.Close()
+
+	enrollID := viper.GetString("security.enrollID")
+	logger.Infof("Backing up keystore of %s to %s", enrollID, args[0])
+	if err = crypto.BackupKeyStore(getKeyStoreNodeType(), enrollID, nil, []byte(keyStorePassphrase), file); err != nil {
+		os.Remove(args[0])
+		return fmt.Errorf("Error backing up keystore: %s", err)
+	}
+
+	return nil
+}
+
+// restore replaces the keystore of this peer with the snapshot read from the file given as parameter.
+func restore(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the backup file as the 1st and only parameter")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no keystore to restore")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("Error opening backup file %s: %s", args[0], err)
+	}
+	defer file.Close()
+
+	enrollID := viper.GetString("security.enrollID")
+	logger.Infof("Restoring keystore of %s from %s", enrollID, args[0])
+	if err = crypto.RestoreKeyStore(getKeyStoreNodeType(), enrollID, nil, []byte(keyStorePassphrase), file); err != nil {
+		return fmt.Errorf("Error restoring keystore: %s", err)
+	}
+
+	return nil
+}
+
+// exportIdentity writes the identity of this peer, as a PKCS#12 bundle, to the file given as parameter.
+func exportIdentity(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the PKCS#12 file as the 1st and only parameter")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no identity to export")
+	}
+
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("Error creating PKCS#12 file %s: %s", args[0], err)
+	}
+	defer file.Close()
+
+	enrollID := viper.GetString("security.enrollID")
+	logger.Infof("Exporting identity of %s to %s", enrollID, args[0])
+	if err = crypto.ExportIdentity(getKeyStoreNodeType(), enrollID, nil, identityPassword, file); err != nil {
+		os.Remove(args[0])
+		return fmt.Errorf("Error exporting identity: %s", err)
+	}
+
+	return nil
+}
+
+// importIdentity replaces the identity of this peer with the PKCS#12 bundle read from the file given as parameter.
+func importIdentity(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the PKCS#12 file as the 1st and only parameter")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no identity to import")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("Error opening PKCS#12 file %s: %s", args[0], err)
+	}
+	defer file.Close()
+
+	enrollID := viper.GetString("security.enrollID")
+	logger.Infof("Importing identity of %s from %s", enrollID, args[0])
+	if err = crypto.ImportIdentity(getKeyStoreNodeType(), enrollID, nil, identityPassword, file); err != nil {
+		return fmt.Errorf("Error importing identity: %s", err)
+	}
+
+	return nil
+}
+
+// verifyKeyStore checks the certificates in the keystore of this peer and prints the problems found.
+func verifyKeyStore(args []string) (err error) {
+	if len(args) != 0 {
+		return errors.New("verify takes no parameters")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no keystore to verify")
+	}
+
+	enrollID := viper.GetString("security.enrollID")
+	logger.Infof("Verifying keystore of %s", enrollID)
+	report, err := crypto.VerifyKeyStore(getKeyStoreNodeType(), enrollID, nil, keyStoreRepair)
+	if err != nil {
+		return fmt.Errorf("Error verifying keystore: %s", err)
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("Checked %d certificates, %d problems, %d repaired\n", report.Checked, len(report.Problems), report.Repaired)
+	if len(report.Problems) > report.Repaired {
+		return errors.New("Keystore verification failed")
+	}
+
+	return nil
+}
+
+// queryAuditLog prints the records of the audit log of this peer.
+func queryAuditLog(args []string) (err error) {
+	if len(args) != 0 {
+		return errors.New("audit takes no parameters")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no audit log")
+	}
+
+	filter := &crypto.AuditFilter{Event: crypto.AuditEvent(auditEvent), Limit: auditLimit}
+	if auditSince > 0 {
+		filter.Since = time.Now().Add(-auditSince)
+	}
+
+	enrollID := viper.GetString("security.enrollID")
+	records, err := crypto.QueryAuditLog(getKeyStoreNodeType(), enrollID, nil, filter)
+	if err != nil {
+		return fmt.Errorf("Error querying audit log: %s", err)
+	}
+
+	for _, record := range records {
+		fmt.Printf("%s %s %s %s %s\n", record.Time.Format(time.RFC3339), record.Event, record.Subject, record.Caller, record.Detail)
+	}
+
+	return nil
+}
+
+// querySignatureLog prints the records of the signature log of this peer.
+func querySignatureLog(args []string) (err error) {
+	if len(args) != 0 {
+		return errors.New("signatures takes no parameters")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no signature log")
+	}
+
+	filter := &crypto.SignatureFilter{Key: signaturesKey, Limit: signaturesLimit}
+	if signaturesSince > 0 {
+		filter.Since = time.Now().Add(-signaturesSince)
+	}
+
+	enrollID := viper.GetString("security.enrollID")
+	records, err := crypto.QuerySignatureLog(getKeyStoreNodeType(), enrollID, nil, filter)
+	if err != nil {
+		return fmt.Errorf("Error querying signature log: %s", err)
+	}
+
+	for _, record := range records {
+		fmt.Printf("%s %s %s %s\n", record.Time.Format(time.RFC3339Nano), record.Key, record.Digest, record.Caller)
+	}
+
+	return nil
+}
+
+// listCertificates prints the certificates cached by this peer.
+func listCertificates(args []string) (err error) {
+	if len(args) != 0 {
+		return errors.New("certs takes no parameters")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there are no cached certificates")
+	}
+
+	filter := &crypto.CertInfoFilter{Type: crypto.CertType(certsType), Limit: certsLimit}
+	if certsExpiresWithin > 0 {
+		filter.ExpiresBefore = time.Now().Add(certsExpiresWithin)
+	}
+
+	enrollID := viper.GetString("security.enrollID")
+	infos, err := crypto.ListCertificates(getKeyStoreNodeType(), enrollID, nil, filter)
+	if err != nil {
+		return fmt.Errorf("Error listing cached certificates: %s", err)
+	}
+
+	for _, info := range infos {
+		fmt.Printf("%s %s serial=%s notBefore=%s notAfter=%s\n", base64.StdEncoding.EncodeToString(info.ID), info.Type, info.SerialNumber,
+			info.NotBefore.Format(time.RFC3339), info.NotAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// rekeyKeyStore re-encrypts the keystore of this peer under the passphrase given with --new-passphrase.
+func rekeyKeyStore(args []string) (err error) {
+	if len(args) != 0 {
+		return errors.New("rekey takes no parameters")
+	}
+	if !core.SecurityEnabled() {
+		return errors.New("Security is not enabled, there is no keystore to rekey")
+	}
+	if newKeyStorePassphrase == undefinedParamValue {
+		return errors.New("Must supply the new passphrase with --new-passphrase")
+	}
+
+	enrollID := viper.GetString("security.enrollID")
+	logger.Infof("Rekeying keystore of %s", enrollID)
+	if err = crypto.RekeyKeyStore(getKeyStoreNodeType(), enrollID, nil, []byte(newKeyStorePassphrase)); err != nil {
+		return fmt.Errorf("Error rekeying keystore: %s", err)
+	}
+	fmt.Println("Keystore rekeyed. Set security.keystore.encryption.passphrase to the new passphrase before starting the node.")
+
+	return nil
+}
+
 // login confirms the enrollmentID and secret password of the client with the
 // CA and stores the enrollment certificate and key in the Devops server.
 func networkLogin(args []string) (err error) {
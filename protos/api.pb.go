@@ -57,6 +57,8 @@ It has these top-level messages:
 	BlockState
 	SyncBlockRange
 	SyncBlocks
+	EnrollmentCertificateRequest
+	EnrollmentCertificateChain
 	SyncStateSnapshotRequest
 	SyncStateSnapshot
 	SyncStateDeltasRequest
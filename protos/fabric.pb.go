@@ -94,6 +94,8 @@ const (
 	Message_SYNC_STATE_DELTAS       Message_Type = 17
 	Message_RESPONSE                Message_Type = 20
 	Message_CONSENSUS               Message_Type = 21
+	Message_ECERT_GET               Message_Type = 22
+	Message_ECERT_CHAIN             Message_Type = 23
 )
 
 var Message_Type_name = map[int32]string{
@@ -113,6 +115,8 @@ var Message_Type_name = map[int32]string{
 	17: "SYNC_STATE_DELTAS",
 	20: "RESPONSE",
 	21: "CONSENSUS",
+	22: "ECERT_GET",
+	23: "ECERT_CHAIN",
 }
 var Message_Type_value = map[string]int32{
 	"UNDEFINED":               0,
@@ -131,6 +135,8 @@ var Message_Type_value = map[string]int32{
 	"SYNC_STATE_DELTAS":       17,
 	"RESPONSE":                20,
 	"CONSENSUS":               21,
+	"ECERT_GET":               22,
+	"ECERT_CHAIN":             23,
 }
 
 func (x Message_Type) String() string {
@@ -179,6 +185,9 @@ type Transaction struct {
 	ToValidators                   []byte                     `protobuf:"bytes,10,opt,name=toValidators,proto3" json:"toValidators,omitempty"`
 	Cert                           []byte                     `protobuf:"bytes,11,opt,name=cert,proto3" json:"cert,omitempty"`
 	Signature                      []byte                     `protobuf:"bytes,12,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Keys of the attributes of cert disclosed to the chaincode, a
+	// marshaled AttributesMetadata. Encrypted along with the payload.
+	AttributesKeys []byte `protobuf:"bytes,13,opt,name=attributesKeys,proto3" json:"attributesKeys,omitempty"`
 }
 
 func (m *Transaction) Reset()         { *m = Transaction{} }
@@ -382,6 +391,10 @@ func (*PeersAddresses) ProtoMessage()    {}
 type HelloMessage struct {
 	PeerEndpoint   *PeerEndpoint   `protobuf:"bytes,1,opt,name=peerEndpoint" json:"peerEndpoint,omitempty"`
 	BlockchainInfo *BlockchainInfo `protobuf:"bytes,2,opt,name=blockchainInfo" json:"blockchainInfo,omitempty"`
+	// Hash family and security level of the sender, set if security is
+	// enabled. Peers under different ones cannot verify each other.
+	HashAlgorithm string `protobuf:"bytes,3,opt,name=hashAlgorithm" json:"hashAlgorithm,omitempty"`
+	SecurityLevel uint32 `protobuf:"varint,4,opt,name=securityLevel" json:"securityLevel,omitempty"`
 }
 
 func (m *HelloMessage) Reset()         { *m = HelloMessage{} }
@@ -490,6 +503,35 @@ func (m *SyncBlocks) GetBlocks() []*Block {
 	return nil
 }
 
+// EnrollmentCertificateRequest is the payload of Message.ECERT_GET, by which
+// a validator asks a peer for its enrollment certificate chain.
+type EnrollmentCertificateRequest struct {
+	CorrelationId uint64 `protobuf:"varint,1,opt,name=correlationId" json:"correlationId,omitempty"`
+}
+
+func (m *EnrollmentCertificateRequest) Reset()         { *m = EnrollmentCertificateRequest{} }
+func (m *EnrollmentCertificateRequest) String() string { return proto.CompactTextString(m) }
+func (*EnrollmentCertificateRequest) ProtoMessage()    {}
+
+// EnrollmentCertificateChain is the payload of Message.ECERT_CHAIN, in
+// response to Message.ECERT_GET. It holds the enrollment certificate of the
+// sender followed by the certificates of the ECA, DER encoded.
+type EnrollmentCertificateChain struct {
+	Request      *EnrollmentCertificateRequest `protobuf:"bytes,1,opt,name=request" json:"request,omitempty"`
+	Certificates [][]byte                      `protobuf:"bytes,2,rep,name=certificates,proto3" json:"certificates,omitempty"`
+}
+
+func (m *EnrollmentCertificateChain) Reset()         { *m = EnrollmentCertificateChain{} }
+func (m *EnrollmentCertificateChain) String() string { return proto.CompactTextString(m) }
+func (*EnrollmentCertificateChain) ProtoMessage()    {}
+
+func (m *EnrollmentCertificateChain) GetRequest() *EnrollmentCertificateRequest {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
 // SyncSnapshotRequest Payload for the penchainMessage.SYNC_GET_SNAPSHOT message.
 type SyncStateSnapshotRequest struct {
 	CorrelationId uint64 `protobuf:"varint,1,opt,name=correlationId" json:"correlationId,omitempty"`